@@ -0,0 +1,143 @@
+// Package address encodes and decodes the addresses coins are sent to.
+// An address is base58check: a version byte, a payload and the first four bytes of the double
+// SHA256 of both, encoded in base58 so it can be read and typed without ambiguous characters.
+package address
+
+import (
+  "bytes"         // to compare the checksums
+  "crypto/sha256" // for the checksum
+  "errors"        // for the decoding errors
+)
+
+// Define some constants for the address format
+const (
+  PubKeyHashVersion        = byte(0x00) // the version of the addresses paying to the hash of a public key
+  ScriptHashVersion        = byte(0x05) // the version of the addresses paying to the hash of a script, like a multisig
+  Ed25519PubKeyHashVersion = byte(0x21) // the version of the addresses paying to the hash of an Ed25519 public key, starting with E
+  SchnorrPubKeyHashVersion = byte(0x3f) // the version of the addresses paying to the hash of a Schnorr key, often an aggregate one, starting with S
+  PubKeyHashLen            = 20         // the length of a public key or script hash, RIPEMD160
+  checksumLen              = 4          // the length of the checksum appended to the payload
+)
+
+// Define the errors of the decoding
+var (
+  ErrInvalidFormat = errors.New("address: invalid format")
+  ErrChecksum      = errors.New("address: invalid checksum")
+  ErrVersion       = errors.New("address: unknown version")
+)
+
+// Define a function to encode a payload with its version byte and checksum
+func CheckEncode(version byte, payload []byte) string {
+  return Base58CheckEncode(append([]byte{version}, payload...)) // prepend the version
+}
+
+// Define a function to decode a base58check string into its version byte and payload, checking the checksum
+func CheckDecode(encoded string) (byte, []byte, error) {
+  versioned, err := Base58CheckDecode(encoded)
+  if err != nil {
+    return 0, nil, err
+  }
+  if len(versioned) == 0 { // there must be a version
+    return 0, nil, ErrInvalidFormat
+  }
+  return versioned[0], versioned[1:], nil
+}
+
+// Define a function to encode data followed by its checksum in base58
+func Base58CheckEncode(data []byte) string {
+  full := append(append([]byte{}, data...), checksum(data)...) // append the checksum
+  return string(Base58Encode(full))                            // encode everything in base58
+}
+
+// Define a function to decode a base58 string ending with a checksum, checking the checksum
+func Base58CheckDecode(encoded string) ([]byte, error) {
+  decoded := Base58Decode([]byte(encoded)) // decode the string
+  if len(decoded) < checksumLen {
+    return nil, ErrInvalidFormat
+  }
+  data := decoded[:len(decoded)-checksumLen] // split the checksum off
+  if !bytes.Equal(decoded[len(decoded)-checksumLen:], checksum(data)) {
+    return nil, ErrChecksum
+  }
+  return data, nil
+}
+
+// Define a function to get the address paying to a public key hash
+func FromPubKeyHash(pubKeyHash []byte) string {
+  return CheckEncode(PubKeyHashVersion, pubKeyHash)
+}
+
+// Define a function to get the address paying to the hash of an Ed25519 public key
+func FromEd25519PubKeyHash(pubKeyHash []byte) string {
+  return CheckEncode(Ed25519PubKeyHashVersion, pubKeyHash)
+}
+
+// Define a function to get the address paying to the hash of a Schnorr public key
+func FromSchnorrPubKeyHash(pubKeyHash []byte) string {
+  return CheckEncode(SchnorrPubKeyHashVersion, pubKeyHash)
+}
+
+// Define a function to get the address paying to a script hash
+func FromScriptHash(scriptHash []byte) string {
+  return CheckEncode(ScriptHashVersion, scriptHash)
+}
+
+// Define a function to extract the version and the hash of an address
+func Decode(address string) (byte, []byte, error) {
+  version, payload, err := CheckDecode(address)
+  if err != nil {
+    return 0, nil, err
+  }
+  switch version {
+  case PubKeyHashVersion, ScriptHashVersion, Ed25519PubKeyHashVersion, SchnorrPubKeyHashVersion:
+  default:
+    return 0, nil, ErrVersion
+  }
+  if len(payload) != PubKeyHashLen {
+    return 0, nil, ErrInvalidFormat
+  }
+  return version, payload, nil
+}
+
+// Define a function to extract the public key hash of an address
+func PubKeyHash(address string) ([]byte, error) {
+  version, payload, err := Decode(address)
+  if err != nil {
+    return nil, err
+  }
+  if version != PubKeyHashVersion {
+    return nil, ErrVersion
+  }
+  return payload, nil
+}
+
+// Define a function to tell if an address pays to a script hash
+func IsScriptHash(address string) bool {
+  version, _, err := Decode(address)
+  return err == nil && version == ScriptHashVersion
+}
+
+// Define a function to tell if an address pays to the hash of an Ed25519 public key
+func IsEd25519(address string) bool {
+  version, _, err := Decode(address)
+  return err == nil && version == Ed25519PubKeyHashVersion
+}
+
+// Define a function to tell if an address pays to the hash of a Schnorr public key
+func IsSchnorr(address string) bool {
+  version, _, err := Decode(address)
+  return err == nil && version == SchnorrPubKeyHashVersion
+}
+
+// Define a function to check that an address is well formed, paying to a public key or a script
+func Validate(address string) bool {
+  _, _, err := Decode(address)
+  return err == nil
+}
+
+// Define a function to compute the checksum of a payload: the first bytes of SHA256(SHA256(payload))
+func checksum(payload []byte) []byte {
+  first := sha256.Sum256(payload)
+  second := sha256.Sum256(first[:])
+  return second[:checksumLen]
+}
@@ -0,0 +1,54 @@
+package address
+
+import (
+  "bytes"   // to build the encoded string
+  "math/big" // base58 is a base conversion of a big number
+)
+
+// The base58 alphabet, without the characters that are easy to mix up (0, O, I and l)
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// Define a function to encode bytes to base58
+func Base58Encode(input []byte) []byte {
+  var result []byte                   // the encoded digits, least significant first
+  x := new(big.Int).SetBytes(input)   // the input as a big number
+  base := big.NewInt(int64(len(base58Alphabet)))
+  zero := big.NewInt(0)
+  mod := new(big.Int)
+  for x.Cmp(zero) != 0 { // divide by 58 until nothing is left
+    x.DivMod(x, base, mod)
+    result = append(result, base58Alphabet[mod.Int64()]) // the remainder is the next digit
+  }
+  for _, b := range input { // leading zero bytes are kept as leading '1's
+    if b != 0x00 {
+      break
+    }
+    result = append(result, base58Alphabet[0])
+  }
+  for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 { // reverse the digits, most significant first
+    result[i], result[j] = result[j], result[i]
+  }
+  return result
+}
+
+// Define a function to decode base58 to bytes, returning nil for invalid characters
+func Base58Decode(input []byte) []byte {
+  result := big.NewInt(0) // the decoded number
+  base := big.NewInt(int64(len(base58Alphabet)))
+  zeroBytes := 0 // the number of leading '1's
+  for _, b := range input {
+    if b != base58Alphabet[0] {
+      break
+    }
+    zeroBytes++
+  }
+  for _, b := range input[zeroBytes:] { // accumulate the digits
+    index := bytes.IndexByte([]byte(base58Alphabet), b)
+    if index < 0 {
+      return nil // not a base58 character
+    }
+    result.Mul(result, base)
+    result.Add(result, big.NewInt(int64(index)))
+  }
+  return append(make([]byte, zeroBytes), result.Bytes()...) // restore the leading zero bytes
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+  "crypto/rand"        // for the secret placing the addresses in the buckets
+  "crypto/sha256"      // to pick the bucket of an address
+  "encoding/binary"    // to read the bucket from the hash
+  "encoding/hex"       // the secret is stored in hex
+  "encoding/json"      // the format of the peers file
+  "errors"             // to tell a missing peers file apart
+  "io/fs"              // for the error of a missing peers file
+  mathrand "math/rand" // to relay a random sample of the addresses
+  "net"                // to group the addresses by network
+  "os"                 // to read and write the peers file
+  "sort"               // to list the best addresses first
+  "strconv"            // to hash the spread of the buckets
+  "sync"               // for the lock of the addresses
+  "time"               // for the timestamps of the addresses
+)
+
+// Define some constants of the address manager
+const (
+  peersFile         = "peers.json"        // the name of the file of the data directory holding the known addresses
+  newBucketCount    = 256                 // the number of buckets of the addresses never connected to
+  triedBucketCount  = 64                  // the number of buckets of the addresses the node connected to
+  bucketSize        = 64                  // the number of addresses a bucket holds
+  maxAddrPerMessage = 1000                // the number of addresses an addr message carries at most
+  addrSaveInterval  = time.Minute         // how often the known addresses are written to the peers file
+  addrHorizon       = 30 * 24 * time.Hour // how long an address not seen is kept
+  addrMaxFutureTime = 10 * time.Minute    // how far in the future a relayed timestamp may be before it is distrusted
+  addrMaxRetries    = 3                   // the failed attempts after which an address never connected to is given up
+  addrMaxFailures   = 10                  // the failed attempts after which an address not connected to for a week is given up
+  addrMinFailDays   = 7                   // the days an address may fail before its failures count
+)
+
+// Define a struct for an address known by a node
+type knownAddress struct {
+  Address     string    `json:"address"`          // the address of the node
+  Source      string    `json:"source,omitempty"` // the peer that relayed the address, empty for the bootstrap addresses
+  LastSeen    time.Time `json:"lastSeen"`         // when the node was last heard from, or about
+  LastTried   time.Time `json:"lastTried"`        // when the node was last dialed unsuccessfully
+  LastSuccess time.Time `json:"lastSuccess"`      // when the node last completed a handshake with us
+  Attempts    int       `json:"attempts"`         // the failed dials since the last handshake
+  Tried       bool      `json:"tried"`            // whether the address is in the tried buckets
+  bucket      int       `json:"-"`                // the bucket holding the address, placed again on load
+}
+
+// Define a method to check if an address is not worth keeping nor relaying: not seen for a long time, or failing
+func (ka *knownAddress) isTerrible(now time.Time) bool {
+  if now.Sub(ka.LastTried) < time.Minute { // just tried, give it a chance to answer
+    return false
+  }
+  if ka.LastSeen.After(now.Add(addrMaxFutureTime)) || now.Sub(ka.LastSeen) > addrHorizon { // from the future or too old
+    return true
+  }
+  if ka.LastSuccess.IsZero() && ka.Attempts >= addrMaxRetries { // never reached
+    return true
+  }
+  return now.Sub(ka.LastSuccess) > addrMinFailDays*24*time.Hour && ka.Attempts >= addrMaxFailures // no longer reached
+}
+
+// Define a method to get the table of the buckets holding an address, 0 for the new buckets and 1 for the tried ones
+func (ka *knownAddress) table() int {
+  if ka.Tried {
+    return 1
+  }
+  return 0
+}
+
+// Define a struct for the peers file
+type addrFile struct {
+  Key       string          `json:"key"`       // the secret placing the addresses in the buckets
+  Addresses []*knownAddress `json:"addresses"` // the known addresses
+}
+
+// Define a struct for the address manager of a node
+// The addresses the node never connected to go to the new buckets, picked from the network groups of the address and of
+// the peer that relayed it so a single peer cannot fill the table, and move to the tried buckets once a handshake succeeds
+// A full bucket makes room by evicting its worst address, a tried one going back to the new buckets
+type addrManager struct {
+  mu        sync.Mutex                    // the lock protecting the addresses
+  path      string                        // the peers file, empty to keep the addresses in memory only
+  first     string                        // the first node, always known and never forgotten
+  key       [32]byte                      // the secret placing the addresses in the buckets, so peers cannot predict them
+  addresses map[string]*knownAddress      // the known addresses
+  buckets   [2][]map[string]*knownAddress // the new and the tried buckets
+  dirty     bool                          // whether the addresses changed since they were saved
+}
+
+// Define a function to create an address manager with the first node and the file persisting the addresses
+func newAddrManager(path, first string) *addrManager {
+  m := &addrManager{path: path, first: first, addresses: map[string]*knownAddress{}}
+  m.buckets[0] = make([]map[string]*knownAddress, newBucketCount)
+  m.buckets[1] = make([]map[string]*knownAddress, triedBucketCount)
+  for _, buckets := range m.buckets {
+    for i := range buckets {
+      buckets[i] = map[string]*knownAddress{}
+    }
+  }
+  rand.Read(m.key[:])
+  if first != "" {
+    m.add(first, "", time.Now())
+  }
+  return m
+}
+
+// Define a function to get the network group of an address or of a host without a port, the addresses of a group are
+// likely run by the same operator: the /16 of an IPv4 address, the /32 of an IPv6 address, or the host name
+func addrGroup(address string) string {
+  host, _, err := net.SplitHostPort(address)
+  if err != nil { // a host alone
+    host = address
+  }
+  ip := net.ParseIP(host)
+  if ip == nil {
+    return host
+  }
+  if ip4 := ip.To4(); ip4 != nil {
+    return ip4.Mask(net.CIDRMask(16, 32)).String()
+  }
+  return ip.Mask(net.CIDRMask(32, 128)).String()
+}
+
+// Define a method to hash some strings with the secret of the manager into a number, the lock must be held
+func (m *addrManager) hash(parts ...string) uint64 {
+  hash := sha256.New()
+  hash.Write(m.key[:])
+  for _, part := range parts {
+    hash.Write([]byte(part))
+    hash.Write([]byte{0}) // so the parts cannot be shifted from one to the next
+  }
+  return binary.BigEndian.Uint64(hash.Sum(nil))
+}
+
+// Define a method to pick the bucket of an address, the lock must be held
+// The addresses of a group share 8 tried buckets, and the peers of a group relay addresses to 64 new buckets at most, so
+// the operator of many addresses of a network cannot fill the table
+func (m *addrManager) bucketOf(ka *knownAddress) int {
+  group := addrGroup(ka.Address)
+  if ka.Tried {
+    spread := m.hash(ka.Address) % 8
+    return int(m.hash(group, strconv.FormatUint(spread, 10)) % triedBucketCount)
+  }
+  source := addrGroup(ka.Source)
+  spread := m.hash(group, source) % 64
+  return int(m.hash(source, strconv.FormatUint(spread, 10)) % newBucketCount)
+}
+
+// Define a method to put an address in its bucket, evicting the worst address of a full bucket, the lock must be held
+func (m *addrManager) place(ka *knownAddress) {
+  ka.bucket = m.bucketOf(ka)
+  bucket := m.buckets[ka.table()][ka.bucket]
+  if len(bucket) >= bucketSize {
+    m.evict(bucket)
+  }
+  bucket[ka.Address] = ka
+  m.addresses[ka.Address] = ka
+}
+
+// Define a method to make room in a full bucket, the lock must be held: a terrible address or else the one heard of the
+// longest ago goes, a tried address moving back to the new buckets
+func (m *addrManager) evict(bucket map[string]*knownAddress) {
+  now := time.Now()
+  var worst *knownAddress
+  for _, ka := range bucket {
+    if ka.Address == m.first { // never forgotten
+      continue
+    }
+    if ka.isTerrible(now) {
+      worst = ka
+      break
+    }
+    if worst == nil || ka.LastSeen.Before(worst.LastSeen) {
+      worst = ka
+    }
+  }
+  if worst == nil {
+    return
+  }
+  delete(bucket, worst.Address)
+  delete(m.addresses, worst.Address)
+  if worst.Tried { // it was reached once, it may be again
+    worst.Tried = false
+    m.place(worst)
+  }
+}
+
+// Define a method to add an address relayed by a peer, or to refresh when it was last seen, returning whether it is new
+func (m *addrManager) add(address, source string, lastSeen time.Time) bool {
+  m.mu.Lock() // lock the addresses
+  defer m.mu.Unlock() // unlock them when done
+  if now := time.Now(); lastSeen.IsZero() || lastSeen.After(now.Add(addrMaxFutureTime)) { // a peer cannot vouch for the future
+    lastSeen = now
+  }
+  m.dirty = true
+  if ka, ok := m.addresses[address]; ok {
+    if lastSeen.After(ka.LastSeen) {
+      ka.LastSeen = lastSeen
+    }
+    return false
+  }
+  m.place(&knownAddress{Address: address, Source: source, LastSeen: lastSeen})
+  return true
+}
+
+// Define a method to check if an address is known
+func (m *addrManager) contains(address string) bool {
+  m.mu.Lock() // lock the addresses
+  defer m.mu.Unlock() // unlock them when done
+  _, ok := m.addresses[address]
+  return ok
+}
+
+// Define a method to record a handshake with a known address, moving it to the tried buckets
+func (m *addrManager) good(address string) {
+  m.mu.Lock() // lock the addresses
+  defer m.mu.Unlock() // unlock them when done
+  ka, ok := m.addresses[address]
+  if !ok {
+    return
+  }
+  now := time.Now()
+  ka.LastSeen, ka.LastSuccess, ka.Attempts = now, now, 0
+  m.dirty = true
+  if ka.Tried {
+    return
+  }
+  delete(m.buckets[0][ka.bucket], address)
+  ka.Tried = true
+  m.place(ka)
+}
+
+// Define a method to record that a known address answered
+func (m *addrManager) seen(address string) {
+  m.mu.Lock() // lock the addresses
+  defer m.mu.Unlock() // unlock them when done
+  if ka, ok := m.addresses[address]; ok {
+    ka.LastSeen = time.Now()
+    m.dirty = true
+  }
+}
+
+// Define a method to record a failed dial of a known address
+func (m *addrManager) attempt(address string) {
+  m.mu.Lock() // lock the addresses
+  defer m.mu.Unlock() // unlock them when done
+  if ka, ok := m.addresses[address]; ok {
+    ka.LastTried = time.Now()
+    ka.Attempts++
+    m.dirty = true
+  }
+}
+
+// Define a method to forget an address, the first node is never forgotten
+func (m *addrManager) remove(address string) {
+  m.mu.Lock() // lock the addresses
+  defer m.mu.Unlock() // unlock them when done
+  ka, ok := m.addresses[address]
+  if !ok || address == m.first {
+    return
+  }
+  delete(m.buckets[ka.table()][ka.bucket], address)
+  delete(m.addresses, address)
+  m.dirty = true
+}
+
+// Define a method to list the known addresses: the first node, then the tried addresses by their last handshake, then the
+// new ones by when they were last seen
+func (m *addrManager) list() []string {
+  m.mu.Lock() // lock the addresses
+  defer m.mu.Unlock() // unlock them when done
+  known := make([]*knownAddress, 0, len(m.addresses))
+  for _, ka := range m.addresses {
+    known = append(known, ka)
+  }
+  sort.Slice(known, func(i, j int) bool {
+    a, b := known[i], known[j]
+    if (a.Address == m.first) != (b.Address == m.first) {
+      return a.Address == m.first
+    }
+    if a.Tried != b.Tried {
+      return a.Tried
+    }
+    if a.Tried && !a.LastSuccess.Equal(b.LastSuccess) {
+      return a.LastSuccess.After(b.LastSuccess)
+    }
+    if !a.LastSeen.Equal(b.LastSeen) {
+      return a.LastSeen.After(b.LastSeen)
+    }
+    return a.Address < b.Address
+  })
+  addresses := make([]string, len(known))
+  for i, ka := range known {
+    addresses[i] = ka.Address
+  }
+  return addresses
+}
+
+// Define a method to pick an address to dial at random, from the tried or the new addresses with the same chance, leaving
+// out the terrible ones and the ones to skip; it returns an empty address when none is left
+func (m *addrManager) pick(skip func(address string) bool) string {
+  m.mu.Lock() // lock the addresses
+  var tables [2][]string
+  now := time.Now()
+  for address, ka := range m.addresses {
+    if !ka.isTerrible(now) {
+      tables[ka.table()] = append(tables[ka.table()], address)
+    }
+  }
+  m.mu.Unlock() // unlock them, skip may look at the node
+  table := mathrand.Intn(2)
+  for range tables {
+    candidates := tables[table]
+    mathrand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+    for _, address := range candidates {
+      if !skip(address) {
+        return address
+      }
+    }
+    table = 1 - table // the other table then
+  }
+  return ""
+}
+
+// Define a method to pick a random sample of the addresses worth relaying, with when they were last seen
+func (m *addrManager) sample(max int) ([]string, []int64) {
+  m.mu.Lock() // lock the addresses
+  defer m.mu.Unlock() // unlock them when done
+  now := time.Now()
+  var addresses []string
+  var lastSeen []int64
+  for _, ka := range m.addresses { // the map is visited in a random order
+    if len(addresses) == max {
+      break
+    }
+    if !ka.isTerrible(now) {
+      addresses = append(addresses, ka.Address)
+      lastSeen = append(lastSeen, ka.LastSeen.Unix())
+    }
+  }
+  mathrand.Shuffle(len(addresses), func(i, j int) {
+    addresses[i], addresses[j] = addresses[j], addresses[i]
+    lastSeen[i], lastSeen[j] = lastSeen[j], lastSeen[i]
+  })
+  return addresses, lastSeen
+}
+
+// Define a method to load the addresses of the peers file, a missing file leaves the manager empty
+// The addresses are given to keep, so the ones the node would not talk to are left out
+func (m *addrManager) load(keep func(address string) bool) error {
+  if m.path == "" {
+    return nil
+  }
+  data, err := os.ReadFile(m.path)
+  if errors.Is(err, fs.ErrNotExist) {
+    return nil
+  } else if err != nil {
+    return err
+  }
+  var file addrFile
+  if err := json.Unmarshal(data, &file); err != nil {
+    return err
+  }
+  m.mu.Lock() // lock the addresses
+  defer m.mu.Unlock() // unlock them when done
+  if key, err := hex.DecodeString(file.Key); err == nil && len(key) == len(m.key) { // the buckets stay the same across restarts
+    copy(m.key[:], key)
+  }
+  if first, ok := m.addresses[m.first]; ok { // the first node was placed with the previous key
+    delete(m.buckets[first.table()][first.bucket], m.first)
+    m.place(first)
+  }
+  now := time.Now()
+  for _, ka := range file.Addresses {
+    if first, ok := m.addresses[ka.Address]; ok && ka.Address == m.first { // keep what the node learned about the first node
+      first.LastSeen, first.LastTried, first.LastSuccess, first.Attempts = ka.LastSeen, ka.LastTried, ka.LastSuccess, ka.Attempts
+      continue
+    }
+    if _, known := m.addresses[ka.Address]; known || ka.isTerrible(now) || !keep(ka.Address) {
+      continue
+    }
+    m.place(ka)
+  }
+  return nil
+}
+
+// Define a method to write the addresses to the peers file if they changed since the last time
+func (m *addrManager) save() error {
+  m.mu.Lock() // lock the addresses
+  if m.path == "" || !m.dirty {
+    m.mu.Unlock() // unlock them
+    return nil
+  }
+  file := addrFile{Key: hex.EncodeToString(m.key[:])}
+  for _, ka := range m.addresses {
+    copied := *ka // the node keeps updating the addresses
+    file.Addresses = append(file.Addresses, &copied)
+  }
+  m.dirty = false
+  m.mu.Unlock() // unlock them
+  sort.Slice(file.Addresses, func(i, j int) bool { return file.Addresses[i].Address < file.Addresses[j].Address })
+  data, err := json.MarshalIndent(file, "", "  ")
+  if err != nil {
+    return err
+  }
+  temp := m.path + ".tmp" // a crash while writing leaves the previous file
+  if err := os.WriteFile(temp, data, 0644); err != nil {
+    return err
+  }
+  return os.Rename(temp, m.path)
+}
+
+// Define a method to save the known addresses of the node every addrSaveInterval until it stops
+func (n *Node) saveAddresses() {
+  ticker := time.NewTicker(addrSaveInterval) // create a ticker for the interval
+  defer ticker.Stop() // stop it when done
+  for {
+    select {
+    case <-n.quit: // the node stopped, Run saves them a last time
+      return
+    case <-ticker.C:
+      if err := n.addrs.save(); err != nil {
+        netLog.Warn("Failed to save the known addresses", "err", err)
+      }
+    }
+  }
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+  "bytes"                // to order the outputs of a transaction
+  "encoding/hex"         // the mempool keys the transactions by hex ID
+  "networkchain/mempool" // to skip the outputs the pending transactions spend
+  "sort"                 // to list the oldest outputs first
+)
+
+// Define a struct for an unspent output of some addresses with how deep it is in the chain
+type walletCoin struct {
+  coin
+  confirmations int  // the blocks from the one holding the transaction to the tip, 0 for a mempool transaction
+  coinbase      bool // whether the transaction is a coinbase
+  mature        bool // whether the next block can spend it
+}
+
+// Define a struct for the coins of some addresses split by whether they can be spent
+type walletBalance struct {
+  confirmed   int // the coins of the chain the next block can spend
+  unconfirmed int // the coins of the mempool transactions
+  immature    int // the coins of the coinbases too young to be spent
+}
+
+// Create a method that lists the unspent outputs of some addresses, in the chain or created by a mempool transaction,
+// oldest first; the ones a mempool transaction spends are left out, since they are as good as gone
+func (u UTXOSet) ListCoins(addresses []string) []walletCoin {
+  owned := map[string]bool{} // the addresses, to look them up
+  for _, address := range addresses {
+    owned[address] = true
+  }
+  pool := u.Blockchain.Mempool
+  unspent := func(txid []byte, vout int) bool {
+    return pool.SpentBy(mempool.Outpoint{Txid: hex.EncodeToString(txid), Index: vout}) == ""
+  }
+  var coins []walletCoin
+  best := u.Blockchain.GetBestHeight()
+  err := u.Blockchain.utxoCache.read(func(view *utxoView) error {
+    return view.forEach(func(key, value []byte) error {
+      entry := deserializeEntry(value)
+      out := entry.output()
+      txid, vout := splitOutpointKey(key)
+      if owned[out.Address()] && unspent(txid, vout) {
+        coins = append(coins, walletCoin{coin{append([]byte{}, txid...), vout, out}, best - entry.Height + 1, entry.Coinbase, entry.mature(best + 1)}) // copy the ID, the key is only valid during the call
+      }
+      return nil
+    })
+  })
+  if err != nil {
+    chainLog.Panic("Failed to read the UTXO set", "err", err)
+  }
+  for _, entry := range pool.Entries() { // then the outputs of the pending transactions
+    tx := entry.Tx.(*Transaction)
+    for vout, out := range tx.Vout {
+      if owned[out.Address()] && unspent(tx.ID, vout) {
+        coins = append(coins, walletCoin{coin{tx.ID, vout, out}, 0, false, true})
+      }
+    }
+  }
+  sort.Slice(coins, func(i, j int) bool {
+    if coins[i].confirmations != coins[j].confirmations {
+      return coins[i].confirmations > coins[j].confirmations
+    }
+    if c := bytes.Compare(coins[i].txid, coins[j].txid); c != 0 {
+      return c < 0
+    }
+    return coins[i].vout < coins[j].vout
+  })
+  return coins
+}
+
+// Create a method that sums the unspent outputs of some addresses, split into the confirmed coins the next block can
+// spend, the coins of the mempool transactions and the coins of the coinbases still maturing
+func (u UTXOSet) WalletBalance(addresses []string) walletBalance {
+  var balance walletBalance
+  for _, c := range u.ListCoins(addresses) {
+    switch {
+    case c.confirmations == 0:
+      balance.unconfirmed += c.output.Value
+    case !c.mature:
+      balance.immature += c.output.Value
+    default:
+      balance.confirmed += c.output.Value
+    }
+  }
+  return balance
+}
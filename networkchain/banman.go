@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Define some constants for the misbehavior scores
+const (
+  banThreshold   = 100         // the score that gets a peer banned
+  scoreDecay     = time.Minute // the time it takes a score to go down by one point, so rare mistakes are forgiven
+  checksumScore  = 20          // the score of a message whose payload does not match its checksum
+  malformedScore = 20          // the score of a payload that cannot be decoded
+)
+
+// Define a struct for a ban
+type ban struct {
+  until  time.Time // when the ban expires
+  reason string    // why the peer was banned
+}
+
+// Define a struct for the misbehavior score of a peer
+type peerScore struct {
+  score int       // the score after the last update
+  last  time.Time // when the score was last updated
+}
+
+// Define a struct for the ban list of a node
+// Peers are scored for each protocol violation and banned for a while once their score reaches banThreshold
+// A peer is the host a connection comes from, whatever address it announces in its messages, or an address banned through
+// RPC
+type banManager struct {
+  mu       sync.Mutex            // the lock protecting the scores and the bans
+  duration time.Duration         // how long a ban lasts
+  scores   map[string]*peerScore // the misbehavior score of each peer
+  bans     map[string]ban        // the banned peers
+}
+
+// Define a function to create an empty ban list with the duration of the bans
+func newBanManager(duration time.Duration) *banManager {
+  return &banManager{duration: duration, scores: map[string]*peerScore{}, bans: map[string]ban{}}
+}
+
+// Define a method to add to the score of a peer, banning it if the score reaches the threshold
+// It returns the new score and whether the peer got banned
+func (b *banManager) misbehaving(peer string, score int, reason string) (int, bool) {
+  b.mu.Lock() // lock the ban list
+  defer b.mu.Unlock() // unlock it when done
+  now := time.Now()
+  state, ok := b.scores[peer] // get the score of the peer
+  if !ok {
+    state = &peerScore{last: now}
+    b.scores[peer] = state
+  }
+  state.score -= int(now.Sub(state.last) / scoreDecay) // forgive the time since the last violation
+  if state.score < 0 {
+    state.score = 0
+  }
+  state.last = now
+  state.score += score // count the new violation
+  if state.score < banThreshold {
+    return state.score, false
+  }
+  delete(b.scores, peer) // the peer starts from zero when the ban expires
+  b.bans[peer] = ban{now.Add(b.duration), reason}
+  return banThreshold, true
+}
+
+// Define a method to ban a peer for a duration, the default duration if it is zero
+func (b *banManager) ban(peer string, duration time.Duration, reason string) {
+  if duration <= 0 {
+    duration = b.duration
+  }
+  b.mu.Lock() // lock the ban list
+  defer b.mu.Unlock() // unlock it when done
+  delete(b.scores, peer)
+  b.bans[peer] = ban{time.Now().Add(duration), reason}
+}
+
+// Define a method to lift the ban of a peer, returning false if it was not banned
+func (b *banManager) unban(peer string) bool {
+  b.mu.Lock() // lock the ban list
+  defer b.mu.Unlock() // unlock it when done
+  _, ok := b.bans[peer]
+  delete(b.bans, peer)
+  return ok
+}
+
+// Define a method to check if a peer is banned, forgetting the expired bans
+func (b *banManager) isBanned(peer string) bool {
+  b.mu.Lock() // lock the ban list
+  defer b.mu.Unlock() // unlock it when done
+  entry, ok := b.bans[peer]
+  if ok && time.Now().After(entry.until) { // the ban is over
+    delete(b.bans, peer)
+    return false
+  }
+  return ok
+}
+
+// Define a struct for a peer of the ban list
+type bannedPeer struct {
+  peer   string    // the address or host banned
+  until  time.Time // when the ban expires
+  reason string    // why the peer was banned
+}
+
+// Define a method to list the banned peers, in name order
+func (b *banManager) list() []bannedPeer {
+  b.mu.Lock() // lock the ban list
+  defer b.mu.Unlock() // unlock it when done
+  now := time.Now()
+  var peers []bannedPeer
+  for peer, entry := range b.bans {
+    if now.After(entry.until) { // the ban is over
+      delete(b.bans, peer)
+      continue
+    }
+    peers = append(peers, bannedPeer{peer, entry.until, entry.reason})
+  }
+  sort.Slice(peers, func(i, j int) bool { return peers[i].peer < peers[j].peer })
+  return peers
+}
+
+// Define a method to score the host a protocol violation came from, disconnecting it once it is banned
+// The host is the one of the connection, the address in the payload is written by the peer and could name another node;
+// a peer that proved a whitelisted ID on the connection is never scored
+func (n *Node) misbehaving(from sender, score int, reason error) {
+  if n.isWhitelisted(from.id) { // the ID of the connection, not one looked up from an address the peer may have made up
+    netLog.Info("Whitelisted peer misbehaving", "host", from.host, "id", from.id, "reason", reason)
+    return
+  }
+  total, banned := n.bans.misbehaving(from.host, score, reason.Error())
+  if !banned {
+    netLog.Info("Peer misbehaving", "host", from.host, "score", total, "reason", reason)
+    return
+  }
+  netLog.Warn("Banning host", "host", from.host, "reason", reason, "duration", n.bans.duration)
+  for _, peer := range n.peers() { // the peers at the host, whatever port they listen on
+    if host, _, err := net.SplitHostPort(peer); err == nil && host == from.host {
+      n.dropPeer(peer)
+    }
+  }
+}
+
+// Define a method to forget the state of a peer that got banned
+func (n *Node) dropPeer(peer string) {
+  n.mu.Lock() // lock the peer state
+  delete(n.filters, peer) // a banned light client loses its filter
+  delete(n.feeFilters, peer) // and a banned peer its feerate
+  n.mu.Unlock() // unlock it
+  n.removeKnownNode(peer) // forget the node
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"networkchain/consensus"
+	"networkchain/storage"
+	"networkchain/wallet"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The error returned by the function checking a proposal, so the changes it made to the store are rolled back
+var errDryRun = errors.New("dry run")
+
+// Define a struct for the state of the rounds deciding the next block of a BFT network
+// Every node follows the rounds and relays their messages, so the votes reach the validators through any peer;
+// the node of a validator also votes, and any node holding a proposal with the commits of a quorum adds the block
+type bftState struct {
+  mu        sync.Mutex                           // the lock protecting the state below
+  engine    *consensus.BFT                       // the validators of the epoch of the height
+  height    int                                  // the height of the block being decided
+  view      int                                  // the current view, moved on when the primary fails
+  proposals map[string]*Block                    // the proposals seen at the height, by hash
+  prepares  map[string]map[string]consensus.Vote // the prepare votes of each proposal, by validator address
+  commits   map[string]map[string]consensus.Vote // the commit votes of each proposal, by validator address
+  prepared  map[int]bool                         // the views in which the validator of the node already prepared a block
+  locked    *Block                               // the block the validator of the node committed to, it prepares no other at the height
+  timer     *time.Timer                          // fires when the view takes too long, nil when no block is expected
+}
+
+// Define a function to create the round state of a BFT network
+func newBFTState() *bftState {
+  return &bftState{height: -1}
+}
+
+// Define a method to move the state to the height of the next block, forgetting the rounds of the previous one
+// The validators may change with the epoch of the height, false if they cannot be found; the lock of the round state must be held
+func (n *Node) syncRound() bool {
+  s := n.bft
+  if s.height == n.bc.GetBestHeight()+1 {
+    return true
+  }
+  engine, height, err := n.bc.nextEngine()
+  if err != nil {
+    minerLog.Warn("Failed to find the validators of the next block", "err", err)
+    return false
+  }
+  s.engine, s.height, s.view = engine.(*consensus.BFT), height, 0
+  s.proposals = map[string]*Block{}
+  s.prepares = map[string]map[string]consensus.Vote{}
+  s.commits = map[string]map[string]consensus.Vote{}
+  s.prepared = map[int]bool{}
+  s.locked = nil
+  if s.timer != nil { // the block the timer waited for was added
+    s.timer.Stop()
+    s.timer = nil
+  }
+  return true
+}
+
+// Define a method to record a vote, false if the validator already cast it
+func addVote(votes map[string]map[string]consensus.Vote, hash []byte, address string, vote consensus.Vote) bool {
+  key := hex.EncodeToString(hash)
+  if votes[key] == nil {
+    votes[key] = map[string]consensus.Vote{}
+  }
+  if _, ok := votes[key][address]; ok {
+    return false
+  }
+  votes[key][address] = vote
+  return true
+}
+
+// Define a method to get the address of the validator of the node, empty if the node does not vote
+func (n *Node) bftValidator() string {
+  if n.validatorKey == nil {
+    return ""
+  }
+  address, ok := n.bft.engine.Validator(n.validatorKey.PublicKey)
+  if !ok {
+    return ""
+  }
+  return address
+}
+
+// Define a method to propose a block with the transactions of the mempool when the node is the primary of the round
+// The validators that are not the primary only start the timer, so the view moves on if the primary stays silent
+func (n *Node) proposeBlock() {
+  s := n.bft
+  s.mu.Lock() // lock the round state
+  defer s.mu.Unlock() // unlock it when done
+  if !n.syncRound() {
+    return
+  }
+  n.startViewTimer()
+  me := n.bftValidator()
+  if me == "" || s.engine.Primary(s.height, s.view) != me || s.prepared[s.view] { // not our turn, or we already proposed
+    return
+  }
+  block := s.locked // a block we committed to is proposed again, a quorum may be about to commit it
+  if block == nil {
+    pending, fees := n.bc.MempoolTransactions() // include the pending transactions, best feerate first
+    txs := append([]*Transaction{NewCoinbaseTX(n.minerAddress, "", s.height, fees)}, pending...) // the coinbase pays the primary the subsidy and the fees
+    var err error
+    if block, err = n.bc.ProposeBlock(txs, n.validatorKey); err != nil {
+      minerLog.Warn("Failed to propose a block", "height", s.height, "err", err)
+      return
+    }
+  }
+  minerLog.Info("Proposing block", "hash", block.MyBlockHash, "height", s.height, "view", s.view, "txs", len(block.Transactions))
+  go n.broadcast(cmdPrePrepare, encodePayload(PrePrepare{n.address, s.height, s.view, block.Serialize()}), "")
+  n.acceptProposal(block)
+}
+
+// Define a method to start the timer of the view if it is not running, the lock of the round state must be held
+func (n *Node) startViewTimer() {
+  s := n.bft
+  if s.timer != nil {
+    return
+  }
+  s.timer = time.AfterFunc(activeNet.TargetBlockTime, n.viewTimeout)
+}
+
+// Define a method called when a view took too long: the next validator in turn becomes the primary
+func (n *Node) viewTimeout() {
+  select {
+  case <-n.quit: // the node stopped meanwhile
+    return
+  default:
+  }
+  s := n.bft
+  s.mu.Lock() // lock the round state
+  s.timer = nil
+  if s.height != n.bc.GetBestHeight()+1 { // the block was added meanwhile
+    s.mu.Unlock()
+    return
+  }
+  s.view++
+  minerLog.Info("View change", "height", s.height, "view", s.view, "primary", s.engine.Primary(s.height, s.view))
+  waiting := s.locked != nil || len(s.proposals) > 0 || n.bc.Mempool.Count() >= n.minTxs // a block is still expected
+  s.mu.Unlock() // unlock it
+  if waiting {
+    n.proposeBlock() // propose if the node is the new primary, and start the timer of the view
+  }
+}
+
+// Define a method to record a proposal that passed the checks and prepare it, the lock of the round state must be held
+func (n *Node) acceptProposal(block *Block) {
+  s := n.bft
+  s.proposals[hex.EncodeToString(block.MyBlockHash)] = block
+  n.startViewTimer()
+  me := n.bftValidator()
+  if me != "" && !s.prepared[s.view] && (s.locked == nil || bytes.Equal(s.locked.MyBlockHash, block.MyBlockHash)) { // one block per view, and only the locked one once committed
+    s.prepared[s.view] = true
+    vote := consensus.Vote{PubKey: n.validatorKey.PublicKey, Signature: n.validatorKey.Sign(consensus.PrepareDigest(block.MyBlockHash))}
+    addVote(s.prepares, block.MyBlockHash, me, vote)
+    go n.broadcast(cmdPrepare, encodePayload(BFTVote{n.address, s.height, s.view, block.MyBlockHash, vote.PubKey, vote.Signature}), "")
+  }
+  n.advanceRound(block.MyBlockHash)
+}
+
+// Define a method to move a proposal through the rounds once its votes reach a quorum, the lock of the round state must be held
+func (n *Node) advanceRound(hash []byte) {
+  s := n.bft
+  key := hex.EncodeToString(hash)
+  block, ok := s.proposals[key]
+  if !ok { // the votes came before the proposal
+    return
+  }
+  me := n.bftValidator()
+  if _, committed := s.commits[key][me]; me != "" && !committed && len(s.prepares[key]) >= s.engine.Quorum() { // a quorum prepared the block
+    s.locked = block
+    vote := consensus.Vote{PubKey: n.validatorKey.PublicKey, Signature: n.validatorKey.Sign(hash)}
+    addVote(s.commits, hash, me, vote)
+    go n.broadcast(cmdCommit, encodePayload(BFTVote{n.address, s.height, s.view, hash, vote.PubKey, vote.Signature}), "")
+  }
+  if len(s.commits[key]) < s.engine.Quorum() {
+    return
+  }
+  committed := *block // the proposal is shared with the state, add the votes to a copy
+  committed.Commits = nil
+  for _, vote := range s.commits[key] {
+    committed.Commits = append(committed.Commits, vote)
+  }
+  sort.Slice(committed.Commits, func(i, j int) bool { // the same order on every node
+    return bytes.Compare(committed.Commits[i].PubKey, committed.Commits[j].PubKey) < 0
+  })
+  if err := n.bc.AddBlock(&committed); err != nil {
+    chainLog.Warn("Failed to add a committed block", "hash", hash, "err", err)
+    return
+  }
+  chainLog.Info("Committed block", "hash", hash, "height", s.height, "view", s.view, "commits", len(committed.Commits))
+  n.syncRound()
+  go n.broadcast(cmdInv, encodePayload(Inv{n.address, "block", [][]byte{hash}}), "") // the nodes that missed the votes download it
+  if me != "" && n.bc.Mempool.Count() >= n.minTxs { // the next block may already be due
+    go n.proposeBlock()
+  }
+}
+
+// Define a method to send a message to every peer but one, the one it came from
+func (n *Node) broadcast(command string, payload []byte, except string) {
+  message := encodeMessage(command, payload) // frame the command and the payload
+  for _, peer := range n.peers() { // iterate over the known nodes
+    if peer != except {
+      n.sendData(peer, message)
+    }
+  }
+}
+
+// Define a method to handle a preprepare command from a node
+func (n *Node) handlePrePrepare(from sender, request []byte) {
+  var payload PrePrepare // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
+  peerAddress := payload.AddrFrom // get the peer address
+  if n.bft == nil { // a network without rounds has nothing to do with it
+    return
+  }
+  block, err := decodeBlock(payload.Block) // deserialize the block
+  if err != nil { // if the block cannot be read
+    n.banPeer(from, err) // stop talking to the peer that sent it
+    return
+  }
+  s := n.bft
+  s.mu.Lock() // lock the round state
+  defer s.mu.Unlock() // unlock it when done
+  if !n.syncRound() || payload.Height != s.height || payload.View < s.view { // a round we are not in
+    return
+  }
+  if _, seen := s.proposals[hex.EncodeToString(block.MyBlockHash)]; seen {
+    return
+  }
+  primary := s.engine.Primary(payload.Height, payload.View)
+  if signer := wallet.AddressFromPubKey(block.Proposer); signer != primary {
+    n.banPeer(from, fmt.Errorf("block %x proposed by %s in view %d, %s is the primary", block.MyBlockHash, signer, payload.View, primary))
+    return
+  }
+  if err := n.bc.CheckProposal(block); err != nil {
+    n.banPeer(from, err)
+    return
+  }
+  if payload.View > s.view { // the other validators timed out first
+    s.view = payload.View
+  }
+  netLog.Debug("Received proposal", "peer", peerAddress, "hash", block.MyBlockHash, "height", payload.Height, "view", payload.View)
+  payload.AddrFrom = n.address
+  go n.broadcast(cmdPrePrepare, encodePayload(payload), peerAddress) // relay it to the validators the sender may not know
+  n.acceptProposal(block)
+}
+
+// Define a method to handle a prepare or commit command from a node
+func (n *Node) handleBFTVote(from sender, command string, request []byte) {
+  var payload BFTVote // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
+  peerAddress := payload.AddrFrom // get the peer address
+  if n.bft == nil { // a network without rounds has nothing to do with it
+    return
+  }
+  s := n.bft
+  s.mu.Lock() // lock the round state
+  defer s.mu.Unlock() // unlock it when done
+  if !n.syncRound() || payload.Height != s.height { // a round we are not in
+    return
+  }
+  votes, digest := s.prepares, consensus.PrepareDigest(payload.BlockHash)
+  if command == cmdCommit {
+    votes, digest = s.commits, payload.BlockHash
+  }
+  vote := consensus.Vote{PubKey: payload.PubKey, Signature: payload.Signature}
+  address, err := s.engine.VerifyVote(vote, digest)
+  if err != nil {
+    n.banPeer(from, err)
+    return
+  }
+  if !addVote(votes, payload.BlockHash, address, vote) { // already counted
+    return
+  }
+  netLog.Debug("Received vote", "command", command, "peer", peerAddress, "validator", address, "hash", payload.BlockHash)
+  payload.AddrFrom = n.address
+  go n.broadcast(command, encodePayload(payload), peerAddress) // relay it to the validators the sender may not know
+  n.advanceRound(payload.BlockHash)
+}
+
+// create the method that builds a block on the tip signed by a validator key, without adding it to the chain
+func (blockchain *Blockchain) ProposeBlock(transactions []*Transaction, key *wallet.Wallet) (*Block, error) {
+  blockchain.mu.RLock()         // lock the chain for reading
+  defer blockchain.mu.RUnlock() // unlock it when done
+  tip := blockchain.tipNode()
+  engine, err := blockchain.engineAt(tip.height + 1) // the validators of the epoch of the block
+  if err != nil {
+    return nil, err
+  }
+  return NewBlock(transactions, tip, blockchain.blockTime(tip), nextBits(tip), engine, key)
+}
+
+// create the method that checks a proposed block before it is voted on: everything AddBlock checks but the commit votes,
+// and the transactions are connected to the UTXO set in a transaction of the store that is then rolled back
+func (blockchain *Blockchain) CheckProposal(block *Block) error {
+  blockchain.mu.Lock()         // nobody else may change the chain while the transactions are tried
+  defer blockchain.mu.Unlock() // unlock it when done
+  tip := blockchain.tipNode()
+  if !bytes.Equal(block.PreviousBlockHash, tip.block.MyBlockHash) { // a proposal extends the final tip
+    return fmt.Errorf("proposal %x does not build on the tip %x", block.MyBlockHash, tip.block.MyBlockHash)
+  }
+  if err := checkBlockBody(block, blockchain.clock.now()); err != nil {
+    return err
+  }
+  if err := checkBlockContext(block, tip); err != nil {
+    return err
+  }
+  err := blockchain.utxoCache.update(func(batch *storage.Batch, view *utxoView) error {
+    engine, err := validatorEngine(view, tip.height+1) // the validators of the epoch of the block
+    if err != nil {
+      return err
+    }
+    if err := engine.(*consensus.BFT).VerifyProposal(block.consensusHeader()); err != nil {
+      return fmt.Errorf("proposal %x: %w", block.MyBlockHash, err)
+    }
+    if err := connectUTXO(view, block, tip.height+1); err != nil { // the inputs must exist and the coinbase be right
+      return err
+    }
+    return errDryRun
+  })
+  if errors.Is(err, errDryRun) {
+    return nil
+  }
+  return err
+}
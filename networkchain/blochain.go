@@ -1,13 +1,359 @@
 package main
 
-// create the method that adds a new block to a blockchain
-func (blockchain *Blockchain) AddBlock(data string) {
-  PreviousBlock := blockchain.Blocks[len(blockchain.Blocks)-1] // the previous block is needed, so let's get it
-  newBlock := NewBlock(data, PreviousBlock.MyBlockHash)        // create a new block containing the data and the hash of the previous block
-  blockchain.Blocks = append(blockchain.Blocks, newBlock)      // add that block to the chain to create a chain of blocks
+import (
+  "bytes"                 // to compare the tip with the best block when reindexing
+  "errors"                // for the unknown parent error
+  "fmt"                   // for the validation errors
+  "networkchain/chaincfg" // a BFT network never reorganizes
+  "networkchain/events"   // to announce the new blocks
+  "networkchain/mempool"  // the transactions waiting to be mined
+  "networkchain/storage"  // the blocks are persisted in the storage layer
+  "networkchain/wallet"   // the key signing a proof of stake block
+)
+
+// The default directory where a node keeps its data
+const defaultDataDir = "data"
+
+// The error returned for a block whose parent is not known yet, the block is not invalid, it came too early
+var errUnknownParent = errors.New("unknown parent")
+
+// create the method that mines a new block with some transactions and adds it to the blockchain
+// The chain is locked while the nonce is searched, so the block cannot end up on a tip that moved meanwhile
+// On a proof of stake network the key signs the block and must be the one of the proposer of the current slot
+func (blockchain *Blockchain) MineBlock(transactions []*Transaction, key *wallet.Wallet) (*Block, error) {
+  blockchain.mu.Lock()         // nobody else may change the chain while we build on it
+  defer blockchain.mu.Unlock() // unlock it when done
+  PreviousBlock := blockchain.tipNode()                                                           // the previous block is needed, so let's get it
+  engine, err := blockchain.engineAt(PreviousBlock.height + 1) // the validators of the epoch sign it
+  if err != nil {
+    return nil, err
+  }
+  newBlock, err := NewBlock(transactions, PreviousBlock, blockchain.blockTime(PreviousBlock), nextBits(PreviousBlock), engine, key) // mine a new block containing the transactions and the hash of the previous block
+  if err != nil {
+    return nil, err
+  }
+  if err := blockchain.addBlock(newBlock); err != nil {                                    // add that block to the chain to create a chain of blocks
+    return nil, err // a transaction may have been mined by someone else in the meantime
+  }
+  return newBlock, nil
+}
+
+// create the method that builds the next block of a miner on top of the tip: a coinbase paying the address the subsidy and
+// the fees, then the mempool transactions, best feerate first; the nonce is left for the miner to search
+func (blockchain *Blockchain) NewBlockTemplate(minerAddress string) (*Block, int) {
+  blockchain.mu.RLock()         // the tip must not move while the template is built
+  defer blockchain.mu.RUnlock() // unlock it when done
+  tip := blockchain.tipNode()
+  pending, fees := blockchain.MempoolTransactions()
+  txs := append([]*Transaction{NewCoinbaseTX(minerAddress, "", tip.height+1, fees)}, pending...)
+  block := &Block{blockchain.blockTime(tip), tip.block.MyBlockHash, []byte{}, nil, txs, 0, nextBits(tip), nil, nil, nil}
+  block.MerkleRoot = block.HashTransactions() // commit to the transactions in the header
+  return block, fees
+}
+
+// create the method that adds a block mined by anyone, after validating it
+// The inputs of its transactions are checked when the block is connected to the main chain
+// The block may extend the main chain or a side branch; when a side branch ends up with more work
+// than the main chain, the chain is reorganized onto it
+func (blockchain *Blockchain) AddBlock(block *Block) error {
+  blockchain.mu.Lock()         // lock the chain
+  defer blockchain.mu.Unlock() // unlock it when done
+  return blockchain.addBlock(block)
+}
+
+// create the method that validates and adds a block, the lock must be held
+func (blockchain *Blockchain) addBlock(block *Block) error {
+  key := indexKey(block.MyBlockHash)
+  if known, ok := blockchain.index[key]; ok { // nothing to do for a block we already have
+    if known.invalid {
+      return fmt.Errorf("block %x is known to be invalid", block.MyBlockHash)
+    }
+    return nil
+  }
+  if len(block.PreviousBlockHash) == 0 { // we already have a genesis block, this one starts another chain
+    return fmt.Errorf("block %x is the genesis block of another chain", block.MyBlockHash)
+  }
+  parent, ok := blockchain.index[indexKey(block.PreviousBlockHash)] // the block must build on a known block
+  if !ok {
+    return fmt.Errorf("block %x: %w %x", block.MyBlockHash, errUnknownParent, block.PreviousBlockHash)
+  }
+  if parent.invalid { // the branch failed to connect already, do not try it again
+    return fmt.Errorf("block %x builds on the invalid block %x", block.MyBlockHash, block.PreviousBlockHash)
+  }
+  if err := checkCheckpoints(block, parent.height+1, blockchain.tipNode().height); err != nil { // the chain cannot be rewritten below a checkpoint
+    return err
+  }
+  if err := CheckBlock(block, blockchain.clock.now()); err != nil { // check the work and the transactions
+    return err
+  }
+  if err := checkBlockContext(block, parent); err != nil { // check the header against the parent
+    return err
+  }
+  if activeNet.Consensus == chaincfg.BFT && parent != blockchain.tipNode() { // a committed block is final, no branch may replace it
+    return fmt.Errorf("block %x forks the chain below the final block %x", block.MyBlockHash, blockchain.tipNode().block.MyBlockHash)
+  }
+  node := newBlockNode(block, parent)
+  if node.work.Cmp(blockchain.tipNode().work) <= 0 { // the main chain still has the most work, keep the block on its side branch
+    err := blockchain.db.Update(func(batch *storage.Batch) error {
+      return batch.PutBlock(block.MyBlockHash, block.Serialize())
+    })
+    if err != nil {
+      return err
+    }
+    blockchain.index[key] = node
+    return nil
+  }
+  return blockchain.reorganize(node) // the branch of the block becomes the main chain
+}
+
+// create the method that makes the branch ending with a node the main chain
+// The blocks of the old chain after the fork are disconnected and their transactions go back to the mempool,
+// then the blocks of the new branch are connected; everything is written at once, so a failure leaves the chain as it was
+func (blockchain *Blockchain) reorganize(node *blockNode) error {
+  var attach []*blockNode // the blocks of the new branch, oldest first
+  for n := node; !blockchain.onMainChain(n); n = n.parent {
+    attach = append([]*blockNode{n}, attach...)
+  }
+  fork := attach[0].parent                            // the last block both chains share
+  detach := blockchain.Blocks[fork.height+1:]          // the blocks of the old chain after the fork
+  if fork.height+1 < blockchain.prunedHeight { // their transactions and undo data are gone
+    return fmt.Errorf("block %x forks the chain at height %d, below the pruned height %d", node.block.MyBlockHash, fork.height, blockchain.prunedHeight)
+  }
+  var failed *blockNode // the block of the branch that does not connect
+  err := blockchain.utxoCache.update(func(batch *storage.Batch, view *utxoView) error {
+    if err := batch.PutBlock(node.block.MyBlockHash, node.block.Serialize()); err != nil { // store the new block
+      return err
+    }
+    for i := len(detach) - 1; i >= 0; i-- { // disconnect the old blocks, last first
+      if err := disconnectUTXO(view, detach[i]); err != nil {
+        return err
+      }
+      if err := blockchain.unindexTransactions(batch, detach[i]); err != nil {
+        return err
+      }
+    }
+    for _, n := range attach { // connect the new blocks, first first
+      if err := checkValidators(view, n); err != nil { // the signers depend on the bonds of the branch
+        failed = n
+        return err
+      }
+      if err := connectUTXO(view, n.block, n.height); err != nil {
+        failed = n
+        return err
+      }
+      if err := blockchain.indexTransactions(batch, n.block); err != nil {
+        return err
+      }
+    }
+    view.flush = len(detach) > 0 // the undo data of the old blocks is gone, the set cannot be replayed from before them
+    return batch.SetTip(node.block.MyBlockHash) // the new block is the tip
+  })
+  if err != nil {
+    if failed != nil {
+      blockchain.markInvalid(failed, node)
+    }
+    return err
+  }
+  blockchain.index[indexKey(node.block.MyBlockHash)] = node
+  detached := append([]*Block{}, detach...)                   // keep the old blocks, the slice is about to be reused
+  blockchain.Blocks = blockchain.Blocks[:fork.height+1]
+  for _, n := range attach {
+    blockchain.Blocks = append(blockchain.Blocks, n.block)
+    blockchain.removeMinedTransactions(n.block, n.height) // the transactions of the new blocks are no longer pending
+  }
+  if len(detached) > 0 {
+    chainLog.Info("Reorganized the chain", "from", detached[len(detached)-1].MyBlockHash, "to", node.block.MyBlockHash, "height", node.height, "disconnected", len(detached))
+    connected := make([]*Block, len(attach))
+    for i, n := range attach {
+      connected[i] = n.block
+    }
+    blockchain.Events.Publish(events.Event{Type: events.ReorgDetected, Data: &ReorgEvent{fork.block, detached, connected}}) // tell the listeners the branch changed
+  }
+  for _, n := range attach {
+    blockchain.Events.Publish(events.Event{Type: events.TipChanged, Data: n.block}) // announce the new blocks
+  }
+  for _, block := range detached { // give the transactions of the old blocks another chance
+    for _, tx := range block.Transactions {
+      if !tx.IsCoinbase() {
+        blockchain.addTxToMempool(tx) // transactions conflicting with the new chain are refused
+      }
+    }
+  }
+  if len(detached) > 0 { // the coinbases of the old blocks are gone and the new ones are younger
+    blockchain.removeImmatureSpends()
+  }
+  for _, n := range attach { // the orphans spending the new blocks can enter the mempool
+    blockchain.promoteOrphans(n.block.Transactions...)
+  }
+  if err := blockchain.prune(); err != nil { // the chain is already moved, the blocks are pruned next time
+    chainLog.Warn("Failed to prune the blocks", "err", err)
+  }
+  return nil
+}
+
+// create the method that marks a block that failed to connect and every known block built on it invalid, node is the
+// block that started the reorganization; it is kept in the index too, so the blocks on top of it are refused at once
+func (blockchain *Blockchain) markInvalid(failed, node *blockNode) {
+  chainLog.Warn("Marking the branch invalid", "block", failed.block.MyBlockHash, "height", failed.height)
+  failed.invalid = true
+  for _, n := range blockchain.index {
+    if n.ancestor(failed.height) == failed {
+      n.invalid = true
+    }
+  }
+  node.invalid = true // the node is the failed block or one built on it
+  blockchain.index[indexKey(node.block.MyBlockHash)] = node
+}
+
+// create the method that writes the genesis block to an empty store
+func (blockchain *Blockchain) connectGenesis(genesis *Block) error {
+  err := blockchain.utxoCache.update(func(batch *storage.Batch, view *utxoView) error {
+    if err := batch.SaveBlock(genesis.MyBlockHash, genesis.Serialize()); err != nil { // store the block and move the tip
+      return err
+    }
+    if err := connectUTXO(view, genesis, 0); err != nil { // add the outputs
+      return err
+    }
+    if err := blockchain.indexTransactions(batch, genesis); err != nil { // and the transactions
+      return err
+    }
+    return batch.SetMeta(txIndexKey, blockchain.txIndexMeta()) // the index is complete from the start
+  })
+  if err != nil {
+    return err
+  }
+  blockchain.index[indexKey(genesis.MyBlockHash)] = newBlockNode(genesis, nil)
+  blockchain.Blocks = []*Block{genesis}
+  return nil
+}
+
+// create the method that returns a copy of the main chain, oldest block first
+// The blocks themselves never change, so the copy stays valid after the chain moves on
+func (blockchain *Blockchain) MainChain() []*Block {
+  blockchain.mu.RLock()         // lock the chain for reading
+  defer blockchain.mu.RUnlock() // unlock it when done
+  return append([]*Block{}, blockchain.Blocks...)
+}
+
+// create the method that finds a transaction of the chain by its ID
+func (blockchain *Blockchain) FindTransaction(ID []byte) (*Transaction, error) {
+  tx, _, _, err := blockchain.LocateTransaction(ID) // look the transaction up in the index
+  return tx, err
+}
+
+/* Create the function that returns the whole blockchain. If the data directory already holds a chain it is reopened, otherwise the genesis block is created first and its reward is paid to address. the genesis block is the first ever mined block, so let's create a function that will return it since it does not exist yet */
+func NewBlockchain(dataDir, address string) *Blockchain { // the function is created
+  return openBlockchain(dataDir, address, false)
 }
 
-/* Create the function that returns the whole blockchain and add the genesis to it first. the genesis block is the first ever mined block, so let's create a function that will return it since it does not exist yet */
-func NewBlockchain() *Blockchain { // the function is created
-  return &Blockchain{[]*Block{NewGenesisBlock()}} // the genesis block is added first to the chain
-}
\ No newline at end of file
+// create the function that opens the chain of a data directory whose state is about to be rebuilt from the stored blocks
+// The blocks that cannot be read back are skipped and the branch with the most work becomes the main chain, whatever tip
+// was recorded; the UTXO set is not caught up, it is rebuilt next
+func ReindexBlockchain(dataDir string) *Blockchain {
+  return openBlockchain(dataDir, "", true)
+}
+
+// create the function that opens the chain of a data directory, creating it with its genesis block if it is empty
+func openBlockchain(dataDir, address string, reindex bool) *Blockchain {
+  db, err := storage.Open(dataDir) // open the store in the data directory
+  if err != nil {
+    chainLog.Panic("Failed to open the store", "dir", dataDir, "err", err)
+  }
+  blockchain := &Blockchain{Mempool: mempool.New(mempool.DefaultMaxSize), Orphans: mempool.NewOrphanPool(mempool.DefaultMaxOrphans), db: db, utxoCache: newUTXOCache(db, defaultUTXOCacheSize<<20), index: map[string]*blockNode{}, Events: events.New(), fees: newFeeEstimator()} // the chain is backed by the store
+  tip, err := db.Tip()              // get the hash of the last stored block
+  if err != nil {
+    chainLog.Panic("Failed to read the tip", "err", err)
+  }
+  if err := checkNetwork(db, tip == nil && !reindex); err != nil { // a chain of another network cannot be used
+    chainLog.Panic("Wrong network", "network", activeNet.Name, "err", err)
+  }
+  blockchain.loadTxIndex()   // the genesis block is indexed if the store keeps the indexes
+  blockchain.loadAddrIndex()
+  if tip == nil && !reindex { // the store is empty
+    genesis, err := BuildGenesisBlock(address) // the genesis block is added first to the chain
+    if err != nil {
+      chainLog.Panic("Failed to build the genesis block", "err", err)
+    }
+    if err := blockchain.connectGenesis(genesis); err != nil { // persist it and start the chain with it
+      chainLog.Panic("Failed to store the genesis block", "err", err)
+    }
+    return blockchain
+  }
+  blocks := map[string]*Block{} // read every stored block, side branches included
+  err = db.ForEachBlock(func(hash, data []byte) error {
+    if !reindex {
+      blocks[indexKey(hash)] = DeserializeBlock(data)
+      return nil
+    }
+    block, err := decodeBlock(data) // a corrupt block is left out with its descendants
+    if err == nil && !bytes.Equal(block.MyBlockHash, hash) {
+      err = fmt.Errorf("stored under hash %x", hash)
+    }
+    if err != nil {
+      chainLog.Warn("Skipping a corrupt stored block", "hash", hash, "err", err)
+      return nil
+    }
+    blocks[indexKey(hash)] = block
+    return nil
+  })
+  if err != nil {
+    chainLog.Panic("Failed to read the blocks", "err", err)
+  }
+  blockchain.loadIndex(blocks) // link them into the index
+  tipNode, ok := blockchain.index[indexKey(tip)]
+  if reindex { // the recorded tip may be lost, or one of the blocks before it
+    tipNode, ok = blockchain.bestNode()
+  }
+  if !ok {
+    chainLog.Panic("The tip is not stored", "hash", tip)
+  }
+  blockchain.Blocks = make([]*Block, tipNode.height+1)
+  for node := tipNode; node != nil; node = node.parent { // walk back from the tip to the genesis block
+    blockchain.Blocks[node.height] = node.block
+  }
+  if err := checkGenesisHeader(blockchain.Blocks[0]); err != nil { // a chain of another deployment of the network
+    chainLog.Panic("Wrong genesis block", "err", err)
+  }
+  blockchain.loadPrunedHeight() // the blocks below it have no transactions
+  if reindex {
+    if !bytes.Equal(tip, tipNode.block.MyBlockHash) { // the recorded tip was lost or is not the best block left
+      chainLog.Warn("Moving the tip to the stored block with the most work", "from", tip, "to", tipNode.block.MyBlockHash, "height", tipNode.height)
+      err := db.Update(func(batch *storage.Batch) error {
+        return batch.SetTip(tipNode.block.MyBlockHash)
+      })
+      if err != nil {
+        chainLog.Panic("Failed to write the tip", "err", err)
+      }
+    }
+    return blockchain
+  }
+  blockchain.ensureTxIndex() // stores created before the transaction index get one
+  blockchain.replayUTXO() // the set catches up with the blocks the cache held when the node stopped
+  return blockchain
+}
+
+// create the method that finds the known block with the most work, false if there is none
+func (blockchain *Blockchain) bestNode() (*blockNode, bool) {
+  var best *blockNode
+  for _, node := range blockchain.index {
+    if node.invalid { // the branch failed to connect
+      continue
+    }
+    if best == nil || node.work.Cmp(best.work) > 0 {
+      best = node
+    }
+  }
+  return best, best != nil
+}
+
+// create the method that closes the store behind the chain
+func (blockchain *Blockchain) Close() {
+  blockchain.mu.Lock()         // wait for the writes in progress
+  defer blockchain.mu.Unlock() // unlock it when done
+  if err := blockchain.utxoCache.flush(); err != nil { // the blocks are replayed at the next start
+    chainLog.Warn("Failed to write the UTXO cache", "err", err)
+  }
+  if err := blockchain.db.Close(); err != nil { // release the database
+    chainLog.Panic("Failed to close the store", "err", err)
+  }
+}
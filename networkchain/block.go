@@ -2,29 +2,170 @@ package main
 
 import (
   // We will need these libraries:
-  "bytes"         // need to convert data into byte in order to be sent on the network, computer understands better the byte(8bits)language
-  "crypto/sha256" //crypto library to hash the data
-  "strconv"       // for conversion
-  "time"          // the time for our timestamp
+  "bytes"                  // need to convert data into byte in order to be sent on the network, computer understands better the byte(8bits)language
+  "encoding/gob"           // to serialize the block before storing it
+  "encoding/hex"           // to compare the genesis hash of the network
+  "fmt"                    // for the genesis and seal errors
+  "networkchain/consensus" // the engine sealing the blocks
+  "networkchain/wallet"    // the key signing a proof of stake block
 )
 
-// Now let's create a method for generating a hash of the block
-// We will just concatenate all the data and hash it to obtain the block hash
-func (block *Block) SetHash() {
-  timestamp := []byte(strconv.FormatInt(block.Timestamp, 10))                                  // get the time and convert it into a unique series of digits
-  headers := bytes.Join([][]byte{timestamp, block.PreviousBlockHash, block.AllData}, []byte{}) // concatenate all the block data
-  hash := sha256.Sum256(headers)                                                               // hash the whole thing
-  block.MyBlockHash = hash[:]                                                                  // now set the hash of the block
+// Now let's create a method for generating a hash of the block on top of its parent, nil for the genesis block
+// The hash comes from the consensus engine of the block's epoch: a proof of work searches a nonce meeting the target,
+// a proof of stake signs the block with the key, which must be the one of the proposer of the slot
+func (block *Block) Seal(engine consensus.Engine, parent *Block, key *wallet.Wallet) error {
+  header := block.consensusHeader()
+  var parentHeader *consensus.Header
+  if parent != nil {
+    parentHeader = parent.consensusHeader()
+  }
+  if err := engine.Seal(header, parentHeader, key); err != nil { // mine or sign the block
+    return err
+  }
+  block.Nonce = header.Nonce                                      // keep the nonce so anyone can check the work
+  block.Proposer, block.Signature = header.Proposer, header.Signature // or the signer and the signature
+  block.Commits = header.Commits
+  block.MyBlockHash = header.Hash                                 // now set the hash of the block
+  return nil
 }
 
-// Create a function for new block generation and return that block
-func NewBlock(data string, prevBlockHash []byte) *Block {
-  block := &Block{time.Now().Unix(), prevBlockHash, []byte{}, []byte(data)} // the block is received
-  block.SetHash()                                                           // the block is hashed
-  return block                                                              // the block is returned with all the information in it
+// Create a method that checks the seal of the block against its header: the work or the signature
+func (block *Block) VerifySeal() error {
+  if err := activeEngine().VerifySeal(block.consensusHeader()); err != nil {
+    return fmt.Errorf("block %x has an invalid seal: %w", block.MyBlockHash, err)
+  }
+  return nil
 }
 
-/* let's now create the genesis block function that will return the first block. The genesis block is the first block on the chain */
-func NewGenesisBlock() *Block {
-  return NewBlock("Genesis Block", []byte{}) // the genesis block is made with some data in it
-}
\ No newline at end of file
+// Create a method that describes the header of the block to the consensus engine
+func (block *Block) consensusHeader() *consensus.Header {
+  return &consensus.Header{
+    PrevHash:   block.PreviousBlockHash,
+    MerkleRoot: block.MerkleRoot,
+    Timestamp:  block.Timestamp,
+    Bits:       block.Bits,
+    Nonce:      block.Nonce,
+    Proposer:   block.Proposer,
+    Signature:  block.Signature,
+    Commits:    block.Commits,
+    Hash:       block.MyBlockHash,
+  }
+}
+
+// Create a method that computes the merkle root of the transactions, so the block hash covers them
+func (block *Block) HashTransactions() []byte {
+  var txHashes [][]byte // the IDs of the transactions
+  for _, tx := range block.Transactions { // iterate over the transactions
+    txHashes = append(txHashes, tx.ID) // collect their IDs
+  }
+  return NewMerkleTree(txHashes).Root() // build the merkle tree and return its root
+}
+
+// Create a function for new block generation on top of a parent and return that block
+func NewBlock(transactions []*Transaction, parent *blockNode, timestamp int64, bits uint32, engine consensus.Engine, key *wallet.Wallet) (*Block, error) {
+  block := &Block{timestamp, parent.block.MyBlockHash, []byte{}, nil, transactions, 0, bits, nil, nil, nil} // the block is received
+  block.MerkleRoot = block.HashTransactions()                                                          // commit to the transactions in the header
+  if err := block.Seal(engine, parent.block, key); err != nil {                                                      // the block is mined and hashed
+    return nil, err
+  }
+  return block, nil // the block is returned with all the information in it
+}
+
+// Create a method that returns the timestamp of a new block on top of a parent: the time of the network, or the median
+// time past of the parent if the clock is behind it, so the block is valid
+func (blockchain *Blockchain) blockTime(parent *blockNode) int64 {
+  now := blockchain.clock.now().Unix()
+  if mtp := parent.medianTimePast(); now < mtp {
+    return mtp
+  }
+  return now
+}
+
+/* let's now create the genesis block function that will return the first block. The genesis block is the first block on the chain, its time and target come from the network parameters */
+func NewGenesisBlock(coinbase *Transaction) *Block {
+  block := &Block{activeNet.GenesisTime, []byte{}, []byte{}, nil, []*Transaction{coinbase}, 0, activeNet.PowLimitBits, nil, nil, nil} // the genesis block is made with the coinbase transaction in it
+  block.MerkleRoot = block.HashTransactions() // commit to the coinbase in the header
+  if err := block.Seal(activeEngine(), nil, nil); err != nil { // the block is mined and hashed, the genesis block needs no key
+    chainLog.Panic("Failed to seal the genesis block", "err", err)
+  }
+  return block
+}
+
+// Create a function that builds the genesis block of the active network
+// A network with a fixed genesis pays its premine, or the subsidy to nobody, so every node builds the same block and it
+// must match the genesis hash of the parameters; otherwise the subsidy goes to the address given, the miner of the deployment
+func BuildGenesisBlock(address string) (*Block, error) {
+  if !activeNet.FixedGenesis() {
+    return NewGenesisBlock(NewCoinbaseTX(address, activeNet.GenesisMessage, 0, 0)), nil
+  }
+  coinbase := NewCoinbaseTX("", activeNet.GenesisMessage, 0, 0) // the data is given, so the ID is the same on every node
+  if len(activeNet.GenesisOutputs) > 0 { // the premine replaces the unspendable subsidy
+    coinbase.Vout = nil
+    for _, premine := range activeNet.GenesisOutputs {
+      out, err := NewTXOutput(premine.Value, premine.Address)
+      if err != nil {
+        return nil, fmt.Errorf("genesis output to %s: %w", premine.Address, err)
+      }
+      coinbase.Vout = append(coinbase.Vout, out)
+    }
+    coinbase.ID = coinbase.Hash()
+  }
+  genesis := NewGenesisBlock(coinbase)
+  if err := checkGenesisHeader(genesis); err != nil {
+    return nil, err
+  }
+  return genesis, nil
+}
+
+// Create a function that checks the header of a genesis block against the parameters of the network
+func checkGenesisHeader(header *Block) error {
+  if header.Bits != activeNet.PowLimitBits {
+    return fmt.Errorf("genesis header %x has target %08x, expected %08x", header.MyBlockHash, header.Bits, activeNet.PowLimitBits)
+  }
+  if activeNet.GenesisHash != "" && hex.EncodeToString(header.MyBlockHash) != activeNet.GenesisHash {
+    return fmt.Errorf("genesis header %x is not the genesis block %s of the %s network", header.MyBlockHash, activeNet.GenesisHash, activeNet.Name)
+  }
+  return nil
+}
+
+// Create a method that returns the header of the block: a copy without the transactions, still committing to them through the merkle root
+func (block *Block) Header() *Block {
+  header := *block
+  header.Transactions = nil
+  return &header
+}
+
+// Create a method that tells if the transactions of a block were discarded by a pruned node, leaving its header
+// A block always holds at least its coinbase
+func (block *Block) Pruned() bool {
+  return len(block.Transactions) == 0
+}
+
+// Create a method that serializes the block so it can be stored or sent to a peer
+func (block *Block) Serialize() []byte {
+  var result bytes.Buffer              // the buffer receiving the encoded block
+  encoder := gob.NewEncoder(&result)   // create a gob encoder writing to the buffer
+  if err := encoder.Encode(block); err != nil { // encode the block
+    chainLog.Panic("Failed to encode a block", "hash", block.MyBlockHash, "err", err)
+  }
+  return result.Bytes() // return the encoded block
+}
+
+// Create a function that rebuilds a block from its serialized form
+func DeserializeBlock(data []byte) *Block {
+  block, err := decodeBlock(data) // decode the block
+  if err != nil {
+    chainLog.Panic("Failed to decode a stored block", "err", err)
+  }
+  return block // return the decoded block
+}
+
+// Create a function that rebuilds a block received from a peer, returning an error instead of panicking on garbage
+func decodeBlock(data []byte) (*Block, error) {
+  var block Block                                       // the block to fill
+  decoder := gob.NewDecoder(bytes.NewReader(data))      // create a gob decoder reading the data
+  if err := decoder.Decode(&block); err != nil {        // decode the block
+    return nil, err
+  }
+  return &block, nil // return the decoded block
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+  "encoding/hex" // the index is keyed by hex block hash
+  "math/big"     // the work is a 256 bit number
+  "sort"         // to find the median of the timestamps
+)
+
+// The number of blocks whose timestamps give the median time past
+const medianTimeBlocks = 11
+
+// Create the blockNode data structure
+// Every known block gets a node, whether it is on the main chain or on a side branch,
+// so the node can tell which branch holds the most work
+type blockNode struct {
+  block   *Block     // the block itself
+  parent  *blockNode // the node of the previous block, nil for the genesis block
+  height  int        // the number of blocks before this one
+  work    *big.Int   // the total work of the branch from the genesis block up to this block
+  invalid bool       // the block or one before it failed to connect, no block may build on it
+}
+
+// Create a function that makes the node of a block on top of its parent
+func newBlockNode(block *Block, parent *blockNode) *blockNode {
+  node := &blockNode{block: block, parent: parent, work: activeEngine().Work(block.consensusHeader())} // the weight of the block comes from the consensus engine
+  if parent != nil { // the height and the work build on the parent
+    node.height = parent.height + 1
+    node.work.Add(node.work, parent.work)
+  }
+  return node
+}
+
+// Create a method that returns the ancestor of the node at a given height
+func (node *blockNode) ancestor(height int) *blockNode {
+  for node != nil && node.height > height {
+    node = node.parent
+  }
+  return node
+}
+
+// Create a method that returns the median timestamp of the node and the blocks before it, up to medianTimeBlocks of them
+// A block must not be older than the median time past of its parent, so no single miner can pull the time of the chain
+// back, while the timestamps of the blocks may still be a little out of order
+func (node *blockNode) medianTimePast() int64 {
+  var times []int64
+  for ; node != nil && len(times) < medianTimeBlocks; node = node.parent {
+    times = append(times, node.block.Timestamp)
+  }
+  sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+  return times[len(times)/2]
+}
+
+// create the method that returns the earliest timestamp of a block on top of a known block, the median time past of
+// that block, false if it is unknown
+func (blockchain *Blockchain) MinBlockTime(parent []byte) (int64, bool) {
+  blockchain.mu.RLock()         // lock the chain for reading
+  defer blockchain.mu.RUnlock() // unlock it when done
+  node, ok := blockchain.index[indexKey(parent)]
+  if !ok {
+    return 0, false
+  }
+  return node.medianTimePast(), true
+}
+
+// Create a method that returns the hashes describing the branch ending with the node, for a peer to find where our chains split
+// The last blocks are listed one by one, then the steps double back to the genesis block, which is always included
+func (node *blockNode) locator() [][]byte {
+  var hashes [][]byte
+  step := 1
+  for node != nil {
+    hashes = append(hashes, node.block.MyBlockHash)
+    if node.height == 0 {
+      break // the genesis block is in
+    }
+    if len(hashes) >= 10 { // after the last 10 blocks, skip more and more
+      step *= 2
+    }
+    height := node.height - step
+    if height < 0 {
+      height = 0
+    }
+    node = node.ancestor(height)
+  }
+  return hashes
+}
+
+// Create a function that returns the key of a block hash in the index
+func indexKey(hash []byte) string {
+  return hex.EncodeToString(hash)
+}
+
+// create the method that returns the node of the last block of the main chain
+func (blockchain *Blockchain) tipNode() *blockNode {
+  return blockchain.index[indexKey(blockchain.Blocks[len(blockchain.Blocks)-1].MyBlockHash)]
+}
+
+// create the method that tells if a node is part of the main chain
+func (blockchain *Blockchain) onMainChain(node *blockNode) bool {
+  return node.height < len(blockchain.Blocks) && blockchain.Blocks[node.height] == node.block
+}
+
+// create the method that returns the height of the last block of the main chain
+func (blockchain *Blockchain) GetBestHeight() int {
+  blockchain.mu.RLock()         // lock the chain for reading
+  defer blockchain.mu.RUnlock() // unlock it when done
+  return len(blockchain.Blocks) - 1
+}
+
+// create the method that returns the last block of the main chain
+func (blockchain *Blockchain) Tip() *Block {
+  blockchain.mu.RLock()         // lock the chain for reading
+  defer blockchain.mu.RUnlock() // unlock it when done
+  return blockchain.Blocks[len(blockchain.Blocks)-1]
+}
+
+// create the method that counts the confirmations of a block at a height: the block itself and the blocks on top of it,
+// 0 for a block of a side branch
+func (blockchain *Blockchain) Confirmations(block *Block, height int) int {
+  blockchain.mu.RLock()         // lock the chain for reading
+  defer blockchain.mu.RUnlock() // unlock it when done
+  if height < 0 || height >= len(blockchain.Blocks) || blockchain.Blocks[height] != block {
+    return 0 // not on the main chain
+  }
+  return len(blockchain.Blocks) - height
+}
+
+// create the method that returns the locator of the main chain, for a peer to find where our chains split
+func (blockchain *Blockchain) Locator() [][]byte {
+  blockchain.mu.RLock()         // lock the chain for reading
+  defer blockchain.mu.RUnlock() // unlock it when done
+  return blockchain.tipNode().locator()
+}
+
+// create the method that returns the height following the first locator hash found on the main chain, the lock must be held
+// With no known hash it is the genesis block
+func (blockchain *Blockchain) locatorStart(locator [][]byte) int {
+  for _, hash := range locator { // the locator starts with the most recent blocks
+    if node, ok := blockchain.index[indexKey(hash)]; ok && blockchain.onMainChain(node) {
+      return node.height + 1 // the peer has everything up to this block
+    }
+  }
+  return 0
+}
+
+// create the method that returns the headers of the main chain following the first locator hash found on it, at most limit of them
+// With no known hash the headers start from the genesis block
+func (blockchain *Blockchain) HeadersAfter(locator [][]byte, limit int) []*Block {
+  blockchain.mu.RLock()         // lock the chain for reading
+  defer blockchain.mu.RUnlock() // unlock it when done
+  var headers []*Block
+  for height := blockchain.locatorStart(locator); height < len(blockchain.Blocks) && len(headers) < limit; height++ {
+    headers = append(headers, blockchain.Blocks[height].Header())
+  }
+  return headers
+}
+
+// create the method that returns the hashes of the main chain following the first locator hash found on it, at most limit of
+// them, so a peer on a fork gets the blocks after the last one we share
+func (blockchain *Blockchain) HashesAfter(locator [][]byte, limit int) [][]byte {
+  blockchain.mu.RLock()         // lock the chain for reading
+  defer blockchain.mu.RUnlock() // unlock it when done
+  var hashes [][]byte
+  for height := blockchain.locatorStart(locator); height < len(blockchain.Blocks) && len(hashes) < limit; height++ {
+    hashes = append(hashes, blockchain.Blocks[height].MyBlockHash)
+  }
+  return hashes
+}
+
+// create the method that finds a known block by its hash, on the main chain or on a side branch, with its height
+func (blockchain *Blockchain) GetBlock(hash []byte) (*Block, int, bool) {
+  blockchain.mu.RLock()         // lock the chain for reading
+  defer blockchain.mu.RUnlock() // unlock it when done
+  return blockchain.getBlock(hash)
+}
+
+// create the method that finds a known block by its hash, the lock must be held
+func (blockchain *Blockchain) getBlock(hash []byte) (*Block, int, bool) {
+  node, ok := blockchain.index[indexKey(hash)]
+  if !ok || node.invalid { // an invalid block is kept only to refuse the blocks on top of it
+    return nil, 0, false
+  }
+  return node.block, node.height, true
+}
+
+// create the method that builds the index from every stored block, side branches included
+func (blockchain *Blockchain) loadIndex(blocks map[string]*Block) {
+  indexBlocks(blockchain.index, blocks)
+}
+
+// Create a function that links blocks into an index, each block after its parent; blocks whose parent is missing are left out
+func indexBlocks(index map[string]*blockNode, blocks map[string]*Block) {
+  var add func(key string) *blockNode // add a block after its parent, recursively
+  add = func(key string) *blockNode {
+    if node, ok := index[key]; ok {
+      return node // already indexed
+    }
+    block := blocks[key]
+    var parent *blockNode
+    if len(block.PreviousBlockHash) > 0 {
+      if _, ok := blocks[indexKey(block.PreviousBlockHash)]; !ok {
+        return nil // the parent was never stored, the block cannot be used
+      }
+      if parent = add(indexKey(block.PreviousBlockHash)); parent == nil {
+        return nil
+      }
+    }
+    node := newBlockNode(block, parent)
+    index[key] = node
+    return node
+  }
+  for key := range blocks {
+    add(key)
+  }
+}
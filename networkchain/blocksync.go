@@ -0,0 +1,314 @@
+package main
+
+import (
+  "encoding/hex" // the downloads are keyed by hex block hash
+  "sync"         // for the lock of the downloads
+  "time"         // for the timeouts of the downloads
+)
+
+// Define some constants of the block download
+const (
+  downloadWindow       = 1024             // the blocks past the first missing one that may be downloaded ahead of it
+  maxBlocksInFlight    = 16               // the blocks requested from a peer at once
+  blockStallTimeout    = 5 * time.Second  // how long the first missing block may hold back the blocks downloaded after it
+  blockDownloadTimeout = 30 * time.Second // how long a requested block is waited for before another peer is asked
+  stallPenalty         = time.Minute      // how long a peer that stalled the download is asked for no block
+  syncCheckInterval    = time.Second      // how often the downloads are checked for timeouts and stalls
+)
+
+// Define a struct for a block to download
+type blockDownload struct {
+  hash      []byte          // the hash of the block
+  sources   map[string]bool // the peers that announced it
+  peer      string          // the peer it is requested from, empty while it waits
+  requested time.Time       // when it was requested
+  block     *Block          // the block received before its parent, held until the parent is added
+  from      string          // the peer that sent the held block
+  sender    sender          // the connection the held block came from, scored if it is invalid
+  taken     bool            // whether the held block is being added, it is neither taken nor requested again
+}
+
+// Define a struct for a block request to send
+type blockRequest struct {
+  peer string // the peer to ask
+  hash []byte // the block to ask for
+}
+
+// Define a struct for the parallel download of the blocks during a sync
+// The peers answering getblocks announce the blocks after the last one we share, which are queued in chain order; the
+// blocks in a window past the first missing one are requested from the peers that announced them, a few at a time from
+// each, and the blocks arriving before their parent are held until it is added
+// A block not received in time is asked from another peer, and a peer holding back the window with the first missing
+// block while the others delivered the blocks after it is asked for nothing for a while
+type blockSync struct {
+  mu       sync.Mutex                // the lock protecting the downloads
+  queue    []*blockDownload          // the blocks to download, in chain order
+  byHash   map[string]*blockDownload // the blocks to download by hex hash
+  inFlight map[string]int            // the number of blocks requested from each peer
+  stalled  map[string]time.Time      // the peers that stalled the download, until when they are asked for nothing
+}
+
+// Define a function to create an empty block download
+func newBlockSync() *blockSync {
+  return &blockSync{byHash: map[string]*blockDownload{}, inFlight: map[string]int{}, stalled: map[string]time.Time{}}
+}
+
+// Define a method to queue the blocks a peer announced, in chain order, the ones already queued get another source and
+// the ones added to the chain meanwhile are skipped
+func (s *blockSync) enqueue(peer string, hashes [][]byte, known func(hash []byte) bool) {
+  s.mu.Lock() // lock the downloads
+  defer s.mu.Unlock() // unlock them when done
+  for _, hash := range hashes {
+    key := hex.EncodeToString(hash)
+    d, ok := s.byHash[key]
+    if !ok && known(hash) { // a block is added before it is done, so it is either queued or known here
+      continue
+    }
+    if !ok {
+      d = &blockDownload{hash: hash, sources: map[string]bool{}}
+      s.byHash[key] = d
+      s.queue = append(s.queue, d)
+    }
+    d.sources[peer] = true
+  }
+}
+
+// Define a method to tell if blocks are being downloaded
+func (s *blockSync) syncing() bool {
+  s.mu.Lock() // lock the downloads
+  defer s.mu.Unlock() // unlock them when done
+  return len(s.queue) > 0
+}
+
+// Define a method to tell if a block is queued
+func (s *blockSync) queued(hash []byte) bool {
+  s.mu.Lock() // lock the downloads
+  defer s.mu.Unlock() // unlock them when done
+  _, ok := s.byHash[hex.EncodeToString(hash)]
+  return ok
+}
+
+// Define a method to count the blocks still to download, and find the peer the most of them are requested from, empty
+// when none is requested
+func (s *blockSync) status() (int, string) {
+  s.mu.Lock() // lock the downloads
+  defer s.mu.Unlock() // unlock them when done
+  peer := ""
+  for source, count := range s.inFlight {
+    if peer == "" || count > s.inFlight[peer] || (count == s.inFlight[peer] && source < peer) {
+      peer = source
+    }
+  }
+  return len(s.queue), peer
+}
+
+// Define a method to pick the blocks of the window to request, each from the least busy peer that announced it
+func (s *blockSync) schedule() []blockRequest {
+  s.mu.Lock() // lock the downloads
+  defer s.mu.Unlock() // unlock them when done
+  now := time.Now()
+  var requests []blockRequest
+  for i, d := range s.queue {
+    if i >= downloadWindow { // the blocks too far ahead wait for the window to move
+      break
+    }
+    if d.block != nil || d.peer != "" { // held or requested already
+      continue
+    }
+    peer, slow := "", "" // the least busy peer, and the least busy slow one, asked only when no other peer has the block
+    others := false // whether a peer that is not slow has the block
+    for source := range d.sources {
+      others = others || !now.Before(s.stalled[source])
+      if s.inFlight[source] >= maxBlocksInFlight {
+        continue
+      }
+      if now.Before(s.stalled[source]) {
+        if slow == "" || s.inFlight[source] < s.inFlight[slow] {
+          slow = source
+        }
+      } else if peer == "" || s.inFlight[source] < s.inFlight[peer] {
+        peer = source
+      }
+    }
+    if !others {
+      peer = slow
+    }
+    if peer == "" { // every peer that has it is busy
+      continue
+    }
+    d.peer, d.requested = peer, now
+    s.inFlight[peer]++
+    requests = append(requests, blockRequest{peer, d.hash})
+  }
+  return requests
+}
+
+// Define a method to stop waiting for a block from its peer, the lock must be held
+func (s *blockSync) unassign(d *blockDownload) {
+  if d.peer == "" {
+    return
+  }
+  if s.inFlight[d.peer]--; s.inFlight[d.peer] <= 0 {
+    delete(s.inFlight, d.peer)
+  }
+  d.peer = ""
+}
+
+// Define a method to hold a block received before its parent, returning false if its parent is not being downloaded, so
+// the block is on a branch the sync does not know, or if the parent was added meanwhile, so the block can be added
+// The parent is checked under the lock the held blocks are taken with, so a block is never held after its parent was
+// added and its held children taken
+func (s *blockSync) hold(from sender, peer string, block *Block, known func(hash []byte) bool) bool {
+  s.mu.Lock() // lock the downloads
+  defer s.mu.Unlock() // unlock them when done
+  d, ok := s.byHash[hex.EncodeToString(block.MyBlockHash)]
+  _, parentQueued := s.byHash[hex.EncodeToString(block.PreviousBlockHash)]
+  if !ok || !parentQueued || known(block.PreviousBlockHash) {
+    return false
+  }
+  s.unassign(d) // the peer may be asked for another one
+  d.block, d.from, d.sender = block, peer, from
+  return true
+}
+
+// Define a method to take a held block whose parent the chain has, nil if there is none
+func (s *blockSync) takeReady(known func(hash []byte) bool) (*Block, string, sender) {
+  s.mu.Lock() // lock the downloads
+  defer s.mu.Unlock() // unlock them when done
+  for _, d := range s.queue {
+    if d.block != nil && !d.taken && known(d.block.PreviousBlockHash) {
+      d.taken = true
+      return d.block, d.from, d.sender
+    }
+  }
+  return nil, "", sender{}
+}
+
+// Define a method to forget a block once it was added, or found invalid
+func (s *blockSync) done(hash []byte) {
+  s.mu.Lock() // lock the downloads
+  defer s.mu.Unlock() // unlock them when done
+  key := hex.EncodeToString(hash)
+  d, ok := s.byHash[key]
+  if !ok {
+    return
+  }
+  s.unassign(d)
+  delete(s.byHash, key)
+  for i, queued := range s.queue {
+    if queued == d {
+      s.queue = append(s.queue[:i], s.queue[i+1:]...)
+      break
+    }
+  }
+}
+
+// Define a method to stop downloading from a peer, the blocks only it announced are forgotten
+func (s *blockSync) dropPeer(peer string) {
+  s.mu.Lock() // lock the downloads
+  defer s.mu.Unlock() // unlock them when done
+  kept := s.queue[:0]
+  for _, d := range s.queue {
+    if d.peer == peer {
+      s.unassign(d)
+    }
+    delete(d.sources, peer)
+    if len(d.sources) == 0 && d.block == nil { // nobody else has it
+      delete(s.byHash, hex.EncodeToString(d.hash))
+      continue
+    }
+    kept = append(kept, d)
+  }
+  s.queue = kept
+  delete(s.inFlight, peer)
+  delete(s.stalled, peer)
+}
+
+// Define a method to give the requests that timed out to other peers, and to find the peer stalling the window: the one
+// asked for the first missing block too long ago while blocks after it arrived; it returns the peers that timed out or
+// stalled, each asked for no block for a while
+func (s *blockSync) check() []string {
+  s.mu.Lock() // lock the downloads
+  defer s.mu.Unlock() // unlock them when done
+  now := time.Now()
+  var slow []string
+  penalize := func(peer string) {
+    s.stalled[peer] = now.Add(stallPenalty)
+    slow = append(slow, peer)
+    for _, d := range s.queue { // its blocks go to the other peers
+      if d.peer == peer {
+        s.unassign(d)
+      }
+    }
+  }
+  for _, d := range s.queue {
+    if d.peer != "" && now.Sub(d.requested) > blockDownloadTimeout {
+      penalize(d.peer)
+    }
+  }
+  var first *blockDownload // the first block still missing
+  held := false // whether blocks after it arrived
+  for i, d := range s.queue {
+    if i >= downloadWindow {
+      break
+    }
+    if first == nil && d.block == nil {
+      first = d
+    } else if first != nil && d.block != nil {
+      held = true
+      break
+    }
+  }
+  if first != nil && held && first.peer != "" && now.Sub(first.requested) > blockStallTimeout && len(first.sources) > 1 {
+    penalize(first.peer) // another peer can send it
+  }
+  for peer, until := range s.stalled {
+    if now.After(until) {
+      delete(s.stalled, peer)
+    }
+  }
+  return slow
+}
+
+// Define a method to request the blocks of the window from the peers
+func (n *Node) scheduleBlocks() {
+  for _, request := range n.blocks.schedule() {
+    n.sendGetData(request.peer, "block", request.hash)
+  }
+}
+
+// Define a method to check if the chain has a block
+func (n *Node) hasBlock(hash []byte) bool {
+  _, _, ok := n.bc.GetBlock(hash)
+  return ok
+}
+
+// Define a method to add the held blocks whose parent was just added, in chain order
+func (n *Node) connectHeldBlocks() {
+  for {
+    block, peer, from := n.blocks.takeReady(n.hasBlock)
+    if block == nil {
+      return
+    }
+    n.connectBlock(from, peer, block)
+  }
+}
+
+// Define a method to check the downloads until the node stops, asking other peers for the blocks that are late, and to
+// publish the progress of the sync
+func (n *Node) blockSyncLoop() {
+  ticker := time.NewTicker(syncCheckInterval) // create a ticker for the interval
+  defer ticker.Stop() // stop it when done
+  for {
+    select {
+    case <-n.quit: // the node stopped
+      return
+    case <-ticker.C:
+      for _, peer := range n.blocks.check() {
+        netLog.Info("Peer is slow to send blocks, asking the others", "peer", peer, "for", stallPenalty)
+      }
+      n.scheduleBlocks()
+      n.publishSyncProgress() // and tell how far the sync is
+    }
+  }
+}
@@ -0,0 +1,120 @@
+// Package bloom implements the bloom filters light clients use to find their transactions.
+// A filter answers "maybe" for every element added to it and for a small fraction of other
+// data, and "no" for the rest, so a node can select data for a client without the client
+// listing exactly what it looks for.
+package bloom
+
+import (
+  "crypto/sha256"   // the hash the bit positions are derived from
+  "encoding/binary" // to read the hashes and encode the filter header
+  "errors"          // for the parsing errors
+  "math"            // to size the filter
+)
+
+// Define the limits of a filter, larger filters match everything anyway
+const (
+  MaxSize   = 36000 // the largest filter, in bytes
+  MaxHashes = 50    // the most hash functions a filter may use
+)
+
+// Define an error returned for a filter that cannot be read or breaks the limits
+var ErrMalformed = errors.New("bloom: malformed filter")
+
+// Define a struct for a filter
+type Filter struct {
+  bits   []byte // the bit field
+  hashes uint32 // the number of bits set for each element
+  tweak  uint32 // a random value changing the bit positions, so two filters of the same data differ
+}
+
+// Define a function to create an empty filter sized for a number of elements and a false positive rate
+func New(elements int, fpRate float64, tweak uint32) *Filter {
+  if elements < 1 {
+    elements = 1
+  }
+  size := int(-float64(elements) * math.Log(fpRate) / (math.Ln2 * math.Ln2) / 8) // the optimal size in bytes
+  if size < 1 {
+    size = 1
+  }
+  if size > MaxSize {
+    size = MaxSize
+  }
+  hashes := uint32(float64(size*8) / float64(elements) * math.Ln2) // the optimal number of hashes for that size
+  if hashes < 1 {
+    hashes = 1
+  }
+  if hashes > MaxHashes {
+    hashes = MaxHashes
+  }
+  return &Filter{make([]byte, size), hashes, tweak}
+}
+
+// Define a function to rebuild a filter from its parts, checking the limits
+func Load(bits []byte, hashes, tweak uint32) (*Filter, error) {
+  if len(bits) == 0 || len(bits) > MaxSize || hashes == 0 || hashes > MaxHashes {
+    return nil, ErrMalformed
+  }
+  return &Filter{append([]byte{}, bits...), hashes, tweak}, nil
+}
+
+// Define a function to rebuild a filter from its serialized form
+func Parse(data []byte) (*Filter, error) {
+  if len(data) < 8 {
+    return nil, ErrMalformed
+  }
+  return Load(data[8:], binary.BigEndian.Uint32(data[:4]), binary.BigEndian.Uint32(data[4:8]))
+}
+
+// Define a method to serialize the filter: the number of hashes, the tweak and the bit field
+func (f *Filter) Bytes() []byte {
+  data := make([]byte, 8, 8+len(f.bits))
+  binary.BigEndian.PutUint32(data[:4], f.hashes)
+  binary.BigEndian.PutUint32(data[4:8], f.tweak)
+  return append(data, f.bits...)
+}
+
+// Define a method to get the bit field of the filter
+func (f *Filter) Bits() []byte {
+  return append([]byte{}, f.bits...)
+}
+
+// Define a method to get the number of hashes of the filter
+func (f *Filter) Hashes() uint32 {
+  return f.hashes
+}
+
+// Define a method to get the tweak of the filter
+func (f *Filter) Tweak() uint32 {
+  return f.tweak
+}
+
+// Define a method to compute the bit positions of an element
+// Two hashes are taken from SHA256 of the tweak and the element, the positions are their combinations
+func (f *Filter) positions(data []byte) []uint32 {
+  var seed [4]byte
+  binary.BigEndian.PutUint32(seed[:], f.tweak)
+  sum := sha256.Sum256(append(seed[:], data...))
+  first, second := binary.BigEndian.Uint32(sum[:4]), binary.BigEndian.Uint32(sum[4:8])
+  positions := make([]uint32, f.hashes)
+  for i := range positions {
+    positions[i] = (first + uint32(i)*second) % uint32(len(f.bits)*8)
+  }
+  return positions
+}
+
+// Define a method to add an element to the filter
+func (f *Filter) Add(data []byte) {
+  for _, bit := range f.positions(data) {
+    f.bits[bit/8] |= 1 << (bit % 8)
+  }
+}
+
+// Define a method to check whether an element may be in the filter
+func (f *Filter) Matches(data []byte) bool {
+  for _, bit := range f.positions(data) {
+    if f.bits[bit/8]&(1<<(bit%8)) == 0 {
+      return false // a missing bit means the element was never added
+    }
+  }
+  return true
+}
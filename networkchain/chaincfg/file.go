@@ -0,0 +1,97 @@
+package chaincfg
+
+import (
+  "bytes"         // to decode the file
+  "encoding/hex"  // to check the genesis hash
+  "errors"        // for the errors of the parameters
+  "fmt"           // to format the errors
+  "os"            // to read and write the file
+  "strconv"       // to check the port
+
+  "gopkg.in/yaml.v3" // the format of the file
+)
+
+// Define a function to read the parameters of a private network from a YAML file, as written by WriteFile
+func LoadFile(path string) (*Params, error) {
+  data, err := os.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+  params := &Params{}
+  decoder := yaml.NewDecoder(bytes.NewReader(data))
+  decoder.KnownFields(true) // a misspelled key is an error, not a parameter silently left to zero
+  if err := decoder.Decode(params); err != nil {
+    return nil, fmt.Errorf("chaincfg: %s: %w", path, err)
+  }
+  if err := params.Validate(); err != nil {
+    return nil, fmt.Errorf("chaincfg: %s: %w", path, err)
+  }
+  return params, nil
+}
+
+// Define a method to write the parameters to a YAML file every node of a private network loads
+func (p *Params) WriteFile(path string) error {
+  data, err := yaml.Marshal(p)
+  if err != nil {
+    return err
+  }
+  return os.WriteFile(path, data, 0644)
+}
+
+// Define a method to check the parameters of a private network, which must not be taken for a known network
+func (p *Params) Validate() error {
+  if p.Name == "" {
+    return errors.New("the name is empty")
+  }
+  for _, known := range networks {
+    if p.Name == known.Name {
+      return fmt.Errorf("the name %q is the one of a known network", p.Name)
+    }
+    if p.Net == known.Net {
+      return fmt.Errorf("the magic bytes %08x are the ones of the %s network", p.Net, known.Name)
+    }
+  }
+  if p.Net == 0 {
+    return errors.New("the magic bytes are zero")
+  }
+  if port, err := strconv.Atoi(p.DefaultPort); err != nil || port <= 0 || port > 65535 {
+    return fmt.Errorf("invalid default port %q", p.DefaultPort)
+  }
+  if p.GenesisMessage == "" {
+    return errors.New("the genesis message is empty")
+  }
+  if p.PowLimitBits == 0 {
+    return errors.New("the target is zero")
+  }
+  if p.TargetBlockTime <= 0 {
+    return fmt.Errorf("the target block time must be positive, got %s", p.TargetBlockTime)
+  }
+  if p.InitialSubsidy < 0 || p.RetargetInterval < 0 || p.SubsidyHalvingInterval < 0 || p.CoinbaseMaturity < 0 || p.DustThreshold < 0 || p.MaxTimeDrift < 0 || p.MaxBlockSize < 0 {
+    return errors.New("the subsidy, the intervals, the coinbase maturity, the dust threshold, the time drift and the block size cannot be negative")
+  }
+  if err := p.CheckConsensus(); err != nil {
+    return err
+  }
+  if p.InitialSubsidy > MaxMoney {
+    return fmt.Errorf("the subsidy %d is over the most coins there can be, %d", p.InitialSubsidy, MaxMoney)
+  }
+  genesisValue := p.InitialSubsidy // the coinbase of the genesis block pays the subsidy and the premine
+  for _, out := range p.GenesisOutputs {
+    if out.Address == "" || out.Value <= 0 {
+      return fmt.Errorf("invalid genesis output of %d to %q", out.Value, out.Address)
+    }
+    if out.Value > MaxMoney-genesisValue {
+      return fmt.Errorf("the genesis block pays more than the most coins there can be, %d", MaxMoney)
+    }
+    genesisValue += out.Value
+  }
+  if decoded, err := hex.DecodeString(p.GenesisHash); err != nil || (p.GenesisHash != "" && len(decoded) != 32) {
+    return fmt.Errorf("invalid genesis hash %q", p.GenesisHash)
+  }
+  for _, checkpoint := range append([]Checkpoint{p.AssumeValid}, p.Checkpoints...) {
+    if decoded, err := hex.DecodeString(checkpoint.Hash); err != nil || (checkpoint.Hash != "" && len(decoded) != 32) || checkpoint.Height < 0 {
+      return fmt.Errorf("invalid checkpoint %d:%s", checkpoint.Height, checkpoint.Hash)
+    }
+  }
+  return nil
+}
@@ -0,0 +1,314 @@
+// Package chaincfg defines the parameters of the networks a node can run on.
+// Every node of a network must use the same parameters: the magic bytes keep the messages
+// of different networks apart, and the genesis and difficulty rules decide which blocks are valid.
+package chaincfg
+
+import (
+  "encoding/hex"         // to check the hashes of the checkpoints
+  "fmt"                  // for the unknown network error
+  "networkchain/address" // to check the addresses of the validators
+  "strconv"              // to read the heights of the checkpoints
+  "strings"              // to list the network names
+  "time"                 // for the target block time
+)
+
+// Define the defaults of the limits a parameters file written before them does not set
+const (
+  DefaultMaxTimeDrift = 2 * time.Hour // how far in the future a block timestamp may be
+  DefaultMaxBlockSize = 1000000       // the largest serialized block, in bytes
+)
+
+// The most coins a value may hold on any network: no output, and no sum of the outputs or of the inputs of a
+// transaction, may go above it, so the sums of the values never overflow
+const MaxMoney = 1000000000000000
+
+// Define a struct for a checkpoint: a block known to be on the chain of the network
+type Checkpoint struct {
+  Height int    `yaml:"height"` // the height of the block
+  Hash   string `yaml:"hash"`   // the hex hash of the block
+}
+
+// Define a struct for an output of the genesis block, coins given out before any block is mined
+type GenesisOutput struct {
+  Address string `yaml:"address"` // the address receiving the coins
+  Value   int    `yaml:"value"`   // the amount of coins
+}
+
+// The consensus engines a network can run on
+const (
+  ProofOfWork  = "pow" // blocks are mined, the branch with the most work wins
+  ProofOfStake = "pos" // blocks are signed by validators picked by stake, the longest branch wins
+  BFT          = "bft" // blocks are agreed on by a fixed set of validators in rounds of votes and are final at once
+)
+
+// The hash functions the headers of a proof of work network can be hashed with
+const (
+  HashSHA256       = "sha256"  // SHA-256, the hash of the networks built before the others
+  HashDoubleSHA256 = "sha256d" // SHA-256 of the SHA-256
+  HashSHA3         = "sha3"    // SHA3-256
+  HashBlake2b      = "blake2b" // BLAKE2b-256
+)
+
+// Define a struct for a validator: an address whose key signs blocks, picked in proportion to its stake on a proof of
+// stake network; the validators of a BFT network all have the same weight
+type Validator struct {
+  Address string `yaml:"address"` // the address of the key signing the blocks
+  Stake   int    `yaml:"stake"`   // the weight of the validator in the choice of the proposers
+}
+
+// Define a struct for the parameters of a network, the yaml tags are the keys of a parameters file
+type Params struct {
+  Name                   string          `yaml:"name"`                       // the name selecting the network in the settings
+  Net                    uint32          `yaml:"net"`                        // the magic bytes starting every message
+  DefaultPort            string          `yaml:"defaultport"`                // the port of the addresses given without one
+  GenesisTime            int64           `yaml:"genesistime"`                // the timestamp of the genesis block
+  GenesisMessage         string          `yaml:"genesismessage"`             // the data of the coinbase input of the genesis block
+  GenesisOutputs         []GenesisOutput `yaml:"genesisoutputs,omitempty"`   // the outputs of the coinbase of the genesis block, the premine
+  GenesisHash            string          `yaml:"genesishash"`                // the hex hash of the genesis block, empty if the genesis block pays the miner of each deployment
+  PowLimitBits           uint32          `yaml:"powlimitbits"`               // the easiest target in compact form, also the target of the genesis block
+  RetargetInterval       int             `yaml:"retargetinterval"`           // the number of blocks between two difficulty adjustments, 0 to never adjust
+  TargetBlockTime        time.Duration   `yaml:"targetblocktime"`            // the time a block should take to mine on average
+  InitialSubsidy         int             `yaml:"initialsubsidy"`             // the coins created by the coinbase of the first blocks
+  SubsidyHalvingInterval int             `yaml:"subsidyhalvinginterval"`     // the number of blocks between two halvings of the subsidy, 0 to never halve
+  CoinbaseMaturity       int             `yaml:"coinbasematurity,omitempty"` // the number of blocks built on a coinbase before its outputs can be spent, 0 to spend them at once
+  MaxTimeDrift           time.Duration   `yaml:"maxtimedrift,omitempty"`     // how far ahead of the clock of a node a block timestamp may be, DefaultMaxTimeDrift if 0
+  MaxBlockSize           int             `yaml:"maxblocksize,omitempty"`     // the largest serialized block in bytes, DefaultMaxBlockSize if 0
+  DustThreshold          int             `yaml:"dustthreshold,omitempty"`    // the smallest value of an output the mempool accepts, a smaller one costs more to spend than it holds; 0 accepts any
+  MineOnDemand           bool            `yaml:"mineondemand,omitempty"`     // whether the nodes mine blocks at once when asked to, for the tests of the applications
+  Consensus              string          `yaml:"consensus,omitempty"`        // the consensus engine, ProofOfWork if empty
+  BlockHash              string          `yaml:"blockhash,omitempty"`        // the hash of the headers the work is done on, HashSHA256 if empty
+  Validators             []Validator     `yaml:"validators,omitempty"`       // the validators of a proof of stake or BFT network, of its first epoch if it rotates them
+  EpochLength            int             `yaml:"epochlength,omitempty"`      // the number of blocks between two rotations of the validators, 0 keeps the validators above
+  MinStake               int             `yaml:"minstake,omitempty"`         // the coins an address must have bonded to join the validators at the next epoch
+  Checkpoints            []Checkpoint    `yaml:"checkpoints,omitempty"`      // blocks known to be on the chain, by increasing height; no fork below the last one reached is accepted
+  AssumeValid            Checkpoint      `yaml:"assumevalid,omitempty"`      // the signatures of this block and its ancestors are not checked, an empty hash checks them all
+}
+
+// Define the parameters of the main network
+var MainNetParams = Params{
+  Name:                   "mainnet",
+  Net:                    0x6e63686e, // "nchn"
+  DefaultPort:            "3000",
+  GenesisTime:            1735689600, // 2025-01-01 00:00:00 UTC
+  GenesisMessage:         "Genesis Block",
+  PowLimitBits:           0x1f00ffff, // a hash must start with 16 zero bits
+  RetargetInterval:       10,
+  TargetBlockTime:        10 * time.Second,
+  InitialSubsidy:         10,
+  SubsidyHalvingInterval: 210000,
+  CoinbaseMaturity:       100,
+  MaxTimeDrift:           2 * time.Hour,
+  MaxBlockSize:           1000000,
+  DustThreshold:          1, // spending an output costs about a coin at the minimum relay fee
+  Checkpoints:            nil, // the genesis block pays the miner of each deployment, the checkpoints come with the settings until the network settles
+}
+
+// Define the parameters of the test network, the same rules as the main network on separate nodes
+var TestNetParams = Params{
+  Name:                   "testnet",
+  Net:                    0x6e637474, // "nctt"
+  DefaultPort:            "13000",
+  GenesisTime:            1735689600,
+  GenesisMessage:         "Testnet Genesis Block",
+  PowLimitBits:           0x1f00ffff,
+  RetargetInterval:       10,
+  TargetBlockTime:        10 * time.Second,
+  InitialSubsidy:         10,
+  SubsidyHalvingInterval: 210000,
+  CoinbaseMaturity:       100,
+  MaxTimeDrift:           2 * time.Hour,
+  MaxBlockSize:           1000000,
+  DustThreshold:          1,
+}
+
+// Define the parameters of the regression test network, mining is instant and the difficulty never changes
+var RegTestParams = Params{
+  Name:                   "regtest",
+  Net:                    0x6e637267, // "ncrg"
+  DefaultPort:            "23000",
+  GenesisTime:            1735689600,
+  GenesisMessage:         "Regtest Genesis Block",
+  PowLimitBits:           0x207fffff, // every other hash meets the target
+  RetargetInterval:       0,
+  TargetBlockTime:        10 * time.Second,
+  InitialSubsidy:         10,
+  SubsidyHalvingInterval: 150, // a few blocks show the halvings
+  CoinbaseMaturity:       5,   // and the maturity of the coinbases
+  MaxTimeDrift:           2 * time.Hour,
+  MaxBlockSize:           1000000,
+  DustThreshold:          1,
+  MineOnDemand:           true,
+}
+
+// The known networks, in the order they are listed
+var networks = []*Params{&MainNetParams, &TestNetParams, &RegTestParams}
+
+// Define a function to find the parameters of a network by name
+func ByName(name string) (*Params, error) {
+  for _, params := range networks {
+    if params.Name == name {
+      return params, nil
+    }
+  }
+  return nil, fmt.Errorf("chaincfg: unknown network %q, expected one of %s", name, strings.Join(Names(), ", "))
+}
+
+// Define a function to list the names of the known networks
+func Names() []string {
+  var names []string
+  for _, params := range networks {
+    names = append(names, params.Name)
+  }
+  return names
+}
+
+// Define a function to read a checkpoint written height:hash
+func ParseCheckpoint(text string) (Checkpoint, error) {
+  height, hash, ok := strings.Cut(text, ":")
+  if !ok {
+    return Checkpoint{}, fmt.Errorf("chaincfg: checkpoint %q is not height:hash", text)
+  }
+  n, err := strconv.Atoi(height)
+  if err != nil || n < 0 {
+    return Checkpoint{}, fmt.Errorf("chaincfg: checkpoint %q has an invalid height", text)
+  }
+  if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != 32 {
+    return Checkpoint{}, fmt.Errorf("chaincfg: checkpoint %q has an invalid hash", text)
+  }
+  return Checkpoint{n, strings.ToLower(hash)}, nil
+}
+
+// Define a method to check the consensus engine and its validators
+func (p *Params) CheckConsensus() error {
+  switch p.Consensus {
+  case "", ProofOfWork:
+    if p.EpochLength != 0 || p.MinStake != 0 {
+      return fmt.Errorf("a %s network has no validators to rotate", ProofOfWork)
+    }
+    switch p.BlockHash {
+    case "", HashSHA256, HashDoubleSHA256, HashSHA3, HashBlake2b:
+    default:
+      return fmt.Errorf("unknown block hash %q, expected %s, %s, %s or %s", p.BlockHash, HashSHA256, HashDoubleSHA256, HashSHA3, HashBlake2b)
+    }
+    return nil
+  case ProofOfStake, BFT:
+  default:
+    return fmt.Errorf("unknown consensus %q, expected %s, %s or %s", p.Consensus, ProofOfWork, ProofOfStake, BFT)
+  }
+  if p.BlockHash != "" { // the signed headers are hashed as they always were
+    return fmt.Errorf("a %s network cannot choose the block hash", p.Consensus)
+  }
+  if len(p.Validators) == 0 {
+    return fmt.Errorf("a %s network needs validators", p.Consensus)
+  }
+  if p.EpochLength < 0 || p.MinStake < 0 {
+    return fmt.Errorf("invalid epoch length %d or minimum stake %d", p.EpochLength, p.MinStake)
+  }
+  if p.EpochLength > 0 && p.MinStake == 0 { // every bond of a coin would make a validator
+    return fmt.Errorf("a network rotating its validators every %d blocks needs a minimum stake", p.EpochLength)
+  }
+  seen := map[string]bool{}
+  for _, validator := range p.Validators {
+    if !address.Validate(validator.Address) || validator.Stake <= 0 {
+      return fmt.Errorf("invalid validator %s with stake %d", validator.Address, validator.Stake)
+    }
+    if seen[validator.Address] { // a validator listed twice would vote twice
+      return fmt.Errorf("validator %s is listed twice", validator.Address)
+    }
+    seen[validator.Address] = true
+  }
+  return nil
+}
+
+// Define a function to read a validator written address:stake, or address alone for a stake of 1
+func ParseValidator(text string) (Validator, error) {
+  addr, stake, ok := strings.Cut(text, ":")
+  if !ok {
+    return Validator{addr, 1}, nil
+  }
+  n, err := strconv.Atoi(stake)
+  if err != nil {
+    return Validator{}, fmt.Errorf("chaincfg: validator %q is not address:stake", text)
+  }
+  return Validator{addr, n}, nil
+}
+
+// Define a method to get how far ahead of the clock of a node a block timestamp may be
+func (p *Params) MaxFutureBlockTime() time.Duration {
+  if p.MaxTimeDrift == 0 { // a parameters file written before the drift was set
+    return DefaultMaxTimeDrift
+  }
+  return p.MaxTimeDrift
+}
+
+// Define a method to get the largest serialized block in bytes
+func (p *Params) BlockSizeLimit() int {
+  if p.MaxBlockSize == 0 { // a parameters file written before the limit was set
+    return DefaultMaxBlockSize
+  }
+  return p.MaxBlockSize
+}
+
+// Define a method to tell if the blocks are mined, the networks built before the other engines leave the consensus empty
+func (p *Params) IsProofOfWork() bool {
+  return p.Consensus == "" || p.Consensus == ProofOfWork
+}
+
+// Define a method to tell if the validators change over time: every EpochLength blocks, the addresses with bonds of at
+// least MinStake become the validators, the ones of the parameters only sign the first epoch
+func (p *Params) RotatesValidators() bool {
+  return p.EpochLength > 0
+}
+
+// Define a method to get the epoch of a height, always 0 when the validators do not rotate
+func (p *Params) Epoch(height int) int {
+  if !p.RotatesValidators() {
+    return 0
+  }
+  return height / p.EpochLength
+}
+
+// Define a method to tell if the genesis block is the same on every node of the network: it pays the premine,
+// or the subsidy to nobody, instead of the miner of each deployment
+func (p *Params) FixedGenesis() bool {
+  return p.GenesisHash != "" || len(p.GenesisOutputs) > 0
+}
+
+// Define a method to get the coins the genesis block may pay on top of its subsidy, the premine
+func (p *Params) Premine() int {
+  total := 0
+  for _, out := range p.GenesisOutputs {
+    total += out.Value
+  }
+  return total
+}
+
+// Define a method to find the block a chain must have at a height: a checkpoint or the assume-valid block,
+// which is enforced like a checkpoint so the blocks whose signatures were skipped are its ancestors
+func (p *Params) CheckpointAt(height int) (Checkpoint, bool) {
+  if p.AssumeValid.Hash != "" && p.AssumeValid.Height == height {
+    return p.AssumeValid, true
+  }
+  for _, checkpoint := range p.Checkpoints {
+    if checkpoint.Height == height {
+      return checkpoint, true
+    }
+  }
+  return Checkpoint{}, false
+}
+
+// Define a method to find the highest checkpoint at or below a height, the assume-valid block included
+func (p *Params) LastCheckpoint(height int) (Checkpoint, bool) {
+  last, found := Checkpoint{}, false
+  for _, checkpoint := range append([]Checkpoint{p.AssumeValid}, p.Checkpoints...) {
+    if checkpoint.Hash != "" && checkpoint.Height <= height && (!found || checkpoint.Height > last.Height) {
+      last, found = checkpoint, true
+    }
+  }
+  return last, found
+}
+
+// Define a method to tell if the signatures of the block at a height are assumed valid
+func (p *Params) AssumedValid(height int) bool {
+  return p.AssumeValid.Hash != "" && height <= p.AssumeValid.Height
+}
@@ -0,0 +1,49 @@
+package chaincfg
+
+// The number of halvings after which the subsidy is zero whatever its initial value
+const maxHalvings = 63
+
+// Define a method to get the coins created by the coinbase of the block at a height
+// The subsidy starts at InitialSubsidy and is halved every SubsidyHalvingInterval blocks, rounding down
+func (p *Params) BlockSubsidy(height int) int {
+  if p.SubsidyHalvingInterval <= 0 { // the subsidy never changes
+    return p.InitialSubsidy
+  }
+  halvings := height / p.SubsidyHalvingInterval
+  if halvings >= maxHalvings {
+    return 0
+  }
+  return p.InitialSubsidy >> uint(halvings)
+}
+
+// Define a method to get the coins created by the blocks from the genesis block up to a height, the premine included
+func (p *Params) TotalSupply(height int) int {
+  blocks := height + 1 // the genesis block pays the subsidy too
+  if p.SubsidyHalvingInterval <= 0 {
+    return p.Premine() + blocks*p.InitialSubsidy
+  }
+  total := p.Premine()
+  for subsidy := p.InitialSubsidy; blocks > 0 && subsidy > 0; subsidy >>= 1 { // one era at a time
+    count := blocks
+    if count > p.SubsidyHalvingInterval {
+      count = p.SubsidyHalvingInterval
+    }
+    total += count * subsidy
+    blocks -= count
+  }
+  return total
+}
+
+// Define a method to get the height of the next block whose subsidy is halved, 0 if the subsidy no longer changes
+func (p *Params) NextHalving(height int) int {
+  if p.SubsidyHalvingInterval <= 0 || p.BlockSubsidy(height) == 0 {
+    return 0
+  }
+  return (height/p.SubsidyHalvingInterval + 1) * p.SubsidyHalvingInterval
+}
+
+// Define a method to tell if the outputs of the coinbase of the block at a height can be spent by the block at another
+// height: CoinbaseMaturity blocks must be built on it first, the premine of the genesis block is spendable at once
+func (p *Params) CoinbaseMature(coinbaseHeight, height int) bool {
+  return coinbaseHeight == 0 || height-coinbaseHeight >= p.CoinbaseMaturity
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+  "errors"                 // for the network mismatch error
+  "networkchain/chaincfg"  // the parameters of the networks
+  "networkchain/consensus" // the engine of the active network
+  "networkchain/storage"   // the network is recorded in the store
+)
+
+// The parameters of the network the node runs on, set from the settings before the chain is opened
+var activeNet = &chaincfg.MainNetParams
+
+// create the function that returns the consensus engine of the active network
+func activeEngine() consensus.Engine {
+  return consensus.New(activeNet)
+}
+
+// The metadata key holding the name of the network of the store
+const networkKey = "network"
+
+// create the function that checks a store holds the chain of the active network, recording it in a new store
+// Stores created before the networks existed hold the main network chain
+func checkNetwork(db *storage.Store, empty bool) error {
+  name, err := db.Meta(networkKey)
+  if err != nil {
+    return err
+  }
+  if name == nil {
+    name = []byte(chaincfg.MainNetParams.Name)
+    if empty {
+      name = []byte(activeNet.Name)
+    }
+    if err := db.SetMeta(networkKey, name); err != nil {
+      return err
+    }
+  }
+  if string(name) != activeNet.Name {
+    return errors.New("the data directory holds the " + string(name) + " chain, not the " + activeNet.Name + " one")
+  }
+  return nil
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+  "crypto/sha256"         // to derive the magic bytes of a private network from its name
+  "encoding/binary"       // to read the magic bytes
+  "errors"                // for the errors of the commands
+  "fmt"                   // to print the results
+  "networkchain/address"  // to check the addresses given
+  "networkchain/chaincfg" // the parameters written by the genesis command
+  "math"                  // to bound the lock time
+  "networkchain/config"   // the settings of the node
+  "networkchain/wallet"   // the keys of the user
+  "strconv"               // to read the amounts and the targets of the genesis command
+  "strings"               // to split the premine outputs
+  "time"                  // the default timestamp of a genesis block
+
+  "github.com/spf13/cobra" // the command line interface
+)
+
+// Create the command that starts a node
+func startNodeCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use:   "startnode",
+    Short: "Start a node, mining the pending transactions if a miner address is given",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      cfg, err := loadConfig(cmd) // merge the file, the environment and the flags
+      if err != nil {
+        return err
+      }
+      if cfg.Miner != "" && !address.Validate(cfg.Miner) {
+        return fmt.Errorf("invalid miner address %q", cfg.Miner)
+      }
+      if cfg.StratumAddr != "" && !activeNet.IsProofOfWork() {
+        return fmt.Errorf("the blocks of %s are signed, there is no work for external miners", activeNet.Name)
+      }
+      for _, watched := range cfg.Watch {
+        if !address.Validate(watched) {
+          return fmt.Errorf("invalid watched address %q", watched)
+        }
+      }
+      if cfg.Light { // only the headers and the wallet transactions are kept
+        StartLightNode(cfg) // runs until the process exits
+        return nil
+      }
+      StartNode(cfg) // runs until the process exits
+      return nil
+    },
+  }
+  defaults := config.Default() // the flags show the defaults, the settings are merged by loadConfig
+  flags := cmd.Flags()
+  flags.StringSlice("listen", nil, "address the node listens on, IPv4 or IPv6 like [::1]:port, repeated to listen on several, localhost and the port of the network by default")
+  flags.String("externaladdr", "", "address advertised to peers instead of the first listening one, like the .onion address of a Tor hidden service forwarding to the node")
+  flags.String("proxy", "", "SOCKS5 proxy written [user:password@]host:port every connection to peers goes through, like Tor at localhost:9050, which also reaches .onion peers")
+  flags.String("firstnode", "", "node every node knows, relaying transactions to the others, localhost and the port of the network by default")
+  flags.String("miner", "", "address receiving the rewards of the blocks mined by the node, and of the genesis block of a new chain")
+  flags.Int("mintxs", defaults.MinTxs, "number of mempool transactions that triggers mining a block")
+  flags.Int("minerthreads", defaults.MinerThreads, "number of goroutines searching the nonces of a proof of work block")
+  flags.StringSlice("dnsseed", nil, "DNS seed to query for peers")
+  flags.StringSlice("addnode", nil, "address of a peer to connect to")
+  flags.StringSlice("connect", nil, "connect only to this peer")
+  flags.Int("targetoutbound", defaults.TargetOutbound, "number of peers the node dials and keeps, picked from the known addresses after the first node and the added nodes")
+  flags.Int("maxinbound", defaults.MaxInbound, "most peers dialing the node it accepts, a new one evicts the youngest peer of the busiest network, sparing the oldest and the fastest peers")
+  flags.Int("maxoutbound", defaults.MaxOutbound, "most peers the node dials, the first node and the added nodes included")
+  flags.Bool("tls", false, "encrypt the connections with peers supporting TLS")
+  flags.String("tlscert", "", "PEM certificate of the node, a self-signed one is generated if empty")
+  flags.String("tlskey", "", "PEM private key of the certificate")
+  flags.String("tlsca", "", "PEM certificates peers must be signed with")
+  flags.Bool("tlsrequire", false, "refuse peers that do not support TLS")
+  flags.Bool("noise", false, "encrypt the connections with peers with a handshake proving the identity key of each side, kept in the nodekey file of the data directory")
+  flags.Bool("noiserequire", false, "refuse peers that do not complete the Noise handshake")
+  flags.StringSlice("whitelist", nil, "node ID of a peer never banned nor rate limited, proven by the Noise handshake")
+  flags.String("rpcaddr", "", "address serving JSON-RPC, REST and WebSocket requests, like :8332 for localhost or 0.0.0.0:8332 for every interface, disabled if empty")
+  flags.String("rpcuser", "", "user of the HTTP basic authentication of the RPC requests, set with rpcpassword")
+  flags.String("rpcpassword", "", "password of the RPC requests, a random one is written to the .cookie file of the data directory if empty")
+  flags.String("grpcaddr", "", "address serving gRPC requests, disabled if empty")
+  flags.String("stratumaddr", "", "address serving the stratum mining protocol to external miners, disabled if empty")
+  flags.Int("stratumdifficulty", defaults.StratumDifficulty, "difficulty of the shares of the external miners, the easiest target divided by it")
+  flags.String("zmqaddr", "", "address publishing the hashblock, hashtx, rawblock and rawtx topics to ZMQ subscribers, disabled if empty")
+  flags.Bool("light", false, "keep only the block headers and find the transactions of the watched addresses with block filters")
+  flags.StringSlice("watch", nil, "address whose transactions a light node looks for, or the webhooks of a full node report")
+  flags.StringSlice("webhook", nil, "HTTP URL the new blocks, the reorganizations and the activity of the watched addresses are posted to as signed JSON")
+  flags.String("webhooksecret", "", "key signing the payloads of the webhooks with HMAC-SHA256")
+  flags.Duration("banduration", defaults.BanDuration, "how long a misbehaving peer is banned")
+  flags.Bool("compress", defaults.Compress, "compress blocks and other large payloads for the peers accepting it")
+  flags.Bool("nat", defaults.NAT, "map the port with UPnP or NAT-PMP on the router of a node listening beyond loopback and advertise the external address, false disables it")
+  flags.StringSlice("checkpoint", nil, "block written height:hash the chain must go through, no fork below it is accepted")
+  flags.String("assumevalid", "", "block written height:hash whose ancestors are not signature checked, none to check them all")
+  flags.String("consensus", "", "consensus engine, pow, pos or bft, the one of the network by default")
+  flags.StringSlice("validator", nil, "validator written address:stake of a proof of stake network, or address of a BFT one")
+  flags.String("passphrase", "", "passphrase of the wallet file holding the keys paying with sendtoaddress and the key of the miner address of a proof of stake or BFT validator")
+  flags.StringSlice("wallet", nil, "named wallet of the wallets directory loaded at startup, repeated for each one")
+  flags.Bool("txindex", defaults.TxIndex, "index the transactions by ID so any transaction of the chain can be looked up, false drops the index")
+  flags.Bool("addrindex", defaults.AddrIndex, "index the transactions by address for the history queries and the wallet scans, false drops the index")
+  flags.Int("utxocache", defaults.UTXOCache, "megabytes the changes of the UTXO set may use in memory before they are written to the store, 0 writes every block")
+  flags.Int("sigcache", defaults.SigCache, "number of valid signatures remembered so the mempool transactions are not verified again when their block arrives, 0 verifies every signature")
+  flags.Duration("mempoolexpiry", defaults.MempoolExpiry, "how long a transaction waits in the mempool to be mined before it is dropped")
+  flags.Int("minrelayfee", defaults.MinRelayFee, fmt.Sprintf("fee per %d bytes below which transactions are neither accepted nor relayed, raised while the mempool is full", feeRateUnit))
+  flags.Int("prune", 0, fmt.Sprintf("number of recent blocks keeping their transactions, at least %d, 0 keeps every block", config.MinPrune))
+  return cmd
+}
+
+// Create the command that adds a new key to the wallet file
+func createWalletCmd() *cobra.Command {
+  var passphrase, kind string
+  cmd := &cobra.Command{
+    Use:   "createwallet",
+    Short: "Generate a new key and print its address",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      cfg, err := loadConfig(cmd) // find the data directory
+      if err != nil {
+        return err
+      }
+      wallets, err := wallet.LoadWallets(cfg.DataDir, []byte(passphrase)) // open the wallet file, or start a new one
+      if err != nil {
+        return err
+      }
+      address, err := wallets.CreateWalletOfKind(kind) // generate the key
+      if err != nil {
+        return err
+      }
+      if err := wallets.Save(); err != nil { // write the file back
+        return err
+      }
+      fmt.Printf("Your new address: %s\n", address)
+      return nil
+    },
+  }
+  cmd.Flags().StringVar(&passphrase, "passphrase", "", "passphrase encrypting the wallet file")
+  cmd.Flags().StringVar(&kind, "type", wallet.KeyECDSA, "kind of key, ecdsa or ed25519; an ed25519 key is random and only kept by the wallet file")
+  return cmd
+}
+
+// Create the command that sends coins from an address to another
+func sendCmd() *cobra.Command {
+  var from, to, node, passphrase string
+  var amount, fee, lockTime int
+  var mine bool
+  cmd := &cobra.Command{
+    Use:   "send",
+    Short: "Send coins, mining the transaction locally or handing it to a node",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      if !address.Validate(from) {
+        return fmt.Errorf("invalid sender address %q", from)
+      }
+      if !address.Validate(to) {
+        return fmt.Errorf("invalid recipient address %q", to)
+      }
+      if amount <= 0 {
+        return errors.New("the amount must be positive")
+      }
+      if lockTime < 0 || lockTime > math.MaxUint32 {
+        return fmt.Errorf("invalid lock time %d", lockTime)
+      }
+      cfg, err := loadConfig(cmd) // find the data directory and the first node
+      if err != nil {
+        return err
+      }
+      bc := NewBlockchain(cfg.DataDir, from) // open the chain, the node must not be running
+      defer bc.Close()
+      wallets, err := wallet.LoadWallets(cfg.DataDir, []byte(passphrase)) // the key of the sender signs the transaction
+      if err != nil {
+        return err
+      }
+      tx, err := NewUTXOTransaction(wallets, from, to, amount, fee, lockTime, &UTXOSet{bc}) // spend the outputs of the sender
+      if err != nil {
+        return err
+      }
+      if complete, err := printIfIncomplete(tx, UTXOSet{bc}); err != nil || !complete { // the other keys of a multisig may still have to sign
+        return err
+      }
+      if mine { // if the transaction is mined here
+        key, _ := wallets.Wallet(from) // on a proof of stake network the sender must be the proposer of the slot
+        block, err := bc.MineBlock([]*Transaction{NewCoinbaseTX(from, "", bc.GetBestHeight()+1, fee), tx}, key) // the sender gets the reward and its own fee back
+        if err != nil {
+          return err
+        }
+        fmt.Printf("Mined block %x\n", block.MyBlockHash)
+      } else if err := handOver(bc, cfg, node, tx); err != nil { // otherwise a node takes it
+        return err
+      }
+      fmt.Printf("Sent transaction %x\n", tx.ID)
+      return nil
+    },
+  }
+  flags := cmd.Flags()
+  flags.StringVar(&from, "from", "", "address sending the coins")
+  flags.StringVar(&to, "to", "", "address receiving the coins")
+  flags.IntVar(&amount, "amount", 0, "amount of coins to send")
+  flags.IntVar(&fee, "fee", 0, "fee left to the miner of the transaction")
+  flags.IntVar(&lockTime, "locktime", 0, "the transaction is only mined in blocks after this height, or after this unix time from 500000000 on")
+  flags.BoolVar(&mine, "mine", false, "mine the transaction in a new block instead of sending it to a node")
+  flags.StringVar(&passphrase, "passphrase", "", "passphrase of the wallet file holding the key of the sender")
+  flags.StringVar(&node, "node", "", "address of the node receiving the transaction, the first node by default")
+  cmd.MarkFlagRequired("from")
+  cmd.MarkFlagRequired("to")
+  cmd.MarkFlagRequired("amount")
+  return cmd
+}
+
+// Create the command that pays an address with the coins of every key of the wallet file, picking the outputs to spend
+// and paying the fee their size needs
+func sendToAddressCmd() *cobra.Command {
+  var to, node, passphrase string
+  var amount, feeRate int
+  cmd := &cobra.Command{
+    Use:   "sendtoaddress",
+    Short: "Pay an address with the coins of the wallet, picking the outputs and the fee, and hand the payment to a node",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      if !address.Validate(to) {
+        return fmt.Errorf("invalid recipient address %q", to)
+      }
+      if amount <= 0 {
+        return errors.New("the amount must be positive")
+      }
+      payment, err := NewTXOutput(amount, to)
+      if err != nil {
+        return err
+      }
+      cfg, err := loadConfig(cmd) // find the data directory and the first node
+      if err != nil {
+        return err
+      }
+      bc := NewBlockchain(cfg.DataDir, "") // open the chain, the node must not be running
+      defer bc.Close()
+      wallets, err := wallet.LoadWallets(cfg.DataDir, []byte(passphrase)) // the keys owning the coins
+      if err != nil {
+        return err
+      }
+      coins := (UTXOSet{bc}).FindCoins(wallets.Addresses())
+      tx, fee, err := fundTransaction(wallets, coins, payment, feeRate, 0)
+      if err != nil {
+        return err
+      }
+      if err := handOver(bc, cfg, node, tx); err != nil {
+        return err
+      }
+      fmt.Printf("Sent transaction %x paying a fee of %d\n", tx.ID, fee)
+      return nil
+    },
+  }
+  flags := cmd.Flags()
+  flags.StringVar(&to, "to", "", "address receiving the coins")
+  flags.IntVar(&amount, "amount", 0, "amount of coins to send")
+  flags.IntVar(&feeRate, "feerate", defaultFeeRate, fmt.Sprintf("fee paid per %d bytes of the transaction", feeRateUnit))
+  flags.StringVar(&passphrase, "passphrase", "", "passphrase of the wallet file holding the keys of the coins")
+  flags.StringVar(&node, "node", "", "address of the node receiving the transaction, the first node by default")
+  cmd.MarkFlagRequired("to")
+  cmd.MarkFlagRequired("amount")
+  return cmd
+}
+
+// Create the function that hands a transaction over to a node, the first node by default, which relays it to the miners
+func handOver(bc *Blockchain, cfg *config.Config, node string, tx *Transaction) error {
+  node = firstNonEmpty(node, cfg.FirstNode)
+  cfg.Listen, cfg.Connect = nil, []string{node} // a node that only sends
+  client, err := NewNode(bc, cfg)
+  if err != nil {
+    return err
+  }
+  client.sendTx(node, tx) // hand the transaction over
+  return nil
+}
+
+// Create the command that prints the balance of an address, or of every key of the wallet file, split into the coins
+// that can be spent and the coinbases still maturing
+func getBalanceCmd() *cobra.Command {
+  var addr, passphrase string
+  cmd := &cobra.Command{
+    Use:   "getbalance",
+    Short: "Print the balance of an address, or of the wallet",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      if addr != "" && !address.Validate(addr) {
+        return fmt.Errorf("invalid address %q", addr)
+      }
+      cfg, err := loadConfig(cmd) // find the data directory
+      if err != nil {
+        return err
+      }
+      addresses, name := []string{addr}, addr
+      if addr == "" { // every key of the wallet
+        wallets, err := wallet.LoadWallets(cfg.DataDir, []byte(passphrase))
+        if err != nil {
+          return err
+        }
+        addresses, name = wallets.Addresses(), "the wallet"
+      }
+      bc := NewBlockchain(cfg.DataDir, "") // open the chain
+      defer bc.Close()
+      balance := UTXOSet{bc}.WalletBalance(addresses) // the mempool of a stopped node is empty, nothing is unconfirmed
+      fmt.Printf("Balance of %s: %d\n", name, balance.confirmed)
+      if balance.immature > 0 {
+        fmt.Printf("Immature: %d\n", balance.immature)
+      }
+      return nil
+    },
+  }
+  cmd.Flags().StringVar(&addr, "address", "", "address to look up, every address of the wallet by default")
+  cmd.Flags().StringVar(&passphrase, "passphrase", "", "passphrase of the wallet file")
+  return cmd
+}
+
+// Create the command that prints every block of the main chain
+func printChainCmd() *cobra.Command {
+  return &cobra.Command{
+    Use:   "printchain",
+    Short: "Print the blocks of the main chain, last first",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      cfg, err := loadConfig(cmd) // find the data directory
+      if err != nil {
+        return err
+      }
+      bc := NewBlockchain(cfg.DataDir, "") // open the chain
+      defer bc.Close()
+      blocks := bc.MainChain()
+      for height := len(blocks) - 1; height >= 0; height-- { // walk back from the tip
+        block := blocks[height]
+        fmt.Printf("============ Block %x ============\n", block.MyBlockHash)
+        fmt.Printf("Height: %d\n", height)
+        fmt.Printf("Prev. block: %x\n", block.PreviousBlockHash)
+        fmt.Printf("Timestamp: %d\n", block.Timestamp)
+        fmt.Printf("Bits: %08x Nonce: %d Seal: %t\n", block.Bits, block.Nonce, block.VerifySeal() == nil)
+        if len(block.Proposer) > 0 { // a proof of stake block
+          fmt.Printf("Proposer: %s\n", wallet.AddressFromPubKey(block.Proposer))
+        }
+        if len(block.Commits) > 0 { // a committed BFT block
+          fmt.Printf("Commits: %d\n", len(block.Commits))
+        }
+        for _, tx := range block.Transactions { // print the transactions
+          fmt.Printf("Transaction %x: %d inputs, %d outputs\n", tx.ID, len(tx.Vin), len(tx.Vout))
+        }
+        fmt.Println()
+      }
+      return nil
+    },
+  }
+}
+
+// Create the command that rebuilds the UTXO set and the transaction indexes from the blocks
+// It repairs a data directory a crash or a disk error left corrupt: the stored blocks that cannot be read are skipped,
+// the branch with the most work left becomes the main chain, and the state is rebuilt from its blocks
+func reindexCmd() *cobra.Command {
+  var indexesOnly bool
+  cmd := &cobra.Command{
+    Use:   "reindex",
+    Short: "Rebuild the chain state, the UTXO set and the transaction index, from the stored blocks",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      cfg, err := loadConfig(cmd) // find the data directory
+      if err != nil {
+        return err
+      }
+      if indexesOnly { // the stored blocks are read again, the UTXO set stays
+        bc := NewBlockchain(cfg.DataDir, "") // open the chain
+        defer bc.Close()
+        if !bc.HasTxIndex() && !bc.HasAddrIndex() {
+          return errors.New("the chain keeps no transaction index, start the node with --txindex to build one")
+        }
+        if err := bc.ReindexTransactions(); err != nil {
+          return err
+        }
+        fmt.Printf("Done! Indexed the transactions of %d blocks.\n", bc.GetBestHeight()+1)
+        return nil
+      }
+      bc := ReindexBlockchain(cfg.DataDir) // open the chain without trusting its state
+      defer bc.Close()
+      if bc.IsPruned() { // the set cannot be rebuilt without the old blocks
+        return errors.New("the chain is pruned, the old blocks needed to rebuild the UTXO set are gone")
+      }
+      utxoSet := UTXOSet{bc}
+      utxoSet.Reindex()
+      fmt.Printf("Done! There are %d transactions in the UTXO set.\n", utxoSet.CountTransactions())
+      return nil
+    },
+  }
+  cmd.Flags().BoolVar(&indexesOnly, "indexes", false, "rebuild only the transaction and address indexes kept by the chain, which works on a pruned chain too")
+  return cmd
+}
+
+// Create the command that checks the last blocks of the main chain against the store, the undo data and the UTXO set
+func verifyChainCmd() *cobra.Command {
+  var level, blocks int
+  cmd := &cobra.Command{
+    Use:   "verifychain",
+    Short: "Check the last blocks of the chain and the state built from them, reindex repairs what fails",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      cfg, err := loadConfig(cmd) // find the data directory
+      if err != nil {
+        return err
+      }
+      bc := NewBlockchain(cfg.DataDir, "") // open the chain
+      defer bc.Close()
+      if err := bc.VerifyChain(level, blocks); err != nil {
+        return fmt.Errorf("the chain is corrupt, run reindex: %w", err)
+      }
+      fmt.Println("Done! The chain is valid.")
+      return nil
+    },
+  }
+  cmd.Flags().IntVar(&level, "level", defaultVerifyLevel, "thoroughness of the checks: 0 reads the blocks back, 1 validates them, 2 checks their undo data, 3 disconnects and connects them again against the UTXO set")
+  cmd.Flags().IntVar(&blocks, "blocks", defaultVerifyBlocks, "number of blocks checked from the tip, 0 for the whole chain")
+  return cmd
+}
+
+// Create the command that builds the genesis block of a private network and writes its parameters file
+func genesisCmd() *cobra.Command {
+  params := chaincfg.Params{}
+  var magic, bits, out string
+  var premine, validators []string
+  cmd := &cobra.Command{
+    Use:   "genesis",
+    Short: "Build the genesis block of a private network and write the parameters file its nodes load with --params",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      if magic == "" { // derived from the name, so two private networks rarely share it
+        hash := sha256.Sum256([]byte(params.Name))
+        params.Net = binary.BigEndian.Uint32(hash[:4])
+      } else {
+        n, err := strconv.ParseUint(magic, 16, 32)
+        if err != nil {
+          return fmt.Errorf("invalid magic bytes %q", magic)
+        }
+        params.Net = uint32(n)
+      }
+      n, err := strconv.ParseUint(bits, 16, 32)
+      if err != nil {
+        return fmt.Errorf("invalid target %q", bits)
+      }
+      params.PowLimitBits = uint32(n)
+      if params.GenesisMessage == "" {
+        params.GenesisMessage = params.Name + " Genesis Block"
+      }
+      for _, text := range premine { // each output is written address:amount
+        to, amount, ok := strings.Cut(text, ":")
+        value, err := strconv.Atoi(amount)
+        if !ok || err != nil || value <= 0 {
+          return fmt.Errorf("invalid premine output %q, expected address:amount", text)
+        }
+        if !address.Validate(to) {
+          return fmt.Errorf("invalid premine address %q", to)
+        }
+        params.GenesisOutputs = append(params.GenesisOutputs, chaincfg.GenesisOutput{Address: to, Value: value})
+      }
+      for _, text := range validators {
+        validator, err := chaincfg.ParseValidator(text)
+        if err != nil {
+          return err
+        }
+        params.Validators = append(params.Validators, validator)
+      }
+      if err := params.Validate(); err != nil {
+        return err
+      }
+      activeNet = &params // the genesis block is built on the new network
+      genesis, err := BuildGenesisBlock("")
+      if err != nil {
+        return err
+      }
+      params.GenesisHash = fmt.Sprintf("%x", genesis.MyBlockHash)
+      out = firstNonEmpty(out, params.Name+".yaml")
+      if err := params.WriteFile(out); err != nil {
+        return err
+      }
+      fmt.Printf("Genesis block %s\n", params.GenesisHash)
+      fmt.Printf("Wrote the parameters of %s to %s, start its nodes with --params %s\n", params.Name, out, out)
+      return nil
+    },
+  }
+  defaults := chaincfg.MainNetParams // the rules of the main network unless changed
+  flags := cmd.Flags()
+  flags.StringVar(&params.Name, "name", "", "name of the network, recorded in the data directories of its nodes")
+  flags.StringVar(&magic, "magic", "", "hex magic bytes starting every message, derived from the name by default")
+  flags.StringVar(&params.DefaultPort, "port", "33000", "port of the addresses given without one")
+  flags.Int64Var(&params.GenesisTime, "time", time.Now().Unix(), "unix timestamp of the genesis block")
+  flags.StringVar(&params.GenesisMessage, "message", "", "data of the coinbase of the genesis block, the name followed by Genesis Block by default")
+  flags.StringSliceVar(&premine, "premine", nil, "output of the genesis block written address:amount")
+  flags.StringVar(&bits, "bits", fmt.Sprintf("%08x", defaults.PowLimitBits), "hex compact target of the genesis block, also the easiest target")
+  flags.IntVar(&params.RetargetInterval, "retarget", defaults.RetargetInterval, "number of blocks between two difficulty adjustments, 0 to never adjust")
+  flags.DurationVar(&params.TargetBlockTime, "blocktime", defaults.TargetBlockTime, "time a block should take to mine on average")
+  flags.IntVar(&params.InitialSubsidy, "subsidy", defaults.InitialSubsidy, "coins created by the coinbase of the first blocks")
+  flags.IntVar(&params.SubsidyHalvingInterval, "halving", defaults.SubsidyHalvingInterval, "number of blocks between two halvings of the subsidy, 0 to never halve")
+  flags.IntVar(&params.CoinbaseMaturity, "maturity", defaults.CoinbaseMaturity, "number of blocks built on a coinbase before its outputs can be spent")
+  flags.DurationVar(&params.MaxTimeDrift, "drift", defaults.MaxTimeDrift, "how far ahead of the clock of a node a block timestamp may be")
+  flags.IntVar(&params.MaxBlockSize, "maxblocksize", defaults.MaxBlockSize, "largest serialized block in bytes")
+  flags.IntVar(&params.DustThreshold, "dust", defaults.DustThreshold, "smallest value of an output the mempool accepts, 0 accepts any")
+  flags.StringVar(&params.Consensus, "consensus", chaincfg.ProofOfWork, "consensus engine, pow, pos or bft")
+  flags.StringVar(&params.BlockHash, "blockhash", "", "hash of the headers the work is done on, sha256, sha256d, sha3 or blake2b, sha256 by default")
+  flags.StringSliceVar(&validators, "validator", nil, "validator written address:stake of a proof of stake network, or address of a BFT one")
+  flags.IntVar(&params.EpochLength, "epoch", 0, "number of blocks of an epoch, after which the bonded validators take over, 0 to keep the given validators")
+  flags.IntVar(&params.MinStake, "minstake", 0, "bonded coins an address needs to become a validator of a rotating network")
+  flags.StringVar(&out, "out", "", "parameters file to write, the name followed by .yaml by default")
+  cmd.MarkFlagRequired("name")
+  return cmd
+}
@@ -0,0 +1,262 @@
+// Package codec encodes structs using the protocol buffers wire format.
+// Every encoded field carries a `proto:"N"` struct tag giving its field number, so the
+// schema is explicit, fields can be added without breaking older peers (unknown fields
+// are skipped) and the messages can be read by any protobuf implementation.
+package codec
+
+import (
+  "encoding/binary" // for the varint and fixed size encodings
+  "errors"          // for the decoding errors
+  "fmt"             // to format the errors
+  "reflect"         // to walk the struct fields
+  "strconv"         // to parse the field numbers
+)
+
+// Define the protobuf wire types used by the codec
+const (
+  wireVarint  = 0 // integers and booleans
+  wireFixed64 = 1 // only skipped, never produced
+  wireBytes   = 2 // strings, byte slices and nested messages
+  wireFixed32 = 5 // only skipped, never produced
+)
+
+// Define an error returned for truncated or corrupted input
+var ErrMalformed = errors.New("codec: malformed message")
+
+// Define a function to encode a struct (or a pointer to one)
+func Marshal(v interface{}) ([]byte, error) {
+  value := reflect.Indirect(reflect.ValueOf(v)) // accept both values and pointers
+  if value.Kind() != reflect.Struct {
+    return nil, fmt.Errorf("codec: cannot marshal %s", value.Type())
+  }
+  return appendMessage(nil, value) // encode the fields
+}
+
+// Define a function to decode a message into a pointer to a struct
+func Unmarshal(data []byte, v interface{}) error {
+  value := reflect.ValueOf(v) // the target must be a pointer
+  if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+    return fmt.Errorf("codec: cannot unmarshal into %T", v)
+  }
+  return decodeMessage(data, value.Elem()) // decode the fields
+}
+
+// Define a function to read the field number of a struct field, 0 when the field is not encoded
+func fieldNumber(field reflect.StructField) int {
+  number, err := strconv.Atoi(field.Tag.Get("proto")) // parse the tag
+  if err != nil || !field.IsExported() {
+    return 0 // untagged fields are not part of the schema
+  }
+  return number
+}
+
+// Define a function to append the encoded fields of a struct
+func appendMessage(buf []byte, value reflect.Value) ([]byte, error) {
+  for i := 0; i < value.NumField(); i++ { // iterate over the fields
+    number := fieldNumber(value.Type().Field(i)) // get the field number
+    if number == 0 {
+      continue // the field is not part of the schema
+    }
+    var err error
+    buf, err = appendField(buf, number, value.Field(i)) // encode the field
+    if err != nil {
+      return nil, err
+    }
+  }
+  return buf, nil
+}
+
+// Define a function to append a tag (field number and wire type)
+func appendTag(buf []byte, number, wireType int) []byte {
+  return binary.AppendUvarint(buf, uint64(number)<<3|uint64(wireType))
+}
+
+// Define a function to append a length delimited value
+func appendBytes(buf []byte, number int, data []byte) []byte {
+  buf = appendTag(buf, number, wireBytes) // write the tag
+  buf = binary.AppendUvarint(buf, uint64(len(data))) // write the length
+  return append(buf, data...) // write the data
+}
+
+// Define a function to append one field, zero values are omitted like in proto3
+func appendField(buf []byte, number int, field reflect.Value) ([]byte, error) {
+  switch field.Kind() {
+  case reflect.Bool:
+    if field.Bool() {
+      buf = appendTag(buf, number, wireVarint)
+      buf = append(buf, 1)
+    }
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    if field.Int() != 0 { // signed integers are zigzag encoded like sint64
+      buf = appendTag(buf, number, wireVarint)
+      buf = binary.AppendVarint(buf, field.Int())
+    }
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    if field.Uint() != 0 {
+      buf = appendTag(buf, number, wireVarint)
+      buf = binary.AppendUvarint(buf, field.Uint())
+    }
+  case reflect.String:
+    if field.Len() > 0 {
+      buf = appendBytes(buf, number, []byte(field.String()))
+    }
+  case reflect.Array:
+    if field.Type().Elem().Kind() != reflect.Uint8 {
+      return nil, fmt.Errorf("codec: unsupported array %s", field.Type())
+    }
+    data := make([]byte, field.Len()) // fixed size byte arrays are sent as bytes
+    reflect.Copy(reflect.ValueOf(data), field)
+    buf = appendBytes(buf, number, data)
+  case reflect.Struct:
+    data, err := appendMessage(nil, field) // nested messages are length delimited
+    if err != nil {
+      return nil, err
+    }
+    buf = appendBytes(buf, number, data)
+  case reflect.Ptr:
+    if !field.IsNil() {
+      return appendField(buf, number, field.Elem())
+    }
+  case reflect.Slice:
+    if field.Type().Elem().Kind() == reflect.Uint8 {
+      if field.Len() > 0 {
+        buf = appendBytes(buf, number, field.Bytes())
+      }
+      break
+    }
+    for i := 0; i < field.Len(); i++ { // other slices are repeated fields
+      element := field.Index(i)
+      if element.Kind() == reflect.Slice && element.Len() == 0 {
+        buf = appendBytes(buf, number, nil) // keep empty elements so the indexes stay aligned
+        continue
+      }
+      var err error
+      if buf, err = appendElement(buf, number, element); err != nil {
+        return nil, err
+      }
+    }
+  default:
+    return nil, fmt.Errorf("codec: unsupported field type %s", field.Type())
+  }
+  return buf, nil
+}
+
+// Define a function to append one element of a repeated field, zero values included
+func appendElement(buf []byte, number int, element reflect.Value) ([]byte, error) {
+  switch element.Kind() {
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    buf = appendTag(buf, number, wireVarint)
+    return binary.AppendVarint(buf, element.Int()), nil
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    buf = appendTag(buf, number, wireVarint)
+    return binary.AppendUvarint(buf, element.Uint()), nil
+  case reflect.String:
+    return appendBytes(buf, number, []byte(element.String())), nil
+  case reflect.Ptr:
+    if element.IsNil() {
+      return appendBytes(buf, number, nil), nil // repeated messages cannot be absent, send an empty one
+    }
+    return appendElement(buf, number, element.Elem())
+  case reflect.Struct:
+    data, err := appendMessage(nil, element)
+    if err != nil {
+      return nil, err
+    }
+    return appendBytes(buf, number, data), nil
+  }
+  return appendField(buf, number, element) // byte slices and arrays
+}
+
+// Define a function to decode the fields of a message into a struct
+func decodeMessage(data []byte, value reflect.Value) error {
+  fields := map[int]reflect.Value{} // index the fields by number
+  for i := 0; i < value.NumField(); i++ {
+    if number := fieldNumber(value.Type().Field(i)); number != 0 {
+      fields[number] = value.Field(i)
+    }
+  }
+  for len(data) > 0 { // read the fields one by one
+    tag, n := binary.Uvarint(data) // read the tag
+    if n <= 0 {
+      return ErrMalformed
+    }
+    data = data[n:]
+    number, wireType := int(tag>>3), int(tag&7) // split the tag
+    var raw uint64    // the value of a varint field
+    var chunk []byte  // the value of a length delimited field
+    switch wireType {
+    case wireVarint:
+      raw, n = binary.Uvarint(data)
+      if n <= 0 {
+        return ErrMalformed
+      }
+      data = data[n:]
+    case wireBytes:
+      length, n := binary.Uvarint(data)
+      if n <= 0 || uint64(len(data)-n) < length {
+        return ErrMalformed
+      }
+      chunk, data = data[n:n+int(length)], data[n+int(length):]
+    case wireFixed64, wireFixed32:
+      size := 8 // skip fixed size values, the codec never writes them
+      if wireType == wireFixed32 {
+        size = 4
+      }
+      if len(data) < size {
+        return ErrMalformed
+      }
+      data = data[size:]
+      continue
+    default:
+      return ErrMalformed
+    }
+    field, ok := fields[number]
+    if !ok {
+      continue // unknown fields come from newer peers and are skipped
+    }
+    if err := decodeField(field, wireType, raw, chunk); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// Define a function to decode one value into a field
+func decodeField(field reflect.Value, wireType int, raw uint64, chunk []byte) error {
+  kind := field.Kind()
+  if kind == reflect.Slice && field.Type().Elem().Kind() != reflect.Uint8 { // repeated field, append one element
+    element := reflect.New(field.Type().Elem()).Elem()
+    if err := decodeField(element, wireType, raw, chunk); err != nil {
+      return err
+    }
+    field.Set(reflect.Append(field, element))
+    return nil
+  }
+  switch {
+  case wireType == wireVarint && kind == reflect.Bool:
+    field.SetBool(raw != 0)
+  case wireType == wireVarint && kind >= reflect.Int && kind <= reflect.Int64:
+    field.SetInt(int64(raw>>1) ^ -int64(raw&1)) // undo the zigzag encoding
+  case wireType == wireVarint && kind >= reflect.Uint && kind <= reflect.Uint64:
+    field.SetUint(raw)
+  case wireType == wireBytes && kind == reflect.String:
+    field.SetString(string(chunk))
+  case wireType == wireBytes && kind == reflect.Slice:
+    field.SetBytes(append([]byte{}, chunk...)) // copy, the input buffer belongs to the caller
+  case wireType == wireBytes && kind == reflect.Array && field.Type().Elem().Kind() == reflect.Uint8:
+    if len(chunk) != field.Len() {
+      return ErrMalformed
+    }
+    reflect.Copy(field, reflect.ValueOf(chunk))
+  case wireType == wireBytes && kind == reflect.Struct:
+    return decodeMessage(chunk, field)
+  case wireType == wireBytes && kind == reflect.Ptr && field.Type().Elem().Kind() == reflect.Struct:
+    if field.IsNil() {
+      field.Set(reflect.New(field.Type().Elem()))
+    }
+    return decodeMessage(chunk, field.Elem())
+  default:
+    return ErrMalformed // the wire type does not match the field
+  }
+  return nil
+}
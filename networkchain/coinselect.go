@@ -0,0 +1,163 @@
+package main
+
+import (
+  "encoding/hex"        // to log the ID of the payments
+  "errors"              // for the error of a wallet short of coins
+  "fmt"                 // to describe the shortfall
+  "networkchain/wallet" // the keys signing the inputs
+  "math"                // to round the minimum fee rate of the mempool up
+  "sort"                // to order the coins by value
+)
+
+// Define some constants of the fees paid by the wallet
+const (
+  feeRateUnit     = 1000 // the fee rates are in coins per this many bytes
+  defaultFeeRate  = 1    // the fee rate of a payment when none is given
+  maxFundAttempts = 10   // how many times the coins are picked again for the fee grown with the transaction
+)
+
+// Define the errors of the payments of the wallet
+var (
+  errInsufficientFunds = errors.New("insufficient funds")              // the spendable coins of the wallet cannot cover the payment
+  errPaymentRejected   = errors.New("the mempool refused the payment") // the payment was built but not accepted
+)
+
+// Define a struct for an unspent output the wallet can spend
+type coin struct {
+  txid   []byte   // the ID of the transaction holding it
+  vout   int      // its index
+  output TXOutput // the output itself
+}
+
+// Create a method that finds the confirmed unspent outputs of some addresses the next block can spend, leaving out the
+// ones a mempool transaction already spends
+func (u UTXOSet) FindCoins(addresses []string) []coin {
+  var coins []coin
+  for _, c := range u.ListCoins(addresses) {
+    if c.confirmations > 0 && c.mature {
+      coins = append(coins, c.coin)
+    }
+  }
+  return coins
+}
+
+// Define a function to compute the fee of a transaction of a size at a fee rate, rounded up so every transaction pays
+// something at a positive rate
+func feeFor(size, feeRate int) int {
+  return (size*feeRate + feeRateUnit - 1) / feeRateUnit
+}
+
+// Define a function to pick the coins covering a target, returning them with their value, or false if all of them fall
+// short; the smallest coin covering the target alone is taken when there is one, so the payment has one input and the
+// least change, otherwise the largest coins are taken until they cover it, so the inputs and the fee stay few
+func selectCoins(coins []coin, target int) ([]coin, int, bool) {
+  sorted := append([]coin{}, coins...)
+  sort.Slice(sorted, func(i, j int) bool { return sorted[i].output.Value < sorted[j].output.Value })
+  for _, c := range sorted {
+    if c.output.Value >= target {
+      return []coin{c}, c.output.Value, true
+    }
+  }
+  var selected []coin
+  total := 0
+  for i := len(sorted) - 1; i >= 0 && total < target; i-- {
+    selected = append(selected, sorted[i])
+    total += sorted[i].output.Value
+  }
+  return selected, total, total >= target
+}
+
+// Create a function that makes a transaction paying an output with coins of the wallets, at a fee rate in coins per
+// feeRateUnit bytes, sending the change back to the owner of the first coin spent, and returns it with its fee
+func fundTransaction(wallets *wallet.Wallets, coins []coin, payment TXOutput, feeRate, lockTime int) (*Transaction, int, error) {
+  tx, _, fee, err := fundWith(coins, payment, feeRate, lockTime, func(tx *Transaction, prevOuts []TXOutput) error {
+    return tx.Sign(wallets, prevOuts)
+  })
+  return tx, fee, err
+}
+
+// Create a function that picks the coins of a payment like fundTransaction, with a function filling the unlocking
+// scripts, and returns the transaction with the outputs it spends and its fee
+// The fee depends on the size, which depends on the coins picked, so the coins are picked again until the signed
+// transaction pays the fee its size needs
+func fundWith(coins []coin, payment TXOutput, feeRate, lockTime int, sign func(tx *Transaction, prevOuts []TXOutput) error) (*Transaction, []TXOutput, int, error) {
+  if feeRate < 0 {
+    return nil, nil, 0, errors.New("the fee rate cannot be negative")
+  }
+  if payment.IsDust() {
+    return nil, nil, 0, fmt.Errorf("the payment of %d is below the dust threshold %d", payment.Value, activeNet.DustThreshold)
+  }
+  fee := 0 // the fee of the last transaction built
+  for attempt := 0; attempt < maxFundAttempts; attempt++ {
+    selected, total, ok := selectCoins(coins, payment.Value+fee)
+    if !ok {
+      return nil, nil, 0, fmt.Errorf("%w: the wallet can spend %d, the payment needs %d", errInsufficientFunds, total, payment.Value+fee)
+    }
+    var inputs []TXInput    // build the inputs spending the coins
+    var prevOuts []TXOutput // and remember the outputs they spend for the signatures
+    for _, c := range selected {
+      inputs = append(inputs, TXInput{Txid: c.txid, Vout: c.vout})
+      prevOuts = append(prevOuts, c.output)
+    }
+    outputs := []TXOutput{payment}
+    change := total - payment.Value - fee
+    if change > 0 {
+      changeOut, err := NewTXOutput(change, selected[0].output.Address())
+      if err != nil {
+        return nil, nil, 0, err
+      }
+      if changeOut.IsDust() { // the mempool would refuse it, the miner gets it instead
+        change = 0
+      } else {
+        outputs = append(outputs, changeOut)
+      }
+    }
+    tx := &Transaction{nil, inputs, outputs, lockTime}
+    if err := sign(tx, prevOuts); err != nil { // the size counts the signatures
+      return nil, nil, 0, err
+    }
+    needed := feeFor(len(tx.Serialize()), feeRate)
+    if fee >= needed {
+      return tx, prevOuts, total - payment.Value - change, nil // the fee with the change folded into it
+    }
+    fee = needed // build it again paying for its size
+  }
+  return nil, nil, 0, fmt.Errorf("no coins of the wallet pay the fee of the payment after %d attempts", maxFundAttempts)
+}
+
+// Define a method to get the fee rate for a payment to be mined within a number of blocks, the estimate of the chain or
+// the default while there is none, at least the minimum of the mempool so the payment is relayed
+func (n *Node) feeRate(target int) int {
+  feeRate, ok := n.bc.EstimateFee(target)
+  if !ok || feeRate <= 0 {
+    feeRate = defaultFeeRate
+  }
+  if minimum := int(math.Ceil(n.bc.Mempool.MinFeeRate() * feeRateUnit)); feeRate < minimum {
+    feeRate = minimum
+  }
+  return feeRate
+}
+
+// Define a method to pay an amount to an address with the coins of the wallet, at a fee rate, then add the transaction
+// to the mempool and announce it
+func (w *nodeWallet) sendToAddress(to string, amount, feeRate int) (*Transaction, error) {
+  if amount <= 0 {
+    return nil, errors.New("the amount must be positive")
+  }
+  payment, err := NewTXOutput(amount, to)
+  if err != nil {
+    return nil, err
+  }
+  w.mu.Lock() // the coins picked are spent in the mempool before the next payment picks its own
+  defer w.mu.Unlock() // unlock the wallet when done
+  coins := (UTXOSet{w.n.bc}).FindCoins(w.keys.Addresses())
+  tx, fee, err := fundTransaction(w.keys, coins, payment, feeRate, 0)
+  if err != nil {
+    return nil, err
+  }
+  if _, err := w.n.submitTransaction(tx.Serialize()); err != nil { // check, add and announce it
+    return nil, fmt.Errorf("%w: %s", errPaymentRejected, err)
+  }
+  netLog.Info("Sent a payment of the wallet", "wallet", w.name, "txid", hex.EncodeToString(tx.ID), "to", to, "amount", amount, "fee", fee)
+  return tx, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+// The number of bytes of the hash kept in a short transaction ID
+const shortIDLength = 6
+
+// Define a struct for a compact block waiting for the transactions that were not in the mempool
+type partialBlock struct {
+  block   *Block // the block, with a nil transaction at each missing position
+  missing []int  // the positions of the missing transactions, in block order
+}
+
+// Define a function to compute the short ID of a transaction in a compact block:
+// the first bytes of SHA256(nonce || block hash || transaction ID), the nonce keeps collisions from being planned
+func shortTxID(nonce uint64, blockHash, txid []byte) uint64 {
+  hasher := sha256.New() // create the hasher
+  binary.Write(hasher, binary.LittleEndian, nonce) // writing to a hash never fails
+  hasher.Write(blockHash)
+  hasher.Write(txid)
+  var id [8]byte // the short ID, zero padded to a number
+  copy(id[:], hasher.Sum(nil)[:shortIDLength])
+  return binary.LittleEndian.Uint64(id[:])
+}
+
+// Define a function to describe a block as a compact block: its header, the coinbase sent whole and the short IDs of the other transactions
+func newCompactBlock(from string, block *Block) CmpctBlock {
+  compact := CmpctBlock{AddrFrom: from, Header: block.Header().Serialize(), Nonce: rand.Uint64()} // create the message
+  for i, tx := range block.Transactions { // iterate over the transactions
+    if i == 0 { // the peer cannot have the coinbase
+      compact.Prefilled = append(compact.Prefilled, PrefilledTx{i, tx.Serialize()})
+      continue
+    }
+    compact.ShortIDs = append(compact.ShortIDs, shortTxID(compact.Nonce, block.MyBlockHash, tx.ID))
+  }
+  return compact // return the message
+}
+
+// Define a method to send a cmpctblock command to a node
+func (n *Node) sendCmpctBlock(address string, block *Block) {
+  payload := encodePayload(newCompactBlock(n.address, block)) // encode the cmpctblock struct into a payload
+  message := encodeMessage(cmdCmpctBlock, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
+}
+
+// Define a method to handle a cmpctblock command from a node
+// The block is rebuilt from the mempool; the transactions we do not have are requested with a getblocktxn command
+func (n *Node) handleCmpctBlock(from sender, request []byte) {
+  var payload CmpctBlock // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
+  peerAddress := payload.AddrFrom // get the peer address
+  partial, err := n.rebuildCompactBlock(payload) // fill the block with the transactions we have
+  if err != nil { // if the compact block is invalid
+    n.banPeer(from, err) // stop talking to the peer that sent it
+    return
+  }
+  if partial == nil { // if we already have the block
+    return
+  }
+  if len(partial.missing) == 0 { // if the mempool held every transaction
+    netLog.Debug("Rebuilt compact block from the mempool", "peer", peerAddress, "hash", partial.block.MyBlockHash, "txs", len(partial.block.Transactions))
+    n.completeCompactBlock(from, peerAddress, partial.block)
+    return
+  }
+  netLog.Debug("Requesting the missing transactions of a compact block", "peer", peerAddress, "hash", partial.block.MyBlockHash, "missing", len(partial.missing))
+  n.mu.Lock() // lock the peer state
+  n.compactBlocks[peerAddress] = partial // wait for the transactions
+  n.mu.Unlock() // unlock it
+  n.sendGetBlockTxn(peerAddress, partial.block.MyBlockHash, partial.missing) // request them
+}
+
+// Define a method to rebuild the block of a compact block with the prefilled transactions and the mempool, nil if we have the block
+func (n *Node) rebuildCompactBlock(compact CmpctBlock) (*partialBlock, error) {
+  block, err := decodeBlock(compact.Header) // deserialize the header
+  if err != nil {
+    return nil, err
+  }
+  if _, _, known := n.bc.GetBlock(block.MyBlockHash); known { // if the block reached us another way
+    return nil, nil
+  }
+  if err := block.VerifySeal(); err != nil { // do not spend a round trip on a header without work
+    return nil, err
+  }
+  count := len(compact.ShortIDs) + len(compact.Prefilled) // the number of transactions of the block
+  block.Transactions = make([]*Transaction, count)
+  for i, prefilled := range compact.Prefilled { // place the transactions sent whole
+    if prefilled.Index < 0 || prefilled.Index >= count || (i > 0 && prefilled.Index <= compact.Prefilled[i-1].Index) {
+      return nil, fmt.Errorf("compact block %x has a prefilled transaction at a bad position %d", block.MyBlockHash, prefilled.Index)
+    }
+    if block.Transactions[prefilled.Index], err = decodeTransaction(prefilled.Transaction); err != nil {
+      return nil, err
+    }
+  }
+  mempoolTxs := map[uint64]*Transaction{} // the mempool transactions by short ID
+  collisions := map[uint64]bool{} // the short IDs shared by several mempool transactions
+  for _, entry := range n.bc.Mempool.Entries() {
+    tx := entry.Tx.(*Transaction)
+    id := shortTxID(compact.Nonce, block.MyBlockHash, tx.ID)
+    if _, ok := mempoolTxs[id]; ok {
+      collisions[id] = true
+    }
+    mempoolTxs[id] = tx
+  }
+  partial := &partialBlock{block: block}
+  next := 0 // the next short ID to place
+  for i := range block.Transactions { // fill the other positions in order
+    if block.Transactions[i] != nil {
+      continue
+    }
+    id := compact.ShortIDs[next]
+    next++
+    if tx, ok := mempoolTxs[id]; ok && !collisions[id] {
+      block.Transactions[i] = tx
+    } else { // ask the peer for the transactions we do not have or cannot tell apart
+      partial.missing = append(partial.missing, i)
+    }
+  }
+  return partial, nil
+}
+
+// Define a method to add a rebuilt compact block to the chain
+// A short ID may match the wrong mempool transaction, the merkle root then differs and the whole block is requested
+func (n *Node) completeCompactBlock(from sender, peerAddress string, block *Block) {
+  if !bytes.Equal(block.MerkleRoot, block.HashTransactions()) {
+    netLog.Info("Compact block does not match its merkle root, requesting the whole block", "peer", peerAddress, "hash", block.MyBlockHash)
+    n.sendGetData(peerAddress, "block", block.MyBlockHash)
+    return
+  }
+  n.processBlock(from, peerAddress, block) // add it to the chain
+}
+
+// Define a method to send a getblocktxn command to a node
+func (n *Node) sendGetBlockTxn(address string, blockHash []byte, indexes []int) {
+  payload := encodePayload(GetBlockTxn{n.address, blockHash, indexes}) // encode the getblocktxn struct into a payload
+  message := encodeMessage(cmdGetBlockTxn, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
+}
+
+// Define a method to handle a getblocktxn command from a node
+func (n *Node) handleGetBlockTxn(from sender, request []byte) {
+  var payload GetBlockTxn // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
+  peerAddress := payload.AddrFrom // get the peer address
+  block, _, ok := n.bc.GetBlock(payload.BlockHash) // look the block up
+  if !ok || block.Pruned() { // if we do not have it
+    return
+  }
+  response := BlockTxn{AddrFrom: n.address, BlockHash: block.MyBlockHash} // create the message
+  for _, index := range payload.Indexes { // collect the requested transactions
+    if index < 0 || index >= len(block.Transactions) {
+      n.misbehaving(from, malformedScore, fmt.Errorf("getblocktxn asks for transaction %d of a block of %d", index, len(block.Transactions)))
+      return
+    }
+    response.Transactions = append(response.Transactions, block.Transactions[index].Serialize())
+  }
+  n.sendBlockTxn(peerAddress, response) // send them
+}
+
+// Define a method to send a blocktxn command to a node
+func (n *Node) sendBlockTxn(address string, response BlockTxn) {
+  payload := encodePayload(response) // encode the blocktxn struct into a payload
+  message := encodeMessage(cmdBlockTxn, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
+}
+
+// Define a method to handle a blocktxn command from a node, completing the compact block waiting for these transactions
+func (n *Node) handleBlockTxn(from sender, request []byte) {
+  var payload BlockTxn // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
+  peerAddress := payload.AddrFrom // get the peer address
+  n.mu.Lock() // lock the peer state
+  partial, ok := n.compactBlocks[peerAddress] // get the block waiting for the transactions
+  if ok && bytes.Equal(partial.block.MyBlockHash, payload.BlockHash) { // the answer is for this block
+    delete(n.compactBlocks, peerAddress) // it is no longer waiting
+  }
+  n.mu.Unlock() // unlock it
+  if !ok || !bytes.Equal(partial.block.MyBlockHash, payload.BlockHash) { // we did not ask for them
+    return
+  }
+  if len(payload.Transactions) != len(partial.missing) {
+    n.banPeer(from, errors.New("blocktxn does not hold the requested transactions"))
+    return
+  }
+  for i, raw := range payload.Transactions { // fill the missing positions
+    tx, err := decodeTransaction(raw)
+    if err != nil {
+      n.banPeer(from, err)
+      return
+    }
+    partial.block.Transactions[partial.missing[i]] = tx
+  }
+  netLog.Debug("Completed compact block", "peer", peerAddress, "hash", partial.block.MyBlockHash, "received", len(partial.missing))
+  n.completeCompactBlock(from, peerAddress, partial.block)
+}
@@ -0,0 +1,373 @@
+// Package config holds the settings of a node.
+// The settings come from, in increasing priority: the defaults, a YAML file, environment
+// variables named NETWORKCHAIN_<KEY> and command line flags named like the keys.
+package config
+
+import (
+  "bytes"                 // to decode the file
+  "errors"                // for the errors of the settings
+  "fmt"                   // to format the errors
+  "io"                    // for the end of an empty file
+  "networkchain/chaincfg" // the known networks
+  "networkchain/logger"   // to check the log levels
+  "networkchain/noise"    // to check the node IDs
+  "networkchain/script"   // the default size of the signature cache
+  "net"                   // to build the default addresses
+  "net/url"               // to check the webhooks
+  "os"                    // to read the file and the environment
+  "path/filepath"         // to find the default file in the data directory
+  "reflect"               // to set the settings by key
+  "sort"                  // to order the checkpoints
+  "strconv"               // to parse the numbers and booleans
+  "strings"               // to split the lists
+  "time"                  // for the durations
+
+  "gopkg.in/yaml.v3" // the format of the file
+)
+
+// Define the prefix of the environment variables
+const EnvPrefix = "NETWORKCHAIN_"
+
+// The fewest recent blocks a pruned node keeps, the deepest reorganization it can follow and the blocks it still serves
+const MinPrune = 288
+
+// Define the name of the file read from the data directory when no file is given
+const DefaultFile = "networkchain.yaml"
+
+// Define a struct for the settings of a node, the yaml tags are the keys of the file, the variables and the flags
+type Config struct {
+  DataDir           string        `yaml:"datadir"`           // the directory holding the chain and the wallets
+  Network           string        `yaml:"network"`           // the network the node runs on: mainnet, testnet or regtest
+  Params            string        `yaml:"params"`            // the parameters file of a private network, replacing network if set
+  Listen            []string      `yaml:"listen"`            // the addresses the node listens on, IPv4 or IPv6 like [::1]:port, localhost and the port of the network if empty
+  ExternalAddr      string        `yaml:"externaladdr"`      // the address advertised to peers instead of the first listening one, like the .onion address of a Tor hidden service
+  Proxy             string        `yaml:"proxy"`             // the SOCKS5 proxy written [user:password@]host:port the connections to peers go through, like Tor at localhost:9050, direct if empty
+  FirstNode         string        `yaml:"firstnode"`         // the node every node knows, relaying transactions to the others, localhost and the port of the network if empty
+  DNSSeeds          []string      `yaml:"dnsseed"`           // host names resolving to the addresses of long running nodes
+  AddNodes          []string      `yaml:"addnode"`           // addresses to connect to in addition to the discovered ones
+  Connect           []string      `yaml:"connect"`           // if set, the only addresses the node talks to
+  TargetOutbound    int           `yaml:"targetoutbound"`    // the number of peers the node dials and keeps, the first node and the added nodes among them
+  MaxInbound        int           `yaml:"maxinbound"`        // the most peers dialing the node it accepts, a new one evicting one of them when it is full
+  MaxOutbound       int           `yaml:"maxoutbound"`       // the most peers the node dials, at least targetoutbound
+  Miner             string        `yaml:"miner"`             // the address receiving the mining rewards, the node does not mine without it
+  Passphrase        string        `yaml:"passphrase"`        // the passphrase of the wallet file holding the keys of the node, the miner address among them on a proof of stake or BFT network
+  Wallets           []string      `yaml:"wallet"`            // the named wallets loaded at startup besides the one of the data directory, their keys locked if encrypted
+  MinTxs            int           `yaml:"mintxs"`            // the number of mempool transactions that triggers mining a block
+  MinerThreads      int           `yaml:"minerthreads"`      // the number of goroutines searching the nonces of a proof of work miner
+  TLS               bool          `yaml:"tls"`               // whether the connections with peers are encrypted
+  TLSCert           string        `yaml:"tlscert"`           // the PEM certificate of the node, generated if empty
+  TLSKey            string        `yaml:"tlskey"`            // the PEM private key of the certificate
+  TLSCA             string        `yaml:"tlsca"`             // the PEM certificates peers must be signed with
+  TLSRequire        bool          `yaml:"tlsrequire"`        // whether peers without TLS are refused
+  Noise             bool          `yaml:"noise"`             // whether the connections with peers are encrypted with a handshake proving the identity key of each side
+  NoiseRequire      bool          `yaml:"noiserequire"`      // whether peers without the Noise handshake are refused
+  Whitelist         []string      `yaml:"whitelist"`         // the node IDs of the peers never banned nor rate limited, proven by the Noise handshake
+  RPCAddr           string        `yaml:"rpcaddr"`           // the address serving JSON-RPC, REST and WebSocket requests, on localhost if the host is empty, disabled if empty
+  RPCUser           string        `yaml:"rpcuser"`           // the user of the HTTP basic authentication of the RPC requests, set with rpcpassword
+  RPCPassword       string        `yaml:"rpcpassword"`       // the password of the RPC requests, a random one is written to the .cookie file of the data directory if empty
+  GRPCAddr          string        `yaml:"grpcaddr"`          // the address serving gRPC requests, disabled if empty
+  StratumAddr       string        `yaml:"stratumaddr"`       // the address serving the stratum mining protocol to external miners, disabled if empty
+  StratumDifficulty int           `yaml:"stratumdifficulty"` // the difficulty of the shares of the external miners, the easiest target divided by it
+  ZMQAddr           string        `yaml:"zmqaddr"`           // the address publishing the blocks and transactions to ZMQ subscribers, disabled if empty
+  Webhooks          []string      `yaml:"webhook"`           // the HTTP URLs the new blocks, the reorganizations and the activity of the watched addresses are posted to
+  WebhookSecret     string        `yaml:"webhooksecret"`     // the key signing the payloads of the webhooks
+  LogLevel          string        `yaml:"loglevel"`          // the lowest level of the messages printed, with overrides per subsystem like "info,NET=debug"
+  Light             bool          `yaml:"light"`             // whether the node only keeps block headers and the transactions of the watched addresses
+  Watch             []string      `yaml:"watch"`             // the addresses whose transactions a light node looks for, or the webhooks of a full node report
+  BanDuration       time.Duration `yaml:"banduration"`       // how long a misbehaving peer is banned
+  Compress          bool          `yaml:"compress"`          // whether large payloads are compressed for the peers accepting it
+  NAT               bool          `yaml:"nat"`               // whether the router maps a port to a node listening beyond loopback, which then advertises the external address
+  Checkpoints       []string      `yaml:"checkpoint"`        // blocks written height:hash the chain must go through, added to the ones of the network
+  Prune             int           `yaml:"prune"`             // the number of recent blocks keeping their transactions, 0 keeps every block
+  TxIndex           bool          `yaml:"txindex"`           // whether the transactions are indexed by ID, to look any transaction of the chain up
+  AddrIndex         bool          `yaml:"addrindex"`         // whether the transactions are indexed by address, for the history queries and the wallet scans
+  UTXOCache         int           `yaml:"utxocache"`         // the megabytes the changes of the UTXO set may use in memory before they are written to the store
+  SigCache          int           `yaml:"sigcache"`          // the number of valid signatures remembered, so the mempool transactions are not verified again in their block
+  MempoolExpiry     time.Duration `yaml:"mempoolexpiry"`     // how long a transaction waits in the mempool to be mined before it is dropped
+  MinRelayFee       int           `yaml:"minrelayfee"`       // the fee per 1000 bytes below which transactions are neither accepted nor relayed
+  AssumeValid       string        `yaml:"assumevalid"`       // the block written height:hash whose ancestors are not signature checked, none to check them all, the one of the network if empty
+  Consensus         string        `yaml:"consensus"`         // the consensus engine, pow, pos or bft, the one of the network if empty
+  Validators        []string      `yaml:"validator"`         // the validators written address:stake of a proof of stake or BFT network, replacing the ones of the network
+}
+
+// Define a function to get the default settings
+func Default() *Config {
+  return &Config{
+    DataDir:           "data",
+    Network:           chaincfg.MainNetParams.Name,
+    MinTxs:            2,
+    MinerThreads:      1,
+    StratumDifficulty: 1,
+    LogLevel:          "info",
+    TargetOutbound:    8,
+    MaxInbound:        117,
+    MaxOutbound:       16,
+    BanDuration:       24 * time.Hour,
+    Compress:          true,
+    NAT:               true,
+    TxIndex:           true,
+    AddrIndex:         true,
+    UTXOCache:         32,
+    SigCache:          script.DefaultSigCacheSize,
+    MempoolExpiry:     14 * 24 * time.Hour,
+  }
+}
+
+// Define a method to read a YAML file over the settings, a missing file is ignored unless required
+func (c *Config) LoadFile(path string, required bool) error {
+  data, err := os.ReadFile(path)
+  if os.IsNotExist(err) && !required {
+    return nil
+  }
+  if err != nil {
+    return err
+  }
+  decoder := yaml.NewDecoder(bytes.NewReader(data))
+  decoder.KnownFields(true) // a misspelled key is an error, not a silently ignored setting
+  if err := decoder.Decode(c); err != nil && err != io.EOF { // an empty file sets nothing
+    return fmt.Errorf("config: %s: %w", path, err)
+  }
+  return nil
+}
+
+// Define a method to apply the environment variables over the settings
+func (c *Config) LoadEnv(lookup func(string) (string, bool)) error {
+  for _, key := range Keys() {
+    if value, ok := lookup(EnvPrefix + strings.ToUpper(key)); ok {
+      if err := c.Set(key, value); err != nil {
+        return fmt.Errorf("config: %s%s: %w", EnvPrefix, strings.ToUpper(key), err)
+      }
+    }
+  }
+  return nil
+}
+
+// Define a method to get the path of the default file, inside the data directory
+func (c *Config) DefaultPath() string {
+  return filepath.Join(c.DataDir, DefaultFile)
+}
+
+// Define a function to list the keys of the settings
+func Keys() []string {
+  var keys []string
+  t := reflect.TypeOf(Config{})
+  for i := 0; i < t.NumField(); i++ {
+    keys = append(keys, t.Field(i).Tag.Get("yaml"))
+  }
+  return keys
+}
+
+// Define a method to set a setting from its text form, lists are comma separated
+func (c *Config) Set(key, value string) error {
+  v := reflect.ValueOf(c).Elem()
+  for i := 0; i < v.NumField(); i++ {
+    if v.Type().Field(i).Tag.Get("yaml") != key {
+      continue
+    }
+    field := v.Field(i)
+    switch field.Kind() {
+    case reflect.String:
+      field.SetString(value)
+    case reflect.Bool:
+      b, err := strconv.ParseBool(value)
+      if err != nil {
+        return err
+      }
+      field.SetBool(b)
+    case reflect.Int64: // durations are written like 24h or 90m
+      d, err := time.ParseDuration(value)
+      if err != nil {
+        return err
+      }
+      field.SetInt(int64(d))
+    case reflect.Int:
+      n, err := strconv.Atoi(value)
+      if err != nil {
+        return err
+      }
+      field.SetInt(int64(n))
+    case reflect.Slice:
+      var list []string
+      for _, item := range strings.Split(value, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+          list = append(list, item)
+        }
+      }
+      field.Set(reflect.ValueOf(list))
+    }
+    return nil
+  }
+  return fmt.Errorf("unknown setting %q", key)
+}
+
+// Define a method to get the parameters of the network, or of the private network of the parameters file,
+// with the checkpoints of the settings, the settings must be valid
+func (c *Config) ChainParams() *chaincfg.Params {
+  network, err := c.network()
+  if err != nil {
+    panic(err) // checked by Validate
+  }
+  params := *network // a copy, the settings do not change the defaults
+  params.Checkpoints = append([]chaincfg.Checkpoint{}, network.Checkpoints...)
+  for _, text := range c.Checkpoints {
+    checkpoint, _ := chaincfg.ParseCheckpoint(text) // checked by Validate
+    params.Checkpoints = append(params.Checkpoints, checkpoint)
+  }
+  sort.Slice(params.Checkpoints, func(i, j int) bool { return params.Checkpoints[i].Height < params.Checkpoints[j].Height })
+  if c.Consensus != "" {
+    params.Consensus = c.Consensus
+  }
+  if len(c.Validators) > 0 {
+    params.Validators = nil
+    for _, text := range c.Validators {
+      validator, _ := chaincfg.ParseValidator(text) // checked by Validate
+      params.Validators = append(params.Validators, validator)
+    }
+  }
+  switch c.AssumeValid {
+  case "": // keep the block of the network
+  case "none":
+    params.AssumeValid = chaincfg.Checkpoint{}
+  default:
+    params.AssumeValid, _ = chaincfg.ParseCheckpoint(c.AssumeValid) // checked by Validate
+  }
+  return &params
+}
+
+// Define a method to get the parameters the settings select, without the checkpoints of the settings
+func (c *Config) network() (*chaincfg.Params, error) {
+  if c.Params != "" {
+    return chaincfg.LoadFile(c.Params)
+  }
+  return chaincfg.ByName(c.Network)
+}
+
+// Define a method to fill the addresses left empty with localhost and the default port of the network
+func (c *Config) SetNetworkDefaults() {
+  local := net.JoinHostPort("localhost", c.ChainParams().DefaultPort)
+  if len(c.Listen) == 0 {
+    c.Listen = []string{local}
+  }
+  if c.FirstNode == "" {
+    c.FirstNode = local
+  }
+  if host, port, err := net.SplitHostPort(c.RPCAddr); err == nil && host == "" { // serving every interface must be asked for, like 0.0.0.0:port
+    c.RPCAddr = net.JoinHostPort("localhost", port)
+  }
+}
+
+// Define a method to check the settings once they are all merged
+func (c *Config) Validate() error {
+  if c.DataDir == "" {
+    return errors.New("config: datadir is empty")
+  }
+  if _, err := c.network(); err != nil {
+    return fmt.Errorf("config: network: %w", err)
+  }
+  if c.MinTxs < 1 {
+    return fmt.Errorf("config: mintxs must be at least 1, got %d", c.MinTxs)
+  }
+  if c.MinerThreads < 1 {
+    return fmt.Errorf("config: minerthreads must be at least 1, got %d", c.MinerThreads)
+  }
+  if c.Prune != 0 && c.Prune < MinPrune {
+    return fmt.Errorf("config: prune must be 0 or at least %d, got %d", MinPrune, c.Prune)
+  }
+  for _, address := range c.Listen {
+    if _, _, err := net.SplitHostPort(address); err != nil { // an IPv6 address goes in brackets
+      return fmt.Errorf("config: listen: %w", err)
+    }
+  }
+  if c.ExternalAddr != "" {
+    if _, _, err := net.SplitHostPort(c.ExternalAddr); err != nil {
+      return fmt.Errorf("config: externaladdr: %w", err)
+    }
+  }
+  if c.Proxy != "" {
+    if _, _, err := net.SplitHostPort(c.Proxy[strings.LastIndex(c.Proxy, "@")+1:]); err != nil { // the credentials are optional
+      return fmt.Errorf("config: proxy: %w", err)
+    }
+  }
+  if c.RPCAddr != "" {
+    if _, _, err := net.SplitHostPort(c.RPCAddr); err != nil {
+      return fmt.Errorf("config: rpcaddr: %w", err)
+    }
+  }
+  if (c.RPCUser == "") != (c.RPCPassword == "") {
+    return errors.New("config: rpcuser and rpcpassword must be set together")
+  }
+  if strings.Contains(c.RPCUser, ":") { // basic authentication splits the user from the password at the first colon
+    return fmt.Errorf("config: rpcuser %q cannot contain a colon", c.RPCUser)
+  }
+  if c.UTXOCache < 0 {
+    return fmt.Errorf("config: utxocache cannot be negative, got %d", c.UTXOCache)
+  }
+  if c.SigCache < 0 {
+    return fmt.Errorf("config: sigcache cannot be negative, got %d", c.SigCache)
+  }
+  if c.MempoolExpiry <= 0 {
+    return fmt.Errorf("config: mempoolexpiry must be positive, got %s", c.MempoolExpiry)
+  }
+  if c.MinRelayFee < 0 {
+    return fmt.Errorf("config: minrelayfee cannot be negative, got %d", c.MinRelayFee)
+  }
+  if c.AddrIndex && !c.TxIndex { // the history looks up the outputs spent by the transactions of an address
+    return errors.New("config: addrindex needs txindex")
+  }
+  if c.TargetOutbound < 0 {
+    return fmt.Errorf("config: targetoutbound cannot be negative, got %d", c.TargetOutbound)
+  }
+  if c.MaxInbound < 0 {
+    return fmt.Errorf("config: maxinbound cannot be negative, got %d", c.MaxInbound)
+  }
+  if c.MaxOutbound < c.TargetOutbound {
+    return fmt.Errorf("config: maxoutbound %d is below targetoutbound %d", c.MaxOutbound, c.TargetOutbound)
+  }
+  if c.BanDuration <= 0 {
+    return fmt.Errorf("config: banduration must be positive, got %s", c.BanDuration)
+  }
+  if c.StratumDifficulty < 1 {
+    return fmt.Errorf("config: stratumdifficulty must be at least 1, got %d", c.StratumDifficulty)
+  }
+  if c.Light && (c.Miner != "" || c.RPCAddr != "" || c.GRPCAddr != "" || c.StratumAddr != "" || c.ZMQAddr != "" || len(c.Webhooks) > 0) { // these need the full chain
+    return errors.New("config: a light node cannot mine, serve rpcaddr, grpcaddr, stratumaddr and zmqaddr nor post to webhooks")
+  }
+  for _, hook := range c.Webhooks {
+    if u, err := url.Parse(hook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+      return fmt.Errorf("config: webhook %q is not an http or https URL", hook)
+    }
+  }
+  if len(c.Webhooks) > 0 && c.WebhookSecret == "" { // the endpoints could not tell the payloads are genuine
+    return errors.New("config: webhook needs webhooksecret")
+  }
+  for _, checkpoint := range c.Checkpoints {
+    if _, err := chaincfg.ParseCheckpoint(checkpoint); err != nil {
+      return fmt.Errorf("config: checkpoint: %w", err)
+    }
+  }
+  if c.AssumeValid != "" && c.AssumeValid != "none" {
+    if _, err := chaincfg.ParseCheckpoint(c.AssumeValid); err != nil {
+      return fmt.Errorf("config: assumevalid: %w", err)
+    }
+  }
+  for _, id := range c.Whitelist {
+    if err := noise.ValidateID(id); err != nil {
+      return fmt.Errorf("config: whitelist: %w", err)
+    }
+  }
+  if len(c.Whitelist) > 0 && !c.Noise && !c.NoiseRequire { // the IDs are only proven by the handshake
+    return errors.New("config: whitelist needs noise")
+  }
+  for _, validator := range c.Validators {
+    if _, err := chaincfg.ParseValidator(validator); err != nil {
+      return fmt.Errorf("config: validator: %w", err)
+    }
+  }
+  if err := c.ChainParams().CheckConsensus(); err != nil {
+    return fmt.Errorf("config: consensus: %w", err)
+  }
+  if err := logger.ValidateSpec(c.LogLevel); err != nil {
+    return fmt.Errorf("config: loglevel %q: %w", c.LogLevel, err)
+  }
+  return nil
+}
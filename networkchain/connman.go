@@ -0,0 +1,354 @@
+package main
+
+import (
+  "errors"              // for the error of a full node
+  "networkchain/events" // the peers connecting and leaving are announced
+  "sort"                // to list the peers in the order they connected
+  "sync"                // for the lock of the connections
+  "time"                // for the backoff of the dials
+)
+
+// Define some constants of the connection manager
+const (
+  connectInterval  = 5 * time.Second  // how often the outbound peers are counted and the missing ones dialed
+  connectTimeout   = 30 * time.Second // how long a dialed peer may take to answer with its version
+  connectRetryBase = 5 * time.Second  // the wait before dialing an address again after its first failure, doubled after each other
+  connectRetryMax  = 10 * time.Minute // the longest wait before dialing an address again
+  protectLongest   = 4                // the inbound peers connected the longest, never evicted
+  protectFastest   = 8                // the inbound peers answering the pings the fastest, never evicted
+)
+
+// Define some errors of the inbound peers
+var (
+  errInboundFull  = errors.New("no room for another inbound peer")
+  errAddressTaken = errors.New("the address is a peer connecting from another host")
+)
+
+// Define a struct for the connection manager of a node
+// A peer is outbound once it answered the version the node dialed it with, and inbound once it dialed the node; the node
+// keeps a target number of outbound peers, dialing the manual addresses first and then addresses of the address manager,
+// and waits longer before dialing an address again after each failure
+// The outbound and the inbound peers are limited; a node full of inbound peers evicts one to admit another, sparing the
+// peers connected the longest and the fastest ones, which an attacker cannot easily take the place of
+// A peer names itself by the address it listens on, which it may claim freely, so an inbound peer is also tied to the host
+// its connection came from: only that host keeps its place, and the network groups of the eviction are those of the hosts
+type connManager struct {
+  mu          sync.Mutex           // the lock protecting the connections
+  target      int                  // the number of outbound peers the node keeps
+  maxInbound  int                  // the most inbound peers the node accepts
+  maxOutbound int                  // the most outbound peers the node dials, manual ones included
+  manual      []string             // the addresses always dialed: the first node and the added nodes, or the only ones given
+  outbound    map[string]time.Time // the peers the node dialed, with when they answered
+  inbound     map[string]time.Time // the peers that dialed the node, with when they did
+  hosts       map[string]string    // the host the connection of each inbound peer came from
+  dialing     map[string]time.Time // the peers dialed that did not answer yet, with when they were dialed
+  failures    map[string]int       // the failed dials of each address in a row
+  retryAt     map[string]time.Time // when each failed address may be dialed again
+  events      *events.Bus          // the bus announcing the peers that connect and leave
+}
+
+// Define a struct for a peer that connected or left, published with the peer events
+type PeerEvent struct {
+  Address string // the address of the peer
+  Inbound bool   // whether the peer dialed the node
+}
+
+// Define a function to create a connection manager with the target number of outbound peers, the limits, the manual
+// addresses and the bus announcing the peers
+func newConnManager(target, maxInbound, maxOutbound int, manual []string, bus *events.Bus) *connManager {
+  return &connManager{
+    target:      target,
+    maxInbound:  maxInbound,
+    maxOutbound: maxOutbound,
+    manual:      manual,
+    outbound:    map[string]time.Time{},
+    inbound:     map[string]time.Time{},
+    hosts:       map[string]string{},
+    dialing:     map[string]time.Time{},
+    failures:    map[string]int{},
+    retryAt:     map[string]time.Time{},
+    events:      bus,
+  }
+}
+
+// Define a method to check if an address is a peer, or is being dialed, the lock must be held
+func (c *connManager) isConnected(address string) bool {
+  _, outbound := c.outbound[address]
+  _, inbound := c.inbound[address]
+  _, dialing := c.dialing[address]
+  return outbound || inbound || dialing
+}
+
+// Define a method to check if an address may be dialed: not a peer, and not waiting after a failure
+func (c *connManager) canDial(address string) bool {
+  c.mu.Lock() // lock the connections
+  defer c.mu.Unlock() // unlock them when done
+  return !c.isConnected(address) && !time.Now().Before(c.retryAt[address])
+}
+
+// Define a method to record the dial of an address, returning false if it is already a peer, must wait, or the node has
+// all the outbound peers it may have
+func (c *connManager) dial(address string) bool {
+  c.mu.Lock() // lock the connections
+  defer c.mu.Unlock() // unlock them when done
+  if c.isConnected(address) || time.Now().Before(c.retryAt[address]) || len(c.outbound)+len(c.dialing) >= c.maxOutbound {
+    return false
+  }
+  c.dialing[address] = time.Now()
+  return true
+}
+
+// Define a method to record the version of a peer, with the host its connection came from and the round trip times of the
+// peers, returning whether it dialed the node, which then answers with its own version, rather than answered a dial of
+// the node
+// A new inbound peer beyond the limit takes the place of an evicted one, returned, or is refused with errInboundFull; a
+// peer claiming the address of an inbound peer from another host is refused with errAddressTaken
+func (c *connManager) handshake(address, host string, rtts map[string]time.Duration) (bool, string, error) {
+  c.mu.Lock() // lock the connections
+  defer c.mu.Unlock() // unlock them when done
+  if _, dialed := c.dialing[address]; dialed { // the answer to our version
+    delete(c.dialing, address)
+    delete(c.failures, address)
+    delete(c.retryAt, address)
+    c.outbound[address] = time.Now()
+    c.events.Publish(events.Event{Type: events.PeerConnected, Data: &PeerEvent{address, false}})
+    return false, "", nil
+  }
+  if _, outbound := c.outbound[address]; outbound { // a peer we dialed dialing us too
+    return true, "", nil
+  }
+  if _, inbound := c.inbound[address]; inbound {
+    if c.hosts[address] != host { // the address is not its to take
+      return true, "", errAddressTaken
+    }
+    return true, "", nil // a peer dialing us again, after a restart, keeps its place
+  }
+  var evicted string
+  if len(c.inbound) >= c.maxInbound {
+    if evicted = c.evictionCandidate(rtts); evicted == "" {
+      return true, "", errInboundFull
+    }
+    delete(c.inbound, evicted)
+    delete(c.hosts, evicted)
+    c.events.Publish(events.Event{Type: events.PeerDisconnected, Data: &PeerEvent{evicted, true}})
+  }
+  c.inbound[address] = time.Now()
+  c.hosts[address] = host
+  c.events.Publish(events.Event{Type: events.PeerConnected, Data: &PeerEvent{address, true}})
+  return true, evicted, nil
+}
+
+// Define a method to pick the inbound peer to evict, the lock must be held: the ones connected the longest and the fastest
+// ones are spared, then the youngest peer of the network group with the most peers goes, so the peers of a single
+// operator are the first to make room; it returns an empty address when every peer is protected
+// The groups are those of the hosts the peers connected from, a peer cannot move to another group by claiming an address
+func (c *connManager) evictionCandidate(rtts map[string]time.Duration) string {
+  candidates := make([]string, 0, len(c.inbound))
+  for address := range c.inbound {
+    candidates = append(candidates, address)
+  }
+  sort.Slice(candidates, func(i, j int) bool { return c.inbound[candidates[i]].Before(c.inbound[candidates[j]]) })
+  if len(candidates) <= protectLongest {
+    return ""
+  }
+  candidates = candidates[protectLongest:] // spare the oldest peers
+  sort.SliceStable(candidates, func(i, j int) bool { // the peers never answering a ping last
+    a, b := rtts[candidates[i]], rtts[candidates[j]]
+    return a != 0 && (b == 0 || a < b)
+  })
+  protected := 0
+  for protected < len(candidates) && protected < protectFastest && rtts[candidates[protected]] != 0 {
+    protected++
+  }
+  candidates = candidates[protected:] // spare the fastest peers
+  if len(candidates) == 0 {
+    return ""
+  }
+  groups := map[string][]string{} // the candidates of each network group
+  for _, address := range candidates {
+    group := addrGroup(c.hosts[address])
+    groups[group] = append(groups[group], address)
+  }
+  var largest []string
+  for _, group := range groups {
+    if len(group) > len(largest) || (len(group) == len(largest) && c.youngest(group).After(c.youngest(largest))) {
+      largest = group
+    }
+  }
+  evicted := largest[0]
+  for _, address := range largest[1:] {
+    if c.inbound[address].After(c.inbound[evicted]) {
+      evicted = address
+    }
+  }
+  return evicted
+}
+
+// Define a method to get when the youngest inbound peer of a group connected, the lock must be held
+func (c *connManager) youngest(group []string) time.Time {
+  var youngest time.Time
+  for _, address := range group {
+    if c.inbound[address].After(youngest) {
+      youngest = c.inbound[address]
+    }
+  }
+  return youngest
+}
+
+// Define a method to record a failed dial of an address or a peer that stopped answering, returning how long it waits
+// before the address is dialed again
+func (c *connManager) failed(address string) time.Duration {
+  c.mu.Lock() // lock the connections
+  defer c.mu.Unlock() // unlock them when done
+  c.disconnectLocked(address)
+  c.failures[address]++
+  wait := connectRetryMax
+  if shift := c.failures[address] - 1; shift < 16 && connectRetryBase<<shift < connectRetryMax {
+    wait = connectRetryBase << shift
+  }
+  c.retryAt[address] = time.Now().Add(wait)
+  return wait
+}
+
+// Define a method to check if an address is a peer or a manual address, the node only answers the pings of those
+func (c *connManager) isPeer(address string) bool {
+  c.mu.Lock() // lock the connections
+  defer c.mu.Unlock() // unlock them when done
+  _, outbound := c.outbound[address]
+  _, inbound := c.inbound[address]
+  if outbound || inbound {
+    return true
+  }
+  for _, manual := range c.manual {
+    if address == manual {
+      return true
+    }
+  }
+  return false
+}
+
+// Define a method to forget the connection with a peer
+func (c *connManager) disconnect(address string) {
+  c.mu.Lock() // lock the connections
+  defer c.mu.Unlock() // unlock them when done
+  c.disconnectLocked(address)
+}
+
+// Define a method to forget the connection with a peer, announcing it left if it completed the handshake, the lock must
+// be held
+func (c *connManager) disconnectLocked(address string) {
+  _, outbound := c.outbound[address]
+  _, inbound := c.inbound[address]
+  delete(c.outbound, address)
+  delete(c.inbound, address)
+  delete(c.hosts, address)
+  delete(c.dialing, address)
+  if outbound || inbound {
+    c.events.Publish(events.Event{Type: events.PeerDisconnected, Data: &PeerEvent{address, inbound && !outbound}})
+  }
+}
+
+// Define a method to get the dials that were not answered in time
+func (c *connManager) expired() []string {
+  c.mu.Lock() // lock the connections
+  defer c.mu.Unlock() // unlock them when done
+  var expired []string
+  for address, since := range c.dialing {
+    if time.Since(since) > connectTimeout {
+      expired = append(expired, address)
+    }
+  }
+  return expired
+}
+
+// Define a method to count the outbound peers, with the dials in progress
+func (c *connManager) outboundCount() int {
+  c.mu.Lock() // lock the connections
+  defer c.mu.Unlock() // unlock them when done
+  return len(c.outbound) + len(c.dialing)
+}
+
+// Define a method to list the peers, outbound and inbound, in the order they connected
+func (c *connManager) peers() []string {
+  c.mu.Lock() // lock the connections
+  defer c.mu.Unlock() // unlock them when done
+  peers := make([]string, 0, len(c.outbound)+len(c.inbound))
+  for address := range c.outbound {
+    peers = append(peers, address)
+  }
+  for address := range c.inbound {
+    if _, outbound := c.outbound[address]; !outbound {
+      peers = append(peers, address)
+    }
+  }
+  sort.Slice(peers, func(i, j int) bool {
+    return c.since(peers[i]).Before(c.since(peers[j]))
+  })
+  return peers
+}
+
+// Define a method to get when a peer connected, the lock must be held
+func (c *connManager) since(address string) time.Time {
+  if since, ok := c.outbound[address]; ok {
+    return since
+  }
+  return c.inbound[address]
+}
+
+// Define a method to describe the connection with a peer: when it connected, zero if it is not a peer, and whether it
+// dialed the node
+func (c *connManager) state(address string) (time.Time, bool) {
+  c.mu.Lock() // lock the connections
+  defer c.mu.Unlock() // unlock them when done
+  _, outbound := c.outbound[address]
+  return c.since(address), !outbound && !c.inbound[address].IsZero()
+}
+
+// Define a method to keep the outbound peers of the node until it stops
+func (n *Node) connectLoop() {
+  ticker := time.NewTicker(connectInterval) // create a ticker for the interval
+  defer ticker.Stop() // stop it when done
+  for {
+    n.maintainOutbound() // right away, then on every tick
+    select {
+    case <-n.quit: // the node stopped
+      return
+    case <-ticker.C:
+    }
+  }
+}
+
+// Define a method to dial the manual addresses that are not peers, then addresses of the address manager until the node
+// has its target number of outbound peers
+func (n *Node) maintainOutbound() {
+  for _, address := range n.conns.expired() { // the dials nobody answered
+    wait := n.conns.failed(address)
+    netLog.Debug("Peer did not answer the version", "peer", address, "retry", wait)
+  }
+  for _, address := range n.conns.manual {
+    if n.canReach(address) && n.conns.canDial(address) {
+      go n.connect(address)
+    }
+  }
+  if n.connectOnly { // the node talks to the given addresses only
+    return
+  }
+  for missing := n.conns.target - n.conns.outboundCount(); missing > 0; missing-- {
+    address := n.addrs.pick(func(address string) bool {
+      return !n.conns.canDial(address) || !n.canReach(address)
+    })
+    if address == "" { // no address left to try
+      return
+    }
+    if n.conns.dial(address) { // the dial is counted right away, the next pick skips the address
+      go n.sendVersion(address)
+    }
+  }
+}
+
+// Define a method to dial a peer with the version of the node, unless it is already a peer or must wait after a failure
+func (n *Node) connect(address string) {
+  if n.conns.dial(address) {
+    n.sendVersion(address)
+  }
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+  "errors"  // to match the errors
+  "testing" // the test framework
+  "time"    // to order the peers
+)
+
+func TestHandshakeClaimedAddress(t *testing.T) {
+  tests := []struct {
+    name  string
+    host  string // the host the second version comes from
+    err   error
+    peers int // the inbound peers after it
+  }{
+    {"the same host again", "203.0.113.1", nil, 1},
+    {"another host claiming the address", "198.51.100.7", errAddressTaken, 1},
+  }
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      c := newConnManager(8, 8, 8, nil, nil)
+      if _, _, err := c.handshake("203.0.113.1:3000", "203.0.113.1", nil); err != nil {
+        t.Fatal(err)
+      }
+      inbound, _, err := c.handshake("203.0.113.1:3000", test.host, nil)
+      if !inbound || !errors.Is(err, test.err) {
+        t.Errorf("inbound %v, err %v, want an inbound peer and %v", inbound, err, test.err)
+      }
+      if len(c.inbound) != test.peers || c.hosts["203.0.113.1:3000"] != "203.0.113.1" {
+        t.Errorf("%d inbound peers from %q, want %d from the first host", len(c.inbound), c.hosts["203.0.113.1:3000"], test.peers)
+      }
+    })
+  }
+}
+
+// A host claiming addresses of many network groups is still a single group to the eviction
+func TestEvictionByHost(t *testing.T) {
+  peers := []struct {
+    address string // the address the peer claims
+    host    string // the host it connects from
+  }{
+    {"192.0.2.1:3000", "192.0.2.1"}, // the oldest peers, protected
+    {"192.0.2.2:3000", "192.0.2.2"},
+    {"192.0.2.3:3000", "192.0.2.3"},
+    {"192.0.2.4:3000", "192.0.2.4"},
+    {"1.1.0.1:3000", "10.1.0.1"}, // an attacker, claiming addresses in other groups
+    {"2.2.0.1:3000", "10.1.0.1"},
+    {"10.2.0.1:3000", "10.2.0.1"}, // an honest peer, the youngest
+  }
+  c := newConnManager(8, len(peers), 8, nil, nil)
+  start := time.Now()
+  for i, peer := range peers {
+    if _, _, err := c.handshake(peer.address, peer.host, nil); err != nil {
+      t.Fatal(err)
+    }
+    c.inbound[peer.address] = start.Add(time.Duration(i) * time.Second)
+  }
+  if evicted := c.evictionCandidate(nil); evicted != "2.2.0.1:3000" {
+    t.Errorf("evicted %q, want the youngest peer of the attacker", evicted)
+  }
+}
@@ -0,0 +1,159 @@
+package consensus
+
+import (
+  "bytes"                 // to compare the hashes
+  "crypto/sha256"         // to hash the prepare votes
+  "errors"                // for the seal errors
+  "fmt"                   // to format the seal errors
+  "networkchain/chaincfg" // the validators of the network
+  "networkchain/wallet"   // the keys signing the blocks and the votes
+  "math/big"              // the weight of a branch is a big number
+)
+
+// Define a struct for the vote of a validator: its public key and its signature
+type Vote struct {
+  PubKey    []byte // the public key of the validator
+  Signature []byte // the signature of the voted digest
+}
+
+// Define the BFT engine
+// A fixed set of validators agrees on each block in three rounds: the primary of the round proposes a block it signs
+// (pre-prepare), the validators that accept it vote for it (prepare), and once a quorum prepared they commit to it (commit).
+// The commit votes of a quorum are kept in the header: any node can check them, and a committed block is final
+type BFT struct {
+  validators map[string]bool // the addresses of the validators
+  order      []string        // the addresses in the order of the parameters, the primaries take turns in it
+}
+
+// Define a function to create the BFT engine of a set of validators, their stakes are ignored
+func NewBFT(validators []chaincfg.Validator) *BFT {
+  engine := &BFT{validators: map[string]bool{}}
+  for _, validator := range validators {
+    engine.validators[validator.Address] = true
+    engine.order = append(engine.order, validator.Address)
+  }
+  return engine
+}
+
+// Define a method to get the number of votes a round needs: all the validators but the f that may fail or lie, 2f+1 of 3f+1
+// Two quorums share an honest validator whatever the size of the set, so a rotated set need not have 3f+1 members
+func (e *BFT) Quorum() int {
+  faulty := (len(e.order) - 1) / 3
+  return len(e.order) - faulty
+}
+
+// Define a method to get the address of the validator proposing the block at a height in a view, the view moves on when a primary fails
+func (e *BFT) Primary(height, view int) string {
+  return e.order[(height+view)%len(e.order)]
+}
+
+// Define a method to get the address of a validator from its public key, false if the key is not one of a validator
+func (e *BFT) Validator(pubKey []byte) (string, bool) {
+  address := wallet.AddressFromPubKey(pubKey)
+  return address, e.validators[address]
+}
+
+// Define a function to get the digest a prepare vote signs, a commit vote signs the block hash itself
+// The prefix keeps a prepare vote from being passed off as a commit vote
+func PrepareDigest(hash []byte) []byte {
+  digest := sha256.Sum256(append([]byte("prepare"), hash...))
+  return digest[:]
+}
+
+// Define a method to check a vote of a validator for a digest, returning the address of the validator
+func (e *BFT) VerifyVote(vote Vote, digest []byte) (string, error) {
+  address, ok := e.Validator(vote.PubKey)
+  if !ok {
+    return "", fmt.Errorf("consensus: %s is not a validator", address)
+  }
+  if err := wallet.Verify(vote.PubKey, digest, vote.Signature); err != nil {
+    return "", fmt.Errorf("consensus: invalid vote of %s: %w", address, err)
+  }
+  return address, nil
+}
+
+// Define a method that signs a proposed block with the key of a validator
+// The block is only valid once the commit votes of a quorum are added to it; the genesis block only gets its hash
+func (e *BFT) Seal(header, parent *Header, key *wallet.Wallet) error {
+  header.Commits = nil
+  if parent == nil {
+    header.Proposer, header.Signature = nil, nil
+    header.Hash = posHash(header)
+    return nil
+  }
+  if key == nil || !e.validators[key.Address()] {
+    return errors.New("consensus: a BFT block must be proposed by a validator key")
+  }
+  header.Proposer = key.PublicKey
+  header.Hash = posHash(header)
+  header.Signature = key.Sign(header.Hash)
+  return nil
+}
+
+// Define a function that checks the hash of a signed header and the signature of its proposer
+func verifySignedHeader(header *Header) error {
+  if !bytes.Equal(posHash(header), header.Hash) {
+    return errors.New("consensus: the hash was not computed from the header")
+  }
+  if err := wallet.Verify(header.Proposer, header.Hash, header.Signature); err != nil {
+    return fmt.Errorf("consensus: invalid block signature: %w", err)
+  }
+  return nil
+}
+
+// Define a method that checks a proposed block: its hash matches the header and a validator signed it
+func (e *BFT) VerifyProposal(header *Header) error {
+  if err := verifySignedHeader(header); err != nil {
+    return err
+  }
+  if address, ok := e.Validator(header.Proposer); !ok {
+    return fmt.Errorf("consensus: block proposed by %s, not a validator", address)
+  }
+  return nil
+}
+
+// Define a method that checks the signatures of a committed block: the proposal and the commit votes, once per key
+// Whether the signers are validators, and enough of them, depends on the epoch and is checked by VerifyHeader
+func (e *BFT) VerifySeal(header *Header) error {
+  if len(header.PrevHash) == 0 && len(header.Proposer) == 0 { // the genesis block is not voted on
+    if !bytes.Equal(posHash(header), header.Hash) {
+      return errors.New("consensus: the hash was not computed from the header")
+    }
+    return nil
+  }
+  if err := verifySignedHeader(header); err != nil {
+    return err
+  }
+  voters := map[string]bool{} // the keys that committed
+  for _, vote := range header.Commits {
+    if voters[string(vote.PubKey)] {
+      return fmt.Errorf("consensus: %s committed twice", wallet.AddressFromPubKey(vote.PubKey))
+    }
+    voters[string(vote.PubKey)] = true
+    if err := wallet.Verify(vote.PubKey, header.Hash, vote.Signature); err != nil {
+      return fmt.Errorf("consensus: invalid commit vote of %s: %w", wallet.AddressFromPubKey(vote.PubKey), err)
+    }
+  }
+  return nil
+}
+
+// Define a method that checks a committed block was proposed by a validator and committed by a quorum of them
+func (e *BFT) VerifyHeader(header, parent *Header) error {
+  if err := e.VerifyProposal(header); err != nil {
+    return err
+  }
+  for _, vote := range header.Commits {
+    if address, ok := e.Validator(vote.PubKey); !ok {
+      return fmt.Errorf("consensus: commit vote of %s, not a validator", address)
+    }
+  }
+  if len(header.Commits) < e.Quorum() { // the keys are distinct, VerifySeal checked it
+    return fmt.Errorf("consensus: the block has %d commit votes, %d are needed", len(header.Commits), e.Quorum())
+  }
+  return nil
+}
+
+// Define a method that weighs every block the same, a committed block is final so branches never compete
+func (e *BFT) Work(header *Header) *big.Int {
+  return big.NewInt(1)
+}
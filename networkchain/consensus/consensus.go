@@ -0,0 +1,52 @@
+// Package consensus decides how a block proves it may extend a chain.
+// An engine seals the header of a new block, checks the seal of a received one and weighs the blocks
+// so the node can pick the best branch; every node of a network runs the engine of its parameters.
+package consensus
+
+import (
+  "errors"                // for the sealing errors
+  "networkchain/chaincfg" // the network selects the engine
+  "networkchain/wallet"   // the keys signing proof of stake blocks
+  "math/big"              // the weight of a branch is a big number
+)
+
+// Define the error returned when a key is asked to seal a block of a slot another validator proposes
+var ErrNotProposer = errors.New("consensus: the key is not the proposer of the slot")
+
+// Define a struct for the fields of a block header an engine seals and checks
+type Header struct {
+  PrevHash   []byte // the hash of the previous block, empty for the genesis block
+  MerkleRoot []byte // the merkle root of the transactions
+  Timestamp  int64  // the time when the block was created
+  Bits       uint32 // the target of a proof of work block
+  Nonce      int    // the number found by the proof of work
+  Proposer   []byte // the public key of the validator that signed a proof of stake block
+  Signature  []byte // the signature of the hash by the proposer
+  Commits    []Vote // the commit votes of the validators of a BFT network, not covered by the hash
+  Hash       []byte // the hash of the block, set by the seal
+}
+
+// Define the interface of a consensus engine
+type Engine interface {
+  Seal(header, parent *Header, key *wallet.Wallet) error // set the hash of a new block on top of parent, nil for the genesis block, the key signs it if the engine needs one
+  VerifySeal(header *Header) error                        // check the seal against the header alone, whatever the validators
+  VerifyHeader(header, parent *Header) error              // check the seal is allowed on top of the parent, by the validators of the engine
+  Work(header *Header) *big.Int                           // the weight of the block in the choice of the best branch
+}
+
+// Define a function to create the engine of a network with the validators of its parameters, the parameters must be valid
+func New(params *chaincfg.Params) Engine {
+  return NewWithValidators(params, params.Validators)
+}
+
+// Define a function to create the engine of a network with the validators of an epoch, a network rotating its validators
+// seals and checks the headers of each epoch with its own engine
+func NewWithValidators(params *chaincfg.Params, validators []chaincfg.Validator) Engine {
+  switch params.Consensus {
+  case chaincfg.ProofOfStake:
+    return NewProofOfStake(validators, params.TargetBlockTime)
+  case chaincfg.BFT:
+    return NewBFT(validators)
+  }
+  return NewProofOfWork(params)
+}
@@ -0,0 +1,114 @@
+package consensus
+
+import (
+  "bytes"                 // to concatenate the header fields
+  "crypto/sha256"         // to hash the headers and pick the proposers
+  "errors"                // for the seal errors
+  "fmt"                   // to format the seal errors
+  "networkchain/chaincfg" // the validators of the network
+  "networkchain/wallet"   // the keys signing the blocks
+  "math/big"              // to draw a proposer from the seed
+  "time"                  // for the length of a slot
+)
+
+// Define the proof of stake engine
+// Time after a block is cut in slots of the target block time; each slot has one proposer, drawn among the validators
+// in proportion to their stake with the hash of the parent and the slot number as seed. Only the proposer of a slot
+// may sign a block in it, and when it stays silent the next slot gives another validator its chance
+type ProofOfStake struct {
+  validators []chaincfg.Validator // the validators, in the order of the parameters
+  total      int64                // the sum of their stakes
+  slot       int64                // the length of a slot in seconds
+}
+
+// Define a function to create the proof of stake engine of a set of validators
+func NewProofOfStake(validators []chaincfg.Validator, blockTime time.Duration) *ProofOfStake {
+  engine := &ProofOfStake{validators: validators, slot: int64(blockTime / time.Second)}
+  if engine.slot < 1 {
+    engine.slot = 1
+  }
+  for _, validator := range validators {
+    engine.total += int64(validator.Stake)
+  }
+  return engine
+}
+
+// Define a method to get the slot of a block: the number of slot lengths elapsed since its parent
+func (e *ProofOfStake) slotOf(header, parent *Header) int64 {
+  return (header.Timestamp - parent.Timestamp) / e.slot
+}
+
+// Define a method to draw the address of the proposer of a slot after a block, in proportion to the stakes
+func (e *ProofOfStake) Proposer(parentHash []byte, slot int64) string {
+  seed := sha256.Sum256(append(append([]byte{}, parentHash...), intToBytes(slot)...)) // the same draw on every node
+  draw := new(big.Int).Mod(new(big.Int).SetBytes(seed[:]), big.NewInt(e.total)).Int64()
+  for _, validator := range e.validators { // find the validator whose stake range holds the draw
+    if draw < int64(validator.Stake) {
+      return validator.Address
+    }
+    draw -= int64(validator.Stake)
+  }
+  return e.validators[len(e.validators)-1].Address // not reached, the draw is below the total
+}
+
+// Define a function that hashes the fields of a signed header, the proposer included so the hash names the signer
+func posHash(header *Header) []byte {
+  hash := sha256.Sum256(bytes.Join([][]byte{
+    header.PrevHash,                // the hash of the previous block
+    header.MerkleRoot,              // the merkle root of the transactions
+    intToBytes(header.Timestamp),   // the time when the block was created
+    intToBytes(int64(header.Bits)), // the target, kept for the header format
+    header.Proposer,                // the public key of the signer
+  }, []byte{}))
+  return hash[:]
+}
+
+// Define a method that signs a block with the key of the proposer of its slot, ErrNotProposer if the key is another one
+// The genesis block has no slot and no signer, it only gets its hash
+func (e *ProofOfStake) Seal(header, parent *Header, key *wallet.Wallet) error {
+  if parent == nil {
+    header.Proposer, header.Signature = nil, nil
+    header.Hash = posHash(header)
+    return nil
+  }
+  if key == nil {
+    return errors.New("consensus: a proof of stake block must be signed by a validator key")
+  }
+  slot := e.slotOf(header, parent)
+  if proposer := e.Proposer(parent.Hash, slot); proposer != key.Address() {
+    return fmt.Errorf("%w %d after block %x, %s proposes it", ErrNotProposer, slot, parent.Hash, proposer)
+  }
+  header.Proposer = key.PublicKey
+  header.Hash = posHash(header)
+  header.Signature = key.Sign(header.Hash)
+  return nil
+}
+
+// Define a method that checks that the block hash matches its header and is signed by the key it names
+func (e *ProofOfStake) VerifySeal(header *Header) error {
+  if !bytes.Equal(posHash(header), header.Hash) {
+    return errors.New("consensus: the hash was not computed from the header")
+  }
+  if len(header.PrevHash) == 0 && len(header.Proposer) == 0 { // the genesis block is not signed
+    return nil
+  }
+  if err := wallet.Verify(header.Proposer, header.Hash, header.Signature); err != nil {
+    return fmt.Errorf("consensus: invalid block signature: %w", err)
+  }
+  return nil
+}
+
+// Define a method that checks that the signer of a block is the proposer of its slot
+func (e *ProofOfStake) VerifyHeader(header, parent *Header) error {
+  slot := e.slotOf(header, parent)
+  proposer := e.Proposer(parent.Hash, slot)
+  if signer := wallet.AddressFromPubKey(header.Proposer); signer != proposer {
+    return fmt.Errorf("consensus: block signed by %s in slot %d, %s proposes it", signer, slot, proposer)
+  }
+  return nil
+}
+
+// Define a method that weighs every block the same, so the longest branch is the best one
+func (e *ProofOfStake) Work(header *Header) *big.Int {
+  return big.NewInt(1)
+}
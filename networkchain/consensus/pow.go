@@ -0,0 +1,176 @@
+package consensus
+
+import (
+  "bytes"                 // to concatenate the header fields
+  "crypto/sha256"         // the hash the work is done on
+  "encoding/binary"       // to encode the numbers of the header
+  "errors"                // for the seal errors
+  "networkchain/chaincfg" // the names of the hash functions
+  "networkchain/wallet"   // the engine interface passes a key, unused here
+  "math"                  // for the largest nonce
+  "math/big"              // the target is a 256 bit number
+
+  "golang.org/x/crypto/blake2b" // the BLAKE2b headers
+  "golang.org/x/crypto/sha3"    // the SHA3 headers
+)
+
+// The largest nonce tried before giving up
+const maxNonce = math.MaxInt64
+
+// The number of hashes between two checks of the abort channel of a search
+const abortCheckInterval = 1 << 12
+
+// Define a type for the function hashing the headers of a proof of work network
+type HashFunc func(data []byte) [32]byte
+
+// Define the proof of work engine
+// Mining a block means finding a nonce so that the hash of the header is below the target encoded in the block bits
+type ProofOfWork struct {
+  hash HashFunc // the hash of the headers, SHA-256 if nil
+}
+
+// Define a function to create the proof of work engine of a network, hashing the headers with the function of its
+// parameters
+func NewProofOfWork(params *chaincfg.Params) ProofOfWork {
+  return ProofOfWork{hash: NewHashFunc(params.BlockHash)}
+}
+
+// Define a function to get the hash function of a name of the parameters, SHA-256 if the name is empty or unknown
+func NewHashFunc(name string) HashFunc {
+  switch name {
+  case chaincfg.HashDoubleSHA256:
+    return func(data []byte) [32]byte {
+      first := sha256.Sum256(data)
+      return sha256.Sum256(first[:])
+    }
+  case chaincfg.HashSHA3:
+    return sha3.Sum256
+  case chaincfg.HashBlake2b:
+    return blake2b.Sum256
+  }
+  return sha256.Sum256
+}
+
+// Define a method to hash the bytes of a header with the function of the network
+func (e ProofOfWork) sum(data []byte) [32]byte {
+  if e.hash == nil {
+    return sha256.Sum256(data)
+  }
+  return e.hash(data)
+}
+
+// Define a function that builds the header bytes hashed for a given nonce
+func powData(header *Header, nonce int) []byte {
+  return bytes.Join([][]byte{
+    header.PrevHash,                  // the hash of the previous block
+    header.MerkleRoot,                // the merkle root of the transactions
+    intToBytes(header.Timestamp),     // the time when the block was created
+    intToBytes(int64(header.Bits)),   // the target
+    intToBytes(int64(nonce)),         // the nonce being tried
+  }, []byte{})
+}
+
+// Define a method that searches a nonce meeting the target and sets it with the block hash
+func (e ProofOfWork) Seal(header, parent *Header, key *wallet.Wallet) error {
+  e.SearchNonce(header, 0, 1, nil) // try the nonces one by one
+  return nil
+}
+
+// Define a method that searches the nonces first, first+step, first+2*step... for one meeting the target, setting
+// it with the block hash, until the nonces run out or abort is closed; it tells if it found one and how many it tried
+// Workers mining together start at their index with their number as step, so they never try the same nonce
+func (e ProofOfWork) SearchNonce(header *Header, first, step int, abort <-chan struct{}) (bool, int) {
+  target := CompactToBig(header.Bits)
+  var hashInt big.Int // the hash as a number
+  tried := 0
+  for nonce := first; nonce >= 0 && nonce < maxNonce; nonce += step { // a nonce past the largest one wraps around
+    if tried%abortCheckInterval == 0 && abort != nil {
+      select {
+      case <-abort: // the template is stale or the miner stopped
+        return false, tried
+      default:
+      }
+    }
+    hash := e.sum(powData(header, nonce)) // hash the header
+    tried++
+    hashInt.SetBytes(hash[:])
+    if hashInt.Cmp(target) == -1 { // the hash is below the target, the block is mined
+      header.Nonce, header.Hash = nonce, hash[:]
+      return true, tried
+    }
+  }
+  return false, tried
+}
+
+// Define a method that hashes a header with its nonce, the hash the target applies to
+func (e ProofOfWork) PowHash(header *Header) []byte {
+  hash := e.sum(powData(header, header.Nonce))
+  return hash[:]
+}
+
+// Define a method that checks that the block hash matches its header and meets its target
+func (e ProofOfWork) VerifySeal(header *Header) error {
+  hash := e.PowHash(header) // hash the header with the stored nonce
+  if !bytes.Equal(hash, header.Hash) {
+    return errors.New("consensus: the hash was not computed from the header")
+  }
+  var hashInt big.Int
+  hashInt.SetBytes(hash)
+  if target := CompactToBig(header.Bits); target.Sign() <= 0 || hashInt.Cmp(target) != -1 { // the hash must be below a valid target
+    return errors.New("consensus: the hash does not meet the target")
+  }
+  return nil
+}
+
+// Define a method that checks a block against its parent, the target is checked by the difficulty rules of the chain
+func (ProofOfWork) VerifyHeader(header, parent *Header) error {
+  return nil
+}
+
+// Define a method that computes the work of a block: the expected number of hashes needed to meet its target
+func (ProofOfWork) Work(header *Header) *big.Int {
+  target := CompactToBig(header.Bits)
+  if target.Sign() <= 0 {
+    return big.NewInt(0)
+  }
+  denominator := new(big.Int).Add(target, big.NewInt(1)) // 2^256 / (target + 1)
+  return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
+
+// Define a function that encodes a number as 8 big endian bytes
+func intToBytes(num int64) []byte {
+  buff := make([]byte, 8)
+  binary.BigEndian.PutUint64(buff, uint64(num))
+  return buff
+}
+
+// Define a function that decodes a target from its compact form
+// The compact form stores the size of the number in bytes in the high byte and its 3 most significant bytes in the rest
+func CompactToBig(compact uint32) *big.Int {
+  mantissa := int64(compact & 0x007fffff) // the significant bytes, the sign bit is ignored
+  exponent := uint(compact >> 24)          // the size in bytes
+  if exponent <= 3 {
+    return big.NewInt(mantissa >> (8 * (3 - exponent))) // small numbers fit in the mantissa
+  }
+  target := big.NewInt(mantissa)
+  return target.Lsh(target, 8*(exponent-3)) // shift the significant bytes into place
+}
+
+// Define a function that encodes a target in its compact form, keeping its 3 most significant bytes
+func BigToCompact(n *big.Int) uint32 {
+  if n.Sign() <= 0 {
+    return 0
+  }
+  exponent := uint(len(n.Bytes())) // the size in bytes
+  var mantissa uint32
+  if exponent <= 3 {
+    mantissa = uint32(n.Uint64()) << (8 * (3 - exponent))
+  } else {
+    mantissa = uint32(new(big.Int).Rsh(n, 8*(exponent-3)).Uint64()) // keep the 3 most significant bytes
+  }
+  if mantissa&0x00800000 != 0 { // the high bit would read as a sign, move to the next byte
+    mantissa >>= 8
+    exponent++
+  }
+  return uint32(exponent<<24) | mantissa
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+  "networkchain/consensus" // to decode and encode the targets
+  "math/big"               // the targets are 256 bit numbers
+  "time"                   // for the target block time
+)
+
+// create the function that computes the target a block following last must meet
+// Every RetargetInterval blocks of the active network the target is scaled by the time the last interval actually took
+// compared to the time it should have taken, limited to a factor of 4 either way
+// The blocks are found through the parents of last, so it works on side branches too
+func nextBits(lastNode *blockNode) uint32 {
+  last := lastNode.block             // the block the next one will follow
+  nextHeight := lastNode.height + 1   // the height of the next block
+  interval := activeNet.RetargetInterval // the difficulty settings are the same on every node of a network
+  if interval <= 0 || nextHeight%interval != 0 || !activeNet.IsProofOfWork() { // a signed block has no work to adjust
+    return last.Bits // not a retarget block, keep the same difficulty
+  }
+  first := lastNode.ancestor(nextHeight - interval).block // the first block of the interval
+  expected := int64(interval) * int64(activeNet.TargetBlockTime/time.Second) // how long the interval should have taken, in seconds
+  actual := last.Timestamp - first.Timestamp                               // how long it took
+  if actual < expected/4 {                                                 // limit the adjustment so a few bad timestamps cannot swing it
+    actual = expected / 4
+  }
+  if actual > expected*4 {
+    actual = expected * 4
+  }
+  target := consensus.CompactToBig(last.Bits)            // start from the current target
+  target.Mul(target, big.NewInt(actual))        // a slower interval gives a bigger (easier) target
+  target.Div(target, big.NewInt(expected))
+  if powLimit := consensus.CompactToBig(activeNet.PowLimitBits); target.Cmp(powLimit) > 0 { // never go easier than the limit
+    target.Set(powLimit)
+  }
+  return consensus.BigToCompact(target)
+}
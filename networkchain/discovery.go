@@ -0,0 +1,75 @@
+package main
+
+import (
+  "errors"  // for the error of an invalid port
+  "net"     // to resolve the seeds and split the addresses
+  "strconv" // to check the ports
+  "strings" // to strip the brackets of an IPv6 host without a port
+)
+
+// Define a struct for the ways a node finds its first peers
+type Discovery struct {
+  DNSSeeds []string // host names resolving to the addresses of long running nodes
+  AddNodes []string // addresses to connect to in addition to the discovered ones
+  Connect  []string // if set, the only addresses the node talks to: no DNS seeds and no addresses learned from peers
+}
+
+// Define a function to find the bootstrap peers of the node
+func discoverPeers(discovery Discovery) []string {
+  if len(discovery.Connect) > 0 { // if the peers are fixed
+    return withDefaultPorts(discovery.Connect) // use them and nothing else
+  }
+  peers := withDefaultPorts(discovery.AddNodes) // start with the addresses given by the user
+  for _, seed := range discovery.DNSSeeds { // iterate over the DNS seeds
+    hosts, err := net.LookupHost(seed) // resolve the seed
+    if err != nil {
+      netLog.Warn("Failed to resolve DNS seed", "seed", seed, "err", err) // the other seeds may work
+      continue
+    }
+    netLog.Info("Resolved DNS seed", "seed", seed, "count", len(hosts))
+    peers = append(peers, withDefaultPorts(hosts)...) // add the resolved addresses
+  }
+  return peers // return the peers
+}
+
+// Define a function to add the default port to the addresses that do not have one, like the addresses resolved from DNS seeds
+func withDefaultPorts(addresses []string) []string {
+  var result []string // create a buffer for the addresses
+  for _, address := range addresses { // iterate over the addresses
+    if _, _, err := net.SplitHostPort(address); err != nil { // if there is no port
+      address = net.JoinHostPort(strings.Trim(address, "[]"), activeNet.DefaultPort) // add the default one of the network, bracketing an IPv6 host
+    }
+    result = append(result, address) // keep the address
+  }
+  return result // return the addresses
+}
+
+// Define a function to write an address in its canonical form, so an address given or relayed in another way names the
+// same node: an IP host is written the shortest way, IPv6 in brackets and an IPv4-mapped IPv6 as IPv4, a host name in
+// lower case; an IPv6 host without brackets is ambiguous and rejected
+func canonicalAddress(address string) (string, error) {
+  host, port, err := net.SplitHostPort(address)
+  if err != nil {
+    return "", err
+  }
+  if number, err := strconv.ParseUint(port, 10, 16); err != nil || number == 0 {
+    return "", errors.New("invalid port " + strconv.Quote(port))
+  }
+  if ip := net.ParseIP(host); ip != nil {
+    host = ip.String() // the IPv4 form of a mapped address
+  } else {
+    host = strings.ToLower(host)
+  }
+  return net.JoinHostPort(host, port), nil
+}
+
+// Define a function to write addresses in their canonical form, dropping the malformed ones
+func canonicalAddresses(addresses []string) []string {
+  var result []string // create a buffer for the addresses
+  for _, address := range addresses {
+    if canonical, err := canonicalAddress(address); err == nil {
+      result = append(result, canonical)
+    }
+  }
+  return result
+}
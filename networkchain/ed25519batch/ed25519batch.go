@@ -0,0 +1,116 @@
+// Package ed25519batch verifies many Ed25519 signatures at once, which saves about a quarter of the time of verifying them
+// one after the other.
+// A signature (R, s) of the message M is valid for the key A when [8](s*B - R - k*A) is the identity, with B the base
+// point and k = SHA-512(R, A, M): the cofactored equation of ZIP 215, where R and A may be any encoding of a point,
+// non-canonical ones included, and s must be below the order l of the group. This is a deliberate change from the
+// cofactorless check of crypto/ed25519: it accepts every signature the standard library accepts, and also the few
+// whose R or A carries a point of small order, which the standard library refuses. Only the cofactored equation can be
+// checked in a batch, so the single signatures follow it too and a signature never gets a different answer alone and
+// in a batch.
+// A batch of n signatures draws a random 128 bit zi for each and checks the single equation
+// [8](-(sum of zi*si)*B + sum of zi*Ri + sum of (zi*ki)*Ai) = 0, which fails with overwhelming probability when any
+// signature is invalid; the batch then tells nothing of which one, they are verified one by one.
+package ed25519batch
+
+import (
+  "crypto/rand"             // for the coefficients of the batch
+  "crypto/sha512"           // for the challenges
+  "filippo.io/edwards25519" // the arithmetic of the curve
+)
+
+// Define some constants for the sizes of the encodings
+const (
+  PublicKeySize = 32 // an encoded point
+  SignatureSize = 64 // the encoded point R and the scalar s
+)
+
+// Define a struct for a signature waiting in a batch
+type entry struct {
+  pubKey, message, signature []byte
+}
+
+// Define a struct for a batch of signatures to verify together
+type Verifier struct {
+  entries []entry // the signatures added, in order
+}
+
+// Define a method to add a signature of a message to the batch, it is checked with the others by Verify
+func (v *Verifier) Add(pubKey, message, signature []byte) {
+  v.entries = append(v.entries, entry{pubKey, message, signature})
+}
+
+// Define a method to get the number of signatures in the batch
+func (v *Verifier) Len() int {
+  return len(v.entries)
+}
+
+// Define a method to check every signature of the batch at once, true when they are all valid and for an empty batch
+// False means at least one is invalid, the batch does not tell which
+func (v *Verifier) Verify() bool {
+  if len(v.entries) == 0 {
+    return true
+  }
+  random := make([]byte, 16*len(v.entries))
+  if _, err := rand.Read(random); err != nil { // without secret coefficients a forged batch could pass
+    return false
+  }
+  scalars := make([]*edwards25519.Scalar, 0, 2*len(v.entries)+1)
+  points := make([]*edwards25519.Point, 0, 2*len(v.entries)+1)
+  baseScalar := edwards25519.NewScalar() // the sum of the zi*si
+  for i, e := range v.entries {
+    r, a, s, k, ok := parse(e)
+    if !ok {
+      return false
+    }
+    var zBytes [32]byte // 128 bits, far below l, so always a canonical scalar
+    copy(zBytes[:], random[16*i:16*(i+1)])
+    z, _ := edwards25519.NewScalar().SetCanonicalBytes(zBytes[:])
+    baseScalar.MultiplyAdd(z, s, baseScalar)
+    scalars = append(scalars, z, edwards25519.NewScalar().Multiply(z, k))
+    points = append(points, r, a)
+  }
+  scalars = append(scalars, baseScalar.Negate(baseScalar))
+  points = append(points, edwards25519.NewGeneratorPoint())
+  return isSmallOrder(new(edwards25519.Point).VarTimeMultiScalarMult(scalars, points))
+}
+
+// Define a function to tell whether a point becomes the identity times the cofactor 8
+func isSmallOrder(p *edwards25519.Point) bool {
+  return new(edwards25519.Point).MultByCofactor(p).Equal(edwards25519.NewIdentityPoint()) == 1
+}
+
+// Define a function to decode a signature waiting in a batch: its points R and A, its scalar s and its challenge k;
+// false when a point does not decode, s is not below l or the sizes are wrong
+func parse(e entry) (r, a *edwards25519.Point, s, k *edwards25519.Scalar, ok bool) {
+  if len(e.pubKey) != PublicKeySize || len(e.signature) != SignatureSize {
+    return nil, nil, nil, nil, false
+  }
+  r, err := new(edwards25519.Point).SetBytes(e.signature[:32]) // a y above p is taken modulo p, as ZIP 215 allows
+  if err != nil {
+    return nil, nil, nil, nil, false
+  }
+  a, err = new(edwards25519.Point).SetBytes(e.pubKey)
+  if err != nil {
+    return nil, nil, nil, nil, false
+  }
+  s, err = edwards25519.NewScalar().SetCanonicalBytes(e.signature[32:]) // a signature has a single encoding of s
+  if err != nil {
+    return nil, nil, nil, nil, false
+  }
+  h := sha512.New()
+  h.Write(e.signature[:32])
+  h.Write(e.pubKey)
+  h.Write(e.message)
+  k, _ = edwards25519.NewScalar().SetUniformBytes(h.Sum(nil)) // the 64 bytes of the hash, reduced modulo l
+  return r, a, s, k, true
+}
+
+// Define a function to check a single signature by the cofactored equation of the batches
+func Verify(pubKey, message, signature []byte) bool {
+  r, a, s, k, ok := parse(entry{pubKey, message, signature})
+  if !ok {
+    return false
+  }
+  check := new(edwards25519.Point).VarTimeDoubleScalarBaseMult(k, new(edwards25519.Point).Negate(a), s) // s*B - k*A
+  return isSmallOrder(check.Subtract(check, r))
+}
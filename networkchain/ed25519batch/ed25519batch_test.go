@@ -0,0 +1,141 @@
+package ed25519batch
+
+import (
+  "bytes"                   // for the nonce of the torsion signature
+  "crypto/ed25519"          // to make the signatures
+  "crypto/sha512"           // to sign with a torsion point
+  "encoding/hex"            // for the known points
+  "filippo.io/edwards25519" // to build the torsion signature
+  "fmt"                     // to name the cases
+  "testing"                 // the test framework
+)
+
+// Define a function to make n keys and their signatures of distinct messages
+func signatures(t *testing.T, n int) (pubKeys, messages, sigs [][]byte) {
+  t.Helper()
+  for i := 0; i < n; i++ {
+    public, private, err := ed25519.GenerateKey(nil)
+    if err != nil {
+      t.Fatal(err)
+    }
+    message := []byte(fmt.Sprintf("message %d", i))
+    pubKeys = append(pubKeys, public)
+    messages = append(messages, message)
+    sigs = append(sigs, ed25519.Sign(private, message))
+  }
+  return pubKeys, messages, sigs
+}
+
+// Define a function to flip a bit of a copy of some bytes
+func flipped(b []byte, bit int) []byte {
+  c := append([]byte{}, b...)
+  c[bit/8] ^= 1 << (bit % 8)
+  return c
+}
+
+func TestVerify(t *testing.T) {
+  pubKeys, messages, sigs := signatures(t, 1)
+  tests := []struct {
+    name      string
+    pubKey    []byte
+    message   []byte
+    signature []byte
+    valid     bool
+  }{
+    {"valid", pubKeys[0], messages[0], sigs[0], true},
+    {"other message", pubKeys[0], []byte("other"), sigs[0], false},
+    {"flipped R", pubKeys[0], messages[0], flipped(sigs[0], 3), false},
+    {"flipped s", pubKeys[0], messages[0], flipped(sigs[0], 300), false},
+    {"s above l", pubKeys[0], messages[0], flipped(sigs[0], 511), false},
+    {"short signature", pubKeys[0], messages[0], sigs[0][:63], false},
+    {"short key", pubKeys[0][:31], messages[0], sigs[0], false},
+  }
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      if got := Verify(test.pubKey, test.message, test.signature); got != test.valid {
+        t.Errorf("Verify = %v, want %v", got, test.valid)
+      }
+      var batch Verifier
+      batch.Add(test.pubKey, test.message, test.signature)
+      if got := batch.Verify(); got != test.valid {
+        t.Errorf("batch Verify = %v, want %v", got, test.valid)
+      }
+    })
+  }
+}
+
+func TestBatch(t *testing.T) {
+  pubKeys, messages, sigs := signatures(t, 20)
+  tests := []struct {
+    name  string
+    bad   int // the signature tampered with, -1 for none
+    valid bool
+  }{
+    {"all valid", -1, true},
+    {"first invalid", 0, false},
+    {"middle invalid", 10, false},
+    {"last invalid", 19, false},
+  }
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      var batch Verifier
+      for i := range sigs {
+        message := messages[i]
+        if i == test.bad {
+          message = []byte("forged")
+        }
+        batch.Add(pubKeys[i], message, sigs[i])
+      }
+      if batch.Len() != len(sigs) {
+        t.Fatalf("Len = %d, want %d", batch.Len(), len(sigs))
+      }
+      if got := batch.Verify(); got != test.valid {
+        t.Errorf("Verify = %v, want %v", got, test.valid)
+      }
+    })
+  }
+  var empty Verifier
+  if !empty.Verify() {
+    t.Error("an empty batch is not valid")
+  }
+}
+
+// A signature whose R has a point of order 8 added is refused by the standard library and accepted by the cofactored
+// rule, alone and in a batch alike
+func TestTorsion(t *testing.T) {
+  seed := make([]byte, ed25519.SeedSize)
+  private := ed25519.NewKeyFromSeed(seed)
+  public := private.Public().(ed25519.PublicKey)
+  digest := sha512.Sum512(seed)
+  a, _ := edwards25519.NewScalar().SetBytesWithClamping(digest[:32]) // the secret scalar of the key
+  torsion, _ := hex.DecodeString("c7176a703d4dd84fba3c0b760d10670f2a2053fa2c39ccc64ec7fd7792ac037a") // a point of order 8
+  t8, err := new(edwards25519.Point).SetBytes(torsion)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !isSmallOrder(t8) || t8.Equal(edwards25519.NewIdentityPoint()) == 1 {
+    t.Fatal("the torsion point is not of small order")
+  }
+  nonce, _ := edwards25519.NewScalar().SetUniformBytes(bytes.Repeat([]byte{7}, 64))
+  r := new(edwards25519.Point).ScalarBaseMult(nonce)
+  rBytes := r.Add(r, t8).Bytes()
+  message := []byte("torsion")
+  h := sha512.New()
+  h.Write(rBytes)
+  h.Write(public)
+  h.Write(message)
+  k, _ := edwards25519.NewScalar().SetUniformBytes(h.Sum(nil))
+  s := edwards25519.NewScalar().MultiplyAdd(k, a, nonce) // s = r + k*a
+  sig := append(rBytes, s.Bytes()...)
+  if ed25519.Verify(public, message, sig) {
+    t.Fatal("the standard library accepts the torsion signature")
+  }
+  if !Verify(public, message, sig) {
+    t.Error("Verify refuses the torsion signature")
+  }
+  var batch Verifier
+  batch.Add(public, message, sig)
+  if !batch.Verify() {
+    t.Error("the batch refuses the torsion signature")
+  }
+}
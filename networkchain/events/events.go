@@ -0,0 +1,99 @@
+// Package events lets the chain and the node announce what happens to them without knowing who listens.
+// The miner, the wallet, the relay of the transactions and the servers subscribe to the events they follow instead of
+// being called by the code that causes them. Subscribers get their own buffered channel; a subscriber that does not
+// keep up loses events instead of slowing the chain down.
+package events
+
+import (
+  "sync" // the bus is shared by the chain and the subscribers
+)
+
+// Define the types of the events published by the chain and the node
+const (
+  TipChanged       = "newBlock"         // a block was connected to the main chain, the data is the block
+  TxAccepted       = "newTx"            // a transaction entered the mempool, the data is the transaction
+  ReorgDetected    = "reorg"            // the main chain switched to another branch, the data describes both branches
+  SyncProgress     = "syncProgress"     // the node downloaded more blocks of the best chain, or caught up with its peers
+  PeerConnected    = "peerConnected"    // a peer completed the handshake, the data tells its address and direction
+  PeerDisconnected = "peerDisconnected" // a peer was dropped, evicted or stopped answering
+)
+
+// Define the default number of events buffered for a subscriber
+const DefaultBuffer = 64
+
+// Define a struct for an event
+type Event struct {
+  Type string      // one of the event types
+  Data interface{} // the block, transaction or reorganization, as published by the chain, the progress of the sync or the peer
+}
+
+// Define a struct for the event bus
+type Bus struct {
+  mu          sync.Mutex                 // the lock protecting the subscriptions
+  subscribers map[*Subscription]struct{} // the active subscriptions
+}
+
+// Define a struct for a subscription
+type Subscription struct {
+  C       chan Event      // the channel receiving the events, closed when the subscription is cancelled
+  bus     *Bus            // the bus the subscription belongs to
+  types   map[string]bool // the types of the events received, every type if nil
+  dropped int             // the number of events lost because the channel was full
+}
+
+// Define a function to create a bus
+func New() *Bus {
+  return &Bus{subscribers: map[*Subscription]struct{}{}}
+}
+
+// Define a method to subscribe to the events of some types, or to every event if none is given, buffering at most
+// buffer events
+func (b *Bus) Subscribe(buffer int, types ...string) *Subscription {
+  s := &Subscription{C: make(chan Event, buffer), bus: b}
+  if len(types) > 0 {
+    s.types = map[string]bool{}
+    for _, t := range types {
+      s.types[t] = true
+    }
+  }
+  b.mu.Lock()
+  b.subscribers[s] = struct{}{}
+  b.mu.Unlock()
+  return s
+}
+
+// Define a method to publish an event to every subscriber without waiting for them
+func (b *Bus) Publish(e Event) {
+  if b == nil { // a chain without a bus publishes nothing
+    return
+  }
+  b.mu.Lock()
+  defer b.mu.Unlock()
+  for s := range b.subscribers {
+    if s.types != nil && !s.types[e.Type] { // the subscriber does not follow this type
+      continue
+    }
+    select {
+    case s.C <- e:
+    default: // the subscriber is too slow, drop the event
+      s.dropped++
+    }
+  }
+}
+
+// Define a method to stop receiving events
+func (s *Subscription) Cancel() {
+  s.bus.mu.Lock()
+  defer s.bus.mu.Unlock()
+  if _, ok := s.bus.subscribers[s]; ok {
+    delete(s.bus.subscribers, s)
+    close(s.C)
+  }
+}
+
+// Define a method to get the number of events lost by the subscription
+func (s *Subscription) Dropped() int {
+  s.bus.mu.Lock()
+  defer s.bus.mu.Unlock()
+  return s.dropped
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+  "errors"    // for the error of a cut connection
+  "math/rand" // to draw the faults
+  "net"       // the connections are wrapped
+  "sync"      // the injector is shared by the senders of a node
+  "time"      // for the delays
+)
+
+// The error returned by a connection cut halfway through a message
+var errInjectedDisconnect = errors.New("connection cut by the fault injector")
+
+// Define a struct for the faults injected into the messages a node sends, to exercise the sync and the reorganizations
+// of a simulated network under adverse conditions; the zero value injects nothing
+type Faults struct {
+  Latency    time.Duration // the delay of every message
+  Jitter     time.Duration // a random extra delay up to this, so the messages to a peer can arrive out of order
+  Loss       float64       // the probability a message is dropped
+  Disconnect float64       // the probability the connection is cut halfway through a message
+}
+
+// Define a struct for the fault injector of a simulated node, sitting between the node and its connections
+type faultInjector struct {
+  mu      sync.Mutex      // the lock protecting the fields below
+  faults  Faults          // the faults injected
+  random  *rand.Rand      // the source of the faults
+  blocked map[string]bool // the peers the node cannot reach, the other side of a partition
+}
+
+// Define a function to create an injector injecting nothing yet
+func newFaultInjector() *faultInjector {
+  return &faultInjector{random: rand.New(rand.NewSource(time.Now().UnixNano())), blocked: map[string]bool{}}
+}
+
+// Define a method to change the faults injected
+func (f *faultInjector) set(faults Faults) {
+  f.mu.Lock() // lock the injector
+  defer f.mu.Unlock() // unlock it when done
+  f.faults = faults
+}
+
+// Define a method to make a peer unreachable or reachable again
+func (f *faultInjector) block(address string, blocked bool) {
+  f.mu.Lock() // lock the injector
+  defer f.mu.Unlock() // unlock it when done
+  if blocked {
+    f.blocked[address] = true
+  } else {
+    delete(f.blocked, address)
+  }
+}
+
+// Define a method to draw the fate of a message to a peer: whether it is sent, and after which delay
+func (f *faultInjector) plan(address string) (bool, time.Duration) {
+  f.mu.Lock() // lock the injector, the random source is not safe for concurrent use
+  defer f.mu.Unlock() // unlock it when done
+  if f.blocked[address] || f.random.Float64() < f.faults.Loss {
+    return false, 0
+  }
+  delay := f.faults.Latency
+  if f.faults.Jitter > 0 {
+    delay += time.Duration(f.random.Int63n(int64(f.faults.Jitter)))
+  }
+  return true, delay
+}
+
+// Define a method to wrap a connection to a peer, so it may be cut halfway through the message
+func (f *faultInjector) wrap(conn net.Conn) net.Conn {
+  f.mu.Lock() // lock the injector
+  defer f.mu.Unlock() // unlock it when done
+  if f.random.Float64() < f.faults.Disconnect {
+    return &cutConn{conn}
+  }
+  return conn
+}
+
+// Define a struct for a connection that is cut halfway through the first message written to it
+type cutConn struct {
+  net.Conn // the connection
+}
+
+// Define a method to write half of the data and close the connection
+func (c *cutConn) Write(data []byte) (int, error) {
+  written, _ := c.Conn.Write(data[:len(data)/2])
+  c.Conn.Close()
+  return written, errInjectedDisconnect
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+  "sort" // to find the bucket of a feerate
+  "sync" // for the lock of the estimator
+)
+
+// Define some constants of the fee estimation
+const (
+  feeMaxTarget      = 25    // the deepest confirmation target estimated, in blocks
+  feeDecay          = 0.998 // the weight the data keeps at each block, so the estimates follow the recent blocks
+  feeSuccessRatio   = 0.85  // the share of the transactions of a feerate that must confirm within the target
+  feeMinSamples     = 2     // the weight of transactions a group of buckets needs before it is judged
+  defaultConfTarget = 6     // the blocks the wallet gives its payments to confirm
+)
+
+// Define the lower bounds of the feerate buckets, in coins per feeRateUnit bytes, a transaction falls in the highest one
+// its feerate reaches, or in the first one if it pays less
+var feeBuckets = []float64{1, 2, 3, 4, 5, 7, 10, 15, 20, 30, 50, 70, 100, 150, 200, 300, 500, 700, 1000, 1500, 2000, 3000, 5000, 10000}
+
+// Define a struct for a mempool transaction followed until it is mined
+type trackedTx struct {
+  height int // the height of the tip when it entered the mempool
+  bucket int // the bucket of its feerate
+}
+
+// Define a struct for the fee estimator of a chain
+// Every transaction entering the mempool is followed until a block mines it, and the blocks it waited are counted in the
+// bucket of its feerate; the estimate for a target is the lowest feerate whose transactions, and the ones of every
+// higher feerate, mostly confirmed within the target, the transactions still waiting longer counting as failures
+// The data of older blocks fades so the estimates follow the demand; nothing is stored, a restarted node learns again
+type feeEstimator struct {
+  mu        sync.Mutex           // the lock protecting the estimator
+  tracked   map[string]trackedTx // the mempool transactions followed, by hex ID
+  confirmed [][]float64          // the weight of the transactions of each bucket mined within each target, target 1 first
+  mined     []float64            // the weight of the transactions of each bucket mined
+  height    int                  // the height of the last block counted
+}
+
+// Define a function to create an estimator with no data
+func newFeeEstimator() *feeEstimator {
+  e := &feeEstimator{tracked: map[string]trackedTx{}, confirmed: make([][]float64, len(feeBuckets)), mined: make([]float64, len(feeBuckets))}
+  for b := range e.confirmed {
+    e.confirmed[b] = make([]float64, feeMaxTarget)
+  }
+  return e
+}
+
+// Define a function to find the bucket of a feerate
+func feeBucket(feeRate float64) int {
+  if b := sort.Search(len(feeBuckets), func(b int) bool { return feeBuckets[b] > feeRate }); b > 0 {
+    return b - 1
+  }
+  return 0
+}
+
+// Define a method to follow a transaction that entered the mempool when the tip was at a height, at a feerate in coins
+// per feeRateUnit bytes
+func (e *feeEstimator) track(id string, feeRate float64, height int) {
+  e.mu.Lock() // lock the estimator
+  defer e.mu.Unlock() // unlock it when done
+  e.tracked[id] = trackedTx{height, feeBucket(feeRate)}
+}
+
+// Define a method to count the transactions a block at a height mined, once per height, and to stop following the
+// transactions waiting for so long they will never count
+func (e *feeEstimator) processBlock(height int, ids []string) {
+  e.mu.Lock() // lock the estimator
+  defer e.mu.Unlock() // unlock it when done
+  counted := height > e.height // a block replacing one in a reorganization is not counted again
+  if counted {
+    e.height = height
+    for b := range e.mined { // the older blocks weigh less
+      e.mined[b] *= feeDecay
+      for t := range e.confirmed[b] {
+        e.confirmed[b][t] *= feeDecay
+      }
+    }
+  }
+  for _, id := range ids {
+    tx, ok := e.tracked[id]
+    if !ok { // it never went through the mempool
+      continue
+    }
+    delete(e.tracked, id)
+    if !counted || height <= tx.height {
+      continue
+    }
+    e.mined[tx.bucket]++
+    for t := height - tx.height; t <= feeMaxTarget; t++ { // it confirmed within every target from the blocks it waited
+      e.confirmed[tx.bucket][t-1]++
+    }
+  }
+  for id, tx := range e.tracked { // evicted or replaced long ago
+    if height-tx.height > 2*feeMaxTarget {
+      delete(e.tracked, id)
+    }
+  }
+}
+
+// Define a method to estimate the feerate in coins per feeRateUnit bytes a transaction needs to be mined within a number
+// of blocks, false without enough data
+func (e *feeEstimator) estimate(target int) (int, bool) {
+  if target < 1 {
+    target = 1
+  }
+  if target > feeMaxTarget {
+    target = feeMaxTarget
+  }
+  e.mu.Lock() // lock the estimator
+  defer e.mu.Unlock() // unlock it when done
+  waiting := make([]float64, len(feeBuckets)) // the transactions of each bucket that already missed the target
+  for _, tx := range e.tracked {
+    if e.height-tx.height >= target {
+      waiting[tx.bucket]++
+    }
+  }
+  best := -1 // the lowest bucket passing
+  var confirmed, total float64 // the weights of the group of buckets judged
+  for b := len(feeBuckets) - 1; b >= 0; b-- { // from the highest feerate down, grouping the buckets with few transactions
+    confirmed += e.confirmed[b][target-1]
+    total += e.mined[b] + waiting[b]
+    if total < feeMinSamples {
+      continue
+    }
+    if confirmed/total < feeSuccessRatio { // the lower feerates wait longer
+      break
+    }
+    best, confirmed, total = b, 0, 0
+  }
+  if best < 0 {
+    return 0, false
+  }
+  return int(feeBuckets[best]), true
+}
+
+// create the method that estimates the feerate in coins per feeRateUnit bytes a transaction needs to be mined within a
+// number of blocks, false while the node saw too few transactions mined
+func (blockchain *Blockchain) EstimateFee(target int) (int, bool) {
+  return blockchain.fees.estimate(target)
+}
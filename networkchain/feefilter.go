@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Define how often the node checks whether the feerate its mempool accepts moved, to tell its peers
+const feeFilterInterval = 1 * time.Minute
+
+// Define a method to get the feerate the node tells its peers, in coins per feeRateUnit bytes: the lowest its mempool
+// accepts, rounded down so no transaction it would take is held back
+func (n *Node) feeFilterRate() int {
+  return int(n.bc.Mempool.MinFeeRate() * feeRateUnit)
+}
+
+// Define a method to send a feefilter command to a node, the older nodes do not know it
+func (n *Node) sendFeeFilter(address string, feeRate int) {
+  if version, _ := n.negotiatedVersion(address); version < feeFilterVersion {
+    return
+  }
+  payload := encodePayload(FeeFilter{n.address, feeRate}) // encode the feefilter struct into a payload
+  message := encodeMessage(cmdFeeFilter, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
+}
+
+// Define a method to handle a feefilter command from a node
+func (n *Node) handleFeeFilter(from sender, request []byte) {
+  var payload FeeFilter // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
+  peerAddress := payload.AddrFrom // get the peer address
+  if payload.FeeRate < 0 { // no transaction pays less than nothing
+    n.misbehaving(from, malformedScore, fmt.Errorf("feefilter of %d", payload.FeeRate))
+    return
+  }
+  n.mu.Lock() // lock the peer state
+  n.feeFilters[peerAddress] = payload.FeeRate // announce it only the transactions paying as much from now on
+  n.mu.Unlock() // unlock it
+  netLog.Debug("Received fee filter", "peer", peerAddress, "feerate", payload.FeeRate)
+}
+
+// Define a method to check whether a transaction of the mempool pays the feerate a peer asked for, the peers that asked
+// for none want them all
+func (n *Node) paysFeeFilter(address string, tx *Transaction) bool {
+  n.mu.Lock() // lock the peer state
+  feeRate, ok := n.feeFilters[address] // get the filter of the peer
+  n.mu.Unlock() // unlock it
+  if !ok || feeRate == 0 {
+    return true
+  }
+  entry := n.bc.Mempool.Get(hex.EncodeToString(tx.ID)) // the pool knows the fee it pays
+  return entry == nil || entry.FeeRate()*feeRateUnit >= float64(feeRate)
+}
+
+// Define a method to tell the peers the feerate the mempool accepts each time it moves until the node stops, a full
+// mempool raises it and it falls back once the pressure is gone; the peers get it when they connect too
+func (n *Node) feeFilterLoop() {
+  ticker := time.NewTicker(feeFilterInterval) // create a ticker for the interval
+  defer ticker.Stop() // stop it when done
+  sent := n.feeFilterRate() // the feerate the peers were told when they connected
+  for {
+    select {
+    case <-n.quit: // the node stopped
+      return
+    case <-ticker.C: // on every tick
+      feeRate := n.feeFilterRate()
+      if feeRate == sent { // the peers know it already
+        continue
+      }
+      netLog.Info("Telling the peers the new minimum feerate", "feerate", feeRate, "previous", sent)
+      for _, peer := range n.peers() { // iterate over the known nodes
+        n.sendFeeFilter(peer, feeRate)
+      }
+      sent = feeRate
+    }
+  }
+}
@@ -0,0 +1,235 @@
+// Package gcs implements the Golomb-coded sets of the compact block filters, following BIP158.
+// The elements are hashed to numbers below N*M, sorted, and the differences between consecutive
+// numbers are written with a Golomb-Rice code; a filter is about half the size of a bloom filter
+// with the same false positive rate, 1/M. It answers "maybe" for every element of the set and
+// "no" for nearly everything else, and anyone can check it against the block it was built from.
+package gcs
+
+import (
+  "encoding/binary" // to read the key and encode the number of elements
+  "errors"          // for the parsing errors
+  "math/bits"       // to map the hashes to the range of the set
+  "sort"            // to sort the hashed elements
+)
+
+// Define the parameters of the filters, the ones of the basic filter of BIP158
+const (
+  P       = 19     // the number of bits of the remainder of a Golomb-Rice code
+  M       = 784931 // the inverse of the false positive rate
+  KeySize = 16     // the length of the key of a filter, the first bytes of the hash of its block
+)
+
+// The most elements a filter may hold, far more than a block has scripts
+const MaxElements = 1 << 24
+
+// Define an error returned for a filter that cannot be read or breaks the limits
+var ErrMalformed = errors.New("gcs: malformed filter")
+
+// Define a struct for a filter
+type Filter struct {
+  n    uint32 // the number of elements
+  data []byte // the Golomb-Rice codes of the differences between the sorted hashes
+}
+
+// Define a function to build the filter of a set of elements under a key, the duplicates are counted once
+func Build(key [KeySize]byte, elements [][]byte) (*Filter, error) {
+  unique := map[string]bool{}
+  for _, element := range elements {
+    unique[string(element)] = true
+  }
+  if len(unique) > MaxElements {
+    return nil, ErrMalformed
+  }
+  f := &Filter{n: uint32(len(unique))}
+  hashes := make([]uint64, 0, len(unique))
+  for element := range unique {
+    hashes = append(hashes, f.hash(key, []byte(element)))
+  }
+  sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+  var w bitWriter
+  last := uint64(0)
+  for _, hash := range hashes {
+    delta := hash - last
+    last = hash
+    for q := delta >> P; q > 0; q-- { // the quotient in unary: ones closed by a zero
+      w.writeBit(1)
+    }
+    w.writeBit(0)
+    w.writeBits(delta, P) // the remainder
+  }
+  f.data = w.bytes
+  return f, nil
+}
+
+// Define a function to rebuild a filter from its serialized form
+func Parse(data []byte) (*Filter, error) {
+  n, size := binary.Uvarint(data)
+  if size <= 0 || n > MaxElements {
+    return nil, ErrMalformed
+  }
+  data = data[size:]
+  if n*(P+1) > uint64(len(data))*8 { // every element takes at least a zero and its remainder
+    return nil, ErrMalformed
+  }
+  return &Filter{uint32(n), append([]byte{}, data...)}, nil
+}
+
+// Define a method to serialize the filter: the number of elements as a varint, then the codes
+func (f *Filter) Bytes() []byte {
+  data := binary.AppendUvarint(nil, uint64(f.n))
+  return append(data, f.data...)
+}
+
+// Define a method to get the number of elements of the filter
+func (f *Filter) N() int {
+  return int(f.n)
+}
+
+// Define a method to hash an element under a key to a number below N*M: SipHash-2-4 of the element, scaled to the range
+// with a multiplication rather than a modulo
+func (f *Filter) hash(key [KeySize]byte, element []byte) uint64 {
+  k0, k1 := binary.LittleEndian.Uint64(key[:8]), binary.LittleEndian.Uint64(key[8:])
+  hi, _ := bits.Mul64(sipHash(k0, k1, element), uint64(f.n)*M)
+  return hi
+}
+
+// Define a method to check whether an element may be in the filter
+func (f *Filter) Match(key [KeySize]byte, element []byte) bool {
+  return f.MatchAny(key, [][]byte{element})
+}
+
+// Define a method to check whether any of some elements may be in the filter
+// The hashes of the elements are sorted and walked along the filter, so it is decoded once whatever their number
+func (f *Filter) MatchAny(key [KeySize]byte, elements [][]byte) bool {
+  if f.n == 0 || len(elements) == 0 {
+    return false
+  }
+  wanted := make([]uint64, len(elements))
+  for i, element := range elements {
+    wanted[i] = f.hash(key, element)
+  }
+  sort.Slice(wanted, func(i, j int) bool { return wanted[i] < wanted[j] })
+  r := bitReader{data: f.data}
+  value := uint64(0)
+  for i := uint32(0); i < f.n; i++ {
+    delta, ok := r.readDelta()
+    if !ok { // a truncated filter matches nothing more
+      return false
+    }
+    value += delta
+    for len(wanted) > 0 && wanted[0] < value {
+      wanted = wanted[1:]
+    }
+    if len(wanted) == 0 {
+      return false
+    }
+    if wanted[0] == value {
+      return true
+    }
+  }
+  return false
+}
+
+// Define a struct to write bits, most significant first
+type bitWriter struct {
+  bytes []byte // the bytes written, the last one possibly partial
+  used  uint8  // the bits used in the last byte, 0 when it is full or there is none
+}
+
+// Define a method to write one bit
+func (w *bitWriter) writeBit(bit byte) {
+  if w.used == 0 {
+    w.bytes = append(w.bytes, 0)
+  }
+  w.bytes[len(w.bytes)-1] |= bit << (7 - w.used)
+  w.used = (w.used + 1) % 8
+}
+
+// Define a method to write the low bits of a number
+func (w *bitWriter) writeBits(value uint64, count int) {
+  for i := count - 1; i >= 0; i-- {
+    w.writeBit(byte(value >> uint(i) & 1))
+  }
+}
+
+// Define a struct to read bits written by a bitWriter
+type bitReader struct {
+  data []byte // the bytes to read
+  pos  int    // the position of the next bit
+}
+
+// Define a method to read one bit, false past the end
+func (r *bitReader) readBit() (byte, bool) {
+  if r.pos >= len(r.data)*8 {
+    return 0, false
+  }
+  bit := r.data[r.pos/8] >> (7 - r.pos%8) & 1
+  r.pos++
+  return bit, true
+}
+
+// Define a method to read a Golomb-Rice code, false past the end
+func (r *bitReader) readDelta() (uint64, bool) {
+  quotient := uint64(0)
+  for {
+    bit, ok := r.readBit()
+    if !ok {
+      return 0, false
+    }
+    if bit == 0 {
+      break
+    }
+    quotient++
+  }
+  remainder := uint64(0)
+  for i := 0; i < P; i++ {
+    bit, ok := r.readBit()
+    if !ok {
+      return 0, false
+    }
+    remainder = remainder<<1 | uint64(bit)
+  }
+  return quotient<<P | remainder, true
+}
+
+// Define a function to compute SipHash-2-4 of data with a 128-bit key, the keyed hash of BIP158
+func sipHash(k0, k1 uint64, data []byte) uint64 {
+  v0, v1 := k0^0x736f6d6570736575, k1^0x646f72616e646f6d
+  v2, v3 := k0^0x6c7967656e657261, k1^0x7465646279746573
+  round := func() {
+    v0 += v1
+    v1 = bits.RotateLeft64(v1, 13)
+    v1 ^= v0
+    v0 = bits.RotateLeft64(v0, 32)
+    v2 += v3
+    v3 = bits.RotateLeft64(v3, 16)
+    v3 ^= v2
+    v0 += v3
+    v3 = bits.RotateLeft64(v3, 21)
+    v3 ^= v0
+    v2 += v1
+    v1 = bits.RotateLeft64(v1, 17)
+    v1 ^= v2
+    v2 = bits.RotateLeft64(v2, 32)
+  }
+  last := uint64(len(data)) << 56 // the length goes in the top byte of the last word
+  for ; len(data) >= 8; data = data[8:] {
+    m := binary.LittleEndian.Uint64(data)
+    v3 ^= m
+    round()
+    round()
+    v0 ^= m
+  }
+  for i, b := range data {
+    last |= uint64(b) << (8 * uint(i))
+  }
+  v3 ^= last
+  round()
+  round()
+  v0 ^= last
+  v2 ^= 0xff
+  for i := 0; i < 4; i++ {
+    round()
+  }
+  return v0 ^ v1 ^ v2 ^ v3
+}
@@ -1,3 +1,25 @@
-module main
+module networkchain
 
 go 1.19
+
+require (
+	filippo.io/edwards25519 v1.0.0
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.2.0
+	github.com/gorilla/websocket v1.5.0
+	github.com/spf13/cobra v1.7.0
+	github.com/spf13/pflag v1.0.5
+	go.etcd.io/bbolt v1.3.7
+	golang.org/x/crypto v0.14.0
+	golang.org/x/net v0.12.0
+	golang.org/x/text v0.13.0
+	google.golang.org/grpc v1.58.3
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20230711160842-782d3b101e98 // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
+)
@@ -0,0 +1,263 @@
+// Package grpcapi serves the gRPC API of a node, for backend services that prefer typed clients.
+// The service is described in node.proto; the descriptor and the client are written by hand
+// on top of the messages of this package.
+package grpcapi
+
+import (
+  "context" // the context of the calls
+  "errors"  // for the errors of the backend
+  "net"     // to listen for clients
+
+  "google.golang.org/grpc"                      // the RPC framework
+  "google.golang.org/grpc/codes"                // the status codes of the errors
+  "google.golang.org/grpc/credentials/insecure" // the default transport of the client
+  "google.golang.org/grpc/status"               // to return errors with a code
+)
+
+// Define the full name of the service, as declared in node.proto
+const serviceName = "networkchain.Node"
+
+// Define the errors a backend returns to pick the status code
+var (
+  ErrNotFound = errors.New("grpcapi: not found")
+  ErrRejected = errors.New("grpcapi: rejected")
+)
+
+// Define an interface for the node behind the service
+type Backend interface {
+  BestHeight() int                              // the height of the main chain
+  BlockByHash(hash []byte) (*Block, error)      // any known block, ErrNotFound if unknown
+  BlockByHeight(height int) (*Block, error)     // a main chain block, ErrNotFound above the tip
+  SubmitTransaction(raw []byte) ([]byte, error) // check, add and relay a serialized transaction, returning its ID
+  SubscribeBlocks() (<-chan *Block, func())     // the blocks connected to the main chain, and a function to stop receiving them
+}
+
+// Define the descriptor of the service
+var serviceDesc = grpc.ServiceDesc{
+  ServiceName: serviceName,
+  HandlerType: (*Backend)(nil),
+  Methods: []grpc.MethodDesc{
+    {MethodName: "GetBlockCount", Handler: getBlockCountHandler},
+    {MethodName: "GetBlock", Handler: getBlockHandler},
+    {MethodName: "SubmitTransaction", Handler: submitTransactionHandler},
+  },
+  Streams: []grpc.StreamDesc{
+    {StreamName: "SubscribeBlocks", Handler: subscribeBlocksHandler, ServerStreams: true},
+  },
+  Metadata: "node.proto",
+}
+
+// Define a struct for the server
+type Server struct {
+  grpc *grpc.Server // the underlying server
+}
+
+// Define a function to create a server for a backend
+func NewServer(backend Backend, options ...grpc.ServerOption) *Server {
+  options = append(options, grpc.ForceServerCodec(protoCodec{})) // the messages are not generated code
+  server := grpc.NewServer(options...)
+  server.RegisterService(&serviceDesc, backend)
+  return &Server{server}
+}
+
+// Define a method to serve the clients on an address until the server stops
+func (s *Server) ListenAndServe(address string) error {
+  ln, err := net.Listen("tcp", address)
+  if err != nil {
+    return err
+  }
+  return s.grpc.Serve(ln)
+}
+
+// Define a method to stop the server, closing the streams
+func (s *Server) Stop() {
+  s.grpc.Stop()
+}
+
+// Define a function to convert a backend error to a gRPC status
+func toStatus(err error) error {
+  switch {
+  case err == nil:
+    return nil
+  case errors.Is(err, ErrNotFound):
+    return status.Error(codes.NotFound, err.Error())
+  case errors.Is(err, ErrRejected):
+    return status.Error(codes.InvalidArgument, err.Error())
+  default:
+    return status.Error(codes.Internal, err.Error())
+  }
+}
+
+// Define a function to serve GetBlockCount
+func getBlockCountHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+  in := new(GetBlockCountRequest)
+  if err := dec(in); err != nil {
+    return nil, err
+  }
+  handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+    return &GetBlockCountResponse{int64(srv.(Backend).BestHeight())}, nil
+  }
+  if interceptor == nil {
+    return handler(ctx, in)
+  }
+  return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/GetBlockCount"}, handler)
+}
+
+// Define a function to serve GetBlock
+func getBlockHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+  in := new(GetBlockRequest)
+  if err := dec(in); err != nil {
+    return nil, err
+  }
+  handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+    r := req.(*GetBlockRequest)
+    var block *Block
+    var err error
+    if len(r.Hash) > 0 {
+      block, err = srv.(Backend).BlockByHash(r.Hash)
+    } else {
+      block, err = srv.(Backend).BlockByHeight(int(r.Height))
+    }
+    return block, toStatus(err)
+  }
+  if interceptor == nil {
+    return handler(ctx, in)
+  }
+  return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/GetBlock"}, handler)
+}
+
+// Define a function to serve SubmitTransaction
+func submitTransactionHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+  in := new(SubmitTransactionRequest)
+  if err := dec(in); err != nil {
+    return nil, err
+  }
+  handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+    txid, err := srv.(Backend).SubmitTransaction(req.(*SubmitTransactionRequest).Transaction)
+    if err != nil {
+      return nil, toStatus(err)
+    }
+    return &SubmitTransactionResponse{txid}, nil
+  }
+  if interceptor == nil {
+    return handler(ctx, in)
+  }
+  return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/SubmitTransaction"}, handler)
+}
+
+// Define a function to serve SubscribeBlocks: the main chain from the requested height, then the new blocks
+func subscribeBlocksHandler(srv interface{}, stream grpc.ServerStream) error {
+  in := new(SubscribeBlocksRequest)
+  if err := stream.RecvMsg(in); err != nil {
+    return err
+  }
+  backend := srv.(Backend)
+  blocks, cancel := backend.SubscribeBlocks() // subscribe first, so no block is missed during the catch up
+  defer cancel()
+  sent := map[string]bool{} // the blocks sent during the catch up, they may also arrive from the subscription
+  if in.FromHeight >= 0 {
+    for height := int(in.FromHeight); height <= backend.BestHeight(); height++ {
+      block, err := backend.BlockByHeight(height)
+      if err != nil {
+        return toStatus(err)
+      }
+      if err := stream.SendMsg(block); err != nil {
+        return err
+      }
+      sent[string(block.Hash)] = true
+    }
+  }
+  for {
+    select {
+    case <-stream.Context().Done(): // the client left
+      return nil
+    case block, ok := <-blocks:
+      if !ok {
+        return status.Error(codes.Unavailable, "the node stopped the subscription")
+      }
+      if sent[string(block.Hash)] {
+        continue
+      }
+      if err := stream.SendMsg(block); err != nil {
+        return err
+      }
+    }
+  }
+}
+
+// Define a struct for a client of the service
+type Client struct {
+  conn *grpc.ClientConn // the connection to the node
+}
+
+// Define a function to connect to a node, without TLS unless transport credentials are given
+func Dial(address string, options ...grpc.DialOption) (*Client, error) {
+  options = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, options...)
+  options = append(options, grpc.WithDefaultCallOptions(grpc.ForceCodec(protoCodec{})))
+  conn, err := grpc.Dial(address, options...)
+  if err != nil {
+    return nil, err
+  }
+  return &Client{conn}, nil
+}
+
+// Define a method to close the connection
+func (c *Client) Close() error {
+  return c.conn.Close()
+}
+
+// Define a method to get the height of the main chain
+func (c *Client) GetBlockCount(ctx context.Context) (int64, error) {
+  out := new(GetBlockCountResponse)
+  if err := c.conn.Invoke(ctx, "/"+serviceName+"/GetBlockCount", &GetBlockCountRequest{}, out); err != nil {
+    return 0, err
+  }
+  return out.Height, nil
+}
+
+// Define a method to get a block by hash, or by main chain height if the hash is empty
+func (c *Client) GetBlock(ctx context.Context, req *GetBlockRequest) (*Block, error) {
+  out := new(Block)
+  if err := c.conn.Invoke(ctx, "/"+serviceName+"/GetBlock", req, out); err != nil {
+    return nil, err
+  }
+  return out, nil
+}
+
+// Define a method to submit a serialized transaction, returning its ID
+func (c *Client) SubmitTransaction(ctx context.Context, raw []byte) ([]byte, error) {
+  out := new(SubmitTransactionResponse)
+  if err := c.conn.Invoke(ctx, "/"+serviceName+"/SubmitTransaction", &SubmitTransactionRequest{raw}, out); err != nil {
+    return nil, err
+  }
+  return out.Txid, nil
+}
+
+// Define a struct for the stream of blocks of a subscription
+type BlockStream struct {
+  stream grpc.ClientStream // the underlying stream
+}
+
+// Define a method to subscribe to the blocks from a height, -1 for only the new blocks
+func (c *Client) SubscribeBlocks(ctx context.Context, fromHeight int64) (*BlockStream, error) {
+  stream, err := c.conn.NewStream(ctx, &serviceDesc.Streams[0], "/"+serviceName+"/SubscribeBlocks")
+  if err != nil {
+    return nil, err
+  }
+  if err := stream.SendMsg(&SubscribeBlocksRequest{fromHeight}); err != nil {
+    return nil, err
+  }
+  if err := stream.CloseSend(); err != nil {
+    return nil, err
+  }
+  return &BlockStream{stream}, nil
+}
+
+// Define a method to wait for the next block
+func (s *BlockStream) Recv() (*Block, error) {
+  block := new(Block)
+  if err := s.stream.RecvMsg(block); err != nil {
+    return nil, err
+  }
+  return block, nil
+}
@@ -0,0 +1,71 @@
+package grpcapi
+
+import (
+  "fmt"                // to format the codec errors
+  "networkchain/codec" // the messages use the protocol buffers wire format
+)
+
+// Define a struct for a GetBlockCount request
+type GetBlockCountRequest struct{}
+
+// Define a struct for a GetBlockCount response
+type GetBlockCountResponse struct {
+  Height int64 `proto:"1"` // the height of the main chain
+}
+
+// Define a struct for a GetBlock request
+type GetBlockRequest struct {
+  Hash   []byte `proto:"1"` // the hash of the block, any known block
+  Height int64  `proto:"2"` // the height of a main chain block, used when Hash is empty
+}
+
+// Define a struct for a block
+type Block struct {
+  Hash           []byte   `proto:"1"`
+  PreviousHash   []byte   `proto:"2"`
+  MerkleRoot     []byte   `proto:"3"`
+  Timestamp      int64    `proto:"4"`
+  Bits           uint32   `proto:"5"`
+  Nonce          int64    `proto:"6"`
+  Height         int64    `proto:"7"`
+  TransactionIDs [][]byte `proto:"8"`
+  Raw            []byte   `proto:"9"` // the serialized block, as sent between nodes
+}
+
+// Define a struct for a SubmitTransaction request
+type SubmitTransactionRequest struct {
+  Transaction []byte `proto:"1"` // the serialized transaction, as sent between nodes
+}
+
+// Define a struct for a SubmitTransaction response
+type SubmitTransactionResponse struct {
+  Txid []byte `proto:"1"`
+}
+
+// Define a struct for a SubscribeBlocks request
+type SubscribeBlocksRequest struct {
+  FromHeight int64 `proto:"1"` // the first height to send, -1 to only receive the new blocks
+}
+
+// Define a struct for the gRPC codec of the messages
+// It is named "proto" because the bytes are protocol buffers, so it is forced on both sides
+// instead of being registered over the default codec
+type protoCodec struct{}
+
+// Define a method to encode a message
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+  return codec.Marshal(v)
+}
+
+// Define a method to decode a message
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+  if err := codec.Unmarshal(data, v); err != nil {
+    return fmt.Errorf("grpcapi: %w", err)
+  }
+  return nil
+}
+
+// Define a method to name the codec, the name goes in the content type
+func (protoCodec) Name() string {
+  return "proto"
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"networkchain/events"
+	"networkchain/grpcapi"
+)
+
+// Define a struct for the view of a node given to the gRPC server
+type grpcBackend struct {
+  n *Node // the node answering the requests
+}
+
+// Define a method to serve gRPC requests on an address until it fails
+func (n *Node) ServeGRPC(address string) error {
+  rpcLog.Info("Serving gRPC", "addr", address)
+  return grpcapi.NewServer(grpcBackend{n}).ListenAndServe(address) // serve the requests
+}
+
+// Define a method to get the height of the main chain
+func (b grpcBackend) BestHeight() int {
+  return b.n.bc.GetBestHeight()
+}
+
+// Define a method to get a known block by its hash
+func (b grpcBackend) BlockByHash(hash []byte) (*grpcapi.Block, error) {
+  block, height, ok := b.n.bc.GetBlock(hash) // look the block up
+  if !ok {
+    return nil, fmt.Errorf("%w: block %x", grpcapi.ErrNotFound, hash)
+  }
+  return grpcBlock(block, height), nil // return its message
+}
+
+// Define a method to get a block of the main chain by its height
+func (b grpcBackend) BlockByHeight(height int) (*grpcapi.Block, error) {
+  blocks := b.n.bc.MainChain() // the main chain
+  if height < 0 || height >= len(blocks) {
+    return nil, fmt.Errorf("%w: no block at height %d", grpcapi.ErrNotFound, height)
+  }
+  return grpcBlock(blocks[height], height), nil // return its message
+}
+
+// Define a function to build the message of a block
+func grpcBlock(block *Block, height int) *grpcapi.Block {
+  message := &grpcapi.Block{
+    Hash:         block.MyBlockHash,
+    PreviousHash: block.PreviousBlockHash,
+    MerkleRoot:   block.MerkleRoot,
+    Timestamp:    block.Timestamp,
+    Bits:         block.Bits,
+    Nonce:        int64(block.Nonce),
+    Height:       int64(height),
+    Raw:          block.Serialize(),
+  }
+  for _, tx := range block.Transactions { // iterate over the transactions
+    message.TransactionIDs = append(message.TransactionIDs, tx.ID) // list their IDs
+  }
+  return message // return the message
+}
+
+// Define a method to check a serialized transaction, add it to the mempool and announce it to the peers
+func (b grpcBackend) SubmitTransaction(raw []byte) ([]byte, error) {
+  tx, err := b.n.submitTransaction(raw) // check, add and announce the transaction
+  if err != nil {
+    return nil, fmt.Errorf("%w: %s", grpcapi.ErrRejected, err)
+  }
+  return tx.ID, nil // return its ID
+}
+
+// Define a method to subscribe to the blocks connected to the main chain
+func (b grpcBackend) SubscribeBlocks() (<-chan *grpcapi.Block, func()) {
+  sub := b.n.events.Subscribe(events.DefaultBuffer, events.TipChanged) // subscribe to the new tips
+  blocks := make(chan *grpcapi.Block, events.DefaultBuffer) // create a channel for the messages
+  go func() {
+    defer close(blocks) // the subscription was cancelled
+    for event := range sub.C { // iterate over the events
+      block, ok := event.Data.(*Block)
+      if event.Type != events.TipChanged || !ok { // only the blocks are streamed
+        continue
+      }
+      _, height, _ := b.n.bc.GetBlock(block.MyBlockHash) // find its height
+      if sub.Dropped() > 0 { // a block was lost, end the stream so the client resubscribes from its last height
+        sub.Cancel()
+        return
+      }
+      select {
+      case blocks <- grpcBlock(block, height): // hand the message over
+      default: // the client is too slow, end the stream for the same reason
+        sub.Cancel()
+        return
+      }
+    }
+  }()
+  return blocks, sub.Cancel // return the messages and the way to stop them
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+  "bufio"              // to look at the first byte of a connection
+  "encoding/binary"    // the network magic is the prologue of the handshake
+  "errors"             // for the error of a refused connection
+  "networkchain/noise" // the handshake and the encrypted connections
+  "net"                // the connections to the peers
+  "path/filepath"      // to locate the identity key
+  "time"               // for the deadline of the handshake
+)
+
+// The name of the file of the data directory holding the identity key of the node
+const identityFile = "nodekey"
+
+// Define a struct for the Noise settings of a node
+// Every connection is encrypted and both sides prove their persistent identity key, so a peer is known by its node ID
+// wherever it connects from
+type NoiseOptions struct {
+  Enabled   bool     // whether the node offers the Noise handshake
+  Require   bool     // refuse peers that do not complete the handshake instead of falling back, implies Enabled
+  Whitelist []string // the IDs of the peers never banned nor rate limited
+}
+
+// Define a function to load the identity of a node from its data directory, generating it the first time
+func loadIdentity(dataDir string) (*noise.Identity, error) {
+  return noise.LoadIdentity(filepath.Join(dataDir, identityFile))
+}
+
+// Define a function to get the prologue of the handshakes, so the nodes of different networks fail to complete it
+func noisePrologue() []byte {
+  return binary.BigEndian.AppendUint32(nil, activeNet.Net)
+}
+
+// Define a method to open a connection to a peer encrypted and authenticated with the Noise handshake
+func (n *Node) dialNoise(address string) (net.Conn, error) {
+  conn, err := n.dialPeer(address) // create a connection to the node
+  if err != nil {
+    return nil, err
+  }
+  conn.SetDeadline(time.Now().Add(handshakeTimeout)) // do not wait forever for the handshake
+  secure, err := noise.Client(conn, n.identity, noisePrologue())
+  if err != nil { // noise.ErrHandshake if the peer does not speak Noise or is not on our network, a network error otherwise
+    conn.Close()
+    return nil, err
+  }
+  secure.SetDeadline(time.Time{}) // the handshake is done
+  n.mu.Lock() // lock the peer state
+  n.peerIDs[address] = secure.RemoteID() // remember who the peer proved to be
+  n.mu.Unlock() // unlock it
+  return secure, nil
+}
+
+// Define a method to run the Noise handshake on an accepted connection if the peer starts one, returning the connection
+// to read from and the ID the peer proved, empty for the other connections
+func (n *Node) acceptNoise(conn net.Conn) (net.Conn, string, error) {
+  if n.identity == nil { // if the node does not speak Noise
+    return conn, "", nil // use the connection as it is
+  }
+  reader := bufio.NewReader(conn) // create a reader to look at the first byte
+  first, err := reader.Peek(1) // look at it without consuming it
+  if err != nil {
+    return nil, "", err
+  }
+  peeked := &peekedConn{conn, reader} // keep the peeked byte for the next reads
+  if first[0] != noise.HandshakeByte { // if the peer does not start a handshake
+    if n.noiseOptions.Require {
+      return nil, "", errors.New("connection without the Noise handshake refused")
+    }
+    return peeked, "", nil
+  }
+  secure, err := noise.Server(peeked, n.identity, noisePrologue())
+  if err != nil {
+    return nil, "", err
+  }
+  return secure, secure.RemoteID(), nil
+}
+
+// Define a method to check if a node ID is whitelisted
+func (n *Node) isWhitelisted(id string) bool {
+  for _, whitelisted := range n.noiseOptions.Whitelist {
+    if id != "" && id == whitelisted {
+      return true
+    }
+  }
+  return false
+}
+
+// Define a method to get the ID a peer proved when the node connected to it, empty if it did not
+func (n *Node) peerID(address string) string {
+  n.mu.Lock() // lock the peer state
+  defer n.mu.Unlock() // unlock it when done
+  return n.peerIDs[address]
+}
+
+// Define a method to check if a peer was found not to speak Noise lately, the handshake is tried again once it expired
+func (n *Node) isNoNoisePeer(address string) bool {
+  n.mu.Lock() // lock the peer state
+  defer n.mu.Unlock() // unlock it when done
+  until, ok := n.noNoisePeers[address]
+  if ok && time.Now().After(until) { // the peer may have been upgraded
+    delete(n.noNoisePeers, address)
+    return false
+  }
+  return ok
+}
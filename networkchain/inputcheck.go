@@ -0,0 +1,100 @@
+package main
+
+import (
+  "fmt"                 // to format the failures
+  "networkchain/script" // for the batches of Ed25519 signatures
+  "runtime"             // to size the pool of workers
+  "strings"             // to join the failures
+  "sync"                // to wait for the workers
+  "sync/atomic"         // for the next check a worker takes
+)
+
+// Define a struct for the check of the script of an input of a block, queued so the inputs are verified in parallel
+type inputCheck struct {
+  tx      *Transaction // the transaction spending the output
+  index   int          // the input of the transaction
+  prevOut TXOutput     // the output it spends
+}
+
+// Define a method to run the scripts of the input, leaving its Ed25519 signatures in a batch, nil verifies them at once
+func (check inputCheck) run(batch *script.Ed25519Batch) error {
+  if err := check.tx.verifyInputBatched(check.index, check.prevOut, batch); err != nil {
+    return fmt.Errorf("input %d of transaction %x: %w", check.index, check.tx.ID, err)
+  }
+  return nil
+}
+
+// Define a type for the inputs of a block that failed their checks, in block order
+// It unwraps to the first failure, so the errors of the scripts can still be told apart
+type inputErrors []error
+
+// Define a method to describe the failures, every one of them
+func (errs inputErrors) Error() string {
+  if len(errs) == 1 {
+    return errs[0].Error()
+  }
+  messages := make([]string, len(errs))
+  for i, err := range errs {
+    messages[i] = err.Error()
+  }
+  return fmt.Sprintf("%d inputs failed: %s", len(errs), strings.Join(messages, "; "))
+}
+
+// Define a method to get the first failure
+func (errs inputErrors) Unwrap() error {
+  return errs[0]
+}
+
+// Define a function to verify the scripts of inputs with a worker per processor, instead of one input after the other
+// Every input is checked, so the error lists all the inputs of the block that failed
+// Each worker verifies the Ed25519 signatures of its inputs in one batch once their scripts ran; when the batch fails, or
+// a script failed while a signature was only taken as valid, the inputs are checked again one signature at a time
+func verifyInputs(checks []inputCheck) error {
+  failures := make([]error, len(checks)) // each worker writes the results of its own checks
+  workers := runtime.GOMAXPROCS(0)
+  if workers > len(checks) {
+    workers = len(checks)
+  }
+  var next int64 = -1 // the last check taken by a worker
+  var wg sync.WaitGroup
+  for i := 0; i < workers; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      var batch script.Ed25519Batch // the signatures of the inputs of the worker
+      var batched []int              // the inputs whose result stands on the batch
+      for {
+        j := int(atomic.AddInt64(&next, 1))
+        if j >= len(checks) {
+          break
+        }
+        var input script.Ed25519Batch
+        failures[j] = checks[j].run(&input)
+        switch {
+        case input.Len() == 0: // no signature was left for later, the result stands
+        case failures[j] != nil: // a signature taken as valid may have failed the script
+          failures[j] = checks[j].run(nil)
+        default:
+          batch.Append(&input)
+          batched = append(batched, j)
+        }
+      }
+      if !batch.Verify(sigCache) { // one signature at least is invalid, find the inputs it belongs to
+        for _, j := range batched {
+          failures[j] = checks[j].run(nil)
+        }
+      }
+    }()
+  }
+  wg.Wait()
+  var errs inputErrors
+  for _, err := range failures {
+    if err != nil {
+      errs = append(errs, err)
+    }
+  }
+  if len(errs) == 0 {
+    return nil
+  }
+  return errs
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+  "encoding/hex" // the items are keyed by their hex hash
+  "strings"      // to tell the transactions apart
+  "sync"         // for the lock of the inventory
+  "time"         // for the timeout of the requests
+)
+
+// Define some constants of the inventory tracking
+const (
+  knownInvPerPeer = 5000            // the items remembered for each peer, the oldest are forgotten first
+  recentTxs       = 50000           // the transactions remembered once received, accepted or not
+  requestTimeout  = 2 * time.Minute // how long a requested item is waited for before another peer is asked
+)
+
+// Define a struct for a set of items bounded to a size, forgetting the oldest first
+type invSet struct {
+  items map[string]bool // the items of the set
+  order []string        // the items, oldest first
+  limit int             // the size of the set
+}
+
+// Define a function to create an empty set of items with a size
+func newInvSet(limit int) *invSet {
+  return &invSet{items: map[string]bool{}, limit: limit}
+}
+
+// Define a method to add an item to a set, returning false if it was already in it
+func (s *invSet) add(key string) bool {
+  if s.items[key] {
+    return false
+  }
+  if len(s.order) >= s.limit { // forget the oldest item
+    delete(s.items, s.order[0])
+    s.order = s.order[1:]
+  }
+  s.items[key] = true
+  s.order = append(s.order, key)
+  return true
+}
+
+// Define a struct for an item requested from a peer
+type requestedItem struct {
+  peer string    // the peer asked for it
+  at   time.Time // when it was asked
+}
+
+// Define a struct for the inventory tracking of a node
+// The node remembers the items each peer has, because the peer announced or sent them or the node announced them to it,
+// so it never announces an item back to a peer; the items requested and still awaited, so an item announced by several
+// peers is downloaded once; and the transactions received lately, so one rejected or already mined is not asked again
+type inventory struct {
+  mu        sync.Mutex               // the lock protecting the inventory
+  known     map[string]*invSet       // the items each peer has
+  requested map[string]requestedItem // the items requested and not received yet
+  seenTxs   *invSet                  // the transactions received lately
+}
+
+// Define a function to create an empty inventory tracking
+func newInventory() *inventory {
+  return &inventory{known: map[string]*invSet{}, requested: map[string]requestedItem{}, seenTxs: newInvSet(recentTxs)}
+}
+
+// Define a function to get the key of an item, a block requested as a compact or a filtered block is still the block
+func invKey(kind string, hash []byte) string {
+  if kind == "cmpctblock" || kind == "merkleblock" {
+    kind = "block"
+  }
+  return kind + ":" + hex.EncodeToString(hash)
+}
+
+// Define a method to record that a peer has an item, returning false if it was already known to have it
+func (inv *inventory) addKnown(peer, key string) bool {
+  inv.mu.Lock() // lock the inventory
+  defer inv.mu.Unlock() // unlock it when done
+  known, ok := inv.known[peer]
+  if !ok {
+    known = newInvSet(knownInvPerPeer)
+    inv.known[peer] = known
+  }
+  return known.add(key)
+}
+
+// Define a method to record the request of an item from a peer
+func (inv *inventory) request(peer, key string) {
+  inv.mu.Lock() // lock the inventory
+  defer inv.mu.Unlock() // unlock it when done
+  inv.requested[key] = requestedItem{peer, time.Now()}
+}
+
+// Define a method to check if an item is awaited from a peer other than one
+func (inv *inventory) awaited(key, except string) bool {
+  inv.mu.Lock() // lock the inventory
+  defer inv.mu.Unlock() // unlock it when done
+  pending, ok := inv.requested[key]
+  return ok && pending.peer != except && time.Since(pending.at) < requestTimeout
+}
+
+// Define a method to record an item received from a peer, which then has it
+func (inv *inventory) received(peer, key string) {
+  inv.addKnown(peer, key)
+  inv.mu.Lock() // lock the inventory
+  defer inv.mu.Unlock() // unlock it when done
+  delete(inv.requested, key)
+  if strings.HasPrefix(key, "tx:") {
+    inv.seenTxs.add(key)
+  }
+}
+
+// Define a method to check if a transaction was received lately
+func (inv *inventory) seenTx(key string) bool {
+  inv.mu.Lock() // lock the inventory
+  defer inv.mu.Unlock() // unlock it when done
+  return inv.seenTxs.items[key]
+}
+
+// Define a method to forget what a peer has, once it is no longer a peer
+func (inv *inventory) forget(peer string) {
+  inv.mu.Lock() // lock the inventory
+  defer inv.mu.Unlock() // unlock it when done
+  delete(inv.known, peer)
+  for key, pending := range inv.requested { // the items it was asked for go to the next peer announcing them
+    if pending.peer == peer {
+      delete(inv.requested, key)
+    }
+  }
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Define some constants for the keepalive
+const (
+  pingInterval   = 30 * time.Second // how often each peer is pinged
+  pingTimeout    = 20 * time.Second // how long a peer has to answer a ping
+  maxMissedPings = 3                // how many pings in a row a peer may miss before it is dropped
+)
+
+// Define a struct for the ping state of a peer
+type pingState struct {
+  nonce   int64         // the nonce of the last ping sent
+  sentAt  time.Time     // when the last ping was sent
+  waiting bool          // whether the last ping is still unanswered
+  missed  int           // how many pings in a row were not answered
+  rtt     time.Duration // the round trip time of the last answered ping
+}
+
+// Define a method to ping the known nodes until the node stops, dropping the ones that stop answering
+func (n *Node) keepAlive() {
+  ticker := time.NewTicker(pingInterval) // create a ticker for the interval
+  defer ticker.Stop() // stop it when done
+  for {
+    select {
+    case <-n.quit: // the node stopped
+      return
+    case <-ticker.C: // on every tick
+      for _, peer := range n.peers() { // iterate over the known nodes
+        n.pingPeer(peer) // ping the node
+      }
+    }
+  }
+}
+
+// Define a method to check the last ping of a peer and send a new one
+func (n *Node) pingPeer(address string) {
+  n.mu.Lock() // lock the peer state
+  state, ok := n.pings[address] // get the state of the peer
+  if !ok { // if the peer was never pinged
+    state = &pingState{} // create its state
+    n.pings[address] = state // and remember it
+  }
+  if state.waiting && time.Since(state.sentAt) > pingTimeout { // if the last ping was not answered in time
+    state.missed++ // count the miss
+  }
+  if state.missed >= maxMissedPings { // if the peer missed too many pings
+    delete(n.pings, address) // forget its state
+    n.mu.Unlock() // unlock the peer state
+    netLog.Info("Dropping peer after unanswered pings", "peer", address, "missed", maxMissedPings)
+    n.conns.failed(address) // disconnect it, the connection manager dials another peer
+    n.inv.forget(address) // the items it was asked for go to the other peers
+    n.blocks.dropPeer(address) // and so do the blocks
+    return
+  }
+  state.nonce = rand.Int63() // pick a new nonce
+  state.sentAt = time.Now() // remember when the ping leaves
+  state.waiting = true // wait for the answer
+  nonce := state.nonce // copy the nonce before unlocking
+  n.mu.Unlock() // unlock the peer state
+  n.sendPing(address, nonce) // send the ping
+}
+
+// Define a method to record the pong of a peer, returning the round trip time
+func (n *Node) recordPong(address string, nonce int64) (time.Duration, bool) {
+  n.mu.Lock() // lock the peer state
+  defer n.mu.Unlock() // unlock it when done
+  state, ok := n.pings[address] // get the state of the peer
+  if !ok || !state.waiting || state.nonce != nonce { // if we did not send this ping
+    return 0, false // ignore the pong
+  }
+  state.waiting = false // the ping is answered
+  state.missed = 0 // the peer is alive
+  state.rtt = time.Since(state.sentAt) // measure the round trip time
+  return state.rtt, true // return it
+}
+
+// Define a method to get the last round trip time measured with a peer
+func (n *Node) peerRTT(address string) time.Duration {
+  n.mu.Lock() // lock the peer state
+  defer n.mu.Unlock() // unlock it when done
+  if state, ok := n.pings[address]; ok { // if the peer was pinged
+    return state.rtt // return its round trip time
+  }
+  return 0 // the round trip time is unknown
+}
+
+// Define a method to get the round trip times of the peers, for the ones that answered a ping
+func (n *Node) peerRTTs() map[string]time.Duration {
+  n.mu.Lock() // lock the peer state
+  defer n.mu.Unlock() // unlock it when done
+  rtts := map[string]time.Duration{} // create a buffer for the times
+  for address, state := range n.pings { // iterate over the pinged peers
+    if state.rtt > 0 {
+      rtts[address] = state.rtt
+    }
+  }
+  return rtts
+}
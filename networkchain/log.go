@@ -0,0 +1,14 @@
+package main
+
+import (
+  "networkchain/logger" // the structured logger
+)
+
+// Create the loggers of the subsystems of the node
+var (
+  netLog     = logger.New(logger.NET)     // the peer to peer protocol
+  chainLog   = logger.New(logger.CHAIN)   // the blocks, the indexes and the headers
+  mempoolLog = logger.New(logger.MEMPOOL) // the transactions waiting for a block
+  minerLog   = logger.New(logger.MINER)   // the mining of blocks
+  rpcLog     = logger.New(logger.RPC)     // the API servers
+)
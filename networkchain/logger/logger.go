@@ -0,0 +1,287 @@
+// Package logger prints the messages of the node as lines of key=value fields.
+// Every part of the node logs through the logger of its subsystem, and each subsystem has its
+// own level, so one part can be debugged without the noise of the others, even while running.
+package logger
+
+import (
+  "errors"      // for the errors of the level specifications
+  "fmt"         // to format the values and the panics
+  "io"          // for the output of the lines
+  "os"          // the default output
+  "sort"        // to list the subsystems in order
+  "strconv"     // to quote the values
+  "strings"     // to build the lines and parse the specifications
+  "sync"        // to guard the output and the levels
+  "sync/atomic" // the levels are read on every message
+  "time"        // for the time of the messages
+)
+
+// Define a type for the importance of a message
+type Level int32
+
+// Define the levels, a logger prints the messages at its level and above
+const (
+  LevelDebug Level = iota // the details of the protocol, for debugging
+  LevelInfo               // what the node does
+  LevelWarn               // something went wrong but the node carries on
+  LevelError              // something failed
+)
+
+// Define the names of the levels, in order
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// Define the subsystems of the node
+const (
+  NET     = "NET"     // the peer to peer protocol
+  CHAIN   = "CHAIN"   // the blocks, the indexes and the headers of a light node
+  MEMPOOL = "MEMPOOL" // the transactions waiting for a block
+  MINER   = "MINER"   // the mining of blocks
+  RPC     = "RPC"     // the JSON-RPC, REST, WebSocket and gRPC servers and the ZMQ publisher and the webhooks
+)
+
+// Define an error returned for an unknown level or subsystem
+var ErrUnknown = errors.New("logger: unknown level or subsystem")
+
+// Define a method to get the name of a level
+func (l Level) String() string {
+  if l < LevelDebug || l > LevelError {
+    return fmt.Sprintf("level(%d)", int32(l))
+  }
+  return levelNames[l]
+}
+
+// Define a function to read a level from its name
+func ParseLevel(name string) (Level, error) {
+  for i, levelName := range levelNames {
+    if strings.EqualFold(name, levelName) {
+      return Level(i), nil
+    }
+  }
+  return 0, fmt.Errorf("%w: level %q", ErrUnknown, name)
+}
+
+// Define a function to list the names of the levels
+func LevelNames() []string {
+  return append([]string{}, levelNames...)
+}
+
+var (
+  outMu  sync.Mutex             // guards the output, so the lines do not mix
+  out    io.Writer = os.Stderr  // where the lines are written
+  levels = map[string]*int32{} // the level of each subsystem, set atomically
+)
+
+func init() {
+  for _, subsystem := range []string{NET, CHAIN, MEMPOOL, MINER, RPC} {
+    level := int32(LevelInfo)
+    levels[subsystem] = &level
+  }
+}
+
+// Define a function to change where the lines are written
+func SetOutput(w io.Writer) {
+  outMu.Lock()
+  defer outMu.Unlock()
+  out = w
+}
+
+// Define a function to list the subsystems, in name order
+func Subsystems() []string {
+  var names []string
+  for subsystem := range levels {
+    names = append(names, subsystem)
+  }
+  sort.Strings(names)
+  return names
+}
+
+// Define a function to change the level of a subsystem, or of every subsystem if it is empty
+func SetLevel(subsystem string, level Level) error {
+  if level < LevelDebug || level > LevelError {
+    return fmt.Errorf("%w: level %d", ErrUnknown, int32(level))
+  }
+  if subsystem == "" {
+    for _, current := range levels {
+      atomic.StoreInt32(current, int32(level))
+    }
+    return nil
+  }
+  current, ok := levels[strings.ToUpper(subsystem)]
+  if !ok {
+    return fmt.Errorf("%w: subsystem %q", ErrUnknown, subsystem)
+  }
+  atomic.StoreInt32(current, int32(level))
+  return nil
+}
+
+// Define a function to get the level of each subsystem
+func Levels() map[string]Level {
+  current := make(map[string]Level)
+  for subsystem, level := range levels {
+    current[subsystem] = Level(atomic.LoadInt32(level))
+  }
+  return current
+}
+
+// Define a function to apply a level specification: a level for every subsystem, followed by
+// comma separated overrides for some subsystems, like "info,NET=debug,RPC=warn"
+// Nothing is changed if the specification is wrong
+func Apply(spec string) error {
+  changes, err := parseSpec(spec)
+  if err != nil {
+    return err
+  }
+  for _, change := range changes {
+    SetLevel(change.subsystem, change.level) // checked by parseSpec
+  }
+  return nil
+}
+
+// Define a function to check a level specification without applying it
+func ValidateSpec(spec string) error {
+  _, err := parseSpec(spec)
+  return err
+}
+
+// Define a struct for one part of a level specification
+type levelChange struct {
+  subsystem string // empty for every subsystem
+  level     Level
+}
+
+// Define a function to read a level specification, in the order the changes apply
+func parseSpec(spec string) ([]levelChange, error) {
+  var changes []levelChange
+  for _, part := range strings.Split(spec, ",") {
+    part = strings.TrimSpace(part)
+    if part == "" {
+      continue
+    }
+    subsystem, name, override := strings.Cut(part, "=")
+    if !override { // a bare level is for every subsystem
+      subsystem, name = "", part
+    }
+    level, err := ParseLevel(strings.TrimSpace(name))
+    if err != nil {
+      return nil, err
+    }
+    subsystem = strings.ToUpper(strings.TrimSpace(subsystem))
+    if _, ok := levels[subsystem]; override && !ok {
+      return nil, fmt.Errorf("%w: subsystem %q", ErrUnknown, subsystem)
+    }
+    changes = append(changes, levelChange{subsystem, level})
+  }
+  if len(changes) == 0 {
+    return nil, errors.New("logger: empty level specification")
+  }
+  return changes, nil
+}
+
+// Define a struct for the logger of a subsystem, with the fields added to all its messages
+type Logger struct {
+  subsystem string        // the subsystem printed on each line
+  level     *int32        // the level of the subsystem, shared by its loggers
+  fields    []interface{} // key/value pairs printed after the message
+}
+
+// Define a function to get the logger of a subsystem
+func New(subsystem string) *Logger {
+  level, ok := levels[subsystem]
+  if !ok {
+    panic(fmt.Sprintf("logger: unknown subsystem %q", subsystem)) // the subsystems are fixed, this is a programming error
+  }
+  return &Logger{subsystem: subsystem, level: level}
+}
+
+// Define a method to get a logger adding key/value pairs to every message, like the peer a handler talks to
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+  fields := append(append([]interface{}{}, l.fields...), keyvals...)
+  return &Logger{l.subsystem, l.level, fields}
+}
+
+// Define a method to check if the messages of a level are printed, to skip building expensive fields
+func (l *Logger) Enabled(level Level) bool {
+  return level >= Level(atomic.LoadInt32(l.level))
+}
+
+// Define a method to print a debug message with key/value pairs
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+  l.log(LevelDebug, msg, keyvals)
+}
+
+// Define a method to print an info message with key/value pairs
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+  l.log(LevelInfo, msg, keyvals)
+}
+
+// Define a method to print a warning with key/value pairs
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+  l.log(LevelWarn, msg, keyvals)
+}
+
+// Define a method to print an error with key/value pairs
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+  l.log(LevelError, msg, keyvals)
+}
+
+// Define a method to print an error whatever the level and panic, for the failures the node cannot recover from
+func (l *Logger) Panic(msg string, keyvals ...interface{}) {
+  line := l.format(LevelError, msg, keyvals)
+  l.write(line)
+  panic(line)
+}
+
+// Define a method to print a message if its level is enabled
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+  if l.Enabled(level) {
+    l.write(l.format(level, msg, keyvals))
+  }
+}
+
+// Define a method to write a line
+func (l *Logger) write(line string) {
+  outMu.Lock()
+  defer outMu.Unlock()
+  io.WriteString(out, line+"\n") // nowhere to report a failing output
+}
+
+// Define a method to format a message: the time, the level, the subsystem, the message and the fields
+func (l *Logger) format(level Level, msg string, keyvals []interface{}) string {
+  var b strings.Builder
+  b.WriteString("time=" + time.Now().UTC().Format("2006-01-02T15:04:05.000Z"))
+  b.WriteString(" level=" + level.String())
+  b.WriteString(" sub=" + l.subsystem)
+  b.WriteString(" msg=" + quote(msg))
+  fields := append(append([]interface{}{}, l.fields...), keyvals...)
+  for i := 0; i < len(fields); i += 2 {
+    key := fmt.Sprint(fields[i])
+    if i+1 == len(fields) { // a key without value is a programming error, print it anyway
+      b.WriteString(" " + key + "=")
+      break
+    }
+    b.WriteString(" " + key + "=" + quote(formatValue(fields[i+1])))
+  }
+  return b.String()
+}
+
+// Define a function to format a field value, byte slices are hashes and IDs and are printed in hex
+func formatValue(value interface{}) string {
+  switch v := value.(type) {
+  case []byte:
+    return fmt.Sprintf("%x", v)
+  case error:
+    return v.Error()
+  case fmt.Stringer:
+    return v.String()
+  default:
+    return fmt.Sprint(v)
+  }
+}
+
+// Define a function to quote a value if it holds spaces, quotes, equal signs or nothing
+func quote(value string) string {
+  if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+    return strconv.Quote(value)
+  }
+  return value
+}
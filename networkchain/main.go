@@ -1,25 +1,90 @@
 package main
-import "https://github.com/adgadgad/blockchainstart/blob/main/networkchain/network.go"
+
 import (
-	"https://github.com/adgadgad/blockchainstart/blob/main/networkchain/network.go"
-	"fmt" // just for printing something on the screen
+  "fmt"                   // to report the errors of the commands
+  "networkchain/chaincfg" // the known networks
+  "networkchain/config"   // the settings of the node
+  "networkchain/logger"   // to set the log levels
+  "os"                    // to exit with an error code
+  "strings"               // to list the log levels and the networks
+
+  "github.com/spf13/cobra" // the command line interface
+  "github.com/spf13/pflag" // the flags behind the commands
 )
 
-func main(args []string) {
-  newblockchain := NewBlockchain() // Initialize the blockchain with the genesis block
-  // create 5 blocks and add some transactions
-  for i := 1; i <= 15; i++ { // use a for loop to add multiple blocks
-    data := fmt.Sprintf("Transaction %d", i) // generate some data for each block
-    newblockchain.AddBlock(data)             // add the block to the chain
-  }
-  // Now print all the blocks and their contents
-  for i, block := range newblockchain.Blocks { // iterate on each block
-    fmt.Printf("Block ID : %d \n", i)                                        // print the block ID
-    fmt.Printf("Timestamp : %d \n", block.Timestamp+int64(i))                // print the timestamp of the block, to make them different, we just add a value i
-    fmt.Printf("Hash of the block : %x\n", block.MyBlockHash)                // print the hash of the block
-    fmt.Printf("Hash of the previous Block : %x\n", block.PreviousBlockHash) // print the hash of the previous block
-    fmt.Printf("All the transactions : %s\n", block.AllData)                 // print the transactions
-  } // our blockchain will be printed
+// Create the root command, every action of the binary is one of its subcommands
+var rootCmd = &cobra.Command{
+  Use:           "networkchain",
+  Short:         "A small proof of work blockchain with a peer to peer network",
+  SilenceUsage:  true, // do not print the usage for errors that are not about the command line
+  SilenceErrors: true, // the errors are printed once by main
+}
 
-  network.StartNode(args[0]) // start the node with the address
+func main() {
+  defaults := config.Default() // the flags show the defaults, the settings are merged by loadConfig
+  rootCmd.PersistentFlags().String("config", "", "YAML settings file, "+config.DefaultFile+" in the data directory by default")
+  rootCmd.PersistentFlags().String("datadir", defaults.DataDir, "directory holding the chain and the wallets")
+  rootCmd.PersistentFlags().String("network", defaults.Network, "network the node runs on: "+strings.Join(chaincfg.Names(), ", "))
+  rootCmd.PersistentFlags().String("params", "", "YAML parameters file of a private network written by the genesis command, replacing --network")
+  rootCmd.PersistentFlags().String("loglevel", defaults.LogLevel, "lowest level of the messages printed, one of "+strings.Join(logger.LevelNames(), ", ")+", followed by overrides like NET=debug for the subsystems "+strings.Join(logger.Subsystems(), ", "))
+  rootCmd.AddCommand(startNodeCmd(), createWalletCmd(), sendCmd(), sendToAddressCmd(), getBalanceCmd(), printChainCmd(), reindexCmd(), verifyChainCmd(), walletCmd(), snapshotCmd(), genesisCmd(), validatorCmd(), simnetCmd())
+  if err := rootCmd.Execute(); err != nil { // run the command given on the command line
+    fmt.Fprintln(os.Stderr, "Error:", err)
+    os.Exit(1)
+  }
+}
+
+// Create the function that merges the settings of a command: defaults, then the file, then the environment, then the flags
+func loadConfig(cmd *cobra.Command) (*config.Config, error) {
+  cfg := config.Default()
+  if err := applyOverrides(cmd, cfg); err != nil { // the data directory may be given by a flag or a variable, it locates the default file
+    return nil, err
+  }
+  path, _ := cmd.Flags().GetString("config")
+  if err := cfg.LoadFile(firstNonEmpty(path, cfg.DefaultPath()), path != ""); err != nil { // a file given explicitly must exist
+    return nil, err
+  }
+  if err := applyOverrides(cmd, cfg); err != nil { // the variables and the flags win over the file
+    return nil, err
+  }
+  if err := cfg.Validate(); err != nil {
+    return nil, err
+  }
+  cfg.SetNetworkDefaults() // the default port depends on the network
+  logger.Apply(cfg.LogLevel) // checked by Validate
+  activeNet = cfg.ChainParams() // every command works on the chain of the network
+  return cfg, nil
+}
+
+// Create the function that applies the environment variables and then the flags set on the command line
+func applyOverrides(cmd *cobra.Command, cfg *config.Config) error {
+  if err := cfg.LoadEnv(os.LookupEnv); err != nil {
+    return err
+  }
+  keys := map[string]bool{}
+  for _, key := range config.Keys() {
+    keys[key] = true
+  }
+  var err error
+  cmd.Flags().Visit(func(flag *pflag.Flag) { // only the flags given on the command line
+    if !keys[flag.Name] || err != nil { // the flags that are not settings belong to the command
+      return
+    }
+    value := flag.Value.String()
+    if list, ok := flag.Value.(pflag.SliceValue); ok { // lists are passed comma separated
+      value = strings.Join(list.GetSlice(), ",")
+    }
+    err = cfg.Set(flag.Name, value)
+  })
+  return err
+}
+
+// Create the function that returns the first non-empty string
+func firstNonEmpty(values ...string) string {
+  for _, value := range values {
+    if value != "" {
+      return value
+    }
+  }
+  return ""
 }
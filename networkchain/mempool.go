@@ -0,0 +1,275 @@
+package main
+
+import (
+  "bytes"                // to compare the IDs of the missing parents
+  "encoding/hex"         // the pool identifies transactions by hex ID
+  "errors"               // for the admission errors
+  "fmt"                  // to format the admission errors
+  "networkchain/events"  // to announce the accepted transactions
+  "networkchain/mempool" // the pool of transactions waiting to be mined
+  "networkchain/storage" // to look the parents up in the transaction index
+  "time"                 // to check the lock times against the clock
+)
+
+// An error returned for a transaction spending outputs of transactions not seen yet
+type missingParentsError struct {
+  parents [][]byte // the IDs of the unknown transactions
+}
+
+// create the method that describes the error
+func (e *missingParentsError) Error() string {
+  return fmt.Sprintf("transaction spends outputs of %d unknown transactions", len(e.parents))
+}
+
+// create the method that checks a transaction against the chain and the pool and adds it to the mempool
+// A transaction spending outputs of transactions not seen yet is parked in the orphan pool instead and the IDs of the
+// missing transactions are returned, to ask for them; the accepted transactions are returned too: the transaction
+// and the orphans it completed, parents first
+// The chain is locked so no block can spend the same outputs between the checks and the admission
+func (blockchain *Blockchain) AddTxToMempool(tx *Transaction) ([]*Transaction, [][]byte, error) {
+  blockchain.mu.Lock()         // lock the chain
+  defer blockchain.mu.Unlock() // unlock it when done
+  err := blockchain.addTxToMempool(tx)
+  var missing *missingParentsError
+  if errors.As(err, &missing) { // park it until the parents arrive
+    orphan := &mempool.Orphan{ID: hex.EncodeToString(tx.ID), Tx: tx, Size: len(tx.Serialize())}
+    for _, parent := range missing.parents {
+      orphan.Missing = append(orphan.Missing, hex.EncodeToString(parent))
+    }
+    if err := blockchain.Orphans.Add(orphan); err != nil {
+      return nil, nil, err
+    }
+    return nil, missing.parents, nil
+  }
+  if err != nil {
+    return nil, nil, err
+  }
+  return append([]*Transaction{tx}, blockchain.promoteOrphans(tx)...), nil, nil
+}
+
+// create the method that moves the orphans spending the outputs of new transactions to the mempool, the lock must be held
+// The orphans accepted may free orphans in turn; an orphan still missing other parents is parked again
+func (blockchain *Blockchain) promoteOrphans(parents ...*Transaction) []*Transaction {
+  var accepted []*Transaction
+  for len(parents) > 0 {
+    parent := parents[0]
+    parents = parents[1:]
+    for _, orphan := range blockchain.Orphans.TakeChildren(hex.EncodeToString(parent.ID)) {
+      tx := orphan.Tx.(*Transaction)
+      err := blockchain.addTxToMempool(tx)
+      var missing *missingParentsError
+      if errors.As(err, &missing) { // another parent is still unknown
+        orphan.Missing = nil
+        for _, id := range missing.parents {
+          orphan.Missing = append(orphan.Missing, hex.EncodeToString(id))
+        }
+        blockchain.Orphans.Add(orphan)
+        continue
+      }
+      if err != nil {
+        mempoolLog.Debug("Dropped orphan transaction", "txid", tx.ID, "err", err)
+        continue
+      }
+      mempoolLog.Debug("Promoted orphan transaction", "txid", tx.ID, "parent", parent.ID)
+      accepted = append(accepted, tx)
+      parents = append(parents, tx)
+    }
+  }
+  return accepted
+}
+
+// create the method that checks a transaction and adds it to the mempool, the lock must be held
+func (blockchain *Blockchain) addTxToMempool(tx *Transaction) error {
+  if tx.IsCoinbase() { // coinbases only exist inside blocks
+    return errors.New("coinbase transactions cannot enter the mempool")
+  }
+  if err := checkTransaction(tx); err != nil { // the ID must match the content and the outputs must be sane
+    return err
+  }
+  for i, out := range tx.Vout { // the blocks may hold them, but the pool does not relay outputs nobody would spend
+    if out.IsDust() {
+      return fmt.Errorf("transaction %x output %d of %d is below the dust threshold %d: %w", tx.ID, i, out.Value, activeNet.DustThreshold, errDust)
+    }
+  }
+  if !tx.IsFinal(blockchain.tipNode().height+1, time.Now().Unix()) { // the next block must be able to hold it
+    return fmt.Errorf("transaction %x is locked until %d: %w", tx.ID, tx.LockTime, errNotFinal)
+  }
+  next := blockchain.tipNode().height + 1 // the height of the block that may hold it
+  entry := &mempool.Entry{ID: hex.EncodeToString(tx.ID), Tx: tx, Size: len(tx.Serialize())} // describe the transaction for the pool
+  if entry.Size > maxBlockTxsSize() { // no block could ever hold it
+    return fmt.Errorf("transaction %x has %d bytes, a block holds %d: %w", tx.ID, entry.Size, maxBlockTxsSize(), errTxTooLarge)
+  }
+  inputValue := 0 // the value of the spent outputs
+  var prevOuts []TXOutput // the spent outputs, for the signatures
+  missing := &missingParentsError{} // the transactions not seen yet
+  for i, in := range tx.Vin { // iterate over the inputs
+    out, ok := blockchain.findUnspentOutput(in.Txid, in.Vout) // the output must exist and be unspent
+    if !ok && !blockchain.knownTransaction(in.Txid) { // its transaction may just not have arrived yet
+      if !containsHash(missing.parents, in.Txid) {
+        missing.parents = append(missing.parents, in.Txid)
+      }
+      continue
+    }
+    if !ok {
+      return fmt.Errorf("transaction %x spends unknown output %x:%d", tx.ID, in.Txid, in.Vout)
+    }
+    if !out.mature(next) {
+      return fmt.Errorf("transaction %x spends output %x:%d of the coinbase at height %d: %w", tx.ID, in.Txid, in.Vout, out.Height, errImmatureSpend)
+    }
+    var err error
+    if inputValue, err = addValue(inputValue, out.Value); err != nil { // a sum wrapping around would create coins
+      return fmt.Errorf("transaction %x input %d of %d: %w", tx.ID, i, out.Value, err)
+    }
+    prevOuts = append(prevOuts, out.output())
+    entry.Spends = append(entry.Spends, mempool.Outpoint{Txid: hex.EncodeToString(in.Txid), Index: in.Vout})
+  }
+  if len(missing.parents) > 0 {
+    return missing
+  }
+  if err := tx.Verify(prevOuts); err != nil { // the owners of the outputs must have signed
+    return err
+  }
+  outputValue := 0 // the value of the new outputs
+  for i, out := range tx.Vout {
+    var err error
+    if outputValue, err = addValue(outputValue, out.Value); err != nil {
+      return fmt.Errorf("transaction %x output %d of %d: %w", tx.ID, i, out.Value, err)
+    }
+  }
+  if outputValue > inputValue { // a transaction cannot create coins
+    return fmt.Errorf("transaction %x spends %d but only has %d", tx.ID, outputValue, inputValue)
+  }
+  entry.Fee = inputValue - outputValue // whatever is not spent goes to the miner
+  if err := blockchain.Mempool.Add(entry); err != nil { // the pool rejects duplicates and double spends
+    return err
+  }
+  blockchain.fees.track(entry.ID, entry.FeeRate()*feeRateUnit, next-1) // follow it until it is mined, for the fee estimates
+  blockchain.Events.Publish(events.Event{Type: events.TxAccepted, Data: tx}) // announce the transaction
+  return nil
+}
+
+// create the method that sets the fee per feeRateUnit bytes below which the mempool refuses transactions and how long
+// they wait in it to be mined
+func (blockchain *Blockchain) SetMempoolPolicy(minRelayFee int, expiry time.Duration) {
+  blockchain.Mempool.SetMinRelayFeeRate(float64(minRelayFee) / feeRateUnit)
+  blockchain.Mempool.SetExpiry(expiry)
+}
+
+// create the method that removes a transaction and the transactions spending its outputs from the mempool, returning
+// whether it was there
+func (blockchain *Blockchain) RemoveFromMempool(id string) bool {
+  blockchain.mu.Lock()         // lock the chain
+  defer blockchain.mu.Unlock() // unlock it when done
+  if !blockchain.Mempool.Has(id) {
+    return false
+  }
+  blockchain.Mempool.RemoveWithDescendants(id)
+  return true
+}
+
+// create the method that finds an output that is unspent in the chain or created by a mempool transaction, which is
+// never a coinbase
+func (blockchain *Blockchain) findUnspentOutput(txid []byte, vout int) (utxoEntry, bool) {
+  if out, ok := (UTXOSet{blockchain}).findEntry(txid, vout); ok { // look in the confirmed outputs first
+    return out, true
+  }
+  if entry := blockchain.Mempool.Get(hex.EncodeToString(txid)); entry != nil { // then in the pending transactions
+    tx := entry.Tx.(*Transaction)
+    if vout >= 0 && vout < len(tx.Vout) {
+      return utxoEntry{Value: tx.Vout[vout].Value, ScriptPubKey: tx.Vout[vout].ScriptPubKey}, true
+    }
+  }
+  return utxoEntry{}, false
+}
+
+// create the method that drops the mempool transactions the new tip cannot confirm after a reorganization, with the
+// transactions spending them: the ones spending the outputs of a disconnected coinbase, or of a coinbase too young
+// on the new branch; the lock must be held
+func (blockchain *Blockchain) removeImmatureSpends() {
+  next := blockchain.tipNode().height + 1
+  for _, entry := range blockchain.Mempool.Entries() {
+    if !blockchain.Mempool.Has(entry.ID) { // dropped with an ancestor
+      continue
+    }
+    for _, in := range entry.Tx.(*Transaction).Vin {
+      out, ok := blockchain.findUnspentOutput(in.Txid, in.Vout)
+      if !ok || !out.mature(next) {
+        mempoolLog.Debug("Dropped transaction spending an immature coinbase", "txid", entry.ID)
+        blockchain.Mempool.RemoveWithDescendants(entry.ID)
+        break
+      }
+    }
+  }
+}
+
+// create the method that tells if a transaction is in the main chain or the mempool, the lock must be held
+// Without the transaction index only the mempool is known, a transaction spending a spent output waits as an orphan
+func (blockchain *Blockchain) knownTransaction(txid []byte) bool {
+  if blockchain.Mempool.Has(hex.EncodeToString(txid)) {
+    return true
+  }
+  if !blockchain.txIndex {
+    return false
+  }
+  hash, err := blockchain.db.Get(storage.TxIndexBucket, txid) // the index of the main chain transactions
+  return err != nil || hash != nil                              // a failing store is no reason to keep an orphan
+}
+
+// create a function that tells if a list of hashes holds a hash
+func containsHash(hashes [][]byte, hash []byte) bool {
+  for _, h := range hashes {
+    if bytes.Equal(h, hash) {
+      return true
+    }
+  }
+  return false
+}
+
+// The bytes of a block kept for its header, its coinbase and the type descriptions of its encoding, the mempool
+// transactions fill the rest
+const blockReservedSize = 2000
+
+// create the function that returns the bytes of a block the mempool transactions may fill
+func maxBlockTxsSize() int {
+  return activeNet.BlockSizeLimit() - blockReservedSize
+}
+
+// create the method that selects the mempool transactions for the next block, parents before children, with the fees they pay
+// They fit in the block size limit of the network with the coinbase
+func (blockchain *Blockchain) MempoolTransactions() ([]*Transaction, int) {
+  var txs []*Transaction
+  fees := 0
+  for _, entry := range blockchain.Mempool.Select(maxBlockTxsSize()) { // the highest feerates first
+    txs = append(txs, entry.Tx.(*Transaction))
+    fees += entry.Fee
+  }
+  return txs, fees
+}
+
+// create the method that removes the transactions of a new block at a height from the mempool and the orphan pool, then
+// the mempool transactions spending the same outputs as the block, which can never be mined on this chain, and the
+// expired ones; the fee estimator learns how long the mined ones waited
+func (blockchain *Blockchain) removeMinedTransactions(block *Block, height int) {
+  var mined []string // the IDs of the transactions of the block
+  for _, tx := range block.Transactions {
+    mined = append(mined, hex.EncodeToString(tx.ID))
+    blockchain.Mempool.Remove(hex.EncodeToString(tx.ID))
+    blockchain.Orphans.Remove(hex.EncodeToString(tx.ID)) // an orphan may be mined by a node that had its parents
+  }
+  blockchain.fees.processBlock(height, mined)
+  for _, tx := range block.Transactions {
+    if tx.IsCoinbase() {
+      continue
+    }
+    var spends []mempool.Outpoint
+    for _, in := range tx.Vin {
+      spends = append(spends, mempool.Outpoint{Txid: hex.EncodeToString(in.Txid), Index: in.Vout})
+    }
+    for _, id := range blockchain.Mempool.RemoveConflicts(spends) { // a competing spend was confirmed
+      mempoolLog.Info("Evicted transaction conflicting with a block", "txid", id, "block", block.MyBlockHash, "spend", tx.ID)
+    }
+  }
+  for _, id := range blockchain.Mempool.Expire() { // and the ones nobody mined for too long
+    mempoolLog.Info("Expired transaction waiting too long to be mined", "txid", id)
+  }
+}
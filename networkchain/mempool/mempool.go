@@ -0,0 +1,388 @@
+// Package mempool holds the transactions waiting to be mined.
+// The pool does not know the transaction format: callers describe each transaction
+// with an Entry (ID, size, fee and the outputs it spends) and keep the transaction itself in Tx.
+package mempool
+
+import (
+  "errors" // for the admission errors
+  "fmt"    // to name the conflicting transaction
+  "math"   // to decay the minimum feerate
+  "sort"   // to order the entries by feerate
+  "sync"   // the pool is shared by the connection goroutines
+  "time"   // to remember when an entry was added
+)
+
+// Define some constants for the pool
+const (
+  DefaultMaxSize     = 5 << 20             // the size limit of the pool, in serialized bytes
+  DefaultExpiry      = 14 * 24 * time.Hour // how long a transaction waits to be mined before it is dropped
+  IncrementalFeeRate = 0.001               // the feerate per byte a transaction pays above the evicted ones to get in a full pool
+  minFeeHalfLife     = 12 * time.Hour      // how fast the minimum feerate raised by an eviction falls back
+)
+
+// Define the errors returned when a transaction is refused
+var (
+  ErrDuplicate   = errors.New("mempool: transaction already in the pool")
+  ErrDoubleSpend = errors.New("mempool: transaction spends an output already spent in the pool")
+  ErrTooLarge    = errors.New("mempool: transaction is larger than the pool")
+  ErrFeeTooLow   = errors.New("mempool: pool is full and the feerate is too low")
+  ErrBelowMinFee = errors.New("mempool: feerate below the minimum of the pool")
+)
+
+// Define a struct for an output reference
+type Outpoint struct {
+  Txid  string // the hex ID of the transaction holding the output
+  Index int    // the index of the output
+}
+
+// Define a struct for a transaction in the pool
+type Entry struct {
+  ID      string      // the hex ID of the transaction
+  Tx      interface{} // the transaction itself
+  Size    int         // the serialized size in bytes
+  Fee     int         // the fee paid by the transaction
+  Spends  []Outpoint  // the outputs spent by the transaction
+  Added   time.Time   // when the transaction entered the pool
+  parents []string    // the pool transactions whose outputs are spent
+}
+
+// Define a method to get the fee paid per byte
+func (e *Entry) FeeRate() float64 {
+  if e.Size == 0 {
+    return 0
+  }
+  return float64(e.Fee) / float64(e.Size)
+}
+
+// Define a struct for the pool
+// Besides its size, the pool bounds the feerate it accepts: the fixed minimum relay feerate, and a minimum raised above
+// the feerate of the transactions evicted when it is full, which halves every minFeeHalfLife once the pressure is gone,
+// faster while the pool is mostly empty, so a spam wave cannot be replaced at once by a slightly better paid one
+type Pool struct {
+  mu           sync.RWMutex        // the lock protecting everything below
+  entries      map[string]*Entry   // the transactions, by ID
+  spent        map[Outpoint]string // the transaction spending each output
+  size         int                 // the total size of the transactions
+  maxSize      int                 // the size limit
+  expiry       time.Duration       // how long a transaction stays without being mined
+  relayFeeRate float64             // the fixed minimum feerate per byte
+  evictFeeRate float64             // the minimum feerate per byte raised by the evictions, decaying
+  evictUpdated time.Time           // when evictFeeRate was last decayed
+}
+
+// Define a function to create a pool holding at most maxSize bytes of transactions
+func New(maxSize int) *Pool {
+  return &Pool{entries: map[string]*Entry{}, spent: map[Outpoint]string{}, maxSize: maxSize, expiry: DefaultExpiry}
+}
+
+// Define a method to set how long a transaction stays in the pool without being mined
+func (p *Pool) SetExpiry(expiry time.Duration) {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  p.expiry = expiry
+}
+
+// Define a method to set the feerate per byte below which transactions are refused even when the pool has room
+func (p *Pool) SetMinRelayFeeRate(feeRate float64) {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  p.relayFeeRate = feeRate
+}
+
+// Define a method to get the feerate per byte a transaction must pay to enter the pool
+func (p *Pool) MinFeeRate() float64 {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  return p.minFeeRate(time.Now())
+}
+
+// Define a method to get the feerate per byte a transaction must pay to enter the pool, decaying the minimum raised by
+// the evictions, the lock must be held
+func (p *Pool) minFeeRate(now time.Time) float64 {
+  if p.evictFeeRate > 0 {
+    halfLife := minFeeHalfLife
+    if p.size < p.maxSize/4 { // the pressure is gone
+      halfLife /= 4
+    } else if p.size < p.maxSize/2 {
+      halfLife /= 2
+    }
+    p.evictFeeRate *= math.Pow(0.5, float64(now.Sub(p.evictUpdated))/float64(halfLife))
+    if p.evictFeeRate < IncrementalFeeRate/2 {
+      p.evictFeeRate = 0
+    }
+  }
+  p.evictUpdated = now
+  if p.evictFeeRate > p.relayFeeRate {
+    return p.evictFeeRate
+  }
+  return p.relayFeeRate
+}
+
+// Define a method to add a transaction, evicting the lowest feerate transactions if the pool is full, after dropping
+// the transactions waiting for longer than the expiry
+func (p *Pool) Add(e *Entry) error {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  if _, ok := p.entries[e.ID]; ok {
+    return ErrDuplicate
+  }
+  for _, out := range e.Spends { // an output can only be spent once, the first spend seen wins
+    if spender, ok := p.spent[out]; ok {
+      return fmt.Errorf("%w: output %s:%d is spent by %s", ErrDoubleSpend, out.Txid, out.Index, spender)
+    }
+  }
+  if e.Size > p.maxSize {
+    return ErrTooLarge
+  }
+  now := time.Now()
+  p.expire(now)
+  if minimum := p.minFeeRate(now); e.FeeRate() < minimum {
+    return fmt.Errorf("%w: it pays %.4f per byte, the pool asks %.4f", ErrBelowMinFee, e.FeeRate(), minimum)
+  }
+  if p.size+e.Size > p.maxSize { // make room by evicting cheaper transactions
+    victims := p.evictionCandidates(e)
+    if victims == nil {
+      return ErrFeeTooLow
+    }
+    for _, id := range victims {
+      if victim, ok := p.entries[id]; ok && victim.FeeRate()+IncrementalFeeRate > p.evictFeeRate { // the next ones must pay more
+        p.evictFeeRate = victim.FeeRate() + IncrementalFeeRate
+      }
+      p.removeWithDescendants(id) // a victim may be gone with its evicted parent
+    }
+  }
+  if e.Added.IsZero() {
+    e.Added = now
+  }
+  e.parents = nil
+  for _, out := range e.Spends { // remember the pool parents so they are mined first and evicted together
+    if _, ok := p.entries[out.Txid]; ok {
+      e.parents = append(e.parents, out.Txid)
+    }
+    p.spent[out] = e.ID
+  }
+  p.entries[e.ID] = e
+  p.size += e.Size
+  return nil
+}
+
+// Define a method to pick the transactions to evict so a new entry fits, nil if the entry does not pay enough
+func (p *Pool) evictionCandidates(e *Entry) []string {
+  sorted := p.sortedByFeeRate()
+  ancestors := p.ancestors(e) // the new entry cannot make room by evicting what it spends
+  var victims []string
+  freed := 0
+  for i := len(sorted) - 1; i >= 0 && p.size-freed+e.Size > p.maxSize; i-- { // start from the cheapest
+    if ancestors[sorted[i].ID] {
+      continue
+    }
+    if sorted[i].FeeRate() >= e.FeeRate() {
+      return nil // everything left pays at least as much as the new entry
+    }
+    victims = append(victims, sorted[i].ID)
+    freed += sorted[i].Size
+  }
+  if p.size-freed+e.Size > p.maxSize {
+    return nil
+  }
+  return victims
+}
+
+// Define a method to find the pool transactions an entry depends on, its parents, their parents and so on, the lock must
+// be held; the entry need not be in the pool yet
+func (p *Pool) ancestors(e *Entry) map[string]bool {
+  ancestors := map[string]bool{}
+  var pending []string
+  for _, out := range e.Spends { // the parents are the pool transactions whose outputs it spends
+    pending = append(pending, out.Txid)
+  }
+  for len(pending) > 0 {
+    id := pending[len(pending)-1]
+    pending = pending[:len(pending)-1]
+    parent, ok := p.entries[id]
+    if !ok || ancestors[id] { // confirmed already, or seen through another path
+      continue
+    }
+    ancestors[id] = true
+    pending = append(pending, parent.parents...)
+  }
+  return ancestors
+}
+
+// Define a method to remove a transaction, for example once it is mined
+func (p *Pool) Remove(id string) {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  p.remove(id)
+}
+
+// Define a method to remove a transaction and every pool transaction depending on it
+func (p *Pool) RemoveWithDescendants(id string) {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  p.removeWithDescendants(id)
+}
+
+// Define a method to remove the transactions spending any of some outputs, with their descendants, once a block
+// confirmed another spend of the outputs; it returns the IDs of the conflicting transactions removed
+func (p *Pool) RemoveConflicts(spends []Outpoint) []string {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  var removed []string
+  for _, out := range spends {
+    if spender, ok := p.spent[out]; ok {
+      removed = append(removed, spender)
+      p.removeWithDescendants(spender)
+    }
+  }
+  return removed
+}
+
+// Define a method to remove the transactions waiting in the pool for longer than the expiry, with their descendants,
+// returning the IDs of the expired ones
+func (p *Pool) Expire() []string {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  return p.expire(time.Now())
+}
+
+// Define a method to remove the transactions waiting for longer than the expiry, the lock must be held
+func (p *Pool) expire(now time.Time) []string {
+  var expired []string
+  for id, e := range p.entries {
+    if now.Sub(e.Added) > p.expiry {
+      expired = append(expired, id)
+    }
+  }
+  for _, id := range expired { // a descendant may be removed with an expired parent already
+    p.removeWithDescendants(id)
+  }
+  return expired
+}
+
+// Define a method to remove a transaction, the lock must be held
+func (p *Pool) remove(id string) {
+  e, ok := p.entries[id]
+  if !ok {
+    return
+  }
+  for _, out := range e.Spends {
+    if p.spent[out] == id {
+      delete(p.spent, out)
+    }
+  }
+  delete(p.entries, id)
+  p.size -= e.Size
+}
+
+// Define a method to remove a transaction and its descendants, the lock must be held
+func (p *Pool) removeWithDescendants(id string) {
+  if _, ok := p.entries[id]; !ok {
+    return
+  }
+  var children []string
+  for _, e := range p.entries { // the children spend one of our outputs
+    for _, parent := range e.parents {
+      if parent == id {
+        children = append(children, e.ID)
+        break
+      }
+    }
+  }
+  p.remove(id)
+  for _, child := range children {
+    p.removeWithDescendants(child)
+  }
+}
+
+// Define a method to check whether a transaction is in the pool
+func (p *Pool) Has(id string) bool {
+  p.mu.RLock()
+  defer p.mu.RUnlock()
+  _, ok := p.entries[id]
+  return ok
+}
+
+// Define a method to get a transaction of the pool, nil if it is not there
+func (p *Pool) Get(id string) *Entry {
+  p.mu.RLock()
+  defer p.mu.RUnlock()
+  return p.entries[id]
+}
+
+// Define a method to find which pool transaction spends an output, "" if none
+func (p *Pool) SpentBy(out Outpoint) string {
+  p.mu.RLock()
+  defer p.mu.RUnlock()
+  return p.spent[out]
+}
+
+// Define a method to count the transactions of the pool
+func (p *Pool) Count() int {
+  p.mu.RLock()
+  defer p.mu.RUnlock()
+  return len(p.entries)
+}
+
+// Define a method to get the total size of the transactions of the pool
+func (p *Pool) Size() int {
+  p.mu.RLock()
+  defer p.mu.RUnlock()
+  return p.size
+}
+
+// Define a method to list every transaction of the pool, highest feerate first
+func (p *Pool) Entries() []*Entry {
+  p.mu.RLock()
+  defer p.mu.RUnlock()
+  return p.sortedByFeeRate()
+}
+
+// Define a method to sort the entries by feerate, highest first, the lock must be held
+func (p *Pool) sortedByFeeRate() []*Entry {
+  sorted := make([]*Entry, 0, len(p.entries))
+  for _, e := range p.entries {
+    sorted = append(sorted, e)
+  }
+  sort.Slice(sorted, func(i, j int) bool {
+    if sorted[i].FeeRate() != sorted[j].FeeRate() {
+      return sorted[i].FeeRate() > sorted[j].FeeRate()
+    }
+    return sorted[i].Added.Before(sorted[j].Added) // older first on ties
+  })
+  return sorted
+}
+
+// Define a method to select transactions for a block of at most maxSize bytes
+// The highest feerates are taken first and a transaction is only taken after its pool parents,
+// so the selection can be mined in the returned order
+func (p *Pool) Select(maxSize int) []*Entry {
+  p.mu.RLock()
+  defer p.mu.RUnlock()
+  var selected []*Entry
+  included := map[string]bool{}
+  size := 0
+  remaining := p.sortedByFeeRate()
+  for progress := true; progress; { // loop while something was added, a child may become ready after its parent
+    progress = false
+    var next []*Entry
+    for _, e := range remaining {
+      ready := true
+      for _, parent := range e.parents {
+        if _, inPool := p.entries[parent]; inPool && !included[parent] {
+          ready = false
+        }
+      }
+      if !ready || size+e.Size > maxSize {
+        next = append(next, e)
+        continue
+      }
+      selected = append(selected, e)
+      included[e.ID] = true
+      size += e.Size
+      progress = true
+    }
+    remaining = next
+  }
+  return selected
+}
@@ -0,0 +1,101 @@
+package mempool
+
+import (
+  "errors"  // to match the errors
+  "testing" // the test framework
+)
+
+// Define a function to make an entry of 100 bytes paying a fee and spending the first output of some transactions
+func entry(id string, fee int, parents ...string) *Entry {
+  e := &Entry{ID: id, Size: 100, Fee: fee}
+  for _, parent := range parents {
+    e.Spends = append(e.Spends, Outpoint{parent, 0})
+  }
+  return e
+}
+
+func TestEviction(t *testing.T) {
+  tests := []struct {
+    name    string
+    maxSize int
+    pool    []*Entry // the entries added first, filling the pool
+    add     *Entry   // the entry that needs room
+    err     error    // the error adding it
+    kept    []string // the entries left in the pool
+    evicted []string // the entries evicted
+  }{
+    {
+      name:    "the cheapest entry is evicted",
+      maxSize: 200,
+      pool:    []*Entry{entry("a", 100), entry("b", 200)},
+      add:     entry("c", 1000),
+      kept:    []string{"b", "c"},
+      evicted: []string{"a"},
+    },
+    {
+      name:    "a parent is never evicted",
+      maxSize: 200,
+      pool:    []*Entry{entry("a", 100), entry("b", 200)},
+      add:     entry("c", 1000, "a"),
+      kept:    []string{"a", "c"},
+      evicted: []string{"b"},
+    },
+    {
+      name:    "a grandparent is never evicted",
+      maxSize: 300,
+      pool:    []*Entry{entry("a", 100), entry("b", 500, "a"), entry("c", 200)},
+      add:     entry("d", 1000, "b"),
+      kept:    []string{"a", "b", "d"},
+      evicted: []string{"c"},
+    },
+    {
+      name:    "ancestors through several parents are never evicted",
+      maxSize: 400,
+      pool:    []*Entry{entry("a", 100), entry("b", 150), entry("c", 500, "a", "b"), entry("d", 300)},
+      add:     entry("e", 1000, "c"),
+      kept:    []string{"a", "b", "c", "e"},
+      evicted: []string{"d"},
+    },
+    {
+      name:    "no room besides the ancestors",
+      maxSize: 200,
+      pool:    []*Entry{entry("a", 100), entry("b", 500, "a")},
+      add:     entry("c", 1000, "b"),
+      err:     ErrFeeTooLow,
+      kept:    []string{"a", "b"},
+      evicted: []string{"c"},
+    },
+    {
+      name:    "everything pays more",
+      maxSize: 200,
+      pool:    []*Entry{entry("a", 500), entry("b", 600)},
+      add:     entry("c", 100),
+      err:     ErrFeeTooLow,
+      kept:    []string{"a", "b"},
+      evicted: []string{"c"},
+    },
+  }
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      pool := New(test.maxSize)
+      for _, e := range test.pool {
+        if err := pool.Add(e); err != nil {
+          t.Fatalf("adding %s: %v", e.ID, err)
+        }
+      }
+      if err := pool.Add(test.add); !errors.Is(err, test.err) {
+        t.Fatalf("adding %s: err = %v, want %v", test.add.ID, err, test.err)
+      }
+      for _, id := range test.kept {
+        if !pool.Has(id) {
+          t.Errorf("%s is not in the pool", id)
+        }
+      }
+      for _, id := range test.evicted {
+        if pool.Has(id) {
+          t.Errorf("%s is still in the pool", id)
+        }
+      }
+    })
+  }
+}
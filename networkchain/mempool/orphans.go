@@ -0,0 +1,140 @@
+package mempool
+
+import (
+  "errors" // for the admission errors
+  "sync"   // the pool is shared by the connection goroutines
+  "time"   // to expire the orphans
+)
+
+// Define some constants for the orphan pool
+const (
+  DefaultMaxOrphans = 100              // the default number of orphans kept
+  MaxOrphanSize     = 100000           // the largest orphan kept, in serialized bytes, so orphans cannot fill the memory
+  OrphanExpiry      = 20 * time.Minute // how long an orphan waits for its parents
+)
+
+// Define an error returned when an orphan is too large to be kept
+var ErrOrphanTooLarge = errors.New("mempool: orphan transaction is too large to be kept")
+
+// Define a struct for a transaction spending outputs of transactions not seen yet
+type Orphan struct {
+  ID      string      // the hex ID of the transaction
+  Tx      interface{} // the transaction itself
+  Size    int         // the serialized size in bytes
+  Missing []string    // the hex IDs of the parents not seen yet
+  Added   time.Time   // when the orphan was parked
+}
+
+// Define a struct for the pool of orphans, waiting for their parents to enter the mempool or a block
+type OrphanPool struct {
+  mu       sync.Mutex                 // the lock protecting everything below
+  orphans  map[string]*Orphan         // the orphans, by ID
+  byParent map[string]map[string]bool // the orphans waiting for each missing parent
+  max      int                        // the number of orphans kept
+}
+
+// Define a function to create an orphan pool keeping at most max orphans
+func NewOrphanPool(max int) *OrphanPool {
+  return &OrphanPool{orphans: map[string]*Orphan{}, byParent: map[string]map[string]bool{}, max: max}
+}
+
+// Define a method to park an orphan, evicting the expired orphans then the oldest ones when the pool is full
+func (p *OrphanPool) Add(o *Orphan) error {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  if _, ok := p.orphans[o.ID]; ok {
+    return nil
+  }
+  if o.Size > MaxOrphanSize {
+    return ErrOrphanTooLarge
+  }
+  if o.Added.IsZero() {
+    o.Added = time.Now()
+  }
+  p.expire(o.Added)
+  for len(p.orphans) >= p.max && len(p.orphans) > 0 {
+    p.remove(p.oldest())
+  }
+  if p.max <= 0 {
+    return nil
+  }
+  p.orphans[o.ID] = o
+  for _, parent := range o.Missing {
+    if p.byParent[parent] == nil {
+      p.byParent[parent] = map[string]bool{}
+    }
+    p.byParent[parent][o.ID] = true
+  }
+  return nil
+}
+
+// Define a method to remove the orphans parked for longer than OrphanExpiry, the lock must be held
+func (p *OrphanPool) expire(now time.Time) {
+  for id, o := range p.orphans {
+    if now.Sub(o.Added) > OrphanExpiry {
+      p.remove(id)
+    }
+  }
+}
+
+// Define a method to find the oldest orphan, the lock must be held
+func (p *OrphanPool) oldest() string {
+  var oldest *Orphan
+  for _, o := range p.orphans {
+    if oldest == nil || o.Added.Before(oldest.Added) {
+      oldest = o
+    }
+  }
+  return oldest.ID
+}
+
+// Define a method to remove an orphan, once it entered the mempool or was refused
+func (p *OrphanPool) Remove(id string) {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  p.remove(id)
+}
+
+// Define a method to remove an orphan, the lock must be held
+func (p *OrphanPool) remove(id string) {
+  o, ok := p.orphans[id]
+  if !ok {
+    return
+  }
+  for _, parent := range o.Missing {
+    delete(p.byParent[parent], id)
+    if len(p.byParent[parent]) == 0 {
+      delete(p.byParent, parent)
+    }
+  }
+  delete(p.orphans, id)
+}
+
+// Define a method to take the orphans waiting for a parent out of the pool, to try them again now that the parent is known
+func (p *OrphanPool) TakeChildren(parent string) []*Orphan {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  var children []*Orphan
+  for id := range p.byParent[parent] {
+    children = append(children, p.orphans[id])
+  }
+  for _, o := range children {
+    p.remove(o.ID)
+  }
+  return children
+}
+
+// Define a method to check if an orphan is parked
+func (p *OrphanPool) Has(id string) bool {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  _, ok := p.orphans[id]
+  return ok
+}
+
+// Define a method to count the orphans
+func (p *OrphanPool) Count() int {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  return len(p.orphans)
+}
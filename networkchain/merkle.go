@@ -0,0 +1,86 @@
+package main
+
+import (
+  "bytes"         // to compare a proof with the root
+  "crypto/sha256" // the hash used to build the tree
+)
+
+// Create the MerkleTree data structure
+// The leaves are the transaction IDs, every level above hashes pairs of nodes of the level below,
+// so the root commits to every transaction and their order
+type MerkleTree struct {
+  Levels [][][]byte // the hashes of each level, the leaves first and the root last
+}
+
+// Create a function that builds the tree of a list of hashes
+// A level with an odd number of nodes pairs its last node with itself
+func NewMerkleTree(leaves [][]byte) *MerkleTree {
+  if len(leaves) == 0 { // an empty tree has a single empty hash as root
+    empty := sha256.Sum256(nil)
+    return &MerkleTree{[][][]byte{{empty[:]}}}
+  }
+  level := append([][]byte{}, leaves...) // the level being built, starting with the leaves
+  tree := &MerkleTree{[][][]byte{level}}
+  for len(level) > 1 { // hash pairs until a single node is left
+    var next [][]byte
+    for i := 0; i < len(level); i += 2 {
+      right := level[i] // the last node of an odd level is paired with itself
+      if i+1 < len(level) {
+        right = level[i+1]
+      }
+      next = append(next, hashPair(level[i], right))
+    }
+    level = next
+    tree.Levels = append(tree.Levels, level)
+  }
+  return tree
+}
+
+// Create a function that hashes two nodes into their parent
+func hashPair(left, right []byte) []byte {
+  hash := sha256.Sum256(append(append([]byte{}, left...), right...))
+  return hash[:]
+}
+
+// Create a method that returns the root of the tree
+func (tree *MerkleTree) Root() []byte {
+  return tree.Levels[len(tree.Levels)-1][0]
+}
+
+// Create the MerkleProof data structure
+// A proof holds the hashes needed to go from a leaf up to the root: the sibling of the leaf,
+// then the sibling of their parent, and so on, so a transaction can be checked against a block
+// header without the other transactions
+type MerkleProof struct {
+  Index    int      // the position of the leaf, its bits tell on which side each sibling goes
+  Siblings [][]byte // the sibling hashes, from the leaves up
+}
+
+// Create a method that builds the proof of the leaf at an index
+func (tree *MerkleTree) Proof(index int) *MerkleProof {
+  proof := &MerkleProof{Index: index}
+  for _, level := range tree.Levels[:len(tree.Levels)-1] { // every level but the root
+    sibling := index ^ 1 // the other node of the pair
+    if sibling >= len(level) { // the last node of an odd level is paired with itself
+      sibling = index
+    }
+    proof.Siblings = append(proof.Siblings, level[sibling])
+    index /= 2 // move to the parent
+  }
+  return proof
+}
+
+// Create a method that checks that a leaf is part of the tree with a root
+func (proof *MerkleProof) Verify(leaf, root []byte) bool {
+  hash := leaf
+  index := proof.Index
+  for _, sibling := range proof.Siblings { // hash our way up
+    if index%2 == 0 {
+      hash = hashPair(hash, sibling) // we are the left node
+    } else {
+      hash = hashPair(sibling, hash) // we are the right node
+    }
+    index /= 2
+  }
+  return index == 0 && bytes.Equal(hash, root) // the index must not point past the tree
+}
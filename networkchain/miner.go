@@ -0,0 +1,177 @@
+package main
+
+import (
+  "bytes"                  // to tell if the mined block became the tip
+  "networkchain/consensus" // the nonce search of the proof of work
+  "networkchain/events"    // a new tip or transaction makes the template stale
+  "sync"                   // the workers and the state of the miner
+  "sync/atomic"            // the workers count their hashes
+  "time"                   // for the hash rate
+)
+
+// Define a struct for the CPU miner of a proof of work node
+// The miner builds a block template from the tip and the mempool and its workers search the nonce together, each one
+// trying its own share of the nonces; a new tip or a new mempool transaction makes the template stale, so the search is
+// aborted and the template rebuilt
+type cpuMiner struct {
+  n       *Node         // the node the blocks are mined for
+  mu      sync.Mutex    // the lock protecting the fields below
+  threads int           // the number of workers searching the nonces
+  stop    chan struct{} // closed to stop the running miner, nil while it is stopped
+  done    chan struct{} // closed when the running miner returned
+  started time.Time     // when the miner was started, for the hash rate
+  hashes  uint64        // the hashes tried since then, updated atomically by the workers
+  mined   int           // the blocks mined since then
+}
+
+// Define a struct for the state of the miner
+type minerStatus struct {
+  Running  bool    // whether the miner is searching
+  Threads  int     // the number of workers
+  HashRate float64 // the hashes per second since the miner started
+  Mined    int     // the blocks mined since the miner started
+}
+
+// Define a function to create the stopped miner of a node
+func newCPUMiner(n *Node, threads int) *cpuMiner {
+  return &cpuMiner{n: n, threads: threads}
+}
+
+// Define a method to start the miner with a number of workers, the current number if 0, restarting it if it runs
+func (m *cpuMiner) Start(threads int) {
+  m.Stop() // the workers of a running miner are replaced
+  m.mu.Lock() // lock the miner state
+  defer m.mu.Unlock() // unlock it when done
+  if threads > 0 {
+    m.threads = threads
+  }
+  m.stop, m.done = make(chan struct{}), make(chan struct{})
+  m.started, m.mined = time.Now(), 0
+  atomic.StoreUint64(&m.hashes, 0)
+  minerLog.Info("Started the CPU miner", "threads", m.threads)
+  go m.run(m.threads, m.stop, m.done)
+}
+
+// Define a method to stop the miner and wait for its workers, nothing happens if it is stopped
+func (m *cpuMiner) Stop() {
+  m.mu.Lock() // lock the miner state
+  stop, done := m.stop, m.done
+  m.stop, m.done = nil, nil
+  m.mu.Unlock() // unlock it, the loop takes the lock to count the blocks
+  if stop == nil {
+    return
+  }
+  close(stop) // abort the search
+  <-done // and wait for it
+  minerLog.Info("Stopped the CPU miner")
+}
+
+// Define a method to get the state of the miner
+func (m *cpuMiner) Status() minerStatus {
+  m.mu.Lock() // lock the miner state
+  defer m.mu.Unlock() // unlock it when done
+  status := minerStatus{Running: m.stop != nil, Threads: m.threads}
+  if status.Running {
+    status.HashRate = float64(atomic.LoadUint64(&m.hashes)) / time.Since(m.started).Seconds()
+    status.Mined = m.mined
+  }
+  return status
+}
+
+// Define a method to mine blocks until the miner or the node is stopped
+// The miner waits for enough mempool transactions, like a node mining on demand, then searches the nonce of a template
+func (m *cpuMiner) run(threads int, stop, done chan struct{}) {
+  defer close(done) // tell Stop the workers returned
+  sub := m.n.events.Subscribe(events.DefaultBuffer, events.TipChanged, events.TxAccepted, events.ReorgDetected) // the chain tells when the template is stale
+  defer sub.Cancel()
+  for {
+    drain(sub.C) // the template built now covers the events received so far
+    if m.n.bc.Mempool.Count() < m.n.minTxs { // wait for enough transactions
+      select {
+      case <-sub.C:
+        continue
+      case <-stop:
+        return
+      case <-m.n.quit:
+        return
+      }
+    }
+    block, fees := m.n.bc.NewBlockTemplate(m.n.minerAddress)
+    header, stopped := m.search(block, threads, sub.C, stop)
+    if stopped {
+      return
+    }
+    if header == nil { // the template is stale or its nonces ran out, build another one
+      continue
+    }
+    block.Nonce, block.MyBlockHash = header.Nonce, header.Hash // keep the nonce so anyone can check the work
+    if err := m.n.bc.AddBlock(block); err != nil { // a transaction may have been mined by someone else in the meantime
+      minerLog.Warn("Failed to add the mined block", "hash", block.MyBlockHash, "err", err)
+      continue
+    }
+    if !bytes.Equal(m.n.bc.Tip().MyBlockHash, block.MyBlockHash) { // another block took the tip just before
+      minerLog.Info("Mined a stale block", "hash", block.MyBlockHash)
+      continue
+    }
+    m.mu.Lock() // lock the miner state
+    m.mined++
+    m.mu.Unlock() // unlock it
+    minerLog.Info("Mined block", "hash", block.MyBlockHash, "height", m.n.bc.GetBestHeight(), "txs", len(block.Transactions), "fees", fees)
+    m.n.announceBlock(block)
+  }
+}
+
+// Define a method to search the nonce of a template with the workers, until one finds it, the chain publishes an event
+// or the miner stops; it returns the sealed header if found, and whether the miner stopped
+func (m *cpuMiner) search(block *Block, threads int, stale <-chan events.Event, stop chan struct{}) (*consensus.Header, bool) {
+  abort := make(chan struct{}) // closed to make the workers return
+  found := make(chan *consensus.Header, threads) // a worker never blocks on it
+  pow := consensus.NewProofOfWork(activeNet) // the workers hash the headers with the function of the network
+  var wg sync.WaitGroup
+  for i := 0; i < threads; i++ {
+    wg.Add(1)
+    go func(first int) {
+      defer wg.Done()
+      header := block.consensusHeader() // each worker sets the nonce of its own copy
+      ok, tried := pow.SearchNonce(header, first, threads, abort) // the worker tries one nonce out of threads
+      atomic.AddUint64(&m.hashes, uint64(tried))
+      if ok {
+        found <- header
+      }
+    }(i)
+  }
+  exhausted := make(chan struct{}) // closed when every worker returned
+  go func() {
+    wg.Wait()
+    close(exhausted)
+  }()
+  var header *consensus.Header
+  stopped := false
+  select {
+  case header = <-found: // a worker mined the block
+  case <-exhausted: // the nonces ran out, unless a worker found one last
+    select {
+    case header = <-found:
+    default:
+    }
+  case <-stale: // the tip moved or a transaction arrived
+  case <-stop:
+    stopped = true
+  case <-m.n.quit:
+    stopped = true
+  }
+  close(abort) // the other workers give up
+  <-exhausted
+  return header, stopped
+}
+
+// Define a function to discard the events waiting in a channel
+func drain(c <-chan events.Event) {
+  for {
+    select {
+    case <-c:
+    default:
+      return
+    }
+  }
+}
@@ -0,0 +1,110 @@
+// Package nat maps a port of the router in front of a node to the node, so peers on the internet can connect to a node
+// behind a home router, and finds the external address of the router for the node to advertise.
+// Two protocols are tried at once: NAT-PMP, asking the default gateway over UDP, and UPnP IGD, looking for an internet
+// gateway device with SSDP and talking SOAP to it. A mapping lasts for a lifetime and must be renewed before it ends.
+package nat
+
+import (
+  "bufio"   // to read the routing table
+  "errors"  // for the errors of the discovery
+  "fmt"     // to format the errors
+  "net"     // the addresses of the router
+  "os"      // to open the routing table
+  "strconv" // to read the gateway of the routing table
+  "strings" // to split the lines of the routing table
+  "time"    // for the timeouts and the lifetimes
+)
+
+// The error returned when no router answers
+var ErrNoRouter = errors.New("nat: no router supporting NAT-PMP or UPnP found")
+
+// Define an interface for a router mapping ports
+type NAT interface {
+  Type() string                                                                                         // the protocol spoken with the router
+  ExternalIP() (net.IP, error)                                                                          // the address of the router on the internet
+  AddMapping(protocol string, external, internal int, name string, lifetime time.Duration) (int, error) // map a tcp or udp port, returning the external port the router chose
+  DeleteMapping(protocol string, external, internal int) error                                          // remove a mapping
+}
+
+// Define a function to find the router in front of this host, asking with both protocols at once and taking the first to
+// answer within the timeout
+func Discover(timeout time.Duration) (NAT, error) {
+  found := make(chan NAT, 2)
+  failed := make(chan error, 2)
+  go func() {
+    gateway, err := defaultGateway()
+    if err != nil {
+      failed <- err
+      return
+    }
+    router := newPMP(gateway, timeout)
+    if _, err := router.ExternalIP(); err != nil { // a gateway answering the address request speaks NAT-PMP
+      failed <- err
+      return
+    }
+    found <- router
+  }()
+  go func() {
+    router, err := discoverUPnP(timeout)
+    if err != nil {
+      failed <- err
+      return
+    }
+    found <- router
+  }()
+  var errs []string
+  for len(errs) < 2 {
+    select {
+    case router := <-found:
+      return router, nil
+    case err := <-failed:
+      errs = append(errs, err.Error())
+    }
+  }
+  return nil, fmt.Errorf("%w: %s", ErrNoRouter, strings.Join(errs, ", "))
+}
+
+// Define a function to find the default gateway, from the routing table of Linux or, elsewhere, guessed as the first
+// address of the network of the host
+func defaultGateway() (net.IP, error) {
+  if gateway, err := routeGateway("/proc/net/route"); err == nil {
+    return gateway, nil
+  }
+  addrs, err := net.InterfaceAddrs()
+  if err != nil {
+    return nil, err
+  }
+  for _, addr := range addrs {
+    ipnet, ok := addr.(*net.IPNet)
+    if !ok || ipnet.IP.To4() == nil || !ipnet.IP.IsPrivate() { // the router gives private IPv4 addresses to its hosts
+      continue
+    }
+    gateway := ipnet.IP.Mask(ipnet.Mask).To4()
+    gateway[3]++ // the router usually takes the first address of its network
+    return gateway, nil
+  }
+  return nil, errors.New("nat: no default gateway found")
+}
+
+// Define a function to read the default gateway from a Linux routing table, whose addresses are hex little endian
+func routeGateway(path string) (net.IP, error) {
+  file, err := os.Open(path)
+  if err != nil {
+    return nil, err
+  }
+  defer file.Close()
+  scanner := bufio.NewScanner(file)
+  scanner.Scan() // skip the header
+  for scanner.Scan() {
+    fields := strings.Fields(scanner.Text())
+    if len(fields) < 3 || fields[1] != "00000000" { // the default route goes to any destination
+      continue
+    }
+    value, err := strconv.ParseUint(fields[2], 16, 32)
+    if err != nil || value == 0 {
+      continue
+    }
+    return net.IPv4(byte(value), byte(value>>8), byte(value>>16), byte(value>>24)), nil
+  }
+  return nil, errors.New("nat: no default route")
+}
@@ -0,0 +1,114 @@
+package nat
+
+import (
+  "encoding/binary" // the messages are big endian
+  "fmt"             // to format the errors
+  "net"             // to talk to the gateway
+  "strings"         // to read the protocol names
+  "time"            // for the timeouts and the lifetimes
+)
+
+// Define some constants of NAT-PMP (RFC 6886)
+const (
+  pmpPort       = 5351                   // the port of the gateway answering the requests
+  pmpFirstWait  = 250 * time.Millisecond // how long the first request waits for its answer, doubling at each retry
+  pmpOpExternal = 0                      // the operation asking the external address
+  pmpOpMapUDP   = 1                      // the operation mapping a udp port
+  pmpOpMapTCP   = 2                      // the operation mapping a tcp port
+  pmpResponse   = 128                    // added to the operation in its answer
+)
+
+// Define a struct for a gateway speaking NAT-PMP
+type pmp struct {
+  gateway net.IP        // the default gateway
+  timeout time.Duration // how long a request may take, retries included
+}
+
+// Define a function to create the client of a gateway
+func newPMP(gateway net.IP, timeout time.Duration) *pmp {
+  return &pmp{gateway, timeout}
+}
+
+// Define a method to name the protocol
+func (p *pmp) Type() string {
+  return "NAT-PMP"
+}
+
+// Define a method to ask the gateway its external address
+func (p *pmp) ExternalIP() (net.IP, error) {
+  response, err := p.request([]byte{0, pmpOpExternal}, 12)
+  if err != nil {
+    return nil, err
+  }
+  return net.IPv4(response[8], response[9], response[10], response[11]), nil
+}
+
+// Define a method to map a port, the gateway may choose another external port than the one suggested
+func (p *pmp) AddMapping(protocol string, external, internal int, name string, lifetime time.Duration) (int, error) {
+  op, err := pmpOp(protocol)
+  if err != nil {
+    return 0, err
+  }
+  request := make([]byte, 12)
+  request[1] = op
+  binary.BigEndian.PutUint16(request[4:], uint16(internal))
+  binary.BigEndian.PutUint16(request[6:], uint16(external))
+  binary.BigEndian.PutUint32(request[8:], uint32(lifetime/time.Second))
+  response, err := p.request(request, 16)
+  if err != nil {
+    return 0, err
+  }
+  return int(binary.BigEndian.Uint16(response[10:])), nil
+}
+
+// Define a method to remove a mapping, which is mapping it again for no time
+func (p *pmp) DeleteMapping(protocol string, external, internal int) error {
+  _, err := p.AddMapping(protocol, 0, internal, "", 0)
+  return err
+}
+
+// Define a function to get the operation mapping a protocol
+func pmpOp(protocol string) (byte, error) {
+  switch strings.ToLower(protocol) {
+  case "tcp":
+    return pmpOpMapTCP, nil
+  case "udp":
+    return pmpOpMapUDP, nil
+  }
+  return 0, fmt.Errorf("nat: unknown protocol %q", protocol)
+}
+
+// Define a method to send a request to the gateway until it answers or the timeout passes, and check the answer
+func (p *pmp) request(request []byte, size int) ([]byte, error) {
+  conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: p.gateway, Port: pmpPort})
+  if err != nil {
+    return nil, err
+  }
+  defer conn.Close()
+  deadline := time.Now().Add(p.timeout)
+  response := make([]byte, 16)
+  for wait := pmpFirstWait; time.Now().Before(deadline); wait *= 2 {
+    if _, err := conn.Write(request); err != nil {
+      return nil, err
+    }
+    until := time.Now().Add(wait)
+    if until.After(deadline) {
+      until = deadline
+    }
+    conn.SetReadDeadline(until)
+    for {
+      read, err := conn.Read(response)
+      if err != nil { // nothing yet, send the request again
+        break
+      }
+      if read < size || response[0] != 0 || response[1] != request[1]+pmpResponse { // not the answer to this request
+        continue
+      }
+      if code := binary.BigEndian.Uint16(response[2:]); code != 0 {
+        return nil, fmt.Errorf("nat: NAT-PMP gateway %s refused the request with code %d", p.gateway, code)
+      }
+      return response[:size], nil
+    }
+  }
+  return nil, fmt.Errorf("nat: no NAT-PMP answer from %s", p.gateway)
+}
@@ -0,0 +1,244 @@
+package nat
+
+import (
+  "bufio"        // to read the SSDP answers
+  "bytes"        // to read the SSDP answers and build the SOAP requests
+  "encoding/xml" // the descriptions and the SOAP messages
+  "errors"       // for the errors of the discovery
+  "fmt"          // to format the errors
+  "io"           // to bound the answers read
+  "net"          // to search the devices
+  "net/http"     // to talk to the device
+  "net/url"      // to resolve the control address
+  "strconv"      // to format the ports
+  "strings"      // to match the service types
+  "time"         // for the timeouts and the lifetimes
+)
+
+// Define some constants of UPnP IGD
+const (
+  ssdpAddress    = "239.255.255.250:1900"                                // the multicast group the devices listen on
+  igdDevice      = "urn:schemas-upnp-org:device:InternetGatewayDevice:1" // the devices searched
+  maxDescription = 1 << 20                                               // the largest description or SOAP answer read
+)
+
+// The services of a gateway device mapping ports, the first found is used
+var igdServices = []string{
+  "urn:schemas-upnp-org:service:WANIPConnection:2",
+  "urn:schemas-upnp-org:service:WANIPConnection:1",
+  "urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// Define a struct for a gateway device speaking UPnP
+type upnp struct {
+  service    string       // the type of the service mapping the ports
+  controlURL string       // the address of the service
+  localIP    net.IP       // the address of this host on the network of the device, the internal client of the mappings
+  client     *http.Client // the client of the SOAP requests
+}
+
+// Define a method to name the protocol
+func (u *upnp) Type() string {
+  return "UPnP"
+}
+
+// Define a method to ask the device its external address
+func (u *upnp) ExternalIP() (net.IP, error) {
+  var response struct {
+    IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+  }
+  if err := u.call("GetExternalIPAddress", "", &response); err != nil {
+    return nil, err
+  }
+  ip := net.ParseIP(strings.TrimSpace(response.IP))
+  if ip == nil {
+    return nil, fmt.Errorf("nat: invalid external address %q", response.IP)
+  }
+  return ip, nil
+}
+
+// Define a method to map a port, the device keeps the external port asked for
+func (u *upnp) AddMapping(protocol string, external, internal int, name string, lifetime time.Duration) (int, error) {
+  args := soapArgs(
+    "NewRemoteHost", "",
+    "NewExternalPort", strconv.Itoa(external),
+    "NewProtocol", strings.ToUpper(protocol),
+    "NewInternalPort", strconv.Itoa(internal),
+    "NewInternalClient", u.localIP.String(),
+    "NewEnabled", "1",
+    "NewPortMappingDescription", name,
+    "NewLeaseDuration", strconv.Itoa(int(lifetime/time.Second)),
+  )
+  if err := u.call("AddPortMapping", args, nil); err != nil {
+    return 0, err
+  }
+  return external, nil
+}
+
+// Define a method to remove a mapping
+func (u *upnp) DeleteMapping(protocol string, external, internal int) error {
+  args := soapArgs("NewRemoteHost", "", "NewExternalPort", strconv.Itoa(external), "NewProtocol", strings.ToUpper(protocol))
+  return u.call("DeletePortMapping", args, nil)
+}
+
+// Define a function to search a gateway device with SSDP and read its description, within a timeout
+func discoverUPnP(timeout time.Duration) (*upnp, error) {
+  conn, err := net.ListenPacket("udp4", ":0")
+  if err != nil {
+    return nil, err
+  }
+  defer conn.Close()
+  group, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+  if err != nil {
+    return nil, err
+  }
+  search := "M-SEARCH * HTTP/1.1\r\nHOST: " + ssdpAddress + "\r\nST: " + igdDevice + "\r\nMAN: \"ssdp:discover\"\r\nMX: 2\r\n\r\n"
+  if _, err := conn.WriteTo([]byte(search), group); err != nil {
+    return nil, err
+  }
+  deadline := time.Now().Add(timeout)
+  conn.SetReadDeadline(deadline)
+  buffer := make([]byte, 2048)
+  for {
+    read, _, err := conn.ReadFrom(buffer)
+    if err != nil {
+      return nil, errors.New("nat: no UPnP gateway device answered")
+    }
+    response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buffer[:read])), nil)
+    if err != nil || response.Header.Get("Location") == "" {
+      continue
+    }
+    router, err := describeUPnP(response.Header.Get("Location"), timeout)
+    if err != nil { // a device without a port mapping service, keep looking
+      continue
+    }
+    return router, nil
+  }
+}
+
+// Define a struct for the description of a UPnP device and its embedded devices
+type upnpDevice struct {
+  Services []struct {
+    ServiceType string `xml:"serviceType"`
+    ControlURL  string `xml:"controlURL"`
+  } `xml:"serviceList>service"`
+  Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// Define a method to find the control address of a service in a device or its embedded devices
+func (d *upnpDevice) find(service string) string {
+  for _, s := range d.Services {
+    if strings.TrimSpace(s.ServiceType) == service {
+      return strings.TrimSpace(s.ControlURL)
+    }
+  }
+  for i := range d.Devices {
+    if control := d.Devices[i].find(service); control != "" {
+      return control
+    }
+  }
+  return ""
+}
+
+// Define a function to read the description of a device and find its port mapping service
+func describeUPnP(location string, timeout time.Duration) (*upnp, error) {
+  base, err := url.Parse(location)
+  if err != nil {
+    return nil, err
+  }
+  client := &http.Client{Timeout: timeout}
+  response, err := client.Get(location)
+  if err != nil {
+    return nil, err
+  }
+  defer response.Body.Close()
+  if response.StatusCode != http.StatusOK {
+    return nil, fmt.Errorf("nat: description %s: %s", location, response.Status)
+  }
+  var root struct {
+    URLBase string     `xml:"URLBase"`
+    Device  upnpDevice `xml:"device"`
+  }
+  if err := xml.NewDecoder(io.LimitReader(response.Body, maxDescription)).Decode(&root); err != nil {
+    return nil, err
+  }
+  if root.URLBase != "" { // the control addresses are relative to the base if there is one, else to the description
+    if base, err = url.Parse(strings.TrimSpace(root.URLBase)); err != nil {
+      return nil, err
+    }
+  }
+  for _, service := range igdServices {
+    control := root.Device.find(service)
+    if control == "" {
+      continue
+    }
+    controlURL, err := base.Parse(control)
+    if err != nil {
+      return nil, err
+    }
+    localIP, err := localAddress(controlURL.Host)
+    if err != nil {
+      return nil, err
+    }
+    return &upnp{service, controlURL.String(), localIP, client}, nil
+  }
+  return nil, fmt.Errorf("nat: %s has no port mapping service", location)
+}
+
+// Define a function to find the address of this host on the route to a device
+func localAddress(host string) (net.IP, error) {
+  if _, _, err := net.SplitHostPort(host); err != nil { // the default http port
+    host = net.JoinHostPort(host, "80")
+  }
+  conn, err := net.Dial("udp4", host) // nothing is sent, the system only picks the route
+  if err != nil {
+    return nil, err
+  }
+  defer conn.Close()
+  return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// Define a function to write the arguments of a SOAP action from name and value pairs
+func soapArgs(pairs ...string) string {
+  var args bytes.Buffer
+  for i := 0; i+1 < len(pairs); i += 2 {
+    args.WriteString("<" + pairs[i] + ">")
+    xml.EscapeText(&args, []byte(pairs[i+1]))
+    args.WriteString("</" + pairs[i] + ">")
+  }
+  return args.String()
+}
+
+// Define a method to call an action of the service and decode its answer, a SOAP fault is returned as an error
+func (u *upnp) call(action, args string, result interface{}) error {
+  body := `<?xml version="1.0"?>` +
+    `<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+    `<s:Body><u:` + action + ` xmlns:u="` + u.service + `">` + args + `</u:` + action + `></s:Body></s:Envelope>`
+  request, err := http.NewRequest(http.MethodPost, u.controlURL, strings.NewReader(body))
+  if err != nil {
+    return err
+  }
+  request.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+  request.Header.Set("SOAPAction", `"`+u.service+"#"+action+`"`)
+  response, err := u.client.Do(request)
+  if err != nil {
+    return err
+  }
+  defer response.Body.Close()
+  data, err := io.ReadAll(io.LimitReader(response.Body, maxDescription))
+  if err != nil {
+    return err
+  }
+  if response.StatusCode != http.StatusOK {
+    var fault struct {
+      Code        string `xml:"Body>Fault>detail>UPnPError>errorCode"`
+      Description string `xml:"Body>Fault>detail>UPnPError>errorDescription"`
+    }
+    xml.Unmarshal(data, &fault)
+    return fmt.Errorf("nat: UPnP %s failed: %s %s %s", action, response.Status, fault.Code, fault.Description)
+  }
+  if result == nil {
+    return nil
+  }
+  return xml.Unmarshal(data, result)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+  "networkchain/nat" // the port mapping protocols
+  "net"              // to read the listening address
+  "strconv"          // to read and format the ports
+  "time"             // for the lifetime of the mapping
+)
+
+// Define some constants of the port mapping
+const (
+  natTimeout  = 3 * time.Second // how long the router may take to answer
+  natLifetime = time.Hour       // how long a mapping lasts, it is renewed halfway through
+  natName     = "networkchain"  // the name of the mapping shown by the router
+)
+
+// Define a method to map a listening port on the router in front of the node, which then advertises the external address
+// of the router to its peers; the routers map IPv4 ports, a node listening on loopback or on IPv6 addresses only is left alone
+// It runs before the node starts, the address of the node does not change once it talks to peers
+func (n *Node) mapPort() {
+  port := 0
+  for _, address := range n.listen { // the first address reachable over IPv4 from the network of the router
+    if port = mappablePort(address); port != 0 {
+      break
+    }
+  }
+  if port == 0 {
+    return
+  }
+  router, err := nat.Discover(natTimeout)
+  if err != nil {
+    netLog.Info("No router to map the port on", "err", err)
+    return
+  }
+  external, err := router.ExternalIP()
+  if err != nil {
+    netLog.Warn("Failed to get the external address of the router", "method", router.Type(), "err", err)
+    return
+  }
+  if external.IsPrivate() || external.IsUnspecified() { // behind another router, peers could not reach the address
+    netLog.Info("The router has no public address, not mapping the port", "method", router.Type(), "external", external)
+    return
+  }
+  mapped, err := router.AddMapping(protocol, port, port, natName, natLifetime)
+  if err != nil {
+    netLog.Warn("Failed to map the port on the router", "method", router.Type(), "port", port, "err", err)
+    return
+  }
+  n.address = net.JoinHostPort(external.String(), strconv.Itoa(mapped)) // introduce the node with the address peers can reach
+  netLog.Info("Mapped the port on the router", "method", router.Type(), "address", n.address)
+  go n.renewMapping(router, port, mapped)
+}
+
+// Define a function to get the port of a listening address the router can map, 0 for a loopback or an IPv6 address which
+// peers on the internet either cannot reach or reach without a mapping
+func mappablePort(address string) int {
+  host, portText, err := net.SplitHostPort(address)
+  if err != nil || host == "localhost" {
+    return 0
+  }
+  if ip := net.ParseIP(host); ip != nil && (ip.IsLoopback() || (ip.To4() == nil && !ip.IsUnspecified())) { // [::] takes IPv4 too
+    return 0
+  }
+  port, err := strconv.Atoi(portText)
+  if err != nil {
+    return 0
+  }
+  return port
+}
+
+// Define a method to renew the mapping of the port until the node stops, and remove it then
+func (n *Node) renewMapping(router nat.NAT, port, mapped int) {
+  ticker := time.NewTicker(natLifetime / 2) // create a ticker renewing well before the end
+  defer ticker.Stop() // stop it when done
+  for {
+    select {
+    case <-n.quit: // the node stopped
+      if err := router.DeleteMapping(protocol, mapped, port); err != nil {
+        netLog.Warn("Failed to remove the mapping of the port", "method", router.Type(), "err", err)
+      }
+      return
+    case <-ticker.C:
+      renewed, err := router.AddMapping(protocol, mapped, port, natName, natLifetime)
+      if err != nil {
+        netLog.Warn("Failed to renew the mapping of the port", "method", router.Type(), "err", err)
+      } else if renewed != mapped { // the peers still know the old address
+        netLog.Warn("The router moved the mapping to another port", "method", router.Type(), "port", renewed, "advertised", mapped)
+      }
+    }
+  }
+}
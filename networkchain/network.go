@@ -1,20 +1,78 @@
-package network
+package main
 
 import (
-	"bytes"
-	"encoding/gob"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log"
+	"networkchain/bloom"
+	"networkchain/codec"
+	"networkchain/chaincfg"
+	"networkchain/config"
+	"networkchain/consensus"
+	"networkchain/events"
+	"networkchain/logger"
+	"networkchain/noise"
+	"networkchain/script"
+	"networkchain/wallet"
+	"networkchain/webhook"
 	"net"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
 )
 
 // Define some constants for the network protocol
 const (
   protocol      = "tcp" // the network protocol to use
-  nodeVersion   = 1     // the version of the node software
+  nodeVersion   = 8     // the protocol version spoken by the node
+  minVersion    = 2     // the oldest protocol version the node still talks to
+  headersVersion = 3    // the first protocol version serving headers and block filters to light clients
+  bloomVersion  = 4     // the first protocol version filtering transactions with the bloom filter of a light client
+  compactVersion = 5    // the first protocol version relaying new blocks as compact blocks
+  servicesVersion = 6   // the first protocol version advertising its optional features in the services of its version
+  feeFilterVersion = 7  // the first protocol version telling its peers the lowest feerate of the transactions it wants
+  mempoolVersion = 8    // the first protocol version announcing its mempool to the peers asking for it
   commandLength = 12    // the fixed length of the command field in a message
 )
 
+// Define the services a node advertises in its version command
+const (
+  serviceNetwork        uint64 = 1 << 0 // the node serves every block of its chain
+  serviceNetworkLimited uint64 = 1 << 1 // the node pruned its old blocks and only serves the last config.MinPrune ones
+  serviceBloom          uint64 = 1 << 2 // the node filters the transactions and blocks it sends with the bloom filter of a light client
+  serviceCompactBlocks  uint64 = 1 << 3 // the node sends blocks as compact blocks
+  serviceCFilters       uint64 = 1 << 4 // the node serves the block headers and filters light clients sync with
+  serviceBasicFilters   uint64 = 1 << 5 // the node serves the basic filters of its blocks, Golomb-coded sets of their scripts
+)
+
+// Define the kinds of block filters a getcfilters command asks for
+const (
+  filterBloom uint8 = 0 // the bloom filter of the addresses of each block, all in one cfilters command; older light clients send no kind
+  filterBasic uint8 = 1 // the basic filter of each block, in a cfilter command per block
+)
+
+// The software of the node, sent in its version messages
+var userAgent = fmt.Sprintf("/networkchain:%d/", nodeVersion)
+
+// Define the most block hashes sent in an inv command answering a getblocks command, the peer asks for the next ones
+// once it downloaded them
+const maxBlocksPerInv = 500
+
+// Define the most transaction IDs sent in an inv command answering a mempool command, a larger mempool takes several
+const maxTxsPerInv = 1000
+
+// Define some limits for the light client commands
+const (
+  maxHeadersPerMessage = 2000 // the most headers sent in a headers command
+  maxFiltersPerMessage = 500  // the most block filters requested in a getcfilters command
+  maxFilterAddSize     = 520  // the largest element added with a filteradd command
+)
+
 // Define some commands for the network protocol
 const (
   cmdVersion    = "version"    // a command to send version and blockchain height
@@ -27,120 +85,702 @@ const (
   cmdGetAddr    = "getaddr"    // a command to request a list of known nodes
   cmdPing       = "ping"       // a command to check the connectivity of a node
   cmdPong       = "pong"       // a command to respond to a ping
+  cmdGetHeaders = "getheaders" // a command to request block headers from a node
+  cmdHeaders    = "headers"    // a command to send block headers
+  cmdGetCFilters = "getcfilters" // a command to request the filters of blocks
+  cmdCFilters   = "cfilters"   // a command to send the filters of blocks
+  cmdCFilter    = "cfilter"    // a command to send the basic filter of a block
+  cmdFilterLoad = "filterload" // a command to set the bloom filter of a light client
+  cmdFilterAdd  = "filteradd"  // a command to add an element to the bloom filter of a light client
+  cmdFilterClear = "filterclear" // a command to remove the bloom filter of a light client
+  cmdMerkleBlock = "merkleblock" // a command to send the transactions of a block matching a bloom filter, with their merkle proofs
+  cmdCmpctBlock = "cmpctblock" // a command to send a block as its header and the short IDs of its transactions
+  cmdGetBlockTxn = "getblocktxn" // a command to request the transactions of a compact block missing from the mempool
+  cmdBlockTxn   = "blocktxn"   // a command to send the transactions of a block requested with getblocktxn
+  cmdPrePrepare = "preprepare" // a command to propose a block, sent by the primary of a BFT round
+  cmdPrepare    = "prepare"    // a command to vote for a proposed block
+  cmdCommit     = "commit"     // a command to commit to a block a quorum of validators prepared
+  cmdFeeFilter  = "feefilter"  // a command to set the lowest feerate of the transactions announced to the sender
+  cmdMempool    = "mempool"    // a command to request the transactions of the mempool of a node
 )
 
-// Define a struct for a message
-type Message struct {
-  Command []byte // the command name
-  Payload []byte // the command data
+// Define the payload of each command, to check a payload before it is handled
+var payloadTypes = map[string]func() interface{}{
+  cmdVersion:     func() interface{} { return &Version{} },
+  cmdGetBlocks:   func() interface{} { return &GetBlocks{} },
+  cmdInv:         func() interface{} { return &Inv{} },
+  cmdGetData:     func() interface{} { return &GetData{} },
+  cmdBlock:       func() interface{} { return &BlockMsg{} },
+  cmdTx:          func() interface{} { return &Tx{} },
+  cmdAddr:        func() interface{} { return &Addr{} },
+  cmdGetAddr:     func() interface{} { return &GetAddr{} },
+  cmdPing:        func() interface{} { return &Ping{} },
+  cmdPong:        func() interface{} { return &Pong{} },
+  cmdGetHeaders:  func() interface{} { return &GetHeaders{} },
+  cmdHeaders:     func() interface{} { return &Headers{} },
+  cmdGetCFilters: func() interface{} { return &GetCFilters{} },
+  cmdCFilters:    func() interface{} { return &CFilters{} },
+  cmdCFilter:     func() interface{} { return &CFilter{} },
+  cmdFilterLoad:  func() interface{} { return &FilterLoad{} },
+  cmdFilterAdd:   func() interface{} { return &FilterAdd{} },
+  cmdFilterClear: func() interface{} { return &FilterClear{} },
+  cmdMerkleBlock: func() interface{} { return &MerkleBlock{} },
+  cmdCmpctBlock:  func() interface{} { return &CmpctBlock{} },
+  cmdGetBlockTxn: func() interface{} { return &GetBlockTxn{} },
+  cmdBlockTxn:    func() interface{} { return &BlockTxn{} },
+  cmdPrePrepare:  func() interface{} { return &PrePrepare{} },
+  cmdPrepare:     func() interface{} { return &BFTVote{} },
+  cmdCommit:      func() interface{} { return &BFTVote{} },
+  cmdFeeFilter:   func() interface{} { return &FeeFilter{} },
+  cmdMempool:     func() interface{} { return &MempoolMsg{} },
 }
 
 // Define a struct for a version command
 type Version struct {
-  Version    int    // the node version
-  BestHeight int    // the blockchain height
-  AddrFrom   string // the address of the sender
+  Version     int      `proto:"1"` // the node version
+  BestHeight  int      `proto:"2"` // the blockchain height
+  AddrFrom    string   `proto:"3"` // the address of the sender
+  Compression []string `proto:"4"` // the compression algorithms the sender accepts, most preferred first
+  Services    uint64   `proto:"5"` // the services of the sender, older nodes send none and serve every block
+  Nonce       uint64   `proto:"6"` // a random number of the sender, a node receiving its own is talking to itself, older nodes send none
+  AddrTo      string   `proto:"7"` // the address the sender sent the message to, the one to forget when it leads back to the sender
+  UserAgent   string   `proto:"8"` // the software of the sender, older nodes send none
+  Timestamp   int64    `proto:"9"` // the Unix time of the clock of the sender, older nodes send none
+}
+
+// Define a struct for a getblocks command
+type GetBlocks struct {
+  AddrFrom string   `proto:"1"` // the address of the sender
+  Locator  [][]byte `proto:"2"` // the hashes of the main chain of the sender, most recent first, none from older nodes
 }
 
 // Define a struct for an inventory command
 type Inv struct {
-  AddrFrom string   // the address of the sender
-  Type     string   // the type of the inventory (block or tx)
-  Items    [][]byte // the hashes of the items
+  AddrFrom string   `proto:"1"` // the address of the sender
+  Type     string   `proto:"2"` // the type of the inventory (block or tx)
+  Items    [][]byte `proto:"3"` // the hashes of the items
 }
 
 // Define a struct for a getdata command
 type GetData struct {
-  AddrFrom string // the address of the sender
-  Type     string // the type of the data (block or tx)
-  ID       []byte // the hash of the data
+  AddrFrom string `proto:"1"` // the address of the sender
+  Type     string `proto:"2"` // the type of the data (block or tx)
+  ID       []byte `proto:"3"` // the hash of the data
 }
 
 // Define a struct for a block command
-type Block struct {
-  AddrFrom string // the address of the sender
-  Block    []byte // the serialized block
+type BlockMsg struct {
+  AddrFrom string `proto:"1"` // the address of the sender
+  Block    []byte `proto:"2"` // the serialized block
 }
 
 // Define a struct for a transaction command
 type Tx struct {
-  AddrFrom    string    // the address of the sender
-  Transaction []byte    // the serialized transaction
+  AddrFrom    string `proto:"1"` // the address of the sender
+  Transaction []byte `proto:"2"` // the serialized transaction
 }
 
 // Define a struct for an address command
 type Addr struct {
-  AddrList []string // the list of known node addresses
+  AddrList []string `proto:"1"` // the list of known node addresses
+  LastSeen []int64  `proto:"2"` // the unix time each address was last seen, older nodes send none
+  AddrFrom string   `proto:"3"` // the address of the sender, empty from older nodes
+}
+
+// Define a struct for a getaddr command
+type GetAddr struct {
+  AddrFrom string `proto:"1"` // the address of the sender
+}
+
+// Define a struct for a mempool command
+type MempoolMsg struct {
+  AddrFrom string `proto:"1"` // the address of the sender
 }
 
 // Define a struct for a ping command
 type Ping struct {
-  Nonce int64 // a random number to identify the ping
+  AddrFrom string `proto:"1"` // the address of the sender
+  Nonce    int64  `proto:"2"` // a random number to identify the ping
 }
 
 // Define a struct for a pong command
 type Pong struct {
-  Nonce int64 // the same number as the ping
+  AddrFrom string `proto:"1"` // the address of the sender
+  Nonce    int64  `proto:"2"` // the same number as the ping
 }
 
-// Define a global variable for the node address
-var nodeAddress string
+// Define a struct for a getheaders command
+type GetHeaders struct {
+  AddrFrom string   `proto:"1"` // the address of the sender
+  Locator  [][]byte `proto:"2"` // the hashes of the chain of the sender, most recent first
+}
 
-// Define a global variable for the known nodes
-var knownNodes = []string{"localhost:3000"} // a list of node addresses, starting with the first node
-// Define a function to start a node
-func StartNode(address string) {
-  nodeAddress = address // set the node address
-  ln, err := net.Listen(protocol, address) // create a listener for the node
+// Define a struct for a headers command
+type Headers struct {
+  AddrFrom string   `proto:"1"` // the address of the sender
+  Headers  [][]byte `proto:"2"` // the serialized headers, oldest first
+}
+
+// Define a struct for a getcfilters command
+type GetCFilters struct {
+  AddrFrom   string   `proto:"1"` // the address of the sender
+  Hashes     [][]byte `proto:"2"` // the hashes of the blocks
+  FilterType uint8    `proto:"3"` // the kind of filters wanted, filterBloom from older light clients
+}
+
+// Define a struct for a cfilters command
+type CFilters struct {
+  AddrFrom string   `proto:"1"` // the address of the sender
+  Hashes   [][]byte `proto:"2"` // the hashes of the blocks
+  Filters  [][]byte `proto:"3"` // the serialized filter of each block, in the same order
+}
+
+// Define a struct for a cfilter command
+type CFilter struct {
+  AddrFrom   string `proto:"1"` // the address of the sender
+  FilterType uint8  `proto:"2"` // the kind of the filter, filterBasic
+  BlockHash  []byte `proto:"3"` // the hash of the block
+  Filter     []byte `proto:"4"` // the serialized filter
+}
+
+// Define a struct for a filterload command
+type FilterLoad struct {
+  AddrFrom string `proto:"1"` // the address of the sender
+  Filter   []byte `proto:"2"` // the serialized bloom filter
+}
+
+// Define a struct for a filteradd command
+type FilterAdd struct {
+  AddrFrom string `proto:"1"` // the address of the sender
+  Data     []byte `proto:"2"` // the element to add to the filter
+}
+
+// Define a struct for a filterclear command
+type FilterClear struct {
+  AddrFrom string `proto:"1"` // the address of the sender
+}
+
+// Define a struct for a merkleblock command
+type MerkleBlock struct {
+  AddrFrom     string      `proto:"1"` // the address of the sender
+  Header       []byte      `proto:"2"` // the serialized header of the block
+  Transactions []MatchedTx `proto:"3"` // the transactions matching the filter
+}
+
+// Define a struct for a transaction of a merkleblock command
+type MatchedTx struct {
+  Transaction []byte   `proto:"1"` // the serialized transaction
+  Index       int      `proto:"2"` // the position of the transaction in the block
+  Siblings    [][]byte `proto:"3"` // the merkle proof of the transaction, from the leaves up
+}
+
+// Define a struct for a cmpctblock command
+type CmpctBlock struct {
+  AddrFrom  string        `proto:"1"` // the address of the sender
+  Header    []byte        `proto:"2"` // the serialized header of the block
+  Nonce     uint64        `proto:"3"` // a random number salting the short IDs
+  ShortIDs  []uint64      `proto:"4"` // the short IDs of the transactions that are not prefilled, in block order
+  Prefilled []PrefilledTx `proto:"5"` // the transactions sent whole, the coinbase at least
+}
+
+// Define a struct for a transaction sent whole in a cmpctblock command
+type PrefilledTx struct {
+  Index       int    `proto:"1"` // the position of the transaction in the block
+  Transaction []byte `proto:"2"` // the serialized transaction
+}
+
+// Define a struct for a getblocktxn command
+type GetBlockTxn struct {
+  AddrFrom  string `proto:"1"` // the address of the sender
+  BlockHash []byte `proto:"2"` // the hash of the block
+  Indexes   []int  `proto:"3"` // the positions of the transactions in the block
+}
+
+// Define a struct for a blocktxn command
+type BlockTxn struct {
+  AddrFrom     string   `proto:"1"` // the address of the sender
+  BlockHash    []byte   `proto:"2"` // the hash of the block
+  Transactions [][]byte `proto:"3"` // the serialized transactions, in the order they were requested
+}
+
+// Define a struct for a preprepare command
+type PrePrepare struct {
+  AddrFrom string `proto:"1"` // the address of the sender
+  Height   int    `proto:"2"` // the height of the proposed block
+  View     int    `proto:"3"` // the view the block is proposed in
+  Block    []byte `proto:"4"` // the serialized block, signed by the primary but without commit votes
+}
+
+// Define a struct for a prepare or commit command
+type BFTVote struct {
+  AddrFrom  string `proto:"1"` // the address of the sender
+  Height    int    `proto:"2"` // the height of the block
+  View      int    `proto:"3"` // the view the vote was cast in
+  BlockHash []byte `proto:"4"` // the hash of the block
+  PubKey    []byte `proto:"5"` // the public key of the validator
+  Signature []byte `proto:"6"` // the signature of the validator, over the prepare digest or the block hash
+}
+
+// Define a struct for a feefilter command
+type FeeFilter struct {
+  AddrFrom string `proto:"1"` // the address of the sender
+  FeeRate  int    `proto:"2"` // the coins per feeRateUnit bytes below which the sender does not want transactions announced
+}
+
+// Define a struct for a node of the network
+// A node owns its chain, its peer list and the state of each peer, so several nodes can run in one process
+type Node struct {
+  address         string                // the address the node advertises to its peers, the first listening one unless the router maps a port to it
+  listen          []string              // the addresses the node listens on, IPv4 or IPv6
+  proxy           string                // the SOCKS5 proxy the connections to the peers go through, empty for direct connections
+  dialer          proxy.ContextDialer   // opens the connections to the peers, directly or through the proxy
+  minerAddress    string                // the address receiving the rewards of the blocks mined by the node, the node does not mine without it
+  minTxs          int                   // the number of mempool transactions that triggers mining a block
+  validatorKey    *wallet.Wallet        // the key of the miner address signing the blocks of a proof of stake or BFT network
+  dataDir         string                // the data directory, holding the wallets
+  walletsMu       sync.RWMutex          // the lock protecting the loaded wallets
+  wallets         map[string]*nodeWallet // the wallets loaded by the node, by name, the one of the data directory named ""
+  miningRetry     bool                  // whether mining is tried again at the next slot, a proof of stake validator waits for its turn
+  miner           *cpuMiner             // the CPU miner of a proof of work node with a miner address, nil otherwise
+  bft             *bftState             // the rounds deciding the next block of a BFT network, nil otherwise
+  bc              *Blockchain           // the chain of the node, nil for a light client
+  spv             *lightClient          // the state of a light client, nil for a full node
+  events          *events.Bus           // the events of the chain and of the peers, the bus of the chain for a full node
+  connectOnly     bool                  // whether the node only talks to the addresses it was given
+  compress        bool                  // whether the node accepts and sends compressed payloads
+  addrs           *addrManager          // the known node addresses with when they were seen, starting with the first node
+  conns           *connManager          // the outbound and inbound peers, and the dials of the addresses
+  inv             *inventory            // the items each peer has and the items requested from the peers
+  blocks          *blockSync            // the blocks downloaded from the peers during a sync
+  progress        *syncTracker          // the heights the main chain reached lately, to estimate when the sync ends
+  mu              sync.Mutex            // the lock protecting the peer state below
+  peerVersions    map[string]int        // the protocol version negotiated with each peer
+  compression     map[string]byte       // the compression algorithm accepted by each peer, compressionNone if it accepts none
+  bans            *banManager           // the misbehavior scores and the bans of the peers
+  pings           map[string]*pingState // the ping state of each peer
+  plaintextPeers  map[string]time.Time  // the peers that do not support TLS, until when they are dialed in plaintext
+  peerServices    map[string]uint64     // the services each peer advertised, or that its version implies for the older ones
+  userAgents      map[string]string     // the software each peer runs
+  peerHeights     map[string]int        // the height each peer announced in its version
+  timeOffsets     map[string]time.Duration // the offset of the clock of each peer from the clock of the node, told in its version
+  networkTime     *timeData                // the offsets of the clocks of the hosts the connections came from, adjusting the time of the node
+  filters         map[string]*bloom.Filter // the bloom filter loaded by each light client, the others get every transaction
+  feeFilters      map[string]int        // the feerate below which each peer wants no transactions announced, in coins per feeRateUnit bytes
+  compactBlocks   map[string]*partialBlock // the compact block received from each peer that still waits for its missing transactions
+  limiters        map[string]*rateLimiter  // the message rate of each remote host
+  tlsOptions      TLSOptions            // the TLS settings of the node
+  noiseOptions    NoiseOptions          // the Noise settings of the node
+  identity        *noise.Identity       // the key proving the node in the Noise handshake, nil without Noise
+  peerIDs         map[string]string     // the node ID each peer proved when the node connected to it
+  noNoisePeers    map[string]time.Time  // the peers that do not speak Noise, until when they are dialed without it
+  versionNonce    uint64                // the random number the node sends in its version messages to detect connections to itself
+  selfAddresses   map[string]bool       // the addresses found to lead back to the node
+  tlsServer       *tls.Config           // the TLS configuration for incoming connections, nil without TLS
+  tlsClient       *tls.Config           // the TLS configuration for outgoing connections, nil without TLS
+  listeners       []net.Listener        // the listeners accepting connections, set while the node runs
+  handlers        sync.WaitGroup        // the connections being handled
+  faults          *faultInjector        // the faults injected into the messages of a simulated node, nil on a real network
+  quit            chan struct{}         // closed when the node stops
+}
+
+// Define a function to create a node on top of a chain
+func NewNode(bc *Blockchain, cfg *config.Config) (*Node, error) {
+  discovery := Discovery{cfg.DNSSeeds, cfg.AddNodes, cfg.Connect} // how the node finds its first peers
+  tlsOptions := TLSOptions{cfg.TLS, cfg.TLSCert, cfg.TLSKey, cfg.TLSCA, cfg.TLSRequire} // how the node encrypts its connections
+  noiseOptions := NoiseOptions{cfg.Noise, cfg.NoiseRequire, cfg.Whitelist} // and authenticates its peers
+  firstNode, err := canonicalAddress(cfg.FirstNode) // the first node is known even if malformed, it is never forgotten
   if err != nil {
-    log.Panic(err) // handle any errors
+    firstNode = cfg.FirstNode
   }
-  defer ln.Close() // close the listener when done
-  bc := NewBlockchain() // create a new blockchain for the node
-  if address != knownNodes[0] { // if the node is not the first node
-    sendVersion(knownNodes[0], bc) // send the version and height to the first node
+  n := &Node{
+    minerAddress:    cfg.Miner,
+    minTxs:          cfg.MinTxs,
+    dataDir:         cfg.DataDir,
+    wallets:         map[string]*nodeWallet{},
+    bc:              bc,
+    compress:        cfg.Compress,
+    peerVersions:    map[string]int{},
+    compression:     map[string]byte{},
+    bans:            newBanManager(cfg.BanDuration),
+    pings:           map[string]*pingState{},
+    plaintextPeers:  map[string]time.Time{},
+    peerServices:    map[string]uint64{},
+    userAgents:      map[string]string{},
+    peerHeights:     map[string]int{},
+    timeOffsets:     map[string]time.Duration{},
+    networkTime:     newTimeData(),
+    filters:         map[string]*bloom.Filter{},
+    feeFilters:      map[string]int{},
+    compactBlocks:   map[string]*partialBlock{},
+    limiters:        map[string]*rateLimiter{},
+    tlsOptions:      tlsOptions,
+    noiseOptions:    noiseOptions,
+    peerIDs:         map[string]string{},
+    noNoisePeers:    map[string]time.Time{},
+    versionNonce:    newVersionNonce(),
+    selfAddresses:   map[string]bool{},
+    inv:             newInventory(),
+    blocks:          newBlockSync(),
+    progress:        newSyncTracker(),
+    quit:            make(chan struct{}),
+  }
+  if bc != nil { // the subsystems follow the chain and the peers on the same bus
+    n.events = bc.Events
+    bc.clock = n.networkTime // the blocks are checked against and built with the time of the peers
+  } else { // a light client has no chain, its peers are announced on a bus of its own
+    n.events = events.New()
+  }
+  for _, address := range cfg.Listen { // the peers know the node by the canonical form of its address
+    canonical, err := canonicalAddress(address)
+    if err != nil {
+      return nil, fmt.Errorf("listen address %q: %w", address, err)
+    }
+    n.listen = append(n.listen, canonical)
+  }
+  if len(n.listen) > 0 { // the node introduces itself with the first one
+    n.address = n.listen[0]
+  }
+  if cfg.ExternalAddr != "" { // unless it is reached another way, like a Tor hidden service
+    if n.address, err = canonicalAddress(cfg.ExternalAddr); err != nil {
+      return nil, fmt.Errorf("external address %q: %w", cfg.ExternalAddr, err)
+    }
+  }
+  if n.dialer, err = newDialer(cfg.Proxy); err != nil {
+    return nil, err
+  }
+  n.proxy = cfg.Proxy
+  if n.proxy != "" && len(discovery.DNSSeeds) > 0 { // resolving the seeds here would leak the queries outside the proxy
+    netLog.Info("Not resolving the DNS seeds, the node connects through a proxy", "seeds", len(discovery.DNSSeeds))
+    discovery.DNSSeeds = nil
+  }
+  if activeNet.Consensus == chaincfg.BFT { // the blocks are agreed on by the validators
+    n.bft = newBFTState()
+  }
+  if cfg.Miner != "" && activeNet.IsProofOfWork() { // the blocks are mined
+    n.miner = newCPUMiner(n, cfg.MinerThreads)
+  }
+  if len(discovery.Connect) > 0 { // if the node only talks to the given addresses
+    n.connectOnly = true // do not learn addresses from peers
+    n.addrs = newAddrManager("", "") // and forget the default first node and the saved addresses
+    connect := canonicalAddresses(withDefaultPorts(discovery.Connect))
+    maxOutbound := cfg.MaxOutbound
+    if maxOutbound < len(connect) { // the given addresses are dialed whatever the limit
+      maxOutbound = len(connect)
+    }
+    n.conns = newConnManager(len(connect), cfg.MaxInbound, maxOutbound, connect, n.events)
+  } else {
+    manual := append([]string{firstNode}, canonicalAddresses(withDefaultPorts(discovery.AddNodes))...) // always dialed
+    n.conns = newConnManager(cfg.TargetOutbound, cfg.MaxInbound, cfg.MaxOutbound, manual, n.events)
+    n.addrs = newAddrManager(filepath.Join(cfg.DataDir, peersFile), firstNode)
+    if err := n.addrs.load(n.canReach); err != nil { // reconnect to the peers known before the restart
+      netLog.Warn("Failed to load the known addresses", "err", err)
+    }
+  }
+  if tlsOptions.Enabled || tlsOptions.Require { // if the connections are encrypted
+    server, client, err := newTLSConfigs(tlsOptions) // load or generate the certificate
+    if err != nil {
+      return nil, err
+    }
+    n.tlsServer, n.tlsClient = server, client
+  }
+  if noiseOptions.Enabled || noiseOptions.Require { // if the peers are authenticated
+    if n.identity, err = loadIdentity(cfg.DataDir); err != nil { // load or generate the key of the node
+      return nil, err
+    }
+    netLog.Info("Offering the Noise handshake", "id", n.identity.ID())
+  }
+  n.addKnownNodes(discoverPeers(discovery)) // add the bootstrap peers to the known nodes
+  return n, nil // return the node
+}
+
+// Define a function to start a node with its settings, it runs until the process exits
+func StartNode(cfg *config.Config) {
+  bc := NewBlockchain(cfg.DataDir, cfg.Miner) // open the blockchain stored in the data directory
+  defer bc.Close() // close the store when done
+  bc.SetUTXOCacheSize(cfg.UTXOCache) // keep the changes of the UTXO set in memory up to the budget
+  sigCache = script.NewSigCache(cfg.SigCache) // remember the signatures the mempool verified for their block
+  bc.SetMempoolPolicy(cfg.MinRelayFee, cfg.MempoolExpiry) // bound how cheap and how old the pending transactions are
+  if err := bc.SetTxIndex(cfg.TxIndex); err != nil { // build or drop the transaction index
+    chainLog.Panic("Failed to update the transaction index", "err", err)
+  }
+  if err := bc.SetAddrIndex(cfg.AddrIndex); err != nil { // and the address index
+    chainLog.Panic("Failed to update the address index", "err", err)
+  }
+  if cfg.Prune > 0 { // if the node discards its old blocks
+    if err := bc.EnablePruning(cfg.Prune); err != nil {
+      chainLog.Panic("Failed to prune the blocks", "err", err)
+    }
+  }
+  node, err := NewNode(bc, cfg) // create the node
+  if err != nil {
+    netLog.Panic("Failed to create the node", "err", err)
+  }
+  wallets, err := wallet.LoadWallets(cfg.DataDir, []byte(cfg.Passphrase)) // the keys of the node
+  if err != nil {
+    netLog.Warn("Failed to open the wallet file, the wallet is disabled", "err", err)
+  } else if _, err := node.addWallet("", wallets); err != nil { // with the transactions to announce again
+    netLog.Panic("Failed to load the wallet transactions", "err", err)
+  }
+  for _, name := range cfg.Wallets { // the named wallets, their keys locked if encrypted
+    if _, err := node.loadWallet(name, "", false); err != nil {
+      netLog.Warn("Failed to load a wallet", "name", name, "err", err)
+    }
+  }
+  if cfg.Miner != "" && !activeNet.IsProofOfWork() { // the blocks are signed, not mined
+    if err != nil {
+      minerLog.Panic("Failed to open the wallet file", "err", err)
+    }
+    if node.validatorKey, err = wallets.Wallet(cfg.Miner); err != nil { // the miner address must be a validator key of the node
+      minerLog.Panic("No key for the validator address", "address", cfg.Miner, "err", err)
+    }
+  }
+  if cfg.NAT && cfg.Proxy == "" && cfg.ExternalAddr == "" { // reachable from the internet behind a home router, a proxied node hides its address
+    node.mapPort()
+  }
+  if node.miner != nil && !node.isFirstNode() { // the first node only relays the transactions
+    node.miner.Start(0) // mine in the background
   }
-  for { // loop forever
+  if cfg.RPCAddr != "" { // if the node answers RPC requests
+    go func() {
+      if err := node.ServeRPC(cfg.RPCAddr, cfg.RPCUser, cfg.RPCPassword); err != nil { // serve them in the background
+        rpcLog.Error("JSON-RPC server stopped", "err", err) // the node keeps running
+      }
+    }()
+  }
+  if cfg.StratumAddr != "" { // if external miners work for the node
+    go func() {
+      if err := node.ServeStratum(cfg.StratumAddr, cfg.StratumDifficulty); err != nil { // serve them in the background
+        minerLog.Error("Stratum server stopped", "err", err) // the node keeps running
+      }
+    }()
+  }
+  if cfg.GRPCAddr != "" { // if the node answers gRPC requests
+    go func() {
+      if err := node.ServeGRPC(cfg.GRPCAddr); err != nil { // serve them in the background
+        rpcLog.Error("gRPC server stopped", "err", err) // the node keeps running
+      }
+    }()
+  }
+  if len(cfg.Webhooks) > 0 { // if the operator wants the events posted
+    rpcLog.Info("Posting the chain events to webhooks", "urls", len(cfg.Webhooks), "watched", len(cfg.Watch))
+    go node.runWebhooks(webhook.New(cfg.Webhooks, cfg.WebhookSecret), cfg.Watch) // in the background
+  }
+  if cfg.ZMQAddr != "" { // if indexers follow the node
+    go func() {
+      if err := node.ServeZMQ(cfg.ZMQAddr); err != nil { // publish to them in the background
+        rpcLog.Error("ZMQ publisher stopped", "err", err) // the node keeps running
+      }
+    }()
+  }
+  if err := node.Run(); err != nil { // run the node
+    netLog.Panic("The node stopped", "err", err)
+  }
+}
+
+// Define a method to listen for peers on every address and handle their messages until the node is stopped
+func (n *Node) Run() error {
+  var listeners []net.Listener // create a buffer for the listeners
+  for _, address := range n.listen { // bind every address before talking to peers
+    ln, err := net.Listen(protocol, address) // create a listener for the address
+    if err != nil {
+      for _, bound := range listeners { // release the addresses already bound
+        bound.Close()
+      }
+      return err
+    }
+    listeners = append(listeners, ln)
+  }
+  n.mu.Lock() // lock the node state
+  n.listeners = listeners // remember the listeners so Stop can close them
+  n.mu.Unlock() // unlock it
+  go n.keepAlive() // ping the peers in the background
+  go n.saveAddresses() // and save the known addresses
+  go n.connectLoop() // and dial the outbound peers
+  if n.bc != nil { // a full node downloads the blocks
+    go n.blockSyncLoop() // from the peers that are not slow
+  }
+  if n.bc != nil { // the first node relays the transactions of the mempool
+    go n.relayLoop()
+  }
+  if n.bc != nil { // the peers learn when the mempool gets more selective
+    go n.feeFilterLoop()
+  }
+  if n.bc != nil && n.minerAddress != "" && n.miner == nil { // a validator mines on demand, a CPU miner follows the chain by itself
+    go n.validatorLoop()
+  }
+  errs := make(chan error, len(listeners)) // what each accept loop returned
+  for _, ln := range listeners {
+    go func(ln net.Listener) {
+      errs <- n.accept(ln)
+    }(ln)
+  }
+  var err error
+  for range listeners { // wait for every accept loop
+    if failed := <-errs; failed != nil && err == nil { // a listener failed, the node stops
+      err = failed
+      n.Stop()
+    }
+  }
+  n.handlers.Wait() // the chain can be closed once Run returned
+  if saveErr := n.addrs.save(); saveErr != nil { // keep the addresses for the next start
+    netLog.Warn("Failed to save the known addresses", "err", saveErr)
+  }
+  return err
+}
+
+// Define a method to accept the connections of a listener until the node is stopped
+func (n *Node) accept(ln net.Listener) error {
+  defer ln.Close() // close the listener when done
+  for { // loop until the node is stopped
     conn, err := ln.Accept() // accept incoming connections
     if err != nil {
-      log.Panic(err) // handle any errors
+      select {
+      case <-n.quit: // the listener was closed by Stop
+        return nil
+      default:
+        return err
+      }
     }
-    go handleConnection(conn, bc) // handle the connection in a separate goroutine
+    n.handlers.Add(1)
+    go func() { // handle the connection in a separate goroutine
+      defer n.handlers.Done()
+      n.handleConnection(conn)
+    }()
   }
 }
 
-// Define a function to handle a connection
-func handleConnection(conn net.Conn, bc *Blockchain) {
+// Define a method to tell if the node is listening for peers
+func (n *Node) listening() bool {
+  n.mu.Lock() // lock the node state
+  defer n.mu.Unlock() // unlock it when done
+  return len(n.listeners) > 0
+}
+
+// Define a method to stop a running node
+func (n *Node) Stop() {
+  n.mu.Lock() // lock the node state
+  defer n.mu.Unlock() // unlock it when done
+  select {
+  case <-n.quit: // already stopped
+    return
+  default:
+  }
+  close(n.quit) // tell the background loops to stop
+  for _, ln := range n.listeners {
+    ln.Close() // make Run return
+  }
+}
+
+// Define a struct for the connection a message came from, the handlers score the peer that sent it by what it proved on
+// the connection rather than by the fields of its payload
+type sender struct {
+  host string // the host the connection comes from
+  id   string // the node ID the peer proved with the Noise handshake, empty without it
+}
+
+// Define a method to handle a connection
+func (n *Node) handleConnection(conn net.Conn) {
   defer conn.Close() // close the connection when done
-  request := make([]byte, commandLength) // create a buffer for the request
-  _, err := conn.Read(request) // read the request from the connection
+  host := remoteHost(conn.RemoteAddr()) // the limits apply to the host
+  conn.SetReadDeadline(time.Now().Add(messageTimeout)) // do not let a slow peer hold the connection
+  peer, id, err := n.acceptNoise(conn) // authenticate the peer if it starts a Noise handshake
+  if err != nil {
+    netLog.Warn("Failed to accept a connection", "peer", conn.RemoteAddr(), "err", err)
+    return // drop the connection
+  }
+  whitelisted := n.isWhitelisted(id) // a whitelisted peer is trusted wherever it connects from
+  if !whitelisted && (n.isBanned(host) || !n.allowMessage(host)) { // if the host is banned or sends too many messages
+    return // drop the connection
+  }
+  if id == "" { // the Noise connections are encrypted already
+    if peer, err = n.acceptTLS(peer); err != nil { // encrypt the connection if the peer asks for it
+      netLog.Warn("Failed to accept a connection", "peer", conn.RemoteAddr(), "err", err)
+      return // drop the connection
+    }
+  }
+  from := sender{host, id} // the peer the handlers score
+  header, request, err := readMessage(peer) // read a whole framed message from the connection
+  if err != nil && whitelisted { // a whitelisted peer is never penalized
+    netLog.Warn("Failed to read a message", "peer", conn.RemoteAddr(), "id", id, "err", err)
+    return
+  }
+  if errors.Is(err, errPayloadTooLarge) { // if the peer tries to exhaust our memory
+    n.penalize(host, err) // disconnect it for a while
+    return
+  }
+  if errors.Is(err, errChecksum) { // if the payload was corrupted
+    n.misbehaving(from, checksumScore, err) // count it against the host
+    return
+  }
+  if errors.Is(err, errCompression) { // if the payload cannot be decompressed
+    n.misbehaving(from, malformedScore, err) // count it against the host
+    return
+  }
   if err != nil {
-    log.Panic(err) // handle any errors
+    netLog.Warn("Failed to read a message", "peer", conn.RemoteAddr(), "err", err)
+    return // drop the connection
+  }
+  command := header.Command // get the command from the header
+  netLog.Debug("Received message", "command", command, "peer", conn.RemoteAddr(), "size", len(request))
+  if newPayload, ok := payloadTypes[command]; ok { // the handlers only know the sender once the payload is decoded
+    if err := codec.Unmarshal(request, newPayload()); err != nil { // so a malformed payload is counted against the host
+      if !whitelisted {
+        n.misbehaving(from, malformedScore, fmt.Errorf("malformed %s payload: %w", command, err))
+      }
+      return
+    }
+  }
+  if n.spv != nil { // a light client only understands part of the protocol
+    n.handleLightCommand(from, command, request) // handle the command without a chain
+    return
   }
-  command := bytesToCommand(request) // convert the request to a command
   switch command { // switch on the command
   case cmdVersion: // if the command is version
-    handleVersion(request, bc) // handle the version command
+    n.handleVersion(from, request) // handle the version command
   case cmdGetBlocks: // if the command is getblocks
-    handleGetBlocks(request, bc) // handle the getblocks command
+    n.handleGetBlocks(request) // handle the getblocks command
   case cmdInv: // if the command is inv
-    handleInv(request, bc) // handle the inv command
+    n.handleInv(request) // handle the inv command
   case cmdGetData: // if the command is getdata
-    handleGetData(request, bc) // handle the getdata command
+    n.handleGetData(request) // handle the getdata command
   case cmdBlock: // if the command is block
-    handleBlock(request, bc) // handle the block command
+    n.handleBlock(from, request) // handle the block command
   case cmdTx: // if the command is tx
-    handleTx(request, bc) // handle the tx command
+    n.handleTx(from, request) // handle the tx command
   case cmdAddr: // if the command is addr
-    handleAddr(request, bc) // handle the addr command
+    n.handleAddr(request) // handle the addr command
   case cmdGetAddr: // if the command is getaddr
-    handleGetAddr(request, bc) // handle the getaddr command
+    n.handleGetAddr(request) // handle the getaddr command
   case cmdPing: // if the command is ping
-    handlePing(request, bc) // handle the ping command
+    n.handlePing(request) // handle the ping command
   case cmdPong: // if the command is pong
-    handlePong(request, bc) // handle the pong command
+    n.handlePong(request) // handle the pong command
+  case cmdGetHeaders: // if the command is getheaders
+    n.handleGetHeaders(request) // handle the getheaders command
+  case cmdGetCFilters: // if the command is getcfilters
+    n.handleGetCFilters(request) // handle the getcfilters command
+  case cmdFilterLoad: // if the command is filterload
+    n.handleFilterLoad(from, request) // handle the filterload command
+  case cmdFilterAdd: // if the command is filteradd
+    n.handleFilterAdd(from, request) // handle the filteradd command
+  case cmdFilterClear: // if the command is filterclear
+    n.handleFilterClear(request) // handle the filterclear command
+  case cmdCmpctBlock: // if the command is cmpctblock
+    n.handleCmpctBlock(from, request) // handle the cmpctblock command
+  case cmdGetBlockTxn: // if the command is getblocktxn
+    n.handleGetBlockTxn(from, request) // handle the getblocktxn command
+  case cmdBlockTxn: // if the command is blocktxn
+    n.handleBlockTxn(from, request) // handle the blocktxn command
+  case cmdPrePrepare: // if the command is preprepare
+    n.handlePrePrepare(from, request) // handle the preprepare command
+  case cmdPrepare, cmdCommit: // if the command is a vote
+    n.handleBFTVote(from, command, request) // handle the vote
+  case cmdFeeFilter: // if the command is feefilter
+    n.handleFeeFilter(from, request) // handle the feefilter command
+  case cmdMempool: // if the command is mempool
+    n.handleMempool(request) // handle the mempool command
   default: // if the command is unknown
-    fmt.Println("Unknown command") // print a message
+    netLog.Warn("Unknown command", "command", command, "peer", conn.RemoteAddr())
   }
 }
 
@@ -164,172 +804,1014 @@ func commandToBytes(command string) []byte {
   return data[:] // return the data as a slice
 }
 
-// Define a function to send a message to a node
-func sendData(address string, data []byte) {
-  conn, err := net.Dial(protocol, address) // create a connection to the node
+// Define a method to send a message to a node
+func (n *Node) sendData(address string, data []byte) {
+  if n.faults != nil { // a simulated node may drop or delay the message
+    send, delay := n.faults.plan(address)
+    if !send {
+      netLog.Debug("Dropped a message", "peer", address)
+      return
+    }
+    if delay > 0 { // the sender goes on, so the next messages may arrive first
+      time.AfterFunc(delay, func() { n.writeData(address, data) })
+      return
+    }
+  }
+  n.writeData(address, data)
+}
+
+// Define a method to connect to a node and write a message to it
+func (n *Node) writeData(address string, data []byte) {
+  conn, err := n.dial(address) // create a connection to the node
   if err != nil {
-    fmt.Printf("%s is not available\n", address) // print a message if the node is not available
+    netLog.Info("Peer is not available", "peer", address, "err", err)
+    n.addrs.attempt(address) // count the failure against the address
+    n.conns.failed(address) // and wait before dialing it again
     return
   }
+  if n.faults != nil { // the connection of a simulated node may be cut
+    conn = n.faults.wrap(conn)
+  }
   defer conn.Close() // close the connection when done
+  data = compressMessage(data, n.peerCompression(address)) // compress the large payloads for the peers accepting it
   _, err = conn.Write(data) // write the data to the connection
   if err != nil {
-    log.Panic(err) // handle any errors
+    netLog.Warn("Failed to send a message", "peer", address, "err", err) // the peer may come back, the node keeps running
   }
 }
 
-// Define a function to send a version command to a node
-func sendVersion(address string, bc *Blockchain) {
-  bestHeight := bc.GetBestHeight() // get the best height of the blockchain
-  payload := gobEncode(Version{nodeVersion, bestHeight, nodeAddress}) // encode the version struct into a payload
-  message := append(commandToBytes(cmdVersion), payload...) // append the command and the payload
-  sendData(address, message) // send the message to the node
+// Define a method to send a version command to a node
+func (n *Node) sendVersion(address string) {
+  bestHeight := n.bestHeight() // get the best height of the blockchain
+  var accepted []string // the compression algorithms we accept
+  if n.compress { // unless compression is disabled
+    for _, algorithm := range compressionAlgorithms {
+      accepted = append(accepted, algorithm.name)
+    }
+  }
+  services := serviceNetwork | serviceBloom | serviceCompactBlocks | serviceCFilters | serviceBasicFilters // a full node serves every block and feature
+  if n.spv != nil { // a light client serves none
+    services = 0
+  } else if n.bc.IsPruned() { // a pruned node only serves the recent blocks
+    services = services&^serviceNetwork | serviceNetworkLimited
+  }
+  payload := encodePayload(Version{nodeVersion, bestHeight, n.address, accepted, services, n.versionNonce, address, userAgent, time.Now().Unix()}) // encode the version struct into a payload
+  message := encodeMessage(cmdVersion, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
 }
 
-// Define a function to handle a version command from a node
-func handleVersion(request []byte, bc *Blockchain) {
+// Define a method to handle a version command from a node, its clock sampled once per host the connections come from
+func (n *Node) handleVersion(from sender, request []byte) {
   var payload Version // create a buffer for the payload
-  gobDecode(request[commandLength:], &payload) // decode the request into the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
   peerVersion := payload.Version // get the peer version
   peerBestHeight := payload.BestHeight // get the peer best height
   peerAddress := payload.AddrFrom // get the peer address
-  fmt.Printf("Received version %d and best height %d from %s\n", peerVersion, peerBestHeight, peerAddress) // print a message
-  if peerVersion < nodeVersion { // if the peer version is lower than the node version
-    sendVersion(peerAddress, bc) // send the node version and height to the peer
+  if payload.Nonce != 0 && payload.Nonce == n.versionNonce { // if the node sent the message to itself
+    n.forgetSelfAddress(payload.AddrTo) // never send to that address again
+    return
+  }
+  netLog.Info("Received version", "peer", peerAddress, "version", peerVersion, "height", peerBestHeight, "agent", payload.UserAgent)
+  if peerVersion < minVersion { // if the peer is too old to understand us
+    netLog.Warn("Ignoring peer, its protocol version is no longer supported", "peer", peerAddress, "version", peerVersion)
+    return
   } else if peerVersion > nodeVersion { // if the peer version is higher than the node version
-    fmt.Println("Please update your node software") // print a message
+    netLog.Warn("A peer runs a newer protocol, please update your node software", "peer", peerAddress, "version", peerVersion)
+  }
+  inbound, evicted, err := n.conns.handshake(peerAddress, from.host, n.peerRTTs()) // make it a peer, tied to the host its connection came from
+  if err != nil { // the node is full, the peer finds another one when its dial times out
+    netLog.Info("Refusing inbound peer", "peer", peerAddress, "host", from.host, "err", err)
+    return
+  }
+  if evicted != "" { // an inbound peer made room for it
+    netLog.Info("Evicted inbound peer to make room", "peer", evicted, "for", peerAddress)
+    n.inv.forget(evicted)
+    n.blocks.dropPeer(evicted)
+  }
+  if inbound { // if the peer dialed us rather than answered our version
+    n.sendVersion(peerAddress) // send the node version and height to the peer to complete the handshake
+  } else if !n.connectOnly { // an outbound peer tells us the addresses the connection manager dials next
+    n.sendGetAddr(peerAddress)
+  }
+  n.setNegotiatedVersion(peerAddress, peerVersion) // remember the version to use with the peer
+  n.setCompression(peerAddress, payload.Compression) // and how to compress its payloads
+  if n.bc != nil { // a full node only wants the transactions its mempool would accept
+    n.sendFeeFilter(peerAddress, n.feeFilterRate())
+  }
+  if n.bc != nil && !inbound && peerBestHeight <= n.bestHeight() { // the transactions of a peer ahead of us may spend outputs of blocks we miss
+    n.sendMempool(peerAddress) // fill the mempool without waiting for new transactions, after a restart
+  }
+  services := versionServices(peerVersion, payload.Services) // the features the peer supports
+  n.mu.Lock() // lock the peer state
+  n.peerServices[peerAddress] = services // remember them
+  n.userAgents[peerAddress] = payload.UserAgent // and its software
+  n.peerHeights[peerAddress] = peerBestHeight // and how far its chain goes
+  if payload.Timestamp != 0 { // and how far its clock is from ours
+    n.timeOffsets[peerAddress] = n.networkTime.add(from.host, payload.Timestamp) // a peer cannot claim many addresses to outvote the others
+  }
+  n.mu.Unlock() // unlock it
+  limited := services&serviceNetwork == 0 // whether the peer lacks the old blocks, pruned or a light client
+  if n.spv != nil { // a light client downloads headers and filters, from peers serving them
+    if services&serviceCFilters != 0 && !limited { // a pruned peer has no filters for the old blocks
+      n.syncHeaders(peerAddress, peerBestHeight) // catch up with the peer
+    }
+  } else if services&serviceNetworkLimited == 0 && limited { // a light client serves no blocks
+    netLog.Debug("Not syncing from a peer serving no blocks", "peer", peerAddress)
+  } else if limited && peerBestHeight-n.bestHeight() > config.MinPrune { // the blocks we miss were pruned by the peer
+    netLog.Info("Not syncing from a pruned peer, it lacks the blocks we miss", "peer", peerAddress, "height", peerBestHeight)
+  } else if peerBestHeight > n.bestHeight() { // if the peer best height is higher than the node best height
+    n.sendGetBlocks(peerAddress) // send a getblocks command to the peer
+  }
+  if !n.connectOnly { // if the node learns addresses from its peers
+    n.addKnownNode(peerAddress, peerAddress, time.Now()) // add the peer to the known nodes
+    n.addrs.good(peerAddress) // the handshake succeeded, the address is worth trying again
   }
-  if peerBestHeight > bc.GetBestHeight() { // if the peer best height is higher than the node best height
-    sendGetBlocks(peerAddress) // send a getblocks command to the peer
+}
+
+// Define a method to handle a block command from a node
+func (n *Node) handleBlock(from sender, request []byte) {
+  var payload BlockMsg // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
   }
-  if !nodeIsKnown(peerAddress) { // if the peer address is not known
-    knownNodes = append(knownNodes, peerAddress) // add it to the known nodes
+  peerAddress := payload.AddrFrom // get the peer address
+  block, err := decodeBlock(payload.Block) // deserialize the block
+  if err != nil { // if the block cannot be read
+    n.banPeer(from, err) // stop talking to the peer that sent it
+    return
   }
+  n.processBlock(from, peerAddress, block) // add it to the chain
 }
 
-// Define a function to send a transaction command to a node
-func sendTx(address string, tx *Transaction) {
-  payload := gobEncode(Tx{nodeAddress, tx.Serialize()}) // encode the tx struct into a payload
-  message := append(commandToBytes(cmdTx), payload...) // append the command and the payload
-  sendData(address, message) // send the message to the node
+// Define a method to add a block received from a peer to the chain, with the blocks held waiting for it, then request
+// the next blocks of the sync
+func (n *Node) processBlock(from sender, peerAddress string, block *Block) {
+  n.inv.received(peerAddress, invKey("block", block.MyBlockHash)) // the peer has it, nobody else is waited for
+  if n.connectBlock(from, peerAddress, block) { // if it joined the chain
+    n.connectHeldBlocks() // the blocks downloaded after it may follow
+  }
+  n.scheduleBlocks() // request the next blocks of the window
 }
 
-// Define a function to handle a transaction command from a node
-func handleTx(request []byte, bc *Blockchain) {
-  var payload Tx // create a buffer for the payload
-  gobDecode(request[commandLength:], &payload) // decode the request into the payload
+// Define a method to add a block received from a peer to the chain, returning whether it was added
+// A block whose parent is still being downloaded is held until it arrives, otherwise the peer is asked for its chain
+func (n *Node) connectBlock(from sender, peerAddress string, block *Block) bool {
+  err := n.bc.AddBlock(block) // validate it and add it to the chain
+  if errors.Is(err, errUnknownParent) { // if we miss the blocks before it
+    if n.blocks.hold(from, peerAddress, block, n.hasBlock) { // its parent is on the way
+      return false
+    }
+    if n.hasBlock(block.PreviousBlockHash) { // its parent was added meanwhile
+      return n.connectBlock(from, peerAddress, block)
+    }
+    n.blocks.done(block.MyBlockHash) // it is on a branch we do not know
+    if !n.blocks.syncing() { // unless we are already downloading the chain
+      netLog.Info("Block has an unknown parent, asking for the chain", "peer", peerAddress, "hash", block.MyBlockHash)
+      n.sendGetBlocks(peerAddress) // ask the peer for its chain
+    }
+    return false
+  }
+  if errors.Is(err, errTimeTooNew) { // our clock may be behind, the block can be valid later
+    netLog.Info("Dropping block from the future", "peer", peerAddress, "hash", block.MyBlockHash, "err", err)
+    return false // it stays on the download list, to be asked again when it times out
+  }
+  n.blocks.done(block.MyBlockHash) // added or invalid, it is not downloaded again
+  if err != nil { // if the block is invalid
+    n.banPeer(from, err) // stop talking to the peer that sent it
+    return false
+  }
+  if chainLog.Enabled(logger.LevelInfo) { // skip the index lookup when the message is not printed
+    _, height, _ := n.bc.GetBlock(block.MyBlockHash)
+    chainLog.Info("Added block", "peer", peerAddress, "hash", block.MyBlockHash, "height", height)
+  }
+  return true
+}
+
+// Define a method to send a getblocks command to a node
+func (n *Node) sendGetBlocks(address string) {
+  n.sendGetBlocksAfter(address, nil)
+}
+
+// Define a method to send a getblocks command to a node for the blocks after one it announced, nil for the blocks after
+// our tip
+func (n *Node) sendGetBlocksAfter(address string, hash []byte) {
+  locator := n.bc.Locator() // the blocks of our main chain, tip first
+  if hash != nil { // the peer answers from the announced block, which it has
+    locator = append([][]byte{hash}, locator...)
+  }
+  payload := encodePayload(GetBlocks{n.address, locator}) // encode the getblocks struct into a payload
+  message := encodeMessage(cmdGetBlocks, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
+}
+
+// Define a method to handle a getblocks command from a node
+func (n *Node) handleGetBlocks(request []byte) {
+  var payload GetBlocks // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
+  peerAddress := payload.AddrFrom // get the peer address
+  limit := maxBlocksPerInv // the peer asks again for the next ones
+  if len(payload.Locator) == 0 { // an older node expects the whole chain at once
+    limit = n.bc.GetBestHeight() + 1
+  }
+  hashes := n.bc.HashesAfter(payload.Locator, limit) // the blocks after the last one the peer shares with our main chain, oldest first so they can be added in order
+  if len(hashes) == 0 { // the peer has our whole chain
+    return
+  }
+  n.sendInv(peerAddress, "block", hashes) // send an inv command with the hashes to the peer
+}
+
+// Define a method to send an inventory command to a node
+func (n *Node) sendInv(address, kind string, items [][]byte) {
+  payload := encodePayload(Inv{n.address, kind, items}) // encode the inv struct into a payload
+  message := encodeMessage(cmdInv, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
+}
+
+// Define a method to handle an inventory command from a node
+func (n *Node) handleInv(request []byte) {
+  var payload Inv // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
   peerAddress := payload.AddrFrom // get the peer address
-  txData := payload.Transaction // get the transaction data
-  tx := DeserializeTransaction(txData) // deserialize the transaction
-  fmt.Println("Received a new transaction") // print a message
-  bc.AddTxToMempool(tx) // add the transaction to the mempool
-  fmt.Printf("Added transaction %x\n", tx.ID) // print a message
-  if nodeAddress == knownNodes[0] { // if the node is the first node
-    for _, node := range knownNodes { // iterate over the known nodes
-      if node != nodeAddress && node != peerAddress { // if the node is not the sender or the receiver
-        sendInv(node, "tx", [][]byte{tx.ID}) // send an inv command with the transaction hash to the node
+  netLog.Debug("Received inventory", "peer", peerAddress, "type", payload.Type, "count", len(payload.Items))
+  switch payload.Type { // switch on the type of the inventory
+  case "block": // if the inventory lists blocks
+    var missing [][]byte // create a buffer for the blocks we do not have
+    for _, hash := range payload.Items { // iterate over the hashes
+      n.inv.addKnown(peerAddress, invKey("block", hash)) // the peer has it, it is not announced back
+      if _, _, known := n.bc.GetBlock(hash); !known { // if the block is new
+        missing = append(missing, hash) // keep it
       }
     }
-  } else { // if the node is not the first node
-    if len(bc.Mempool) >= 2 && len(bc.Mempool)%2 == 0 { // if the mempool has enough transactions to mine a new block
-      MineBlock(bc) // mine a new block
+    if len(payload.Items) >= maxBlocksPerInv { // a full inventory is followed by more, asked for right away
+      n.sendGetBlocksAfter(peerAddress, payload.Items[len(payload.Items)-1])
+    }
+    if len(missing) == 0 { // if we have every block
+      return
+    }
+    if len(payload.Items) > 1 || n.blocks.queued(missing[0]) { // the chain of the peer, downloaded from every peer having it
+      n.blocks.enqueue(peerAddress, missing, n.hasBlock)
+      n.scheduleBlocks()
+      return
+    }
+    if n.inv.awaited(invKey("block", missing[0]), peerAddress) { // a new block announced by several peers
+      netLog.Debug("Block already requested from another peer", "peer", peerAddress, "hash", missing[0])
+      return
+    }
+    kind := "block" // the whole block by default
+    if n.peerHas(peerAddress, serviceCompactBlocks) { // a new block, most of its transactions are in our mempool
+      kind = "cmpctblock" // ask for the short IDs of its transactions instead
+    }
+    n.sendGetData(peerAddress, kind, missing[0]) // request it
+  case "tx": // if the inventory lists transactions
+    for _, id := range payload.Items { // iterate over the IDs
+      key := invKey("tx", id)
+      n.inv.addKnown(peerAddress, key) // the peer has it, it is not announced back
+      if n.bc.Mempool.Has(hex.EncodeToString(id)) || n.bc.Orphans.Has(hex.EncodeToString(id)) || n.inv.seenTx(key) { // if we had it lately
+        continue
+      }
+      if !n.inv.awaited(key, peerAddress) { // unless another peer is sending it
+        n.sendGetData(peerAddress, "tx", id) // request it
+      }
     }
   }
 }
 
-// Define a function to send an address command to a node
-func sendAddr(address string) {
-  payload := gobEncode(Addr{knownNodes}) // encode the addr struct into a payload
-  message := append(commandToBytes(cmdAddr), payload...) // append the command and the payload
-  sendData(address, message) // send the message to the node
+// Define a method to send a getheaders command to a node
+func (n *Node) sendGetHeaders(address string) {
+  payload := encodePayload(GetHeaders{n.address, n.spv.headers.Locator()}) // encode the getheaders struct into a payload
+  message := encodeMessage(cmdGetHeaders, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
 }
 
-// Define a function to handle an address command from a node
-func handleAddr(request []byte, bc *Blockchain) {
+// Define a method to handle a getheaders command from a node
+func (n *Node) handleGetHeaders(request []byte) {
+  var payload GetHeaders // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
+  peerAddress := payload.AddrFrom // get the peer address
+  var headers [][]byte // create a buffer for the headers
+  for _, header := range n.bc.HeadersAfter(payload.Locator, maxHeadersPerMessage) { // iterate over the headers the peer is missing
+    headers = append(headers, header.Serialize()) // serialize them
+  }
+  n.sendHeaders(peerAddress, headers) // send a headers command to the peer
+}
+
+// Define a method to send a headers command to a node
+func (n *Node) sendHeaders(address string, headers [][]byte) {
+  payload := encodePayload(Headers{n.address, headers}) // encode the headers struct into a payload
+  message := encodeMessage(cmdHeaders, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
+}
+
+// Define a method to send a getcfilters command to a node
+func (n *Node) sendGetCFilters(address string, filterType uint8, hashes [][]byte) {
+  payload := encodePayload(GetCFilters{n.address, hashes, filterType}) // encode the getcfilters struct into a payload
+  message := encodeMessage(cmdGetCFilters, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
+}
+
+// Define a method to handle a getcfilters command from a node
+func (n *Node) handleGetCFilters(request []byte) {
+  var payload GetCFilters // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
+  peerAddress := payload.AddrFrom // get the peer address
+  if len(payload.Hashes) > maxFiltersPerMessage { // the peer asks too much at once
+    payload.Hashes = payload.Hashes[:maxFiltersPerMessage] // serve the first ones
+  }
+  if payload.FilterType == filterBasic { // a filter per block, in the order asked
+    for _, hash := range payload.Hashes { // iterate over the requested blocks
+      filter, ok := n.bc.BasicFilter(hash)
+      if !ok { // the block left our main chain or was pruned, the filters after it would not follow on from the ones sent
+        return
+      }
+      n.sendCFilter(peerAddress, CFilter{n.address, filterBasic, hash, filter.Bytes()}) // send a cfilter command to the peer
+    }
+    return
+  }
+  response := CFilters{AddrFrom: n.address} // create the response
+  for _, hash := range payload.Hashes { // iterate over the requested blocks
+    if block, _, ok := n.bc.GetBlock(hash); ok && !block.Pruned() { // if we have the block
+      response.Hashes = append(response.Hashes, hash) // add its hash
+      response.Filters = append(response.Filters, blockFilter(block).Bytes()) // and its filter
+    }
+  }
+  n.sendCFilters(peerAddress, response) // send a cfilters command to the peer
+}
+
+// Define a method to send a cfilters command to a node
+func (n *Node) sendCFilters(address string, filters CFilters) {
+  payload := encodePayload(filters) // encode the cfilters struct into a payload
+  message := encodeMessage(cmdCFilters, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
+}
+
+// Define a method to send a cfilter command to a node
+func (n *Node) sendCFilter(address string, filter CFilter) {
+  payload := encodePayload(filter) // encode the cfilter struct into a payload
+  message := encodeMessage(cmdCFilter, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
+}
+
+// Define a method to send a getdata command to a node
+func (n *Node) sendGetData(address, kind string, id []byte) {
+  n.inv.request(address, invKey(kind, id)) // wait for it from this peer
+  payload := encodePayload(GetData{n.address, kind, id}) // encode the getdata struct into a payload
+  message := encodeMessage(cmdGetData, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
+}
+
+// Define a method to handle a getdata command from a node
+func (n *Node) handleGetData(request []byte) {
+  var payload GetData // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
+  peerAddress := payload.AddrFrom // get the peer address
+  switch payload.Type { // switch on the type of the data
+  case "block": // if the peer wants a block
+    if block, _, ok := n.bc.GetBlock(payload.ID); ok && !block.Pruned() { // if we have it
+      n.sendBlock(peerAddress, block) // send it
+    }
+  case "cmpctblock": // if the peer wants a block as a compact block
+    if block, _, ok := n.bc.GetBlock(payload.ID); ok && !block.Pruned() { // if we have it
+      n.sendCmpctBlock(peerAddress, block) // send its header and short IDs
+    }
+  case "tx": // if the peer wants a transaction
+    if entry := n.bc.Mempool.Get(hex.EncodeToString(payload.ID)); entry != nil { // if it is in the mempool
+      n.sendTx(peerAddress, entry.Tx.(*Transaction)) // send it
+    }
+  case "merkleblock": // if the peer wants the transactions of a block matching its filter
+    block, _, ok := n.bc.GetBlock(payload.ID) // look the block up
+    if !ok || block.Pruned() { // if we do not have it
+      return
+    }
+    if filtered, ok := n.filterBlock(peerAddress, block); ok { // if the peer loaded a filter
+      n.sendMerkleBlock(peerAddress, filtered) // send the matching transactions
+    } else {
+      n.sendBlock(peerAddress, block) // send the whole block
+    }
+  }
+}
+
+// Define a method to select the transactions of a block matching the filter of a peer, false if the peer has no filter
+func (n *Node) filterBlock(address string, block *Block) (MerkleBlock, bool) {
+  n.mu.Lock() // lock the peer state
+  defer n.mu.Unlock() // unlock it when done
+  filter, ok := n.filters[address] // get the filter of the peer
+  if !ok { // if there is none
+    return MerkleBlock{}, false
+  }
+  filtered := MerkleBlock{AddrFrom: n.address, Header: block.Header().Serialize()} // create the message
+  var ids [][]byte // create a buffer for the transaction IDs
+  for _, tx := range block.Transactions { // iterate over the transactions
+    ids = append(ids, tx.ID) // collect their IDs
+  }
+  tree := NewMerkleTree(ids) // build the tree the proofs come from
+  for i, tx := range block.Transactions { // iterate over the transactions
+    if filterMatchesTx(filter, tx) { // if the peer is interested
+      proof := tree.Proof(i) // prove it is in the block
+      filtered.Transactions = append(filtered.Transactions, MatchedTx{tx.Serialize(), i, proof.Siblings}) // add it
+    }
+  }
+  return filtered, true // return the message
+}
+
+// Define a method to send a merkleblock command to a node
+func (n *Node) sendMerkleBlock(address string, filtered MerkleBlock) {
+  payload := encodePayload(filtered) // encode the merkleblock struct into a payload
+  message := encodeMessage(cmdMerkleBlock, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
+}
+
+// Define a method to send a filterload command to a node
+func (n *Node) sendFilterLoad(address string, filter *bloom.Filter) {
+  payload := encodePayload(FilterLoad{n.address, filter.Bytes()}) // encode the filterload struct into a payload
+  message := encodeMessage(cmdFilterLoad, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
+}
+
+// Define a method to handle a filterload command from a node
+func (n *Node) handleFilterLoad(from sender, request []byte) {
+  var payload FilterLoad // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
+  peerAddress := payload.AddrFrom // get the peer address
+  filter, err := bloom.Parse(payload.Filter) // read the filter
+  if err != nil { // if the filter is garbage or too large
+    n.banPeer(from, err) // stop talking to the peer that sent it
+    return
+  }
+  n.mu.Lock() // lock the peer state
+  n.filters[peerAddress] = filter // filter what the peer receives from now on
+  n.mu.Unlock() // unlock it
+  netLog.Info("Loaded a bloom filter", "peer", peerAddress, "size", len(filter.Bits()))
+}
+
+// Define a method to send a filteradd command to a node
+func (n *Node) sendFilterAdd(address string, data []byte) {
+  payload := encodePayload(FilterAdd{n.address, data}) // encode the filteradd struct into a payload
+  message := encodeMessage(cmdFilterAdd, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
+}
+
+// Define a method to handle a filteradd command from a node
+func (n *Node) handleFilterAdd(from sender, request []byte) {
+  var payload FilterAdd // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
+  peerAddress := payload.AddrFrom // get the peer address
+  if len(payload.Data) > maxFilterAddSize { // an element that large is not a key or an outpoint
+    n.banPeer(from, fmt.Errorf("filteradd of %d bytes", len(payload.Data))) // stop talking to the peer that sent it
+    return
+  }
+  n.mu.Lock() // lock the peer state
+  filter, ok := n.filters[peerAddress] // get the filter of the peer
+  if ok { // if the peer loaded one
+    filter.Add(payload.Data) // add the element
+  }
+  n.mu.Unlock() // unlock it
+  if !ok { // there is nothing to add to
+    n.banPeer(from, errors.New("filteradd without a filter")) // stop talking to the peer that sent it
+  }
+}
+
+// Define a method to send a filterclear command to a node
+func (n *Node) sendFilterClear(address string) {
+  payload := encodePayload(FilterClear{n.address}) // encode the filterclear struct into a payload
+  message := encodeMessage(cmdFilterClear, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
+}
+
+// Define a method to handle a filterclear command from a node
+func (n *Node) handleFilterClear(request []byte) {
+  var payload FilterClear // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
+  n.mu.Lock() // lock the peer state
+  delete(n.filters, payload.AddrFrom) // the peer receives every transaction again
+  n.mu.Unlock() // unlock it
+}
+
+// Define a method to check if a peer wants a transaction: peers without a filter want them all, unless the transaction
+// pays less than the feerate the peer asked for
+func (n *Node) peerWantsTx(address string, tx *Transaction) bool {
+  if !n.paysFeeFilter(address, tx) { // the peer would refuse it
+    return false
+  }
+  n.mu.Lock() // lock the peer state
+  defer n.mu.Unlock() // unlock it when done
+  filter, ok := n.filters[address] // get the filter of the peer
+  return !ok || filterMatchesTx(filter, tx)
+}
+
+// Define a method to announce a transaction to the peers wanting it, except the one it came from
+func (n *Node) relayTx(tx *Transaction, except string) {
+  for _, peer := range n.peers() { // iterate over the known nodes
+    if peer != except && n.peerWantsTx(peer, tx) && n.inv.addKnown(peer, invKey("tx", tx.ID)) { // if the node wants it and does not have it
+      n.sendInv(peer, "tx", [][]byte{tx.ID}) // send an inv command with the transaction hash to the node
+    }
+  }
+}
+
+// Define a method to send a block command to a node
+func (n *Node) sendBlock(address string, block *Block) {
+  payload := encodePayload(BlockMsg{n.address, block.Serialize()}) // encode the block struct into a payload
+  message := encodeMessage(cmdBlock, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
+}
+
+// Define a method to ban the host that sent invalid data, like a block without a valid proof of work
+func (n *Node) banPeer(from sender, reason error) {
+  n.misbehaving(from, banThreshold, reason) // the score reaches the threshold at once
+}
+
+// Define a method to check if a node or a host is banned, a node is banned with its host too
+func (n *Node) isBanned(address string) bool {
+  if host, _, err := net.SplitHostPort(address); err == nil && n.bans.isBanned(host) {
+    return true
+  }
+  return n.bans.isBanned(address) // return whether the node is in the ban list
+}
+
+// Define a method to send a transaction command to a node
+func (n *Node) sendTx(address string, tx *Transaction) {
+  payload := encodePayload(Tx{n.address, tx.Serialize()}) // encode the tx struct into a payload
+  message := encodeMessage(cmdTx, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
+}
+
+// Define a method to handle a transaction command from a node
+func (n *Node) handleTx(from sender, request []byte) {
+  var payload Tx // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
+  peerAddress := payload.AddrFrom // get the peer address
+  tx, err := decodeTransaction(payload.Transaction) // deserialize the transaction
+  if err != nil { // if the transaction cannot be read
+    n.banPeer(from, err) // stop talking to the peer that sent it
+    return
+  }
+  mempoolLog.Debug("Received transaction", "peer", peerAddress, "txid", tx.ID)
+  n.inv.received(peerAddress, invKey("tx", tx.ID)) // the peer has it, and it is not requested again
+  accepted, missing, err := n.bc.AddTxToMempool(tx) // check the transaction and add it to the mempool
+  if err != nil {
+    mempoolLog.Info("Rejected transaction", "peer", peerAddress, "txid", tx.ID, "err", err)
+    return
+  }
+  if len(missing) > 0 { // the transaction waits in the orphan pool for its parents
+    mempoolLog.Info("Parked orphan transaction", "peer", peerAddress, "txid", tx.ID, "missing", len(missing), "orphans", n.bc.Orphans.Count())
+    for _, parent := range missing { // the peer that sent it should have them
+      n.sendGetData(peerAddress, "tx", parent)
+    }
+    return
+  }
+  for _, added := range accepted { // the transaction and the orphans waiting for it, relayed and mined by the subscribers of the mempool
+    mempoolLog.Info("Added transaction", "peer", peerAddress, "txid", added.ID, "size", n.bc.Mempool.Count())
+  }
+}
+
+// Define a method to sign a block with the transactions of the mempool and announce it, a proof of work node has a CPU miner
+func (n *Node) mineBlock() {
+  if n.bft != nil { // the block is proposed to the validators instead
+    n.proposeBlock()
+    return
+  }
+  pending, fees := n.bc.MempoolTransactions() // include the pending transactions, best feerate first
+  txs := append([]*Transaction{NewCoinbaseTX(n.minerAddress, "", n.bc.GetBestHeight()+1, fees)}, pending...) // the coinbase pays the miner the subsidy and the fees
+  newBlock, err := n.bc.MineBlock(txs, n.validatorKey) // search the nonce and add the block to the chain, the mined transactions leave the mempool
+  if errors.Is(err, consensus.ErrNotProposer) { // another validator proposes the current slot
+    minerLog.Debug("Waiting for a slot to propose a block", "err", err)
+    n.retryMining()
+    return
+  }
+  if err != nil { // if another goroutine mined or received the transactions first
+    minerLog.Warn("Failed to mine a block", "err", err)
+    return
+  }
+  minerLog.Info("Mined block", "hash", newBlock.MyBlockHash, "height", n.bc.GetBestHeight(), "txs", len(newBlock.Transactions), "fees", fees)
+  n.announceBlock(newBlock)
+}
+
+// Define a method to mine blocks with the mempool transactions at once and announce them, on a network mining on demand
+func (n *Node) generate(count int, address string) ([]*Block, error) {
+  if !activeNet.MineOnDemand || !activeNet.IsProofOfWork() {
+    return nil, fmt.Errorf("the %s network does not mine blocks on demand, use regtest", activeNet.Name)
+  }
+  var blocks []*Block
+  for i := 0; i < count; i++ {
+    pending, fees := n.bc.MempoolTransactions() // like a mined block, best feerate first
+    txs := append([]*Transaction{NewCoinbaseTX(address, "", n.bc.GetBestHeight()+1, fees)}, pending...)
+    block, err := n.bc.MineBlock(txs, nil) // the nonce search takes a few hashes at most
+    if err != nil {
+      return nil, err
+    }
+    minerLog.Info("Generated block", "hash", block.MyBlockHash, "height", n.bc.GetBestHeight(), "txs", len(block.Transactions), "fees", fees)
+    n.announceBlock(block)
+    blocks = append(blocks, block)
+  }
+  return blocks, nil
+}
+
+// Define a method to announce a block the node mined to its peers
+func (n *Node) announceBlock(block *Block) {
+  for _, peer := range n.peers() { // iterate over the known nodes
+    if n.inv.addKnown(peer, invKey("block", block.MyBlockHash)) { // unless the node has it
+      n.sendInv(peer, "block", [][]byte{block.MyBlockHash}) // announce the new block
+    }
+  }
+}
+
+// Define a method to try mining again one slot later, while the mempool still holds enough transactions
+func (n *Node) retryMining() {
+  n.mu.Lock() // lock the node state
+  defer n.mu.Unlock() // unlock it when done
+  if n.miningRetry { // a retry is already scheduled
+    return
+  }
+  n.miningRetry = true
+  time.AfterFunc(activeNet.TargetBlockTime, func() {
+    n.mu.Lock() // lock the node state
+    n.miningRetry = false
+    n.mu.Unlock() // unlock it
+    select {
+    case <-n.quit: // the node stopped meanwhile
+      return
+    default:
+    }
+    if n.bc.Mempool.Count() >= n.minTxs { // a block of another validator may have taken the transactions
+      n.mineBlock()
+    }
+  })
+}
+
+// Define a method to check a serialized transaction submitted by a client, add it to the mempool and announce it to the peers
+func (n *Node) submitTransaction(raw []byte) (*Transaction, error) {
+  tx, err := decodeTransaction(raw) // deserialize the transaction
+  if err != nil {
+    return nil, err
+  }
+  accepted, missing, err := n.bc.AddTxToMempool(tx) // check the transaction and add it to the mempool
+  if err != nil {
+    return nil, err
+  }
+  for _, w := range n.loadedWallets() { // a wallet transaction is announced again until it is mined
+    w.trackWalletTx(tx)
+  }
+  if len(missing) > 0 { // kept until the parents arrive, there is nothing to announce yet
+    return nil, fmt.Errorf("transaction %x spends outputs of %d unknown transactions, it waits in the orphan pool", tx.ID, len(missing))
+  }
+  for _, added := range accepted { // the transaction and the orphans waiting for it
+    n.relayTx(added, "") // announce the transaction
+  }
+  return tx, nil // return the transaction
+}
+
+// Define a method to send an address command to a node
+func (n *Node) sendAddr(address string) {
+  addresses, lastSeen := n.addrs.sample(maxAddrPerMessage) // pick the addresses worth relaying
+  payload := encodePayload(Addr{addresses, lastSeen, n.address}) // encode the addr struct into a payload
+  message := encodeMessage(cmdAddr, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
+}
+
+// Define a method to handle an address command from a node
+func (n *Node) handleAddr(request []byte) {
   var payload Addr // create a buffer for the payload
-  gobDecode(request[commandLength:], &payload) // decode the request into the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
   peerAddressList := payload.AddrList // get the peer address list
-  for _, address := range peerAddressList { // iterate over the addresses
-    if !nodeIsKnown(address) { // if the address is not known
-      knownNodes = append(knownNodes, address) // add it to the known nodes
+  if n.connectOnly { // if the node does not learn addresses from its peers
+    return
+  }
+  if len(peerAddressList) > maxAddrPerMessage { // a peer relays a sample, not its whole table
+    peerAddressList = peerAddressList[:maxAddrPerMessage]
+  }
+  for i, address := range peerAddressList { // add the new addresses to the known nodes
+    var lastSeen time.Time // an older peer sends no timestamps, the addresses are taken as seen now
+    if i < len(payload.LastSeen) {
+      lastSeen = time.Unix(payload.LastSeen[i], 0)
     }
+    n.addKnownNode(address, payload.AddrFrom, lastSeen)
   }
 }
 
-// Define a function to send a getaddr command to a node
-func sendGetAddr(address string) {
-  payload := gobEncode(GetAddr{nodeAddress}) // encode the getaddr struct into a payload
-  message := append(commandToBytes(cmdGetAddr), payload...) // append the command and the payload
-  sendData(address, message) // send the message to the node
+// Define a method to send a getaddr command to a node
+func (n *Node) sendGetAddr(address string) {
+  payload := encodePayload(GetAddr{n.address}) // encode the getaddr struct into a payload
+  message := encodeMessage(cmdGetAddr, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
 }
 
-// Define a function to handle a getaddr command from a node
-func handleGetAddr(request []byte, bc *Blockchain) {
+// Define a method to handle a getaddr command from a node
+func (n *Node) handleGetAddr(request []byte) {
   var payload GetAddr // create a buffer for the payload
-  gobDecode(request[commandLength:], &payload) // decode the request into the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
+  peerAddress := payload.AddrFrom // get the peer address
+  n.sendAddr(peerAddress) // send an addr command with the known nodes to the peer
+}
+
+// Define a method to send a mempool command to a node, the older nodes do not know it
+func (n *Node) sendMempool(address string) {
+  if version, _ := n.negotiatedVersion(address); version < mempoolVersion {
+    return
+  }
+  payload := encodePayload(MempoolMsg{n.address}) // encode the mempool struct into a payload
+  message := encodeMessage(cmdMempool, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
+}
+
+// Define a method to handle a mempool command from a node: announce it the transactions of the mempool it wants,
+// parents first, the peer requests the ones it misses
+func (n *Node) handleMempool(request []byte) {
+  var payload MempoolMsg // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
   peerAddress := payload.AddrFrom // get the peer address
-  sendAddr(peerAddress) // send an addr command with the known nodes to the peer
+  var ids [][]byte // create a buffer for the transactions to announce
+  for _, entry := range n.bc.Mempool.Select(n.bc.Mempool.Size()) { // every transaction, mineable in that order
+    tx := entry.Tx.(*Transaction)
+    if n.peerWantsTx(peerAddress, tx) && n.inv.addKnown(peerAddress, invKey("tx", tx.ID)) { // unless it is filtered out or the peer has it
+      ids = append(ids, tx.ID)
+    }
+  }
+  netLog.Debug("Announcing the mempool", "peer", peerAddress, "count", len(ids))
+  for len(ids) > 0 { // in inventories of maxTxsPerInv transactions
+    count := len(ids)
+    if count > maxTxsPerInv {
+      count = maxTxsPerInv
+    }
+    n.sendInv(peerAddress, "tx", ids[:count]) // send an inv command with the transaction hashes to the node
+    ids = ids[count:]
+  }
 }
 
-// Define a function to send a ping command to a node
-func sendPing(address string, nonce int64) {
-  payload := gobEncode(Ping{nonce}) // encode the ping struct into a payload
-  message := append(commandToBytes(cmdPing), payload...) // append the command and the payload
-  sendData(address, message) // send the message to the node
+// Define a method to send a ping command to a node
+func (n *Node) sendPing(address string, nonce int64) {
+  payload := encodePayload(Ping{n.address, nonce}) // encode the ping struct into a payload
+  message := encodeMessage(cmdPing, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
 }
 
-// Define a function to handle a ping command from a node
-func handlePing(request []byte, bc *Blockchain) {
+// Define a method to handle a ping command from a node
+func (n *Node) handlePing(request []byte) {
   var payload Ping // create a buffer for the payload
-  gobDecode(request[commandLength:], &payload) // decode the request into the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
   peerAddress := payload.AddrFrom // get the peer address
   peerNonce := payload.Nonce // get the peer nonce
-  sendPong(peerAddress, peerNonce) // send a pong command with the same nonce to the peer
+  if !n.conns.isPeer(peerAddress) { // a peer the node disconnected stops getting pongs, and drops the node in turn
+    return
+  }
+  n.sendPong(peerAddress, peerNonce) // send a pong command with the same nonce to the peer
 }
 
-// Define a function to send a pong command to a node
-func sendPong(address string, nonce int64) {
-  payload := gobEncode(Pong{nonce}) // encode the pong struct into a payload
-  message := append(commandToBytes(cmdPong), payload...) // append the command and the payload
-  sendData(address, message) // send the message to the node
+// Define a method to send a pong command to a node
+func (n *Node) sendPong(address string, nonce int64) {
+  payload := encodePayload(Pong{n.address, nonce}) // encode the pong struct into a payload
+  message := encodeMessage(cmdPong, payload) // frame the command and the payload
+  n.sendData(address, message) // send the message to the node
 }
 
-// Define a function to handle a pong command from a node
-func handlePong(request []byte, bc *Blockchain) {
+// Define a method to handle a pong command from a node
+func (n *Node) handlePong(request []byte) {
   var payload Pong // create a buffer for the payload
-  gobDecode(request[commandLength:], &payload) // decode the request into the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
   peerAddress := payload.AddrFrom // get the peer address
   peerNonce := payload.Nonce // get the peer nonce
-  fmt.Printf("Received pong %d from %s\n", peerNonce, peerAddress) // print a message
+  if rtt, ok := n.recordPong(peerAddress, peerNonce); ok { // if the pong answers our last ping
+    netLog.Debug("Received pong", "peer", peerAddress, "rtt", rtt)
+    n.addrs.seen(peerAddress) // the peer is still up
+  }
+}
+
+// Define a method to get the height of the chain of the node, the header chain for a light client
+func (n *Node) bestHeight() int {
+  if n.spv != nil { // a light client only has headers
+    return n.spv.headers.Height()
+  }
+  return n.bc.GetBestHeight()
+}
+
+// Define a method to list the peers other than the node itself: the manual addresses, starting with the first node, then
+// the outbound and inbound peers
+func (n *Node) peers() []string {
+  connected := append(append([]string{}, n.conns.manual...), n.conns.peers()...) // get the peers, the manual ones first
+  n.mu.Lock() // lock the peer state
+  defer n.mu.Unlock() // unlock it when done
+  var peers []string // create a buffer for the peers
+  seen := map[string]bool{} // a manual address is often a peer too
+  for _, address := range connected { // iterate over the peers
+    if !n.isOwnAddress(address) && !seen[address] { // if the node is not us
+      peers = append(peers, address) // keep it
+      seen[address] = true
+    }
+  }
+  return peers // return a copy, the list may change once the lock is released
+}
+
+// Define a method to check if the node is the first node, the one relaying transactions to the others
+func (n *Node) isFirstNode() bool {
+  n.mu.Lock() // lock the peer state
+  defer n.mu.Unlock() // unlock it when done
+  return n.addrs.first != "" && n.isOwnAddress(n.addrs.first)
+}
+
+// Define a method to check if an address is one of the node, advertised, listening or found to lead back to it, the lock
+// must be held
+func (n *Node) isOwnAddress(address string) bool {
+  if address == n.address || n.selfAddresses[address] {
+    return true
+  }
+  for _, listen := range n.listen {
+    if address == listen {
+      return true
+    }
+  }
+  return false
+}
+
+// Define a method to check if the node would talk to an address: neither one of its own, banned, nor a hidden service it
+// cannot reach without a proxy
+func (n *Node) canReach(address string) bool {
+  n.mu.Lock() // lock the peer state
+  defer n.mu.Unlock() // unlock it when done
+  if n.proxy == "" && isOnion(address) { // the node cannot reach a hidden service
+    return false
+  }
+  return !n.isOwnAddress(address) && !n.isBanned(address)
+}
+
+// Define a method to add an address relayed by a peer, empty for the bootstrap addresses, to the known nodes with when it
+// was last seen, zero for now
+func (n *Node) addKnownNode(address, source string, lastSeen time.Time) {
+  address, err := canonicalAddress(address) // the same address written another way is the same node
+  if err != nil { // drop a malformed address
+    return
+  }
+  if n.canReach(address) { // if the address is not us and not banned
+    n.addrs.add(address, source, lastSeen) // add it to the known nodes, or refresh it
+  }
+}
+
+// Define a method to add discovered addresses to the known nodes
+func (n *Node) addKnownNodes(addresses []string) {
+  for _, address := range addresses { // iterate over the addresses
+    n.addKnownNode(address, "", time.Time{})
+  }
+}
+
+// Define a function to draw the version nonce of a node from the system source, so two nodes never share it
+func newVersionNonce() uint64 {
+  var nonce [8]byte
+  rand.Read(nonce[:])
+  return binary.BigEndian.Uint64(nonce[:]) | 1 // never 0, the nonce of older nodes
+}
+
+// Define a method to forget an address that leads back to the node, so it is neither contacted nor relayed again
+func (n *Node) forgetSelfAddress(address string) {
+  address, err := canonicalAddress(address)
+  if err != nil || address == n.address { // the node never sends to its own address anyway
+    return
+  }
+  n.mu.Lock() // lock the peer state
+  if n.selfAddresses[address] { // already forgotten
+    n.mu.Unlock() // unlock it
+    return
+  }
+  n.selfAddresses[address] = true // remember it
+  n.mu.Unlock() // unlock it
+  netLog.Info("Connected to itself, forgetting the address", "address", address)
+  n.removeKnownNode(address) // stop sending to it
+}
+
+// Define a method to remove a node from the known nodes, the first node is never forgotten
+func (n *Node) removeKnownNode(address string) {
+  n.addrs.remove(address)
+  n.conns.disconnect(address) // and stop talking to it
+  n.inv.forget(address)
+  n.blocks.dropPeer(address) // the blocks it was asked for go to the other peers
+}
+
+// Define a function to get the services of a peer from its version message; the nodes older than servicesVersion only
+// advertised the blocks they serve, sending none when they serve every block, the features they support follow from
+// their version
+func versionServices(version int, services uint64) uint64 {
+  if version >= servicesVersion {
+    return services
+  }
+  if services&serviceNetworkLimited == 0 {
+    services |= serviceNetwork
+  }
+  if version >= headersVersion {
+    services |= serviceCFilters
+  }
+  if version >= bloomVersion {
+    services |= serviceBloom
+  }
+  if version >= compactVersion {
+    services |= serviceCompactBlocks
+  }
+  return services
+}
+
+// Define a method to check if a peer supports a service, false before its version is received
+func (n *Node) peerHas(address string, service uint64) bool {
+  n.mu.Lock() // lock the peer state
+  defer n.mu.Unlock() // unlock it when done
+  return n.peerServices[address]&service != 0
+}
+
+// Define a method to get the offset of the clock of a peer from the clock of the node, false if it did not tell its time
+func (n *Node) peerTimeOffset(address string) (time.Duration, bool) {
+  n.mu.Lock() // lock the peer state
+  defer n.mu.Unlock() // unlock it when done
+  offset, ok := n.timeOffsets[address]
+  return offset, ok
+}
+
+// Define a method to get the services and the software of a peer, zero before its version is received
+func (n *Node) peerAgent(address string) (uint64, string) {
+  n.mu.Lock() // lock the peer state
+  defer n.mu.Unlock() // unlock it when done
+  return n.peerServices[address], n.userAgents[address]
+}
+
+// Define a method to get the protocol version negotiated with a peer
+func (n *Node) negotiatedVersion(address string) (int, bool) {
+  n.mu.Lock() // lock the peer state
+  defer n.mu.Unlock() // unlock it when done
+  version, known := n.peerVersions[address] // look the peer up
+  return version, known // return the version and whether the handshake happened
 }
 
-// Define a function to check if a node is known
-func nodeIsKnown(address string) bool {
-  for _, node := range knownNodes { // iterate over the known nodes
-    if node == address { // if the node matches the address
-      return true // return true
+// Define a method to remember the protocol version to use with a peer
+func (n *Node) setNegotiatedVersion(address string, peerVersion int) {
+  version := nodeVersion // we never speak a newer version than our own
+  if peerVersion < version { // if the peer is older
+    version = peerVersion // fall back to the peer version
+  }
+  n.mu.Lock() // lock the peer state
+  n.peerVersions[address] = version // store the negotiated version
+  n.mu.Unlock() // unlock it
+}
+
+// Define a method to pick the compression algorithm for a peer: the first algorithm it accepts that we know
+// Older peers announce none and get every payload as is
+func (n *Node) setCompression(address string, accepted []string) {
+  flags := compressionNone // send payloads as is by default
+  for _, name := range accepted { // iterate over the algorithms of the peer, most preferred first
+    for _, algorithm := range compressionAlgorithms {
+      if n.compress && flags == compressionNone && algorithm.name == name { // if we know it too
+        flags = algorithm.flag // use it
+      }
     }
   }
-  return false // return false
+  n.mu.Lock() // lock the peer state
+  n.compression[address] = flags // store the algorithm
+  n.mu.Unlock() // unlock it
+}
+
+// Define a method to get the compression algorithm accepted by a peer
+func (n *Node) peerCompression(address string) byte {
+  n.mu.Lock() // lock the peer state
+  defer n.mu.Unlock() // unlock it when done
+  return n.compression[address] // compressionNone for the peers we never shook hands with
 }
 
-// Define a function to encode a struct into a byte slice
-func gobEncode(data interface{}) []byte {
-  var buffer bytes.Buffer // create a buffer
-  encoder := gob.NewEncoder(&buffer) // create a new encoder
-  err := encoder.Encode(data) // encode the data into the buffer
+// Define a function to encode a struct into a payload
+func encodePayload(data interface{}) []byte {
+  payload, err := codec.Marshal(data) // encode the data using the message schema
   if err != nil {
-    log.Panic(err) // handle any errors
+    netLog.Panic("Failed to encode a payload", "err", err)
   }
-  return buffer.Bytes() // return the buffer as a byte slice
+  return payload // return the payload
 }
 
-// Define a function to decode a byte slice into a struct
-func gobDecode(data []byte, target interface{}) {
-  reader := bytes.NewReader(data) // create a reader from the data
-  decoder := gob.NewDecoder(reader) // create a new decoder
-  err := decoder.Decode(target) // decode the data into the target
+// Define a function to decode a payload into a struct, a malformed payload is reported and returned as an error
+func decodePayload(data []byte, target interface{}) error {
+  err := codec.Unmarshal(data, target) // decode the data into the target
   if err != nil {
-    log.Panic(err) // handle any errors
+    netLog.Warn("Dropping malformed payload", "type", fmt.Sprintf("%T", target), "err", err)
   }
+  return err
 }
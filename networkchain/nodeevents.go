@@ -0,0 +1,43 @@
+package main
+
+import (
+  "networkchain/events" // the subsystems follow the events of the chain and of the peers
+)
+
+// Define the number of accepted transactions waiting to be relayed before the next ones are dropped
+const relayBuffer = 1000
+
+// Define a method to announce the transactions the mempool accepts to the peers until the node stops, on the first node
+// only; the peer a transaction came from knows it already and is not told again
+func (n *Node) relayLoop() {
+  sub := n.events.Subscribe(relayBuffer, events.TxAccepted) // the mempool tells when it accepts a transaction
+  defer sub.Cancel()
+  for {
+    select {
+    case <-n.quit: // the node stopped
+      return
+    case event := <-sub.C:
+      if tx, ok := event.Data.(*Transaction); ok && n.isFirstNode() {
+        n.relayTx(tx, "")
+      }
+    }
+  }
+}
+
+// Define a method to mine a block each time the mempool has enough transactions until the node stops, on a validator
+// other than the first node; the transactions accepted while a block is mined wait for the next one
+func (n *Node) validatorLoop() {
+  sub := n.events.Subscribe(events.DefaultBuffer, events.TxAccepted) // the mempool tells when it grows
+  defer sub.Cancel()
+  for {
+    select {
+    case <-n.quit: // the node stopped
+      return
+    case <-sub.C:
+      drain(sub.C) // one check covers the transactions received so far
+      if !n.isFirstNode() && n.bc.Mempool.Count() >= n.minTxs { // enough transactions to mine a new block
+        n.mineBlock()
+      }
+    }
+  }
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+  "errors"              // for the errors of the named wallets
+  "fmt"                 // to name the wallets in the errors
+  "networkchain/wallet" // the keys of the wallets
+  "os"                  // to check the wallet files exist
+  "path/filepath"       // to find the directories of the named wallets
+  "regexp"              // to check the names
+  "sort"                // to list the wallets in a stable order
+  "sync"                // for the lock of the payments of a wallet
+  "time"                // for the timer locking a wallet again
+)
+
+// Define the directory of the data directory holding the named wallets, each in a directory of its name
+const walletsDir = "wallets"
+
+// Define the errors of the named wallets
+var (
+  errWalletNotFound     = errors.New("wallet not found")
+  errWalletNotSpecified = errors.New("several wallets are loaded, name one in the URL /wallet/<name>")
+  errWalletLoaded       = errors.New("the wallet is loaded already")
+)
+
+// Define the names a wallet can have, they name its directory too
+var walletNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`)
+
+// Define a struct for a wallet loaded by the node
+// Each wallet has its own file and transactions, so one node serves separate accounts or applications; the wallet of
+// the data directory has the empty name and the named ones live in the wallets directory
+type nodeWallet struct {
+  n       *Node           // the node holding the wallet
+  name    string          // the name of the wallet, empty for the one of the data directory
+  keys    *wallet.Wallets // the keys and the watch-only addresses of the wallet
+  mu      sync.Mutex      // the lock making the payments of the wallet one at a time, so two never pick the same coins
+  history *walletTxs      // the transactions of the wallet, announced again until mined
+  relock  *time.Timer     // locks the encrypted wallet again when its unlock times out, nil while it is locked
+  quit    chan struct{}   // closed when the wallet is unloaded
+}
+
+// Define a function to get the directory of a wallet in a data directory
+func walletDir(dataDir, name string) string {
+  if name == "" {
+    return dataDir
+  }
+  return filepath.Join(dataDir, walletsDir, name)
+}
+
+// Define a method to add the wallet of some keys to the loaded wallets under a name, with the transactions of its
+// directory, and to start announcing them
+func (n *Node) addWallet(name string, keys *wallet.Wallets) (*nodeWallet, error) {
+  history, err := loadWalletTxs(walletDir(n.dataDir, name), n.bc)
+  if err != nil {
+    return nil, err
+  }
+  n.walletsMu.Lock() // lock the loaded wallets
+  defer n.walletsMu.Unlock() // unlock them when done
+  if _, ok := n.wallets[name]; ok {
+    return nil, errWalletLoaded
+  }
+  w := &nodeWallet{n: n, name: name, keys: keys, history: history, quit: make(chan struct{})}
+  n.wallets[name] = w
+  go w.rebroadcastLoop() // the transactions of the wallet are announced until mined
+  return w, nil
+}
+
+// Define a method to load the wallet file of a name, the empty name for the one of the data directory; it must exist
+// unless create is set, then it must not, and a new wallet encrypted with the passphrase if one is given is saved
+// The keys of an encrypted wallet stay locked until walletpassphrase
+func (n *Node) loadWallet(name, passphrase string, create bool) (*nodeWallet, error) {
+  if name != "" && !walletNamePattern.MatchString(name) {
+    return nil, fmt.Errorf("invalid wallet name %q", name)
+  }
+  dir := walletDir(n.dataDir, name)
+  _, err := os.Stat(wallet.Path(dir))
+  switch {
+  case create && err == nil:
+    return nil, fmt.Errorf("the wallet %q exists already, load it", name)
+  case !create && errors.Is(err, os.ErrNotExist) && name != "": // the wallet of the data directory starts empty
+    return nil, fmt.Errorf("%w: %q has no wallet file", errWalletNotFound, name)
+  }
+  if n.loadedWallet(name) != nil { // before the file is read
+    return nil, errWalletLoaded
+  }
+  keys, err := wallet.LoadWallets(dir, nil) // an encrypted file is readable without the passphrase of its keys
+  if err != nil {
+    return nil, err
+  }
+  if create {
+    if err := os.MkdirAll(dir, 0700); err != nil {
+      return nil, err
+    }
+    if passphrase != "" {
+      err = keys.Encrypt([]byte(passphrase))
+    } else {
+      err = keys.Save()
+    }
+    if err != nil {
+      return nil, err
+    }
+  }
+  w, err := n.addWallet(name, keys)
+  if err != nil {
+    return nil, err
+  }
+  netLog.Info("Loaded a wallet", "name", name, "addresses", len(keys.Addresses()), "encrypted", keys.IsEncrypted())
+  return w, nil
+}
+
+// Define a method to unload a wallet: its transactions are no longer followed nor announced, and its keys are wiped
+func (n *Node) unloadWallet(name string) error {
+  n.walletsMu.Lock() // lock the loaded wallets
+  w, ok := n.wallets[name]
+  delete(n.wallets, name)
+  n.walletsMu.Unlock() // unlock them
+  if !ok {
+    return errWalletNotFound
+  }
+  if w.keys.IsEncrypted() {
+    w.lock()
+  }
+  close(w.quit) // stop announcing its transactions
+  netLog.Info("Unloaded a wallet", "name", name)
+  return nil
+}
+
+// Define a method to get a loaded wallet by name, nil if it is not loaded
+func (n *Node) loadedWallet(name string) *nodeWallet {
+  n.walletsMu.RLock() // lock the loaded wallets
+  defer n.walletsMu.RUnlock() // unlock them when done
+  return n.wallets[name]
+}
+
+// Define a method to get the wallet a request names, or the only wallet loaded when it names none; a named request
+// may name the wallet of the data directory by the empty name
+func (n *Node) wallet(name string, named bool) (*nodeWallet, error) {
+  n.walletsMu.RLock() // lock the loaded wallets
+  defer n.walletsMu.RUnlock() // unlock them when done
+  if named {
+    if w, ok := n.wallets[name]; ok {
+      return w, nil
+    }
+    return nil, fmt.Errorf("%w: %q", errWalletNotFound, name)
+  }
+  switch len(n.wallets) {
+  case 0:
+    return nil, errNoWallet
+  case 1:
+    for _, w := range n.wallets {
+      return w, nil
+    }
+  }
+  return nil, errWalletNotSpecified
+}
+
+// Define a method to list the loaded wallets by name
+func (n *Node) loadedWallets() []*nodeWallet {
+  n.walletsMu.RLock() // lock the loaded wallets
+  defer n.walletsMu.RUnlock() // unlock them when done
+  var loaded []*nodeWallet
+  for _, w := range n.wallets {
+    loaded = append(loaded, w)
+  }
+  sort.Slice(loaded, func(i, j int) bool { return loaded[i].name < loaded[j].name })
+  return loaded
+}
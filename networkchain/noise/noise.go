@@ -0,0 +1,379 @@
+// Package noise encrypts and mutually authenticates the connections between nodes with a handshake following the XX
+// pattern of the Noise protocol framework over secp256k1: each side proves a persistent identity key, the identity keys
+// travel encrypted so only the peer learns them, and the traffic is then encrypted with ChaCha20-Poly1305 under a key of
+// its own per direction. The ID of a node is its compressed identity public key in hex.
+//
+// The initiator starts with HandshakeByte, then the messages of the pattern follow, with empty payloads:
+//   -> e
+//   <- e, ee, s, es
+//   -> s, se
+// After the handshake each frame is a 2 byte big endian length followed by that many bytes of ciphertext.
+package noise
+
+import (
+  "crypto/hmac"     // the key derivation
+  "crypto/sha256"   // the hash of the protocol
+  "encoding/binary" // the nonces and the frame lengths
+  "encoding/hex"    // the IDs and the key file
+  "errors"          // for the errors of the handshake
+  "fmt"             // to format the errors
+  "io"              // to read whole messages
+  "net"             // the connections wrapped
+  "os"              // to keep the identity key
+  "strings"         // to read the key file
+  "syscall"         // to tell a peer hanging up from the other failures
+
+  "github.com/decred/dcrd/dcrec/secp256k1/v4" // the keys and the Diffie-Hellman function
+  "golang.org/x/crypto/chacha20poly1305"      // the cipher
+)
+
+// Define some constants of the protocol
+const (
+  HandshakeByte = 0x4e                                   // the first byte an initiator sends, "N", no network magic of ours starts with it
+  protocolName  = "Noise_XX_secp256k1_ChaChaPoly_SHA256" // hashed into the handshake, both sides must use the same
+  keySize       = 33                                     // the size of a compressed public key
+  tagSize       = chacha20poly1305.Overhead              // the size of the authentication tag of each ciphertext
+  maxFrame      = 65535                                  // the largest ciphertext of a frame
+  maxPlaintext  = maxFrame - tagSize                     // the largest plaintext of a frame
+)
+
+// The errors of the handshake and the transport
+// ErrHandshake means the peer answered, but not with the handshake: a bad reply, or a node that does not speak Noise and
+// dropped the connection on the first message; a timeout or a connection lost on the way is returned as it is
+var (
+  ErrHandshake  = errors.New("noise: handshake failed")
+  ErrDecryption = errors.New("noise: message authentication failed")
+)
+
+// Define a struct for the persistent identity of a node
+type Identity struct {
+  private *secp256k1.PrivateKey // the identity key
+}
+
+// Define a function to generate a new identity
+func NewIdentity() (*Identity, error) {
+  private, err := secp256k1.GeneratePrivateKey()
+  if err != nil {
+    return nil, err
+  }
+  return &Identity{private}, nil
+}
+
+// Define a function to load the identity kept in a file as hex, generating and writing one if the file is missing
+func LoadIdentity(path string) (*Identity, error) {
+  data, err := os.ReadFile(path)
+  if os.IsNotExist(err) {
+    identity, err := NewIdentity()
+    if err != nil {
+      return nil, err
+    }
+    return identity, os.WriteFile(path, []byte(hex.EncodeToString(identity.private.Serialize())+"\n"), 0600) // only the owner may read the key
+  }
+  if err != nil {
+    return nil, err
+  }
+  key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+  if err != nil || len(key) != 32 {
+    return nil, fmt.Errorf("noise: %s does not hold a hex private key", path)
+  }
+  return &Identity{secp256k1.PrivKeyFromBytes(key)}, nil
+}
+
+// Define a method to get the ID of the identity
+func (i *Identity) ID() string {
+  return hex.EncodeToString(i.private.PubKey().SerializeCompressed())
+}
+
+// Define a function to check an ID is a compressed public key in hex
+func ValidateID(id string) error {
+  key, err := hex.DecodeString(id)
+  if err != nil {
+    return fmt.Errorf("noise: node ID %q is not hex", id)
+  }
+  if _, err := secp256k1.ParsePubKey(key); err != nil || len(key) != keySize {
+    return fmt.Errorf("noise: node ID %q is not a compressed public key", id)
+  }
+  return nil
+}
+
+// Define a struct for the keys of one direction of the traffic
+type cipherState struct {
+  key   []byte // nil before the first key is mixed in
+  nonce uint64 // incremented with every message
+}
+
+// Define a method to encrypt a plaintext with the associated data
+func (c *cipherState) encrypt(ad, plaintext []byte) []byte {
+  if c.key == nil { // nothing is encrypted before the first Diffie-Hellman
+    return append([]byte(nil), plaintext...)
+  }
+  aead, _ := chacha20poly1305.New(c.key) // the key has the right size
+  return aead.Seal(nil, c.nextNonce(), plaintext, ad)
+}
+
+// Define a method to decrypt a ciphertext with the associated data
+func (c *cipherState) decrypt(ad, ciphertext []byte) ([]byte, error) {
+  if c.key == nil {
+    return append([]byte(nil), ciphertext...), nil
+  }
+  aead, _ := chacha20poly1305.New(c.key)
+  plaintext, err := aead.Open(nil, c.nextNonce(), ciphertext, ad)
+  if err != nil {
+    return nil, ErrDecryption
+  }
+  return plaintext, nil
+}
+
+// Define a method to get the nonce of the next message: 4 zero bytes then the counter, little endian
+func (c *cipherState) nextNonce() []byte {
+  nonce := make([]byte, chacha20poly1305.NonceSize)
+  binary.LittleEndian.PutUint64(nonce[4:], c.nonce)
+  c.nonce++
+  return nonce
+}
+
+// Define a struct for the state hashed through the handshake
+type symmetricState struct {
+  cipher cipherState // the key of the handshake messages
+  ck     []byte      // the chaining key the keys are derived from
+  h      []byte      // the hash of everything sent and received
+}
+
+// Define a function to start the state of a handshake, bound to a prologue both sides must agree on
+func newSymmetricState(prologue []byte) *symmetricState {
+  name := sha256.Sum256([]byte(protocolName)) // the name is longer than a hash
+  s := &symmetricState{ck: name[:], h: name[:]}
+  s.mixHash(prologue)
+  return s
+}
+
+// Define a method to hash data into the handshake
+func (s *symmetricState) mixHash(data []byte) {
+  hash := sha256.New()
+  hash.Write(s.h)
+  hash.Write(data)
+  s.h = hash.Sum(nil)
+}
+
+// Define a method to derive a new chaining key and cipher key from a Diffie-Hellman result
+func (s *symmetricState) mixKey(secret []byte) {
+  s.ck, s.cipher.key = hkdf(s.ck, secret)
+  s.cipher.nonce = 0
+}
+
+// Define a method to encrypt a part of a handshake message and hash the ciphertext
+func (s *symmetricState) encryptAndHash(plaintext []byte) []byte {
+  ciphertext := s.cipher.encrypt(s.h, plaintext)
+  s.mixHash(ciphertext)
+  return ciphertext
+}
+
+// Define a method to decrypt a part of a handshake message and hash the ciphertext
+func (s *symmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+  plaintext, err := s.cipher.decrypt(s.h, ciphertext)
+  if err != nil {
+    return nil, err
+  }
+  s.mixHash(ciphertext)
+  return plaintext, nil
+}
+
+// Define a method to derive the keys of the two directions once the handshake is done, the initiator sends with the first
+func (s *symmetricState) split() (*cipherState, *cipherState) {
+  first, second := hkdf(s.ck, nil)
+  return &cipherState{key: first}, &cipherState{key: second}
+}
+
+// Define a function to derive two keys from a chaining key and input key material, the HKDF of Noise
+func hkdf(ck, ikm []byte) ([]byte, []byte) {
+  mac := hmac.New(sha256.New, ck)
+  mac.Write(ikm)
+  temp := mac.Sum(nil)
+  mac = hmac.New(sha256.New, temp)
+  mac.Write([]byte{1})
+  first := mac.Sum(nil)
+  mac = hmac.New(sha256.New, temp)
+  mac.Write(first)
+  mac.Write([]byte{2})
+  return first, mac.Sum(nil)
+}
+
+// Define a function to compute the Diffie-Hellman result of a private key and a public key
+func dh(private *secp256k1.PrivateKey, public *secp256k1.PublicKey) []byte {
+  return secp256k1.GenerateSharedSecret(private, public)
+}
+
+// Define a function to read a public key of the handshake
+func parseKey(data []byte) (*secp256k1.PublicKey, error) {
+  key, err := secp256k1.ParsePubKey(data)
+  if err != nil {
+    return nil, fmt.Errorf("%w: %v", ErrHandshake, err)
+  }
+  return key, nil
+}
+
+// Define a struct for a connection encrypted after the handshake
+type Conn struct {
+  net.Conn                      // the underlying connection
+  send     *cipherState         // the keys of the frames written
+  receive  *cipherState         // the keys of the frames read
+  remote   *secp256k1.PublicKey // the identity key the peer proved
+  pending  []byte               // the plaintext of the last frame not read yet
+}
+
+// Define a method to get the ID of the peer
+func (c *Conn) RemoteID() string {
+  return hex.EncodeToString(c.remote.SerializeCompressed())
+}
+
+// Define a method to write data in encrypted frames
+func (c *Conn) Write(data []byte) (int, error) {
+  written := 0
+  for len(data) > 0 {
+    chunk := data
+    if len(chunk) > maxPlaintext {
+      chunk = chunk[:maxPlaintext]
+    }
+    ciphertext := c.send.encrypt(nil, chunk)
+    frame := make([]byte, 2, 2+len(ciphertext))
+    binary.BigEndian.PutUint16(frame, uint16(len(ciphertext)))
+    if _, err := c.Conn.Write(append(frame, ciphertext...)); err != nil {
+      return written, err
+    }
+    written += len(chunk)
+    data = data[len(chunk):]
+  }
+  return written, nil
+}
+
+// Define a method to read the decrypted data, a frame at a time
+func (c *Conn) Read(data []byte) (int, error) {
+  if len(c.pending) == 0 {
+    var length [2]byte
+    if _, err := io.ReadFull(c.Conn, length[:]); err != nil {
+      return 0, err
+    }
+    ciphertext := make([]byte, binary.BigEndian.Uint16(length[:]))
+    if _, err := io.ReadFull(c.Conn, ciphertext); err != nil {
+      return 0, err
+    }
+    plaintext, err := c.receive.decrypt(nil, ciphertext)
+    if err != nil {
+      return 0, err
+    }
+    c.pending = plaintext
+  }
+  read := copy(data, c.pending)
+  c.pending = c.pending[read:]
+  return read, nil
+}
+
+// Define a function to run the handshake as the initiator over a connection and return the encrypted connection
+func Client(conn net.Conn, identity *Identity, prologue []byte) (*Conn, error) {
+  s := newSymmetricState(prologue)
+  e, err := secp256k1.GeneratePrivateKey()
+  if err != nil {
+    return nil, err
+  }
+  // -> e
+  ephemeral := e.PubKey().SerializeCompressed()
+  s.mixHash(ephemeral)
+  first := append([]byte{HandshakeByte}, ephemeral...)
+  first = append(first, s.encryptAndHash(nil)...)
+  if _, err := conn.Write(first); err != nil {
+    return nil, err
+  }
+  // <- e, ee, s, es
+  second := make([]byte, keySize+keySize+tagSize+tagSize)
+  if read, err := io.ReadFull(conn, second); err != nil {
+    if read == 0 && hungUp(err) { // a node without Noise reads our message as a header with a bad magic and drops us
+      return nil, fmt.Errorf("%w: the peer closed the connection without answering", ErrHandshake)
+    }
+    return nil, err
+  }
+  re, err := parseKey(second[:keySize])
+  if err != nil {
+    return nil, err
+  }
+  s.mixHash(second[:keySize])
+  s.mixKey(dh(e, re))
+  static, err := s.decryptAndHash(second[keySize : 2*keySize+tagSize])
+  if err != nil {
+    return nil, fmt.Errorf("%w: %v", ErrHandshake, err)
+  }
+  rs, err := parseKey(static)
+  if err != nil {
+    return nil, err
+  }
+  s.mixKey(dh(e, rs))
+  if _, err := s.decryptAndHash(second[2*keySize+tagSize:]); err != nil {
+    return nil, fmt.Errorf("%w: %v", ErrHandshake, err)
+  }
+  // -> s, se
+  third := s.encryptAndHash(identity.private.PubKey().SerializeCompressed())
+  s.mixKey(dh(identity.private, re))
+  third = append(third, s.encryptAndHash(nil)...)
+  if _, err := conn.Write(third); err != nil {
+    return nil, err
+  }
+  send, receive := s.split()
+  return &Conn{Conn: conn, send: send, receive: receive, remote: rs}, nil
+}
+
+// Define a function to check whether a read failed because the peer closed or reset the connection
+func hungUp(err error) bool {
+  return errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// Define a function to run the handshake as the responder over a connection whose first byte is the handshake byte, and
+// return the encrypted connection
+func Server(conn net.Conn, identity *Identity, prologue []byte) (*Conn, error) {
+  s := newSymmetricState(prologue)
+  // -> e
+  first := make([]byte, 1+keySize)
+  if _, err := io.ReadFull(conn, first); err != nil {
+    return nil, err
+  }
+  if first[0] != HandshakeByte {
+    return nil, fmt.Errorf("%w: not a Noise handshake", ErrHandshake)
+  }
+  re, err := parseKey(first[1:])
+  if err != nil {
+    return nil, err
+  }
+  s.mixHash(first[1:])
+  s.decryptAndHash(nil) // the empty payload, sent in the clear
+  // <- e, ee, s, es
+  e, err := secp256k1.GeneratePrivateKey()
+  if err != nil {
+    return nil, err
+  }
+  second := e.PubKey().SerializeCompressed()
+  s.mixHash(second)
+  s.mixKey(dh(e, re))
+  second = append(second, s.encryptAndHash(identity.private.PubKey().SerializeCompressed())...)
+  s.mixKey(dh(identity.private, re))
+  second = append(second, s.encryptAndHash(nil)...)
+  if _, err := conn.Write(second); err != nil {
+    return nil, err
+  }
+  // -> s, se
+  third := make([]byte, keySize+tagSize+tagSize)
+  if _, err := io.ReadFull(conn, third); err != nil {
+    return nil, err
+  }
+  static, err := s.decryptAndHash(third[:keySize+tagSize])
+  if err != nil {
+    return nil, fmt.Errorf("%w: %v", ErrHandshake, err)
+  }
+  rs, err := parseKey(static)
+  if err != nil {
+    return nil, err
+  }
+  s.mixKey(dh(e, rs))
+  if _, err := s.decryptAndHash(third[keySize+tagSize:]); err != nil {
+    return nil, fmt.Errorf("%w: %v", ErrHandshake, err)
+  }
+  receive, send := s.split()
+  return &Conn{Conn: conn, send: send, receive: receive, remote: rs}, nil
+}
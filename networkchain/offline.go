@@ -0,0 +1,104 @@
+package main
+
+import (
+  "errors"               // for the errors of the offline mode
+  "fmt"                  // to describe the inputs that cannot be estimated
+  "networkchain/address" // to tell the key hash outputs apart
+  "networkchain/config"  // the settings of the wallet commands
+  "networkchain/script"  // to build the placeholder unlocking scripts
+  "os"                   // to read and write the files carried to and from the offline machine
+  "strings"              // to trim the files
+
+  "github.com/spf13/cobra" // the command line interface
+)
+
+// Define the sizes of the unlocking script of a key hash output, so a payment is funded before anyone signs it
+const (
+  maxSignatureSize = 72 // the longest DER encoded signature
+  pubKeySize       = 33 // a compressed public key
+)
+
+// Define the error of a wallet command needing the chain in offline mode
+var errOffline = errors.New("the wallet runs offline, this command needs the chain")
+
+// Define a function to fill the unlocking scripts of a transaction with placeholders of the size of the signatures, so
+// its fee can be computed without the keys; only the key hash outputs can be estimated
+func reserveSignatures(tx *Transaction, prevOuts []TXOutput) error {
+  placeholder := script.NewBuilder().AddData(make([]byte, maxSignatureSize), make([]byte, pubKeySize)).Script()
+  for i := range tx.Vin {
+    owner := prevOuts[i].Address()
+    if owner == "" || address.IsScriptHash(owner) {
+      return fmt.Errorf("cannot tell the size of the signatures of input %d, spending %s", i, owner)
+    }
+    tx.Vin[i].ScriptSig = placeholder
+  }
+  return nil
+}
+
+// Define a function to fund a payment with some coins without signing it, and wrap it in a partially signed
+// transaction carrying the outputs it spends, for a signer holding the keys offline; it returns the fee too
+func fundPartialTx(coins []coin, payment TXOutput, feeRate int, redeems map[string][]byte) (*PartialTx, int, error) {
+  tx, prevOuts, fee, err := fundWith(coins, payment, feeRate, 0, reserveSignatures)
+  if err != nil {
+    return nil, 0, err
+  }
+  p, err := newPartialTx(tx, prevOuts, redeems) // drops the placeholders
+  if err != nil {
+    return nil, 0, err
+  }
+  return p, fee, nil
+}
+
+// Define a method to fund a payment to an address with the coins of the wallet, the watch-only ones included, at a fee
+// rate, without signing it
+// The coins are not spent until the signed transaction comes back, so another payment may pick them meanwhile
+func (w *nodeWallet) fundPayment(to string, amount, feeRate int) (*PartialTx, int, error) {
+  if amount <= 0 {
+    return nil, 0, errors.New("the amount must be positive")
+  }
+  payment, err := NewTXOutput(amount, to)
+  if err != nil {
+    return nil, 0, err
+  }
+  w.mu.Lock() // lock the wallet
+  defer w.mu.Unlock() // unlock it when done
+  addresses := append(w.keys.Addresses(), w.keys.WatchOnlyAddresses()...)
+  return fundPartialTx((UTXOSet{w.n.bc}).FindCoins(addresses), payment, feeRate, w.keys.Redeems())
+}
+
+// Create the function that opens the chain for a wallet command, refused in offline mode so the machine holding the
+// keys never touches the chain or the network
+func openWalletChain(cmd *cobra.Command, cfg *config.Config) (*Blockchain, error) {
+  if offline, _ := cmd.Flags().GetBool("offline"); offline {
+    return nil, errOffline
+  }
+  return NewBlockchain(cfg.DataDir, ""), nil // the node must not be running
+}
+
+// Create the function that reads a partially signed transaction given in base64, or in a file when a path is given
+func readPartialTx(text, path string) (*PartialTx, error) {
+  if path != "" {
+    data, err := os.ReadFile(path)
+    if err != nil {
+      return nil, err
+    }
+    text = strings.TrimSpace(string(data))
+  }
+  if text == "" {
+    return nil, errors.New("give a partially signed transaction with --psbt or --in")
+  }
+  return decodePartialTx(text)
+}
+
+// Create the function that writes a partially signed transaction in base64 to a file, or prints it without a path
+func writePartialTx(p *PartialTx, path string) error {
+  if path == "" {
+    fmt.Println(p.Encode())
+    return nil
+  }
+  if err := os.WriteFile(path, []byte(p.Encode()+"\n"), 0600); err != nil {
+    return err
+  }
+  fmt.Printf("Wrote the partially signed transaction %x to %s\n", p.Tx.ID, path)
+  return nil
+}
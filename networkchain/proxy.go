@@ -0,0 +1,54 @@
+package main
+
+import (
+  "context"  // for the timeout of the connections
+  "errors"   // for the error of an onion address without a proxy
+  "net"      // the connections to the peers
+  "strings"  // to read the credentials of the proxy
+  "time"     // for the timeout of the connections
+
+  "golang.org/x/net/proxy" // the SOCKS5 client
+)
+
+// How long a connection through a proxy may take, Tor builds a circuit first
+const proxyDialTimeout = 30 * time.Second
+
+// The error returned when dialing a Tor hidden service without a proxy
+var errOnionWithoutProxy = errors.New("an onion address is only reachable through a Tor proxy")
+
+// Define a function to create the dialer of the connections to the peers: direct, or through a SOCKS5 proxy like Tor
+// written [user:password@]host:port, which then resolves the host names so no DNS query leaves outside it
+func newDialer(address string) (proxy.ContextDialer, error) {
+  if address == "" {
+    return &net.Dialer{}, nil
+  }
+  var auth *proxy.Auth
+  if at := strings.LastIndex(address, "@"); at >= 0 { // Tor isolates the streams of different credentials
+    user, password, _ := strings.Cut(address[:at], ":")
+    auth, address = &proxy.Auth{User: user, Password: password}, address[at+1:]
+  }
+  dialer, err := proxy.SOCKS5(protocol, address, auth, &net.Dialer{Timeout: dialTimeout})
+  if err != nil {
+    return nil, err
+  }
+  return dialer.(proxy.ContextDialer), nil
+}
+
+// Define a function to tell if an address is a Tor hidden service
+func isOnion(address string) bool {
+  host, _, err := net.SplitHostPort(address)
+  return err == nil && strings.HasSuffix(host, ".onion")
+}
+
+// Define a method to open a plain connection to a peer, through the proxy if the node has one
+func (n *Node) dialPeer(address string) (net.Conn, error) {
+  timeout := dialTimeout
+  if n.proxy != "" {
+    timeout = proxyDialTimeout
+  } else if isOnion(address) {
+    return nil, errOnionWithoutProxy
+  }
+  ctx, cancel := context.WithTimeout(context.Background(), timeout)
+  defer cancel()
+  return n.dialer.DialContext(ctx, protocol, address)
+}
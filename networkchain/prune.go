@@ -0,0 +1,79 @@
+package main
+
+import (
+  "encoding/binary"      // the pruned height is stored as a number
+  "networkchain/storage" // the bodies are dropped from the store
+)
+
+// The metadata key holding the height below which the blocks of the main chain lost their transactions
+const prunedHeightKey = "prunedheight"
+
+// create the method that starts discarding the transactions of the main chain blocks deeper than a number of blocks
+// The headers stay, so the chain and its work are unchanged; the UTXO set already holds what the old blocks left
+func (blockchain *Blockchain) EnablePruning(depth int) error {
+  blockchain.mu.Lock()         // lock the chain
+  defer blockchain.mu.Unlock() // unlock it when done
+  blockchain.pruneDepth = depth
+  return blockchain.prune()
+}
+
+// create the method that tells if the chain lost old blocks or will, so the node cannot serve every block
+func (blockchain *Blockchain) IsPruned() bool {
+  blockchain.mu.RLock()         // lock the chain for reading
+  defer blockchain.mu.RUnlock() // unlock it when done
+  return blockchain.pruneDepth > 0 || blockchain.prunedHeight > 0
+}
+
+// create the method that replaces the main chain blocks deeper than the prune depth with their headers, the lock must be held
+// Their undo data goes too: a reorganization cannot go below the pruned height
+func (blockchain *Blockchain) prune() error {
+  target := len(blockchain.Blocks) - blockchain.pruneDepth // the first height keeping its transactions
+  if blockchain.pruneDepth <= 0 || target <= blockchain.prunedHeight {
+    return nil
+  }
+  flushed, err := blockchain.db.Meta(utxoTipKey)
+  if err != nil {
+    return err
+  }
+  if node, ok := blockchain.index[indexKey(flushed)]; ok && node.height+1 < target { // the blocks the cache holds are replayed after a crash
+    if err := blockchain.utxoCache.flush(); err != nil {
+      return err
+    }
+  }
+  var headers []*Block // the headers replacing the blocks, from the pruned height on
+  err = blockchain.db.Update(func(batch *storage.Batch) error {
+    for height := blockchain.prunedHeight; height < target; height++ {
+      header := blockchain.Blocks[height].Header()
+      if err := batch.PutBlock(header.MyBlockHash, header.Serialize()); err != nil { // overwrite the stored block
+        return err
+      }
+      if err := batch.Delete(storage.UndoBucket, header.MyBlockHash); err != nil {
+        return err
+      }
+      headers = append(headers, header)
+    }
+    return batch.SetMeta(prunedHeightKey, heightBytes(target))
+  })
+  if err != nil {
+    return err
+  }
+  for i, header := range headers { // the main chain and the index share the block
+    height := blockchain.prunedHeight + i
+    blockchain.Blocks[height] = header
+    blockchain.index[indexKey(header.MyBlockHash)].block = header
+  }
+  chainLog.Debug("Pruned blocks", "from", blockchain.prunedHeight, "to", target-1)
+  blockchain.prunedHeight = target
+  return nil
+}
+
+// create the method that reads the pruned height of the store
+func (blockchain *Blockchain) loadPrunedHeight() {
+  data, err := blockchain.db.Meta(prunedHeightKey)
+  if err != nil {
+    chainLog.Panic("Failed to read the pruned height", "err", err)
+  }
+  if len(data) == 8 {
+    blockchain.prunedHeight = int(binary.BigEndian.Uint64(data))
+  }
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+  "bytes"                // to check the magic of the container
+  "encoding/base64"      // the containers are exchanged in base64
+  "encoding/gob"         // to serialize the containers
+  "encoding/hex"         // the signatures are keyed by hex public key
+  "errors"               // for the errors of the containers
+  "fmt"                  // to name the inputs in the errors
+  "networkchain/address" // to tell the multisig and aggregate outputs apart
+  "networkchain/schnorr" // to combine the partial signatures of an aggregate key
+  "networkchain/script"  // to build the unlocking scripts
+  "networkchain/wallet"  // the keys signing the inputs
+)
+
+// The bytes starting a serialized partially signed transaction, so another format is never taken for one
+var psbtMagic = []byte("psbt\xff")
+
+// Define a struct for a partially signed transaction
+// It carries the unsigned transaction along with what its signers need: the outputs spent, so a signer holding its keys
+// offline needs no chain, the multisig scripts and the signatures gathered so far; every signer adds its signatures to
+// a copy, the copies are combined, and the transaction is finalized once an input has enough signatures
+// The signers of an aggregate key go around twice: they add their nonces first, and their partial signatures once the
+// nonces of all of them are in
+type PartialTx struct {
+  Tx     *Transaction   // the transaction, without unlocking scripts
+  Inputs []PartialInput // what each input needs to be signed, in input order
+}
+
+// Define a struct for what an input of a partially signed transaction needs
+type PartialInput struct {
+  PrevOut    TXOutput          // the output the input spends
+  Redeem     []byte            // the multisig script of a script hash output or the keys of an aggregate one, nil for a key hash
+  Signatures map[string][]byte // the signatures of the input, the partial ones for an aggregate key, by hex public key
+  Nonces     map[string][]byte // the public nonces of the signers of an aggregate key, by hex public key
+}
+
+// Define a function to wrap an unsigned transaction in a partially signed one, with the outputs its inputs spend and
+// the multisig scripts and the aggregate keys among the given ones that the spent outputs pay to, by address
+func newPartialTx(tx *Transaction, prevOuts []TXOutput, redeems map[string][]byte) (*PartialTx, error) {
+  unsigned := &Transaction{nil, make([]TXInput, len(tx.Vin)), tx.Vout, tx.LockTime}
+  p := &PartialTx{unsigned, make([]PartialInput, len(tx.Vin))}
+  for i, in := range tx.Vin {
+    unsigned.Vin[i] = TXInput{Txid: in.Txid, Vout: in.Vout} // the signatures live in the inputs of the container
+    owner := prevOuts[i].Address()
+    if owner == "" {
+      return nil, fmt.Errorf("input %d spends an output with a script that is not standard: %s", i, script.Disassemble(prevOuts[i].ScriptPubKey))
+    }
+    p.Inputs[i] = PartialInput{PrevOut: prevOuts[i], Redeem: redeems[owner], Signatures: map[string][]byte{}, Nonces: map[string][]byte{}}
+  }
+  unsigned.ID = unsigned.Hash()
+  return p, nil
+}
+
+// Define a method to serialize a partially signed transaction in base64
+func (p *PartialTx) Encode() string {
+  encoded := bytes.NewBuffer(append([]byte{}, psbtMagic...))
+  if err := gob.NewEncoder(encoded).Encode(p); err != nil { // encoding a struct of slices never fails
+    chainLog.Panic("Failed to encode a partially signed transaction", "err", err)
+  }
+  return base64.StdEncoding.EncodeToString(encoded.Bytes())
+}
+
+// Define a function to rebuild a partially signed transaction serialized by Encode
+func decodePartialTx(text string) (*PartialTx, error) {
+  data, err := base64.StdEncoding.DecodeString(text)
+  if err != nil || !bytes.HasPrefix(data, psbtMagic) {
+    return nil, errors.New("not a partially signed transaction")
+  }
+  var p PartialTx
+  if err := gob.NewDecoder(bytes.NewReader(data[len(psbtMagic):])).Decode(&p); err != nil {
+    return nil, fmt.Errorf("cannot decode the partially signed transaction: %w", err)
+  }
+  if p.Tx == nil || len(p.Inputs) != len(p.Tx.Vin) {
+    return nil, errors.New("the partially signed transaction does not describe every input")
+  }
+  for i := range p.Inputs {
+    if p.Inputs[i].Signatures == nil { // gob leaves an empty map out
+      p.Inputs[i].Signatures = map[string][]byte{}
+    }
+    if p.Inputs[i].Nonces == nil {
+      p.Inputs[i].Nonces = map[string][]byte{}
+    }
+  }
+  return &p, nil
+}
+
+// Define a method to get the public keys allowed to sign an input, and how many of them must sign
+func (in *PartialInput) signers() ([][]byte, int, error) {
+  owner := in.PrevOut.Address()
+  if address.IsSchnorr(owner) { // an aggregate key is signed by all its keys
+    aggregate, err := in.aggregate()
+    if err != nil {
+      return nil, 0, err
+    }
+    return aggregate.PubKeys, len(aggregate.PubKeys), nil
+  }
+  if !address.IsScriptHash(owner) { // a key hash is signed by the one key hashing to it, known once it signed
+    return nil, 1, nil
+  }
+  if in.Redeem == nil {
+    return nil, 0, fmt.Errorf("the multisig script of %s is unknown", owner)
+  }
+  multisig, err := wallet.ParseMultisigScript(in.Redeem)
+  if err != nil {
+    return nil, 0, err
+  }
+  if multisig.Address() != owner {
+    return nil, 0, fmt.Errorf("the multisig script does not hash to %s", owner)
+  }
+  return multisig.PubKeys, multisig.Required, nil
+}
+
+// Define a method to get the aggregate key of an input spending a Schnorr output
+func (in *PartialInput) aggregate() (*wallet.AggregateKey, error) {
+  owner := in.PrevOut.Address()
+  if in.Redeem == nil {
+    return nil, fmt.Errorf("the aggregate key of %s is unknown", owner)
+  }
+  aggregate, err := wallet.ParseAggregateKey(in.Redeem)
+  if err != nil {
+    return nil, err
+  }
+  if aggregate.Address() != owner {
+    return nil, fmt.Errorf("the aggregate key does not hash to %s", owner)
+  }
+  return aggregate, nil
+}
+
+// Define a method to add the signatures of the keys of the wallets to every input they can sign, returning how many
+// were added along with the nonces added for the aggregate keys; the multisig scripts and the aggregate keys the
+// container lacks are taken from the wallets
+func (p *PartialTx) Sign(wallets *wallet.Wallets) (int, int) {
+  added, nonces := 0, 0
+  redeems := wallets.Redeems()
+  for i := range p.Inputs {
+    in := &p.Inputs[i]
+    owner, hash := in.PrevOut.Address(), p.Tx.SignatureHash(i, in.PrevOut)
+    if in.Redeem == nil && redeems[owner] != nil {
+      in.Redeem = redeems[owner]
+    }
+    keys, _, err := in.signers()
+    if err != nil {
+      continue // another signer may know the script
+    }
+    if address.IsSchnorr(owner) {
+      signed, shared := in.signAggregate(wallets, hash)
+      added, nonces = added+signed, nonces+shared
+      continue
+    }
+    var signers []*wallet.Wallet
+    if keys == nil { // a key hash
+      if w, err := wallets.Wallet(owner); err == nil {
+        signers = append(signers, w)
+      }
+    }
+    for _, key := range keys {
+      if w, err := wallets.Wallet(wallet.AddressFromPubKey(key)); err == nil {
+        signers = append(signers, w)
+      }
+    }
+    for _, w := range signers {
+      key := hex.EncodeToString(w.PublicKey)
+      if _, ok := in.Signatures[key]; !ok {
+        in.Signatures[key] = w.Sign(hash)
+        added++
+      }
+    }
+  }
+  return added, nonces
+}
+
+// Define a method to add the nonces of the keys of an aggregate key held by the wallets to an input, then their partial
+// signatures once every signer added its nonce, returning how many of each were added
+func (in *PartialInput) signAggregate(wallets *wallet.Wallets, hash []byte) (int, int) {
+  aggregate, err := in.aggregate()
+  if err != nil {
+    return 0, 0
+  }
+  nonces, err := wallets.MuSigNonces(aggregate, hash)
+  if err != nil {
+    return 0, 0
+  }
+  shared := 0
+  for key, nonce := range nonces {
+    if _, ok := in.Nonces[key]; !ok {
+      in.Nonces[key] = nonce
+      shared++
+    }
+  }
+  if len(in.Nonces) < len(aggregate.PubKeys) { // the other signers add their nonces first
+    return 0, shared
+  }
+  partials, err := wallets.MuSigSign(aggregate, hash, in.Nonces)
+  if err != nil {
+    return 0, shared
+  }
+  signed := 0
+  for key, partial := range partials {
+    if _, ok := in.Signatures[key]; !ok {
+      in.Signatures[key] = partial
+      signed++
+    }
+  }
+  return signed, shared
+}
+
+// Define a function to merge the signatures and the nonces of copies of one partially signed transaction
+func combinePartialTxs(parts []*PartialTx) (*PartialTx, error) {
+  if len(parts) == 0 {
+    return nil, errors.New("no partially signed transaction to combine")
+  }
+  combined := parts[0]
+  for _, p := range parts[1:] {
+    if !bytes.Equal(p.Tx.Hash(), combined.Tx.Hash()) {
+      return nil, fmt.Errorf("transaction %x is not transaction %x", p.Tx.Hash(), combined.Tx.Hash())
+    }
+    for i, in := range p.Inputs {
+      if combined.Inputs[i].Redeem == nil {
+        combined.Inputs[i].Redeem = in.Redeem
+      }
+      for key, signature := range in.Signatures {
+        combined.Inputs[i].Signatures[key] = signature
+      }
+      for key, nonce := range in.Nonces {
+        combined.Inputs[i].Nonces[key] = nonce
+      }
+    }
+  }
+  return combined, nil
+}
+
+// Define a method to count the valid signatures of an input, and to build its unlocking script once there are enough
+func (p *PartialTx) unlock(i int) ([]byte, int, int, error) {
+  in := &p.Inputs[i]
+  keys, required, err := in.signers()
+  if err != nil {
+    return nil, 0, 0, err
+  }
+  hash := p.Tx.SignatureHash(i, in.PrevOut)
+  if keys == nil { // a key hash: <signature> <public key>
+    for key, signature := range in.Signatures {
+      pubKey, err := hex.DecodeString(key)
+      if err == nil && wallet.AddressFromPubKey(pubKey) == in.PrevOut.Address() && wallet.Verify(pubKey, hash, signature) == nil {
+        return script.NewBuilder().AddData(signature, pubKey).Script(), 1, 1, nil
+      }
+    }
+    return nil, 0, 1, nil
+  }
+  if address.IsSchnorr(in.PrevOut.Address()) { // an aggregate key: <signature> <aggregate key>, the partial signatures added up
+    return in.unlockAggregate(keys, hash)
+  }
+  var signatures [][]byte // a multisig: <signatures...> <multisig script>, the signatures in the order of their keys
+  for _, key := range keys {
+    signature, ok := in.Signatures[hex.EncodeToString(key)]
+    if ok && len(signatures) < required && wallet.Verify(key, hash, signature) == nil {
+      signatures = append(signatures, signature)
+    }
+  }
+  if len(signatures) < required {
+    return nil, len(signatures), required, nil
+  }
+  return script.NewBuilder().AddData(signatures...).AddData(in.Redeem).Script(), required, required, nil
+}
+
+// Define a method to count the valid partial signatures of an input spending an aggregate key, and to build its
+// unlocking script once every key signed
+func (in *PartialInput) unlockAggregate(keys [][]byte, hash []byte) ([]byte, int, int, error) {
+  signed := 0
+  for _, key := range keys {
+    partial, ok := in.Signatures[hex.EncodeToString(key)]
+    if ok && schnorr.VerifyPartial(key, partial, keys, in.Nonces, hash) {
+      signed++
+    }
+  }
+  if signed < len(keys) {
+    return nil, signed, len(keys), nil
+  }
+  signature, err := schnorr.Combine(keys, in.Nonces, in.Signatures, hash)
+  if err != nil {
+    return nil, 0, len(keys), err
+  }
+  aggregate, _ := schnorr.AggregateKeys(keys) // checked by signers
+  return script.NewBuilder().AddData(signature, aggregate).Script(), signed, signed, nil
+}
+
+// Define a method to wrap an unsigned transaction spending outputs of the chain or the mempool in a partially signed one,
+// with the multisig scripts and the aggregate keys of some wallets, if any, and the given ones
+func (n *Node) newPartialTx(tx *Transaction, wallets *wallet.Wallets, scripts [][]byte) (*PartialTx, error) {
+  redeems := map[string][]byte{} // the scripts by address
+  if wallets != nil {
+    redeems = wallets.Redeems()
+  }
+  for _, redeem := range scripts {
+    if aggregate, err := wallet.ParseAggregateKey(redeem); err == nil { // the keys of an aggregate key, a script never parses as one
+      redeems[aggregate.Address()] = redeem
+      continue
+    }
+    multisig, err := wallet.ParseMultisigScript(redeem)
+    if err != nil {
+      return nil, err
+    }
+    redeems[multisig.Address()] = redeem
+  }
+  var prevOuts []TXOutput
+  for i, in := range tx.Vin {
+    entry, ok := n.bc.findUnspentOutput(in.Txid, in.Vout)
+    if !ok {
+      return nil, fmt.Errorf("input %d spends %x:%d, which is spent or unknown", i, in.Txid, in.Vout)
+    }
+    prevOuts = append(prevOuts, entry.output())
+  }
+  return newPartialTx(tx, prevOuts, redeems)
+}
+
+// Define a method to build the signed transaction once every input has enough signatures, the error tells which input
+// still misses some
+func (p *PartialTx) Finalize() (*Transaction, error) {
+  tx := &Transaction{nil, make([]TXInput, len(p.Tx.Vin)), p.Tx.Vout, p.Tx.LockTime}
+  copy(tx.Vin, p.Tx.Vin)
+  for i := range p.Inputs {
+    unlocking, signed, required, err := p.unlock(i)
+    if err != nil {
+      return nil, fmt.Errorf("input %d: %w", i, err)
+    }
+    if unlocking == nil {
+      return nil, fmt.Errorf("input %d has %d of the %d signatures it needs", i, signed, required)
+    }
+    tx.Vin[i].ScriptSig = unlocking
+    if err := tx.verifyInput(i, p.Inputs[i].PrevOut); err != nil {
+      return nil, fmt.Errorf("input %d: %w", i, err)
+    }
+  }
+  tx.ID = tx.Hash() // the ID covers the signatures
+  return tx, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net"
+	"time"
+)
+
+// Define some limits protecting the node from peers flooding it
+const (
+  maxPayloadSize  = 8 << 20          // the largest payload accepted, in bytes
+  maxMessageRate  = 500              // the messages a host may send per second on average
+  maxMessageBurst = 1000             // the messages a host may send at once after being quiet
+  messageTimeout  = 30 * time.Second // how long a peer has to send a whole message
+  limitPenalty    = time.Minute      // how long a host that broke a limit is disconnected
+  maxLimiters     = 10000            // the number of hosts tracked before the idle ones are forgotten
+)
+
+// Define a struct for the message rate of a host, a token bucket refilled at maxMessageRate
+type rateLimiter struct {
+  tokens       float64   // the messages the host may still send right away
+  last         time.Time // when the bucket was last refilled
+  blockedUntil time.Time // the end of the penalty of a host that broke a limit
+}
+
+// Define a function to get the host of a remote address, the limits apply to the host whatever the port
+func remoteHost(addr net.Addr) string {
+  host, _, err := net.SplitHostPort(addr.String())
+  if err != nil {
+    return addr.String()
+  }
+  return host
+}
+
+// Define a method to check if a host may send another message, taking it from its bucket
+func (n *Node) allowMessage(host string) bool {
+  now := time.Now()
+  n.mu.Lock() // lock the peer state
+  defer n.mu.Unlock() // unlock it when done
+  limiter, ok := n.limiters[host] // get the bucket of the host
+  if !ok { // if the host is new
+    if len(n.limiters) >= maxLimiters { // do not let a crowd of hosts grow the map forever
+      n.pruneLimiters(now)
+    }
+    limiter = &rateLimiter{tokens: maxMessageBurst, last: now} // it starts with a full bucket
+    n.limiters[host] = limiter
+  }
+  if now.Before(limiter.blockedUntil) { // if the host is serving a penalty
+    return false
+  }
+  limiter.tokens += now.Sub(limiter.last).Seconds() * maxMessageRate // refill the bucket for the time elapsed
+  if limiter.tokens > maxMessageBurst {
+    limiter.tokens = maxMessageBurst
+  }
+  limiter.last = now
+  if limiter.tokens < 1 { // if the host sends faster than the rate
+    limiter.blockedUntil = now.Add(limitPenalty) // disconnect it for a while
+    netLog.Warn("Disconnecting host sending too many messages", "host", host, "penalty", limitPenalty)
+    return false
+  }
+  limiter.tokens-- // take the message from the bucket
+  return true
+}
+
+// Define a method to disconnect a host for a while after it broke a limit
+func (n *Node) penalize(host string, reason error) {
+  netLog.Warn("Disconnecting host", "host", host, "reason", reason, "penalty", limitPenalty)
+  n.mu.Lock() // lock the peer state
+  defer n.mu.Unlock() // unlock it when done
+  limiter, ok := n.limiters[host]
+  if !ok {
+    limiter = &rateLimiter{last: time.Now()}
+    n.limiters[host] = limiter
+  }
+  limiter.blockedUntil = time.Now().Add(limitPenalty)
+}
+
+// Define a method to forget the hosts whose bucket is full and that serve no penalty, the peer state must be locked
+func (n *Node) pruneLimiters(now time.Time) {
+  refill := time.Duration(float64(maxMessageBurst) / maxMessageRate * float64(time.Second)) // the time an empty bucket takes to fill
+  for host, limiter := range n.limiters {
+    if now.Sub(limiter.last) > refill && now.After(limiter.blockedUntil) { // the host would start with a full bucket anyway
+      delete(n.limiters, host)
+    }
+  }
+}
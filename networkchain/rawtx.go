@@ -0,0 +1,57 @@
+package main
+
+import (
+  "encoding/hex"        // the given outputs are keyed by hex ID
+  "errors"              // for the errors of the inputs
+  "fmt"                 // to name the inputs in the errors
+  "networkchain/script" // to tell a multisig input missing signatures
+  "networkchain/wallet" // the keys signing the inputs
+)
+
+// Define a function to build an unsigned transaction spending some outputs and paying others, the inputs are signed
+// later, by the node or by the owners of the keys
+func newRawTransaction(inputs []TXInput, outputs []TXOutput, lockTime int) *Transaction {
+  tx := &Transaction{nil, inputs, outputs, lockTime}
+  tx.ID = tx.Hash() // the ID changes with every signature added
+  return tx
+}
+
+// Define a function to get the key of an output given with a transaction to sign
+func prevOutKey(txid []byte, vout int) string {
+  return fmt.Sprintf("%s:%d", hex.EncodeToString(txid), vout)
+}
+
+// Define a method to sign the inputs of a transaction with some keys, returning whether every input is signed and why
+// the others are not
+// The outputs spent are looked up in the given ones first, so the outputs the node does not know yet can be spent, then
+// in the chain and the mempool; the inputs already signed are kept, so several owners sign in turn
+func (n *Node) signTransaction(tx *Transaction, keys *wallet.Wallets, given map[string]TXOutput) (bool, []error) {
+  var problems []error
+  for i, in := range tx.Vin {
+    prevOut, ok := given[prevOutKey(in.Txid, in.Vout)]
+    if !ok {
+      var entry utxoEntry
+      if entry, ok = n.bc.findUnspentOutput(in.Txid, in.Vout); ok {
+        prevOut = entry.output()
+      }
+    }
+    if !ok {
+      problems = append(problems, fmt.Errorf("input %d spends %x:%d, which is spent or unknown", i, in.Txid, in.Vout))
+      continue
+    }
+    if tx.verifyInput(i, prevOut) == nil { // signed already
+      continue
+    }
+    if err := tx.signInput(keys, i, prevOut); err != nil {
+      problems = append(problems, fmt.Errorf("input %d: %w", i, err))
+      continue
+    }
+    if err := tx.verifyInput(i, prevOut); errors.Is(err, script.ErrNotEnoughSignatures) {
+      problems = append(problems, fmt.Errorf("input %d needs the signatures of other keys", i))
+    } else if err != nil {
+      problems = append(problems, fmt.Errorf("input %d: %w", i, err))
+    }
+  }
+  tx.ID = tx.Hash() // the ID covers the signatures
+  return len(problems) == 0, problems
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+  "encoding/hex"        // the transactions are keyed by hex ID
+  "fmt"                 // to describe the transactions that cannot be abandoned
+  "networkchain/events" // the chain tells when the tip moves and the mempool when it accepts a transaction
+  "time"                // for the interval of the announcements
+)
+
+// Define how often the pending wallet transactions are announced again
+const rebroadcastInterval = 5 * time.Minute
+
+// Define the events the wallet buffers before the next ones are dropped, a missed block is found by the next scan
+const walletEventBuffer = 1000
+
+// Define a method to announce the pending wallet transactions to every peer again, putting the ones the mempool dropped
+// back first; the peers that have them ignore the announcement
+func (w *nodeWallet) rebroadcastWalletTxs() {
+  w.scanWalletTxs()
+  w.history.mu.Lock() // lock the transactions
+  var pending []*Transaction
+  for _, wtx := range w.history.sorted() { // the parents first, so they get back in the mempool before their children
+    if wtx.status() == walletTxPending { // a conflicted one can no longer be mined
+      pending = append(pending, wtx.tx)
+    }
+  }
+  w.history.mu.Unlock() // unlock them
+  var announced []*Transaction
+  for _, tx := range pending {
+    if !w.n.bc.Mempool.Has(hex.EncodeToString(tx.ID)) { // expired, evicted, or the node restarted
+      if _, _, err := w.n.bc.AddTxToMempool(tx); err != nil {
+        netLog.Debug("Failed to put a wallet transaction back in the mempool", "txid", tx.ID, "err", err)
+        continue
+      }
+      if !w.n.bc.Mempool.Has(hex.EncodeToString(tx.ID)) { // it waits for a parent in the orphan pool
+        continue
+      }
+    }
+    announced = append(announced, tx)
+  }
+  if len(announced) == 0 {
+    return
+  }
+  for _, peer := range w.n.peers() { // every peer, the ones it was announced to already included
+    var ids [][]byte
+    for _, tx := range announced {
+      if w.n.peerWantsTx(peer, tx) {
+        w.n.inv.addKnown(peer, invKey("tx", tx.ID))
+        ids = append(ids, tx.ID)
+      }
+    }
+    if len(ids) > 0 {
+      w.n.sendInv(peer, "tx", ids)
+    }
+  }
+  netLog.Info("Announced the unconfirmed wallet transactions again", "count", len(announced))
+}
+
+// Define a method to announce the pending wallet transactions every rebroadcastInterval until the node stops or the
+// wallet is unloaded, to follow the wallet transactions the mempool accepts from the peers, and to search the blocks
+// for them as the tip moves
+func (w *nodeWallet) rebroadcastLoop() {
+  ticker := time.NewTicker(rebroadcastInterval) // create a ticker for the interval
+  defer ticker.Stop() // stop it when done
+  sub := w.n.events.Subscribe(walletEventBuffer, events.TipChanged, events.ReorgDetected, events.TxAccepted)
+  defer sub.Cancel()
+  for {
+    select {
+    case <-w.n.quit: // the node stopped
+      return
+    case <-w.quit: // the wallet was unloaded
+      return
+    case event := <-sub.C:
+      if tx, ok := event.Data.(*Transaction); ok && event.Type == events.TxAccepted {
+        w.trackWalletTx(tx)
+      } else {
+        w.scanWalletTxs()
+      }
+    case <-ticker.C:
+      w.rebroadcastWalletTxs()
+    }
+  }
+}
+
+// Define a method to give up an unconfirmed wallet transaction: it is no longer announced and it leaves the mempool
+// with the transactions spending its outputs, so the wallet can spend its inputs again; the peers may still mine it
+func (w *nodeWallet) abandonTransaction(id string) error {
+  w.mu.Lock() // no payment picks the coins while they are released
+  defer w.mu.Unlock() // unlock the wallet when done
+  w.scanWalletTxs() // a transaction mined since the last scan cannot be abandoned
+  h := w.history
+  h.mu.Lock() // lock the transactions
+  defer h.mu.Unlock() // unlock them when done
+  wtx, ok := h.txs[id]
+  if !ok {
+    return errNotWalletTx
+  }
+  if wtx.block != nil {
+    return fmt.Errorf("transaction %s is confirmed in block %x", id, wtx.block.MyBlockHash)
+  }
+  abandoned := map[string]bool{id: true} // the transaction and the wallet transactions spending its outputs
+  for _, other := range h.sorted() { // the children come after their parents
+    for _, in := range other.tx.Vin {
+      if abandoned[hex.EncodeToString(in.Txid)] && other.block == nil {
+        abandoned[hex.EncodeToString(other.tx.ID)] = true
+      }
+    }
+  }
+  for abandonedID := range abandoned {
+    delete(h.txs, abandonedID)
+    w.n.bc.RemoveFromMempool(abandonedID)
+    netLog.Info("Abandoned wallet transaction", "txid", abandonedID)
+  }
+  return h.save()
+}
@@ -0,0 +1,183 @@
+package rpc
+
+import (
+  "bytes"         // to render a page before writing it
+  "errors"        // to map the backend errors to status codes
+  "html/template" // the pages, escaping the values
+  "net/http"      // the transport of the requests
+  "net/url"       // to build the redirections of the search
+  "strings"       // to split the paths
+  "time"          // to print the block times
+)
+
+// Define the prefix of the explorer paths
+const explorerPrefix = "/explorer/"
+
+// Define the number of blocks listed on the overview page
+const overviewBlocks = 20
+
+// Define the pages of the explorer, each one fills the content of the layout
+const explorerLayout = `{{define "layout"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} - networkchain explorer</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 0.8em; text-align: left; border-bottom: 1px solid #ddd; }
+.hash { font-family: monospace; }
+</style>
+</head>
+<body>
+<p><a href="/explorer/">Overview</a></p>
+<form action="/explorer/search"><input name="q" size="70" placeholder="block hash, transaction ID or address"> <input type="submit" value="Search"></form>
+<h1>{{.Title}}</h1>
+{{template "content" .Data}}
+</body>
+</html>{{end}}`
+
+const overviewPage = `{{define "content"}}{{if .}}
+<p>Height {{(index . 0).Height}}, last block <a class="hash" href="/explorer/block/{{(index . 0).Hash}}">{{(index . 0).Hash}}</a></p>
+<table>
+<tr><th>Height</th><th>Hash</th><th>Time</th><th>Transactions</th></tr>
+{{range .}}<tr><td>{{.Height}}</td><td><a class="hash" href="/explorer/block/{{.Hash}}">{{.Hash}}</a></td><td>{{unixTime .Time}}</td><td>{{len .Transactions}}</td></tr>
+{{end}}</table>
+{{else}}<p>The chain is empty.</p>{{end}}{{end}}`
+
+const blockPage = `{{define "content"}}<table>
+<tr><th>Hash</th><td class="hash">{{.Hash}}</td></tr>
+<tr><th>Height</th><td>{{.Height}}</td></tr>
+<tr><th>Confirmations</th><td>{{.Confirmations}}{{if eq .Confirmations 0}} (side branch){{end}}</td></tr>
+<tr><th>Previous block</th><td>{{if .PreviousBlockHash}}<a class="hash" href="/explorer/block/{{.PreviousBlockHash}}">{{.PreviousBlockHash}}</a>{{else}}none, genesis block{{end}}</td></tr>
+<tr><th>Merkle root</th><td class="hash">{{.MerkleRoot}}</td></tr>
+<tr><th>Time</th><td>{{unixTime .Time}}</td></tr>
+<tr><th>Bits</th><td>{{.Bits}}</td></tr>
+<tr><th>Nonce</th><td>{{.Nonce}}</td></tr>
+</table>
+<h2>Transactions</h2>
+<ul>
+{{range .Transactions}}<li><a class="hash" href="/explorer/tx/{{.}}">{{.}}</a></li>
+{{end}}</ul>{{end}}`
+
+const transactionPage = `{{define "content"}}<table>
+<tr><th>ID</th><td class="hash">{{.ID}}</td></tr>
+<tr><th>Block</th><td>{{if .BlockHash}}<a class="hash" href="/explorer/block/{{.BlockHash}}">{{.BlockHash}}</a> at height {{.Height}}{{else}}none, in the mempool{{end}}</td></tr>
+<tr><th>Confirmations</th><td>{{.Confirmations}}</td></tr>
+</table>
+<h2>Inputs</h2>
+<table>
+<tr><th>Spent output</th><th>Address</th></tr>
+{{range .Inputs}}<tr>{{if $.Coinbase}}<td>none, coinbase</td><td>{{.Coinbase}}</td>{{else}}<td><a class="hash" href="/explorer/tx/{{.Txid}}">{{.Txid}}</a>:{{.Vout}}</td><td><a href="/explorer/address/{{.Address}}">{{.Address}}</a></td>{{end}}</tr>
+{{end}}</table>
+<h2>Outputs</h2>
+<table>
+<tr><th>Index</th><th>Address</th><th>Value</th></tr>
+{{range $i, $out := .Outputs}}<tr><td>{{$i}}</td><td>{{if $out.Address}}<a href="/explorer/address/{{$out.Address}}">{{$out.Address}}</a>{{else}}{{$out.Asm}}{{end}}</td><td>{{$out.Value}}</td></tr>
+{{end}}</table>{{end}}`
+
+const addressPage = `{{define "content"}}<p>Balance {{.Balance.Balance}} in {{.Balance.Outputs}} unspent outputs</p>
+<h2>Transactions</h2>
+{{if gt .History.Total (len .History.Transactions)}}<p>The latest {{len .History.Transactions}} of {{.History.Total}} transactions</p>
+{{end}}{{if .History.Transactions}}<table>
+<tr><th>Transaction</th><th>Height</th><th>Time</th><th>Received</th><th>Sent</th></tr>
+{{range .History.Transactions}}<tr><td><a class="hash" href="/explorer/tx/{{.Txid}}">{{.Txid}}</a></td><td><a href="/explorer/block/{{.BlockHash}}">{{.Height}}</a></td><td>{{unixTime .Time}}</td><td>{{.Received}}</td><td>{{.Sent}}</td></tr>
+{{end}}</table>
+{{else}}<p>No confirmed transaction.</p>{{end}}{{end}}`
+
+// Define the functions the pages use
+var explorerFuncs = template.FuncMap{
+  "unixTime": func(t int64) string { return time.Unix(t, 0).UTC().Format("2006-01-02 15:04:05 UTC") },
+}
+
+// Parse the pages once, each one on its own copy of the layout
+var explorerPages = map[string]*template.Template{
+  "overview":    template.Must(template.Must(template.New("").Funcs(explorerFuncs).Parse(explorerLayout)).Parse(overviewPage)),
+  "block":       template.Must(template.Must(template.New("").Funcs(explorerFuncs).Parse(explorerLayout)).Parse(blockPage)),
+  "transaction": template.Must(template.Must(template.New("").Funcs(explorerFuncs).Parse(explorerLayout)).Parse(transactionPage)),
+  "address":     template.Must(template.Must(template.New("").Funcs(explorerFuncs).Parse(explorerLayout)).Parse(addressPage)),
+}
+
+// Define a struct for the data of the address page
+type addressView struct {
+  Balance *Balance
+  History *History
+}
+
+// Define a struct for the HTML explorer
+type htmlExplorer struct {
+  explorer Explorer // the node answering the queries
+}
+
+// Define a function to create the handler of the explorer pages, to be mounted on /explorer/
+func NewHTMLExplorer(explorer Explorer) http.Handler {
+  return &htmlExplorer{explorer}
+}
+
+// Define a method to answer an explorer request
+// The pages are /explorer/, /explorer/block/{hash}, /explorer/tx/{id} and /explorer/address/{addr},
+// /explorer/search?q= redirects to the page of a block, a transaction or an address
+func (h *htmlExplorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  if r.Method != http.MethodGet && r.Method != http.MethodHead {
+    http.Error(w, "the explorer is read-only", http.StatusMethodNotAllowed)
+    return
+  }
+  path := strings.Trim(strings.TrimPrefix(r.URL.Path, explorerPrefix), "/")
+  parts := strings.Split(path, "/")
+  switch {
+  case path == "":
+    h.render(w, "overview", "Chain overview", h.explorer.RecentBlocks(overviewBlocks), nil)
+  case path == "search":
+    h.search(w, r, strings.TrimSpace(r.URL.Query().Get("q")))
+  case len(parts) == 2 && parts[0] == "block":
+    block, err := h.explorer.Block(parts[1])
+    h.render(w, "block", "Block "+parts[1], block, err)
+  case len(parts) == 2 && parts[0] == "tx":
+    tx, err := h.explorer.Transaction(parts[1])
+    h.render(w, "transaction", "Transaction "+parts[1], tx, err)
+  case len(parts) == 2 && parts[0] == "address":
+    view := addressView{}
+    var err error
+    if view.Balance, err = h.explorer.Balance(parts[1]); err == nil {
+      view.History, err = h.explorer.History(parts[1], 0, defaultHistoryCount) // the newest ones
+    }
+    h.render(w, "address", "Address "+parts[1], view, err)
+  default:
+    http.NotFound(w, r)
+  }
+}
+
+// Define a method to redirect a search to the block, the transaction or the address it names
+func (h *htmlExplorer) search(w http.ResponseWriter, r *http.Request, query string) {
+  target := explorerPrefix
+  if _, err := h.explorer.Block(query); err == nil { // hashes and IDs look the same, try the blocks first
+    target += "block/" + url.PathEscape(query)
+  } else if _, err := h.explorer.Transaction(query); err == nil {
+    target += "tx/" + url.PathEscape(query)
+  } else if query != "" { // anything else is taken as an address
+    target += "address/" + url.PathEscape(query)
+  }
+  http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// Define a method to write a page, or the error of the backend
+func (h *htmlExplorer) render(w http.ResponseWriter, page, title string, data interface{}, err error) {
+  if errors.Is(err, ErrNotFound) {
+    http.Error(w, err.Error(), http.StatusNotFound)
+    return
+  }
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  var buf bytes.Buffer // render first, so a template error does not send half a page
+  if err := explorerPages[page].ExecuteTemplate(&buf, "layout", struct {
+    Title string
+    Data  interface{}
+  }{title, data}); err != nil {
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  w.Write(buf.Bytes())
+}
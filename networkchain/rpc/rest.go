@@ -0,0 +1,179 @@
+package rpc
+
+import (
+  "encoding/json" // the encoding of the responses
+  "errors"        // to map the backend errors to status codes
+  "net/http"      // the transport of the requests
+  "strconv"       // to parse the block count
+  "strings"       // to split the paths
+)
+
+// Define the prefix of the REST paths
+const restPrefix = "/api/"
+
+// Define an interface for the read-only queries of the REST layer
+type Explorer interface {
+  Block(hash string) (*Block, error)           // a block by hex hash, ErrNotFound if unknown
+  RecentBlocks(count int) []*Block             // the last blocks of the main chain, newest first
+  Transaction(id string) (*Transaction, error) // a transaction of the chain or the mempool by hex ID, ErrNotFound if unknown
+  Balance(address string) (*Balance, error)    // the unspent value locked to an address
+  History(address string, skip, count int) (*History, error) // a page of the main chain transactions spending from or paying to an address, newest first
+}
+
+// Define a struct for the JSON view of a transaction
+type Transaction struct {
+  ID            string   `json:"txid"`
+  BlockHash     string   `json:"blockhash,omitempty"` // empty while the transaction is in the mempool
+  Height        int      `json:"height,omitempty"`
+  Confirmations int      `json:"confirmations"`
+  Coinbase      bool     `json:"coinbase,omitempty"`
+  LockTime      int      `json:"locktime,omitempty"`
+  Inputs        []Input  `json:"vin"`
+  Outputs       []Output `json:"vout"`
+}
+
+// Define a struct for the JSON view of a transaction input
+type Input struct {
+  Txid      string `json:"txid,omitempty"`      // empty for a coinbase
+  Vout      int    `json:"vout"`
+  Coinbase  string `json:"coinbase,omitempty"`  // the data of a coinbase
+  ScriptSig string `json:"scriptsig,omitempty"` // the unlocking script in hex
+  Asm       string `json:"asm,omitempty"`       // the unlocking script in a readable form
+  Address   string `json:"address,omitempty"`   // the owner of the spent output
+}
+
+// Define a struct for the JSON view of a transaction output
+type Output struct {
+  Value        int    `json:"value"`
+  ScriptPubKey string `json:"scriptpubkey"`      // the locking script in hex
+  Asm          string `json:"asm"`               // the locking script in a readable form
+  Address      string `json:"address,omitempty"` // the address paid, empty for a script that is not standard
+}
+
+// Define a struct for the JSON view of the balance of an address
+type Balance struct {
+  Address string `json:"address"`
+  Balance int    `json:"balance"`
+  Outputs int    `json:"utxos"` // the number of unspent outputs making the balance
+}
+
+// Define a struct for the JSON view of the transactions of an address
+type History struct {
+  Address      string         `json:"address"`
+  Total        int            `json:"total"` // the number of transactions of the address, the page holds some of them
+  Skip         int            `json:"skip"`  // the number of newer transactions before the page
+  Transactions []HistoryEntry `json:"txs"`
+}
+
+// Define a struct for the JSON view of a transaction in the history of an address
+type HistoryEntry struct {
+  Txid      string `json:"txid"`
+  BlockHash string `json:"blockhash"`
+  Height    int    `json:"height"`
+  Time      int64  `json:"time"`
+  Received  int    `json:"received"` // the value of the outputs paying to the address
+  Sent      int    `json:"sent"`     // the value of the outputs of the address spent by the inputs
+}
+
+// Define the number of blocks listed by /api/blocks when no count is given
+const defaultRecentBlocks = 20
+
+// Define the largest number of blocks listed by /api/blocks
+const maxRecentBlocks = 500
+
+// Define the number of transactions of an address listed when no count is given
+const defaultHistoryCount = 50
+
+// Define the largest number of transactions of an address listed at once
+const maxHistoryCount = 1000
+
+// Define a struct for the REST layer
+type restHandler struct {
+  explorer Explorer // the node answering the queries
+}
+
+// Define a function to create the handler of the REST paths, to be mounted on /api/
+func NewREST(explorer Explorer) http.Handler {
+  return &restHandler{explorer}
+}
+
+// Define a method to answer a REST request
+// The paths are /api/blocks?count=N, /api/block/{hash}, /api/tx/{id}, /api/address/{addr}/balance and
+// /api/address/{addr}/txs?skip=N&count=N
+func (h *restHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  if r.Method != http.MethodGet && r.Method != http.MethodHead {
+    writeRESTError(w, http.StatusMethodNotAllowed, errors.New("the API is read-only"))
+    return
+  }
+  parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, restPrefix), "/"), "/")
+  var result interface{}
+  var err error
+  switch {
+  case len(parts) == 1 && parts[0] == "blocks":
+    count, ok := recentCount(r.URL.Query().Get("count"))
+    if !ok {
+      writeRESTError(w, http.StatusBadRequest, errors.New("count must be a number between 1 and "+strconv.Itoa(maxRecentBlocks)))
+      return
+    }
+    result = h.explorer.RecentBlocks(count)
+  case len(parts) == 2 && parts[0] == "block":
+    result, err = h.explorer.Block(parts[1])
+  case len(parts) == 2 && parts[0] == "tx":
+    result, err = h.explorer.Transaction(parts[1])
+  case len(parts) == 3 && parts[0] == "address" && parts[2] == "balance":
+    result, err = h.explorer.Balance(parts[1])
+  case len(parts) == 3 && parts[0] == "address" && parts[2] == "txs":
+    skip, count, ok := historyPage(r.URL.Query().Get("skip"), r.URL.Query().Get("count"))
+    if !ok {
+      writeRESTError(w, http.StatusBadRequest, errors.New("skip must be a positive number and count a number between 1 and "+strconv.Itoa(maxHistoryCount)))
+      return
+    }
+    result, err = h.explorer.History(parts[1], skip, count)
+  default:
+    writeRESTError(w, http.StatusNotFound, errors.New("unknown path "+r.URL.Path))
+    return
+  }
+  if errors.Is(err, ErrNotFound) {
+    writeRESTError(w, http.StatusNotFound, err)
+    return
+  }
+  if err != nil {
+    writeRESTError(w, http.StatusInternalServerError, err)
+    return
+  }
+  w.Header().Set("Content-Type", "application/json")
+  writeJSON(w, result)
+}
+
+// Define a function to read the number of recent blocks asked for, the default if empty
+func recentCount(value string) (int, bool) {
+  if value == "" {
+    return defaultRecentBlocks, true
+  }
+  count, err := strconv.Atoi(value)
+  return count, err == nil && count >= 1 && count <= maxRecentBlocks
+}
+
+// Define a function to read the page of the transactions of an address asked for, the newest ones if empty
+func historyPage(skipValue, countValue string) (int, int, bool) {
+  skip, count := 0, defaultHistoryCount
+  var err error
+  if skipValue != "" {
+    if skip, err = strconv.Atoi(skipValue); err != nil || skip < 0 {
+      return 0, 0, false
+    }
+  }
+  if countValue != "" {
+    if count, err = strconv.Atoi(countValue); err != nil || count < 1 || count > maxHistoryCount {
+      return 0, 0, false
+    }
+  }
+  return skip, count, true
+}
+
+// Define a function to write an error as a JSON body with a status code
+func writeRESTError(w http.ResponseWriter, status int, err error) {
+  w.Header().Set("Content-Type", "application/json")
+  w.WriteHeader(status)
+  json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,1186 @@
+// Package rpc serves JSON-RPC 2.0 over HTTP so tools can query and drive a running node.
+// The package does not know the chain types: the node implements Backend and returns
+// the JSON views defined here.
+package rpc
+
+import (
+  "bytes"               // to tell single requests from batches
+  "crypto/sha256"       // to compare the credentials whatever their length
+  "crypto/subtle"       // to compare the credentials in constant time
+  "encoding/hex"        // the raw transactions are hex encoded
+  "encoding/json"       // the encoding of the requests and responses
+  "errors"              // for the errors of the backend
+  "fmt"                 // to format the parameter errors
+  "io"                  // to read the request body
+  "mime"                // to read the content type of the requests
+  "networkchain/logger" // the log levels are changed through the server
+  "networkchain/wallet" // to build the multisig scripts
+  "net/http"            // the transport of the requests
+  "time"                // for the ban durations
+)
+
+// Define the version string of the protocol
+const jsonrpcVersion = "2.0"
+
+// The largest request body accepted, in bytes
+const maxRequestSize = 4 << 20
+
+// Define the error codes of the protocol
+const (
+  CodeParseError         = -32700 // the body is not valid JSON
+  CodeInvalidRequest     = -32600 // the JSON is not a valid request
+  CodeMethodNotFound     = -32601 // the method does not exist
+  CodeInvalidParams      = -32602 // the parameters are wrong
+  CodeInternalError      = -32603 // the method failed
+  CodeNotFound           = -5     // the block or transaction is unknown
+  CodeRejected           = -26    // the transaction was refused
+  CodeWalletError        = -4     // the wallet failed to build the transaction
+  CodeNoFunds            = -6     // the wallet cannot cover the payment
+  CodeUnlockNeeded       = -13    // the keys of the encrypted wallet are locked
+  CodeWrongPassphrase    = -14    // the passphrase does not unlock the wallet
+  CodeWrongEncryption    = -15    // the wallet is encrypted already, or not encrypted
+  CodeWalletNotFound     = -18    // no wallet of the name is loaded
+  CodeWalletNotSpecified = -19    // several wallets are loaded and the request names none
+  CodeWalletLoaded       = -35    // the wallet is loaded already
+)
+
+// The most blocks generate mines at once
+const maxGenerateCount = 1000
+
+// The confirmations listunspent accepts by default, so every confirmed output is listed
+const (
+  defaultMinConf = 1
+  defaultMaxConf = 9999999
+)
+
+// Create the logger of the servers
+var rpcLog = logger.New(logger.RPC)
+
+// Define the errors a backend returns to pick the error code
+var (
+  ErrNotFound = errors.New("rpc: not found")
+  ErrRejected = errors.New("rpc: rejected")
+)
+
+// Define an interface for the node behind the server
+type Backend interface {
+  BlockCount() int                                                                              // the height of the main chain
+  BestBlockHash() string                                                                        // the hex hash of the last block of the main chain
+  Block(hash string) (*Block, error)                                                            // a block by hex hash, ErrNotFound if unknown
+  SendRawTransaction(raw []byte) (string, error)                                                // check, add and relay a serialized transaction, returning its hex ID
+  RawTransaction(id string) ([]byte, error)                                                     // a serialized transaction of the mempool or the chain by hex ID, ErrNotFound if unknown
+  PeerInfo() []PeerInfo                                                                         // the peers of the node
+  SetBan(address string, ban bool, duration time.Duration) error                                // ban a peer address or host, the default duration if zero, or lift its ban, ErrNotFound if it was not banned
+  ListBanned() []BannedPeer                                                                     // the banned peers
+  Supply() *Supply                                                                              // the emission of the main chain
+  SetGenerate(generate bool, threads int) error                                                 // start the miner with a number of threads, the current number if 0, or stop it
+  MiningInfo() *MiningInfo                                                                      // the state of the miner
+  BlockTemplate(address string) (*BlockTemplate, error)                                         // the next block for an external miner, with a coinbase paying the address if it is not empty
+  SubmitBlock(raw []byte) error                                                                 // check, add and announce a serialized block mined by an external miner, ErrRejected if invalid
+  Generate(count int, address string) ([]string, error)                                         // mine blocks at once on a network mining on demand, paying the address or the miner address if empty, returning their hex hashes
+  SyncInfo() *SyncInfo                                                                          // the progress of the sync of the blocks
+  VerifyChain(level, blocks int) bool                                                           // check the last blocks, all if 0, at a level from 0 to 3, the defaults if negative; false if corrupt
+  SendToAddress(address string, amount, feeRate int) (string, error)                            // pay an address with the coins of the wallet at a fee rate per 1000 bytes, the default if negative, returning the hex ID
+  ListUnspent(minConf, maxConf int, addresses []string) ([]Unspent, error)                      // the unspent outputs of the addresses, of the wallet if none, with confirmations in a range, oldest first
+  CreateRawTransaction(inputs []RawInput, outputs []RawOutput, lockTime int) ([]byte, error)    // an unsigned serialized transaction spending the inputs and paying the outputs
+  SignRawTransaction(raw []byte, keys [][]byte, prevOuts []PrevOut) (*SignedTransaction, error) // sign the inputs of a serialized transaction with private keys, the keys of the wallet if none, spending the given outputs or the ones of the node
+  FundPSBT(address string, amount, feeRate int) (*FundedPSBT, error)                            // fund a payment to an address with the coins of the wallet, the watch-only ones included, without signing it
+  CreatePSBT(raw []byte, scripts [][]byte) (string, error)                                      // wrap a serialized unsigned transaction in a partially signed one, with the multisig scripts of the wallet and the given ones
+  ProcessPSBT(psbt string) (*ProcessedPSBT, error)                                              // add the signatures of the keys of the wallet to a partially signed transaction
+  CombinePSBT(psbts []string) (string, error)                                                   // merge the signatures of copies of a partially signed transaction
+  FinalizePSBT(psbt string) (*FinalizedPSBT, error)                                             // build the signed transaction once every input has enough signatures
+  DecodePSBT(psbt string) (*PSBT, error)                                                        // describe a partially signed transaction and the signatures it still needs
+  WalletBalance(address string) (*WalletBalance, error)                                         // the coins of an address, of the wallet if empty
+  EstimateFee(target int) *FeeEstimate                                                          // the fee rate per 1000 bytes a transaction needs to be mined within a number of blocks, the default if negative
+  AbandonTransaction(id string) error                                                           // stop announcing an unconfirmed wallet transaction by hex ID and drop it from the mempool, ErrNotFound if the wallet does not follow it
+  WalletTransaction(id string) (*WalletTransaction, error)                                      // a transaction of the wallet by hex ID with its status, ErrNotFound if the wallet does not follow it
+  ListWalletTransactions(count, skip int) ([]WalletTransaction, error)                          // a page of the transactions of the wallet, newest first
+  ImportAddress(address string, rescan bool) error                                              // watch an address without its key, searching the chain for its transactions if rescan
+  ImportXPub(xpub string, rescan bool) ([]string, error)                                        // watch the receive addresses of an extended public key, returning the ones added
+  EncryptWallet(passphrase string) error                                                        // encrypt the keys of the wallet with a passphrase, leaving them locked
+  UnlockWallet(passphrase string, timeout int) error                                            // unlock the keys of the encrypted wallet for a number of seconds
+  LockWallet() error                                                                            // lock the keys of the encrypted wallet now
+  NewAddress(kind string) (string, error)                                                       // a new receive address of the wallet with a kind of key, derived from its seed if it has one and the key is ECDSA
+}
+
+// Define a struct for the JSON view of a block
+type Block struct {
+  Hash              string   `json:"hash"`
+  Height            int      `json:"height"`
+  Confirmations     int      `json:"confirmations"` // 0 for the blocks of a side branch
+  PreviousBlockHash string   `json:"previousblockhash,omitempty"`
+  MerkleRoot        string   `json:"merkleroot"`
+  Time              int64    `json:"time"`
+  Bits              string   `json:"bits"`
+  Nonce             int      `json:"nonce"`
+  Transactions      []string `json:"tx"` // the hex IDs of the transactions
+  Pruned            bool     `json:"pruned,omitempty"` // the transactions were discarded by a pruned node
+}
+
+// Define a struct for the JSON view of the emission of the chain
+type Supply struct {
+  Height          int `json:"height"`
+  Subsidy         int `json:"subsidy"`                   // the coins created by the next block
+  Supply          int `json:"supply"`                    // the coins created by the blocks up to the tip
+  HalvingInterval int `json:"halvinginterval,omitempty"` // 0 if the subsidy never halves
+  NextHalving     int `json:"nexthalving,omitempty"`     // the height of the next block paying half, 0 if there is none
+}
+
+// Define a struct for the JSON view of the miner
+type MiningInfo struct {
+  Blocks       int     `json:"blocks"`
+  PooledTx     int     `json:"pooledtx"`     // the transactions waiting in the mempool
+  Generate     bool    `json:"generate"`     // whether the miner is running
+  Threads      int     `json:"threads"`      // the number of goroutines searching the nonces
+  HashesPerSec float64 `json:"hashespersec"` // since the miner started
+  Mined        int     `json:"mined"`        // the blocks mined since the miner started
+}
+
+// Define a struct for the JSON view of the next block an external miner may build
+// The miner adds a coinbase paying at most coinbasevalue in front of the transactions, or uses coinbasetxn if it asked
+// for one, and searches a nonce so the hash of the header is below the target
+type BlockTemplate struct {
+  PreviousBlockHash string                `json:"previousblockhash"`
+  Height            int                   `json:"height"`
+  Bits              string                `json:"bits"`
+  Target            string                `json:"target"`                // the hex target the hash must be below
+  CurTime           int64                 `json:"curtime"`               // the current time of the node
+  MinTime           int64                 `json:"mintime"`               // the earliest time of the block, the median time past of its parent
+  CoinbaseValue     int                   `json:"coinbasevalue"`         // the subsidy and the fees the coinbase may claim
+  Transactions      []TemplateTransaction `json:"transactions"`          // the mempool transactions, best feerate first, parents before children
+  CoinbaseTxn       *TemplateTransaction  `json:"coinbasetxn,omitempty"` // the coinbase paying the address given
+  MerkleRoot        string                `json:"merkleroot,omitempty"`  // the merkle root with that coinbase
+}
+
+// Define a struct for the JSON view of a transaction of a block template
+type TemplateTransaction struct {
+  Data string `json:"data"` // the hex serialized transaction
+  Txid string `json:"txid"`
+  Fee  int    `json:"fee"`
+}
+
+// Define a struct for the JSON view of the progress of the sync
+// The node is synced once it downloaded every block it knows of and its chain is as long as the ones its peers announced
+type SyncInfo struct {
+  Headers              int     `json:"headers"`                 // the height of the best chain the peers announced
+  Blocks               int     `json:"blocks"`                  // the height of the main chain
+  VerificationProgress float64 `json:"verificationprogress"`    // the share of the best chain verified, 1 once caught up
+  TimeRemaining        float64 `json:"timeremaining,omitempty"` // the estimated seconds left, absent while unknown
+  SyncPeer             string  `json:"syncpeer,omitempty"`      // the peer the most blocks are downloaded from
+  Synced               bool    `json:"synced"`                  // whether the node caught up with its peers
+}
+
+// Define a struct for the JSON view of a peer
+type PeerInfo struct {
+  Address    string  `json:"addr"`
+  Version    int     `json:"version,omitempty"`    // 0 until the handshake is done
+  Services   string  `json:"services,omitempty"`   // the services of the peer as 16 hex digits
+  SubVer     string  `json:"subver,omitempty"`     // the software of the peer
+  PingTime   float64 `json:"pingtime,omitempty"`   // the last round trip time in seconds
+  Inbound    bool    `json:"inbound"`              // whether the peer dialed the node
+  ConnTime   int64   `json:"conntime,omitempty"`   // when the peer connected, in Unix time, 0 for a manual address that is not connected
+  TimeOffset *int64  `json:"timeoffset,omitempty"` // the seconds the clock of the peer is ahead of the clock of the node, absent if it did not tell its time
+  Banned     bool    `json:"banned,omitempty"`
+  ID         string  `json:"id,omitempty"`         // the node ID the peer proved in the Noise handshake
+}
+
+// Define a struct for the JSON view of a banned peer
+type BannedPeer struct {
+  Address     string `json:"address"`
+  BannedUntil int64  `json:"banned_until"` // the end of the ban, in Unix time
+  Reason      string `json:"reason,omitempty"`
+}
+
+// Define a struct for the JSON view of a multisig address
+type Multisig struct {
+  Address string `json:"address"`
+  Script  string `json:"redeemscript"` // the hex script the spending inputs reveal
+}
+
+// Define a struct for the JSON view of an unspent output
+type Unspent struct {
+  TxID          string `json:"txid"`
+  Vout          int    `json:"vout"`
+  Address       string `json:"address"`
+  Amount        int    `json:"amount"`
+  Confirmations int    `json:"confirmations"`       // 0 for a mempool transaction
+  Coinbase      bool   `json:"coinbase,omitempty"`
+  Spendable     bool   `json:"spendable"`           // false for a coinbase still maturing or a watch-only address
+  WatchOnly     bool   `json:"watchonly,omitempty"` // the wallet follows the address without its key
+}
+
+// Define a struct for the JSON view of the balance of a wallet, split by whether the coins can be spent
+// The coins of the watch-only addresses are never counted in the spendable ones, they are split the same way apart
+type WalletBalance struct {
+  Confirmed   int            `json:"confirmed"`           // the coins of the chain the next block can spend
+  Unconfirmed int            `json:"unconfirmed"`         // the coins of the mempool transactions
+  Immature    int            `json:"immature"`            // the coins of the coinbases still maturing
+  WatchOnly   *WalletBalance `json:"watchonly,omitempty"` // the coins of the watch-only addresses, nil if none is involved
+}
+
+// Define a struct for the JSON view of a transaction of the wallet
+type WalletTransaction struct {
+  Txid              string `json:"txid"`
+  Status            string `json:"status"`                      // pending, confirmed or conflicted
+  Confirmations     int    `json:"confirmations"`               // 0 while pending, minus the confirmations of the double spend when conflicted
+  BlockHash         string `json:"blockhash,omitempty"`
+  Height            int    `json:"height,omitempty"`
+  ConflictBlock     string `json:"conflictblock,omitempty"`     // the block holding the double spend of a conflicted transaction
+  Time              int64  `json:"time"`                        // when the node accepted it or found it in a block
+  Received          int    `json:"received"`                    // the value of the outputs paying to the wallet
+  Sent              int    `json:"sent"`                        // the value of the outputs of the wallet spent by the inputs
+  InvolvesWatchOnly bool   `json:"involveswatchonly,omitempty"` // a watch-only address is paid or spent
+}
+
+// Define a struct for the JSON view of a fee estimate
+type FeeEstimate struct {
+  FeeRate int      `json:"feerate,omitempty"` // the coins per 1000 bytes, left out without an estimate
+  Blocks  int      `json:"blocks"`            // the confirmation target estimated
+  Errors  []string `json:"errors,omitempty"`  // why there is no estimate
+}
+
+// Define a struct for an input of createrawtransaction
+type RawInput struct {
+  TxID string `json:"txid"`
+  Vout int    `json:"vout"`
+}
+
+// Define a struct for an output of createrawtransaction
+type RawOutput struct {
+  Address string `json:"address"`
+  Amount  int    `json:"amount"`
+}
+
+// Define a struct for an output spent by a transaction to sign, given when the node does not know it
+type PrevOut struct {
+  TxID         string `json:"txid"`
+  Vout         int    `json:"vout"`
+  ScriptPubKey string `json:"scriptpubkey"` // the locking script in hex
+  Amount       int    `json:"amount"`
+}
+
+// Define a struct for the JSON view of a signed transaction
+type SignedTransaction struct {
+  Hex      string   `json:"hex"`
+  Complete bool     `json:"complete"`         // whether every input is signed
+  Errors   []string `json:"errors,omitempty"` // why the other inputs are not
+}
+
+// Define a struct for the JSON view of a partially signed transaction
+type PSBT struct {
+  TxID     string      `json:"txid"`     // the ID of the transaction without signatures
+  Inputs   []PSBTInput `json:"inputs"`
+  Outputs  []Output    `json:"outputs"`
+  Fee      int         `json:"fee"`
+  Complete bool        `json:"complete"` // whether every input has enough signatures
+}
+
+// Define a struct for the JSON view of an input of a partially signed transaction
+type PSBTInput struct {
+  TxID       string `json:"txid"`
+  Vout       int    `json:"vout"`
+  Address    string `json:"address"`         // the owner of the spent output
+  Amount     int    `json:"amount"`
+  Signatures int    `json:"signatures"`      // the valid signatures gathered
+  Required   int    `json:"required"`        // the signatures needed, 0 while the multisig script is unknown
+  Nonces     int    `json:"nonces,omitempty"` // the nonces shared by the signers of an aggregate key
+  Error      string `json:"error,omitempty"` // why the input cannot be signed
+}
+
+// Define a struct for the JSON view of a payment funded by the wallet and left unsigned
+type FundedPSBT struct {
+  PSBT string `json:"psbt"`
+  Fee  int    `json:"fee"`
+}
+
+// Define a struct for the JSON view of a partially signed transaction a wallet signed
+type ProcessedPSBT struct {
+  PSBT     string `json:"psbt"`
+  Complete bool   `json:"complete"`
+}
+
+// Define a struct for the JSON view of a finalized partially signed transaction, the signed transaction once complete
+type FinalizedPSBT struct {
+  PSBT     string `json:"psbt,omitempty"`  // the partially signed transaction while it is not complete
+  Hex      string `json:"hex,omitempty"`   // the signed transaction to send once it is
+  Complete bool   `json:"complete"`
+  Error    string `json:"error,omitempty"` // what the transaction still needs
+}
+
+// Define a struct for a request
+type request struct {
+  JSONRPC string          `json:"jsonrpc"`
+  Method  string          `json:"method"`
+  Params  json.RawMessage `json:"params"`
+  ID      json.RawMessage `json:"id"` // missing for notifications
+}
+
+// Define a struct for an error of a response
+type Error struct {
+  Code    int    `json:"code"`
+  Message string `json:"message"`
+}
+
+// Define a method to describe the error
+func (e *Error) Error() string {
+  return e.Message
+}
+
+// Define a struct for a response
+type response struct {
+  JSONRPC string          `json:"jsonrpc"`
+  Result  interface{}     `json:"result,omitempty"`
+  Error   *Error          `json:"error,omitempty"`
+  ID      json.RawMessage `json:"id"`
+}
+
+// Define a type for the function serving a method, it receives the raw positional parameters
+type handler func(s *Server, params []json.RawMessage) (interface{}, error)
+
+// Define the methods of the server
+var methods = map[string]handler{
+  "getblockcount":          getBlockCount,
+  "getbestblockhash":       getBestBlockHash,
+  "getblock":               getBlock,
+  "sendrawtransaction":     sendRawTransaction,
+  "getrawtransaction":      getRawTransaction,
+  "getpeerinfo":            getPeerInfo,
+  "setban":                 setBan,
+  "listbanned":             listBanned,
+  "getsupply":              getSupply,
+  "createmultisig":         createMultisig,
+  "getloglevels":           getLogLevels,
+  "setloglevel":            setLogLevel,
+  "setgenerate":            setGenerate,
+  "getmininginfo":          getMiningInfo,
+  "getblocktemplate":       getBlockTemplate,
+  "submitblock":            submitBlock,
+  "generate":               generate,
+  "getaddresshistory":      getAddressHistory,
+  "getsyncinfo":            getSyncInfo,
+  "verifychain":            verifyChain,
+  "sendtoaddress":          sendToAddress,
+  "listunspent":            listUnspent,
+  "getbalance":             getBalance,
+  "createrawtransaction":   createRawTransaction,
+  "signrawtransaction":     signRawTransaction,
+  "walletcreatefundedpsbt": walletCreateFundedPSBT,
+  "createpsbt":             createPSBT,
+  "walletprocesspsbt":      walletProcessPSBT,
+  "combinepsbt":            combinePSBT,
+  "finalizepsbt":           finalizePSBT,
+  "decodepsbt":             decodePSBT,
+  "estimatefee":            estimateFee,
+  "abandontransaction":     abandonTransaction,
+  "gettransaction":         getTransaction,
+  "listtransactions":       listTransactions,
+  "importaddress":          importAddress,
+  "importxpub":             importXPub,
+  "encryptwallet":          encryptWallet,
+  "walletpassphrase":       walletPassphrase,
+  "walletlock":             walletLock,
+  "getnewaddress":          getNewAddress,
+  "createwallet":           createWallet,
+  "loadwallet":             loadWallet,
+  "unloadwallet":           unloadWallet,
+  "listwallets":            listWallets,
+}
+
+// Define the content type of the JSON-RPC requests, a web page cannot send it to another site without its consent
+const contentType = "application/json"
+
+// Define a struct for the server
+type Server struct {
+  backend    Backend  // the node answering the methods
+  walletName string   // the wallet the URL of the request names, empty for /
+  auth       [32]byte // the SHA-256 of the user:password pair the requests must carry with HTTP basic authentication
+}
+
+// Define a function to create a server for a backend, answering the requests carrying a user and a password
+func NewServer(backend Backend, user, password string) *Server {
+  return &Server{backend: backend, auth: sha256.Sum256([]byte(user + ":" + password))}
+}
+
+// Define a method to check a request carries the user and the password of the server
+func (s *Server) authorized(r *http.Request) bool {
+  user, password, ok := r.BasicAuth()
+  if !ok {
+    return false
+  }
+  auth := sha256.Sum256([]byte(user + ":" + password)) // hashed so the comparison does not tell the length either
+  return subtle.ConstantTimeCompare(auth[:], s.auth[:]) == 1
+}
+
+// Define a method to wrap a handler so it only answers the requests carrying the user and the password of the server
+func (s *Server) authenticate(next http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if !s.authorized(r) {
+      unauthorized(w)
+      return
+    }
+    next.ServeHTTP(w, r)
+  })
+}
+
+// Define a function to answer a request without the user and the password of the server
+func unauthorized(w http.ResponseWriter) {
+  w.Header().Set("WWW-Authenticate", `Basic realm="networkchain"`)
+  http.Error(w, "the request needs the user and the password of the node", http.StatusUnauthorized)
+}
+
+// Define a method to serve the JSON-RPC requests on an address until it fails
+// If the backend also answers the read-only queries, the REST layer is served under /api/ and the
+// HTML explorer under /explorer/, and if it produces events, the WebSocket subscriptions are served on /ws;
+// every one of them needs the user and the password of the server
+func (s *Server) ListenAndServe(address string) error {
+  mux := http.NewServeMux()
+  mux.Handle("/", s)
+  if explorer, ok := s.backend.(Explorer); ok {
+    mux.Handle(restPrefix, s.authenticate(NewREST(explorer)))
+    mux.Handle(explorerPrefix, s.authenticate(NewHTMLExplorer(explorer)))
+  }
+  if notifier, ok := s.backend.(Notifier); ok {
+    mux.Handle(wsPath, s.authenticate(NewWebSocket(notifier)))
+  }
+  return http.ListenAndServe(address, mux)
+}
+
+// Define a method to answer an HTTP request carrying a JSON-RPC request or a batch of them
+// The request must carry the user and the password of the server and the JSON content type, so a web page the operator
+// visits cannot send it a form or a text/plain request
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  if !s.authorized(r) {
+    unauthorized(w)
+    return
+  }
+  if r.Method != http.MethodPost {
+    http.Error(w, "JSON-RPC requests must be POSTed", http.StatusMethodNotAllowed)
+    return
+  }
+  if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != contentType {
+    http.Error(w, "JSON-RPC requests must have the "+contentType+" content type", http.StatusUnsupportedMediaType)
+    return
+  }
+  s, ok := s.route(w, r)
+  if !ok {
+    return
+  }
+  body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  w.Header().Set("Content-Type", contentType)
+  body = bytes.TrimSpace(body)
+  if len(body) > 0 && body[0] == '[' { // a batch
+    var batch []json.RawMessage
+    if err := json.Unmarshal(body, &batch); err != nil || len(batch) == 0 {
+      writeJSON(w, errorResponse(nil, CodeParseError, "invalid batch"))
+      return
+    }
+    var responses []*response
+    for _, raw := range batch {
+      if resp := s.handle(raw); resp != nil { // notifications get no response
+        responses = append(responses, resp)
+      }
+    }
+    if len(responses) == 0 {
+      w.WriteHeader(http.StatusNoContent)
+      return
+    }
+    writeJSON(w, responses)
+    return
+  }
+  resp := s.handle(body)
+  if resp == nil {
+    w.WriteHeader(http.StatusNoContent)
+    return
+  }
+  writeJSON(w, resp)
+}
+
+// Define a method to run a single request, returning nil for a notification
+func (s *Server) handle(raw json.RawMessage) *response {
+  var req request
+  if err := json.Unmarshal(raw, &req); err != nil {
+    return errorResponse(nil, CodeParseError, err.Error())
+  }
+  if req.JSONRPC != jsonrpcVersion || req.Method == "" {
+    return errorResponse(req.ID, CodeInvalidRequest, "invalid request")
+  }
+  method, ok := methods[req.Method]
+  if !ok {
+    return s.reply(req.ID, nil, &Error{CodeMethodNotFound, "method not found: " + req.Method})
+  }
+  var params []json.RawMessage // the methods take positional parameters
+  if len(req.Params) > 0 && string(req.Params) != "null" {
+    if err := json.Unmarshal(req.Params, &params); err != nil {
+      return s.reply(req.ID, nil, &Error{CodeInvalidParams, "params must be an array"})
+    }
+  }
+  result, err := method(s, params)
+  if err != nil {
+    rpcLog.Debug("Request failed", "method", req.Method, "err", err)
+  } else {
+    rpcLog.Debug("Request", "method", req.Method)
+  }
+  return s.reply(req.ID, result, err)
+}
+
+// Define a method to build the response of a request, nil for a notification
+func (s *Server) reply(id json.RawMessage, result interface{}, err error) *response {
+  if id == nil { // notifications get no response, even on errors
+    return nil
+  }
+  if err == nil {
+    return &response{JSONRPC: jsonrpcVersion, Result: result, ID: id}
+  }
+  var rpcErr *Error
+  switch {
+  case errors.As(err, &rpcErr): // the method picked the code
+  case errors.Is(err, ErrNotFound):
+    rpcErr = &Error{CodeNotFound, err.Error()}
+  case errors.Is(err, ErrRejected):
+    rpcErr = &Error{CodeRejected, err.Error()}
+  default:
+    rpcErr = &Error{CodeInternalError, err.Error()}
+  }
+  return &response{JSONRPC: jsonrpcVersion, Error: rpcErr, ID: id}
+}
+
+// Define a function to build an error response
+func errorResponse(id json.RawMessage, code int, message string) *response {
+  if id == nil {
+    id = json.RawMessage("null") // the id could not be read
+  }
+  return &response{JSONRPC: jsonrpcVersion, Error: &Error{code, message}, ID: id}
+}
+
+// Define a function to write a JSON value as the body of a response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+  json.NewEncoder(w).Encode(v) // the client is gone if this fails, nothing to do
+}
+
+// Define a function to read the string parameter at an index
+func stringParam(params []json.RawMessage, index int, name string) (string, error) {
+  if index >= len(params) {
+    return "", &Error{CodeInvalidParams, "missing parameter " + name}
+  }
+  var value string
+  if err := json.Unmarshal(params[index], &value); err != nil {
+    return "", &Error{CodeInvalidParams, name + " must be a string"}
+  }
+  return value, nil
+}
+
+// Define a function to answer getblockcount
+func getBlockCount(s *Server, params []json.RawMessage) (interface{}, error) {
+  return s.backend.BlockCount(), nil
+}
+
+// Define a function to answer getbestblockhash
+func getBestBlockHash(s *Server, params []json.RawMessage) (interface{}, error) {
+  return s.backend.BestBlockHash(), nil
+}
+
+// Define a function to answer getblock with a block hash
+func getBlock(s *Server, params []json.RawMessage) (interface{}, error) {
+  hash, err := stringParam(params, 0, "blockhash")
+  if err != nil {
+    return nil, err
+  }
+  return s.backend.Block(hash)
+}
+
+// Define a function to answer sendrawtransaction with a hex serialized transaction
+func sendRawTransaction(s *Server, params []json.RawMessage) (interface{}, error) {
+  rawHex, err := stringParam(params, 0, "hexstring")
+  if err != nil {
+    return nil, err
+  }
+  raw, err := hex.DecodeString(rawHex)
+  if err != nil {
+    return nil, &Error{CodeInvalidParams, "hexstring is not hex"}
+  }
+  return s.backend.SendRawTransaction(raw)
+}
+
+// Define a function to answer createrawtransaction with a list of outputs to spend, a list of addresses and amounts to
+// pay and an optional lock time, returning the hex serialized transaction with no signatures
+func createRawTransaction(s *Server, params []json.RawMessage) (interface{}, error) {
+  if len(params) < 2 {
+    return nil, &Error{CodeInvalidParams, "missing parameters inputs and outputs"}
+  }
+  var inputs []RawInput
+  if err := json.Unmarshal(params[0], &inputs); err != nil {
+    return nil, &Error{CodeInvalidParams, "inputs must be a list of {txid, vout}"}
+  }
+  var outputs []RawOutput
+  if err := json.Unmarshal(params[1], &outputs); err != nil {
+    return nil, &Error{CodeInvalidParams, "outputs must be a list of {address, amount}"}
+  }
+  var lockTime int
+  if len(params) > 2 {
+    if err := json.Unmarshal(params[2], &lockTime); err != nil || lockTime < 0 {
+      return nil, &Error{CodeInvalidParams, "locktime must be a positive number"}
+    }
+  }
+  raw, err := s.backend.CreateRawTransaction(inputs, outputs, lockTime)
+  if err != nil {
+    return nil, err
+  }
+  return hex.EncodeToString(raw), nil
+}
+
+// Define a function to answer signrawtransaction with a hex serialized transaction, an optional list of hex private keys,
+// the keys of the wallet of the node if missing or empty, and an optional list of the outputs it spends the node does
+// not know
+func signRawTransaction(s *Server, params []json.RawMessage) (interface{}, error) {
+  rawHex, err := stringParam(params, 0, "hexstring")
+  if err != nil {
+    return nil, err
+  }
+  raw, err := hex.DecodeString(rawHex)
+  if err != nil {
+    return nil, &Error{CodeInvalidParams, "hexstring is not hex"}
+  }
+  var keys [][]byte
+  if len(params) > 1 {
+    var keysHex []string
+    if err := json.Unmarshal(params[1], &keysHex); err != nil {
+      return nil, &Error{CodeInvalidParams, "privkeys must be a list of hex private keys"}
+    }
+    for _, keyHex := range keysHex {
+      key, err := hex.DecodeString(keyHex)
+      if err != nil {
+        return nil, &Error{CodeInvalidParams, "privkeys must be a list of hex private keys"}
+      }
+      keys = append(keys, key)
+    }
+  }
+  var prevOuts []PrevOut
+  if len(params) > 2 {
+    if err := json.Unmarshal(params[2], &prevOuts); err != nil {
+      return nil, &Error{CodeInvalidParams, "prevtxs must be a list of {txid, vout, scriptpubkey, amount}"}
+    }
+  }
+  return s.backend.SignRawTransaction(raw, keys, prevOuts)
+}
+
+// Define a function to answer walletcreatefundedpsbt with an address, an amount and an optional fee rate in coins per
+// 1000 bytes, like sendtoaddress
+func walletCreateFundedPSBT(s *Server, params []json.RawMessage) (interface{}, error) {
+  address, err := stringParam(params, 0, "address")
+  if err != nil {
+    return nil, err
+  }
+  if len(params) < 2 {
+    return nil, &Error{CodeInvalidParams, "missing parameter amount"}
+  }
+  var amount int
+  if err := json.Unmarshal(params[1], &amount); err != nil || amount <= 0 {
+    return nil, &Error{CodeInvalidParams, "amount must be a positive number"}
+  }
+  feeRate := -1 // the node picks the default
+  if len(params) > 2 {
+    if err := json.Unmarshal(params[2], &feeRate); err != nil || feeRate < 0 {
+      return nil, &Error{CodeInvalidParams, "feerate must be a positive number"}
+    }
+  }
+  return s.backend.FundPSBT(address, amount, feeRate)
+}
+
+// Define a function to answer createpsbt with a hex serialized unsigned transaction and an optional list of hex
+// multisig scripts the outputs it spends pay to, returning the partially signed transaction in base64
+func createPSBT(s *Server, params []json.RawMessage) (interface{}, error) {
+  rawHex, err := stringParam(params, 0, "hexstring")
+  if err != nil {
+    return nil, err
+  }
+  raw, err := hex.DecodeString(rawHex)
+  if err != nil {
+    return nil, &Error{CodeInvalidParams, "hexstring is not hex"}
+  }
+  var scripts [][]byte
+  if len(params) > 1 {
+    var scriptsHex []string
+    if err := json.Unmarshal(params[1], &scriptsHex); err != nil {
+      return nil, &Error{CodeInvalidParams, "redeemscripts must be a list of hex scripts"}
+    }
+    for _, scriptHex := range scriptsHex {
+      redeem, err := hex.DecodeString(scriptHex)
+      if err != nil {
+        return nil, &Error{CodeInvalidParams, "redeemscripts must be a list of hex scripts"}
+      }
+      scripts = append(scripts, redeem)
+    }
+  }
+  return s.backend.CreatePSBT(raw, scripts)
+}
+
+// Define a function to answer walletprocesspsbt with a partially signed transaction in base64
+func walletProcessPSBT(s *Server, params []json.RawMessage) (interface{}, error) {
+  psbt, err := stringParam(params, 0, "psbt")
+  if err != nil {
+    return nil, err
+  }
+  return s.backend.ProcessPSBT(psbt)
+}
+
+// Define a function to answer combinepsbt with a list of copies of a partially signed transaction in base64
+func combinePSBT(s *Server, params []json.RawMessage) (interface{}, error) {
+  if len(params) < 1 {
+    return nil, &Error{CodeInvalidParams, "missing parameter psbts"}
+  }
+  var psbts []string
+  if err := json.Unmarshal(params[0], &psbts); err != nil || len(psbts) == 0 {
+    return nil, &Error{CodeInvalidParams, "psbts must be a list of base64 partially signed transactions"}
+  }
+  return s.backend.CombinePSBT(psbts)
+}
+
+// Define a function to answer finalizepsbt with a partially signed transaction in base64
+func finalizePSBT(s *Server, params []json.RawMessage) (interface{}, error) {
+  psbt, err := stringParam(params, 0, "psbt")
+  if err != nil {
+    return nil, err
+  }
+  return s.backend.FinalizePSBT(psbt)
+}
+
+// Define a function to answer decodepsbt with a partially signed transaction in base64
+func decodePSBT(s *Server, params []json.RawMessage) (interface{}, error) {
+  psbt, err := stringParam(params, 0, "psbt")
+  if err != nil {
+    return nil, err
+  }
+  return s.backend.DecodePSBT(psbt)
+}
+
+// Define a function to answer getrawtransaction with a hex transaction ID and an optional verbose flag: the hex
+// serialized transaction, or its JSON view with the block holding it if verbose
+func getRawTransaction(s *Server, params []json.RawMessage) (interface{}, error) {
+  id, err := stringParam(params, 0, "txid")
+  if err != nil {
+    return nil, err
+  }
+  verbose := false
+  if len(params) > 1 {
+    if err := json.Unmarshal(params[1], &verbose); err != nil {
+      return nil, &Error{CodeInvalidParams, "verbose must be true or false"}
+    }
+  }
+  if explorer, ok := s.backend.(Explorer); ok && verbose {
+    return explorer.Transaction(id)
+  }
+  raw, err := s.backend.RawTransaction(id)
+  if err != nil {
+    return nil, err
+  }
+  return hex.EncodeToString(raw), nil
+}
+
+// Define a function to answer getpeerinfo
+func getPeerInfo(s *Server, params []json.RawMessage) (interface{}, error) {
+  peers := s.backend.PeerInfo()
+  if peers == nil {
+    peers = []PeerInfo{} // an empty list rather than null
+  }
+  return peers, nil
+}
+
+// Define a function to answer setban with an address or host, "add" or "remove", and an optional ban time in seconds
+func setBan(s *Server, params []json.RawMessage) (interface{}, error) {
+  address, err := stringParam(params, 0, "address")
+  if err != nil {
+    return nil, err
+  }
+  command, err := stringParam(params, 1, "command")
+  if err != nil {
+    return nil, err
+  }
+  var seconds int64 // 0 for the default duration of the node
+  if len(params) > 2 {
+    if err := json.Unmarshal(params[2], &seconds); err != nil || seconds < 0 {
+      return nil, &Error{CodeInvalidParams, "bantime must be a positive number of seconds"}
+    }
+  }
+  switch command {
+  case "add":
+    return nil, s.backend.SetBan(address, true, time.Duration(seconds)*time.Second)
+  case "remove":
+    return nil, s.backend.SetBan(address, false, 0)
+  default:
+    return nil, &Error{CodeInvalidParams, "command must be add or remove"}
+  }
+}
+
+// Define a function to answer listbanned
+func listBanned(s *Server, params []json.RawMessage) (interface{}, error) {
+  banned := s.backend.ListBanned()
+  if banned == nil {
+    banned = []BannedPeer{} // an empty list rather than null
+  }
+  return banned, nil
+}
+
+// Define a function to answer getsupply with the current subsidy and the coins created so far
+func getSupply(s *Server, params []json.RawMessage) (interface{}, error) {
+  return s.backend.Supply(), nil
+}
+
+// Define a function to answer createmultisig with the number of signatures needed and the hex public keys
+func createMultisig(s *Server, params []json.RawMessage) (interface{}, error) {
+  if len(params) < 2 {
+    return nil, &Error{CodeInvalidParams, "expected nrequired and keys"}
+  }
+  var required int
+  if err := json.Unmarshal(params[0], &required); err != nil {
+    return nil, &Error{CodeInvalidParams, "nrequired must be a number"}
+  }
+  var keys []string
+  if err := json.Unmarshal(params[1], &keys); err != nil {
+    return nil, &Error{CodeInvalidParams, "keys must be an array of hex public keys"}
+  }
+  var pubKeys [][]byte
+  for _, key := range keys {
+    pubKey, err := hex.DecodeString(key)
+    if err != nil {
+      return nil, &Error{CodeInvalidParams, "the key " + key + " is not hex"}
+    }
+    pubKeys = append(pubKeys, pubKey)
+  }
+  script, err := wallet.NewMultisigScript(required, pubKeys)
+  if err != nil {
+    return nil, &Error{CodeInvalidParams, err.Error()}
+  }
+  return &Multisig{script.Address(), hex.EncodeToString(script.Serialize())}, nil
+}
+
+// Define a function to answer getloglevels with the level of each subsystem
+func getLogLevels(s *Server, params []json.RawMessage) (interface{}, error) {
+  levels := map[string]string{}
+  for subsystem, level := range logger.Levels() {
+    levels[subsystem] = level.String()
+  }
+  return levels, nil
+}
+
+// Define a function to answer setloglevel with a level specification like "info,NET=debug", returning the new levels
+func setLogLevel(s *Server, params []json.RawMessage) (interface{}, error) {
+  spec, err := stringParam(params, 0, "levels")
+  if err != nil {
+    return nil, err
+  }
+  if err := logger.Apply(spec); err != nil {
+    return nil, &Error{CodeInvalidParams, err.Error()}
+  }
+  rpcLog.Info("Changed the log levels", "levels", spec)
+  return getLogLevels(s, nil)
+}
+
+// Define a function to answer setgenerate with true or false and an optional number of threads, returning the state of the miner
+func setGenerate(s *Server, params []json.RawMessage) (interface{}, error) {
+  if len(params) < 1 {
+    return nil, &Error{CodeInvalidParams, "missing parameter generate"}
+  }
+  var generate bool
+  if err := json.Unmarshal(params[0], &generate); err != nil {
+    return nil, &Error{CodeInvalidParams, "generate must be true or false"}
+  }
+  var threads int // 0 keeps the current number
+  if len(params) > 1 {
+    if err := json.Unmarshal(params[1], &threads); err != nil || threads < 0 {
+      return nil, &Error{CodeInvalidParams, "threads must be a positive number"}
+    }
+  }
+  if err := s.backend.SetGenerate(generate, threads); err != nil {
+    return nil, err
+  }
+  return s.backend.MiningInfo(), nil
+}
+
+// Define a function to answer getsyncinfo
+func getSyncInfo(s *Server, params []json.RawMessage) (interface{}, error) {
+  return s.backend.SyncInfo(), nil
+}
+
+// Define a function to answer getmininginfo
+func getMiningInfo(s *Server, params []json.RawMessage) (interface{}, error) {
+  return s.backend.MiningInfo(), nil
+}
+
+// Define a function to answer getblocktemplate with an optional address the coinbase of the template pays
+func getBlockTemplate(s *Server, params []json.RawMessage) (interface{}, error) {
+  var address string // without one, the miner builds its own coinbase
+  if len(params) > 0 {
+    var err error
+    if address, err = stringParam(params, 0, "address"); err != nil {
+      return nil, err
+    }
+  }
+  return s.backend.BlockTemplate(address)
+}
+
+// Define a function to answer submitblock with a hex serialized block, null once it is accepted
+func submitBlock(s *Server, params []json.RawMessage) (interface{}, error) {
+  rawHex, err := stringParam(params, 0, "hexdata")
+  if err != nil {
+    return nil, err
+  }
+  raw, err := hex.DecodeString(rawHex)
+  if err != nil {
+    return nil, &Error{CodeInvalidParams, "hexdata is not hex"}
+  }
+  return nil, s.backend.SubmitBlock(raw)
+}
+
+// Define a function to answer generate with a number of blocks and an optional address the coinbases pay, returning the hashes
+// of the blocks
+func generate(s *Server, params []json.RawMessage) (interface{}, error) {
+  if len(params) < 1 {
+    return nil, &Error{CodeInvalidParams, "missing parameter nblocks"}
+  }
+  var count int
+  if err := json.Unmarshal(params[0], &count); err != nil || count < 1 || count > maxGenerateCount {
+    return nil, &Error{CodeInvalidParams, fmt.Sprintf("nblocks must be a number between 1 and %d", maxGenerateCount)}
+  }
+  var address string // without one, the blocks pay the miner address of the node
+  if len(params) > 1 {
+    var err error
+    if address, err = stringParam(params, 1, "address"); err != nil {
+      return nil, err
+    }
+  }
+  return s.backend.Generate(count, address)
+}
+
+// Define a function to answer sendtoaddress with an address, an amount and an optional fee rate in coins per 1000 bytes,
+// returning the hex ID of the payment
+func sendToAddress(s *Server, params []json.RawMessage) (interface{}, error) {
+  address, err := stringParam(params, 0, "address")
+  if err != nil {
+    return nil, err
+  }
+  if len(params) < 2 {
+    return nil, &Error{CodeInvalidParams, "missing parameter amount"}
+  }
+  var amount int
+  if err := json.Unmarshal(params[1], &amount); err != nil || amount <= 0 {
+    return nil, &Error{CodeInvalidParams, "amount must be a positive number"}
+  }
+  feeRate := -1 // the node picks the default
+  if len(params) > 2 {
+    if err := json.Unmarshal(params[2], &feeRate); err != nil || feeRate < 0 {
+      return nil, &Error{CodeInvalidParams, "feerate must be a positive number"}
+    }
+  }
+  return s.backend.SendToAddress(address, amount, feeRate)
+}
+
+// Define a function to answer listunspent with an optional minimum and maximum number of confirmations and an optional
+// list of addresses, the wallet of the node by default
+func listUnspent(s *Server, params []json.RawMessage) (interface{}, error) {
+  minConf, maxConf := defaultMinConf, defaultMaxConf
+  if len(params) > 0 {
+    if err := json.Unmarshal(params[0], &minConf); err != nil || minConf < 0 {
+      return nil, &Error{CodeInvalidParams, "minconf must be a positive number"}
+    }
+  }
+  if len(params) > 1 {
+    if err := json.Unmarshal(params[1], &maxConf); err != nil || maxConf < minConf {
+      return nil, &Error{CodeInvalidParams, "maxconf must be a number not below minconf"}
+    }
+  }
+  var addresses []string // the whole wallet if empty
+  if len(params) > 2 {
+    if err := json.Unmarshal(params[2], &addresses); err != nil {
+      return nil, &Error{CodeInvalidParams, "addresses must be a list of addresses"}
+    }
+  }
+  return s.backend.ListUnspent(minConf, maxConf, addresses)
+}
+
+// Define a function to answer getbalance with an optional address, the wallet of the node by default
+func getBalance(s *Server, params []json.RawMessage) (interface{}, error) {
+  var address string // the whole wallet if empty
+  if len(params) > 0 {
+    var err error
+    if address, err = stringParam(params, 0, "address"); err != nil {
+      return nil, err
+    }
+  }
+  return s.backend.WalletBalance(address)
+}
+
+// Define a function to answer verifychain with an optional level of thoroughness from 0 to 3 and an optional number of
+// blocks checked from the tip, 0 for the whole chain, returning whether the chain is valid
+func verifyChain(s *Server, params []json.RawMessage) (interface{}, error) {
+  level, blocks := -1, -1 // the node picks the defaults
+  if len(params) > 0 {
+    if err := json.Unmarshal(params[0], &level); err != nil || level < 0 || level > 3 {
+      return nil, &Error{CodeInvalidParams, "checklevel must be a number between 0 and 3"}
+    }
+  }
+  if len(params) > 1 {
+    if err := json.Unmarshal(params[1], &blocks); err != nil || blocks < 0 {
+      return nil, &Error{CodeInvalidParams, "nblocks must be a positive number"}
+    }
+  }
+  return s.backend.VerifyChain(level, blocks), nil
+}
+
+// Define a function to answer estimatefee with an optional confirmation target in blocks, returning the fee rate the
+// recent blocks asked for
+func estimateFee(s *Server, params []json.RawMessage) (interface{}, error) {
+  target := -1 // the node picks the default
+  if len(params) > 0 {
+    if err := json.Unmarshal(params[0], &target); err != nil || target < 1 {
+      return nil, &Error{CodeInvalidParams, "conf_target must be a number of blocks"}
+    }
+  }
+  return s.backend.EstimateFee(target), nil
+}
+
+// Define a function to answer abandontransaction with the hex ID of an unconfirmed wallet transaction, so the wallet can
+// spend its inputs again
+func abandonTransaction(s *Server, params []json.RawMessage) (interface{}, error) {
+  id, err := stringParam(params, 0, "txid")
+  if err != nil {
+    return nil, err
+  }
+  return nil, s.backend.AbandonTransaction(id)
+}
+
+// Define a function to answer gettransaction with the hex ID of a transaction of the wallet, returning its status and
+// confirmations
+func getTransaction(s *Server, params []json.RawMessage) (interface{}, error) {
+  id, err := stringParam(params, 0, "txid")
+  if err != nil {
+    return nil, err
+  }
+  return s.backend.WalletTransaction(id)
+}
+
+// Define a function to answer listtransactions with an optional number of transactions to list and an optional number
+// of newer transactions to skip, returning the transactions of the wallet newest first
+func listTransactions(s *Server, params []json.RawMessage) (interface{}, error) {
+  count, skip := defaultHistoryCount, 0
+  if len(params) > 0 {
+    if err := json.Unmarshal(params[0], &count); err != nil || count < 1 || count > maxHistoryCount {
+      return nil, &Error{CodeInvalidParams, fmt.Sprintf("count must be a number between 1 and %d", maxHistoryCount)}
+    }
+  }
+  if len(params) > 1 {
+    if err := json.Unmarshal(params[1], &skip); err != nil || skip < 0 {
+      return nil, &Error{CodeInvalidParams, "skip must be a positive number"}
+    }
+  }
+  return s.backend.ListWalletTransactions(count, skip)
+}
+
+// Define a function to answer importaddress with an address to watch without its key and an optional flag, true by
+// default, to search the chain for its past transactions
+func importAddress(s *Server, params []json.RawMessage) (interface{}, error) {
+  address, err := stringParam(params, 0, "address")
+  if err != nil {
+    return nil, err
+  }
+  rescan := true
+  if len(params) > 1 {
+    if err := json.Unmarshal(params[1], &rescan); err != nil {
+      return nil, &Error{CodeInvalidParams, "rescan must be a boolean"}
+    }
+  }
+  return nil, s.backend.ImportAddress(address, rescan)
+}
+
+// Define a function to answer importxpub with an extended public key whose receive addresses are watched and an
+// optional flag, true by default, to search the chain for their past transactions, returning the addresses added
+func importXPub(s *Server, params []json.RawMessage) (interface{}, error) {
+  xpub, err := stringParam(params, 0, "xpub")
+  if err != nil {
+    return nil, err
+  }
+  rescan := true
+  if len(params) > 1 {
+    if err := json.Unmarshal(params[1], &rescan); err != nil {
+      return nil, &Error{CodeInvalidParams, "rescan must be a boolean"}
+    }
+  }
+  return s.backend.ImportXPub(xpub, rescan)
+}
+
+// Define a function to answer encryptwallet with the passphrase encrypting the keys of the wallet
+func encryptWallet(s *Server, params []json.RawMessage) (interface{}, error) {
+  passphrase, err := stringParam(params, 0, "passphrase")
+  if err != nil {
+    return nil, err
+  }
+  if err := s.backend.EncryptWallet(passphrase); err != nil {
+    return nil, err
+  }
+  return "the keys of the wallet are encrypted and locked, unlock them with walletpassphrase", nil
+}
+
+// Define a function to answer walletpassphrase with the passphrase of the wallet and the number of seconds its keys stay
+// unlocked
+func walletPassphrase(s *Server, params []json.RawMessage) (interface{}, error) {
+  passphrase, err := stringParam(params, 0, "passphrase")
+  if err != nil {
+    return nil, err
+  }
+  if len(params) < 2 {
+    return nil, &Error{CodeInvalidParams, "missing parameter timeout"}
+  }
+  var timeout int
+  if err := json.Unmarshal(params[1], &timeout); err != nil || timeout <= 0 {
+    return nil, &Error{CodeInvalidParams, "timeout must be a positive number of seconds"}
+  }
+  return nil, s.backend.UnlockWallet(passphrase, timeout)
+}
+
+// Define a function to answer walletlock, with no parameters
+func walletLock(s *Server, params []json.RawMessage) (interface{}, error) {
+  return nil, s.backend.LockWallet()
+}
+
+// Define a function to answer getnewaddress with optionally the kind of key of the address, ecdsa or ed25519
+func getNewAddress(s *Server, params []json.RawMessage) (interface{}, error) {
+  var kind string // ECDSA by default
+  if len(params) > 0 {
+    var err error
+    if kind, err = stringParam(params, 0, "address_type"); err != nil {
+      return nil, err
+    }
+  }
+  if kind != "" && kind != wallet.KeyECDSA && kind != wallet.KeyEd25519 {
+    return nil, &Error{CodeInvalidParams, "address_type must be " + wallet.KeyECDSA + " or " + wallet.KeyEd25519}
+  }
+  return s.backend.NewAddress(kind)
+}
+
+// Define a function to answer getaddresshistory with an address, then optionally the number of newer transactions to
+// skip and the number to list, from the address index of a node answering the read-only queries
+func getAddressHistory(s *Server, params []json.RawMessage) (interface{}, error) {
+  explorer, ok := s.backend.(Explorer)
+  if !ok {
+    return nil, &Error{CodeMethodNotFound, "the node does not keep the transactions of the addresses"}
+  }
+  address, err := stringParam(params, 0, "address")
+  if err != nil {
+    return nil, err
+  }
+  skip, count := 0, defaultHistoryCount
+  if len(params) > 1 {
+    if err := json.Unmarshal(params[1], &skip); err != nil || skip < 0 {
+      return nil, &Error{CodeInvalidParams, "skip must be a positive number"}
+    }
+  }
+  if len(params) > 2 {
+    if err := json.Unmarshal(params[2], &count); err != nil || count < 1 || count > maxHistoryCount {
+      return nil, &Error{CodeInvalidParams, fmt.Sprintf("count must be a number between 1 and %d", maxHistoryCount)}
+    }
+  }
+  return explorer.History(address, skip, count)
+}
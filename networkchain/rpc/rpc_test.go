@@ -0,0 +1,58 @@
+package rpc
+
+import (
+  "net/http"          // for the status codes
+  "net/http/httptest" // to record the responses
+  "strings"           // for the request bodies
+  "testing"           // the test framework
+)
+
+// Define a struct for a backend answering getblockcount only
+type heightBackend struct {
+  Backend // nil, the other methods are not called
+}
+
+// Define a method to get the height of the main chain
+func (heightBackend) BlockCount() int {
+  return 42
+}
+
+func TestServeHTTPAuth(t *testing.T) {
+  server := NewServer(heightBackend{}, "user", "password")
+  tests := []struct {
+    name        string
+    user        string // the credentials of the request, none if empty
+    password    string
+    contentType string
+    status      int
+  }{
+    {"authorized", "user", "password", "application/json", http.StatusOK},
+    {"content type with a charset", "user", "password", "application/json; charset=utf-8", http.StatusOK},
+    {"no credentials", "", "", "application/json", http.StatusUnauthorized},
+    {"wrong password", "user", "passwore", "application/json", http.StatusUnauthorized},
+    {"wrong user", "root", "password", "application/json", http.StatusUnauthorized},
+    {"password split differently", "user:pass", "word", "application/json", http.StatusUnauthorized},
+    {"text sent by a web page", "user", "password", "text/plain", http.StatusUnsupportedMediaType},
+    {"form sent by a web page", "user", "password", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+    {"no content type", "user", "password", "", http.StatusUnsupportedMediaType},
+  }
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"jsonrpc":"2.0","method":"getblockcount","id":1}`))
+      if test.user != "" {
+        r.SetBasicAuth(test.user, test.password)
+      }
+      if test.contentType != "" {
+        r.Header.Set("Content-Type", test.contentType)
+      }
+      w := httptest.NewRecorder()
+      server.ServeHTTP(w, r)
+      if w.Code != test.status {
+        t.Fatalf("status %d, want %d: %s", w.Code, test.status, w.Body)
+      }
+      if test.status == http.StatusOK && !strings.Contains(w.Body.String(), `"result":42`) {
+        t.Errorf("unexpected response %s", w.Body)
+      }
+    })
+  }
+}
@@ -0,0 +1,120 @@
+package rpc
+
+import (
+  "encoding/json" // the encoding of the parameters
+  "net/http"      // the transport of the requests
+  "strings"       // to read the wallet name of the URL
+)
+
+// Define the path prefix routing a request to a named wallet, /wallet/<name>
+const walletPrefix = "/wallet/"
+
+// Define an interface for a node holding several named wallets
+// The wallet methods of a request to /wallet/<name> act on the wallet of that name, the ones of a request to / on the
+// only wallet loaded
+type WalletRouter interface {
+  ForWallet(name string) Backend              // the backend whose wallet methods act on the wallet of a name
+  CreateWallet(name, passphrase string) error // create and load a wallet, encrypted if the passphrase is not empty
+  LoadWallet(name string) error               // load a wallet created before
+  UnloadWallet(name string) error             // stop following a loaded wallet and wipe its keys
+  ListWallets() []LoadedWallet                // the loaded wallets, by name
+}
+
+// Define a struct for the JSON view of a loaded wallet
+type LoadedWallet struct {
+  Name      string `json:"name"`             // empty for the wallet of the data directory
+  Encrypted bool   `json:"encrypted"`
+  Locked    bool   `json:"locked,omitempty"` // the keys of the encrypted wallet are locked
+}
+
+// Define a method to route a request to the named wallet of its URL, if it names one
+func (s *Server) route(w http.ResponseWriter, r *http.Request) (*Server, bool) {
+  if !strings.HasPrefix(r.URL.Path, walletPrefix) {
+    return s, true
+  }
+  router, ok := s.backend.(WalletRouter)
+  if !ok {
+    http.Error(w, "the node does not hold named wallets", http.StatusNotFound)
+    return nil, false
+  }
+  name := strings.TrimPrefix(r.URL.Path, walletPrefix)
+  return &Server{backend: router.ForWallet(name), walletName: name, auth: s.auth}, true
+}
+
+// Define a function to get the wallets of a backend holding several
+func walletRouter(s *Server) (WalletRouter, error) {
+  router, ok := s.backend.(WalletRouter)
+  if !ok {
+    return nil, &Error{CodeMethodNotFound, "the node does not hold named wallets"}
+  }
+  return router, nil
+}
+
+// Define a function to answer createwallet with the name of the wallet, then optionally the passphrase encrypting its
+// keys
+func createWallet(s *Server, params []json.RawMessage) (interface{}, error) {
+  router, err := walletRouter(s)
+  if err != nil {
+    return nil, err
+  }
+  name, err := stringParam(params, 0, "wallet_name")
+  if err != nil {
+    return nil, err
+  }
+  var passphrase string // without one, the keys are not encrypted
+  if len(params) > 1 {
+    if passphrase, err = stringParam(params, 1, "passphrase"); err != nil {
+      return nil, err
+    }
+  }
+  if err := router.CreateWallet(name, passphrase); err != nil {
+    return nil, err
+  }
+  return LoadedWallet{Name: name, Encrypted: passphrase != "", Locked: passphrase != ""}, nil
+}
+
+// Define a function to answer loadwallet with the name of a wallet created before
+func loadWallet(s *Server, params []json.RawMessage) (interface{}, error) {
+  router, err := walletRouter(s)
+  if err != nil {
+    return nil, err
+  }
+  name, err := stringParam(params, 0, "wallet_name")
+  if err != nil {
+    return nil, err
+  }
+  if err := router.LoadWallet(name); err != nil {
+    return nil, err
+  }
+  for _, loaded := range router.ListWallets() {
+    if loaded.Name == name {
+      return loaded, nil
+    }
+  }
+  return LoadedWallet{Name: name}, nil
+}
+
+// Define a function to answer unloadwallet with an optional wallet name, by default the one of the URL, or the wallet of
+// the data directory when the URL names none
+func unloadWallet(s *Server, params []json.RawMessage) (interface{}, error) {
+  router, err := walletRouter(s)
+  if err != nil {
+    return nil, err
+  }
+  name := s.walletName
+  if len(params) > 0 {
+    if name, err = stringParam(params, 0, "wallet_name"); err != nil {
+      return nil, err
+    }
+  }
+  return nil, router.UnloadWallet(name)
+}
+
+// Define a function to answer listwallets, with no parameters
+func listWallets(s *Server, params []json.RawMessage) (interface{}, error) {
+  router, err := walletRouter(s)
+  if err != nil {
+    return nil, err
+  }
+  return router.ListWallets(), nil
+}
@@ -0,0 +1,185 @@
+package rpc
+
+import (
+  "encoding/json" // the encoding of the messages
+  "net/http"      // the transport of the upgrade request
+  "sync"          // the connection is written by two goroutines
+  "time"          // for the write deadline
+
+  "github.com/gorilla/websocket" // the WebSocket protocol
+)
+
+// Define the topics a client can subscribe to
+const (
+  TopicNewBlock         = "newBlock"         // a block was connected to the main chain, the params are a Block
+  TopicNewTx            = "newTx"            // a transaction entered the mempool, the params are a Transaction
+  TopicReorg            = "reorg"            // the main chain switched branch, the params are a Reorg
+  TopicSyncProgress     = "syncProgress"     // the sync downloaded more blocks or caught up, the params are a SyncInfo
+  TopicPeerConnected    = "peerConnected"    // a peer completed the handshake, the params are a PeerInfo with its address and direction
+  TopicPeerDisconnected = "peerDisconnected" // a peer was dropped, the params are a PeerInfo with its address and direction
+)
+
+// Define the path of the WebSocket endpoint
+const wsPath = "/ws"
+
+// Define some limits of the WebSocket connections
+const (
+  wsMaxMessageSize = 64 << 10        // the largest request accepted from a client
+  wsWriteTimeout   = 10 * time.Second // how long a client has to take a message
+)
+
+// Define an interface for the events pushed to the clients
+type Notifier interface {
+  Subscribe() (<-chan Notification, func()) // the events of the node, and a function to stop receiving them
+}
+
+// Define a struct for an event pushed to a client
+type Notification struct {
+  Topic string      // one of the topics
+  Data  interface{} // the JSON view of the block, transaction, reorganization, sync progress or peer
+}
+
+// Define a struct for the JSON view of a reorganization
+type Reorg struct {
+  Fork         string   `json:"fork"`         // the hash of the last block both branches share
+  Disconnected []string `json:"disconnected"` // the hashes of the blocks of the old branch, oldest first
+  Connected    []string `json:"connected"`    // the hashes of the blocks of the new branch, oldest first
+}
+
+// Define a struct for a notification message, a JSON-RPC request without an ID
+type notification struct {
+  JSONRPC string      `json:"jsonrpc"`
+  Method  string      `json:"method"`
+  Params  interface{} `json:"params"`
+}
+
+// Define a struct for the WebSocket endpoint
+type wsHandler struct {
+  notifier Notifier           // the node producing the events
+  upgrader websocket.Upgrader // the upgrader of the HTTP connections
+}
+
+// Define a function to create the WebSocket endpoint
+// Clients send JSON-RPC requests "subscribe" and "unsubscribe" with a list of topics as params,
+// and receive every event of their topics as a JSON-RPC notification whose method is the topic
+func NewWebSocket(notifier Notifier) http.Handler {
+  return &wsHandler{notifier: notifier}
+}
+
+// Define a struct for a connected client
+type wsClient struct {
+  conn    *websocket.Conn // the connection
+  writeMu sync.Mutex      // only one goroutine may write at a time
+  mu      sync.Mutex      // the lock protecting the topics
+  topics  map[string]bool // the topics the client subscribed to
+}
+
+// Define a method to upgrade a request and push events until the client leaves
+func (h *wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  conn, err := h.upgrader.Upgrade(w, r, nil)
+  if err != nil {
+    return // the upgrader already answered the request
+  }
+  defer conn.Close()
+  conn.SetReadLimit(wsMaxMessageSize)
+  events, cancel := h.notifier.Subscribe()
+  defer cancel()
+  client := &wsClient{conn: conn, topics: map[string]bool{}}
+  done := make(chan struct{})
+  go func() {
+    client.readRequests()
+    close(done)
+  }()
+  for {
+    select {
+    case <-done: // the client left
+      return
+    case event, ok := <-events:
+      if !ok {
+        return
+      }
+      if client.subscribed(event.Topic) {
+        if err := client.write(notification{jsonrpcVersion, event.Topic, event.Data}); err != nil {
+          return
+        }
+      }
+    }
+  }
+}
+
+// Define a method to answer the requests of the client until it disconnects
+func (c *wsClient) readRequests() {
+  for {
+    var req request
+    if err := c.conn.ReadJSON(&req); err != nil {
+      if _, isJSON := err.(*json.SyntaxError); !isJSON {
+        return // the connection is gone
+      }
+      c.write(errorResponse(nil, CodeParseError, err.Error()))
+      continue
+    }
+    var resp *response
+    switch req.Method {
+    case "subscribe", "unsubscribe":
+      var topics []string
+      if err := json.Unmarshal(req.Params, &topics); err != nil || len(topics) == 0 {
+        resp = errorResponse(req.ID, CodeInvalidParams, "params must be a list of topics")
+        break
+      }
+      if !validTopics(topics) {
+        resp = errorResponse(req.ID, CodeInvalidParams, "unknown topic")
+        break
+      }
+      c.setTopics(topics, req.Method == "subscribe")
+      resp = &response{JSONRPC: jsonrpcVersion, Result: true, ID: req.ID}
+    default:
+      resp = errorResponse(req.ID, CodeMethodNotFound, "method not found: "+req.Method)
+    }
+    if req.ID == nil { // notifications get no response
+      continue
+    }
+    if err := c.write(resp); err != nil {
+      return
+    }
+  }
+}
+
+// Define a function to check that every topic exists
+func validTopics(topics []string) bool {
+  for _, topic := range topics {
+    switch topic {
+    case TopicNewBlock, TopicNewTx, TopicReorg, TopicSyncProgress, TopicPeerConnected, TopicPeerDisconnected:
+    default:
+      return false
+    }
+  }
+  return true
+}
+
+// Define a method to add or remove topics
+func (c *wsClient) setTopics(topics []string, subscribe bool) {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  for _, topic := range topics {
+    if subscribe {
+      c.topics[topic] = true
+    } else {
+      delete(c.topics, topic)
+    }
+  }
+}
+
+// Define a method to check if the client subscribed to a topic
+func (c *wsClient) subscribed(topic string) bool {
+  c.mu.Lock()
+  defer c.mu.Unlock()
+  return c.topics[topic]
+}
+
+// Define a method to send a JSON message to the client
+func (c *wsClient) write(v interface{}) error {
+  c.writeMu.Lock()
+  defer c.writeMu.Unlock()
+  c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout)) // do not let a stuck client block the events
+  return c.conn.WriteJSON(v)
+}
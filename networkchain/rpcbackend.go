@@ -0,0 +1,1025 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"networkchain/address"
+	"networkchain/consensus"
+	"networkchain/events"
+	"networkchain/rpc"
+	"networkchain/script"
+	"networkchain/wallet"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Define a struct for the view of a node given to the RPC server
+type rpcBackend struct {
+  n          *Node  // the node answering the requests
+  walletName string // the wallet the request names in its URL
+  routed     bool   // the URL names a wallet, the empty name being the one of the data directory
+}
+
+// The name of the file of the data directory holding the credentials of the RPC requests when none are set
+const rpcCookieFile = ".cookie"
+
+// The user of the credentials of the cookie file
+const rpcCookieUser = "__cookie__"
+
+// Define a method to serve JSON-RPC requests carrying a user and a password on an address until it fails
+// Without a password, a random one is written with its user to the cookie file of the data directory, which the local
+// tools read
+func (n *Node) ServeRPC(address, user, password string) error {
+  if password == "" {
+    var err error
+    if user, password, err = n.writeRPCCookie(); err != nil {
+      return err
+    }
+  }
+  rpcLog.Info("Serving JSON-RPC, the REST API, the WebSocket events and the explorer", "addr", address)
+  return rpc.NewServer(rpcBackend{n: n}, user, password).ListenAndServe(address) // serve the requests
+}
+
+// Define a method to write new random credentials to the cookie file, readable by the owner of the data directory only
+func (n *Node) writeRPCCookie() (string, string, error) {
+  secret := make([]byte, 32)
+  if _, err := rand.Read(secret); err != nil {
+    return "", "", err
+  }
+  password := hex.EncodeToString(secret)
+  path := filepath.Join(n.dataDir, rpcCookieFile)
+  os.Remove(path) // an older file keeps its permissions, it is written again
+  if err := os.WriteFile(path, []byte(rpcCookieUser+":"+password), 0600); err != nil {
+    return "", "", fmt.Errorf("cannot write the RPC cookie: %w", err)
+  }
+  rpcLog.Info("Wrote the credentials of the RPC requests", "file", path)
+  return rpcCookieUser, password, nil
+}
+
+// Define a method to get the height of the main chain
+func (b rpcBackend) BlockCount() int {
+  return b.n.bc.GetBestHeight()
+}
+
+// Define a method to get the hash of the last block of the main chain
+func (b rpcBackend) BestBlockHash() string {
+  return hex.EncodeToString(b.n.bc.Tip().MyBlockHash)
+}
+
+// Define a method to get a block by its hex hash
+func (b rpcBackend) Block(hash string) (*rpc.Block, error) {
+  id, err := hex.DecodeString(hash) // decode the hash
+  if err != nil {
+    return nil, fmt.Errorf("%w: invalid block hash %q", rpc.ErrNotFound, hash)
+  }
+  block, height, ok := b.n.bc.GetBlock(id) // look the block up
+  if !ok {
+    return nil, fmt.Errorf("%w: block %s", rpc.ErrNotFound, hash)
+  }
+  return blockView(b.n.bc, block, height), nil // return its JSON view
+}
+
+// Define a method to get the last blocks of the main chain, newest first
+func (b rpcBackend) RecentBlocks(count int) []*rpc.Block {
+  blocks := b.n.bc.MainChain() // copy the main chain
+  var views []*rpc.Block       // create a buffer for the views
+  for height := len(blocks) - 1; height >= 0 && len(views) < count; height-- { // walk back from the tip
+    views = append(views, blockView(b.n.bc, blocks[height], height))
+  }
+  return views // return the views
+}
+
+// Define a function to build the JSON view of a block
+func blockView(bc *Blockchain, block *Block, height int) *rpc.Block {
+  view := &rpc.Block{
+    Hash:              hex.EncodeToString(block.MyBlockHash),
+    Height:            height,
+    PreviousBlockHash: hex.EncodeToString(block.PreviousBlockHash),
+    MerkleRoot:        hex.EncodeToString(block.MerkleRoot),
+    Time:              block.Timestamp,
+    Bits:              fmt.Sprintf("%08x", block.Bits),
+    Nonce:             block.Nonce,
+  }
+  view.Confirmations = bc.Confirmations(block, height) // count the blocks on top of it, 0 on a side branch
+  view.Pruned = block.Pruned() // only the header is left
+  for _, tx := range block.Transactions { // iterate over the transactions
+    view.Transactions = append(view.Transactions, hex.EncodeToString(tx.ID)) // list their IDs
+  }
+  return view // return the view
+}
+
+// Define a method to check a serialized transaction, add it to the mempool and announce it to the peers
+func (b rpcBackend) SendRawTransaction(raw []byte) (string, error) {
+  tx, err := b.n.submitTransaction(raw) // check, add and announce the transaction
+  if err != nil {
+    return "", fmt.Errorf("%w: %s", rpc.ErrRejected, err)
+  }
+  return hex.EncodeToString(tx.ID), nil // return its ID
+}
+
+// Define a method to describe the peers of the node
+func (b rpcBackend) PeerInfo() []rpc.PeerInfo {
+  var peers []rpc.PeerInfo // create a buffer for the peers
+  for _, address := range b.n.peers() { // iterate over the known nodes
+    version, _ := b.n.negotiatedVersion(address) // the version is 0 before the handshake
+    services, agent := b.n.peerAgent(address)
+    var servicesText string // no services before the handshake
+    if version > 0 {
+      servicesText = fmt.Sprintf("%016x", services)
+    }
+    since, inbound := b.n.conns.state(address)
+    var connTime int64 // a manual address is listed even when it is not connected
+    if !since.IsZero() {
+      connTime = since.Unix()
+    }
+    var timeOffset *int64 // older peers do not tell their time
+    if offset, ok := b.n.peerTimeOffset(address); ok {
+      seconds := int64(offset / time.Second)
+      timeOffset = &seconds
+    }
+    peers = append(peers, rpc.PeerInfo{
+      Address:    address,
+      Version:    version,
+      Services:   servicesText,
+      SubVer:     agent,
+      PingTime:   b.n.peerRTT(address).Seconds(),
+      Inbound:    inbound,
+      ConnTime:   connTime,
+      TimeOffset: timeOffset,
+      Banned:     b.n.isBanned(address),
+      ID:         b.n.peerID(address),
+    })
+  }
+  return peers // return the peers
+}
+
+// Define a method to ban a peer or lift its ban
+func (b rpcBackend) SetBan(address string, ban bool, duration time.Duration) error {
+  if ban {
+    b.n.bans.ban(address, duration, "banned through RPC") // add it to the ban list
+    b.n.dropPeer(address) // and forget it
+    netLog.Info("Banned peer through RPC", "peer", address)
+    return nil
+  }
+  if !b.n.bans.unban(address) {
+    return fmt.Errorf("%w: %s is not banned", rpc.ErrNotFound, address)
+  }
+  netLog.Info("Unbanned peer through RPC", "peer", address)
+  return nil
+}
+
+// Define a method to list the banned peers
+func (b rpcBackend) ListBanned() []rpc.BannedPeer {
+  var banned []rpc.BannedPeer // create a buffer for the views
+  for _, peer := range b.n.bans.list() { // iterate over the bans
+    banned = append(banned, rpc.BannedPeer{Address: peer.peer, BannedUntil: peer.until.Unix(), Reason: peer.reason})
+  }
+  return banned // return the views
+}
+
+// Define a method to get the subsidy of the next block and the coins created by the main chain
+func (b rpcBackend) Supply() *rpc.Supply {
+  height := b.n.bc.GetBestHeight()
+  return &rpc.Supply{
+    Height:          height,
+    Subsidy:         activeNet.BlockSubsidy(height + 1),
+    Supply:          activeNet.TotalSupply(height),
+    HalvingInterval: activeNet.SubsidyHalvingInterval,
+    NextHalving:     activeNet.NextHalving(height + 1),
+  }
+}
+
+// Define a method to start or stop the CPU miner of the node
+func (b rpcBackend) SetGenerate(generate bool, threads int) error {
+  if b.n.miner == nil {
+    return errors.New("the node mines only on a proof of work network, with a miner address")
+  }
+  if !generate {
+    b.n.miner.Stop()
+    return nil
+  }
+  if b.n.isFirstNode() {
+    return errors.New("the first node relays the transactions, it does not mine")
+  }
+  b.n.miner.Start(threads)
+  return nil
+}
+
+// Define a method to get the state of the miner
+func (b rpcBackend) MiningInfo() *rpc.MiningInfo {
+  info := &rpc.MiningInfo{Blocks: b.n.bc.GetBestHeight(), PooledTx: b.n.bc.Mempool.Count()}
+  if b.n.miner != nil {
+    status := b.n.miner.Status()
+    info.Generate, info.Threads, info.HashesPerSec, info.Mined = status.Running, status.Threads, status.HashRate, status.Mined
+  }
+  return info
+}
+
+// Define a method to build the next block for an external miner, the coinbase paying the address if it is not empty
+func (b rpcBackend) BlockTemplate(addr string) (*rpc.BlockTemplate, error) {
+  if !activeNet.IsProofOfWork() {
+    return nil, errors.New("the blocks of the network are signed by its validators, not mined")
+  }
+  if addr != "" && !address.Validate(addr) {
+    return nil, &rpc.Error{Code: rpc.CodeInvalidParams, Message: fmt.Sprintf("invalid address %q", addr)}
+  }
+  block, fees := b.n.bc.NewBlockTemplate(addr) // the same block the CPU miner searches
+  _, height, _ := b.n.bc.GetBlock(block.PreviousBlockHash)
+  minTime, _ := b.n.bc.MinBlockTime(block.PreviousBlockHash)
+  template := &rpc.BlockTemplate{
+    PreviousBlockHash: hex.EncodeToString(block.PreviousBlockHash),
+    Height:            height + 1,
+    Bits:              fmt.Sprintf("%08x", block.Bits),
+    Target:            fmt.Sprintf("%064x", consensus.CompactToBig(block.Bits)),
+    CurTime:           block.Timestamp,
+    MinTime:           minTime,
+    CoinbaseValue:     activeNet.BlockSubsidy(height+1) + fees,
+    Transactions:      []rpc.TemplateTransaction{}, // an empty list, not null
+  }
+  for _, tx := range block.Transactions[1:] { // the transactions after the coinbase
+    view := rpc.TemplateTransaction{Data: hex.EncodeToString(tx.Serialize()), Txid: hex.EncodeToString(tx.ID)}
+    if entry := b.n.bc.Mempool.Get(view.Txid); entry != nil { // a transaction mined meanwhile makes the template stale anyway
+      view.Fee = entry.Fee
+    }
+    template.Transactions = append(template.Transactions, view)
+  }
+  if addr != "" { // the coinbase is ready
+    coinbase := block.Transactions[0]
+    template.CoinbaseTxn = &rpc.TemplateTransaction{Data: hex.EncodeToString(coinbase.Serialize()), Txid: hex.EncodeToString(coinbase.ID)}
+    template.MerkleRoot = hex.EncodeToString(block.MerkleRoot)
+  }
+  return template, nil
+}
+
+// Define a method to check and add a block mined by an external miner, announcing it once it extends the main chain
+func (b rpcBackend) SubmitBlock(raw []byte) error {
+  block, err := decodeBlock(raw) // deserialize the block
+  if err != nil {
+    return fmt.Errorf("%w: invalid block: %s", rpc.ErrRejected, err)
+  }
+  if _, _, known := b.n.bc.GetBlock(block.MyBlockHash); known {
+    return fmt.Errorf("%w: duplicate block %x", rpc.ErrRejected, block.MyBlockHash)
+  }
+  if err := b.n.bc.AddBlock(block); err != nil { // check the work, the transactions and the parent
+    return fmt.Errorf("%w: %s", rpc.ErrRejected, err)
+  }
+  if !bytes.Equal(b.n.bc.Tip().MyBlockHash, block.MyBlockHash) { // kept on a side branch
+    minerLog.Info("Submitted block is not on the main chain", "hash", block.MyBlockHash)
+    return nil
+  }
+  minerLog.Info("Added submitted block", "hash", block.MyBlockHash, "height", b.n.bc.GetBestHeight(), "txs", len(block.Transactions))
+  b.n.announceBlock(block)
+  return nil
+}
+
+// Define a method to mine blocks with the mempool transactions at once, on a network with a trivial difficulty like regtest
+func (b rpcBackend) Generate(count int, addr string) ([]string, error) {
+  if addr == "" {
+    addr = b.n.minerAddress
+  }
+  if addr == "" {
+    return nil, &rpc.Error{Code: rpc.CodeInvalidParams, Message: "no address given and the node has no miner address"}
+  }
+  if !address.Validate(addr) {
+    return nil, &rpc.Error{Code: rpc.CodeInvalidParams, Message: fmt.Sprintf("invalid address %q", addr)}
+  }
+  blocks, err := b.n.generate(count, addr)
+  if err != nil {
+    return nil, err
+  }
+  hashes := []string{} // an empty list, not null
+  for _, block := range blocks {
+    hashes = append(hashes, hex.EncodeToString(block.MyBlockHash))
+  }
+  return hashes, nil
+}
+
+// Define a method to get a serialized transaction of the mempool or the chain by its hex ID
+func (b rpcBackend) RawTransaction(id string) ([]byte, error) {
+  if entry := b.n.bc.Mempool.Get(id); entry != nil { // look in the mempool first, it needs no index
+    return entry.Tx.(*Transaction).Serialize(), nil
+  }
+  txid, err := hex.DecodeString(id) // decode the ID
+  if err != nil {
+    return nil, fmt.Errorf("%w: invalid transaction ID %q", rpc.ErrNotFound, id)
+  }
+  tx, _, _, err := b.n.bc.LocateTransaction(txid) // then in the chain
+  if err != nil {
+    return nil, fmt.Errorf("%w: transaction %s: %s", rpc.ErrNotFound, id, err)
+  }
+  return tx.Serialize(), nil
+}
+
+// Define a method to get a transaction of the chain or the mempool by its hex ID
+func (b rpcBackend) Transaction(id string) (*rpc.Transaction, error) {
+  txid, err := hex.DecodeString(id) // decode the ID
+  if err != nil {
+    return nil, fmt.Errorf("%w: invalid transaction ID %q", rpc.ErrNotFound, id)
+  }
+  tx, block, height, err := b.n.bc.LocateTransaction(txid) // look the transaction up in the chain
+  if err == nil {
+    view := transactionView(tx) // build its JSON view
+    view.BlockHash = hex.EncodeToString(block.MyBlockHash) // with the block holding it
+    view.Height = height
+    view.Confirmations = b.n.bc.Confirmations(block, height)
+    return view, nil
+  }
+  if entry := b.n.bc.Mempool.Get(id); entry != nil { // then in the mempool
+    return transactionView(entry.Tx.(*Transaction)), nil
+  }
+  return nil, fmt.Errorf("%w: transaction %s: %s", rpc.ErrNotFound, id, err)
+}
+
+// Define a function to build the JSON view of a transaction
+func transactionView(tx *Transaction) *rpc.Transaction {
+  view := &rpc.Transaction{ID: hex.EncodeToString(tx.ID), Coinbase: tx.IsCoinbase(), LockTime: tx.LockTime}
+  for _, in := range tx.Vin { // iterate over the inputs
+    input := rpc.Input{Txid: hex.EncodeToString(in.Txid), Vout: in.Vout}
+    if tx.IsCoinbase() { // the unlocking script of a coinbase is free data
+      input.Coinbase = string(in.ScriptSig)
+    } else {
+      input.ScriptSig, input.Asm, input.Address = hex.EncodeToString(in.ScriptSig), script.Disassemble(in.ScriptSig), in.Address()
+    }
+    view.Inputs = append(view.Inputs, input)
+  }
+  for _, out := range tx.Vout { // iterate over the outputs
+    view.Outputs = append(view.Outputs, rpc.Output{
+      Value:        out.Value,
+      ScriptPubKey: hex.EncodeToString(out.ScriptPubKey),
+      Asm:          script.Disassemble(out.ScriptPubKey),
+      Address:      out.Address(),
+    })
+  }
+  return view // return the view
+}
+
+// Define a method to get the confirmed balance of an address
+func (b rpcBackend) Balance(address string) (*rpc.Balance, error) {
+  balance := &rpc.Balance{Address: address} // create the view
+  for _, out := range (UTXOSet{b.n.bc}).FindUTXO(address) { // sum the unspent outputs of the address
+    balance.Balance += out.Value
+    balance.Outputs++
+  }
+  return balance, nil // return it
+}
+
+// Define a method to get a page of the main chain transactions of an address with the value it received and sent in each
+func (b rpcBackend) History(address string, skip, count int) (*rpc.History, error) {
+  found, total, err := b.n.bc.AddressTransactions(address, skip, count) // look the transactions up in the address index
+  if err != nil {
+    return nil, err
+  }
+  history := &rpc.History{Address: address, Total: total, Skip: skip, Transactions: []rpc.HistoryEntry{}} // an empty list, not null
+  for _, atx := range found { // iterate over the transactions
+    entry := rpc.HistoryEntry{
+      Txid:      hex.EncodeToString(atx.Tx.ID),
+      BlockHash: hex.EncodeToString(atx.Block.MyBlockHash),
+      Height:    atx.Height,
+      Time:      atx.Block.Timestamp,
+    }
+    for _, out := range atx.Tx.Vout { // add the outputs paying to the address
+      if out.CanBeUnlockedWith(address) {
+        entry.Received += out.Value
+      }
+    }
+    for _, in := range atx.Tx.Vin { // add the outputs of the address it spends
+      if atx.Tx.IsCoinbase() || !in.CanUnlockOutputWith(address) {
+        continue
+      }
+      prev, _, _, err := b.n.bc.LocateTransaction(in.Txid) // the spent output is in an earlier main chain block
+      if err != nil {
+        return nil, err
+      }
+      entry.Sent += prev.Vout[in.Vout].Value
+    }
+    history.Transactions = append(history.Transactions, entry)
+  }
+  return history, nil // return the history
+}
+
+// Define a method to subscribe to the events of the chain, converted to their JSON views
+func (b rpcBackend) Subscribe() (<-chan rpc.Notification, func()) {
+  sub := b.n.events.Subscribe(events.DefaultBuffer) // subscribe to the events of the chain and of the peers
+  notifications := make(chan rpc.Notification, events.DefaultBuffer) // create a channel for the views
+  go func() {
+    defer close(notifications) // the subscription was cancelled
+    for event := range sub.C { // iterate over the events
+      notification := rpc.Notification{Topic: event.Type} // the topics are named after the events
+      switch data := event.Data.(type) {
+      case *Block: // a block was connected
+        _, height, _ := b.n.bc.GetBlock(data.MyBlockHash) // find its height
+        notification.Data = blockView(b.n.bc, data, height)
+      case *Transaction: // a transaction entered the mempool
+        notification.Data = transactionView(data)
+      case *ReorgEvent: // the chain switched branch
+        notification.Data = reorgView(data)
+      case *SyncProgress: // the sync moved on
+        notification.Data = syncView(data)
+      case *PeerEvent: // a peer connected or left
+        notification.Data = rpc.PeerInfo{Address: data.Address, Inbound: data.Inbound}
+      default:
+        continue
+      }
+      select {
+      case notifications <- notification: // hand the view over
+      default: // the client is too slow, drop it
+      }
+    }
+  }()
+  return notifications, sub.Cancel // return the views and the way to stop them
+}
+
+// Define a method to pay an address with the coins of the wallet of the node
+func (b rpcBackend) SendToAddress(addr string, amount, feeRate int) (string, error) {
+  if !address.Validate(addr) {
+    return "", &rpc.Error{Code: rpc.CodeInvalidParams, Message: fmt.Sprintf("invalid address %q", addr)}
+  }
+  if feeRate < 0 { // pay what the recent blocks asked for, or the default before the node saw enough of them
+    feeRate = b.n.feeRate(defaultConfTarget)
+  }
+  w, err := b.wallet()
+  if err != nil {
+    return "", err
+  }
+  tx, err := w.sendToAddress(addr, amount, feeRate)
+  switch {
+  case errors.Is(err, errInsufficientFunds):
+    return "", &rpc.Error{Code: rpc.CodeNoFunds, Message: err.Error()}
+  case errors.Is(err, errPaymentRejected):
+    return "", fmt.Errorf("%w: %s", rpc.ErrRejected, err)
+  case err != nil:
+    return "", walletError(err)
+  }
+  return hex.EncodeToString(tx.ID), nil
+}
+
+// Define a method to give up an unconfirmed transaction of the wallet of the node
+func (b rpcBackend) AbandonTransaction(id string) error {
+  w, err := b.wallet()
+  if err != nil {
+    return err
+  }
+  err = w.abandonTransaction(id)
+  switch {
+  case errors.Is(err, errNotWalletTx):
+    return fmt.Errorf("%w: %s is %s", rpc.ErrNotFound, id, err)
+  case err != nil:
+    return &rpc.Error{Code: rpc.CodeWalletError, Message: err.Error()}
+  }
+  return nil
+}
+
+// Define a method to describe a transaction of the wallet by hex ID
+func (b rpcBackend) WalletTransaction(id string) (*rpc.WalletTransaction, error) {
+  w, err := b.wallet()
+  if err != nil {
+    return nil, err
+  }
+  w.scanWalletTxs() // count the blocks connected since the last scan
+  h := w.history
+  h.mu.Lock() // lock the transactions
+  defer h.mu.Unlock() // unlock them when done
+  wtx, ok := h.txs[id]
+  if !ok {
+    return nil, fmt.Errorf("%w: %s is %s", rpc.ErrNotFound, id, errNotWalletTx)
+  }
+  view := walletTxView(w, wtx, b.n.bc.GetBestHeight())
+  return &view, nil
+}
+
+// Define a method to list a page of the transactions of the wallet, newest first
+func (b rpcBackend) ListWalletTransactions(count, skip int) ([]rpc.WalletTransaction, error) {
+  w, err := b.wallet()
+  if err != nil {
+    return nil, err
+  }
+  w.scanWalletTxs() // count the blocks connected since the last scan
+  h := w.history
+  h.mu.Lock() // lock the transactions
+  defer h.mu.Unlock() // unlock them when done
+  sorted := h.sorted()
+  tipHeight := b.n.bc.GetBestHeight()
+  views := []rpc.WalletTransaction{} // an empty list, not null
+  for i := len(sorted) - 1 - skip; i >= 0 && len(views) < count; i-- {
+    views = append(views, walletTxView(w, sorted[i], tipHeight))
+  }
+  return views, nil
+}
+
+// Define a function to build the JSON view of a transaction of a wallet with a tip at a height, the lock of the wallet
+// transactions must be held
+func walletTxView(w *nodeWallet, wtx *walletTx, tipHeight int) rpc.WalletTransaction {
+  view := rpc.WalletTransaction{
+    Txid:          hex.EncodeToString(wtx.tx.ID),
+    Status:        wtx.status(),
+    Confirmations: wtx.confirmations(tipHeight),
+    Time:          wtx.added.Unix(),
+  }
+  if wtx.block != nil {
+    view.BlockHash, view.Height = hex.EncodeToString(wtx.block.MyBlockHash), wtx.height
+  } else if wtx.conflict != nil {
+    view.ConflictBlock = hex.EncodeToString(wtx.conflict.MyBlockHash)
+  }
+  view.Received, view.Sent, view.InvolvesWatchOnly = w.walletAmounts(wtx.tx)
+  return view
+}
+
+// Define a method to build an unsigned transaction from the outputs it spends and the addresses it pays
+func (b rpcBackend) CreateRawTransaction(inputs []rpc.RawInput, outputs []rpc.RawOutput, lockTime int) ([]byte, error) {
+  if lockTime > math.MaxUint32 {
+    return nil, &rpc.Error{Code: rpc.CodeInvalidParams, Message: fmt.Sprintf("invalid lock time %d", lockTime)}
+  }
+  var vin []TXInput
+  for _, in := range inputs {
+    txid, err := hex.DecodeString(in.TxID)
+    if err != nil || len(txid) == 0 || in.Vout < 0 {
+      return nil, &rpc.Error{Code: rpc.CodeInvalidParams, Message: fmt.Sprintf("invalid input %s:%d", in.TxID, in.Vout)}
+    }
+    vin = append(vin, TXInput{Txid: txid, Vout: in.Vout})
+  }
+  var vout []TXOutput
+  for _, out := range outputs {
+    if !address.Validate(out.Address) {
+      return nil, &rpc.Error{Code: rpc.CodeInvalidParams, Message: fmt.Sprintf("invalid address %q", out.Address)}
+    }
+    if out.Amount <= 0 {
+      return nil, &rpc.Error{Code: rpc.CodeInvalidParams, Message: fmt.Sprintf("invalid amount %d paid to %s", out.Amount, out.Address)}
+    }
+    output, err := NewTXOutput(out.Amount, out.Address)
+    if err != nil {
+      return nil, &rpc.Error{Code: rpc.CodeInvalidParams, Message: err.Error()}
+    }
+    vout = append(vout, output)
+  }
+  return newRawTransaction(vin, vout, lockTime).Serialize(), nil
+}
+
+// Define a method to sign a serialized transaction with private keys, or with the keys of the wallet of the node
+func (b rpcBackend) SignRawTransaction(raw []byte, keys [][]byte, prevOuts []rpc.PrevOut) (*rpc.SignedTransaction, error) {
+  tx, err := decodeTransaction(raw)
+  if err != nil {
+    return nil, &rpc.Error{Code: rpc.CodeInvalidParams, Message: "cannot decode the transaction: " + err.Error()}
+  }
+  var signers *wallet.Wallets
+  if len(keys) > 0 { // the keys given replace the wallet
+    var wallets []*wallet.Wallet
+    for _, key := range keys {
+      w, err := wallet.FromPrivateKey(key)
+      if err != nil {
+        return nil, &rpc.Error{Code: rpc.CodeInvalidParams, Message: err.Error()}
+      }
+      wallets = append(wallets, w)
+    }
+    signers = wallet.NewKeyring(wallets)
+  } else {
+    w, err := b.n.wallet(b.walletName, b.routed)
+    if errors.Is(err, errNoWallet) {
+      return nil, &rpc.Error{Code: rpc.CodeWalletError, Message: "the node has no wallet, give the private keys"}
+    }
+    if err != nil {
+      return nil, walletError(err)
+    }
+    if w.keys.IsLocked() {
+      return nil, walletError(wallet.ErrLocked)
+    }
+    signers = w.keys
+  }
+  given := map[string]TXOutput{}
+  for _, prev := range prevOuts {
+    txid, err := hex.DecodeString(prev.TxID)
+    if err != nil {
+      return nil, &rpc.Error{Code: rpc.CodeInvalidParams, Message: fmt.Sprintf("invalid txid %q of a spent output", prev.TxID)}
+    }
+    lock, err := hex.DecodeString(prev.ScriptPubKey)
+    if err != nil {
+      return nil, &rpc.Error{Code: rpc.CodeInvalidParams, Message: fmt.Sprintf("invalid script of the spent output %s:%d", prev.TxID, prev.Vout)}
+    }
+    given[prevOutKey(txid, prev.Vout)] = TXOutput{prev.Amount, lock}
+  }
+  complete, problems := b.n.signTransaction(tx, signers, given)
+  signed := &rpc.SignedTransaction{Hex: hex.EncodeToString(tx.Serialize()), Complete: complete}
+  for _, problem := range problems {
+    signed.Errors = append(signed.Errors, problem.Error())
+  }
+  return signed, nil
+}
+
+// Define a method to fund a payment with the coins of the wallet of the node without signing it, for the keys kept
+// offline to sign
+func (b rpcBackend) FundPSBT(addr string, amount, feeRate int) (*rpc.FundedPSBT, error) {
+  if !address.Validate(addr) {
+    return nil, &rpc.Error{Code: rpc.CodeInvalidParams, Message: fmt.Sprintf("invalid address %q", addr)}
+  }
+  if feeRate < 0 {
+    feeRate = b.n.feeRate(defaultConfTarget)
+  }
+  w, err := b.wallet()
+  if err != nil {
+    return nil, err
+  }
+  p, fee, err := w.fundPayment(addr, amount, feeRate)
+  switch {
+  case errors.Is(err, errInsufficientFunds):
+    return nil, &rpc.Error{Code: rpc.CodeNoFunds, Message: err.Error()}
+  case err != nil:
+    return nil, &rpc.Error{Code: rpc.CodeWalletError, Message: err.Error()}
+  }
+  return &rpc.FundedPSBT{PSBT: p.Encode(), Fee: fee}, nil
+}
+
+// Define a method to wrap a serialized unsigned transaction in a partially signed one
+func (b rpcBackend) CreatePSBT(raw []byte, scripts [][]byte) (string, error) {
+  tx, err := decodeTransaction(raw)
+  if err != nil {
+    return "", &rpc.Error{Code: rpc.CodeInvalidParams, Message: "cannot decode the transaction: " + err.Error()}
+  }
+  var keys *wallet.Wallets // the wallet of the request fills in the outputs spent it knows, if there is one
+  if w, err := b.n.wallet(b.walletName, b.routed); err == nil {
+    keys = w.keys
+  }
+  p, err := b.n.newPartialTx(tx, keys, scripts)
+  if err != nil {
+    return "", &rpc.Error{Code: rpc.CodeInvalidParams, Message: err.Error()}
+  }
+  return p.Encode(), nil
+}
+
+// Define a function to decode a partially signed transaction given to the server
+func psbtParam(psbt string) (*PartialTx, error) {
+  p, err := decodePartialTx(psbt)
+  if err != nil {
+    return nil, &rpc.Error{Code: rpc.CodeInvalidParams, Message: err.Error()}
+  }
+  return p, nil
+}
+
+// Define a method to add the signatures of the keys of the wallet of the node to a partially signed transaction
+func (b rpcBackend) ProcessPSBT(psbt string) (*rpc.ProcessedPSBT, error) {
+  p, err := psbtParam(psbt)
+  if err != nil {
+    return nil, err
+  }
+  w, err := b.wallet()
+  if err != nil {
+    return nil, err
+  }
+  w.mu.Lock() // the keys are not locked while they sign
+  defer w.mu.Unlock() // unlock the wallet when done
+  if w.keys.IsLocked() {
+    return nil, walletError(wallet.ErrLocked)
+  }
+  p.Sign(w.keys)
+  _, err = p.Finalize()
+  return &rpc.ProcessedPSBT{PSBT: p.Encode(), Complete: err == nil}, nil
+}
+
+// Define a method to merge the signatures of copies of a partially signed transaction
+func (b rpcBackend) CombinePSBT(psbts []string) (string, error) {
+  var parts []*PartialTx
+  for _, psbt := range psbts {
+    p, err := psbtParam(psbt)
+    if err != nil {
+      return "", err
+    }
+    parts = append(parts, p)
+  }
+  combined, err := combinePartialTxs(parts)
+  if err != nil {
+    return "", &rpc.Error{Code: rpc.CodeInvalidParams, Message: err.Error()}
+  }
+  return combined.Encode(), nil
+}
+
+// Define a method to build the signed transaction of a partially signed one, or tell what it still needs
+func (b rpcBackend) FinalizePSBT(psbt string) (*rpc.FinalizedPSBT, error) {
+  p, err := psbtParam(psbt)
+  if err != nil {
+    return nil, err
+  }
+  tx, err := p.Finalize()
+  if err != nil {
+    return &rpc.FinalizedPSBT{PSBT: psbt, Error: err.Error()}, nil
+  }
+  return &rpc.FinalizedPSBT{Hex: hex.EncodeToString(tx.Serialize()), Complete: true}, nil
+}
+
+// Define a method to describe a partially signed transaction and the signatures each input still needs
+func (b rpcBackend) DecodePSBT(psbt string) (*rpc.PSBT, error) {
+  p, err := psbtParam(psbt)
+  if err != nil {
+    return nil, err
+  }
+  view := &rpc.PSBT{TxID: hex.EncodeToString(p.Tx.ID), Outputs: transactionView(p.Tx).Outputs, Complete: true}
+  for i, in := range p.Tx.Vin {
+    prevOut := p.Inputs[i].PrevOut
+    input := rpc.PSBTInput{TxID: hex.EncodeToString(in.Txid), Vout: in.Vout, Address: prevOut.Address(), Amount: prevOut.Value}
+    _, signed, required, err := p.unlock(i)
+    if err != nil {
+      input.Error = err.Error()
+    }
+    input.Signatures, input.Required, input.Nonces = signed, required, len(p.Inputs[i].Nonces)
+    view.Complete = view.Complete && err == nil && signed == required
+    view.Inputs = append(view.Inputs, input)
+    view.Fee += prevOut.Value
+  }
+  for _, out := range p.Tx.Vout {
+    view.Fee -= out.Value
+  }
+  return view, nil
+}
+
+// Define a method to check the addresses of a wallet query, the addresses of the wallet of the node if none is given
+func (b rpcBackend) walletAddresses(addresses []string) ([]string, error) {
+  if len(addresses) == 0 {
+    w, err := b.n.wallet(b.walletName, b.routed)
+    if errors.Is(err, errNoWallet) {
+      return nil, &rpc.Error{Code: rpc.CodeWalletError, Message: "the node has no wallet, give the addresses"}
+    }
+    if err != nil {
+      return nil, walletError(err)
+    }
+    return append(w.keys.Addresses(), w.keys.WatchOnlyAddresses()...), nil
+  }
+  for _, addr := range addresses {
+    if !address.Validate(addr) {
+      return nil, &rpc.Error{Code: rpc.CodeInvalidParams, Message: fmt.Sprintf("invalid address %q", addr)}
+    }
+  }
+  return addresses, nil
+}
+
+// Define a method to list the unspent outputs of some addresses with a number of confirmations in a range
+func (b rpcBackend) ListUnspent(minConf, maxConf int, addresses []string) ([]rpc.Unspent, error) {
+  addresses, err := b.walletAddresses(addresses)
+  if err != nil {
+    return nil, err
+  }
+  unspent := []rpc.Unspent{} // an empty list, not null
+  for _, c := range (UTXOSet{b.n.bc}).ListCoins(addresses) {
+    if c.confirmations < minConf || c.confirmations > maxConf {
+      continue
+    }
+    unspent = append(unspent, rpc.Unspent{
+      TxID:          hex.EncodeToString(c.txid),
+      Vout:          c.vout,
+      Address:       c.output.Address(),
+      Amount:        c.output.Value,
+      Confirmations: c.confirmations,
+      Coinbase:      c.coinbase,
+      Spendable:     c.mature && !b.isWatchOnly(c.output.Address()),
+      WatchOnly:     b.isWatchOnly(c.output.Address()),
+    })
+  }
+  return unspent, nil
+}
+
+// Define a method to get the coins of an address, or of the wallet of the node if empty
+func (b rpcBackend) WalletBalance(addr string) (*rpc.WalletBalance, error) {
+  var addresses []string
+  if addr != "" {
+    addresses = []string{addr}
+  }
+  addresses, err := b.walletAddresses(addresses)
+  if err != nil {
+    return nil, err
+  }
+  var spendable, watchOnly []string
+  for _, addr := range addresses {
+    if b.isWatchOnly(addr) {
+      watchOnly = append(watchOnly, addr)
+    } else {
+      spendable = append(spendable, addr)
+    }
+  }
+  balance := (UTXOSet{b.n.bc}).WalletBalance(spendable)
+  view := &rpc.WalletBalance{Confirmed: balance.confirmed, Unconfirmed: balance.unconfirmed, Immature: balance.immature}
+  if len(watchOnly) > 0 { // the cold storage coins are told apart
+    watched := (UTXOSet{b.n.bc}).WalletBalance(watchOnly)
+    view.WatchOnly = &rpc.WalletBalance{Confirmed: watched.confirmed, Unconfirmed: watched.unconfirmed, Immature: watched.immature}
+  }
+  return view, nil
+}
+
+// Define a method to check if an address is watched by the wallet of the node without its key
+func (b rpcBackend) isWatchOnly(addr string) bool {
+  w, err := b.n.wallet(b.walletName, b.routed)
+  return err == nil && w.keys.IsWatchOnly(addr)
+}
+
+// Define a method to watch an address without its key, then to search the chain for its transactions if asked
+func (b rpcBackend) ImportAddress(addr string, rescan bool) error {
+  w, err := b.wallet()
+  if err != nil {
+    return err
+  }
+  w.mu.Lock() // the file is not saved while the keys are locked
+  err = w.keys.ImportAddress(addr)
+  w.mu.Unlock() // unlock the wallet
+  if err != nil {
+    return &rpc.Error{Code: rpc.CodeWalletError, Message: err.Error()}
+  }
+  if rescan {
+    w.rescanWalletTxs()
+  }
+  return nil
+}
+
+// Define a method to watch the receive addresses of an extended public key, then to search the chain for their
+// transactions if asked
+func (b rpcBackend) ImportXPub(xpub string, rescan bool) ([]string, error) {
+  w, err := b.wallet()
+  if err != nil {
+    return nil, err
+  }
+  used, err := usedOnChain(b.n.bc)
+  if err != nil { // without the address index only the first addresses are watched
+    used = func(string) bool { return false }
+  }
+  w.mu.Lock() // the file is not saved while the keys are locked
+  added, err := w.keys.ImportXPub(xpub, wallet.DefaultGapLimit, used)
+  w.mu.Unlock() // unlock the wallet
+  if err != nil {
+    return nil, &rpc.Error{Code: rpc.CodeWalletError, Message: err.Error()}
+  }
+  if rescan && len(added) > 0 {
+    w.rescanWalletTxs()
+  }
+  if added == nil {
+    added = []string{} // an empty list, not null
+  }
+  return added, nil
+}
+
+// Define a method to encrypt the keys of the wallet of the node with a passphrase
+func (b rpcBackend) EncryptWallet(passphrase string) error {
+  w, err := b.wallet()
+  if err != nil {
+    return err
+  }
+  return walletError(w.encrypt(passphrase))
+}
+
+// Define a method to unlock the keys of the encrypted wallet of the node for a number of seconds
+func (b rpcBackend) UnlockWallet(passphrase string, timeout int) error {
+  w, err := b.wallet()
+  if err != nil {
+    return err
+  }
+  return walletError(w.unlock(passphrase, timeout))
+}
+
+// Define a method to lock the keys of the encrypted wallet of the node
+func (b rpcBackend) LockWallet() error {
+  w, err := b.wallet()
+  if err != nil {
+    return err
+  }
+  return walletError(w.lock())
+}
+
+// Define a method to route the wallet methods to the wallet of a name
+func (b rpcBackend) ForWallet(name string) rpc.Backend {
+  return rpcBackend{n: b.n, walletName: name, routed: true}
+}
+
+// Define a method to create and load a named wallet, its keys encrypted with the passphrase if one is given
+func (b rpcBackend) CreateWallet(name, passphrase string) error {
+  if name == "" {
+    return &rpc.Error{Code: rpc.CodeInvalidParams, Message: "the wallet needs a name"}
+  }
+  _, err := b.n.loadWallet(name, passphrase, true)
+  return walletError(err)
+}
+
+// Define a method to load a named wallet created before
+func (b rpcBackend) LoadWallet(name string) error {
+  _, err := b.n.loadWallet(name, "", false)
+  return walletError(err)
+}
+
+// Define a method to unload a wallet, so its transactions are no longer followed
+func (b rpcBackend) UnloadWallet(name string) error {
+  if err := b.n.unloadWallet(name); err != nil {
+    return walletError(fmt.Errorf("%w: %q", err, name))
+  }
+  return nil
+}
+
+// Define a method to list the loaded wallets
+func (b rpcBackend) ListWallets() []rpc.LoadedWallet {
+  loaded := []rpc.LoadedWallet{} // an empty list, not null
+  for _, w := range b.n.loadedWallets() {
+    loaded = append(loaded, rpc.LoadedWallet{Name: w.name, Encrypted: w.keys.IsEncrypted(), Locked: w.keys.IsLocked()})
+  }
+  return loaded
+}
+
+// Define a method to get the wallet a request is routed to, the only wallet loaded when its URL names none
+func (b rpcBackend) wallet() (*nodeWallet, error) {
+  w, err := b.n.wallet(b.walletName, b.routed)
+  if err != nil {
+    return nil, walletError(err)
+  }
+  return w, nil
+}
+
+// Define a method to add a new receive address with a kind of key to the wallet of a request
+func (b rpcBackend) NewAddress(kind string) (string, error) {
+  w, err := b.wallet()
+  if err != nil {
+    return "", err
+  }
+  w.mu.Lock() // lock the wallet
+  defer w.mu.Unlock() // unlock it when done
+  addr, err := w.keys.CreateWalletOfKind(kind) // saves the file
+  if err != nil {
+    return "", walletError(err)
+  }
+  return addr, nil
+}
+
+// Define a function to turn an error of the wallet into the error of the protocol, nil if there is none
+func walletError(err error) error {
+  switch {
+  case err == nil:
+    return nil
+  case errors.Is(err, wallet.ErrLocked):
+    return &rpc.Error{Code: rpc.CodeUnlockNeeded, Message: "the wallet is locked, unlock it with walletpassphrase"}
+  case errors.Is(err, wallet.ErrWrongPassphrase):
+    return &rpc.Error{Code: rpc.CodeWrongPassphrase, Message: "the passphrase does not unlock the wallet"}
+  case errors.Is(err, wallet.ErrEncrypted), errors.Is(err, wallet.ErrNotEncrypted):
+    return &rpc.Error{Code: rpc.CodeWrongEncryption, Message: err.Error()}
+  case errors.Is(err, errWalletNotFound):
+    return &rpc.Error{Code: rpc.CodeWalletNotFound, Message: err.Error()}
+  case errors.Is(err, errWalletNotSpecified):
+    return &rpc.Error{Code: rpc.CodeWalletNotSpecified, Message: err.Error()}
+  case errors.Is(err, errWalletLoaded):
+    return &rpc.Error{Code: rpc.CodeWalletLoaded, Message: err.Error()}
+  }
+  return &rpc.Error{Code: rpc.CodeWalletError, Message: err.Error()}
+}
+
+// Define a method to check the last blocks of the chain, the reason they are corrupt is logged
+func (b rpcBackend) VerifyChain(level, blocks int) bool {
+  if level < 0 {
+    level = defaultVerifyLevel
+  }
+  if blocks < 0 {
+    blocks = defaultVerifyBlocks
+  }
+  if err := b.n.bc.VerifyChain(level, blocks); err != nil {
+    chainLog.Error("The chain is corrupt, stop the node and run reindex", "err", err)
+    return false
+  }
+  return true
+}
+
+// Define a method to estimate the fee rate a transaction needs to be mined within a number of blocks
+func (b rpcBackend) EstimateFee(target int) *rpc.FeeEstimate {
+  if target < 0 {
+    target = defaultConfTarget
+  }
+  if target > feeMaxTarget {
+    target = feeMaxTarget
+  }
+  feeRate, ok := b.n.bc.EstimateFee(target)
+  if !ok {
+    return &rpc.FeeEstimate{Blocks: target, Errors: []string{"not enough transactions were seen mined to estimate the fee rate"}}
+  }
+  return &rpc.FeeEstimate{FeeRate: feeRate, Blocks: target}
+}
+
+// Define a method to get the progress of the sync of the blocks
+func (b rpcBackend) SyncInfo() *rpc.SyncInfo {
+  return syncView(b.n.syncProgress())
+}
+
+// Define a function to build the JSON view of the progress of the sync
+func syncView(progress *SyncProgress) *rpc.SyncInfo {
+  return &rpc.SyncInfo{
+    Headers:              progress.HeadersHeight,
+    Blocks:               progress.BlocksHeight,
+    VerificationProgress: progress.Progress,
+    TimeRemaining:        progress.Remaining.Seconds(),
+    SyncPeer:             progress.Peer,
+    Synced:               progress.Synced,
+  }
+}
+
+// Define a function to build the JSON view of a reorganization
+func reorgView(reorg *ReorgEvent) *rpc.Reorg {
+  view := &rpc.Reorg{Fork: hex.EncodeToString(reorg.Fork.MyBlockHash)}
+  for _, block := range reorg.Disconnected { // iterate over the old blocks
+    view.Disconnected = append(view.Disconnected, hex.EncodeToString(block.MyBlockHash))
+  }
+  for _, block := range reorg.Connected { // iterate over the new blocks
+    view.Connected = append(view.Connected, hex.EncodeToString(block.MyBlockHash))
+  }
+  return view // return the view
+}
+
@@ -0,0 +1,367 @@
+// Package schnorr signs and verifies Schnorr signatures over secp256k1, and aggregates keys so that n signers produce
+// one signature of their aggregate key, following MuSig2.
+// A signature is the compressed nonce point R followed by the 32 bytes of s, and is valid for the key P and the hash m
+// when s*G = R + e*P with e the tagged hash of R, P and m; an aggregate signature is verified exactly like one made by a
+// single key, so a spend by all the keys of an aggregate looks like any other spend on the chain.
+//
+// Aggregating the keys P1..Pn: the keys are sorted, L is the hash of all of them, each key gets the coefficient
+// ai = H(L, Pi) and the aggregate key is the sum of the ai*Pi, so no signer can choose its key to cancel the others.
+// Signing takes two rounds: each signer draws two secret nonces and shares their points, then signs with the sum of
+// the points of everyone; the partial signatures add up to the signature of the aggregate key. A secret nonce must
+// sign once only, signing two hashes with it reveals the private key.
+package schnorr
+
+import (
+  "bytes"         // to sort and compare the keys
+  "crypto/rand"   // for the auxiliary randomness and the nonces
+  "crypto/sha256" // the tagged hashes
+  "errors"        // for the errors of the aggregation
+  "fmt"           // to format the errors
+  "sort"          // to sort the keys
+
+  "github.com/decred/dcrd/dcrec/secp256k1/v4" // the curve
+)
+
+// Define some constants for the sizes of the encodings
+const (
+  PubKeySize      = secp256k1.PubKeyBytesLenCompressed // a compressed public key
+  SignatureSize   = PubKeySize + 32                    // the compressed point R and the scalar s
+  PartialSize     = 32                                 // the scalar s of a signer of an aggregate key
+  PublicNonceSize = 2 * PubKeySize                     // the two nonce points of a signer
+  SecretNonceSize = 64                                 // the two secret nonces of a signer
+)
+
+// Define the errors of the aggregation
+var (
+  ErrInvalidKey   = errors.New("schnorr: invalid public key")
+  ErrInvalidNonce = errors.New("schnorr: invalid nonce")
+  ErrMissingNonce = errors.New("schnorr: a signer of the aggregate key has no nonce")
+  ErrNotSigner    = errors.New("schnorr: the key is not one of the aggregate key")
+  ErrBadPartial   = errors.New("schnorr: invalid partial signature")
+)
+
+// Define a function to hash data under a tag, so a hash made for one purpose is never valid for another
+func taggedHash(tag string, parts ...[]byte) [32]byte {
+  tagHash := sha256.Sum256([]byte("networkchain/schnorr/" + tag))
+  h := sha256.New()
+  h.Write(tagHash[:])
+  h.Write(tagHash[:])
+  for _, part := range parts {
+    h.Write(part)
+  }
+  var sum [32]byte
+  copy(sum[:], h.Sum(nil))
+  return sum
+}
+
+// Define a function to read a hash as a scalar, reduced modulo the order of the curve
+func hashScalar(tag string, parts ...[]byte) *secp256k1.ModNScalar {
+  sum := taggedHash(tag, parts...)
+  var s secp256k1.ModNScalar
+  s.SetBytes(&sum)
+  return &s
+}
+
+// Define a function to serialize a point in compressed form, nil for the point at infinity
+func serializePoint(p *secp256k1.JacobianPoint) []byte {
+  if (p.X.IsZero() && p.Y.IsZero()) || p.Z.IsZero() {
+    return nil
+  }
+  affine := *p
+  affine.ToAffine()
+  return secp256k1.NewPublicKey(&affine.X, &affine.Y).SerializeCompressed()
+}
+
+// Define a function to add two points into a new one, the sum must not overwrite a point being added
+func add(p1, p2 *secp256k1.JacobianPoint) secp256k1.JacobianPoint {
+  var sum secp256k1.JacobianPoint
+  secp256k1.AddNonConst(p1, p2, &sum)
+  return sum
+}
+
+// Define a function to parse a compressed point
+func parsePoint(data []byte) (*secp256k1.JacobianPoint, error) {
+  if len(data) != PubKeySize {
+    return nil, ErrInvalidKey
+  }
+  key, err := secp256k1.ParsePubKey(data)
+  if err != nil {
+    return nil, ErrInvalidKey
+  }
+  var p secp256k1.JacobianPoint
+  key.AsJacobian(&p)
+  return &p, nil
+}
+
+// Define a function to get the challenge of a signature
+func challenge(r, pubKey, hash []byte) *secp256k1.ModNScalar {
+  return hashScalar("challenge", r, pubKey, hash)
+}
+
+// Define a function to sign a hash with a private key, with a fresh nonce derived from the key, the hash and random bytes
+func Sign(key *secp256k1.PrivateKey, hash []byte) []byte {
+  pubKey := key.PubKey().SerializeCompressed()
+  var aux [32]byte
+  rand.Read(aux[:]) // a failure leaves zeros, the nonce is still unique to the key and the hash
+  secret := key.Key.Bytes()
+  k := hashScalar("nonce", secret[:], hash, aux[:])
+  for i := range secret {
+    secret[i] = 0
+  }
+  var r secp256k1.JacobianPoint
+  secp256k1.ScalarBaseMultNonConst(k, &r)
+  rBytes := serializePoint(&r)
+  e := challenge(rBytes, pubKey, hash)
+  s := new(secp256k1.ModNScalar).Mul2(e, &key.Key).Add(k) // s = k + e*x
+  k.Zero()
+  sBytes := s.Bytes()
+  return append(rBytes, sBytes[:]...)
+}
+
+// Define a function to check a signature of a hash against a compressed public key, an aggregate one included
+func Verify(pubKey, hash, signature []byte) bool {
+  if len(signature) != SignatureSize {
+    return false
+  }
+  p, err := parsePoint(pubKey)
+  if err != nil {
+    return false
+  }
+  r, err := parsePoint(signature[:PubKeySize])
+  if err != nil {
+    return false
+  }
+  var s secp256k1.ModNScalar
+  if s.SetByteSlice(signature[PubKeySize:]) { // s overflows the order
+    return false
+  }
+  e := challenge(signature[:PubKeySize], pubKey, hash)
+  var sG, eP, sum secp256k1.JacobianPoint // s*G = R + e*P
+  secp256k1.ScalarBaseMultNonConst(&s, &sG)
+  secp256k1.ScalarMultNonConst(e, p, &eP)
+  secp256k1.AddNonConst(r, &eP, &sum)
+  left, right := serializePoint(&sG), serializePoint(&sum)
+  return left != nil && bytes.Equal(left, right)
+}
+
+// Define a function to sort the keys of an aggregate key, the order every signer uses whatever the order they are given
+func SortKeys(pubKeys [][]byte) [][]byte {
+  sorted := append([][]byte{}, pubKeys...)
+  sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+  return sorted
+}
+
+// Define a function to compute the coefficients of the sorted keys of an aggregate key and the aggregate key itself
+func aggregate(sorted [][]byte) ([]*secp256k1.ModNScalar, *secp256k1.JacobianPoint, error) {
+  if len(sorted) == 0 {
+    return nil, nil, fmt.Errorf("%w: no keys to aggregate", ErrInvalidKey)
+  }
+  list := taggedHash("keys", bytes.Join(sorted, nil))
+  coefficients := make([]*secp256k1.ModNScalar, len(sorted))
+  var sum secp256k1.JacobianPoint // the point at infinity
+  for i, key := range sorted {
+    if i > 0 && bytes.Equal(key, sorted[i-1]) {
+      return nil, nil, fmt.Errorf("%w: key %x is repeated", ErrInvalidKey, key)
+    }
+    p, err := parsePoint(key)
+    if err != nil {
+      return nil, nil, fmt.Errorf("%w: %x", ErrInvalidKey, key)
+    }
+    coefficients[i] = hashScalar("coefficient", list[:], key)
+    var term secp256k1.JacobianPoint
+    secp256k1.ScalarMultNonConst(coefficients[i], p, &term)
+    sum = add(&sum, &term)
+  }
+  if serializePoint(&sum) == nil {
+    return nil, nil, fmt.Errorf("%w: the keys cancel out", ErrInvalidKey)
+  }
+  return coefficients, &sum, nil
+}
+
+// Define a function to get the compressed aggregate key of some public keys, in any order
+func AggregateKeys(pubKeys [][]byte) ([]byte, error) {
+  _, sum, err := aggregate(SortKeys(pubKeys))
+  if err != nil {
+    return nil, err
+  }
+  return serializePoint(sum), nil
+}
+
+// Define a function to draw the two secret nonces of a signer, returning them with their points to share
+func NewNonce() ([]byte, []byte, error) {
+  secret := make([]byte, 0, SecretNonceSize)
+  public := make([]byte, 0, PublicNonceSize)
+  for i := 0; i < 2; i++ {
+    k, err := secp256k1.GeneratePrivateKey() // a random scalar, never zero
+    if err != nil {
+      return nil, nil, err
+    }
+    secret = append(secret, k.Serialize()...)
+    public = append(public, k.PubKey().SerializeCompressed()...)
+    k.Zero()
+  }
+  return secret, public, nil
+}
+
+// Define a function to get the points of a secret nonce, to check it is the one a signer shared
+func PublicNonce(secret []byte) ([]byte, error) {
+  if len(secret) != SecretNonceSize {
+    return nil, ErrInvalidNonce
+  }
+  var public []byte
+  for i := 0; i < 2; i++ {
+    var k secp256k1.ModNScalar
+    if k.SetByteSlice(secret[32*i:32*(i+1)]) || k.IsZero() {
+      return nil, ErrInvalidNonce
+    }
+    public = append(public, secp256k1.NewPrivateKey(&k).PubKey().SerializeCompressed()...)
+    k.Zero()
+  }
+  return public, nil
+}
+
+// Define a struct for what the signers of an aggregate key share for one hash: the keys, their nonces and the values
+// derived from them
+type session struct {
+  keys         [][]byte                      // the sorted keys
+  coefficients []*secp256k1.ModNScalar       // the coefficient of each key
+  nonces       [][2]*secp256k1.JacobianPoint // the nonce points of each key
+  aggregateKey []byte                        // the compressed aggregate key
+  b            *secp256k1.ModNScalar         // the coefficient of the second nonces
+  r            []byte                        // the compressed nonce point of the signature
+  e            *secp256k1.ModNScalar         // the challenge of the signature
+}
+
+// Define a function to start the session of the signers of some keys for a hash, with the public nonce of each key
+// given by hex public key
+func newSession(pubKeys [][]byte, nonces map[string][]byte, hash []byte) (*session, error) {
+  s := &session{keys: SortKeys(pubKeys)}
+  coefficients, sum, err := aggregate(s.keys)
+  if err != nil {
+    return nil, err
+  }
+  s.coefficients, s.aggregateKey = coefficients, serializePoint(sum)
+  var r1, r2 secp256k1.JacobianPoint // the sums of the first and of the second nonce points
+  for _, key := range s.keys {
+    nonce, ok := nonces[fmt.Sprintf("%x", key)]
+    if !ok {
+      return nil, fmt.Errorf("%w: %x", ErrMissingNonce, key)
+    }
+    if len(nonce) != PublicNonceSize {
+      return nil, fmt.Errorf("%w: the nonce of %x", ErrInvalidNonce, key)
+    }
+    first, err := parsePoint(nonce[:PubKeySize])
+    if err != nil {
+      return nil, fmt.Errorf("%w: the nonce of %x", ErrInvalidNonce, key)
+    }
+    second, err := parsePoint(nonce[PubKeySize:])
+    if err != nil {
+      return nil, fmt.Errorf("%w: the nonce of %x", ErrInvalidNonce, key)
+    }
+    s.nonces = append(s.nonces, [2]*secp256k1.JacobianPoint{first, second})
+    r1, r2 = add(&r1, first), add(&r2, second)
+  }
+  r1Bytes, r2Bytes := serializePoint(&r1), serializePoint(&r2)
+  if r1Bytes == nil || r2Bytes == nil { // the nonces of the others cancel out the ones of a signer
+    return nil, fmt.Errorf("%w: the nonces cancel out", ErrInvalidNonce)
+  }
+  s.b = hashScalar("nonce coefficient", s.aggregateKey, r1Bytes, r2Bytes, hash)
+  var bR2, r secp256k1.JacobianPoint // R = R1 + b*R2
+  secp256k1.ScalarMultNonConst(s.b, &r2, &bR2)
+  secp256k1.AddNonConst(&r1, &bR2, &r)
+  if s.r = serializePoint(&r); s.r == nil {
+    return nil, fmt.Errorf("%w: the nonces cancel out", ErrInvalidNonce)
+  }
+  s.e = challenge(s.r, s.aggregateKey, hash)
+  return s, nil
+}
+
+// Define a method to find the index of a key among the sorted keys of a session, -1 if it is not one of them
+func (s *session) index(pubKey []byte) int {
+  for i, key := range s.keys {
+    if bytes.Equal(key, pubKey) {
+      return i
+    }
+  }
+  return -1
+}
+
+// Define a function to make the partial signature of a signer of an aggregate key: its secret nonce, whose points are
+// among the nonces given by hex public key, and its private key sign the hash together with the others
+// The secret nonce must be discarded once used
+func PartialSign(key *secp256k1.PrivateKey, secretNonce []byte, pubKeys [][]byte, nonces map[string][]byte, hash []byte) ([]byte, error) {
+  pubKey := key.PubKey().SerializeCompressed()
+  s, err := newSession(pubKeys, nonces, hash)
+  if err != nil {
+    return nil, err
+  }
+  i := s.index(pubKey)
+  if i < 0 {
+    return nil, ErrNotSigner
+  }
+  public, err := PublicNonce(secretNonce)
+  if err != nil {
+    return nil, err
+  }
+  if !bytes.Equal(public, nonces[fmt.Sprintf("%x", pubKey)]) { // the nonce shared must be the one signing
+    return nil, fmt.Errorf("%w: the secret nonce is not the one shared for %x", ErrInvalidNonce, pubKey)
+  }
+  var k1, k2 secp256k1.ModNScalar
+  k1.SetByteSlice(secretNonce[:32])
+  k2.SetByteSlice(secretNonce[32:])
+  partial := new(secp256k1.ModNScalar).Mul2(s.e, s.coefficients[i]).Mul(&key.Key) // s = k1 + b*k2 + e*a*x
+  partial.Add(k2.Mul(s.b)).Add(&k1)
+  k1.Zero()
+  k2.Zero()
+  sBytes := partial.Bytes()
+  return sBytes[:], nil
+}
+
+// Define a function to check the partial signature of a signer of an aggregate key, with the nonces given by hex public
+// key, so a signer sending a bad one is found before the signature is combined
+func VerifyPartial(pubKey, partial []byte, pubKeys [][]byte, nonces map[string][]byte, hash []byte) bool {
+  s, err := newSession(pubKeys, nonces, hash)
+  return err == nil && s.verifyPartial(pubKey, partial)
+}
+
+// Define a method to check the partial signature of a key of the session
+func (s *session) verifyPartial(pubKey, partial []byte) bool {
+  i := s.index(pubKey)
+  if i < 0 || len(partial) != PartialSize {
+    return false
+  }
+  var si secp256k1.ModNScalar
+  if si.SetByteSlice(partial) {
+    return false
+  }
+  p, _ := parsePoint(pubKey) // parsed by the session
+  var sG, bR2, eaP, sum secp256k1.JacobianPoint // s*G = R1 + b*R2 + e*a*P
+  secp256k1.ScalarBaseMultNonConst(&si, &sG)
+  secp256k1.ScalarMultNonConst(s.b, s.nonces[i][1], &bR2)
+  secp256k1.ScalarMultNonConst(new(secp256k1.ModNScalar).Mul2(s.e, s.coefficients[i]), p, &eaP)
+  sum = add(s.nonces[i][0], &bR2)
+  sum = add(&sum, &eaP)
+  left, right := serializePoint(&sG), serializePoint(&sum)
+  return left != nil && bytes.Equal(left, right)
+}
+
+// Define a function to add up the partial signatures of every signer of an aggregate key, given by hex public key with
+// their nonces, into the signature of the aggregate key
+func Combine(pubKeys [][]byte, nonces, partials map[string][]byte, hash []byte) ([]byte, error) {
+  s, err := newSession(pubKeys, nonces, hash)
+  if err != nil {
+    return nil, err
+  }
+  var sum secp256k1.ModNScalar
+  for _, key := range s.keys {
+    partial, ok := partials[fmt.Sprintf("%x", key)]
+    if !ok || !s.verifyPartial(key, partial) {
+      return nil, fmt.Errorf("%w: of %x", ErrBadPartial, key)
+    }
+    var si secp256k1.ModNScalar
+    si.SetByteSlice(partial)
+    sum.Add(&si)
+  }
+  sBytes := sum.Bytes()
+  return append(append([]byte{}, s.r...), sBytes[:]...), nil
+}
@@ -0,0 +1,158 @@
+package schnorr
+
+import (
+  "crypto/sha256" // for the hashes signed
+  "errors"        // to match the errors
+  "fmt"           // to key the nonces and the partial signatures
+  "testing"       // the test framework
+
+  "github.com/decred/dcrd/dcrec/secp256k1/v4" // the keys
+)
+
+// Define a function to make some private keys
+func newKeys(t *testing.T, n int) []*secp256k1.PrivateKey {
+  t.Helper()
+  keys := make([]*secp256k1.PrivateKey, n)
+  for i := range keys {
+    key, err := secp256k1.GeneratePrivateKey()
+    if err != nil {
+      t.Fatal(err)
+    }
+    keys[i] = key
+  }
+  return keys
+}
+
+// Define a function to flip a bit of a copy of some bytes
+func flipped(b []byte, bit int) []byte {
+  c := append([]byte{}, b...)
+  c[bit/8] ^= 1 << (bit % 8)
+  return c
+}
+
+func TestSignVerify(t *testing.T) {
+  keys := newKeys(t, 2)
+  hash := sha256.Sum256([]byte("schnorr"))
+  other := sha256.Sum256([]byte("other"))
+  pubKey := keys[0].PubKey().SerializeCompressed()
+  signature := Sign(keys[0], hash[:])
+  tests := []struct {
+    name      string
+    pubKey    []byte
+    hash      []byte
+    signature []byte
+    valid     bool
+  }{
+    {"valid", pubKey, hash[:], signature, true},
+    {"other hash", pubKey, other[:], signature, false},
+    {"other key", keys[1].PubKey().SerializeCompressed(), hash[:], signature, false},
+    {"flipped R", pubKey, hash[:], flipped(signature, 20), false},
+    {"flipped s", pubKey, hash[:], flipped(signature, 8*PubKeySize+5), false},
+    {"short signature", pubKey, hash[:], signature[:SignatureSize-1], false},
+    {"invalid key", []byte{2}, hash[:], signature, false},
+  }
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      if got := Verify(test.pubKey, test.hash, test.signature); got != test.valid {
+        t.Errorf("Verify = %v, want %v", got, test.valid)
+      }
+    })
+  }
+}
+
+// Define a function to run the two rounds of MuSig2 for the keys signing a hash, returning the signature of the
+// aggregate key of all of them
+func muSig(t *testing.T, signers []*secp256k1.PrivateKey, pubKeys [][]byte, hash []byte) ([]byte, error) {
+  t.Helper()
+  secrets := map[string][]byte{}
+  nonces := map[string][]byte{}
+  for _, key := range signers { // first round, every signer shares its nonce
+    secret, public, err := NewNonce()
+    if err != nil {
+      t.Fatal(err)
+    }
+    id := fmt.Sprintf("%x", key.PubKey().SerializeCompressed())
+    secrets[id], nonces[id] = secret, public
+  }
+  partials := map[string][]byte{}
+  for _, key := range signers { // second round, every signer signs with the nonces of all
+    id := fmt.Sprintf("%x", key.PubKey().SerializeCompressed())
+    partial, err := PartialSign(key, secrets[id], pubKeys, nonces, hash)
+    if err != nil {
+      return nil, err
+    }
+    if !VerifyPartial(key.PubKey().SerializeCompressed(), partial, pubKeys, nonces, hash) {
+      t.Fatalf("the partial signature of %s is invalid", id)
+    }
+    partials[id] = partial
+  }
+  return Combine(pubKeys, nonces, partials, hash)
+}
+
+func TestMuSig2(t *testing.T) {
+  keys := newKeys(t, 4)
+  hash := sha256.Sum256([]byte("musig2"))
+  var pubKeys [][]byte
+  for _, key := range keys[:3] {
+    pubKeys = append(pubKeys, key.PubKey().SerializeCompressed())
+  }
+  tests := []struct {
+    name    string
+    signers []*secp256k1.PrivateKey
+    err     error // the error of the signing, nil when it succeeds
+  }{
+    {"every signer", keys[:3], nil},
+    {"signers in another order", []*secp256k1.PrivateKey{keys[2], keys[0], keys[1]}, nil},
+    {"a missing signer", keys[:2], ErrMissingNonce},
+    {"a signer outside the key", []*secp256k1.PrivateKey{keys[0], keys[1], keys[2], keys[3]}, ErrNotSigner},
+  }
+  aggregate, err := AggregateKeys(pubKeys)
+  if err != nil {
+    t.Fatal(err)
+  }
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      signature, err := muSig(t, test.signers, pubKeys, hash[:])
+      if !errors.Is(err, test.err) {
+        t.Fatalf("err = %v, want %v", err, test.err)
+      }
+      if err != nil {
+        return
+      }
+      if !Verify(aggregate, hash[:], signature) {
+        t.Error("the signature of the aggregate key is invalid")
+      }
+    })
+  }
+}
+
+func TestAggregateKeys(t *testing.T) {
+  keys := newKeys(t, 2)
+  a, b := keys[0].PubKey().SerializeCompressed(), keys[1].PubKey().SerializeCompressed()
+  ab, err := AggregateKeys([][]byte{a, b})
+  if err != nil {
+    t.Fatal(err)
+  }
+  ba, err := AggregateKeys([][]byte{b, a})
+  if err != nil {
+    t.Fatal(err)
+  }
+  if fmt.Sprintf("%x", ab) != fmt.Sprintf("%x", ba) {
+    t.Error("the aggregate key depends on the order of the keys")
+  }
+  tests := []struct {
+    name string
+    keys [][]byte
+  }{
+    {"no keys", nil},
+    {"a repeated key", [][]byte{a, a}},
+    {"an invalid key", [][]byte{a, {2, 1}}},
+  }
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      if _, err := AggregateKeys(test.keys); !errors.Is(err, ErrInvalidKey) {
+        t.Errorf("err = %v, want %v", err, ErrInvalidKey)
+      }
+    })
+  }
+}
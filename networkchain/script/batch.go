@@ -0,0 +1,48 @@
+package script
+
+import (
+  "networkchain/ed25519batch" // the signatures are verified together
+)
+
+// Define a struct for an Ed25519 signature of a batch
+type batchEntry struct {
+  pubKey, signature, hash []byte
+}
+
+// Define a struct for the Ed25519 signatures met while running scripts, left to be verified together once the scripts ran
+// A script takes them as valid meanwhile, so its result only stands once Verify says they are
+type Ed25519Batch struct {
+  entries []batchEntry // the signatures, in the order the scripts met them
+}
+
+// Define a method to leave a signature of a hash in the batch
+func (b *Ed25519Batch) add(pubKey, signature, hash []byte) {
+  b.entries = append(b.entries, batchEntry{pubKey, signature, hash})
+}
+
+// Define a method to get the number of signatures in the batch
+func (b *Ed25519Batch) Len() int {
+  return len(b.entries)
+}
+
+// Define a method to move the signatures of another batch into this one
+func (b *Ed25519Batch) Append(other *Ed25519Batch) {
+  b.entries = append(b.entries, other.entries...)
+  other.entries = nil
+}
+
+// Define a method to verify every signature of the batch at once, they are remembered in the cache when they are all
+// valid; false means one at least is not, the scripts that met them must run again without a batch to find it
+func (b *Ed25519Batch) Verify(cache *SigCache) bool {
+  var verifier ed25519batch.Verifier
+  for _, e := range b.entries {
+    verifier.Add(e.pubKey, e.hash, e.signature)
+  }
+  if !verifier.Verify() {
+    return false
+  }
+  for _, e := range b.entries {
+    cache.Add(e.pubKey, e.signature, e.hash)
+  }
+  return true
+}
@@ -0,0 +1,66 @@
+package script
+
+import (
+  "crypto/ed25519" // the keys signing
+  "crypto/sha256"  // for the hashes signed
+  "testing"        // the test framework
+)
+
+func TestEd25519Batch(t *testing.T) {
+  public, private, err := ed25519.GenerateKey(nil)
+  if err != nil {
+    t.Fatal(err)
+  }
+  hash := sha256.Sum256([]byte("batch"))
+  other := sha256.Sum256([]byte("other"))
+  scriptPubKey := PayToEd25519PubKeyHash(Hash160(public))
+  tests := []struct {
+    name    string
+    signed  []byte // the hash the signature is made for
+    cached  bool   // whether the signature is in the cache already
+    batched int    // the signatures left in the batch
+    valid   bool   // what the batch and the script without a batch say
+  }{
+    {"valid", hash[:], false, 1, true},
+    {"invalid", other[:], false, 1, false},
+    {"cached", hash[:], true, 0, true},
+  }
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      signature := ed25519.Sign(private, test.signed)
+      scriptSig := NewBuilder().AddData(signature, public).Script()
+      cache := NewSigCache(10)
+      if test.cached {
+        cache.Add(public, signature, hash[:])
+      }
+      var batch Ed25519Batch
+      if err := Verify(scriptSig, scriptPubKey, &Context{SigHash: hash[:], SigCache: cache, Batch: &batch}); err != nil {
+        t.Fatalf("the script fails with a batch: %v", err) // the signature is taken as valid until the batch is verified
+      }
+      if batch.Len() != test.batched {
+        t.Fatalf("%d signatures in the batch, want %d", batch.Len(), test.batched)
+      }
+      if got := batch.Verify(cache); got != test.valid {
+        t.Errorf("batch Verify = %v, want %v", got, test.valid)
+      }
+      if got := cache.Exists(public, signature, hash[:]); got != test.valid {
+        t.Errorf("cached = %v, want %v", got, test.valid)
+      }
+      err := Verify(scriptSig, scriptPubKey, &Context{SigHash: hash[:]})
+      if got := err == nil; got != test.valid {
+        t.Errorf("the script without a batch is valid = %v, want %v: %v", got, test.valid, err)
+      }
+    })
+  }
+}
+
+func TestEd25519BatchAppend(t *testing.T) {
+  var a, b Ed25519Batch
+  a.add([]byte("key"), []byte("signature"), []byte("hash"))
+  b.add([]byte("key"), []byte("signature"), []byte("hash"))
+  b.add([]byte("key"), []byte("signature"), []byte("hash"))
+  a.Append(&b)
+  if a.Len() != 3 || b.Len() != 0 {
+    t.Errorf("after Append the batches hold %d and %d signatures, want 3 and 0", a.Len(), b.Len())
+  }
+}
@@ -0,0 +1,369 @@
+package script
+
+import (
+  "bytes"                     // to compare the items
+  "crypto/sha256"             // for OP_SHA256
+  "errors"                    // for the errors
+  "fmt"                       // to format the errors
+  "networkchain/ed25519batch" // for OP_CHECKSIGED25519
+  "networkchain/schnorr"      // for OP_CHECKSCHNORR
+
+  "github.com/decred/dcrd/dcrec/secp256k1/v4"       // the curve of the keys
+  "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa" // the signatures
+)
+
+// The lock times below are block heights, the ones from it on are unix timestamps
+const LockTimeThreshold = 500000000
+
+// Define the errors of the execution
+var (
+  ErrStackUnderflow      = errors.New("script: not enough items on the stack")
+  ErrStackSize           = fmt.Errorf("script: more than %d items on the stack", MaxStackSize)
+  ErrBadOpcode           = errors.New("script: unknown opcode")
+  ErrReturn              = errors.New("script: OP_RETURN, the output cannot be spent")
+  ErrVerify              = errors.New("script: the verified item is false")
+  ErrEvalFalse           = errors.New("script: the script did not leave true on the stack")
+  ErrNotEnoughSignatures = errors.New("script: not enough signatures for the multisig script")
+  ErrBadMultisig         = errors.New("script: invalid OP_CHECKMULTISIG counts")
+  ErrNegativeLockTime    = errors.New("script: negative lock time")
+  ErrLockTimeKind        = errors.New("script: the lock times mix a height and a timestamp")
+  ErrLockTimeNotReached  = errors.New("script: the lock time of the transaction is before the required one")
+)
+
+// Define a struct for what the scripts of an input know of the transaction spending the output
+type Context struct {
+  SigHash  []byte        // the hash the signatures of the input sign
+  LockTime int64         // the lock time of the transaction, checked by OP_CHECKLOCKTIMEVERIFY
+  SigCache *SigCache     // the signatures found valid before, nil verifies every one
+  Batch    *Ed25519Batch // the Ed25519 signatures left to verify together once the scripts ran, nil verifies each at once
+}
+
+// Define a type for the stack of the interpreter, the top is the last item
+type stack [][]byte
+
+// Define a method to push an item
+func (s *stack) push(item []byte) error {
+  if len(*s) >= MaxStackSize {
+    return ErrStackSize
+  }
+  *s = append(*s, item)
+  return nil
+}
+
+// Define a method to remove and return the top item
+func (s *stack) pop() ([]byte, error) {
+  if len(*s) == 0 {
+    return nil, ErrStackUnderflow
+  }
+  item := (*s)[len(*s)-1]
+  *s = (*s)[:len(*s)-1]
+  return item, nil
+}
+
+// Define a method to remove the top item and read it as a number
+func (s *stack) popNumber() (int64, error) {
+  item, err := s.pop()
+  if err != nil {
+    return 0, err
+  }
+  return decodeNumber(item, maxNumberLen)
+}
+
+// Define a method to push a boolean
+func (s *stack) pushBool(value bool) error {
+  if value {
+    return s.push([]byte{1})
+  }
+  return s.push([]byte{})
+}
+
+// Define a function to read an item as a boolean: false is zero, negative zero included
+func asBool(item []byte) bool {
+  for i, b := range item {
+    if b != 0 && !(i == len(item)-1 && b == 0x80) {
+      return true
+    }
+  }
+  return false
+}
+
+// Define a function to check that an unlocking script satisfies the locking script of the output it spends
+// The unlocking script may only push data; when the locking script pays to a script hash, the last item
+// pushed is that script and it runs in turn on the other items
+func Verify(scriptSig, scriptPubKey []byte, ctx *Context) error {
+  if _, err := PushedData(scriptSig); err != nil {
+    return err
+  }
+  var s stack
+  if err := execute(scriptSig, &s, ctx); err != nil {
+    return err
+  }
+  unlocked := append(stack{}, s...) // the items the redeem script of a script hash runs on
+  if err := execute(scriptPubKey, &s, ctx); err != nil {
+    return err
+  }
+  if len(s) == 0 || !asBool(s[len(s)-1]) {
+    return ErrEvalFalse
+  }
+  if !IsPayToScriptHash(scriptPubKey) {
+    return nil
+  }
+  redeem, err := unlocked.pop() // the hash of the script was checked above
+  if err != nil {
+    return err
+  }
+  if err := execute(redeem, &unlocked, ctx); err != nil {
+    return err
+  }
+  if len(unlocked) == 0 || !asBool(unlocked[len(unlocked)-1]) {
+    return ErrEvalFalse
+  }
+  return nil
+}
+
+// Define a function to run a script on a stack
+func execute(script []byte, s *stack, ctx *Context) error {
+  instructions, err := parse(script)
+  if err != nil {
+    return err
+  }
+  for _, ins := range instructions {
+    if err := step(ins, s, ctx); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// Define a function to run one instruction
+func step(ins instruction, s *stack, ctx *Context) error {
+  if ins.data != nil { // a push
+    return s.push(ins.data)
+  }
+  if isSmallInt(ins.op) {
+    return s.push(encodeNumber(int64(ins.op - OP_1 + 1)))
+  }
+  switch ins.op {
+  case OP_1NEGATE:
+    return s.push(encodeNumber(-1))
+  case OP_NOP:
+    return nil
+  case OP_RETURN:
+    return ErrReturn
+  case OP_VERIFY:
+    return verify(s, ErrVerify)
+  case OP_DROP:
+    _, err := s.pop()
+    return err
+  case OP_DUP:
+    if len(*s) == 0 {
+      return ErrStackUnderflow
+    }
+    return s.push((*s)[len(*s)-1])
+  case OP_EQUAL, OP_EQUALVERIFY:
+    a, err := s.pop()
+    if err != nil {
+      return err
+    }
+    b, err := s.pop()
+    if err != nil {
+      return err
+    }
+    if err := s.pushBool(bytes.Equal(a, b)); err != nil {
+      return err
+    }
+    if ins.op == OP_EQUALVERIFY {
+      return verify(s, ErrVerify)
+    }
+    return nil
+  case OP_SHA256, OP_HASH160:
+    item, err := s.pop()
+    if err != nil {
+      return err
+    }
+    if ins.op == OP_HASH160 {
+      return s.push(Hash160(item))
+    }
+    hash := sha256.Sum256(item)
+    return s.push(hash[:])
+  case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+    pubKey, err := s.pop()
+    if err != nil {
+      return err
+    }
+    signature, err := s.pop()
+    if err != nil {
+      return err
+    }
+    if err := s.pushBool(checkSignature(pubKey, signature, ctx)); err != nil {
+      return err
+    }
+    if ins.op == OP_CHECKSIGVERIFY {
+      return verify(s, ErrVerify)
+    }
+    return nil
+  case OP_CHECKSIGED25519:
+    pubKey, err := s.pop()
+    if err != nil {
+      return err
+    }
+    signature, err := s.pop()
+    if err != nil {
+      return err
+    }
+    return s.pushBool(checkEd25519Signature(pubKey, signature, ctx))
+  case OP_CHECKSCHNORR:
+    pubKey, err := s.pop()
+    if err != nil {
+      return err
+    }
+    signature, err := s.pop()
+    if err != nil {
+      return err
+    }
+    return s.pushBool(checkSchnorrSignature(pubKey, signature, ctx))
+  case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+    if err := checkMultisig(s, ctx); err != nil {
+      return err
+    }
+    if ins.op == OP_CHECKMULTISIGVERIFY {
+      return verify(s, ErrVerify)
+    }
+    return nil
+  case OP_CHECKLOCKTIMEVERIFY:
+    return checkLockTime(s, ctx)
+  }
+  return fmt.Errorf("%w %#02x", ErrBadOpcode, ins.op)
+}
+
+// Define a function to remove the top item, failing with an error unless it is true
+func verify(s *stack, failure error) error {
+  item, err := s.pop()
+  if err != nil {
+    return err
+  }
+  if !asBool(item) {
+    return failure
+  }
+  return nil
+}
+
+// Define a function to check a DER encoded signature of the hash of the context against a compressed public key,
+// items that are not a key or a signature make it false; a signature found valid before is not verified again
+func checkSignature(pubKey, signature []byte, ctx *Context) bool {
+  if ctx.SigCache.Exists(pubKey, signature, ctx.SigHash) {
+    return true
+  }
+  key, err := secp256k1.ParsePubKey(pubKey)
+  if err != nil {
+    return false
+  }
+  sig, err := ecdsa.ParseDERSignature(signature)
+  if err != nil {
+    return false
+  }
+  if !sig.Verify(ctx.SigHash, key) {
+    return false
+  }
+  ctx.SigCache.Add(pubKey, signature, ctx.SigHash)
+  return true
+}
+
+// Define a function to check an Ed25519 signature of the hash of the context against an Ed25519 public key, items of
+// other lengths make it false; a signature found valid before is not verified again, and with a batch in the context it
+// is taken as valid and left in the batch
+// Both paths check the cofactored equation of ZIP 215 from ed25519batch, not crypto/ed25519.Verify: it also accepts the
+// signatures whose R or key carries a point of small order, so a signature is valid alone exactly when it is in a batch
+func checkEd25519Signature(pubKey, signature []byte, ctx *Context) bool {
+  if len(pubKey) != ed25519batch.PublicKeySize || len(signature) != ed25519batch.SignatureSize {
+    return false
+  }
+  if ctx.SigCache.Exists(pubKey, signature, ctx.SigHash) {
+    return true
+  }
+  if ctx.Batch != nil {
+    ctx.Batch.add(pubKey, signature, ctx.SigHash)
+    return true
+  }
+  if !ed25519batch.Verify(pubKey, ctx.SigHash, signature) { // the rule of the batches, see above
+    return false
+  }
+  ctx.SigCache.Add(pubKey, signature, ctx.SigHash)
+  return true
+}
+
+// Define a function to check a Schnorr signature of the hash of the context against a compressed public key, the
+// aggregate key of several signers included; a signature found valid before is not verified again
+func checkSchnorrSignature(pubKey, signature []byte, ctx *Context) bool {
+  if ctx.SigCache.Exists(pubKey, signature, ctx.SigHash) {
+    return true
+  }
+  if !schnorr.Verify(pubKey, ctx.SigHash, signature) {
+    return false
+  }
+  ctx.SigCache.Add(pubKey, signature, ctx.SigHash)
+  return true
+}
+
+// Define a function to run OP_CHECKMULTISIG on the stack: <signatures...> M <keys...> N
+// The M signatures must be in the order of their keys, each key signs at most once
+func checkMultisig(s *stack, ctx *Context) error {
+  keyCount, err := s.popNumber()
+  if err != nil {
+    return err
+  }
+  if keyCount < 0 || keyCount > MaxMultisigKeys || int(keyCount) > len(*s) {
+    return fmt.Errorf("%w: %d keys", ErrBadMultisig, keyCount)
+  }
+  keys := make([][]byte, keyCount)
+  for i := len(keys) - 1; i >= 0; i-- { // the last key is on top
+    keys[i], _ = s.pop()
+  }
+  required, err := s.popNumber()
+  if err != nil {
+    return err
+  }
+  if required < 0 || required > keyCount {
+    return fmt.Errorf("%w: %d signatures out of %d keys", ErrBadMultisig, required, keyCount)
+  }
+  if int(required) > len(*s) { // the other signers have not signed yet
+    return fmt.Errorf("%w: %d of %d", ErrNotEnoughSignatures, len(*s), required)
+  }
+  signatures := make([][]byte, required)
+  for i := len(signatures) - 1; i >= 0; i-- {
+    signatures[i], _ = s.pop()
+  }
+  valid := true
+  for sig, key := 0, 0; sig < len(signatures); key++ { // try the keys in order against each signature
+    if len(keys)-key < len(signatures)-sig { // not enough keys left for the signatures left
+      valid = false
+      break
+    }
+    if checkSignature(keys[key], signatures[sig], ctx) {
+      sig++
+    }
+  }
+  return s.pushBool(valid)
+}
+
+// Define a function to run OP_CHECKLOCKTIMEVERIFY: the lock time of the transaction must be at least the
+// top item, both heights or both timestamps, so the output cannot be spent before that height or time
+func checkLockTime(s *stack, ctx *Context) error {
+  if len(*s) == 0 {
+    return ErrStackUnderflow
+  }
+  required, err := decodeNumber((*s)[len(*s)-1], maxNumberLen+1) // timestamps need five bytes
+  if err != nil {
+    return err
+  }
+  if required < 0 {
+    return ErrNegativeLockTime
+  }
+  if (required < LockTimeThreshold) != (ctx.LockTime < LockTimeThreshold) {
+    return ErrLockTimeKind
+  }
+  if required > ctx.LockTime {
+    return fmt.Errorf("%w: %d, the transaction has %d", ErrLockTimeNotReached, required, ctx.LockTime)
+  }
+  return nil
+}
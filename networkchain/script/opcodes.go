@@ -0,0 +1,65 @@
+package script
+
+// Define the opcodes understood by the interpreter, a byte each, with the values of bitcoin
+// The bytes 0x01 to 0x4b push the next 1 to 75 bytes of the script
+const (
+  OP_0                   = byte(0x00) // push an empty item, false
+  OP_PUSHDATA1           = byte(0x4c) // push the number of bytes given by the next byte
+  OP_PUSHDATA2           = byte(0x4d) // push the number of bytes given by the next two bytes, little endian
+  OP_1NEGATE             = byte(0x4f) // push the number -1
+  OP_1                   = byte(0x51) // push the number 1, true
+  OP_16                  = byte(0x60) // push the number 16, the numbers 2 to 15 are between OP_1 and OP_16
+  OP_NOP                 = byte(0x61) // do nothing
+  OP_VERIFY              = byte(0x69) // fail unless the top item is true, removing it
+  OP_RETURN              = byte(0x6a) // fail, the output can never be spent
+  OP_DROP                = byte(0x75) // remove the top item
+  OP_DUP                 = byte(0x76) // duplicate the top item
+  OP_EQUAL               = byte(0x87) // replace the two top items with whether they are equal
+  OP_EQUALVERIFY         = byte(0x88) // OP_EQUAL then OP_VERIFY
+  OP_SHA256              = byte(0xa8) // replace the top item with its SHA256
+  OP_HASH160             = byte(0xa9) // replace the top item with its RIPEMD160(SHA256)
+  OP_CHECKSIG            = byte(0xac) // replace a signature and a public key with whether the signature is valid
+  OP_CHECKSIGVERIFY      = byte(0xad) // OP_CHECKSIG then OP_VERIFY
+  OP_CHECKMULTISIG       = byte(0xae) // replace M signatures and N public keys with whether the signatures are valid
+  OP_CHECKMULTISIGVERIFY = byte(0xaf) // OP_CHECKMULTISIG then OP_VERIFY
+  OP_CHECKLOCKTIMEVERIFY = byte(0xb1) // fail unless the lock time of the transaction reached the top item, kept on the stack
+  OP_CHECKSIGED25519     = byte(0xb3) // OP_CHECKSIG for an Ed25519 signature and public key, in place of OP_NOP4 of bitcoin
+  OP_CHECKSCHNORR        = byte(0xb4) // OP_CHECKSIG for a Schnorr signature of a key or of an aggregate key, in place of OP_NOP5
+)
+
+// The names of the opcodes, for the disassembly
+var opcodeNames = map[byte]string{
+  OP_0:                   "OP_0",
+  OP_PUSHDATA1:           "OP_PUSHDATA1",
+  OP_PUSHDATA2:           "OP_PUSHDATA2",
+  OP_1NEGATE:             "OP_1NEGATE",
+  OP_NOP:                 "OP_NOP",
+  OP_VERIFY:              "OP_VERIFY",
+  OP_RETURN:              "OP_RETURN",
+  OP_DROP:                "OP_DROP",
+  OP_DUP:                 "OP_DUP",
+  OP_EQUAL:               "OP_EQUAL",
+  OP_EQUALVERIFY:         "OP_EQUALVERIFY",
+  OP_SHA256:              "OP_SHA256",
+  OP_HASH160:             "OP_HASH160",
+  OP_CHECKSIG:            "OP_CHECKSIG",
+  OP_CHECKSIGVERIFY:      "OP_CHECKSIGVERIFY",
+  OP_CHECKMULTISIG:       "OP_CHECKMULTISIG",
+  OP_CHECKMULTISIGVERIFY: "OP_CHECKMULTISIGVERIFY",
+  OP_CHECKLOCKTIMEVERIFY: "OP_CHECKLOCKTIMEVERIFY",
+  OP_CHECKSIGED25519:     "OP_CHECKSIGED25519",
+  OP_CHECKSCHNORR:        "OP_CHECKSCHNORR",
+}
+
+// Define a function to tell if an opcode pushes a small number, OP_1 to OP_16
+func isSmallInt(op byte) bool {
+  return op >= OP_1 && op <= OP_16
+}
+
+// Define a function to get the opcode pushing a small number, 0 to 16
+func SmallIntOp(n int) byte {
+  if n == 0 {
+    return OP_0
+  }
+  return OP_1 + byte(n-1)
+}
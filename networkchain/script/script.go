@@ -0,0 +1,410 @@
+// Package script holds the small stack language locking the outputs of the transactions.
+// An output carries a locking script and the input spending it an unlocking script; the input
+// pushes data, the signatures and keys, then the locking script runs on it and must leave true.
+// The standard scripts pay to the hash of a public key or to the hash of a script, like a multisig,
+// and new spend conditions only need new scripts, not new validation code.
+package script
+
+import (
+  "bytes"                // to build the scripts
+  "crypto/sha256"        // for the hashes of the scripts and the keys
+  "encoding/binary"      // for the lengths of the pushes
+  "encoding/hex"         // to disassemble the pushed data
+  "errors"               // for the errors
+  "fmt"                  // to format the errors
+  "networkchain/address" // the standard scripts pay to addresses
+  "strings"              // to join the disassembly
+
+  "golang.org/x/crypto/ripemd160" // the second hash of the addresses
+)
+
+// Define some constants for the limits of the scripts
+const (
+  MaxScriptSize   = 10000 // the longest script, in bytes
+  MaxPushSize     = 520   // the longest item pushed on the stack
+  MaxStackSize    = 1000  // the most items on the stack
+  MaxMultisigKeys = 20    // the most keys of an OP_CHECKMULTISIG
+  maxNumberLen    = 4     // the longest number read from the stack, in bytes
+)
+
+// Define the errors of the parsing
+var (
+  ErrMalformed   = errors.New("script: malformed script")
+  ErrScriptSize  = fmt.Errorf("script: the script is longer than %d bytes", MaxScriptSize)
+  ErrPushSize    = fmt.Errorf("script: an item is longer than %d bytes", MaxPushSize)
+  ErrNotPushOnly = errors.New("script: the unlocking script does more than pushing data")
+  ErrNotStandard = errors.New("script: not a standard script")
+)
+
+// Define a struct for an instruction of a script: an opcode and the data it pushes
+type instruction struct {
+  op   byte   // the opcode
+  data []byte // the pushed data, nil for the opcodes pushing nothing
+}
+
+// Define a function to split a script into its instructions
+func parse(script []byte) ([]instruction, error) {
+  if len(script) > MaxScriptSize {
+    return nil, ErrScriptSize
+  }
+  var instructions []instruction
+  for i := 0; i < len(script); {
+    op := script[i]
+    i++
+    size := -1 // the number of bytes pushed, -1 if the opcode pushes nothing from the script
+    switch {
+    case op == OP_0:
+      size = 0
+    case op < OP_PUSHDATA1: // the opcode is the length
+      size = int(op)
+    case op == OP_PUSHDATA1:
+      if i+1 > len(script) {
+        return nil, ErrMalformed
+      }
+      size, i = int(script[i]), i+1
+    case op == OP_PUSHDATA2:
+      if i+2 > len(script) {
+        return nil, ErrMalformed
+      }
+      size, i = int(binary.LittleEndian.Uint16(script[i:])), i+2
+    }
+    if size < 0 {
+      instructions = append(instructions, instruction{op, nil})
+      continue
+    }
+    if i+size > len(script) { // the push goes past the end
+      return nil, ErrMalformed
+    }
+    if size > MaxPushSize {
+      return nil, ErrPushSize
+    }
+    instructions = append(instructions, instruction{op, script[i : i+size]})
+    i += size
+  }
+  return instructions, nil
+}
+
+// Define a function to check that a script is well formed
+func Check(script []byte) error {
+  _, err := parse(script)
+  return err
+}
+
+// Define a function to get the data pushed by a script made only of pushes
+func PushedData(script []byte) ([][]byte, error) {
+  instructions, err := parse(script)
+  if err != nil {
+    return nil, err
+  }
+  var pushed [][]byte
+  for _, ins := range instructions {
+    if ins.data == nil {
+      return nil, ErrNotPushOnly
+    }
+    pushed = append(pushed, ins.data)
+  }
+  return pushed, nil
+}
+
+// Define a struct to build a script one instruction at a time
+type Builder struct {
+  script bytes.Buffer // the script built so far
+}
+
+// Define a function to create a builder with an empty script
+func NewBuilder() *Builder {
+  return &Builder{}
+}
+
+// Define a method to add opcodes
+func (b *Builder) AddOp(ops ...byte) *Builder {
+  b.script.Write(ops)
+  return b
+}
+
+// Define a method to add pushes of data, each with the shortest push opcode
+func (b *Builder) AddData(items ...[]byte) *Builder {
+  for _, data := range items {
+    switch {
+    case len(data) < int(OP_PUSHDATA1): // OP_0 for empty data
+      b.script.WriteByte(byte(len(data)))
+    case len(data) <= 0xff:
+      b.script.Write([]byte{OP_PUSHDATA1, byte(len(data))})
+    default:
+      b.script.WriteByte(OP_PUSHDATA2)
+      binary.Write(&b.script, binary.LittleEndian, uint16(len(data)))
+    }
+    b.script.Write(data)
+  }
+  return b
+}
+
+// Define a method to add the push of a number, with the small number opcodes when they fit
+func (b *Builder) AddInt(n int64) *Builder {
+  switch {
+  case n == -1:
+    return b.AddOp(OP_1NEGATE)
+  case n >= 0 && n <= 16:
+    return b.AddOp(SmallIntOp(int(n)))
+  }
+  return b.AddData(encodeNumber(n))
+}
+
+// Define a method to get the script
+func (b *Builder) Script() []byte {
+  return append([]byte{}, b.script.Bytes()...)
+}
+
+// Define a function to hash data like the addresses: RIPEMD160(SHA256(data))
+func Hash160(data []byte) []byte {
+  first := sha256.Sum256(data)
+  hasher := ripemd160.New()
+  hasher.Write(first[:]) // writing to a hash never fails
+  return hasher.Sum(nil)
+}
+
+// Define a function to build the script paying to the hash of a public key:
+// OP_DUP OP_HASH160 <hash> OP_EQUALVERIFY OP_CHECKSIG, unlocked with <signature> <public key>
+func PayToPubKeyHash(pubKeyHash []byte) []byte {
+  return NewBuilder().AddOp(OP_DUP, OP_HASH160).AddData(pubKeyHash).AddOp(OP_EQUALVERIFY, OP_CHECKSIG).Script()
+}
+
+// Define a function to build the script paying to the hash of an Ed25519 public key:
+// OP_DUP OP_HASH160 <hash> OP_EQUALVERIFY OP_CHECKSIGED25519, unlocked with <signature> <public key>
+func PayToEd25519PubKeyHash(pubKeyHash []byte) []byte {
+  return NewBuilder().AddOp(OP_DUP, OP_HASH160).AddData(pubKeyHash).AddOp(OP_EQUALVERIFY, OP_CHECKSIGED25519).Script()
+}
+
+// Define a function to build the script paying to the hash of a Schnorr public key:
+// OP_DUP OP_HASH160 <hash> OP_EQUALVERIFY OP_CHECKSCHNORR, unlocked with <signature> <public key>
+func PayToSchnorrPubKeyHash(pubKeyHash []byte) []byte {
+  return NewBuilder().AddOp(OP_DUP, OP_HASH160).AddData(pubKeyHash).AddOp(OP_EQUALVERIFY, OP_CHECKSCHNORR).Script()
+}
+
+// Define a function to build the script paying to the hash of a script: OP_HASH160 <hash> OP_EQUAL,
+// unlocked with the pushes unlocking the script followed by the script itself
+func PayToScriptHash(scriptHash []byte) []byte {
+  return NewBuilder().AddOp(OP_HASH160).AddData(scriptHash).AddOp(OP_EQUAL).Script()
+}
+
+// Define a function to build the script paying to an address
+func PayToAddress(addr string) ([]byte, error) {
+  version, hash, err := address.Decode(addr)
+  if err != nil {
+    return nil, err
+  }
+  switch version {
+  case address.ScriptHashVersion:
+    return PayToScriptHash(hash), nil
+  case address.Ed25519PubKeyHashVersion:
+    return PayToEd25519PubKeyHash(hash), nil
+  case address.SchnorrPubKeyHashVersion:
+    return PayToSchnorrPubKeyHash(hash), nil
+  }
+  return PayToPubKeyHash(hash), nil
+}
+
+// Define a function to tell if a script pays to the hash of a public key
+func IsPayToPubKeyHash(script []byte) bool {
+  return len(script) == 25 && script[0] == OP_DUP && script[1] == OP_HASH160 && script[2] == address.PubKeyHashLen &&
+    script[23] == OP_EQUALVERIFY && script[24] == OP_CHECKSIG
+}
+
+// Define a function to tell if a script pays to the hash of an Ed25519 public key
+func IsPayToEd25519PubKeyHash(script []byte) bool {
+  return len(script) == 25 && script[0] == OP_DUP && script[1] == OP_HASH160 && script[2] == address.PubKeyHashLen &&
+    script[23] == OP_EQUALVERIFY && script[24] == OP_CHECKSIGED25519
+}
+
+// Define a function to tell if a script pays to the hash of a Schnorr public key
+func IsPayToSchnorrPubKeyHash(script []byte) bool {
+  return len(script) == 25 && script[0] == OP_DUP && script[1] == OP_HASH160 && script[2] == address.PubKeyHashLen &&
+    script[23] == OP_EQUALVERIFY && script[24] == OP_CHECKSCHNORR
+}
+
+// Define a function to tell if a script pays to the hash of a script
+func IsPayToScriptHash(script []byte) bool {
+  return len(script) == 23 && script[0] == OP_HASH160 && script[1] == address.PubKeyHashLen && script[22] == OP_EQUAL
+}
+
+// Define a function to get the address a standard script pays to, empty for the other scripts
+func ExtractAddress(script []byte) string {
+  switch {
+  case IsPayToPubKeyHash(script):
+    return address.FromPubKeyHash(script[3:23])
+  case IsPayToEd25519PubKeyHash(script):
+    return address.FromEd25519PubKeyHash(script[3:23])
+  case IsPayToSchnorrPubKeyHash(script):
+    return address.FromSchnorrPubKeyHash(script[3:23])
+  case IsPayToScriptHash(script):
+    return address.FromScriptHash(script[2:22])
+  }
+  return ""
+}
+
+// Define a function to guess the address an unlocking script spends from, empty if it does not look standard:
+// <signature> <public key> spends from the address of the key, ECDSA, Ed25519 or Schnorr, otherwise the last push is the script of a script hash
+func ExtractInputAddress(scriptSig []byte) string {
+  pushed, err := PushedData(scriptSig)
+  if err != nil || len(pushed) == 0 {
+    return ""
+  }
+  last := pushed[len(pushed)-1]
+  if len(pushed) == 2 && len(last) == 33 && (last[0] == 0x02 || last[0] == 0x03) { // a compressed public key
+    if first := pushed[0]; len(first) == 65 && (first[0] == 0x02 || first[0] == 0x03) { // a Schnorr signature starts with its point, a DER one with 0x30
+      return address.FromSchnorrPubKeyHash(Hash160(last))
+    }
+    return address.FromPubKeyHash(Hash160(last))
+  }
+  if len(pushed) == 2 && len(last) == 32 && len(pushed[0]) == 64 { // an Ed25519 signature and public key
+    return address.FromEd25519PubKeyHash(Hash160(last))
+  }
+  if _, err := parse(last); err != nil || len(last) == 0 {
+    return ""
+  }
+  return address.FromScriptHash(Hash160(last))
+}
+
+// The data starting a bond script, so a bond is not taken for a payment to the same key
+var bondMarker = []byte("bond")
+
+// Define a function to build the script bonding coins to a validator: <"bond"> OP_DROP followed by the script paying to
+// the hash of its key, unlocked like it with <signature> <public key>; spending the bond unbonds the coins
+func Bond(pubKeyHash []byte) []byte {
+  return NewBuilder().AddData(bondMarker).AddOp(OP_DROP).AddOp(PayToPubKeyHash(pubKeyHash)...).Script()
+}
+
+// Define a function to tell if a script bonds coins to a validator
+func IsBond(script []byte) bool {
+  prefix := len(bondMarker) + 2 // the push of the marker and OP_DROP
+  return len(script) == prefix+25 && bytes.Equal(script[1:prefix-1], bondMarker) && script[0] == byte(len(bondMarker)) &&
+    script[prefix-1] == OP_DROP && IsPayToPubKeyHash(script[prefix:])
+}
+
+// Define a function to get the address of the validator a bond script bonds coins to, empty for the other scripts
+func ExtractBondAddress(script []byte) string {
+  if !IsBond(script) {
+    return ""
+  }
+  return ExtractAddress(script[len(bondMarker)+2:])
+}
+
+// Define a function to build an M-of-N multisig script: OP_M <key 1> ... <key N> OP_N OP_CHECKMULTISIG,
+// unlocked with M signatures in the order of their keys
+func Multisig(required int, pubKeys [][]byte) []byte {
+  return NewBuilder().AddInt(int64(required)).AddData(pubKeys...).AddInt(int64(len(pubKeys))).AddOp(OP_CHECKMULTISIG).Script()
+}
+
+// Define a function to read the number of signatures and the keys of a multisig script
+func ParseMultisig(script []byte) (int, [][]byte, error) {
+  instructions, err := parse(script)
+  if err != nil {
+    return 0, nil, err
+  }
+  count := len(instructions)
+  if count < 4 || instructions[count-1].op != OP_CHECKMULTISIG || !isSmallInt(instructions[0].op) || !isSmallInt(instructions[count-2].op) {
+    return 0, nil, ErrNotStandard
+  }
+  var keys [][]byte
+  for _, ins := range instructions[1 : count-2] {
+    if ins.data == nil {
+      return 0, nil, ErrNotStandard
+    }
+    keys = append(keys, ins.data)
+  }
+  if int(instructions[count-2].op-OP_1+1) != len(keys) { // N must match the keys
+    return 0, nil, ErrNotStandard
+  }
+  return int(instructions[0].op - OP_1 + 1), keys, nil
+}
+
+// Define a function to read the lock time and the key of a time lock script:
+// <lock time> OP_CHECKLOCKTIMEVERIFY OP_DROP <key> OP_CHECKSIG
+func ParseTimeLock(script []byte) (int64, []byte, error) {
+  instructions, err := parse(script)
+  if err != nil {
+    return 0, nil, err
+  }
+  if len(instructions) != 5 || instructions[1].op != OP_CHECKLOCKTIMEVERIFY || instructions[2].op != OP_DROP ||
+    instructions[3].data == nil || instructions[4].op != OP_CHECKSIG {
+    return 0, nil, ErrNotStandard
+  }
+  lockTime := int64(0)
+  switch first := instructions[0]; {
+  case isSmallInt(first.op):
+    lockTime = int64(first.op - OP_1 + 1)
+  case first.data != nil:
+    if lockTime, err = decodeNumber(first.data, maxNumberLen+1); err != nil {
+      return 0, nil, err
+    }
+  default:
+    return 0, nil, ErrNotStandard
+  }
+  return lockTime, instructions[3].data, nil
+}
+
+// Define a function to write a script in a readable form, the opcodes by name and the pushes in hex
+func Disassemble(script []byte) string {
+  instructions, err := parse(script)
+  if err != nil {
+    return "[error: " + err.Error() + "]"
+  }
+  var words []string
+  for _, ins := range instructions {
+    switch {
+    case ins.data != nil && ins.op != OP_0:
+      words = append(words, hex.EncodeToString(ins.data))
+    case isSmallInt(ins.op):
+      words = append(words, fmt.Sprintf("OP_%d", ins.op-OP_1+1))
+    case opcodeNames[ins.op] != "":
+      words = append(words, opcodeNames[ins.op])
+    default:
+      words = append(words, fmt.Sprintf("OP_UNKNOWN_%#02x", ins.op))
+    }
+  }
+  return strings.Join(words, " ")
+}
+
+// Define a function to encode a number like the stack holds them: little endian, the top bit of the last byte for the sign
+func encodeNumber(n int64) []byte {
+  if n == 0 {
+    return []byte{}
+  }
+  negative, magnitude := n < 0, n
+  if negative {
+    magnitude = -n
+  }
+  var data []byte
+  for ; magnitude > 0; magnitude >>= 8 {
+    data = append(data, byte(magnitude))
+  }
+  if data[len(data)-1]&0x80 != 0 { // the sign needs a byte of its own
+    extra := byte(0x00)
+    if negative {
+      extra = 0x80
+    }
+    data = append(data, extra)
+  } else if negative {
+    data[len(data)-1] |= 0x80
+  }
+  return data
+}
+
+// Define a function to decode a number of the stack no longer than some bytes
+func decodeNumber(data []byte, maxLen int) (int64, error) {
+  if len(data) > maxLen {
+    return 0, fmt.Errorf("script: a number is longer than %d bytes", maxLen)
+  }
+  if len(data) == 0 {
+    return 0, nil
+  }
+  var n int64
+  for i, b := range data {
+    n |= int64(b) << (8 * uint(i))
+  }
+  if last := data[len(data)-1]; last&0x80 != 0 { // clear the sign bit and negate
+    n &^= int64(0x80) << (8 * uint(len(data)-1))
+    n = -n
+  }
+  return n, nil
+}
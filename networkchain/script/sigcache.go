@@ -0,0 +1,79 @@
+package script
+
+import (
+  "crypto/sha256" // to key the entries
+  "sync"          // for the lock of the entries
+)
+
+// The signatures remembered by default, about 3 megabytes
+const DefaultSigCacheSize = 100000
+
+// Define a struct for a cache of the signatures found valid, keyed by the hash they sign, the key and the signature
+// A transaction checked when it enters the mempool is checked again when its block arrives; its signatures are then
+// found here instead of being verified twice, which is most of the time the block takes to connect
+type SigCache struct {
+  mu      sync.RWMutex          // the lock protecting the entries
+  entries map[[32]byte]struct{} // the hashes of the valid (sighash, key, signature) triples
+  max     int                   // the most entries kept, 0 keeps none
+}
+
+// Create a function that returns an empty cache keeping at most max signatures, 0 disables it
+func NewSigCache(max int) *SigCache {
+  return &SigCache{entries: map[[32]byte]struct{}{}, max: max}
+}
+
+// Define a function to get the key of an entry, the hash of the three items
+// The items are prefixed with their lengths so no two triples share a key
+func sigCacheKey(pubKey, signature, hash []byte) [32]byte {
+  h := sha256.New()
+  for _, item := range [][]byte{hash, pubKey, signature} {
+    h.Write([]byte{byte(len(item) >> 8), byte(len(item))}) // the items are far shorter than 64 kilobytes
+    h.Write(item)
+  }
+  var key [32]byte
+  copy(key[:], h.Sum(nil))
+  return key
+}
+
+// Define a method to tell whether a signature of a hash was found valid for a key
+func (c *SigCache) Exists(pubKey, signature, hash []byte) bool {
+  if c == nil || c.max == 0 {
+    return false
+  }
+  key := sigCacheKey(pubKey, signature, hash)
+  c.mu.RLock() // lock the entries for reading
+  defer c.mu.RUnlock() // unlock them when done
+  _, ok := c.entries[key]
+  return ok
+}
+
+// Define a method to remember a valid signature, evicting an arbitrary entry when the cache is full
+// The order of a map is random, so an attacker filling the cache cannot choose which signatures are evicted
+func (c *SigCache) Add(pubKey, signature, hash []byte) {
+  if c == nil || c.max == 0 {
+    return
+  }
+  key := sigCacheKey(pubKey, signature, hash)
+  c.mu.Lock() // lock the entries
+  defer c.mu.Unlock() // unlock them when done
+  if _, ok := c.entries[key]; ok {
+    return
+  }
+  if len(c.entries) >= c.max {
+    for evicted := range c.entries {
+      delete(c.entries, evicted)
+      break
+    }
+  }
+  c.entries[key] = struct{}{}
+}
+
+// Define a method to get the number of signatures remembered
+func (c *SigCache) Len() int {
+  if c == nil {
+    return 0
+  }
+  c.mu.RLock() // lock the entries for reading
+  defer c.mu.RUnlock() // unlock them when done
+  return len(c.entries)
+}
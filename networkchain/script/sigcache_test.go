@@ -0,0 +1,47 @@
+package script
+
+import (
+  "testing" // the test framework
+)
+
+func TestSigCache(t *testing.T) {
+  pubKey, signature, hash := []byte("key"), []byte("signature"), []byte("hash")
+  tests := []struct {
+    name   string
+    cache  *SigCache
+    add    bool // whether the signature is added first
+    lookup [3][]byte
+    found  bool
+  }{
+    {"added", NewSigCache(10), true, [3][]byte{pubKey, signature, hash}, true},
+    {"not added", NewSigCache(10), false, [3][]byte{pubKey, signature, hash}, false},
+    {"other hash", NewSigCache(10), true, [3][]byte{pubKey, signature, []byte("other")}, false},
+    {"other key", NewSigCache(10), true, [3][]byte{[]byte("other"), signature, hash}, false},
+    {"items split differently", NewSigCache(10), true, [3][]byte{[]byte("ke"), []byte("ysignature"), hash}, false},
+    {"disabled", NewSigCache(0), true, [3][]byte{pubKey, signature, hash}, false},
+    {"nil", nil, true, [3][]byte{pubKey, signature, hash}, false},
+  }
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      if test.add {
+        test.cache.Add(pubKey, signature, hash)
+      }
+      if got := test.cache.Exists(test.lookup[0], test.lookup[1], test.lookup[2]); got != test.found {
+        t.Errorf("Exists = %v, want %v", got, test.found)
+      }
+    })
+  }
+}
+
+func TestSigCacheEviction(t *testing.T) {
+  cache := NewSigCache(3)
+  for i := 0; i < 10; i++ {
+    cache.Add([]byte{byte(i)}, []byte("signature"), []byte("hash"))
+    if cache.Len() > 3 {
+      t.Fatalf("the cache holds %d signatures, more than 3", cache.Len())
+    }
+  }
+  if !cache.Exists([]byte{9}, []byte("signature"), []byte("hash")) {
+    t.Error("the last signature added was evicted")
+  }
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+  "bytes"               // to compare the tips of the nodes
+  "encoding/hex"        // the mempools are keyed by hex transaction ID
+  "errors"              // for the errors of the harness
+  "fmt"                 // to format the errors
+  "networkchain/config" // the settings of the simulated nodes
+  "networkchain/wallet" // the keys of the miners
+  "net"                 // to find free loopback ports
+  "os"                  // to check the directory is empty
+  "path/filepath"       // to build the data directories of the nodes
+  "strings"             // to list the states of the nodes in the errors
+  "time"                // for the timeouts
+)
+
+// How often the harness looks at the nodes while it waits for them
+const simnetPollInterval = 50 * time.Millisecond
+
+// Define a struct for a node of a simulated network
+type SimNode struct {
+  *Node             // the running node
+  Miner string      // the address the blocks mined on the node pay
+  done  chan error  // receives what Run returned once the node stopped
+}
+
+// Define a struct for a simulated network: full nodes of a single process talking over loopback, each with its own data
+// directory, on a network mining on demand so the blocks come at once
+// The nodes share the genesis block paying the miner of the first node and a wallet file holding the keys of every miner;
+// like on a real network, every node knows the first node, which relays the transactions to the others
+type Simnet struct {
+  Nodes   []*SimNode      // the nodes, the first one first
+  Wallets *wallet.Wallets // the keys of the miners of the nodes
+}
+
+// Define a function to start a simulated network of a number of nodes in an empty or missing directory
+func NewSimnet(dir string, count int) (*Simnet, error) {
+  if !activeNet.MineOnDemand || !activeNet.IsProofOfWork() {
+    return nil, fmt.Errorf("the %s network does not mine blocks on demand, use regtest", activeNet.Name)
+  }
+  if count < 1 {
+    return nil, errors.New("a simulated network needs at least one node")
+  }
+  if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 { // the chains of another run would not share the genesis block
+    return nil, fmt.Errorf("the directory %s is not empty", dir)
+  }
+  wallets, err := wallet.LoadWallets(dir, nil)
+  if err != nil {
+    return nil, err
+  }
+  s := &Simnet{Wallets: wallets}
+  for i := 0; i < count; i++ {
+    if err := s.startNode(filepath.Join(dir, fmt.Sprintf("node%d", i))); err != nil {
+      s.Stop()
+      return nil, err
+    }
+  }
+  return s, nil
+}
+
+// Define a method to start a node of the network in a data directory and wait for it to listen
+func (s *Simnet) startNode(dataDir string) error {
+  miner, err := s.Wallets.CreateWallet()
+  if err != nil {
+    return err
+  }
+  listen, err := freeLoopbackAddress()
+  if err != nil {
+    return err
+  }
+  cfg := config.Default()
+  cfg.DataDir, cfg.Network, cfg.Listen, cfg.Miner = dataDir, activeNet.Name, []string{listen}, miner
+  cfg.FirstNode = listen
+  genesisAddress := miner
+  if len(s.Nodes) > 0 { // the first node relays the transactions and its miner has the genesis block
+    cfg.FirstNode, genesisAddress = s.Nodes[0].address, s.Nodes[0].Miner
+  }
+  bc := NewBlockchain(cfg.DataDir, genesisAddress)
+  node, err := NewNode(bc, cfg)
+  if err != nil {
+    bc.Close()
+    return err
+  }
+  node.faults = newFaultInjector() // injecting nothing until told to
+  simNode := &SimNode{Node: node, Miner: miner, done: make(chan error, 1)}
+  go func() {
+    simNode.done <- node.Run()
+  }()
+  for !node.listening() { // the next nodes introduce themselves to it as soon as they start
+    select {
+    case err := <-simNode.done:
+      bc.Close()
+      return err
+    case <-time.After(simnetPollInterval):
+    }
+  }
+  s.Nodes = append(s.Nodes, simNode)
+  return nil
+}
+
+// Define a function to find a free port on the loopback interface
+func freeLoopbackAddress() (string, error) {
+  ln, err := net.Listen(protocol, "localhost:0") // the system picks the port
+  if err != nil {
+    return "", err
+  }
+  defer ln.Close() // the node listens on it next
+  return ln.Addr().String(), nil
+}
+
+// Define a method to connect a node to another one, the handshake makes them peers of each other
+func (s *Simnet) Connect(from, to int) {
+  peer := s.Nodes[to].address
+  s.Nodes[from].addKnownNodes([]string{peer})
+  s.Nodes[from].connect(peer)
+}
+
+// Define a method to set the faults injected into the messages every node sends
+func (s *Simnet) SetFaults(faults Faults) {
+  for _, n := range s.Nodes {
+    n.faults.set(faults)
+  }
+}
+
+// Define a method to cut the nodes of a group off from the other nodes, or join them again; the messages between the two
+// sides are dropped
+func (s *Simnet) Partition(group []int, partitioned bool) {
+  inGroup := map[int]bool{}
+  for _, i := range group {
+    inGroup[i] = true
+  }
+  for i, n := range s.Nodes {
+    for j, peer := range s.Nodes {
+      if inGroup[i] != inGroup[j] { // across the partition
+        n.faults.block(peer.address, partitioned)
+      }
+    }
+  }
+}
+
+// Define a method to mine blocks on a node, paying its miner, and announce them to its peers
+func (s *Simnet) Mine(node, count int) ([]*Block, error) {
+  return s.Nodes[node].generate(count, s.Nodes[node].Miner)
+}
+
+// Define a method to send coins from the miner of a node, the transaction enters the mempool of the node and is relayed
+func (s *Simnet) Send(node int, to string, amount, fee int) (*Transaction, error) {
+  n := s.Nodes[node]
+  tx, err := NewUTXOTransaction(s.Wallets, n.Miner, to, amount, fee, 0, &UTXOSet{n.bc})
+  if err != nil {
+    return nil, err
+  }
+  return n.submitTransaction(tx.Serialize())
+}
+
+// Define a method to wait until every node has the same tip, or fail with the tips of the nodes after a timeout
+func (s *Simnet) WaitSync(timeout time.Duration) error {
+  return s.waitFor(timeout, func() bool {
+    tip := s.Nodes[0].bc.Tip().MyBlockHash
+    for _, n := range s.Nodes[1:] {
+      if !bytes.Equal(n.bc.Tip().MyBlockHash, tip) {
+        return false
+      }
+    }
+    return true
+  }, "the nodes did not converge")
+}
+
+// Define a method to wait until a transaction is in the mempool of every node, or fail after a timeout
+func (s *Simnet) WaitMempool(txid []byte, timeout time.Duration) error {
+  return s.waitFor(timeout, func() bool {
+    for _, n := range s.Nodes {
+      if n.bc.Mempool.Get(hex.EncodeToString(txid)) == nil {
+        return false
+      }
+    }
+    return true
+  }, fmt.Sprintf("transaction %x did not reach every mempool", txid))
+}
+
+// Define a method to check a condition on the nodes until it holds, failing with a message and the states of the nodes
+// after a timeout
+func (s *Simnet) waitFor(timeout time.Duration, done func() bool, message string) error {
+  deadline := time.Now().Add(timeout)
+  for !done() {
+    if time.Now().After(deadline) {
+      return fmt.Errorf("%s in %s: %s", message, timeout, s.describe())
+    }
+    time.Sleep(simnetPollInterval)
+  }
+  return nil
+}
+
+// Define a method to describe the tips and the mempools of the nodes
+func (s *Simnet) describe() string {
+  var states []string
+  for i, n := range s.Nodes {
+    tip := n.bc.Tip()
+    states = append(states, fmt.Sprintf("node %d at height %d tip %x with %d pending", i, n.bc.GetBestHeight(), tip.MyBlockHash, n.bc.Mempool.Count()))
+  }
+  return strings.Join(states, ", ")
+}
+
+// Define a method to stop the nodes and close their chains
+func (s *Simnet) Stop() {
+  for _, n := range s.Nodes {
+    n.Stop()
+    if err := <-n.done; err != nil {
+      netLog.Warn("Simulated node stopped with an error", "address", n.address, "err", err)
+    }
+    n.bc.Close()
+  }
+  s.Nodes = nil
+}
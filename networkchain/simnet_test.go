@@ -0,0 +1,138 @@
+package main
+
+import (
+  "bytes"                 // to compare the tips of the nodes
+  "encoding/hex"          // the mempools are keyed by hex transaction ID
+  "networkchain/chaincfg" // the regtest network the nodes mine on
+  "networkchain/logger"   // to keep the nodes quiet
+  "os"                    // to exit with the code of the tests
+  "testing"               // the test framework
+  "time"                  // for the timeouts
+)
+
+// How long the nodes may take to agree after each step
+const simnetTestTimeout = 30 * time.Second
+
+// Run every test of the package on regtest, which mines on demand
+func TestMain(m *testing.M) {
+  activeNet = &chaincfg.RegTestParams
+  logger.Apply("error")
+  os.Exit(m.Run())
+}
+
+// Define a function to start a simulated network where every node is a peer of every other, stopped at the end of the test
+func newTestSimnet(t *testing.T, count int, faults Faults) *Simnet {
+  t.Helper()
+  sim, err := NewSimnet(t.TempDir(), count)
+  if err != nil {
+    t.Fatal(err)
+  }
+  t.Cleanup(sim.Stop)
+  for i := 1; i < count; i++ { // every node knows the first one already
+    for j := 1; j < i; j++ {
+      sim.Connect(i, j)
+    }
+  }
+  sim.SetFaults(faults)
+  return sim
+}
+
+// Define a function to mine blocks on a node of a simulated network and wait for every node to have them
+func mineAndSync(t *testing.T, sim *Simnet, node, count int) []*Block {
+  t.Helper()
+  blocks, err := sim.Mine(node, count)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if err := sim.WaitSync(simnetTestTimeout); err != nil {
+    t.Fatal(err)
+  }
+  return blocks
+}
+
+// Define a function to check every node of a simulated network is at a height with a block as its tip
+func checkTips(t *testing.T, sim *Simnet, height int, tip *Block) {
+  t.Helper()
+  for i, n := range sim.Nodes {
+    if got := n.bc.GetBestHeight(); got != height {
+      t.Errorf("node %d is at height %d, want %d", i, got, height)
+    }
+    if got := n.bc.Tip().MyBlockHash; !bytes.Equal(got, tip.MyBlockHash) {
+      t.Errorf("the tip of node %d is %x, want %x", i, got, tip.MyBlockHash)
+    }
+  }
+}
+
+func TestSimnet(t *testing.T) {
+  tests := []struct {
+    name   string
+    nodes  int
+    faults Faults
+  }{
+    {"one node", 1, Faults{}},
+    {"two nodes", 2, Faults{}},
+    {"three nodes", 3, Faults{}},
+    {"five nodes", 5, Faults{}},
+    {"latency and jitter", 4, Faults{Latency: 20 * time.Millisecond, Jitter: 50 * time.Millisecond}},
+  }
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      sim := newTestSimnet(t, test.nodes, test.faults)
+      last := len(sim.Nodes) - 1
+
+      // convergence: the blocks mined on the first node reach every node
+      blocks := mineAndSync(t, sim, 0, activeNet.CoinbaseMaturity+1) // the first coinbase becomes spendable
+      height := activeNet.CoinbaseMaturity + 1
+      checkTips(t, sim, height, blocks[len(blocks)-1])
+
+      // relay: a transaction sent from the first node reaches every mempool
+      tx, err := sim.Send(0, sim.Nodes[last].Miner, 1, 1)
+      if err != nil {
+        t.Fatal(err)
+      }
+      if err := sim.WaitMempool(tx.ID, simnetTestTimeout); err != nil {
+        t.Fatal(err)
+      }
+
+      // the last node mines the transaction, every node confirms it and drops it from its mempool
+      blocks = mineAndSync(t, sim, last, 1)
+      height++
+      checkTips(t, sim, height, blocks[0])
+      for i, n := range sim.Nodes {
+        if _, err := n.bc.FindTransaction(tx.ID); err != nil {
+          t.Errorf("node %d: %v", i, err)
+        }
+        if n.bc.Mempool.Get(hex.EncodeToString(tx.ID)) != nil {
+          t.Errorf("the mined transaction is still in the mempool of node %d", i)
+        }
+      }
+      if last == 0 {
+        return
+      }
+
+      // reorg: the last node mines a longer branch alone, every node switches to it once the partition ends
+      sim.Partition([]int{last}, true)
+      stale, err := sim.Mine(0, 1)
+      if err != nil {
+        t.Fatal(err)
+      }
+      if _, err := sim.Mine(last, 2); err != nil {
+        t.Fatal(err)
+      }
+      sim.Partition([]int{last}, false)
+      blocks = mineAndSync(t, sim, last, 1) // the announcement of the next block leads the others to the branch
+      height += 3
+      checkTips(t, sim, height, blocks[0])
+      for i, n := range sim.Nodes[:last] {
+        block, staleHeight, ok := n.bc.GetBlock(stale[0].MyBlockHash)
+        if !ok {
+          t.Errorf("node %d forgot the block of the first side", i)
+          continue
+        }
+        if n.bc.Confirmations(block, staleHeight) != 0 {
+          t.Errorf("the block of the first side is still on the main chain of node %d", i)
+        }
+      }
+    })
+  }
+}
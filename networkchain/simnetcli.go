@@ -0,0 +1,106 @@
+package main
+
+import (
+  "fmt"  // to print the steps of the scenario
+  "os"   // for the temporary directory
+  "time" // for the timeouts
+
+  "github.com/spf13/cobra" // the command line interface
+)
+
+// Create the command that runs a scenario on a simulated network: nodes of this process connected over loopback mine,
+// relay a transaction, reorganize after a partition and must all end on the same tip
+func simnetCmd() *cobra.Command {
+  var count int
+  var dir string
+  var timeout time.Duration
+  var faults Faults
+  cmd := &cobra.Command{
+    Use:   "simnet",
+    Short: "Run nodes in this process on a regtest network, mine and send coins between them and check they converge",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      if !cmd.Flags().Changed("network") && !cmd.Flags().Changed("params") { // the network mining on demand unless told otherwise
+        cmd.Flags().Set("network", "regtest")
+      }
+      if _, err := loadConfig(cmd); err != nil { // select the network and the log levels
+        return err
+      }
+      if dir == "" { // the chains are thrown away after the run
+        temp, err := os.MkdirTemp("", "simnet")
+        if err != nil {
+          return err
+        }
+        defer os.RemoveAll(temp)
+        dir = temp
+      }
+      sim, err := NewSimnet(dir, count)
+      if err != nil {
+        return err
+      }
+      defer sim.Stop()
+      for i := 1; i < count; i++ { // the nodes only announce the blocks they mine, so every node is a peer of every other
+        for j := 1; j < i; j++ {
+          sim.Connect(i, j)
+        }
+      }
+      sim.SetFaults(faults)
+      fmt.Printf("Started %d nodes in %s\n", count, dir)
+      if _, err := sim.Mine(0, activeNet.CoinbaseMaturity+1); err != nil { // the first coinbase becomes spendable
+        return err
+      }
+      if err := sim.WaitSync(timeout); err != nil {
+        return err
+      }
+      fmt.Printf("Mined %d blocks on node 0, every node is at height %d\n", activeNet.CoinbaseMaturity+1, sim.Nodes[0].bc.GetBestHeight())
+      last := len(sim.Nodes) - 1
+      tx, err := sim.Send(0, sim.Nodes[last].Miner, 1, 1)
+      if err != nil {
+        return err
+      }
+      if err := sim.WaitMempool(tx.ID, timeout); err != nil {
+        return err
+      }
+      fmt.Printf("Transaction %x reached every mempool\n", tx.ID)
+      if _, err := sim.Mine(last, 1); err != nil { // the node receiving the coins mines them
+        return err
+      }
+      if err := sim.WaitSync(timeout); err != nil {
+        return err
+      }
+      for i, n := range sim.Nodes {
+        if _, err := n.bc.FindTransaction(tx.ID); err != nil {
+          return fmt.Errorf("node %d: %w", i, err)
+        }
+      }
+      fmt.Printf("Mined the transaction on node %d, every node is at height %d\n", last, sim.Nodes[0].bc.GetBestHeight())
+      if last > 0 { // the last node mines a longer branch alone, the others switch to it once they hear of it
+        sim.Partition([]int{last}, true)
+        if _, err := sim.Mine(0, 1); err != nil {
+          return err
+        }
+        if _, err := sim.Mine(last, 2); err != nil {
+          return err
+        }
+        sim.Partition([]int{last}, false)
+        if _, err := sim.Mine(last, 1); err != nil { // the announcement of the next block leads the others to the branch
+          return err
+        }
+        if err := sim.WaitSync(timeout); err != nil {
+          return err
+        }
+        fmt.Printf("Reorganized every node onto the branch node %d mined during a partition, at height %d\n", last, sim.Nodes[0].bc.GetBestHeight())
+      }
+      fmt.Println("The simulated network converged")
+      return nil
+    },
+  }
+  cmd.Flags().IntVar(&count, "nodes", 3, "number of nodes")
+  cmd.Flags().StringVar(&dir, "dir", "", "empty directory keeping the chains and the wallet of the nodes, a temporary one removed after the run by default")
+  cmd.Flags().DurationVar(&timeout, "timeout", 30*time.Second, "how long the nodes may take to converge after each step")
+  cmd.Flags().DurationVar(&faults.Latency, "latency", 0, "delay of every message between the nodes")
+  cmd.Flags().DurationVar(&faults.Jitter, "jitter", 0, "random extra delay of the messages up to this, which reorders them")
+  cmd.Flags().Float64Var(&faults.Loss, "loss", 0, "probability a message is dropped, the nodes do not send it again so the run may not converge")
+  cmd.Flags().Float64Var(&faults.Disconnect, "disconnect", 0, "probability a connection is cut halfway through a message")
+  return cmd
+}
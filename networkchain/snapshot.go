@@ -0,0 +1,220 @@
+package main
+
+import (
+  "bytes"                // to compare the hashes
+  "crypto/sha256"        // to hash the UTXO set
+  "encoding/binary"      // to frame the entries in the hash
+  "encoding/gob"         // to serialize the snapshot
+  "errors"               // for the import errors
+  "fmt"                  // to format the import errors
+  "hash"                 // the hasher the entries are written to
+  "networkchain/storage" // the chainstate is read from and written to the store
+  "os"                   // to read and write the snapshot file
+)
+
+// The error returned for a snapshot of a network rotating its validators, the snapshot does not hold the validators of the epochs
+var errRotatingSnapshot = errors.New("the snapshots do not hold the validators of the epochs of a network rotating them")
+
+// Define a struct for a snapshot of the chainstate: the headers of the main chain and the UTXO set at its best block
+// A node importing it trusts the UTXO set, the hash lets it be checked against the one computed by other nodes
+type Snapshot struct {
+  Network  string          // the network of the chain
+  Headers  [][]byte        // the serialized headers of the main chain, genesis first, the last one is the best block
+  UTXOs    []SnapshotEntry // the unspent outputs, in key order
+  UTXOHash []byte          // the hash of the UTXO set
+}
+
+// Define a struct for an unspent output of a snapshot, as stored in the UTXO bucket
+type SnapshotEntry struct {
+  Key   []byte // the transaction ID followed by the output index
+  Value []byte // the serialized output
+}
+
+// create the method that returns the height of the best block of the snapshot
+func (snapshot *Snapshot) Height() int {
+  return len(snapshot.Headers) - 1
+}
+
+// create the function that adds an unspent output to the hash of a UTXO set
+// The key and the value are length prefixed so two sets cannot serialize to the same bytes
+func hashUTXOEntry(hasher hash.Hash, key, value []byte) {
+  var length [4]byte
+  binary.BigEndian.PutUint32(length[:], uint32(len(key)))
+  hasher.Write(length[:]) // writing to a hash never fails
+  hasher.Write(key)
+  binary.BigEndian.PutUint32(length[:], uint32(len(value)))
+  hasher.Write(length[:])
+  hasher.Write(value)
+}
+
+// create the method that computes the hash of the UTXO set: SHA256 over the serialized outputs in key order
+// Nodes at the same best block have the same hash, so a snapshot can be checked against a node the user trusts
+func (u UTXOSet) Hash() []byte {
+  u.Blockchain.mu.RLock()         // the set must not change while it is hashed
+  defer u.Blockchain.mu.RUnlock() // unlock it when done
+  hasher := sha256.New()
+  err := u.Blockchain.utxoCache.read(func(view *utxoView) error {
+    return view.forEach(func(key, value []byte) error {
+      hashUTXOEntry(hasher, key, value)
+      return nil
+    })
+  })
+  if err != nil {
+    chainLog.Panic("Failed to read the UTXO set", "err", err)
+  }
+  return hasher.Sum(nil)
+}
+
+// create the method that writes the chainstate to a snapshot file: the headers of the main chain and the UTXO set
+func (blockchain *Blockchain) ExportSnapshot(path string) (*Snapshot, error) {
+  if activeNet.RotatesValidators() {
+    return nil, errRotatingSnapshot
+  }
+  blockchain.mu.RLock() // the headers and the set must match
+  snapshot := &Snapshot{Network: activeNet.Name}
+  for _, block := range blockchain.Blocks { // the main chain, genesis first
+    snapshot.Headers = append(snapshot.Headers, block.Header().Serialize())
+  }
+  hasher := sha256.New()
+  err := blockchain.utxoCache.read(func(view *utxoView) error {
+    return view.forEach(func(key, value []byte) error {
+      hashUTXOEntry(hasher, key, value)
+      snapshot.UTXOs = append(snapshot.UTXOs, SnapshotEntry{append([]byte{}, key...), append([]byte{}, value...)}) // copy them out of the transaction
+      return nil
+    })
+  })
+  blockchain.mu.RUnlock()
+  if err != nil {
+    return nil, err
+  }
+  snapshot.UTXOHash = hasher.Sum(nil)
+  file, err := os.Create(path) // create the snapshot file
+  if err != nil {
+    return nil, err
+  }
+  if err := gob.NewEncoder(file).Encode(snapshot); err != nil { // write the snapshot
+    file.Close()
+    os.Remove(path) // do not leave half a snapshot behind
+    return nil, err
+  }
+  if err := file.Close(); err != nil {
+    os.Remove(path)
+    return nil, err
+  }
+  return snapshot, nil
+}
+
+// create the function that reads a snapshot file and checks it: the headers must form a valid chain of the network
+// and the UTXO set must match its hash, and the hash given if any
+func ReadSnapshot(path string, expectedHash []byte) (*Snapshot, error) {
+  file, err := os.Open(path) // open the snapshot file
+  if err != nil {
+    return nil, err
+  }
+  defer file.Close()
+  var snapshot Snapshot
+  if err := gob.NewDecoder(file).Decode(&snapshot); err != nil { // read the snapshot
+    return nil, fmt.Errorf("invalid snapshot: %w", err)
+  }
+  if snapshot.Network != activeNet.Name {
+    return nil, fmt.Errorf("the snapshot holds the %s chain, not the %s one", snapshot.Network, activeNet.Name)
+  }
+  if activeNet.RotatesValidators() {
+    return nil, errRotatingSnapshot
+  }
+  if len(snapshot.Headers) == 0 {
+    return nil, errors.New("the snapshot holds no headers")
+  }
+  var parent *blockNode // the node of the previous header
+  for height, data := range snapshot.Headers { // the headers must chain up like the ones of a light client
+    header, err := decodeBlock(data)
+    if err != nil {
+      return nil, fmt.Errorf("invalid header at height %d: %w", height, err)
+    }
+    if !header.Pruned() {
+      return nil, fmt.Errorf("the snapshot holds transactions at height %d", height)
+    }
+    if parent == nil {
+      if len(header.PreviousBlockHash) != 0 {
+        return nil, fmt.Errorf("header %x is not a genesis block", header.MyBlockHash)
+      }
+      if err := checkGenesisHeader(header); err != nil {
+        return nil, err
+      }
+    } else if !bytes.Equal(header.PreviousBlockHash, parent.block.MyBlockHash) {
+      return nil, fmt.Errorf("header %x at height %d does not follow the previous header", header.MyBlockHash, height)
+    }
+    if err := header.VerifySeal(); err != nil { // the hash must come from the header and meet the target, or be signed
+      return nil, err
+    }
+    if parent != nil {
+      if err := checkCheckpoints(header, height, parent.height); err != nil { // the snapshot must follow the checkpoints
+        return nil, err
+      }
+      if err := checkBlockContext(header, parent); err != nil { // check the target and the time against the parent
+        return nil, err
+      }
+      if err := activeEngine().VerifyHeader(header.consensusHeader(), parent.block.consensusHeader()); err != nil { // the validators never change
+        return nil, fmt.Errorf("header %x: %w", header.MyBlockHash, err)
+      }
+    }
+    parent = newBlockNode(header, parent)
+  }
+  hasher := sha256.New()
+  for i, entry := range snapshot.UTXOs { // the outputs must come in key order, like in the store
+    if i > 0 && bytes.Compare(snapshot.UTXOs[i-1].Key, entry.Key) >= 0 {
+      return nil, errors.New("the outputs of the snapshot are not in key order")
+    }
+    hashUTXOEntry(hasher, entry.Key, entry.Value)
+  }
+  if computed := hasher.Sum(nil); !bytes.Equal(computed, snapshot.UTXOHash) {
+    return nil, fmt.Errorf("the UTXO set of the snapshot hashes to %x, not %x", computed, snapshot.UTXOHash)
+  }
+  if expectedHash != nil && !bytes.Equal(expectedHash, snapshot.UTXOHash) { // the user checked the hash with another node
+    return nil, fmt.Errorf("the UTXO set of the snapshot hashes to %x, not the expected %x", snapshot.UTXOHash, expectedHash)
+  }
+  return &snapshot, nil
+}
+
+// create the function that starts the chain of an empty data directory from a snapshot
+// The blocks of the snapshot only have their headers, like the blocks of a pruned chain: the node syncs the blocks
+// after the best block of the snapshot and cannot serve or reorganize the ones before
+func ImportSnapshot(dataDir string, snapshot *Snapshot) error {
+  db, err := storage.Open(dataDir) // open the store in the data directory
+  if err != nil {
+    return err
+  }
+  defer db.Close()
+  tip, err := db.Tip()
+  if err != nil {
+    return err
+  }
+  if tip != nil {
+    return errors.New("the data directory already holds a chain")
+  }
+  if err := checkNetwork(db, true); err != nil { // the store becomes a chain of the active network
+    return err
+  }
+  return db.Update(func(batch *storage.Batch) error {
+    var hash []byte // the hash of the last header
+    for _, data := range snapshot.Headers {
+      header := DeserializeBlock(data) // the headers were checked when the snapshot was read
+      if err := batch.PutBlock(header.MyBlockHash, data); err != nil {
+        return err
+      }
+      hash = header.MyBlockHash
+    }
+    for _, entry := range snapshot.UTXOs {
+      if err := batch.Put(storage.UTXOBucket, entry.Key, entry.Value); err != nil {
+        return err
+      }
+    }
+    if err := batch.SetMeta(prunedHeightKey, heightBytes(len(snapshot.Headers))); err != nil { // every block of the snapshot lacks its transactions
+      return err
+    }
+    if err := batch.SetMeta(txIndexKey, []byte{txIndexVersion}); err != nil { // the index starts after the snapshot
+      return err
+    }
+    return batch.SetTip(hash)
+  })
+}
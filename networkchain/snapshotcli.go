@@ -0,0 +1,103 @@
+package main
+
+import (
+  "encoding/hex" // the hashes are printed and given in hex
+  "fmt"          // to print the results
+
+  "github.com/spf13/cobra" // the command line interface
+)
+
+// Create the command grouping the chainstate snapshot commands
+func snapshotCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use:   "snapshot",
+    Short: "Export the chainstate to a file or start a new node from one, syncing only the blocks after it",
+  }
+  cmd.AddCommand(snapshotExportCmd(), snapshotImportCmd(), snapshotHashCmd())
+  return cmd
+}
+
+// Create the command that writes the chainstate of the data directory to a snapshot file
+func snapshotExportCmd() *cobra.Command {
+  var file string
+  cmd := &cobra.Command{
+    Use:   "export",
+    Short: "Write the headers of the main chain and the UTXO set to a snapshot file",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      cfg, err := loadConfig(cmd) // find the data directory
+      if err != nil {
+        return err
+      }
+      bc := NewBlockchain(cfg.DataDir, "") // open the chain, the node must not be running
+      defer bc.Close()
+      snapshot, err := bc.ExportSnapshot(file)
+      if err != nil {
+        return err
+      }
+      fmt.Printf("Exported %d outputs at height %d\n", len(snapshot.UTXOs), snapshot.Height())
+      fmt.Printf("UTXO set hash: %x\n", snapshot.UTXOHash)
+      return nil
+    },
+  }
+  cmd.Flags().StringVar(&file, "file", "", "file the snapshot is written to")
+  cmd.MarkFlagRequired("file")
+  return cmd
+}
+
+// Create the command that starts the chain of an empty data directory from a snapshot file
+func snapshotImportCmd() *cobra.Command {
+  var file, expected string
+  cmd := &cobra.Command{
+    Use:   "import",
+    Short: "Start the chain of an empty data directory from a snapshot file",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      cfg, err := loadConfig(cmd) // find the data directory
+      if err != nil {
+        return err
+      }
+      var expectedHash []byte // the hash the snapshot must have, if given
+      if expected != "" {
+        if expectedHash, err = hex.DecodeString(expected); err != nil {
+          return fmt.Errorf("invalid hash %q", expected)
+        }
+      }
+      snapshot, err := ReadSnapshot(file, expectedHash) // check the snapshot before writing anything
+      if err != nil {
+        return err
+      }
+      if err := ImportSnapshot(cfg.DataDir, snapshot); err != nil {
+        return err
+      }
+      fmt.Printf("Imported %d outputs at height %d\n", len(snapshot.UTXOs), snapshot.Height())
+      fmt.Printf("UTXO set hash: %x\n", snapshot.UTXOHash)
+      return nil
+    },
+  }
+  cmd.Flags().StringVar(&file, "file", "", "snapshot file to import")
+  cmd.Flags().StringVar(&expected, "hash", "", "UTXO set hash the snapshot must have, as printed by a trusted node")
+  cmd.MarkFlagRequired("file")
+  return cmd
+}
+
+// Create the command that prints the hash of the UTXO set, to check a snapshot against another node
+func snapshotHashCmd() *cobra.Command {
+  return &cobra.Command{
+    Use:   "hash",
+    Short: "Print the best block and the hash of the UTXO set",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      cfg, err := loadConfig(cmd) // find the data directory
+      if err != nil {
+        return err
+      }
+      bc := NewBlockchain(cfg.DataDir, "") // open the chain
+      defer bc.Close()
+      fmt.Printf("Height: %d\n", bc.GetBestHeight())
+      fmt.Printf("Best block: %x\n", bc.Tip().MyBlockHash)
+      fmt.Printf("UTXO set hash: %x\n", UTXOSet{bc}.Hash())
+      return nil
+    },
+  }
+}
@@ -0,0 +1,753 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"networkchain/bloom"
+	"networkchain/config"
+	"networkchain/gcs"
+	"networkchain/script"
+	"networkchain/storage"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Define some constants for the light client
+const (
+  headerTipKey      = "headertip"      // the metadata key holding the hash of the last header of the best branch
+  scannedKey        = "spvscanned"     // the metadata key holding the height up to which the block filters were checked
+  blockFilterFPRate = 0.0001           // the false positive rate of the block filters
+  scanTimeout       = time.Minute      // how long a peer has to answer a scan before another peer may take over
+)
+
+// Define a struct for the block headers kept by a light client
+// The headers are checked like blocks without their transactions: proof of work, target and timestamps,
+// and the branch with the most work wins. The first genesis header received is trusted.
+// The transactions of the wallet found in the blocks are kept with the merkle proofs tying them to the headers
+type HeaderChain struct {
+  mu      sync.RWMutex          // the lock protecting everything below
+  db      *storage.Store        // the store the headers are persisted to
+  index   map[string]*blockNode // every known header, side branches included, by hex hash
+  tip     *blockNode            // the last header of the branch with the most work, nil before the first header
+  scanned int                   // the height up to which the filters of the best branch were checked, -1 for none
+  clock   *timeData             // the time of the network the timestamps are checked against, the clock of the computer if nil
+}
+
+// Define a struct for a transaction of the wallet of a light client
+type spvTransaction struct {
+  Tx        *Transaction // the transaction
+  BlockHash []byte       // the block holding it
+  Proof     *MerkleProof // the proof that the block holds it
+}
+
+// Define a function to open (or create) the header chain stored in a data directory
+func OpenHeaderChain(dataDir string) (*HeaderChain, error) {
+  db, err := storage.Open(dataDir) // open the store in the data directory
+  if err != nil {
+    return nil, err
+  }
+  hc := &HeaderChain{db: db, index: map[string]*blockNode{}, scanned: -1}
+  headers := map[string]*Block{} // read every stored header
+  err = db.ForEach(storage.HeadersBucket, func(hash, data []byte) error {
+    header, err := decodeBlock(data)
+    if err != nil {
+      return err
+    }
+    headers[indexKey(hash)] = header
+    return nil
+  })
+  if err != nil {
+    db.Close()
+    return nil, err
+  }
+  if err := checkNetwork(db, len(headers) == 0); err != nil { // headers of another network cannot be used
+    db.Close()
+    return nil, err
+  }
+  indexBlocks(hc.index, headers) // link them
+  tip, err := db.Meta(headerTipKey) // find the best branch
+  if err != nil {
+    db.Close()
+    return nil, err
+  }
+  if tip != nil {
+    hc.tip = hc.index[indexKey(tip)]
+  }
+  scanned, err := db.Meta(scannedKey) // find where the scan stopped
+  if err != nil {
+    db.Close()
+    return nil, err
+  }
+  if len(scanned) == 8 && hc.tip != nil {
+    hc.scanned = int(binary.BigEndian.Uint64(scanned))
+  }
+  if hc.tip != nil && hc.scanned > hc.tip.height {
+    hc.scanned = hc.tip.height
+  }
+  return hc, nil // return the header chain
+}
+
+// Define a method to close the store behind the header chain
+func (hc *HeaderChain) Close() {
+  hc.mu.Lock() // wait for the writes in progress
+  defer hc.mu.Unlock() // unlock it when done
+  if err := hc.db.Close(); err != nil { // release the database
+    chainLog.Panic("Failed to close the store", "err", err)
+  }
+}
+
+// Define a method to get the height of the best branch, -1 before the first header
+func (hc *HeaderChain) Height() int {
+  hc.mu.RLock() // lock the chain for reading
+  defer hc.mu.RUnlock() // unlock it when done
+  if hc.tip == nil {
+    return -1
+  }
+  return hc.tip.height
+}
+
+// Define a method to get the locator of the best branch, nil before the first header so a peer starts from its genesis block
+func (hc *HeaderChain) Locator() [][]byte {
+  hc.mu.RLock() // lock the chain for reading
+  defer hc.mu.RUnlock() // unlock it when done
+  if hc.tip == nil {
+    return nil
+  }
+  return hc.tip.locator()
+}
+
+// Define a method to validate a header and add it to the chain
+func (hc *HeaderChain) AddHeader(header *Block) error {
+  hc.mu.Lock() // lock the chain
+  defer hc.mu.Unlock() // unlock it when done
+  if _, known := hc.index[indexKey(header.MyBlockHash)]; known { // nothing to do for a header we already have
+    return nil
+  }
+  var parent *blockNode
+  if len(header.PreviousBlockHash) == 0 { // a genesis header
+    if hc.tip != nil {
+      return fmt.Errorf("header %x is the genesis block of another chain", header.MyBlockHash)
+    }
+    if err := checkGenesisHeader(header); err != nil {
+      return err
+    }
+  } else {
+    var ok bool
+    if parent, ok = hc.index[indexKey(header.PreviousBlockHash)]; !ok { // the header must build on a known header
+      return fmt.Errorf("header %x: %w %x", header.MyBlockHash, errUnknownParent, header.PreviousBlockHash)
+    }
+  }
+  if err := header.VerifySeal(); err != nil { // the hash must come from the header and meet the target, or be signed
+    return err
+  }
+  if err := checkFutureTime(header, hc.clock.now()); err != nil { // the block cannot come from the future
+    return err
+  }
+  if parent != nil {
+    if err := checkCheckpoints(header, parent.height+1, hc.tip.height); err != nil { // the chain cannot be rewritten below a checkpoint
+      return err
+    }
+    if err := checkBlockContext(header, parent); err != nil { // check the target and the time against the parent
+      return err
+    }
+  }
+  node := newBlockNode(header.Header(), parent)
+  better := hc.tip == nil || node.work.Cmp(hc.tip.work) > 0 // whether the branch of the header becomes the best one
+  scanned := hc.scanned
+  if better && hc.tip != nil {
+    if fork := findFork(hc.tip, node); fork.height < scanned { // the blocks after the fork have to be scanned again
+      scanned = fork.height
+    }
+  }
+  err := hc.db.Update(func(batch *storage.Batch) error {
+    if err := batch.Put(storage.HeadersBucket, header.MyBlockHash, node.block.Serialize()); err != nil { // store the header
+      return err
+    }
+    if !better {
+      return nil
+    }
+    if err := batch.SetMeta(headerTipKey, header.MyBlockHash); err != nil { // move the tip
+      return err
+    }
+    return batch.SetMeta(scannedKey, heightBytes(scanned))
+  })
+  if err != nil {
+    return err
+  }
+  hc.index[indexKey(header.MyBlockHash)] = node
+  if better {
+    hc.tip, hc.scanned = node, scanned
+  }
+  return nil
+}
+
+// Define a function to find the last node two branches share
+func findFork(a, b *blockNode) *blockNode {
+  if a.height > b.height { // start at the same height
+    a = a.ancestor(b.height)
+  } else {
+    b = b.ancestor(a.height)
+  }
+  for a != b { // go back until the branches meet
+    a, b = a.parent, b.parent
+  }
+  return a
+}
+
+// Define a function to encode a height for the store
+func heightBytes(height int) []byte {
+  data := make([]byte, 8)
+  binary.BigEndian.PutUint64(data, uint64(height))
+  return data
+}
+
+// Define a method to find a header of the best branch by its hash, with its height, the lock must be held
+func (hc *HeaderChain) mainChainNode(hash []byte) (*blockNode, bool) {
+  node, ok := hc.index[indexKey(hash)]
+  if !ok || hc.tip == nil || hc.tip.ancestor(node.height) != node {
+    return nil, false // unknown or on a side branch
+  }
+  return node, true
+}
+
+// Define a method to find a header of the best branch by its hash, with its height
+func (hc *HeaderChain) MainChainHeader(hash []byte) (*Block, int, bool) {
+  hc.mu.RLock() // lock the chain for reading
+  defer hc.mu.RUnlock() // unlock it when done
+  node, ok := hc.mainChainNode(hash)
+  if !ok {
+    return nil, 0, false
+  }
+  return node.block, node.height, true
+}
+
+// Define a method to get the height up to which the filters were checked
+func (hc *HeaderChain) Scanned() int {
+  hc.mu.RLock() // lock the chain for reading
+  defer hc.mu.RUnlock() // unlock it when done
+  return hc.scanned
+}
+
+// Define a method to remember that the filters were checked up to a height
+func (hc *HeaderChain) SetScanned(height int) error {
+  hc.mu.Lock() // lock the chain
+  defer hc.mu.Unlock() // unlock it when done
+  if hc.tip != nil && height > hc.tip.height { // a reorganization may have shortened the branch meanwhile
+    height = hc.tip.height
+  }
+  if err := hc.db.SetMeta(scannedKey, heightBytes(height)); err != nil {
+    return err
+  }
+  hc.scanned = height
+  return nil
+}
+
+// Define a method to list the hashes of the best branch whose filters were not checked, oldest first, at most limit of them
+func (hc *HeaderChain) Unscanned(limit int) [][]byte {
+  hc.mu.RLock() // lock the chain for reading
+  defer hc.mu.RUnlock() // unlock it when done
+  if hc.tip == nil || hc.scanned >= hc.tip.height {
+    return nil
+  }
+  last := hc.scanned + limit // the last height of the batch
+  if last > hc.tip.height {
+    last = hc.tip.height
+  }
+  hashes := make([][]byte, last-hc.scanned)
+  for node := hc.tip.ancestor(last); node != nil && node.height > hc.scanned; node = node.parent { // walk back to the scanned height
+    hashes[node.height-hc.scanned-1] = node.block.MyBlockHash
+  }
+  return hashes
+}
+
+// Define a method to check a wallet transaction against the header of its block and keep it
+func (hc *HeaderChain) AddTransaction(tx *Transaction, blockHash []byte, proof *MerkleProof) error {
+  hc.mu.Lock() // lock the chain
+  defer hc.mu.Unlock() // unlock it when done
+  node, ok := hc.index[indexKey(blockHash)]
+  if !ok {
+    return fmt.Errorf("transaction %x is in unknown block %x", tx.ID, blockHash)
+  }
+  if !bytes.Equal(tx.Hash(), tx.ID) { // the ID must match the content
+    return fmt.Errorf("transaction %x has an invalid ID", tx.ID)
+  }
+  if !proof.Verify(tx.ID, node.block.MerkleRoot) { // the header must commit to the transaction
+    return fmt.Errorf("transaction %x is not in block %x", tx.ID, blockHash)
+  }
+  var encoded bytes.Buffer
+  if err := gob.NewEncoder(&encoded).Encode(spvTransaction{tx, blockHash, proof}); err != nil { // encode the record
+    return err
+  }
+  return hc.db.Update(func(batch *storage.Batch) error {
+    return batch.Put(storage.SPVTxBucket, tx.ID, encoded.Bytes())
+  })
+}
+
+// Define a method to compute the balance of an address from the wallet transactions of the best branch
+func (hc *HeaderChain) Balance(address string) int {
+  hc.mu.RLock() // lock the chain for reading
+  defer hc.mu.RUnlock() // unlock it when done
+  unspent := map[string]int{} // the value of the outputs of the address, by outpoint
+  spent := map[string]bool{}  // the outpoints spent by the wallet transactions
+  err := hc.db.ForEach(storage.SPVTxBucket, func(key, value []byte) error {
+    var record spvTransaction
+    if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&record); err != nil {
+      return err
+    }
+    if _, ok := hc.mainChainNode(record.BlockHash); !ok { // the block was reorganized away
+      return nil
+    }
+    for vout, out := range record.Tx.Vout {
+      if out.CanBeUnlockedWith(address) {
+        unspent[indexKey(outpointKey(record.Tx.ID, vout))] = out.Value
+      }
+    }
+    if !record.Tx.IsCoinbase() {
+      for _, in := range record.Tx.Vin {
+        spent[indexKey(outpointKey(in.Txid, in.Vout))] = true
+      }
+    }
+    return nil
+  })
+  if err != nil {
+    chainLog.Panic("Failed to read the wallet transactions", "err", err)
+  }
+  balance := 0
+  for outpoint, value := range unspent { // sum the outputs nobody spent
+    if !spent[outpoint] {
+      balance += value
+    }
+  }
+  return balance
+}
+
+// Define a function to build the filter of a block: the addresses paid by its outputs and spending its inputs
+// Full nodes serve it to light clients, which download the block only when the filter matches one of their addresses
+// The tweak comes from the block hash, so the false positives differ from block to block
+func blockFilter(block *Block) *bloom.Filter {
+  var addresses []string
+  for _, tx := range block.Transactions {
+    for _, out := range tx.Vout {
+      addresses = append(addresses, out.Address())
+    }
+    if !tx.IsCoinbase() {
+      for _, in := range tx.Vin {
+        addresses = append(addresses, in.Address())
+      }
+    }
+  }
+  var tweak uint32
+  if len(block.MyBlockHash) >= 4 {
+    tweak = binary.BigEndian.Uint32(block.MyBlockHash[:4])
+  }
+  filter := bloom.New(len(addresses), blockFilterFPRate, tweak)
+  for _, address := range addresses {
+    filter.Add([]byte(address))
+  }
+  return filter
+}
+
+// Define a function to get the key of the basic filter of a block, the first bytes of its hash
+func basicFilterKey(hash []byte) [gcs.KeySize]byte {
+  var key [gcs.KeySize]byte
+  copy(key[:], hash)
+  return key
+}
+
+// Define a function to build the basic filter of a block: the scripts of its outputs and the scripts of the outputs its
+// inputs spend, given in input order like its undo data; the outputs nobody can spend are left out
+// Unlike the bloom filter, it holds nothing a light client has to trust: whoever has the block and the outputs it spent
+// builds the same filter
+func basicFilter(block *Block, spent []utxoEntry) (*gcs.Filter, error) {
+  var scripts [][]byte
+  for _, tx := range block.Transactions {
+    for _, out := range tx.Vout {
+      if len(out.ScriptPubKey) > 0 && out.ScriptPubKey[0] != script.OP_RETURN {
+        scripts = append(scripts, out.ScriptPubKey)
+      }
+    }
+  }
+  for _, entry := range spent {
+    scripts = append(scripts, entry.ScriptPubKey)
+  }
+  return gcs.Build(basicFilterKey(block.MyBlockHash), scripts)
+}
+
+// Define a method to build the basic filter of a block of the main chain, false if the block is not on it or was pruned,
+// its spent outputs are not known then
+func (blockchain *Blockchain) BasicFilter(hash []byte) (*gcs.Filter, bool) {
+  blockchain.mu.RLock() // lock the chain for reading
+  defer blockchain.mu.RUnlock() // unlock it when done
+  node, ok := blockchain.index[indexKey(hash)]
+  if !ok || !blockchain.onMainChain(node) || node.block.Pruned() {
+    return nil, false
+  }
+  var spent []utxoEntry // the undo data of the block
+  if node.height > 0 {
+    data, err := blockchain.db.Get(storage.UndoBucket, hash)
+    if err != nil || data == nil {
+      return nil, false
+    }
+    if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&spent); err != nil {
+      return nil, false
+    }
+  }
+  filter, err := basicFilter(node.block, spent)
+  if err != nil {
+    return nil, false
+  }
+  return filter, true
+}
+
+// Define a function to check whether a transaction matches the bloom filter of a light client:
+// its ID, the addresses it pays, and the addresses and outputs it spends are tried
+func filterMatchesTx(filter *bloom.Filter, tx *Transaction) bool {
+  if filter.Matches(tx.ID) {
+    return true
+  }
+  for _, out := range tx.Vout {
+    if filter.Matches([]byte(out.Address())) {
+      return true
+    }
+  }
+  if tx.IsCoinbase() {
+    return false
+  }
+  for _, in := range tx.Vin {
+    if filter.Matches([]byte(in.Address())) || filter.Matches(outpointKey(in.Txid, in.Vout)) {
+      return true
+    }
+  }
+  return false
+}
+
+// Define a struct for the state of a light client
+// A scan asks one peer for the basic filters of the next headers, matches the scripts of the wallet against them,
+// downloads the whole blocks whose filter matches and moves the scanned height once they all arrived; the peers never
+// learn which blocks hold our transactions among the ones downloaded, and cannot leave out a transaction of a block
+type lightClient struct {
+  headers   *HeaderChain    // the headers and the wallet transactions
+  watch     []string        // the addresses of the wallet
+  scripts   [][]byte        // the scripts paying to the addresses, the elements of the basic filters
+  filter    *bloom.Filter   // the bloom filter of the addresses, loaded on the peers so they relay our unconfirmed transactions
+  mu        sync.Mutex      // the lock protecting the scan state below
+  scanPeer  string          // the peer serving the scan in progress, "" when idle
+  scanStart time.Time       // when the scan started
+  scanTo    int             // the height scanned once the filters and the matched blocks arrived
+  requested map[string]bool // the blocks whose filters the scan still waits for, by hex hash
+  pending   map[string]bool // the matched blocks still to download, by hex hash
+}
+
+// Define a method to check whether the basic filter of a block may match a script of the wallet
+func (c *lightClient) matches(filter *gcs.Filter, hash []byte) bool {
+  return filter.MatchAny(basicFilterKey(hash), c.scripts)
+}
+
+// Define a method to check whether a transaction pays or spends from an address of the wallet
+func (c *lightClient) watches(tx *Transaction) bool {
+  for _, address := range c.watch {
+    for _, out := range tx.Vout {
+      if out.CanBeUnlockedWith(address) {
+        return true
+      }
+    }
+    if !tx.IsCoinbase() {
+      for _, in := range tx.Vin {
+        if in.CanUnlockOutputWith(address) {
+          return true
+        }
+      }
+    }
+  }
+  return false
+}
+
+// Define a function to create a light node on top of a header chain
+func NewLightNode(headers *HeaderChain, cfg *config.Config) (*Node, error) {
+  n, err := NewNode(nil, cfg) // the network side is the same as a full node
+  if err != nil {
+    return nil, err
+  }
+  headers.clock = n.networkTime // the headers are checked against the time of the peers
+  filter := bloom.New(len(cfg.Watch), blockFilterFPRate, rand.Uint32()) // a random tweak, so our filter does not look like anyone else's
+  var scripts [][]byte
+  for _, address := range cfg.Watch {
+    filter.Add([]byte(address))
+    if lock, err := script.PayToAddress(address); err == nil {
+      scripts = append(scripts, lock)
+    }
+  }
+  n.spv = &lightClient{headers: headers, watch: cfg.Watch, scripts: scripts, filter: filter}
+  return n, nil
+}
+
+// Define a function to start a light node with its settings, it runs until the process exits
+func StartLightNode(cfg *config.Config) {
+  headers, err := OpenHeaderChain(cfg.DataDir) // open the headers stored in the data directory
+  if err != nil {
+    chainLog.Panic("Failed to open the headers", "dir", cfg.DataDir, "err", err)
+  }
+  defer headers.Close() // close the store when done
+  node, err := NewLightNode(headers, cfg) // create the node
+  if err != nil {
+    netLog.Panic("Failed to create the node", "err", err)
+  }
+  chainLog.Info("Running a light node", "height", headers.Height())
+  node.printBalances() // print what we know so far
+  if err := node.Run(); err != nil { // run the node
+    netLog.Panic("The node stopped", "err", err)
+  }
+}
+
+// Define a method to handle a command received by a light client
+func (n *Node) handleLightCommand(from sender, command string, request []byte) {
+  switch command { // switch on the command
+  case cmdVersion: // if the command is version
+    n.handleVersion(from, request) // handle the version command
+  case cmdInv: // if the command is inv
+    n.handleLightInv(request) // handle the inv command
+  case cmdHeaders: // if the command is headers
+    n.handleHeaders(from, request) // handle the headers command
+  case cmdCFilter: // if the command is cfilter
+    n.handleCFilter(from, request) // handle the cfilter command
+  case cmdBlock: // if the command is block
+    n.handleLightBlock(from, request) // handle the block command
+  case cmdTx: // if the command is tx
+    n.handleLightTx(request) // handle the tx command
+  case cmdAddr: // if the command is addr
+    n.handleAddr(request) // handle the addr command
+  case cmdGetAddr: // if the command is getaddr
+    n.handleGetAddr(request) // handle the getaddr command
+  case cmdPing: // if the command is ping
+    n.handlePing(request) // handle the ping command
+  case cmdPong: // if the command is pong
+    n.handlePong(request) // handle the pong command
+  default: // a light client has no blocks or transactions to serve
+    netLog.Debug("Ignoring command, light nodes do not serve it", "command", command)
+  }
+}
+
+// Define a method to catch up with a peer: download its headers if it is ahead, or scan the filters of ours
+// Peers filtering transactions get our bloom filter first, they only relay our unconfirmed transactions then
+func (n *Node) syncHeaders(address string, peerBestHeight int) {
+  if n.peerHas(address, serviceBloom) { // if the peer filters transactions
+    n.sendFilterLoad(address, n.spv.filter) // only receive ours
+  }
+  if peerBestHeight > n.spv.headers.Height() { // if the peer has more headers
+    n.sendGetHeaders(address) // ask for them, the scan follows
+    return
+  }
+  n.scanFilters(address) // check the filters of the headers not scanned yet
+}
+
+// Define a method to handle an inventory command received by a light client
+func (n *Node) handleLightInv(request []byte) {
+  var payload Inv // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
+  peerAddress := payload.AddrFrom // get the peer address
+  switch payload.Type { // switch on the type of the inventory
+  case "block": // new blocks are followed through their headers
+    if n.peerHas(peerAddress, serviceCFilters) { // if the peer serves headers
+      n.sendGetHeaders(peerAddress) // ask for the new headers
+    }
+  case "tx": // a peer filtering for us only announces our transactions
+    if n.peerHas(peerAddress, serviceBloom) {
+      for _, id := range payload.Items { // iterate over the IDs
+        n.sendGetData(peerAddress, "tx", id) // request the transaction
+      }
+    }
+  }
+}
+
+// Define a method to handle a transaction command received by a light client
+// The transaction is not confirmed yet, so it is only reported; it is kept once a block holds it
+func (n *Node) handleLightTx(request []byte) {
+  var payload Tx // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
+  tx, err := decodeTransaction(payload.Transaction) // deserialize the transaction
+  if err != nil || !bytes.Equal(tx.Hash(), tx.ID) || !n.spv.watches(tx) { // a false positive of the filter, or garbage
+    return
+  }
+  chainLog.Info("Received unconfirmed transaction", "peer", payload.AddrFrom, "txid", tx.ID)
+}
+
+// Define a method to handle a headers command from a node
+func (n *Node) handleHeaders(from sender, request []byte) {
+  var payload Headers // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
+  peerAddress := payload.AddrFrom // get the peer address
+  for _, data := range payload.Headers { // iterate over the headers, oldest first
+    header, err := decodeBlock(data) // deserialize the header
+    if err == nil { // if the header could be read
+      err = n.spv.headers.AddHeader(header) // validate it and add it to the chain
+    }
+    if err != nil { // if the header is invalid or does not connect
+      n.banPeer(from, err) // stop talking to the peer that sent it
+      return
+    }
+  }
+  chainLog.Info("Received headers", "peer", peerAddress, "count", len(payload.Headers), "height", n.spv.headers.Height())
+  if len(payload.Headers) == maxHeadersPerMessage { // if the peer has more
+    n.sendGetHeaders(peerAddress) // ask for the next ones
+    return
+  }
+  n.scanFilters(peerAddress) // check the filters of the new headers
+}
+
+// Define a method to start scanning the filters of the headers not scanned yet with a peer, unless a scan is running
+// or the peer does not serve the basic filters
+func (n *Node) scanFilters(address string) {
+  if !n.peerHas(address, serviceBasicFilters) { // the peer only has the bloom filters of older nodes
+    return
+  }
+  c := n.spv
+  c.mu.Lock() // lock the scan state
+  if c.scanPeer != "" && time.Since(c.scanStart) < scanTimeout { // another peer is scanning
+    c.mu.Unlock() // unlock it
+    return
+  }
+  hashes := c.headers.Unscanned(maxFiltersPerMessage) // the next headers to scan
+  if len(hashes) == 0 { // everything was scanned
+    c.scanPeer = ""
+    c.mu.Unlock() // unlock it
+    return
+  }
+  c.scanPeer, c.scanStart, c.scanTo, c.pending = address, time.Now(), c.headers.Scanned()+len(hashes), map[string]bool{} // start the scan
+  c.requested = map[string]bool{}
+  for _, hash := range hashes {
+    c.requested[indexKey(hash)] = true
+  }
+  c.mu.Unlock() // unlock it
+  n.sendGetCFilters(address, filterBasic, hashes) // ask for the filters
+}
+
+// Define a method to handle a cfilter command from a node, the basic filter of one of the blocks of the scan
+// A block whose filter matches is downloaded, and the scan ends once every filter and every matched block arrived
+func (n *Node) handleCFilter(from sender, request []byte) {
+  var payload CFilter // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
+  peerAddress := payload.AddrFrom // get the peer address
+  c := n.spv
+  c.mu.Lock() // lock the scan state
+  key := indexKey(payload.BlockHash)
+  if c.scanPeer != peerAddress || payload.FilterType != filterBasic || !c.requested[key] { // we did not ask this peer for this filter
+    c.mu.Unlock() // unlock it
+    return
+  }
+  if _, _, ok := c.headers.MainChainHeader(payload.BlockHash); !ok { // the header left the best branch meanwhile
+    c.scanPeer = "" // start again from the scanned height
+    c.mu.Unlock() // unlock it
+    return
+  }
+  filter, err := gcs.Parse(payload.Filter) // read the filter
+  if err != nil { // if the filter is garbage
+    c.scanPeer = ""
+    c.mu.Unlock() // unlock it
+    n.banPeer(from, err) // stop talking to the peer that sent it
+    return
+  }
+  delete(c.requested, key) // the filter arrived
+  matched := c.matches(filter, payload.BlockHash) // whether the block may hold a wallet transaction
+  if matched {
+    c.pending[key] = true
+  }
+  done := len(c.requested) == 0 && len(c.pending) == 0 // whether the scan is over
+  c.mu.Unlock() // unlock it
+  if matched {
+    n.sendGetData(peerAddress, "block", payload.BlockHash) // download the whole block, its transactions are checked against the header
+  }
+  if done { // if nothing is left to download
+    n.finishScan(peerAddress) // move on
+  }
+}
+
+// Define a method to end a scan once its blocks arrived and start the next one
+func (n *Node) finishScan(address string) {
+  c := n.spv
+  c.mu.Lock() // lock the scan state
+  scanTo := c.scanTo
+  c.scanPeer = "" // the scan is over
+  c.mu.Unlock() // unlock it
+  if err := c.headers.SetScanned(scanTo); err != nil { // remember where the next one starts
+    chainLog.Panic("Failed to store the scanned height", "height", scanTo, "err", err)
+  }
+  n.scanFilters(address) // scan the next headers
+}
+
+// Define a method to handle a block command received by a light client
+func (n *Node) handleLightBlock(from sender, request []byte) {
+  var payload BlockMsg // create a buffer for the payload
+  if err := decodePayload(request, &payload); err != nil { // decode the request into the payload
+    return // drop a malformed payload
+  }
+  peerAddress := payload.AddrFrom // get the peer address
+  c := n.spv
+  block, err := decodeBlock(payload.Block) // deserialize the block
+  if err != nil { // if the block is garbage
+    n.banPeer(from, err) // stop talking to the peer that sent it
+    return
+  }
+  key := indexKey(block.MyBlockHash)
+  c.mu.Lock() // lock the scan state
+  requested := c.pending[key] // whether we asked for the block
+  c.mu.Unlock() // unlock it
+  header, _, ok := c.headers.MainChainHeader(block.MyBlockHash)
+  if !requested || !ok { // we did not ask for it or it left the best branch meanwhile
+    return
+  }
+  var ids [][]byte // the IDs of the transactions
+  for _, tx := range block.Transactions {
+    ids = append(ids, tx.ID)
+  }
+  tree := NewMerkleTree(ids)
+  if !bytes.Equal(tree.Root(), header.MerkleRoot) { // the transactions must be the ones the header commits to
+    n.banPeer(from, fmt.Errorf("block %x does not match its header", block.MyBlockHash)) // stop talking to the peer that sent it
+    return
+  }
+  found := 0 // the number of wallet transactions in the block
+  for i, tx := range block.Transactions { // iterate over the transactions
+    if !c.watches(tx) { // if the transaction is not ours
+      continue
+    }
+    if err := c.headers.AddTransaction(tx, block.MyBlockHash, tree.Proof(i)); err != nil { // check it against the header and keep it
+      n.banPeer(from, err) // stop talking to the peer that sent it
+      return
+    }
+    chainLog.Info("Found transaction", "peer", peerAddress, "txid", tx.ID, "block", block.MyBlockHash)
+    found++
+  }
+  n.blockScanned(peerAddress, block.MyBlockHash, found) // move the scan on
+}
+
+// Define a method to record that a matched block arrived, ending the scan after the last one
+func (n *Node) blockScanned(address string, hash []byte, found int) {
+  c := n.spv
+  c.mu.Lock() // lock the scan state
+  delete(c.pending, indexKey(hash)) // the block arrived
+  done := len(c.pending) == 0 && len(c.requested) == 0 && c.scanPeer == address // whether it was the last one
+  c.mu.Unlock() // unlock it
+  if found > 0 { // if the balances changed
+    n.printBalances() // print them
+  }
+  if done { // if the scan is over
+    n.finishScan(address) // move on
+  }
+}
+
+// Define a method to log the balance of the watched addresses
+func (n *Node) printBalances() {
+  for _, address := range n.spv.watch { // iterate over the addresses
+    chainLog.Info("Balance", "address", address, "balance", n.spv.headers.Balance(address))
+  }
+}
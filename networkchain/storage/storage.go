@@ -0,0 +1,232 @@
+package storage
+
+import (
+  "bytes"         // to match the key prefixes
+  "errors"        // for the errors returned by the store
+  "os"            // to create the data directory
+  "path/filepath" // to build the path of the database file
+  "time"          // for the timeout when opening the database
+
+  bolt "go.etcd.io/bbolt" // the embedded key/value database used to persist the chain
+)
+
+// Define some constants for the database layout
+const (
+  dbFile           = "blockchain.db" // the name of the database file inside the data directory
+  blocksBucket     = "blocks"        // the bucket holding the serialized blocks, keyed by block hash
+  metaBucket       = "chainstate"    // the bucket holding the chain metadata
+  tipKey           = "tip"           // the metadata key holding the hash of the last block
+  UTXOBucket       = "utxo"          // the bucket holding the unspent transaction outputs, keyed by outpoint
+  UndoBucket       = "undo"          // the bucket holding the outputs spent by each block, keyed by block hash
+  TxIndexBucket    = "txindex"       // the bucket holding the hash of the main chain block of each transaction and its position in it, keyed by transaction ID
+  HeadersBucket    = "headers"       // the bucket holding the block headers of a light client, keyed by block hash
+  SPVTxBucket      = "spvtxs"        // the bucket holding the wallet transactions of a light client with their merkle proofs, keyed by transaction ID
+  AddrIndexBucket  = "addrindex"     // the bucket holding the hash of the main chain block of each transaction of an address, keyed by address and transaction ID
+  ValidatorsBucket = "validators"    // the bucket holding the validators of each epoch of a network rotating them, keyed by epoch
+)
+
+// The buckets created when the store is opened
+var buckets = []string{blocksBucket, metaBucket, UTXOBucket, UndoBucket, TxIndexBucket, HeadersBucket, SPVTxBucket, AddrIndexBucket, ValidatorsBucket}
+
+// Define an error returned when a block is not in the store
+var ErrNotFound = errors.New("storage: not found")
+
+// Define a struct for the block store
+type Store struct {
+  db *bolt.DB // the underlying database
+}
+
+// Define a function to open (or create) the store inside a data directory
+func Open(dataDir string) (*Store, error) {
+  err := os.MkdirAll(dataDir, 0700) // make sure the data directory exists
+  if err != nil {
+    return nil, err
+  }
+  db, err := bolt.Open(filepath.Join(dataDir, dbFile), 0600, &bolt.Options{Timeout: time.Second}) // open the database, failing if another process holds it
+  if err != nil {
+    return nil, err
+  }
+  err = db.Update(func(tx *bolt.Tx) error { // create the buckets the first time the store is opened
+    for _, name := range buckets { // iterate over the buckets
+      if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+  if err != nil {
+    db.Close() // do not leak the file lock
+    return nil, err
+  }
+  return &Store{db}, nil // return the store
+}
+
+// Define a method to close the store
+func (s *Store) Close() error {
+  return s.db.Close() // close the database and release the file lock
+}
+
+// Define a struct for a set of writes applied atomically
+type Batch struct {
+  tx *bolt.Tx // the database transaction the writes go to
+}
+
+// Define a method to run a function inside a single write transaction, nothing is written if it fails
+func (s *Store) Update(fn func(batch *Batch) error) error {
+  return s.db.Update(func(tx *bolt.Tx) error {
+    return fn(&Batch{tx}) // hand the transaction to the function
+  })
+}
+
+// Define a method to read the store through a batch, the writes of the function fail
+func (s *Store) View(fn func(batch *Batch) error) error {
+  return s.db.View(func(tx *bolt.Tx) error {
+    return fn(&Batch{tx})
+  })
+}
+
+// Define a method to save a block and make it the new tip of the chain
+func (s *Store) SaveBlock(hash, data []byte) error {
+  return s.Update(func(batch *Batch) error { // both writes happen in a single transaction
+    return batch.SaveBlock(hash, data)
+  })
+}
+
+// Define a method to save a block and make it the new tip of the chain as part of a batch
+func (b *Batch) SaveBlock(hash, data []byte) error {
+  if err := b.PutBlock(hash, data); err != nil { // store the serialized block
+    return err
+  }
+  return b.SetTip(hash) // move the tip to the new block
+}
+
+// Define a method to store a block without changing the tip, for blocks of side branches
+func (b *Batch) PutBlock(hash, data []byte) error {
+  return b.Put(blocksBucket, hash, data)
+}
+
+// Define a method to move the tip of the chain as part of a batch
+func (b *Batch) SetTip(hash []byte) error {
+  return b.Put(metaBucket, []byte(tipKey), hash)
+}
+
+// Define a method to write a chain metadata value as part of a batch
+func (b *Batch) SetMeta(key string, value []byte) error {
+  return b.Put(metaBucket, []byte(key), value)
+}
+
+// Define a method to read a chain metadata value inside a batch, returning nil if it is not set
+func (b *Batch) Meta(key string) []byte {
+  return b.Get(metaBucket, []byte(key))
+}
+
+// Define a method to return the hash of the last block inside a batch, or nil for an empty store
+func (b *Batch) Tip() []byte {
+  return b.Meta(tipKey)
+}
+
+// Define a method to read a value inside a batch, returning nil if it is not set
+func (b *Batch) Get(bucket string, key []byte) []byte {
+  value := b.tx.Bucket([]byte(bucket)).Get(key) // look the key up
+  if value == nil {
+    return nil
+  }
+  return append([]byte{}, value...) // copy the value, bolt memory is only valid inside the transaction
+}
+
+// Define a method to write a value as part of a batch
+func (b *Batch) Put(bucket string, key, value []byte) error {
+  return b.tx.Bucket([]byte(bucket)).Put(key, value)
+}
+
+// Define a method to delete a value as part of a batch
+func (b *Batch) Delete(bucket string, key []byte) error {
+  return b.tx.Bucket([]byte(bucket)).Delete(key)
+}
+
+// Define a method to call a function for every key/value pair of a bucket inside a batch, in key order
+func (b *Batch) ForEach(bucket string, fn func(key, value []byte) error) error {
+  return b.tx.Bucket([]byte(bucket)).ForEach(fn) // the slices are only valid during the call
+}
+
+// Define a method to remove every value of a bucket as part of a batch
+func (b *Batch) Clear(bucket string) error {
+  if err := b.tx.DeleteBucket([]byte(bucket)); err != nil { // drop the bucket
+    return err
+  }
+  _, err := b.tx.CreateBucket([]byte(bucket)) // and recreate it empty
+  return err
+}
+
+// Define a method to read a value from a bucket, returning nil if it is not set
+func (s *Store) Get(bucket string, key []byte) ([]byte, error) {
+  var value []byte // the value
+  err := s.db.View(func(tx *bolt.Tx) error {
+    value = (&Batch{tx}).Get(bucket, key) // reuse the batch lookup on a read transaction
+    return nil
+  })
+  return value, err
+}
+
+// Define a method to call a function for every key/value pair of a bucket, in key order
+func (s *Store) ForEach(bucket string, fn func(key, value []byte) error) error {
+  return s.db.View(func(tx *bolt.Tx) error {
+    return tx.Bucket([]byte(bucket)).ForEach(fn) // the slices are only valid during the call
+  })
+}
+
+// Define a method to call a function for every key/value pair of a bucket whose key starts with a prefix, in key order
+func (s *Store) ForEachPrefix(bucket string, prefix []byte, fn func(key, value []byte) error) error {
+  return s.db.View(func(tx *bolt.Tx) error {
+    cursor := tx.Bucket([]byte(bucket)).Cursor()
+    for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() { // the matching keys are next to each other
+      if err := fn(key, value); err != nil {
+        return err
+      }
+    }
+    return nil
+  })
+}
+
+// Define a method to call a function for every stored block, including the blocks of side branches
+func (s *Store) ForEachBlock(fn func(hash, data []byte) error) error {
+  return s.ForEach(blocksBucket, fn)
+}
+
+// Define a method to read a serialized block by its hash
+func (s *Store) Block(hash []byte) ([]byte, error) {
+  var data []byte // the serialized block
+  err := s.db.View(func(tx *bolt.Tx) error {
+    value := tx.Bucket([]byte(blocksBucket)).Get(hash) // look the block up
+    if value == nil {
+      return ErrNotFound // the block is not stored
+    }
+    data = append([]byte{}, value...) // copy the value, bolt memory is only valid inside the transaction
+    return nil
+  })
+  return data, err
+}
+
+// Define a method to return the hash of the last block, or nil for an empty store
+func (s *Store) Tip() ([]byte, error) {
+  return s.Meta(tipKey) // the tip is stored with the rest of the chain metadata
+}
+
+// Define a method to read a chain metadata value, returning nil if it is not set
+func (s *Store) Meta(key string) ([]byte, error) {
+  var value []byte // the metadata value
+  err := s.db.View(func(tx *bolt.Tx) error {
+    if v := tx.Bucket([]byte(metaBucket)).Get([]byte(key)); v != nil { // look the key up
+      value = append([]byte{}, v...) // copy the value out of the transaction
+    }
+    return nil
+  })
+  return value, err
+}
+
+// Define a method to write a chain metadata value
+func (s *Store) SetMeta(key string, value []byte) error {
+  return s.db.Update(func(tx *bolt.Tx) error {
+    return tx.Bucket([]byte(metaBucket)).Put([]byte(key), value) // store the value
+  })
+}
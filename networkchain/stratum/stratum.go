@@ -0,0 +1,404 @@
+// Package stratum serves a stratum-style mining protocol so external miners can work for a node.
+// A miner subscribes, authorizes a worker, gets the jobs pushed with mining.notify and submits the nonces it finds with
+// mining.submit; the messages are JSON-RPC objects, one per line, over a plain TCP connection. Each job is a block
+// template of its own, so two workers never search the same headers, and the shares meeting the easier share target
+// show the work of a miner even when they do not solve the block.
+// The header a miner hashes once with SHA-256 is the previous hash, the merkle root, the time, the bits and the nonce,
+// the last three as 8 byte big endian numbers; the job gives them in hex and the miner returns the time and the nonce.
+package stratum
+
+import (
+  "bufio"                  // the messages are read line by line
+  "encoding/hex"           // the header fields are exchanged in hex
+  "encoding/json"          // the encoding of the messages
+  "errors"                 // for the errors of the backend
+  "fmt"                    // to name the jobs
+  "networkchain/consensus" // the headers and the work of the jobs
+  "networkchain/logger"    // the servers log to the miner subsystem
+  "math/big"               // the targets are 256 bit numbers
+  "net"                    // to listen for miners
+  "strconv"                // to read the numbers of the submissions
+  "sync"                   // the connections are written by several goroutines
+  "time"                   // for the write deadline and the time of the submissions
+)
+
+// Define some limits of the connections
+const (
+  maxLineSize   = 16 << 10         // the largest message accepted from a miner
+  writeTimeout  = 10 * time.Second // how long a miner has to take a message
+  maxJobs       = 16               // the jobs of a connection a submission may still name, the older ones are stale
+  txRefresh     = 10 * time.Second // how often the jobs are rebuilt to take new mempool transactions
+  maxFutureTime = 2 * time.Hour    // how far after the current time a miner may roll the time of a header
+)
+
+// Define the error codes of the protocol, sent as [code, message, null]
+const (
+  CodeOther          = 20 // any other error
+  CodeJobNotFound    = 21 // the job is unknown or stale
+  CodeDuplicateShare = 22 // the share was already submitted
+  CodeLowDifficulty  = 23 // the hash does not meet the share target
+  CodeUnauthorized   = 24 // the worker is not authorized
+  CodeNotSubscribed  = 25 // the connection did not subscribe
+)
+
+// Create the logger of the server
+var minerLog = logger.New(logger.MINER)
+
+// Define the error a backend returns when a solved job no longer builds on the tip
+var ErrStale = errors.New("stratum: stale job")
+
+// Define a struct for a job: a block template a worker searches the nonce of
+type Job struct {
+  Header consensus.Header // the header of the block, without nonce and hash
+  Height int              // the height of the block
+  Block  interface{}      // the block of the node, handed back when the job is solved
+}
+
+// Define an interface for the node behind the server
+type Backend interface {
+  Template(worker string) (*Job, error)            // a new job for a worker, an error if the worker may not mine
+  Submit(job *Job, header *consensus.Header) error // add the block of a solved job, ErrStale if the chain moved on
+  Changes() (<-chan bool, func())                  // an event when the jobs are outdated, true if the tip moved, and a function to stop receiving them
+}
+
+// Define a struct for a request or a notification
+type message struct {
+  ID     json.RawMessage   `json:"id"`     // null for a notification
+  Method string            `json:"method"`
+  Params []json.RawMessage `json:"params"`
+}
+
+// Define a struct for a response
+type response struct {
+  ID     json.RawMessage `json:"id"`
+  Result interface{}     `json:"result"`
+  Error  interface{}     `json:"error"` // [code, message, null], or null
+}
+
+// Define a struct for a notification sent to a miner
+type notification struct {
+  ID     interface{}   `json:"id"` // always null
+  Method string        `json:"method"`
+  Params []interface{} `json:"params"`
+}
+
+// Define a struct for an error of the protocol
+type Error struct {
+  Code    int
+  Message string
+}
+
+// Define a method to describe the error
+func (e *Error) Error() string {
+  return e.Message
+}
+
+// Define a struct for the server
+type Server struct {
+  backend    Backend               // the node building the jobs
+  pow        consensus.ProofOfWork // the engine hashing the headers of the shares
+  limit      *big.Int              // the easiest target of the network, the target of a share of difficulty 1
+  difficulty int                   // the share difficulty, the share target is the easiest target divided by it
+  mu         sync.Mutex            // the lock protecting the fields below
+  conns      map[*conn]bool        // the open connections
+  nextID     uint64                // the number of the next subscription
+  nextJob    uint64                // the number of the next job
+}
+
+// Define a struct for the connection of a miner
+type conn struct {
+  net.Conn
+  writeMu  sync.Mutex      // the lock of the writes
+  mu       sync.Mutex      // the lock protecting the fields below
+  id       string          // the subscription ID, empty until the miner subscribed
+  worker   string          // the authorized worker, empty until it is authorized
+  jobs     map[string]*Job // the jobs a submission may name
+  order    []string        // their IDs, oldest first
+  shares   map[string]bool // the submitted shares, by job, time and nonce
+  accepted int             // the number of accepted shares
+}
+
+// Define a function to create a server for a backend, the shares are hashed by the engine of the network and their
+// target is the easiest target divided by the difficulty
+func NewServer(backend Backend, pow consensus.ProofOfWork, limit *big.Int, difficulty int) *Server {
+  if difficulty < 1 {
+    difficulty = 1
+  }
+  return &Server{backend: backend, pow: pow, limit: limit, difficulty: difficulty, conns: map[*conn]bool{}}
+}
+
+// Define a method to serve the miners on an address until it fails
+func (s *Server) ListenAndServe(address string) error {
+  ln, err := net.Listen("tcp", address)
+  if err != nil {
+    return err
+  }
+  defer ln.Close()
+  changes, cancel := s.backend.Changes() // the jobs are pushed again when they are outdated
+  defer cancel()
+  go s.pushJobs(changes)
+  for {
+    c, err := ln.Accept()
+    if err != nil {
+      return err
+    }
+    go s.serve(&conn{Conn: c, jobs: map[string]*Job{}, shares: map[string]bool{}})
+  }
+}
+
+// Define a method to push new jobs to the authorized workers: at once when the tip moved, at most every txRefresh
+// when only the mempool changed
+func (s *Server) pushJobs(changes <-chan bool) {
+  ticker := time.NewTicker(txRefresh)
+  defer ticker.Stop()
+  pending := false // the mempool changed since the last jobs
+  for {
+    select {
+    case tipMoved, ok := <-changes:
+      if !ok {
+        return
+      }
+      if !tipMoved {
+        pending = true
+        continue
+      }
+      pending = false
+      s.notifyAll(true)
+    case <-ticker.C:
+      if pending {
+        pending = false
+        s.notifyAll(false)
+      }
+    }
+  }
+}
+
+// Define a method to send a new job to every authorized worker, clean if the previous jobs are stale
+func (s *Server) notifyAll(clean bool) {
+  s.mu.Lock() // lock the connections
+  var conns []*conn
+  for c := range s.conns {
+    conns = append(conns, c)
+  }
+  s.mu.Unlock() // unlock them, the jobs take time to build
+  for _, c := range conns {
+    if c.authorized() != "" {
+      s.notify(c, clean)
+    }
+  }
+}
+
+// Define a method to read the requests of a miner until it disconnects
+func (s *Server) serve(c *conn) {
+  s.mu.Lock() // lock the connections
+  s.conns[c] = true
+  s.mu.Unlock() // unlock them
+  defer func() {
+    s.mu.Lock() // lock the connections
+    delete(s.conns, c)
+    s.mu.Unlock() // unlock them
+    c.Close()
+  }()
+  minerLog.Debug("Stratum miner connected", "addr", c.RemoteAddr())
+  scanner := bufio.NewScanner(c)
+  scanner.Buffer(make([]byte, 4096), maxLineSize)
+  for scanner.Scan() {
+    var req message
+    if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+      minerLog.Debug("Invalid stratum message", "addr", c.RemoteAddr(), "err", err)
+      return // the line framing cannot be trusted anymore
+    }
+    if req.ID == nil || string(req.ID) == "null" { // the miner sends no notifications we handle
+      continue
+    }
+    result, err := s.handle(c, &req)
+    if err := c.reply(req.ID, result, err); err != nil {
+      return
+    }
+    if req.Method == "mining.authorize" && err == nil { // the first job follows the authorization
+      c.send(notification{nil, "mining.set_difficulty", []interface{}{s.difficulty}})
+      s.notify(c, true)
+    }
+  }
+  minerLog.Debug("Stratum miner disconnected", "addr", c.RemoteAddr(), "worker", c.authorized(), "err", scanner.Err())
+}
+
+// Define a method to run a request of a miner
+func (s *Server) handle(c *conn, req *message) (interface{}, error) {
+  switch req.Method {
+  case "mining.subscribe": // the agent of the miner is ignored
+    c.mu.Lock() // lock the connection state
+    defer c.mu.Unlock() // unlock it when done
+    if c.id == "" {
+      s.mu.Lock() // lock the counters
+      s.nextID++
+      c.id = fmt.Sprintf("%08x", s.nextID)
+      s.mu.Unlock() // unlock them
+    }
+    return []interface{}{[][]string{{"mining.set_difficulty", c.id}, {"mining.notify", c.id}}, c.id, 0}, nil // no extranonce, each job has its own coinbase
+  case "mining.authorize":
+    worker, err := stringParam(req.Params, 0, "worker")
+    if err != nil {
+      return nil, err
+    }
+    c.mu.Lock() // lock the connection state
+    subscribed := c.id != ""
+    c.mu.Unlock() // unlock it
+    if !subscribed {
+      return nil, &Error{CodeNotSubscribed, "not subscribed"}
+    }
+    if _, err := s.backend.Template(worker); err != nil { // the backend decides who may mine
+      return nil, &Error{CodeUnauthorized, err.Error()}
+    }
+    c.mu.Lock() // lock the connection state
+    c.worker = worker
+    c.mu.Unlock() // unlock it
+    minerLog.Info("Authorized stratum worker", "addr", c.RemoteAddr(), "worker", worker)
+    return true, nil
+  case "mining.submit":
+    return s.submit(c, req.Params)
+  default:
+    return nil, &Error{CodeOther, "unknown method " + req.Method}
+  }
+}
+
+// Define a method to build a job for a worker and send it
+func (s *Server) notify(c *conn, clean bool) {
+  job, err := s.backend.Template(c.authorized())
+  if err != nil {
+    minerLog.Warn("Failed to build a stratum job", "worker", c.authorized(), "err", err)
+    return
+  }
+  s.mu.Lock() // lock the counters
+  s.nextJob++
+  id := fmt.Sprintf("%x", s.nextJob)
+  s.mu.Unlock() // unlock them
+  c.mu.Lock() // lock the connection state
+  if clean { // the previous jobs build on an old tip
+    c.jobs, c.order, c.shares = map[string]*Job{}, nil, map[string]bool{}
+  }
+  c.jobs[id] = job
+  c.order = append(c.order, id)
+  if len(c.order) > maxJobs { // forget the oldest job
+    delete(c.jobs, c.order[0])
+    c.order = c.order[1:]
+  }
+  c.mu.Unlock() // unlock it
+  header := job.Header
+  c.send(notification{nil, "mining.notify", []interface{}{
+    id,                                     // the job ID
+    hex.EncodeToString(header.PrevHash),    // the hash of the previous block
+    hex.EncodeToString(header.MerkleRoot),  // the merkle root of the transactions
+    fmt.Sprintf("%016x", header.Timestamp), // the time, which may be rolled forward
+    fmt.Sprintf("%016x", header.Bits),      // the bits of the block target
+    job.Height,                             // the height of the block
+    clean,                                  // whether the previous jobs are stale
+  }})
+}
+
+// Define a method to check a share submitted as [worker, job ID, hex time, hex nonce] and hand a solved block over
+func (s *Server) submit(c *conn, params []json.RawMessage) (interface{}, error) {
+  if len(params) < 4 {
+    return nil, &Error{CodeOther, "expected worker, job ID, time and nonce"}
+  }
+  var fields [4]string
+  for i, name := range []string{"worker", "job ID", "time", "nonce"} {
+    field, err := stringParam(params, i, name)
+    if err != nil {
+      return nil, err
+    }
+    fields[i] = field
+  }
+  worker, jobID := fields[0], fields[1]
+  timestamp, errTime := strconv.ParseInt(fields[2], 16, 64)
+  nonce, errNonce := strconv.ParseInt(fields[3], 16, 64)
+  if errTime != nil || errNonce != nil || nonce < 0 {
+    return nil, &Error{CodeOther, "the time and the nonce must be hex numbers"}
+  }
+  c.mu.Lock() // lock the connection state
+  defer c.mu.Unlock() // unlock it when done
+  if c.worker == "" || worker != c.worker {
+    return nil, &Error{CodeUnauthorized, "unauthorized worker"}
+  }
+  job, ok := c.jobs[jobID]
+  if !ok {
+    return nil, &Error{CodeJobNotFound, "job not found"}
+  }
+  if timestamp < job.Header.Timestamp || timestamp > time.Now().Add(maxFutureTime).Unix() { // the time only rolls forward
+    return nil, &Error{CodeOther, "time out of range"}
+  }
+  key := jobID + "/" + fields[2] + "/" + fields[3]
+  if c.shares[key] {
+    return nil, &Error{CodeDuplicateShare, "duplicate share"}
+  }
+  header := job.Header // a copy with the time and the nonce of the miner
+  header.Timestamp, header.Nonce = timestamp, int(nonce)
+  header.Hash = s.pow.PowHash(&header)
+  hashInt := new(big.Int).SetBytes(header.Hash)
+  blockTarget := consensus.CompactToBig(header.Bits)
+  shareTarget := new(big.Int).Div(s.limit, big.NewInt(int64(s.difficulty)))
+  if shareTarget.Cmp(blockTarget) < 0 { // a share never needs more work than the block
+    shareTarget = blockTarget
+  }
+  if hashInt.Cmp(shareTarget) >= 0 {
+    return nil, &Error{CodeLowDifficulty, "low difficulty share"}
+  }
+  c.shares[key] = true
+  c.accepted++
+  if hashInt.Cmp(blockTarget) < 0 { // the share solves the block
+    err := s.backend.Submit(job, &header)
+    if errors.Is(err, ErrStale) {
+      return nil, &Error{CodeJobNotFound, "stale job"}
+    }
+    if err != nil {
+      return nil, &Error{CodeOther, err.Error()}
+    }
+    minerLog.Info("Stratum worker solved a block", "worker", worker, "hash", header.Hash, "height", job.Height, "shares", c.accepted)
+  }
+  return true, nil
+}
+
+// Define a method to get the authorized worker of a connection, empty if there is none
+func (c *conn) authorized() string {
+  c.mu.Lock() // lock the connection state
+  defer c.mu.Unlock() // unlock it when done
+  return c.worker
+}
+
+// Define a method to answer a request
+func (c *conn) reply(id json.RawMessage, result interface{}, err error) error {
+  resp := response{ID: id, Result: result}
+  if err != nil {
+    var stratumErr *Error
+    if !errors.As(err, &stratumErr) {
+      stratumErr = &Error{CodeOther, err.Error()}
+    }
+    resp.Result, resp.Error = false, []interface{}{stratumErr.Code, stratumErr.Message, nil}
+  }
+  return c.send(resp)
+}
+
+// Define a method to write a message as a line
+func (c *conn) send(v interface{}) error {
+  data, err := json.Marshal(v)
+  if err != nil {
+    return err
+  }
+  c.writeMu.Lock() // one message at a time
+  defer c.writeMu.Unlock() // unlock it when done
+  c.SetWriteDeadline(time.Now().Add(writeTimeout)) // a miner that stops reading is dropped
+  _, err = c.Write(append(data, '\n'))
+  return err
+}
+
+// Define a function to read the string parameter at an index
+func stringParam(params []json.RawMessage, index int, name string) (string, error) {
+  if index >= len(params) {
+    return "", &Error{CodeOther, "missing parameter " + name}
+  }
+  var value string
+  if err := json.Unmarshal(params[index], &value); err != nil {
+    return "", &Error{CodeOther, name + " must be a string"}
+  }
+  return value, nil
+}
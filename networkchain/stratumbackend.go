@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"networkchain/address"
+	"networkchain/consensus"
+	"networkchain/events"
+	"networkchain/stratum"
+	"strings"
+)
+
+// Define a struct for the view of a node given to the stratum server
+type stratumBackend struct {
+  n *Node // the node the external miners work for
+}
+
+// Define a method to serve the stratum mining protocol on an address until it fails
+func (n *Node) ServeStratum(address string, difficulty int) error {
+  minerLog.Info("Serving the stratum mining protocol", "addr", address, "difficulty", difficulty)
+  limit := consensus.CompactToBig(activeNet.PowLimitBits) // a share of difficulty 1 meets the easiest target
+  return stratum.NewServer(stratumBackend{n}, consensus.NewProofOfWork(activeNet), limit, difficulty).ListenAndServe(address) // serve the miners
+}
+
+// Define a method to build a job for a worker: a worker named after an address, optionally followed by a dot and the
+// name of a rig, mines for that address, the others for the miner address of the node
+func (b stratumBackend) Template(worker string) (*stratum.Job, error) {
+  payTo := b.n.minerAddress
+  if name, _, _ := strings.Cut(worker, "."); address.Validate(name) {
+    payTo = name
+  }
+  if payTo == "" {
+    return nil, fmt.Errorf("worker %q is not an address and the node has no miner address", worker)
+  }
+  block, _ := b.n.bc.NewBlockTemplate(payTo) // the coinbase pays the subsidy and the fees
+  _, parentHeight, _ := b.n.bc.GetBlock(block.PreviousBlockHash)
+  return &stratum.Job{Header: *block.consensusHeader(), Height: parentHeight + 1, Block: block}, nil
+}
+
+// Define a method to add the block of a solved job to the chain and announce it
+func (b stratumBackend) Submit(job *stratum.Job, header *consensus.Header) error {
+  block := *job.Block.(*Block) // a copy, a job may be solved again with another time
+  block.Timestamp, block.Nonce, block.MyBlockHash = header.Timestamp, header.Nonce, header.Hash
+  if !bytes.Equal(block.PreviousBlockHash, b.n.bc.Tip().MyBlockHash) { // another block took the tip meanwhile
+    return stratum.ErrStale
+  }
+  if err := b.n.bc.AddBlock(&block); err != nil {
+    return err
+  }
+  minerLog.Info("Mined block", "hash", block.MyBlockHash, "height", job.Height, "txs", len(block.Transactions))
+  b.n.announceBlock(&block)
+  return nil
+}
+
+// Define a method to tell the server when its jobs are outdated: a new tip makes them stale, a new transaction only
+// makes them miss a fee
+func (b stratumBackend) Changes() (<-chan bool, func()) {
+  sub := b.n.events.Subscribe(events.DefaultBuffer, events.TipChanged, events.TxAccepted, events.ReorgDetected) // subscribe to the chain events
+  changes := make(chan bool, events.DefaultBuffer) // create a channel for the changes
+  go func() {
+    defer close(changes) // the subscription was cancelled
+    for event := range sub.C { // iterate over the events
+      select {
+      case changes <- event.Type != events.TxAccepted: // a connected block or a reorganization moved the tip
+      default: // the server is busy, it rebuilds the jobs anyway
+      }
+    }
+  }()
+  return changes, sub.Cancel // return the changes and the way to stop them
+}
@@ -1,14 +1,50 @@
 package main //Import the main package
+
+import (
+  "networkchain/consensus" // the votes held by the blocks of a BFT network
+  "networkchain/events"    // the announcements of new blocks and transactions
+  "networkchain/mempool"   // the transactions waiting to be mined
+  "networkchain/storage"   // the blocks are persisted in the storage layer
+  "sync"                   // the chain is shared by the connection goroutines and the API servers
+)
+
 // Create the Block data structure
 // A block contains this info:
 type Block struct {
-  Timestamp         int64  // the time when the block was created
-  PreviousBlockHash []byte // the hash of the previous block
-  MyBlockHash       []byte // the hash of the current block
-  AllData           []byte // the data or transactions (body info)
+  Timestamp         int64            // the time when the block was created
+  PreviousBlockHash []byte           // the hash of the previous block
+  MyBlockHash       []byte           // the hash of the current block
+  MerkleRoot        []byte           // the root of the merkle tree of the transactions
+  Transactions      []*Transaction   // the transactions (body info)
+  Nonce             int              // the number found by the proof of work
+  Bits              uint32           // the target the block hash must meet, in compact form
+  Proposer          []byte           // the public key of the validator that signed the block, on a proof of stake network
+  Signature         []byte           // the signature of the block hash by the proposer
+  Commits           []consensus.Vote // the commit votes of the validators, on a BFT network
 }
 
 // Prepare the Blockchain data structure :
+// The exported methods take the lock themselves, the unexported ones expect the caller to hold it
 type Blockchain struct {
-  Blocks []*Block // remember a blockchain is a series of blocks
+  mu           sync.RWMutex          // the lock protecting the main chain, the index and the UTXO set
+  Blocks       []*Block              // remember a blockchain is a series of blocks, this is the main chain; read it with MainChain once the chain is shared
+  Mempool      *mempool.Pool         // the transactions waiting to be mined
+  Orphans      *mempool.OrphanPool   // the transactions waiting for the transactions they spend
+  db           *storage.Store        // the store the blocks are persisted to
+  utxoCache    *utxoCache            // the changes of the UTXO set not written to the store yet
+  index        map[string]*blockNode // every known block, side branches included, by hex hash
+  Events       *events.Bus           // the announcements of the blocks connected and the transactions accepted
+  pruneDepth   int                   // the number of recent blocks keeping their transactions, 0 keeps them all
+  prunedHeight int                   // the blocks of the main chain below this height only have their header
+  txIndex      bool                  // whether the transactions of the main chain are indexed by ID
+  addrIndex    bool                  // whether the transactions of the main chain are indexed by address too
+  fees         *feeEstimator         // the confirmation times of the mempool transactions, by feerate
+  clock        *timeData             // the time of the network the timestamps are checked against and built with, the clock of the computer if nil
+}
+
+// Describe a reorganization of the chain, published with the reorg event
+type ReorgEvent struct {
+  Fork         *Block   // the last block both branches share
+  Disconnected []*Block // the blocks of the old branch, oldest first
+  Connected    []*Block // the blocks of the new branch, oldest first
 }
\ No newline at end of file
@@ -0,0 +1,110 @@
+package main
+
+import (
+  "networkchain/events" // the progress is published on the bus of the chain
+  "sync"                // for the lock of the tracker
+  "time"                // for the rate of the sync
+)
+
+// The heights the main chain reached over this window give the rate the time left is estimated with
+const syncRateWindow = 30 * time.Second
+
+// Define a struct for the progress of the sync of the blocks
+type SyncProgress struct {
+  HeadersHeight int           // the height of the best chain the peers announced
+  BlocksHeight  int           // the height of our main chain
+  Progress      float64       // the share of the best chain verified, 1 once caught up
+  Remaining     time.Duration // the estimated time left, 0 once caught up or while the rate is unknown
+  Peer          string        // the peer the most blocks are downloaded from, empty when none is
+  Synced        bool          // whether the node caught up with its peers
+}
+
+// Define a struct for a height the main chain reached
+type heightSample struct {
+  at     time.Time // when it was reached
+  height int       // the height
+}
+
+// Define a struct for the tracker of the sync progress
+// The height of the main chain is sampled every syncCheckInterval, the samples of the last syncRateWindow give the rate
+// blocks are added at, and the last progress published tells if the next one changed
+type syncTracker struct {
+  mu        sync.Mutex     // the lock protecting the samples
+  samples   []heightSample // the heights sampled over the window, oldest first
+  published SyncProgress   // the last progress published
+}
+
+// Define a function to create an empty tracker
+func newSyncTracker() *syncTracker {
+  return &syncTracker{}
+}
+
+// Define a method to record the height of the main chain
+func (t *syncTracker) sample(height int, now time.Time) {
+  t.mu.Lock() // lock the samples
+  defer t.mu.Unlock() // unlock them when done
+  t.samples = append(t.samples, heightSample{now, height})
+  for len(t.samples) > 1 && now.Sub(t.samples[0].at) > syncRateWindow { // forget the samples out of the window
+    t.samples = t.samples[1:]
+  }
+}
+
+// Define a method to get the blocks added per second over the window, 0 if none was
+func (t *syncTracker) rate() float64 {
+  t.mu.Lock() // lock the samples
+  defer t.mu.Unlock() // unlock them when done
+  if len(t.samples) < 2 {
+    return 0
+  }
+  first, last := t.samples[0], t.samples[len(t.samples)-1]
+  elapsed := last.at.Sub(first.at).Seconds()
+  if elapsed <= 0 || last.height <= first.height {
+    return 0
+  }
+  return float64(last.height-first.height) / elapsed
+}
+
+// Define a method to remember the progress about to be published, returning false if it did not change since the last
+// one, the estimated time left aside
+func (t *syncTracker) changed(progress SyncProgress) bool {
+  t.mu.Lock() // lock the tracker
+  defer t.mu.Unlock() // unlock it when done
+  last := t.published
+  t.published = progress
+  return progress.HeadersHeight != last.HeadersHeight || progress.BlocksHeight != last.BlocksHeight ||
+    progress.Peer != last.Peer || progress.Synced != last.Synced
+}
+
+// Define a method to get the progress of the sync: the best chain is the longest of the ones the peers announced in
+// their version and of our main chain followed by the blocks still to download
+func (n *Node) syncProgress() *SyncProgress {
+  height := n.bc.GetBestHeight()
+  pending, peer := n.blocks.status()
+  best := height + pending // the blocks queued follow our main chain
+  peers := n.conns.peers() // the heights of the former peers no longer count
+  n.mu.Lock() // lock the peer state
+  for _, address := range peers {
+    if n.peerHeights[address] > best {
+      best = n.peerHeights[address]
+    }
+  }
+  n.mu.Unlock() // unlock it
+  progress := &SyncProgress{HeadersHeight: best, BlocksHeight: height, Progress: 1, Peer: peer, Synced: pending == 0 && height >= best}
+  if best > 0 {
+    progress.Progress = float64(height) / float64(best)
+  }
+  if rate := n.progress.rate(); rate > 0 && best > height {
+    progress.Remaining = time.Duration(float64(best-height) / rate * float64(time.Second))
+  }
+  return progress
+}
+
+// Define a method to sample the height of the main chain and publish the progress of the sync when it changed, so the
+// subscribers learn when the node is caught up
+func (n *Node) publishSyncProgress() {
+  n.progress.sample(n.bc.GetBestHeight(), time.Now())
+  progress := n.syncProgress()
+  if n.progress.changed(*progress) {
+    n.events.Publish(events.Event{Type: events.SyncProgress, Data: progress})
+  }
+}
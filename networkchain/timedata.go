@@ -0,0 +1,78 @@
+package main
+
+import (
+  "sort" // to find the median of the offsets
+  "sync" // for the lock of the samples
+  "time" // for the clock of the node
+)
+
+// Define some constants of the network adjusted time
+const (
+  maxTimeSamples    = 200              // the most hosts whose clocks are sampled, so a flood of peers cannot move the time
+  minTimeSamples    = 5                // the samples needed before the clock is adjusted
+  maxTimeAdjustment = 70 * time.Minute // the largest offset applied to the clock, a bigger one is left to the user to fix
+  clockSkewWarning  = 5 * time.Minute  // the offset from the peers beyond which the clock of the computer is likely wrong
+)
+
+// Define a struct for the offsets between the clocks of the peers and the clock of the node
+// The peers tell their time in their version messages; the median of their offsets adjusts the clock the timestamps of
+// the blocks are checked against and built with, so a node whose clock drifted a little agrees with the network
+// Each node has its own, sampling the hosts its connections come from
+type timeData struct {
+  mu      sync.Mutex               // the lock protecting the samples
+  samples map[string]time.Duration // the offset of each host, the first one it told
+  offset  time.Duration            // the offset added to the clock, 0 until there are enough samples
+  warned  bool                     // whether the user was told the clock is wrong, so the warning is not repeated
+}
+
+// Define a function to create the offsets of the clocks of the peers of a node, before any peer told its time
+func newTimeData() *timeData {
+  return &timeData{samples: map[string]time.Duration{}}
+}
+
+// Define a method to get the time of the network: the clock of the node corrected by the median offset of its peers,
+// the clock alone for a chain opened without a node
+func (t *timeData) now() time.Time {
+  if t == nil { // no peers to ask
+    return time.Now()
+  }
+  t.mu.Lock() // lock the samples
+  defer t.mu.Unlock() // unlock them when done
+  return time.Now().Add(t.offset)
+}
+
+// Define a method to add the time a peer told in its version, returning its offset from the clock of the node
+// The sample is keyed by the host the connection came from, not by an address the peer claims, and a host is sampled
+// once, later connections from it change nothing
+func (t *timeData) add(host string, peerTime int64) time.Duration {
+  sample := time.Duration(peerTime-time.Now().Unix()) * time.Second // both clocks in whole seconds
+  t.mu.Lock() // lock the samples
+  defer t.mu.Unlock() // unlock them when done
+  if _, ok := t.samples[host]; ok || len(t.samples) >= maxTimeSamples {
+    return sample
+  }
+  t.samples[host] = sample
+  if len(t.samples) < minTimeSamples {
+    return sample
+  }
+  var offsets []time.Duration
+  for _, offset := range t.samples {
+    offsets = append(offsets, offset)
+  }
+  sort.Slice(offsets, func(i, j int) bool { return offsets[i] < offsets[j] })
+  median := offsets[len(offsets)/2]
+  if median < -maxTimeAdjustment || median > maxTimeAdjustment { // the clock is too wrong to be trusted, or the peers are
+    t.offset = 0
+  } else {
+    t.offset = median
+  }
+  switch {
+  case (median < -clockSkewWarning || median > clockSkewWarning) && !t.warned:
+    t.warned = true
+    netLog.Error("Please check that the date and time of the computer are correct, the peers disagree with the clock", "offset", median, "applied", t.offset, "peers", len(t.samples))
+  case median >= -clockSkewWarning && median <= clockSkewWarning:
+    t.warned = false // warn again if the clock drifts away later
+  }
+  netLog.Debug("Adjusted the network time", "offset", t.offset, "median", median, "peers", len(t.samples))
+  return sample
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+  "fmt"     // to name the hosts
+  "testing" // the test framework
+  "time"    // for the offsets
+)
+
+func TestTimeDataAdd(t *testing.T) {
+  ahead := time.Now().Add(3 * time.Minute).Unix() // a clock a little ahead, not enough to warn the user
+  tests := []struct {
+    name   string
+    hosts  []string // the hosts telling that time, in order
+    offset time.Duration
+  }{
+    {"too few hosts", []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}, 0},
+    {"enough hosts", []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"}, 3 * time.Minute},
+    {"a host telling its time again", []string{"10.0.0.1", "10.0.0.1", "10.0.0.1", "10.0.0.1", "10.0.0.1"}, 0},
+  }
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      clock := newTimeData()
+      for _, host := range test.hosts {
+        clock.add(host, ahead)
+      }
+      if diff := clock.offset - test.offset; diff < -time.Second || diff > time.Second { // the clock may tick between the samples
+        t.Errorf("offset %s, want %s", clock.offset, test.offset)
+      }
+    })
+  }
+}
+
+// A host cannot outvote the others by connecting many times, and the samples stop at the cap
+func TestTimeDataSybil(t *testing.T) {
+  clock := newTimeData()
+  now := time.Now().Unix()
+  for i := 0; i < minTimeSamples; i++ { // honest hosts agreeing with the clock
+    clock.add(fmt.Sprintf("10.0.0.%d", i), now)
+  }
+  for i := 0; i < 2*maxTimeSamples; i++ { // a single attacker telling a time ahead on every connection
+    clock.add("192.0.2.1", now+180)
+  }
+  if clock.offset != 0 {
+    t.Errorf("a single host moved the time by %s", clock.offset)
+  }
+  for i := 0; i < 2*maxTimeSamples; i++ { // many hosts only fill the free slots
+    clock.add(fmt.Sprintf("198.51.%d.%d", i/256, i%256), now+180)
+  }
+  if len(clock.samples) != maxTimeSamples {
+    t.Errorf("%d samples, want %d", len(clock.samples), maxTimeSamples)
+  }
+}
+
+func TestTimeDataNow(t *testing.T) {
+  var unset *timeData // a chain opened without a node
+  if diff := time.Since(unset.now()); diff < 0 || diff > time.Second {
+    t.Errorf("the time without peers is %s away from the clock", diff)
+  }
+  clock := newTimeData()
+  clock.offset = time.Hour
+  if diff := clock.now().Sub(time.Now()); diff < time.Hour-time.Second || diff > time.Hour {
+    t.Errorf("the time of the network is %s ahead of the clock, want an hour", diff)
+  }
+}
+
+// The nodes of a simulated network each sample their own peers
+func TestSimnetNetworkTime(t *testing.T) {
+  sim := newTestSimnet(t, 2, Faults{})
+  a, b := sim.Nodes[0], sim.Nodes[1]
+  if a.networkTime == b.networkTime {
+    t.Fatal("the nodes share the offsets of their peers")
+  }
+  if a.bc.clock != a.networkTime {
+    t.Error("the chain of the node does not use the time of its peers")
+  }
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"networkchain/noise"
+	"math/big"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Define some constants for the encrypted connections
+const (
+  tlsRecordHandshake = 0x16                 // the first byte of a TLS connection, no message of ours starts with it
+  dialTimeout        = 5 * time.Second      // how long to wait for a peer to accept a connection
+  handshakeTimeout   = 5 * time.Second      // how long to wait for a peer to complete the TLS handshake
+  selfSignedValidity = 365 * 24 * time.Hour // how long a generated certificate is valid
+  fallbackExpiry     = 10 * time.Minute     // how long a peer found not to speak Noise or TLS is dialed without it before trying again
+)
+
+// Define a struct for the TLS settings of a node
+// Without a certificate the node generates a self-signed one: the traffic is encrypted but peers are not authenticated
+type TLSOptions struct {
+  Enabled  bool   // whether the node encrypts its connections
+  CertFile string // the PEM certificate of the node, empty to generate one
+  KeyFile  string // the PEM private key of the certificate
+  CAFile   string // the PEM certificates peers must be signed with, empty to accept any peer certificate
+  Require  bool   // refuse peers that do not speak TLS instead of falling back to plaintext, implies Enabled
+}
+
+// Define a struct for a connection whose first bytes were already read to detect TLS
+type peekedConn struct {
+  net.Conn              // the underlying connection
+  reader *bufio.Reader  // the reader holding the peeked bytes
+}
+
+// Define a method to read from the connection, starting with the peeked bytes
+func (c *peekedConn) Read(data []byte) (int, error) {
+  return c.reader.Read(data)
+}
+
+// Define a struct for a connection counting the bytes read, to tell whether a peer answered the TLS handshake at all
+type countingConn struct {
+  net.Conn     // the underlying connection
+  read     int // the bytes read so far
+}
+
+// Define a method to read from the connection, counting the bytes
+func (c *countingConn) Read(data []byte) (int, error) {
+  read, err := c.Conn.Read(data)
+  c.read += read
+  return read, err
+}
+
+// Define a function to check whether a failed TLS handshake shows a peer without TLS: it answered with bytes that are not
+// a TLS record, or it dropped the connection on the ClientHello without answering, reading it as a message with a bad
+// magic; anything else, like a certificate failing the verification, is no reason to fall back to plaintext
+func withoutTLS(err error, read int) bool {
+  var record tls.RecordHeaderError
+  if errors.As(err, &record) {
+    return true
+  }
+  return read == 0 && (errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET))
+}
+
+// Define a function to build the server and client TLS configurations from the options
+func newTLSConfigs(options TLSOptions) (*tls.Config, *tls.Config, error) {
+  var certificate tls.Certificate // the certificate of the node
+  var err error
+  if options.CertFile != "" { // if a certificate is given
+    certificate, err = tls.LoadX509KeyPair(options.CertFile, options.KeyFile) // load it
+  } else { // otherwise
+    certificate, err = selfSignedCertificate() // generate one
+  }
+  if err != nil {
+    return nil, nil, err
+  }
+  server := &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12} // the configuration for incoming connections
+  client := &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12} // the configuration for outgoing connections
+  if options.CAFile == "" { // if peers are not authenticated
+    client.InsecureSkipVerify = true // accept any certificate, the traffic is still encrypted
+    return server, client, nil
+  }
+  pem, err := os.ReadFile(options.CAFile) // read the trusted certificates
+  if err != nil {
+    return nil, nil, err
+  }
+  pool := x509.NewCertPool() // create a pool for them
+  if !pool.AppendCertsFromPEM(pem) {
+    return nil, nil, fmt.Errorf("no certificate found in %s", options.CAFile)
+  }
+  client.RootCAs = pool // check the peers we dial
+  server.ClientCAs = pool // and the peers dialing us
+  server.ClientAuth = tls.VerifyClientCertIfGiven
+  return server, client, nil
+}
+
+// Define a function to generate a self-signed certificate for the node
+func selfSignedCertificate() (tls.Certificate, error) {
+  key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader) // generate a key
+  if err != nil {
+    return tls.Certificate{}, err
+  }
+  serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128)) // pick a random serial number
+  if err != nil {
+    return tls.Certificate{}, err
+  }
+  template := x509.Certificate{
+    SerialNumber: serial,
+    Subject:      pkix.Name{CommonName: "networkchain node"},
+    NotBefore:    time.Now().Add(-time.Hour), // tolerate clocks slightly behind
+    NotAfter:     time.Now().Add(selfSignedValidity),
+    KeyUsage:     x509.KeyUsageDigitalSignature,
+    ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+  }
+  der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key) // sign the certificate with its own key
+  if err != nil {
+    return tls.Certificate{}, err
+  }
+  return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil // return the certificate
+}
+
+// Define a method to wrap an accepted connection in TLS if the peer starts a TLS handshake
+func (n *Node) acceptTLS(conn net.Conn) (net.Conn, error) {
+  if n.tlsServer == nil { // if the node does not encrypt
+    return conn, nil // use the connection as it is
+  }
+  reader := bufio.NewReader(conn) // create a reader to look at the first byte
+  first, err := reader.Peek(1) // look at it without consuming it
+  if err != nil {
+    return nil, err
+  }
+  peeked := &peekedConn{conn, reader} // keep the peeked byte for the next reads
+  if first[0] != tlsRecordHandshake { // if the peer speaks plaintext
+    if n.tlsOptions.Require {
+      return nil, errors.New("plaintext connection refused")
+    }
+    return peeked, nil // use the connection as it is
+  }
+  secure := tls.Server(peeked, n.tlsServer) // encrypt the connection
+  secure.SetDeadline(time.Now().Add(handshakeTimeout)) // do not wait forever for the handshake
+  if err := secure.Handshake(); err != nil {
+    return nil, err
+  }
+  secure.SetDeadline(time.Time{}) // the handshake is done
+  return secure, nil // return the encrypted connection
+}
+
+// Define a method to open a connection to a peer, encrypted when both sides support it
+func (n *Node) dial(address string) (net.Conn, error) {
+  if n.identity != nil && !n.isNoNoisePeer(address) { // the Noise handshake comes first, it also authenticates the peer
+    conn, err := n.dialNoise(address)
+    if err == nil || !errors.Is(err, noise.ErrHandshake) { // connected, or the peer is down or the network failed
+      return conn, err
+    }
+    if n.noiseOptions.Require {
+      return nil, fmt.Errorf("Noise handshake with %s failed: %w", address, err)
+    }
+    netLog.Warn("Peer does not support Noise, falling back", "peer", address, "err", err)
+    n.mu.Lock() // lock the peer state
+    n.noNoisePeers[address] = time.Now().Add(fallbackExpiry) // remember it for the next connections, for a while
+    n.mu.Unlock() // unlock it
+  }
+  conn, err := n.dialPeer(address) // create a connection to the node
+  if err != nil || n.tlsClient == nil || n.isPlaintextPeer(address) { // if the node is down, or we do not encrypt with it
+    return conn, err
+  }
+  config := n.tlsClient.Clone() // copy the configuration to set the peer name
+  if host, _, err := net.SplitHostPort(address); err == nil {
+    config.ServerName = host // the name checked against the peer certificate
+  }
+  counted := &countingConn{Conn: conn} // to know if the peer answered
+  secure := tls.Client(counted, config) // encrypt the connection
+  secure.SetDeadline(time.Now().Add(handshakeTimeout)) // do not wait forever for the handshake
+  if err := secure.Handshake(); err != nil { // if the handshake fails
+    conn.Close() // drop the connection
+    if n.tlsOptions.Require || !withoutTLS(err, counted.read) { // a bad certificate or a network error is no downgrade
+      return nil, fmt.Errorf("TLS handshake with %s failed: %w", address, err)
+    }
+    netLog.Warn("Peer does not support TLS, falling back to plaintext", "peer", address, "err", err)
+    n.mu.Lock() // lock the peer state
+    n.plaintextPeers[address] = time.Now().Add(fallbackExpiry) // remember it for the next connections, for a while
+    n.mu.Unlock() // unlock it
+    return n.dialPeer(address) // connect again without TLS
+  }
+  secure.SetDeadline(time.Time{}) // the handshake is done
+  return secure, nil // return the encrypted connection
+}
+
+// Define a method to check if a peer was found not to support TLS lately, the handshake is tried again once it expired
+func (n *Node) isPlaintextPeer(address string) bool {
+  n.mu.Lock() // lock the peer state
+  defer n.mu.Unlock() // unlock it when done
+  until, ok := n.plaintextPeers[address]
+  if ok && time.Now().After(until) { // the peer may have been upgraded
+    delete(n.plaintextPeers, address)
+    return false
+  }
+  return ok // return whether the peer fell back to plaintext
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+  "bytes"                // to serialize the transaction
+  "crypto/rand"          // to make coinbase transactions unique
+  "crypto/sha256"        // to hash the transaction into its ID
+  "encoding/binary"      // to write the content hashed into the ID
+  "encoding/gob"         // to serialize the transaction
+  "encoding/hex"         // to use transaction IDs as map keys
+  "errors"               // for the errors of the new transactions
+  "fmt"                  // to build the coinbase data and errors
+  "networkchain/address" // to tell the multisig outputs apart
+  "networkchain/script"  // the scripts locking the outputs
+  "networkchain/wallet"  // to sign the inputs
+)
+
+// Create the Transaction data structure
+// A transaction spends previous outputs (inputs) and creates new ones (outputs)
+type Transaction struct {
+  ID       []byte     // the hash of the transaction
+  Vin      []TXInput  // the inputs spending previous outputs
+  Vout     []TXOutput // the new outputs
+  LockTime int        // 0, or the transaction is only mined in blocks after this height, or this unix time from script.LockTimeThreshold on
+}
+
+// An input references an output of a previous transaction and unlocks it
+type TXInput struct {
+  Txid      []byte // the ID of the transaction holding the output
+  Vout      int    // the index of the output in that transaction
+  ScriptSig []byte // the script unlocking the output, the signatures and keys; the data of a coinbase input
+}
+
+// An output holds an amount of coins locked by a script
+type TXOutput struct {
+  Value        int    // the amount of coins
+  ScriptPubKey []byte // the script that must be satisfied to spend the output, usually paying to an address
+}
+
+// Create a function that makes an output paying an amount to an address
+func NewTXOutput(value int, to string) (TXOutput, error) {
+  lock, err := script.PayToAddress(to)
+  if err != nil {
+    return TXOutput{}, fmt.Errorf("cannot pay to %q: %w", to, err)
+  }
+  return TXOutput{value, lock}, nil
+}
+
+// Create a method that gets the address spending the input, guessed from its unlocking script
+func (in *TXInput) Address() string {
+  if len(in.Txid) == 0 && in.Vout == -1 { // a coinbase input has no owner
+    return ""
+  }
+  return script.ExtractInputAddress(in.ScriptSig)
+}
+
+// Create a method that gets the address the output pays to, empty if its script is not a standard one
+func (out *TXOutput) Address() string {
+  return script.ExtractAddress(out.ScriptPubKey)
+}
+
+// Create a method that tells if the output is dust: worth less than the threshold of the network, so spending it costs
+// more than it holds; an OP_RETURN output is never spent, it carries data or burns its coins
+func (out *TXOutput) IsDust() bool {
+  if len(out.ScriptPubKey) > 0 && out.ScriptPubKey[0] == script.OP_RETURN {
+    return false
+  }
+  return out.Value < activeNet.DustThreshold
+}
+
+// Create a method that tells if the input can spend the outputs of an address
+func (in *TXInput) CanUnlockOutputWith(address string) bool {
+  return in.Address() == address
+}
+
+// Create a method that tells if the output is locked to an address
+func (out *TXOutput) CanBeUnlockedWith(address string) bool {
+  return out.Address() == address
+}
+
+// Create a method that tells if the transaction can be mined in a block at a height and with a timestamp:
+// its lock time must be 0 or before the height or the time, like the lock times of bitcoin
+func (tx *Transaction) IsFinal(height int, blockTime int64) bool {
+  switch {
+  case tx.LockTime == 0:
+    return true
+  case tx.LockTime < script.LockTimeThreshold: // a height
+    return tx.LockTime < height
+  }
+  return int64(tx.LockTime) < blockTime
+}
+
+// Create a method that tells if the transaction is a coinbase, the transaction creating new coins in a block
+func (tx *Transaction) IsCoinbase() bool {
+  return len(tx.Vin) == 1 && len(tx.Vin[0].Txid) == 0 && tx.Vin[0].Vout == -1 // a coinbase has a single input referencing nothing
+}
+
+// Create a method that serializes the transaction
+func (tx *Transaction) Serialize() []byte {
+  var encoded bytes.Buffer                             // the buffer receiving the encoded transaction
+  if err := gob.NewEncoder(&encoded).Encode(tx); err != nil { // encode the transaction
+    chainLog.Panic("Failed to encode a transaction", "txid", tx.ID, "err", err)
+  }
+  return encoded.Bytes() // return the encoded transaction
+}
+
+// Create a method that computes the ID of the transaction, the hash of its content without the ID
+// The content is written field by field rather than with gob, whose output depends on the order
+// the types were first seen by the process, so every node computes the same ID
+func (tx *Transaction) Hash() []byte {
+  hash := sha256.Sum256(tx.content(true)) // hash the content with the signatures
+  return hash[:]
+}
+
+// Create a method that computes the hash an input signs: the content of the transaction without the
+// unlocking scripts, followed by the index of the input and the output it spends
+func (tx *Transaction) SignatureHash(index int, prevOut TXOutput) []byte {
+  content := bytes.NewBuffer(tx.content(false))
+  binary.Write(content, binary.BigEndian, uint32(index))
+  writeLengthPrefixed(content, prevOut.ScriptPubKey)
+  binary.Write(content, binary.BigEndian, int64(prevOut.Value))
+  hash := sha256.Sum256(content.Bytes())
+  return hash[:]
+}
+
+// Create a method that writes the content of the transaction, with or without the unlocking scripts of the inputs
+func (tx *Transaction) content(signatures bool) []byte {
+  var content bytes.Buffer // the content of the transaction
+  binary.Write(&content, binary.BigEndian, uint32(len(tx.Vin))) // the inputs
+  for _, in := range tx.Vin {
+    writeLengthPrefixed(&content, in.Txid)
+    binary.Write(&content, binary.BigEndian, int64(in.Vout))
+    if signatures {
+      writeLengthPrefixed(&content, in.ScriptSig)
+    }
+  }
+  binary.Write(&content, binary.BigEndian, uint32(len(tx.Vout))) // the outputs
+  for _, out := range tx.Vout {
+    binary.Write(&content, binary.BigEndian, int64(out.Value))
+    writeLengthPrefixed(&content, out.ScriptPubKey)
+  }
+  binary.Write(&content, binary.BigEndian, int64(tx.LockTime))
+  return content.Bytes()
+}
+
+// Create a function that writes a variable length field prefixed with its length
+func writeLengthPrefixed(content *bytes.Buffer, data []byte) {
+  binary.Write(content, binary.BigEndian, uint32(len(data)))
+  content.Write(data)
+}
+
+// Create a method that signs every input with the key owning the output it spends, then sets the ID
+// The inputs spending a multisig output get the signatures of the keys of the script held by the wallets,
+// other signers add theirs later and Verify tells when there are enough; the lock time must be set before signing
+// since the signatures cover it; the spent outputs are given in input order
+func (tx *Transaction) Sign(wallets *wallet.Wallets, prevOuts []TXOutput) error {
+  for i := range tx.Vin {
+    if err := tx.signInput(wallets, i, prevOuts[i]); err != nil {
+      return err
+    }
+  }
+  tx.ID = tx.Hash() // the ID covers the signatures
+  return nil
+}
+
+// Create a method that sets the unlocking script of an input with the key owning the output it spends, the ID must be
+// set again once the inputs are signed
+func (tx *Transaction) signInput(wallets *wallet.Wallets, i int, prevOut TXOutput) error {
+  owner, hash := prevOut.Address(), tx.SignatureHash(i, prevOut)
+  if bonded := script.ExtractBondAddress(prevOut.ScriptPubKey); bonded != "" { // a bond is unlocked like the key hash it names
+    owner = bonded
+  }
+  switch {
+  case owner == "": // only the standard scripts are known to the wallets
+    return fmt.Errorf("input %d spends an output with a script the wallets cannot sign: %s", i, script.Disassemble(prevOut.ScriptPubKey))
+  case wallets.TimeLock(owner) != nil: // a time-locked output: <signature> <time lock script>
+    redeem, signature, err := wallets.SignTimeLock(owner, hash)
+    if err != nil {
+      return err
+    }
+    tx.Vin[i].ScriptSig = script.NewBuilder().AddData(signature, redeem).Script()
+  case address.IsScriptHash(owner): // a multisig output: <signatures...> <multisig script>
+    var signatures [][]byte
+    if pushed, err := script.PushedData(tx.Vin[i].ScriptSig); err == nil && len(pushed) > 0 { // keep the signatures already there
+      signatures = pushed[:len(pushed)-1]
+    }
+    redeem, signatures, err := wallets.SignMultisig(owner, hash, signatures)
+    if err != nil {
+      return err
+    }
+    tx.Vin[i].ScriptSig = script.NewBuilder().AddData(signatures...).AddData(redeem).Script()
+  case wallets.Aggregate(owner) != nil: // an aggregate key: <signature> <aggregate key>, like a single key
+    signature, pubKey, err := wallets.SignAggregate(owner, hash)
+    if err != nil {
+      return err
+    }
+    tx.Vin[i].ScriptSig = script.NewBuilder().AddData(signature, pubKey).Script()
+  default: // a public key hash: <signature> <public key>
+    signature, pubKey, err := wallets.Sign(owner, hash) // sign with the key of the owner
+    if err != nil {
+      return err
+    }
+    tx.Vin[i].ScriptSig = script.NewBuilder().AddData(signature, pubKey).Script()
+  }
+  return nil
+}
+
+// The signatures found valid by the node, so a transaction verified in the mempool is not verified again in its block
+var sigCache = script.NewSigCache(script.DefaultSigCacheSize)
+
+// Create a method that checks that the unlocking script of every input satisfies the script of the output it spends
+// The spent outputs are given in input order; a multisig spend missing signatures fails with script.ErrNotEnoughSignatures
+func (tx *Transaction) Verify(prevOuts []TXOutput) error {
+  for i := range tx.Vin {
+    if err := tx.verifyInput(i, prevOuts[i]); err != nil {
+      return fmt.Errorf("input %d of transaction %x: %w", i, tx.ID, err)
+    }
+  }
+  return nil
+}
+
+// Create a method that checks that the unlocking script of an input satisfies the script of the output it spends
+func (tx *Transaction) verifyInput(i int, prevOut TXOutput) error {
+  return tx.verifyInputBatched(i, prevOut, nil)
+}
+
+// Create a method that checks the unlocking script of an input, leaving its Ed25519 signatures in a batch to verify
+// later, nil verifies them at once
+func (tx *Transaction) verifyInputBatched(i int, prevOut TXOutput, batch *script.Ed25519Batch) error {
+  ctx := &script.Context{SigHash: tx.SignatureHash(i, prevOut), LockTime: int64(tx.LockTime), SigCache: sigCache, Batch: batch}
+  return script.Verify(tx.Vin[i].ScriptSig, prevOut.ScriptPubKey, ctx)
+}
+
+// Create a function that rebuilds a transaction from its serialized form
+func DeserializeTransaction(data []byte) *Transaction {
+  var tx Transaction                                                    // the transaction to fill
+  if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&tx); err != nil { // decode the transaction
+    chainLog.Panic("Failed to decode a stored transaction", "err", err)
+  }
+  return &tx // return the decoded transaction
+}
+
+// Create a function that rebuilds a transaction received from outside, returning an error instead of panicking on garbage
+func decodeTransaction(data []byte) (*Transaction, error) {
+  var tx Transaction // the transaction to fill
+  if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&tx); err != nil { // decode the transaction
+    return nil, err
+  }
+  return &tx, nil // return the decoded transaction
+}
+
+// Create a function that makes the coinbase transaction of the block at a height, paying its subsidy and fees to an address
+func NewCoinbaseTX(to, data string, height, fees int) *Transaction {
+  if data == "" { // if no data is given
+    random := make([]byte, 20) // use random data so two coinbases to the same address get different IDs
+    if _, err := rand.Read(random); err != nil {
+      chainLog.Panic("Failed to read random data", "err", err)
+    }
+    data = fmt.Sprintf("Reward to '%s' %x", to, random)
+  }
+  txin := TXInput{Txid: []byte{}, Vout: -1, ScriptSig: []byte(data)} // the input references no output, it carries the data instead
+  txout := TXOutput{activeNet.BlockSubsidy(height) + fees, []byte{script.OP_RETURN}} // without an address nobody can spend the reward
+  if to != "" {
+    var err error
+    if txout, err = NewTXOutput(txout.Value, to); err != nil { // the output pays the subsidy and the fees to the address
+      chainLog.Panic("Failed to pay the coinbase", "err", err)
+    }
+  }
+  tx := &Transaction{nil, []TXInput{txin}, []TXOutput{txout}, 0}
+  tx.ID = tx.Hash() // set the ID
+  return tx
+}
+
+// Create a function that makes a transaction sending an amount from an address to another, leaving a fee to the miner
+// The inputs are signed with the key of the sender found in the wallets; the transaction cannot be mined before its
+// lock time, raised to the one of the script when the sender is a time-locked address of the wallets
+func NewUTXOTransaction(wallets *wallet.Wallets, from, to string, amount, fee, lockTime int, utxoSet *UTXOSet) (*Transaction, error) {
+  if fee < 0 {
+    return nil, errors.New("the fee cannot be negative")
+  }
+  if lock := wallets.TimeLock(from); lock != nil {
+    if lockTime != 0 && (lockTime < script.LockTimeThreshold) != (lock.LockTime < script.LockTimeThreshold) {
+      return nil, fmt.Errorf("%s is locked until %d, the lock time %d must be of the same kind", from, lock.LockTime, lockTime)
+    }
+    if int64(lockTime) < lock.LockTime {
+      lockTime = int(lock.LockTime)
+    }
+  }
+  payment, err := NewTXOutput(amount, to) // pay the recipient
+  if err != nil {
+    return nil, err
+  }
+  return newTransaction(wallets, from, payment, fee, lockTime, utxoSet)
+}
+
+// Create a function that makes a transaction creating an output with coins of an address, sending the change back to it
+func newTransaction(wallets *wallet.Wallets, from string, payment TXOutput, fee, lockTime int, utxoSet *UTXOSet) (*Transaction, error) {
+  amount := payment.Value
+  acc, validOutputs := utxoSet.FindSpendableOutputs(from, amount+fee) // collect enough outputs of the sender
+  if acc < amount+fee {
+    return nil, fmt.Errorf("not enough funds: %s has %d, needs %d", from, acc, amount+fee)
+  }
+  var inputs []TXInput    // build the inputs spending the collected outputs
+  var prevOuts []TXOutput // and remember the outputs they spend for the signatures
+  for txid, outs := range validOutputs {
+    txID, err := hex.DecodeString(txid) // the map is keyed by hex IDs
+    if err != nil {
+      return nil, err
+    }
+    for _, vout := range outs {
+      prevOut, ok := utxoSet.FindOutput(txID, vout)
+      if !ok {
+        return nil, fmt.Errorf("output %x:%d is no longer unspent", txID, vout)
+      }
+      inputs = append(inputs, TXInput{Txid: txID, Vout: vout})
+      prevOuts = append(prevOuts, prevOut)
+    }
+  }
+  outputs := []TXOutput{payment}
+  if change := acc - amount - fee; change > 0 { // the inputs not paid out are the fee
+    changeOut, err := NewTXOutput(change, from) // and send the change back to the sender
+    if err != nil {
+      return nil, err
+    }
+    if !changeOut.IsDust() { // dust change would be refused, it goes to the miner instead
+      outputs = append(outputs, changeOut)
+    }
+  }
+  tx := &Transaction{nil, inputs, outputs, lockTime}
+  if err := tx.Sign(wallets, prevOuts); err != nil { // sign the inputs and set the ID
+    return nil, err
+  }
+  return tx, nil
+}
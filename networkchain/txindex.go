@@ -0,0 +1,361 @@
+package main
+
+import (
+  "bytes"                // to find the transaction of the address index in its block
+  "encoding/binary"      // the position of a transaction in its block is stored as a number
+  "errors"               // for the lookup errors
+  "networkchain/storage" // the index lives in the store next to the blocks
+  "sort"                 // to order the transactions of an address
+)
+
+// The metadata key holding the version of the transaction index, stores with an older index have to rebuild it once,
+// or 0 if the store does not keep it
+const txIndexKey = "txindex"
+
+// The version of the transaction index, version 2 added the address index, version 3 the position of the transactions
+const txIndexVersion = 3
+
+// The error returned by the lookups of a chain not keeping the transaction index
+var errNoTxIndex = errors.New("the transaction index is disabled, start the node with --txindex to build it")
+
+// The metadata key telling whether the address index is kept, the stores written before it was optional keep it
+const addrIndexMetaKey = "addrindex"
+
+// The error returned by the address lookups of a chain not keeping the address index
+var errNoAddrIndex = errors.New("the address index is disabled, start the node with --addrindex to build it")
+
+// Create a function that builds the address index key of a transaction: the address, a zero byte and the transaction ID
+func addrIndexKey(address string, ID []byte) []byte {
+  key := append([]byte(address), 0) // addresses never hold a zero byte
+  return append(key, ID...)
+}
+
+// Create a function that lists the addresses a transaction spends from or pays to, once each
+func txAddresses(tx *Transaction) []string {
+  var addresses []string
+  seen := make(map[string]bool)
+  add := func(address string) {
+    if address != "" && !seen[address] {
+      seen[address] = true
+      addresses = append(addresses, address)
+    }
+  }
+  if !tx.IsCoinbase() { // the input of a coinbase holds arbitrary data
+    for _, in := range tx.Vin {
+      add(in.Address())
+    }
+  }
+  for _, out := range tx.Vout {
+    add(out.Address())
+  }
+  return addresses
+}
+
+// Create a function that builds the transaction index value of a transaction: the hash of its block followed by its position in it
+func txIndexValue(blockHash []byte, position int) []byte {
+  value := make([]byte, len(blockHash)+4)
+  copy(value, blockHash)
+  binary.BigEndian.PutUint32(value[len(blockHash):], uint32(position))
+  return value
+}
+
+// Create a function that splits a transaction index value into the block hash and the position of the transaction
+func splitTxIndexValue(value []byte) ([]byte, int, bool) {
+  if len(value) < 4 {
+    return nil, 0, false
+  }
+  return value[:len(value)-4], int(binary.BigEndian.Uint32(value[len(value)-4:])), true
+}
+
+// create the method that adds the transactions of a block connected to the main chain to the indexes it keeps, the lock must be held
+func (blockchain *Blockchain) indexTransactions(batch *storage.Batch, block *Block) error {
+  if blockchain.txIndex {
+    if err := indexTxIDs(batch, block); err != nil {
+      return err
+    }
+  }
+  if !blockchain.addrIndex {
+    return nil
+  }
+  return indexAddresses(batch, block)
+}
+
+// Create a function that adds the transactions of a block to the transaction index
+func indexTxIDs(batch *storage.Batch, block *Block) error {
+  for position, tx := range block.Transactions { // point each ID to the block and the position in it
+    if err := batch.Put(storage.TxIndexBucket, tx.ID, txIndexValue(block.MyBlockHash, position)); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// Create a function that adds the transactions of a block to the address index
+func indexAddresses(batch *storage.Batch, block *Block) error {
+  for _, tx := range block.Transactions {
+    for _, address := range txAddresses(tx) { // point each address of the transaction to the block
+      if err := batch.Put(storage.AddrIndexBucket, addrIndexKey(address, tx.ID), block.MyBlockHash); err != nil {
+        return err
+      }
+    }
+  }
+  return nil
+}
+
+// create the method that removes the transactions of a block disconnected from the main chain from the indexes, the lock must be held
+func (blockchain *Blockchain) unindexTransactions(batch *storage.Batch, block *Block) error {
+  for _, tx := range block.Transactions { // iterate over the transactions
+    if blockchain.txIndex {
+      if err := batch.Delete(storage.TxIndexBucket, tx.ID); err != nil {
+        return err
+      }
+    }
+    if !blockchain.addrIndex {
+      continue
+    }
+    for _, address := range txAddresses(tx) {
+      if err := batch.Delete(storage.AddrIndexBucket, addrIndexKey(address, tx.ID)); err != nil {
+        return err
+      }
+    }
+  }
+  return nil
+}
+
+// create the method that rebuilds the indexes the chain keeps from the main chain, the transactions of the pruned blocks left out
+func (blockchain *Blockchain) reindexTransactions(batch *storage.Batch) error {
+  if err := batch.Clear(storage.TxIndexBucket); err != nil { // start from an empty index
+    return err
+  }
+  if err := batch.Clear(storage.AddrIndexBucket); err != nil {
+    return err
+  }
+  for _, block := range blockchain.Blocks { // index every block in order
+    if err := blockchain.indexTransactions(batch, block); err != nil {
+      return err
+    }
+  }
+  return batch.SetMeta(txIndexKey, blockchain.txIndexMeta()) // remember the index is complete
+}
+
+// create the method that gives the transaction index metadata of the chain: the version of the index, 0 if it is not kept
+func (blockchain *Blockchain) txIndexMeta() []byte {
+  if !blockchain.txIndex {
+    return []byte{0}
+  }
+  return []byte{txIndexVersion}
+}
+
+// create the method that reads whether the store keeps the transaction index
+func (blockchain *Blockchain) loadTxIndex() {
+  built, err := blockchain.db.Meta(txIndexKey)
+  if err != nil {
+    chainLog.Panic("Failed to read the transaction index version", "err", err)
+  }
+  blockchain.txIndex = len(built) != 1 || built[0] != 0 // a new store builds it unless the node turns it off
+}
+
+// create the method that tells if the chain keeps the transaction index
+func (blockchain *Blockchain) HasTxIndex() bool {
+  blockchain.mu.RLock()         // lock the chain for reading
+  defer blockchain.mu.RUnlock() // unlock it when done
+  return blockchain.txIndex
+}
+
+// create the method that starts or stops keeping the transaction index: starting builds it from the main chain, the
+// transactions of the pruned blocks left out, stopping drops it to save the space
+func (blockchain *Blockchain) SetTxIndex(enable bool) error {
+  blockchain.mu.Lock()         // the index must follow the main chain
+  defer blockchain.mu.Unlock() // unlock it when done
+  if enable == blockchain.txIndex {
+    return nil
+  }
+  blockchain.txIndex = enable
+  err := blockchain.db.Update(func(batch *storage.Batch) error {
+    if err := batch.Clear(storage.TxIndexBucket); err != nil {
+      return err
+    }
+    if enable {
+      chainLog.Info("Building the transaction index", "height", len(blockchain.Blocks)-1)
+      for _, block := range blockchain.Blocks {
+        if err := indexTxIDs(batch, block); err != nil {
+          return err
+        }
+      }
+    }
+    return batch.SetMeta(txIndexKey, blockchain.txIndexMeta())
+  })
+  if err != nil {
+    blockchain.txIndex = !enable // nothing was written
+    return err
+  }
+  if !enable {
+    chainLog.Info("Dropped the transaction index")
+  }
+  return nil
+}
+
+// create the method that rebuilds the indexes the chain keeps, for the reindex command
+func (blockchain *Blockchain) ReindexTransactions() error {
+  blockchain.mu.Lock()         // the blocks must not change while the indexes are rebuilt
+  defer blockchain.mu.Unlock() // unlock it when done
+  return blockchain.db.Update(blockchain.reindexTransactions)
+}
+
+// create the method that reads whether the store keeps the address index
+func (blockchain *Blockchain) loadAddrIndex() {
+  kept, err := blockchain.db.Meta(addrIndexMetaKey)
+  if err != nil {
+    chainLog.Panic("Failed to read the address index setting", "err", err)
+  }
+  blockchain.addrIndex = len(kept) == 0 || kept[0] == 1 // a new store builds it unless the node turns it off
+}
+
+// create the method that tells if the chain keeps the address index
+func (blockchain *Blockchain) HasAddrIndex() bool {
+  blockchain.mu.RLock()         // lock the chain for reading
+  defer blockchain.mu.RUnlock() // unlock it when done
+  return blockchain.addrIndex
+}
+
+// create the method that starts or stops keeping the address index: starting builds it from the main chain, the
+// transactions of the pruned blocks left out, stopping drops it to save the space
+func (blockchain *Blockchain) SetAddrIndex(enable bool) error {
+  blockchain.mu.Lock()         // the index must follow the main chain
+  defer blockchain.mu.Unlock() // unlock it when done
+  if enable == blockchain.addrIndex {
+    return nil
+  }
+  err := blockchain.db.Update(func(batch *storage.Batch) error {
+    if err := batch.Clear(storage.AddrIndexBucket); err != nil {
+      return err
+    }
+    setting := []byte{0}
+    if enable {
+      chainLog.Info("Building the address index", "height", len(blockchain.Blocks)-1)
+      for _, block := range blockchain.Blocks {
+        if err := indexAddresses(batch, block); err != nil {
+          return err
+        }
+      }
+      setting[0] = 1
+    }
+    return batch.SetMeta(addrIndexMetaKey, setting)
+  })
+  if err != nil {
+    return err
+  }
+  blockchain.addrIndex = enable
+  if !enable {
+    chainLog.Info("Dropped the address index")
+  }
+  return nil
+}
+
+// create the method that builds the transaction index if the store keeps one but not a current one yet
+func (blockchain *Blockchain) ensureTxIndex() {
+  built, err := blockchain.db.Meta(txIndexKey) // check which index was built
+  if err != nil {
+    chainLog.Panic("Failed to read the transaction index version", "err", err)
+  }
+  if !blockchain.txIndex || len(built) == 1 && built[0] == txIndexVersion {
+    return
+  }
+  chainLog.Info("Building the transaction index", "height", len(blockchain.Blocks)-1)
+  if err := blockchain.db.Update(blockchain.reindexTransactions); err != nil { // build it
+    chainLog.Panic("Failed to build the transaction index", "err", err)
+  }
+}
+
+// create the method that finds a transaction of the main chain by its ID, with the block holding it and the block height
+func (blockchain *Blockchain) LocateTransaction(ID []byte) (*Transaction, *Block, int, error) {
+  blockchain.mu.RLock()         // the index and the blocks must agree
+  defer blockchain.mu.RUnlock() // unlock it when done
+  if !blockchain.txIndex {
+    return nil, nil, 0, errNoTxIndex
+  }
+  value, err := blockchain.db.Get(storage.TxIndexBucket, ID) // look the block up in the index
+  if err != nil {
+    return nil, nil, 0, err
+  }
+  if value == nil {
+    return nil, nil, 0, errors.New("not in the main chain")
+  }
+  hash, position, ok := splitTxIndexValue(value)
+  if !ok {
+    return nil, nil, 0, errors.New("the transaction index holds a malformed entry")
+  }
+  block, height, ok := blockchain.getBlock(hash)
+  if !ok {
+    return nil, nil, 0, errors.New("the transaction index points to an unknown block")
+  }
+  if block.Pruned() {
+    return nil, nil, 0, errors.New("the block of the transaction was pruned")
+  }
+  if position >= len(block.Transactions) || !bytes.Equal(block.Transactions[position].ID, ID) {
+    return nil, nil, 0, errors.New("the transaction index points to the wrong block")
+  }
+  return block.Transactions[position], block, height, nil
+}
+
+// Define a struct for a main chain transaction found through the address index
+type AddressTx struct {
+  Tx     *Transaction // the transaction
+  Block  *Block       // the block holding it
+  Height int          // the height of the block
+}
+
+// create the method that finds the main chain transactions spending from or paying to an address, newest first, skipping
+// the first ones and returning at most count of them, all of them if count is 0, with the total number of transactions
+func (blockchain *Blockchain) AddressTransactions(address string, skip, count int) ([]AddressTx, int, error) {
+  blockchain.mu.RLock()         // the index and the blocks must agree
+  defer blockchain.mu.RUnlock() // unlock it when done
+  if !blockchain.addrIndex {
+    return nil, 0, errNoAddrIndex
+  }
+  var found []AddressTx // the blocks of the transactions, the transactions are only looked up for the page
+  var ids [][]byte      // the IDs of the transactions, in the same order
+  prefix := append([]byte(address), 0)
+  err := blockchain.db.ForEachPrefix(storage.AddrIndexBucket, prefix, func(key, hash []byte) error {
+    block, height, ok := blockchain.getBlock(hash)
+    if !ok {
+      return errors.New("the address index points to an unknown block")
+    }
+    if block.Pruned() { // the transactions of the old blocks are gone
+      return nil
+    }
+    found = append(found, AddressTx{nil, block, height})
+    ids = append(ids, append([]byte{}, key[len(prefix):]...)) // copy the ID, the key is only valid during the call
+    return nil
+  })
+  if err != nil {
+    return nil, 0, err
+  }
+  order := make([]int, len(found)) // the keys are sorted by ID, not by height
+  for i := range order {
+    order[i] = i
+  }
+  sort.SliceStable(order, func(i, j int) bool { return found[order[i]].Height > found[order[j]].Height })
+  total := len(order)
+  if skip > total {
+    skip = total
+  }
+  order = order[skip:]
+  if count > 0 && count < len(order) {
+    order = order[:count]
+  }
+  page := make([]AddressTx, 0, len(order))
+  for _, i := range order {
+    atx := found[i]
+    for _, tx := range atx.Block.Transactions { // find the transaction in the block
+      if bytes.Equal(tx.ID, ids[i]) {
+        atx.Tx = tx
+      }
+    }
+    if atx.Tx == nil {
+      return nil, 0, errors.New("the address index points to the wrong block")
+    }
+    page = append(page, atx)
+  }
+  return page, total, nil
+}
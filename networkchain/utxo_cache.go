@@ -0,0 +1,252 @@
+package main
+
+import (
+  "networkchain/storage" // the cache is layered over the UTXO set of the store
+  "sort"                 // the changed outputs are walked in key order with the stored ones
+  "sync"                 // the cache is read by the RPC and wallet goroutines while blocks are connected
+)
+
+// The metadata key holding the hash of the block the UTXO set of the store is at, the cache holds the changes of the blocks after it
+const utxoTipKey = "utxotip"
+
+// The memory the changes of the UTXO set may use before they are written to the store by default, in megabytes
+const defaultUTXOCacheSize = 32
+
+// The estimated memory used by an entry of the cache besides its key and value: the map slot and the slice header
+const utxoCacheEntryOverhead = 80
+
+// Create the utxoCache data structure
+// It keeps the changes the connected blocks make to the UTXO set in memory and writes them to the store in one batch once
+// they outgrow the memory budget, so a block does not rewrite its outputs on disk one by one; the store records the block
+// its set is at, and the blocks after it are connected again when the node restarts without flushing
+type utxoCache struct {
+  mu      sync.RWMutex      // the lock protecting the entries, held for writing while a batch changes the set
+  db      *storage.Store    // the store holding the set the entries change
+  entries map[string][]byte // the outputs changed since the last flush by key, nil for a spent output
+  size    int               // the estimated memory used by the entries, in bytes
+  budget  int               // the memory the entries may use before they are flushed, in bytes
+}
+
+// Create a function that returns an empty cache over the UTXO set of a store
+func newUTXOCache(db *storage.Store, budget int) *utxoCache {
+  return &utxoCache{db: db, entries: map[string][]byte{}, budget: budget}
+}
+
+// Create a function that estimates the memory used by an entry of the cache
+func utxoCacheEntrySize(key string, value []byte) int {
+  return len(key) + len(value) + utxoCacheEntryOverhead
+}
+
+// Create the utxoView data structure
+// A view reads and changes the UTXO set inside a transaction of the store: its changes go over the entries of the cache,
+// which go over the set of the store, and they only reach the cache if the transaction is written
+type utxoView struct {
+  cache   *utxoCache        // the cache the view reads through
+  batch   *storage.Batch    // the transaction of the store
+  changes map[string][]byte // the outputs changed through the view by key, nil for a spent output, nil for a read only view
+  cleared bool              // whether the set was emptied, the entries of the cache are gone with the stored outputs
+  flush   bool              // whether the cache is written to the store with the transaction, whatever its size
+}
+
+// Create a method that reads an output by its key, returning nil if it is spent or unknown
+func (v *utxoView) get(key []byte) []byte {
+  if value, ok := v.changes[string(key)]; ok {
+    return value
+  }
+  if !v.cleared {
+    if value, ok := v.cache.entries[string(key)]; ok {
+      return value
+    }
+  }
+  return v.batch.Get(storage.UTXOBucket, key) // the output did not change since the last flush
+}
+
+// Create a method that adds an output
+func (v *utxoView) put(key, value []byte) {
+  v.changes[string(key)] = value
+}
+
+// Create a method that spends an output
+func (v *utxoView) delete(key []byte) {
+  v.changes[string(key)] = nil
+}
+
+// Create a method that empties the set, the cache is written with the transaction so nothing stale remains
+func (v *utxoView) clear() error {
+  v.changes = map[string][]byte{}
+  v.cleared = true
+  v.flush = true
+  return v.batch.Clear(storage.UTXOBucket)
+}
+
+// Create a method that returns the outputs changed since the last flush, the ones of the view over the ones of the cache
+func (v *utxoView) overlay() map[string][]byte {
+  overlay := map[string][]byte{}
+  if !v.cleared {
+    for key, value := range v.cache.entries {
+      overlay[key] = value
+    }
+  }
+  for key, value := range v.changes {
+    overlay[key] = value
+  }
+  return overlay
+}
+
+// Create a method that calls a function for every unspent output, in key order like the stored set
+func (v *utxoView) forEach(fn func(key, value []byte) error) error {
+  overlay := v.overlay()
+  keys := make([]string, 0, len(overlay))
+  for key := range overlay {
+    keys = append(keys, key)
+  }
+  sort.Strings(keys)
+  next := 0 // the next changed output to walk
+  walkChanged := func(before []byte) error { // walk the changed outputs ordered before a stored key, all of them for nil
+    for ; next < len(keys) && (before == nil || keys[next] < string(before)); next++ {
+      if value := overlay[keys[next]]; value != nil { // spent outputs are skipped
+        if err := fn([]byte(keys[next]), value); err != nil {
+          return err
+        }
+      }
+    }
+    return nil
+  }
+  err := v.batch.ForEach(storage.UTXOBucket, func(key, value []byte) error {
+    if err := walkChanged(key); err != nil {
+      return err
+    }
+    if _, changed := overlay[string(key)]; changed { // the changed output is walked from the overlay
+      return nil
+    }
+    return fn(key, value)
+  })
+  if err != nil {
+    return err
+  }
+  return walkChanged(nil)
+}
+
+// Create a method that estimates the memory the changes of the view add to the cache
+func (v *utxoView) size() int {
+  size := 0
+  for key, value := range v.changes {
+    size += utxoCacheEntrySize(key, value)
+  }
+  return size
+}
+
+// Create a method that writes the cache and the changes of the view to the store, with the block the set is now at
+func (v *utxoView) write() error {
+  for key, value := range v.overlay() {
+    var err error
+    if value == nil {
+      err = v.batch.Delete(storage.UTXOBucket, []byte(key))
+    } else {
+      err = v.batch.Put(storage.UTXOBucket, []byte(key), value)
+    }
+    if err != nil {
+      return err
+    }
+  }
+  return v.batch.SetMeta(utxoTipKey, v.batch.Tip())
+}
+
+// Create a method that runs a function changing the UTXO set in a single transaction of the store, nothing changes if it fails
+// The changes go to the cache, or to the store with the whole cache when the view asks for it, the cache outgrows its
+// budget or the store does not record the block its set is at yet
+func (c *utxoCache) update(fn func(batch *storage.Batch, view *utxoView) error) error {
+  c.mu.Lock()         // the readers wait for the changes
+  defer c.mu.Unlock() // unlock it when done
+  var view *utxoView
+  written := false // whether the cache went to the store
+  err := c.db.Update(func(batch *storage.Batch) error {
+    view = &utxoView{cache: c, batch: batch, changes: map[string][]byte{}}
+    if err := fn(batch, view); err != nil {
+      return err
+    }
+    written = view.flush || c.size+view.size() > c.budget || batch.Meta(utxoTipKey) == nil
+    if !written {
+      return nil
+    }
+    return view.write()
+  })
+  if err != nil {
+    return err
+  }
+  if written { // the store holds everything
+    c.entries = map[string][]byte{}
+    c.size = 0
+    return nil
+  }
+  for key, value := range view.changes {
+    if old, ok := c.entries[key]; ok {
+      c.size -= utxoCacheEntrySize(key, old)
+    }
+    c.entries[key] = value
+    c.size += utxoCacheEntrySize(key, value)
+  }
+  return nil
+}
+
+// Create a method that runs a function reading the UTXO set through a read only view
+func (c *utxoCache) read(fn func(view *utxoView) error) error {
+  c.mu.RLock()         // the set must not change while it is read
+  defer c.mu.RUnlock() // unlock it when done
+  return c.db.View(func(batch *storage.Batch) error {
+    return fn(&utxoView{cache: c, batch: batch})
+  })
+}
+
+// Create a method that writes the cache to the store
+func (c *utxoCache) flush() error {
+  return c.update(func(batch *storage.Batch, view *utxoView) error {
+    view.flush = true
+    return nil
+  })
+}
+
+// Create a method that changes the memory budget of the cache, in megabytes
+func (c *utxoCache) setBudget(megabytes int) {
+  c.mu.Lock()         // the budget is read by the batches
+  defer c.mu.Unlock() // unlock it when done
+  c.budget = megabytes << 20
+}
+
+// create the method that connects again the main chain blocks after the block the UTXO set of the store is at: the
+// changes the cache held when the node stopped without flushing it
+func (blockchain *Blockchain) replayUTXO() {
+  flushed, err := blockchain.db.Meta(utxoTipKey)
+  if err != nil {
+    chainLog.Panic("Failed to read the block of the UTXO set", "err", err)
+  }
+  if flushed == nil { // a store written before the cache, its set is at the tip
+    return
+  }
+  node, ok := blockchain.index[indexKey(flushed)]
+  if !ok || !blockchain.onMainChain(node) { // a reorganization always flushes the cache
+    chainLog.Panic("The UTXO set is not at a block of the main chain, run reindex", "hash", flushed)
+  }
+  last := len(blockchain.Blocks) - 1
+  if node.height == last {
+    return
+  }
+  chainLog.Info("Replaying the blocks missing from the UTXO set", "from", node.height+1, "to", last)
+  err = blockchain.utxoCache.update(func(batch *storage.Batch, view *utxoView) error {
+    for height := node.height + 1; height <= last; height++ {
+      if err := connectUTXO(view, blockchain.Blocks[height], height); err != nil {
+        return err
+      }
+    }
+    view.flush = true // the set catches up with the tip at once
+    return nil
+  })
+  if err != nil {
+    chainLog.Panic("Failed to replay the blocks", "err", err)
+  }
+}
+
+// create the method that sets the memory the changes of the UTXO set may use before they are written to the store, in megabytes
+func (blockchain *Blockchain) SetUTXOCacheSize(megabytes int) {
+  blockchain.utxoCache.setBudget(megabytes)
+}
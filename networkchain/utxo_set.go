@@ -0,0 +1,300 @@
+package main
+
+import (
+  "bytes"                // to serialize the outputs
+  "encoding/binary"      // to encode the output index in the keys
+  "encoding/gob"         // to serialize the outputs
+  "encoding/hex"         // to key the spendable outputs by transaction ID
+  "fmt"                  // for the errors
+  "networkchain/storage" // the UTXO set lives in the store next to the blocks
+)
+
+// Create the UTXOSet data structure
+// It holds every unspent transaction output of the chain, so balances and spendable
+// outputs can be found without walking all the blocks
+type UTXOSet struct {
+  Blockchain *Blockchain // the chain the set belongs to
+}
+
+// Create a function that builds the key of an output: the transaction ID followed by the output index
+func outpointKey(txid []byte, vout int) []byte {
+  key := make([]byte, len(txid)+4)                       // room for the ID and the index
+  copy(key, txid)                                        // the ID first, so outputs of a transaction are next to each other
+  binary.BigEndian.PutUint32(key[len(txid):], uint32(vout)) // then the index
+  return key
+}
+
+// Create a function that splits an output key into the transaction ID and the output index
+func splitOutpointKey(key []byte) ([]byte, int) {
+  return key[:len(key)-4], int(binary.BigEndian.Uint32(key[len(key)-4:]))
+}
+
+// Create the utxoEntry data structure
+// An entry of the set is an output with the block that created it, so the outputs of a coinbase can be kept until they
+// mature; the fields of the output come first under the same names, so an entry decodes as a plain TXOutput and the
+// sets written before the entries had a height decode as entries of the genesis block
+type utxoEntry struct {
+  Value        int    // the amount of coins of the output
+  ScriptPubKey []byte // the script of the output
+  Height       int    // the height of the block holding the transaction
+  Coinbase     bool   // whether the transaction is a coinbase
+}
+
+// Create a method that returns the output of an entry
+func (entry utxoEntry) output() TXOutput {
+  return TXOutput{entry.Value, entry.ScriptPubKey}
+}
+
+// Create a method that tells if the output of an entry can be spent by the block at a height
+func (entry utxoEntry) mature(height int) bool {
+  return !entry.Coinbase || activeNet.CoinbaseMature(entry.Height, height)
+}
+
+// Create a function that serializes an entry
+func serializeEntry(entry utxoEntry) []byte {
+  var encoded bytes.Buffer
+  if err := gob.NewEncoder(&encoded).Encode(entry); err != nil { // encode the entry
+    chainLog.Panic("Failed to encode an output", "err", err)
+  }
+  return encoded.Bytes()
+}
+
+// Create a function that rebuilds an entry from its serialized form
+func deserializeEntry(data []byte) utxoEntry {
+  var entry utxoEntry
+  if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil { // decode the entry
+    chainLog.Panic("Failed to decode a stored output", "err", err)
+  }
+  return entry
+}
+
+// Create a function that rebuilds the output of an entry from its serialized form
+func deserializeOutput(data []byte) TXOutput {
+  return deserializeEntry(data).output()
+}
+
+// Create a method that walks every unspent output
+func (u UTXOSet) forEach(fn func(txid []byte, vout int, out TXOutput)) {
+  err := u.Blockchain.utxoCache.read(func(view *utxoView) error {
+    return view.forEach(func(key, value []byte) error {
+      txid, vout := splitOutpointKey(key)                        // decode the key
+      fn(append([]byte{}, txid...), vout, deserializeOutput(value)) // copy the ID, the key is only valid during the call
+      return nil
+    })
+  })
+  if err != nil {
+    chainLog.Panic("Failed to read the UTXO set", "err", err)
+  }
+}
+
+// Create a method that collects unspent outputs of an address until they cover an amount
+// The outputs of the coinbases the next block cannot spend yet are left out
+func (u UTXOSet) FindSpendableOutputs(address string, amount int) (int, map[string][]int) {
+  unspentOutputs := make(map[string][]int) // the output indexes, keyed by hex transaction ID
+  accumulated := 0                         // the value collected so far
+  next := u.Blockchain.GetBestHeight() + 1 // the height of the block that will hold the transaction
+  err := u.Blockchain.utxoCache.read(func(view *utxoView) error {
+    return view.forEach(func(key, value []byte) error {
+      entry := deserializeEntry(value)
+      out := entry.output()
+      if accumulated < amount && entry.mature(next) && out.CanBeUnlockedWith(address) { // keep collecting until the amount is reached
+        txid, vout := splitOutpointKey(key)
+        accumulated += out.Value
+        unspentOutputs[hex.EncodeToString(txid)] = append(unspentOutputs[hex.EncodeToString(txid)], vout)
+      }
+      return nil
+    })
+  })
+  if err != nil {
+    chainLog.Panic("Failed to read the UTXO set", "err", err)
+  }
+  return accumulated, unspentOutputs
+}
+
+// Create a method that finds an unspent output by its transaction ID and index
+func (u UTXOSet) FindOutput(txid []byte, vout int) (TXOutput, bool) {
+  entry, ok := u.findEntry(txid, vout)
+  return entry.output(), ok
+}
+
+// Create a method that finds the entry of an unspent output by its transaction ID and index
+func (u UTXOSet) findEntry(txid []byte, vout int) (utxoEntry, bool) {
+  var data []byte
+  err := u.Blockchain.utxoCache.read(func(view *utxoView) error {
+    data = view.get(outpointKey(txid, vout)) // look the output up, in the cache first
+    return nil
+  })
+  if err != nil {
+    chainLog.Panic("Failed to read the UTXO set", "txid", txid, "vout", vout, "err", err)
+  }
+  if data == nil {
+    return utxoEntry{}, false // spent or never created
+  }
+  return deserializeEntry(data), true
+}
+
+// Create a method that finds the unspent outputs spent by the inputs of a transaction, in input order
+func (u UTXOSet) SpentOutputs(tx *Transaction) ([]TXOutput, error) {
+  var outs []TXOutput
+  for _, in := range tx.Vin {
+    out, ok := u.FindOutput(in.Txid, in.Vout)
+    if !ok {
+      return nil, fmt.Errorf("output %x:%d is spent or unknown", in.Txid, in.Vout)
+    }
+    outs = append(outs, out)
+  }
+  return outs, nil
+}
+
+// Create a method that returns every unspent output of an address
+func (u UTXOSet) FindUTXO(address string) []TXOutput {
+  var outputs []TXOutput
+  u.forEach(func(txid []byte, vout int, out TXOutput) {
+    if out.CanBeUnlockedWith(address) {
+      outputs = append(outputs, out)
+    }
+  })
+  return outputs
+}
+
+// Create a method that computes the balance of an address
+func (u UTXOSet) Balance(address string) int {
+  balance := 0
+  for _, out := range u.FindUTXO(address) { // sum the unspent outputs of the address
+    balance += out.Value
+  }
+  return balance
+}
+
+// Create a method that counts the transactions with unspent outputs
+func (u UTXOSet) CountTransactions() int {
+  transactions := make(map[string]bool) // the distinct transaction IDs
+  u.forEach(func(txid []byte, vout int, out TXOutput) {
+    transactions[hex.EncodeToString(txid)] = true
+  })
+  return len(transactions)
+}
+
+// Create a method that rebuilds the whole set from the blocks of the chain
+func (u UTXOSet) Reindex() {
+  u.Blockchain.mu.Lock()         // the blocks must not change while the set is rebuilt
+  defer u.Blockchain.mu.Unlock() // unlock it when done
+  err := u.Blockchain.utxoCache.update(func(batch *storage.Batch, view *utxoView) error {
+    if err := view.clear(); err != nil { // start from an empty set, the cache goes with it
+      return err
+    }
+    if err := batch.Clear(storage.UndoBucket); err != nil {
+      return err
+    }
+    for height, block := range u.Blockchain.Blocks { // replay every block in order
+      if err := connectUTXO(view, block, height); err != nil {
+        return err
+      }
+    }
+    return u.Blockchain.reindexTransactions(batch) // the transaction index follows the same blocks
+  })
+  if err != nil {
+    chainLog.Panic("Failed to rebuild the UTXO set", "err", err)
+  }
+}
+
+// Create a function that updates the set with the transactions of a new block: spent outputs are removed, new ones added
+// The spent outputs are saved as the undo data of the block, so the block can be disconnected again
+func connectUTXO(view *utxoView, block *Block, height int) error {
+  if err := recordValidators(view, height); err != nil { // the set before the first block of an epoch chooses its validators
+    return err
+  }
+  var spent []utxoEntry // the outputs spent by the block, in input order
+  var checks []inputCheck // the scripts of the inputs, verified together once the block is read
+  verify := !activeNet.AssumedValid(height) // the ancestors of the assume-valid block were checked by the network
+  coinbaseValue, fees := 0, 0 // what the miner takes and what the transactions leave to it
+  for _, tx := range block.Transactions { // iterate over the transactions in order, a transaction may spend an earlier one of the same block
+    var err error
+    outputValue := 0 // the value created by the transaction
+    for i, out := range tx.Vout {
+      if outputValue, err = addValue(outputValue, out.Value); err != nil { // a sum wrapping around would create coins
+        return fmt.Errorf("transaction %x output %d of %d: %w", tx.ID, i, out.Value, err)
+      }
+    }
+    if tx.IsCoinbase() {
+      coinbaseValue += outputValue
+    } else {
+      inputValue := 0 // the value of the outputs spent by the transaction
+      for i, in := range tx.Vin { // remove the outputs spent by the inputs
+        key := outpointKey(in.Txid, in.Vout)
+        data := view.get(key)
+        if data == nil {
+          return fmt.Errorf("transaction %x spends missing output %x:%d", tx.ID, in.Txid, in.Vout)
+        }
+        entry := deserializeEntry(data)
+        if !entry.mature(height) { // the coinbase could still vanish in a reorganization
+          return fmt.Errorf("transaction %x spends output %x:%d of the coinbase at height %d: %w", tx.ID, in.Txid, in.Vout, entry.Height, errImmatureSpend)
+        }
+        if inputValue, err = addValue(inputValue, entry.Value); err != nil {
+          return fmt.Errorf("transaction %x input %d of %d: %w", tx.ID, i, entry.Value, err)
+        }
+        spent = append(spent, entry)
+        view.delete(key)
+        if verify {
+          checks = append(checks, inputCheck{tx, i, entry.output()})
+        }
+      }
+      if outputValue > inputValue {
+        return fmt.Errorf("transaction %x: %w", tx.ID, errValueMismatch)
+      }
+      if fees, err = addValue(fees, inputValue-outputValue); err != nil { // whatever is not spent goes to the miner
+        return fmt.Errorf("block %x: the fees of transaction %x: %w", block.MyBlockHash, tx.ID, err)
+      }
+    }
+    for vout, out := range tx.Vout { // add the new outputs
+      entry := utxoEntry{out.Value, out.ScriptPubKey, height, tx.IsCoinbase()}
+      view.put(outpointKey(tx.ID, vout), serializeEntry(entry))
+    }
+  }
+  if err := verifyInputs(checks); err != nil { // the owners of the outputs must have signed
+    return err
+  }
+  subsidy := activeNet.BlockSubsidy(height)
+  if height == 0 { // the genesis block pays the premine too
+    subsidy += activeNet.Premine()
+  }
+  if coinbaseValue > subsidy+fees { // the miner only gets the subsidy of the height and the fees
+    return fmt.Errorf("coinbase of block %x at height %d pays %d, more than the subsidy %d and the fees %d", block.MyBlockHash, height, coinbaseValue, subsidy, fees)
+  }
+  var undo bytes.Buffer
+  if err := gob.NewEncoder(&undo).Encode(spent); err != nil { // encode the undo data
+    return err
+  }
+  return view.batch.Put(storage.UndoBucket, block.MyBlockHash, undo.Bytes())
+}
+
+// Create a function that reverts the changes of a block to the set: its outputs are removed and the outputs it spent come back
+func disconnectUTXO(view *utxoView, block *Block) error {
+  data := view.batch.Get(storage.UndoBucket, block.MyBlockHash) // read the outputs the block spent
+  if data == nil {
+    return fmt.Errorf("no undo data for block %x", block.MyBlockHash)
+  }
+  var spent []utxoEntry // the undo data written before the entries had a height decodes too
+  if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&spent); err != nil {
+    return err
+  }
+  for i := len(block.Transactions) - 1; i >= 0; i-- { // undo the transactions in reverse order
+    tx := block.Transactions[i]
+    for vout := range tx.Vout { // the outputs of the transaction never existed before the block
+      view.delete(outpointKey(tx.ID, vout))
+    }
+    if tx.IsCoinbase() {
+      continue
+    }
+    for j := len(tx.Vin) - 1; j >= 0; j-- { // the spent outputs are unspent again
+      in := tx.Vin[j]
+      if len(spent) == 0 {
+        return fmt.Errorf("undo data of block %x is too short", block.MyBlockHash)
+      }
+      entry := spent[len(spent)-1]
+      spent = spent[:len(spent)-1]
+      view.put(outpointKey(in.Txid, in.Vout), serializeEntry(entry))
+    }
+  }
+  return view.batch.Delete(storage.UndoBucket, block.MyBlockHash) // the block is no longer connected
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+  "bytes"                 // to compare hashes
+  "encoding/hex"          // to key the spent outputs
+  "errors"                // for the validation errors
+  "fmt"                   // to format the validation errors
+  "math"                  // to bound the lock times
+  "networkchain/chaincfg" // for the most coins a value may hold
+  "networkchain/script"   // to check the output scripts
+  "time"                  // for the time of the network
+)
+
+// An error returned while connecting a block whose transactions do not balance
+var errValueMismatch = errors.New("transaction spends more than its inputs")
+
+// An error returned for an output, or a sum of the outputs or the inputs of a transaction, over chaincfg.MaxMoney
+var errValueOutOfRange = errors.New("value is over the most coins there can be")
+
+// An error returned for a transaction whose lock time is not reached
+var errNotFinal = errors.New("the lock time of the transaction is not reached")
+
+// An error returned for a transaction creating an output worth less than the dust threshold of the network
+var errDust = errors.New("output is dust")
+
+// An error returned for a block older than the median time past of its parent
+var errTimeTooOld = errors.New("block timestamp is before the median time past")
+
+// An error returned for a block whose timestamp is too far ahead of the clock of the node
+var errTimeTooNew = errors.New("block timestamp is too far in the future")
+
+// An error returned for a block whose serialized size is over the limit of the network
+var errBlockTooLarge = errors.New("block is too large")
+
+// An error returned for a transaction too large to fit in a block
+var errTxTooLarge = errors.New("transaction is too large")
+
+// An error returned for a transaction spending the outputs of a coinbase too young
+var errImmatureSpend = errors.New("coinbase output is not mature")
+
+// An error returned for a block that contradicts the checkpoints of the network
+var errCheckpoint = errors.New("block contradicts a checkpoint")
+
+// create the function that runs the checks of a transaction that do not depend on the chain
+func checkTransaction(tx *Transaction) error {
+  if len(tx.Vin) == 0 || len(tx.Vout) == 0 { // a transaction must spend and create something
+    return fmt.Errorf("transaction %x has no inputs or no outputs", tx.ID)
+  }
+  if !bytes.Equal(tx.Hash(), tx.ID) { // the ID must match the content
+    return fmt.Errorf("transaction %x has an invalid ID", tx.ID)
+  }
+  if tx.LockTime < 0 || tx.LockTime > math.MaxUint32 { // the lock time reads like the ones of bitcoin
+    return fmt.Errorf("transaction %x has an invalid lock time %d", tx.ID, tx.LockTime)
+  }
+  outputValue := 0 // the value created by the transaction
+  for i, out := range tx.Vout {
+    if out.Value < 0 { // negative outputs would create coins
+      return fmt.Errorf("transaction %x has a negative output", tx.ID)
+    }
+    var err error
+    if outputValue, err = addValue(outputValue, out.Value); err != nil { // a sum wrapping around would create coins too
+      return fmt.Errorf("transaction %x output %d of %d: %w", tx.ID, i, out.Value, err)
+    }
+    if err := script.Check(out.ScriptPubKey); err != nil { // coins locked by a malformed script could never be spent
+      return fmt.Errorf("transaction %x has an invalid output script: %w", tx.ID, err)
+    }
+  }
+  if !tx.IsCoinbase() {
+    for _, in := range tx.Vin {
+      if len(in.Txid) == 0 || in.Vout < 0 { // only the coinbase may reference nothing
+        return fmt.Errorf("transaction %x has an input without a previous output", tx.ID)
+      }
+    }
+  }
+  return nil
+}
+
+// create the function that adds a value to a running sum of the outputs or the inputs of a transaction, checking neither
+// the value nor the sum goes over chaincfg.MaxMoney before adding, so the sum cannot overflow
+func addValue(sum, value int) (int, error) {
+  if value > chaincfg.MaxMoney || sum > chaincfg.MaxMoney-value {
+    return sum, errValueOutOfRange
+  }
+  return sum + value, nil
+}
+
+// create the function that runs the checks of a block that do not depend on the chain:
+// seal, timestamp against the time of the network, size, merkle root, coinbase placement, transaction format and double
+// spends inside the block
+func CheckBlock(block *Block, now time.Time) error {
+  if err := block.VerifySeal(); err != nil { // the hash must come from the header and meet the target, or be signed
+    return err
+  }
+  return checkBlockBody(block, now)
+}
+
+// create the function that runs the checks of CheckBlock but the seal, so a proposal can be checked before it is voted on
+func checkBlockBody(block *Block, now time.Time) error {
+  if err := checkFutureTime(block, now); err != nil { // the block cannot come from the future
+    return err
+  }
+  if size := len(block.Serialize()); size > activeNet.BlockSizeLimit() { // every node must be able to store and relay it
+    return fmt.Errorf("block %x has %d bytes, over the limit of %d: %w", block.MyBlockHash, size, activeNet.BlockSizeLimit(), errBlockTooLarge)
+  }
+  if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() { // the first transaction pays the miner
+    return fmt.Errorf("block %x does not start with a coinbase", block.MyBlockHash)
+  }
+  if !bytes.Equal(block.MerkleRoot, block.HashTransactions()) { // the header must commit to these transactions
+    return fmt.Errorf("block %x has an invalid merkle root", block.MyBlockHash)
+  }
+  seenTxs := map[string]bool{}   // the transaction IDs of the block
+  spent := map[string]bool{}     // the outputs spent by the block
+  for i, tx := range block.Transactions {
+    if i > 0 && tx.IsCoinbase() {
+      return fmt.Errorf("block %x has more than one coinbase", block.MyBlockHash)
+    }
+    if err := checkTransaction(tx); err != nil {
+      return err
+    }
+    if seenTxs[hex.EncodeToString(tx.ID)] {
+      return fmt.Errorf("block %x contains transaction %x twice", block.MyBlockHash, tx.ID)
+    }
+    seenTxs[hex.EncodeToString(tx.ID)] = true
+    if tx.IsCoinbase() {
+      continue
+    }
+    for _, in := range tx.Vin { // an output can only be spent once
+      key := hex.EncodeToString(outpointKey(in.Txid, in.Vout))
+      if spent[key] {
+        return fmt.Errorf("block %x spends output %x:%d twice", block.MyBlockHash, in.Txid, in.Vout)
+      }
+      spent[key] = true
+    }
+  }
+  return nil
+}
+
+// create the function that checks the timestamp of a block is not further ahead of the time of the network, the clock
+// adjusted by the peers, than the drift of the network
+func checkFutureTime(block *Block, now time.Time) error {
+  limit := now.Add(activeNet.MaxFutureBlockTime()).Unix()
+  if block.Timestamp > limit {
+    return fmt.Errorf("block %x has timestamp %d, after %d: %w", block.MyBlockHash, block.Timestamp, limit, errTimeTooNew)
+  }
+  return nil
+}
+
+// create the function that checks a block against the block it builds on: the header and the lock times of its transactions
+// The signers of the block depend on the validators of its epoch and are checked when it is connected
+func checkBlockContext(block *Block, parent *blockNode) error {
+  if expected := nextBits(parent); block.Bits != expected { // the target cannot be chosen by the miner
+    return fmt.Errorf("block %x has target %08x, expected %08x", block.MyBlockHash, block.Bits, expected)
+  }
+  if mtp := parent.medianTimePast(); block.Timestamp < mtp { // the time of the chain only moves forward
+    return fmt.Errorf("block %x has timestamp %d, the median of the last %d blocks is %d: %w", block.MyBlockHash, block.Timestamp, medianTimeBlocks, mtp, errTimeTooOld)
+  }
+  if !activeNet.IsProofOfWork() && block.Timestamp < parent.block.Timestamp { // the slots of the proposers count from the parent
+    return fmt.Errorf("block %x is older than its parent", block.MyBlockHash)
+  }
+  for _, tx := range block.Transactions { // a header alone has none
+    if !tx.IsFinal(parent.height+1, block.Timestamp) {
+      return fmt.Errorf("block %x holds transaction %x locked until %d: %w", block.MyBlockHash, tx.ID, tx.LockTime, errNotFinal)
+    }
+  }
+  return nil
+}
+
+// create the function that checks a block at a height against the checkpoints of the network: the block at the height
+// of a checkpoint must be the checkpoint, and once the best chain reached a checkpoint no block may fork below it
+func checkCheckpoints(block *Block, height, bestHeight int) error {
+  if checkpoint, ok := activeNet.CheckpointAt(height); ok && hex.EncodeToString(block.MyBlockHash) != checkpoint.Hash {
+    return fmt.Errorf("block %x at height %d is not the checkpoint %s: %w", block.MyBlockHash, height, checkpoint.Hash, errCheckpoint)
+  }
+  if checkpoint, ok := activeNet.LastCheckpoint(bestHeight); ok && height <= checkpoint.Height { // the chain already has a block there
+    return fmt.Errorf("block %x at height %d forks the chain below the checkpoint at height %d: %w", block.MyBlockHash, height, checkpoint.Height, errCheckpoint)
+  }
+  return nil
+}
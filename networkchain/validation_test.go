@@ -0,0 +1,77 @@
+package main
+
+import (
+  "errors"                // to match the errors
+  "math"                  // for the values that overflow
+  "networkchain/chaincfg" // for the most coins a value may hold
+  "networkchain/script"   // for the scripts of the outputs
+  "testing"               // the test framework
+)
+
+// Define a function to make a transaction spending an output with outputs of some values, its ID matching its content
+func newValueTransaction(values ...int) *Transaction {
+  tx := &Transaction{Vin: []TXInput{{Txid: make([]byte, 32), Vout: 0}}}
+  for _, value := range values {
+    tx.Vout = append(tx.Vout, TXOutput{value, script.PayToPubKeyHash(make([]byte, 20))})
+  }
+  tx.ID = tx.Hash()
+  return tx
+}
+
+func TestCheckTransactionValues(t *testing.T) {
+  tests := []struct {
+    name   string
+    values []int
+    err    error // the error wrapped, nil for a valid transaction
+  }{
+    {"a single output", []int{10}, nil},
+    {"the most coins there can be", []int{chaincfg.MaxMoney}, nil},
+    {"outputs summing to the most coins", []int{chaincfg.MaxMoney - 1, 1}, nil},
+    {"an output over the most coins", []int{chaincfg.MaxMoney + 1}, errValueOutOfRange},
+    {"outputs summing over the most coins", []int{chaincfg.MaxMoney, 1}, errValueOutOfRange},
+    {"outputs wrapping around", []int{math.MaxInt64, 1}, errValueOutOfRange},
+    {"outputs wrapping around twice", []int{math.MaxInt64, math.MaxInt64, 2}, errValueOutOfRange},
+  }
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      if err := checkTransaction(newValueTransaction(test.values...)); !errors.Is(err, test.err) {
+        t.Errorf("err = %v, want %v", err, test.err)
+      }
+    })
+  }
+}
+
+// Connecting a block checks the sums again, the inputs and the fees are only known there
+func TestConnectUTXOValues(t *testing.T) {
+  tests := []struct {
+    name    string
+    inputs  []int // the values of the outputs spent
+    outputs []int
+    err     error
+  }{
+    {"outputs wrapping around", []int{10}, []int{math.MaxInt64, 1}, errValueOutOfRange},
+    {"outputs over the inputs", []int{10}, []int{11}, errValueMismatch},
+    {"inputs summing over the most coins", []int{chaincfg.MaxMoney, 1}, []int{1}, errValueOutOfRange},
+    {"inputs wrapping around", []int{math.MaxInt64, math.MaxInt64, 2}, []int{chaincfg.MaxMoney}, errValueOutOfRange},
+  }
+  for _, test := range tests {
+    t.Run(test.name, func(t *testing.T) {
+      cache := newUTXOCache(nil, 0)
+      tx := newValueTransaction(test.outputs...)
+      tx.Vin = nil
+      for i, value := range test.inputs { // the spent outputs are in the cache, so the view never reads the store
+        txid := []byte{byte(i + 1)}
+        cache.entries[string(outpointKey(txid, 0))] = serializeEntry(utxoEntry{value, tx.Vout[0].ScriptPubKey, 0, false})
+        tx.Vin = append(tx.Vin, TXInput{Txid: txid, Vout: 0})
+      }
+      tx.ID = tx.Hash()
+      coinbase := &Transaction{Vin: []TXInput{{Vout: -1}}, Vout: []TXOutput{{0, tx.Vout[0].ScriptPubKey}}}
+      coinbase.ID = coinbase.Hash()
+      block := &Block{Transactions: []*Transaction{coinbase, tx}}
+      view := &utxoView{cache: cache, changes: map[string][]byte{}}
+      if err := connectUTXO(view, block, activeNet.CoinbaseMaturity+1); !errors.Is(err, test.err) {
+        t.Errorf("err = %v, want %v", err, test.err)
+      }
+    })
+  }
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+  "fmt"                  // to print the results
+  "networkchain/address" // to check the addresses given
+  "networkchain/wallet"  // the keys of the validators
+  "sort"                 // the bonds are printed by address
+
+  "github.com/spf13/cobra" // the command line interface
+)
+
+// Create the command grouping the commands managing the validators of a network rotating them
+func validatorCmd() *cobra.Command {
+  var passphrase string
+  cmd := &cobra.Command{
+    Use:   "validator",
+    Short: "Register, bond and unbond the validators of a proof of stake or BFT network rotating them",
+  }
+  cmd.PersistentFlags().StringVar(&passphrase, "passphrase", "", "passphrase of the wallet file holding the key of the validator")
+  cmd.AddCommand(validatorBondCmd(&passphrase, true), validatorBondCmd(&passphrase, false), validatorUnbondCmd(&passphrase), validatorListCmd())
+  return cmd
+}
+
+// Create the function that refuses the validator commands on a network whose validators never change
+func checkRotating() error {
+  if !activeNet.RotatesValidators() {
+    return fmt.Errorf("the validators of %s are fixed by its parameters", activeNet.Name)
+  }
+  return nil
+}
+
+// Create the command that bonds coins of an address, register making it a new validator with at least the minimum stake
+func validatorBondCmd(passphrase *string, register bool) *cobra.Command {
+  var addr, node string
+  var amount, fee int
+  cmd := &cobra.Command{
+    Use:   "bond",
+    Short: "Bond more coins of a validator, raising its stake from the next epoch",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      cfg, err := loadConfig(cmd) // find the data directory, the network and the first node
+      if err != nil {
+        return err
+      }
+      if err := checkRotating(); err != nil {
+        return err
+      }
+      if !address.Validate(addr) {
+        return fmt.Errorf("invalid validator address %q", addr)
+      }
+      bc := NewBlockchain(cfg.DataDir, addr) // open the chain, the node must not be running
+      defer bc.Close()
+      utxoSet := &UTXOSet{bc}
+      if register {
+        if len(utxoSet.FindBonds(addr)) > 0 {
+          return fmt.Errorf("%s is already registered, bond more coins with validator bond", addr)
+        }
+        if amount < activeNet.MinStake {
+          return fmt.Errorf("a validator must bond at least %d coins", activeNet.MinStake)
+        }
+      } else if len(utxoSet.FindBonds(addr)) == 0 {
+        return fmt.Errorf("%s is not registered, register it with validator register", addr)
+      }
+      wallets, err := wallet.LoadWallets(cfg.DataDir, []byte(*passphrase)) // the key of the validator signs the transaction
+      if err != nil {
+        return err
+      }
+      tx, err := NewBondTransaction(wallets, addr, amount, fee, utxoSet)
+      if err != nil {
+        return err
+      }
+      if err := handOver(bc, cfg, node, tx); err != nil {
+        return err
+      }
+      fmt.Printf("Sent bond transaction %x, the stake counts from epoch %d\n", tx.ID, activeNet.Epoch(bc.GetBestHeight()+1)+1)
+      return nil
+    },
+  }
+  if register {
+    cmd.Use = "register"
+    cmd.Short = "Register an address as a validator from the next epoch by bonding at least the minimum stake"
+  }
+  flags := cmd.Flags()
+  flags.StringVar(&addr, "address", "", "address of the validator, its key signs the blocks")
+  flags.IntVar(&amount, "amount", 0, "amount of coins to bond")
+  flags.IntVar(&fee, "fee", 0, "fee left to the miner of the transaction")
+  flags.StringVar(&node, "node", "", "address of the node receiving the transaction, the first node by default")
+  cmd.MarkFlagRequired("address")
+  cmd.MarkFlagRequired("amount")
+  return cmd
+}
+
+// Create the command that unbonds every bond of a validator, leaving the validators at the next epoch
+func validatorUnbondCmd(passphrase *string) *cobra.Command {
+  var addr, node string
+  var fee int
+  cmd := &cobra.Command{
+    Use:   "unbond",
+    Short: "Pay the bonds of a validator back to its address, removing it from the next epoch",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      cfg, err := loadConfig(cmd)
+      if err != nil {
+        return err
+      }
+      if err := checkRotating(); err != nil {
+        return err
+      }
+      if !address.Validate(addr) {
+        return fmt.Errorf("invalid validator address %q", addr)
+      }
+      bc := NewBlockchain(cfg.DataDir, addr) // open the chain, the node must not be running
+      defer bc.Close()
+      wallets, err := wallet.LoadWallets(cfg.DataDir, []byte(*passphrase))
+      if err != nil {
+        return err
+      }
+      tx, err := NewUnbondTransaction(wallets, addr, fee, &UTXOSet{bc})
+      if err != nil {
+        return err
+      }
+      if err := handOver(bc, cfg, node, tx); err != nil {
+        return err
+      }
+      fmt.Printf("Sent unbond transaction %x\n", tx.ID)
+      return nil
+    },
+  }
+  flags := cmd.Flags()
+  flags.StringVar(&addr, "address", "", "address of the validator")
+  flags.IntVar(&fee, "fee", 0, "fee left to the miner of the transaction, taken from the bonds")
+  flags.StringVar(&node, "node", "", "address of the node receiving the transaction, the first node by default")
+  cmd.MarkFlagRequired("address")
+  return cmd
+}
+
+// Create the command that prints the validators signing the next block and the bonds that will pick the next ones
+func validatorListCmd() *cobra.Command {
+  cmd := &cobra.Command{
+    Use:   "list",
+    Short: "Print the validators of the current epoch and the bonded stakes",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      cfg, err := loadConfig(cmd)
+      if err != nil {
+        return err
+      }
+      if err := checkRotating(); err != nil {
+        return err
+      }
+      bc := NewBlockchain(cfg.DataDir, "") // open the chain, the node must not be running
+      defer bc.Close()
+      validators, height, err := bc.NextValidators()
+      if err != nil {
+        return err
+      }
+      epoch := activeNet.Epoch(height)
+      fmt.Printf("Validators of epoch %d, blocks %d to %d:\n", epoch, epoch*activeNet.EpochLength, (epoch+1)*activeNet.EpochLength-1)
+      for _, validator := range validators {
+        fmt.Printf("  %s stake %d\n", validator.Address, validator.Stake)
+      }
+      stakes := map[string]int{} // the bonded coins of each address
+      var bonded []string        // the bonded addresses
+      for _, bond := range (UTXOSet{bc}).FindBonds("") {
+        if _, ok := stakes[bond.Address]; !ok {
+          bonded = append(bonded, bond.Address)
+        }
+        stakes[bond.Address] += bond.Value
+      }
+      sort.Strings(bonded)
+      if len(bonded) == 0 {
+        fmt.Println("No bonds, the validators of the parameters keep signing")
+        return nil
+      }
+      fmt.Printf("Bonds, validators from epoch %d with at least %d coins:\n", epoch+1, activeNet.MinStake)
+      for _, addr := range bonded {
+        fmt.Printf("  %s bonded %d\n", addr, stakes[addr])
+      }
+      return nil
+    },
+  }
+  return cmd
+}
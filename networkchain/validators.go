@@ -0,0 +1,212 @@
+package main
+
+import (
+  "bytes"                  // to serialize the validators of an epoch
+  "encoding/binary"        // to encode the epochs in the keys
+  "encoding/gob"           // to serialize the validators of an epoch
+  "errors"                 // for the errors of the validator transactions
+  "fmt"                    // to format the errors
+  "networkchain/address"   // a validator is a key hash address
+  "networkchain/chaincfg"  // the validators of the parameters
+  "networkchain/consensus" // the engine of an epoch
+  "networkchain/script"    // the bond scripts
+  "networkchain/storage"   // the validators are recorded in the store
+  "networkchain/wallet"    // to sign the validator transactions
+  "sort"                   // the validators are ordered by address
+)
+
+// Define a struct for a bond of the UTXO set: coins an address locked to count as a validator stake
+type Bond struct {
+  Txid    []byte // the ID of the transaction holding the bond
+  Vout    int    // the index of the bond in that transaction
+  Value   int    // the bonded coins
+  Address string // the address of the validator
+}
+
+// Create a function that builds the key of the validators of an epoch
+func epochKey(epoch int) []byte {
+  key := make([]byte, 8)
+  binary.BigEndian.PutUint64(key, uint64(epoch)) // in epoch order
+  return key
+}
+
+// Create a function that computes the validators from the bonds of the UTXO set: every address whose bonds add up
+// to the minimum stake, with its bonded coins as stake, by address so every node gets the same order
+// Without any, the validators of the parameters keep signing so the chain does not stop
+func bondedValidators(view *utxoView) ([]chaincfg.Validator, error) {
+  stakes := map[string]int{} // the bonded coins of each address
+  err := view.forEach(func(key, value []byte) error {
+    out := deserializeOutput(value)
+    if bonded := script.ExtractBondAddress(out.ScriptPubKey); bonded != "" {
+      stakes[bonded] += out.Value
+    }
+    return nil
+  })
+  if err != nil {
+    return nil, err
+  }
+  var validators []chaincfg.Validator
+  for bonded, stake := range stakes {
+    if stake >= activeNet.MinStake {
+      validators = append(validators, chaincfg.Validator{Address: bonded, Stake: stake})
+    }
+  }
+  if len(validators) == 0 {
+    return activeNet.Validators, nil
+  }
+  sort.Slice(validators, func(i, j int) bool { return validators[i].Address < validators[j].Address })
+  return validators, nil
+}
+
+// Create a function that finds the validators of the block at a height, through a view of the UTXO set after its parent
+// The first block of an epoch takes them from the bonds, the next blocks of the epoch from the record written when it was connected
+func validatorSet(view *utxoView, height int) ([]chaincfg.Validator, error) {
+  epoch := activeNet.Epoch(height)
+  if epoch == 0 { // the validators of the parameters sign the first epoch
+    return activeNet.Validators, nil
+  }
+  if height%activeNet.EpochLength == 0 { // a record may be left by a branch that was reorganized away, the bonds are the truth
+    return bondedValidators(view)
+  }
+  data := view.batch.Get(storage.ValidatorsBucket, epochKey(epoch))
+  if data == nil {
+    return nil, fmt.Errorf("the validators of epoch %d are not recorded", epoch)
+  }
+  var validators []chaincfg.Validator
+  if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&validators); err != nil {
+    return nil, err
+  }
+  return validators, nil
+}
+
+// Create a function that records the validators of an epoch when its first block is connected, before its transactions change the bonds
+func recordValidators(view *utxoView, height int) error {
+  if activeNet.Epoch(height) == 0 || height%activeNet.EpochLength != 0 { // not the first block of a rotated epoch
+    return nil
+  }
+  validators, err := bondedValidators(view)
+  if err != nil {
+    return err
+  }
+  var encoded bytes.Buffer
+  if err := gob.NewEncoder(&encoded).Encode(validators); err != nil {
+    return err
+  }
+  return view.batch.Put(storage.ValidatorsBucket, epochKey(activeNet.Epoch(height)), encoded.Bytes())
+}
+
+// Create a function that returns the consensus engine of the block at a height, with the validators of its epoch
+func validatorEngine(view *utxoView, height int) (consensus.Engine, error) {
+  if !activeNet.RotatesValidators() {
+    return activeEngine(), nil
+  }
+  validators, err := validatorSet(view, height)
+  if err != nil {
+    return nil, err
+  }
+  return consensus.NewWithValidators(activeNet, validators), nil
+}
+
+// Create a function that checks the signers of a block being connected are validators of its epoch
+func checkValidators(view *utxoView, node *blockNode) error {
+  engine, err := validatorEngine(view, node.height)
+  if err != nil {
+    return err
+  }
+  if err := engine.VerifyHeader(node.block.consensusHeader(), node.parent.block.consensusHeader()); err != nil { // the signer must be the proposer of the slot
+    return fmt.Errorf("block %x: %w", node.block.MyBlockHash, err)
+  }
+  return nil
+}
+
+// create the method that returns the consensus engine of the block at a height, the next one of the main chain at most
+// The lock must be held
+func (blockchain *Blockchain) engineAt(height int) (consensus.Engine, error) {
+  var engine consensus.Engine
+  err := blockchain.utxoCache.read(func(view *utxoView) error {
+    var err error
+    engine, err = validatorEngine(view, height)
+    return err
+  })
+  return engine, err
+}
+
+// create the method that returns the validators signing the next block, with its height
+func (blockchain *Blockchain) NextValidators() ([]chaincfg.Validator, int, error) {
+  blockchain.mu.RLock()         // lock the chain for reading
+  defer blockchain.mu.RUnlock() // unlock it when done
+  height := blockchain.tipNode().height + 1
+  var validators []chaincfg.Validator
+  err := blockchain.utxoCache.read(func(view *utxoView) error {
+    var err error
+    validators, err = validatorSet(view, height)
+    return err
+  })
+  return validators, height, err
+}
+
+// create the method that returns the consensus engine of the next block, with its height
+func (blockchain *Blockchain) nextEngine() (consensus.Engine, int, error) {
+  blockchain.mu.RLock()         // lock the chain for reading
+  defer blockchain.mu.RUnlock() // unlock it when done
+  height := blockchain.tipNode().height + 1
+  engine, err := blockchain.engineAt(height)
+  return engine, height, err
+}
+
+// Create a method that returns the bonds of an address, or of every validator if the address is empty
+func (u UTXOSet) FindBonds(addr string) []Bond {
+  var bonds []Bond
+  u.forEach(func(txid []byte, vout int, out TXOutput) {
+    if bonded := script.ExtractBondAddress(out.ScriptPubKey); bonded != "" && (addr == "" || bonded == addr) {
+      bonds = append(bonds, Bond{txid, vout, out.Value, bonded})
+    }
+  })
+  return bonds
+}
+
+// Create a function that makes a transaction bonding coins of an address to it, leaving a fee to the miner
+// The bonds of an address add up to its stake; it joins the validators at the next epoch once they reach the minimum stake
+func NewBondTransaction(wallets *wallet.Wallets, addr string, amount, fee int, utxoSet *UTXOSet) (*Transaction, error) {
+  version, pubKeyHash, err := address.Decode(addr)
+  if err != nil {
+    return nil, err
+  }
+  if version != address.PubKeyHashVersion { // the key of the address signs the blocks
+    return nil, fmt.Errorf("%s is not the address of a key", addr)
+  }
+  if amount <= 0 {
+    return nil, errors.New("the bonded amount must be positive")
+  }
+  return newTransaction(wallets, addr, TXOutput{amount, script.Bond(pubKeyHash)}, fee, 0, utxoSet)
+}
+
+// Create a function that makes a transaction unbonding every bond of an address, paying the coins back to it less a fee
+// The address leaves the validators at the next epoch
+func NewUnbondTransaction(wallets *wallet.Wallets, addr string, fee int, utxoSet *UTXOSet) (*Transaction, error) {
+  bonds := utxoSet.FindBonds(addr)
+  if len(bonds) == 0 {
+    return nil, fmt.Errorf("%s has no bonds", addr)
+  }
+  var inputs []TXInput    // the inputs spending the bonds
+  var prevOuts []TXOutput // and the bonds they spend for the signatures
+  total := 0
+  for _, bond := range bonds {
+    inputs = append(inputs, TXInput{Txid: bond.Txid, Vout: bond.Vout})
+    out, _ := utxoSet.FindOutput(bond.Txid, bond.Vout)
+    prevOuts = append(prevOuts, out)
+    total += bond.Value
+  }
+  if fee < 0 || fee >= total {
+    return nil, fmt.Errorf("the fee %d cannot be negative or take the whole bond of %d", fee, total)
+  }
+  refund, err := NewTXOutput(total-fee, addr)
+  if err != nil {
+    return nil, err
+  }
+  tx := &Transaction{nil, inputs, []TXOutput{refund}, 0}
+  if err := tx.Sign(wallets, prevOuts); err != nil { // the key of the validator unlocks its bonds
+    return nil, err
+  }
+  return tx, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+  "bytes"                // to compare the hashes read back
+  "encoding/gob"         // to decode the undo data
+  "errors"               // for the error discarding the checks of the UTXO set
+  "fmt"                  // to describe the corrupt blocks
+  "networkchain/storage" // the blocks are read back from the store
+)
+
+// Define the levels of verifychain, each one runs the checks of the levels below it
+const (
+  verifyRead   = 0 // the blocks are read back from the store and link to their parent
+  verifyBlocks = 1 // they pass the checks of a new block: seal, transactions, target and timestamp against the parent
+  verifyUndo   = 2 // their undo data is stored and lists one output per input spent
+  verifyUTXO   = 3 // they are disconnected from the UTXO set with their undo data and connected again, signatures included
+)
+
+// Define the default checks of verifychain: the last blocks are the ones a crash may have left corrupt
+const (
+  defaultVerifyLevel  = verifyUTXO
+  defaultVerifyBlocks = 6
+)
+
+// The error making the batch checking the UTXO set roll back, nothing is written
+var errVerified = errors.New("verified")
+
+// create the method that checks the last blocks of the main chain, all of them if depth is 0, at a level of thoroughness
+// The blocks are checked from the tip back; it returns an error naming the first bad block found
+// The pruned blocks have no transactions left to check and are skipped
+func (blockchain *Blockchain) VerifyChain(level, depth int) error {
+  if level < verifyRead || level > verifyUTXO {
+    return fmt.Errorf("the level must be between %d and %d", verifyRead, verifyUTXO)
+  }
+  blockchain.mu.Lock()         // the blocks must not change while they are checked
+  defer blockchain.mu.Unlock() // unlock it when done
+  tip := len(blockchain.Blocks) - 1
+  first := 0 // the first height checked
+  if depth > 0 && tip-depth+1 > first {
+    first = tip - depth + 1
+  }
+  if blockchain.prunedHeight > first {
+    first = blockchain.prunedHeight
+  }
+  chainLog.Info("Verifying the blocks", "from", first, "to", tip, "level", level)
+  for height := tip; height >= first; height-- {
+    if err := blockchain.verifyBlock(height, level); err != nil {
+      return fmt.Errorf("block %x at height %d: %w", blockchain.Blocks[height].MyBlockHash, height, err)
+    }
+  }
+  if level >= verifyUTXO && first <= tip {
+    if err := blockchain.verifyUTXO(first); err != nil {
+      return err
+    }
+  }
+  chainLog.Info("Verified the blocks", "count", tip-first+1, "level", level)
+  return nil
+}
+
+// create the method that runs the checks of the levels up to verifyUndo on the main chain block at a height, the lock must
+// be held
+func (blockchain *Blockchain) verifyBlock(height, level int) error {
+  block := blockchain.Blocks[height]
+  data, err := blockchain.db.Block(block.MyBlockHash) // what a restart would load
+  if err != nil {
+    return fmt.Errorf("cannot read the stored block: %w", err)
+  }
+  stored, err := decodeBlock(data)
+  if err != nil {
+    return fmt.Errorf("cannot decode the stored block: %w", err)
+  }
+  if !bytes.Equal(stored.MyBlockHash, block.MyBlockHash) {
+    return fmt.Errorf("the store holds block %x under its hash", stored.MyBlockHash)
+  }
+  if height > 0 && !bytes.Equal(stored.PreviousBlockHash, blockchain.Blocks[height-1].MyBlockHash) {
+    return fmt.Errorf("the stored block builds on %x, not on the block below it", stored.PreviousBlockHash)
+  }
+  if level < verifyBlocks {
+    return nil
+  }
+  if err := CheckBlock(stored, blockchain.clock.now()); err != nil {
+    return err
+  }
+  if height > 0 {
+    if err := checkBlockContext(stored, blockchain.index[indexKey(stored.PreviousBlockHash)]); err != nil {
+      return err
+    }
+  }
+  if level < verifyUndo {
+    return nil
+  }
+  undo, err := blockchain.db.Get(storage.UndoBucket, stored.MyBlockHash)
+  if err != nil {
+    return fmt.Errorf("cannot read the undo data: %w", err)
+  }
+  if undo == nil {
+    return errors.New("no undo data")
+  }
+  var spent []utxoEntry // the outputs the block spent
+  if err := gob.NewDecoder(bytes.NewReader(undo)).Decode(&spent); err != nil {
+    return fmt.Errorf("cannot decode the undo data: %w", err)
+  }
+  inputs := 0 // the outputs the block spends
+  for _, tx := range stored.Transactions {
+    if !tx.IsCoinbase() {
+      inputs += len(tx.Vin)
+    }
+  }
+  if len(spent) != inputs {
+    return fmt.Errorf("the undo data lists %d spent outputs, the block spends %d", len(spent), inputs)
+  }
+  return nil
+}
+
+// create the method that disconnects the main chain blocks from the tip down to a height and connects them again, in a
+// batch that is rolled back, so the UTXO set and the undo data are checked against each other without changing, the
+// lock must be held
+func (blockchain *Blockchain) verifyUTXO(first int) error {
+  err := blockchain.utxoCache.update(func(batch *storage.Batch, view *utxoView) error {
+    tip := len(blockchain.Blocks) - 1
+    for height := tip; height >= first; height-- { // the set goes back to the state before the first block
+      if err := disconnectUTXO(view, blockchain.Blocks[height]); err != nil {
+        return fmt.Errorf("cannot disconnect block %x at height %d: %w", blockchain.Blocks[height].MyBlockHash, height, err)
+      }
+    }
+    for height := first; height <= tip; height++ { // and every output spent must be found again
+      if err := connectUTXO(view, blockchain.Blocks[height], height); err != nil {
+        return fmt.Errorf("cannot connect block %x at height %d again: %w", blockchain.Blocks[height].MyBlockHash, height, err)
+      }
+    }
+    return errVerified
+  })
+  if errors.Is(err, errVerified) {
+    return nil
+  }
+  return err
+}
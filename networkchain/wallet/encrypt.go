@@ -0,0 +1,119 @@
+package wallet
+
+import (
+  "bytes"        // to serialize the keys
+  "encoding/gob" // to serialize the keys
+  "errors"       // for the errors of the encryption
+)
+
+// Define the errors of an encrypted wallet file
+var (
+  ErrLocked       = errors.New("wallet: the wallet is locked, unlock it with its passphrase")
+  ErrNotEncrypted = errors.New("wallet: the wallet file is not encrypted")
+  ErrEncrypted    = errors.New("wallet: the wallet file is encrypted already")
+)
+
+// Define a struct for the secrets of an encrypted wallet file, encrypted apart from the rest
+type walletSecrets struct {
+  Keys   map[string][]byte // the private keys, by address
+  Seed   []byte            // the master seed
+  Nonces map[string][]byte // the secret nonces of the aggregate signatures
+}
+
+// Define a method to encrypt the private keys and the seed with a passphrase, with a key derived from it by scrypt
+// The rest of the file is readable without it, so a node knows its addresses and coins while its keys are locked;
+// the keys are locked once encrypted
+func (ws *Wallets) Encrypt(passphrase []byte) error {
+  if ws.sealed != nil {
+    return ErrEncrypted
+  }
+  if len(passphrase) == 0 {
+    return errors.New("wallet: the passphrase cannot be empty")
+  }
+  ws.passphrase = append([]byte{}, passphrase...)
+  ws.sealed = []byte{} // Save seals the keys
+  if err := ws.Save(); err != nil {
+    return err
+  }
+  return ws.Lock()
+}
+
+// Define a method to encrypt the secrets held in memory with the passphrase, to be saved
+func (ws *Wallets) seal() error {
+  if ws.locked { // nothing changed since they were sealed
+    return nil
+  }
+  secrets := walletSecrets{map[string][]byte{}, ws.Seed, ws.Nonces}
+  for address, w := range ws.Wallets {
+    secrets.Keys[address] = w.secret()
+  }
+  var plain bytes.Buffer
+  if err := gob.NewEncoder(&plain).Encode(secrets); err != nil {
+    return err
+  }
+  sealed, err := encrypt(plain.Bytes(), ws.passphrase)
+  if err != nil {
+    return err
+  }
+  ws.sealed = sealed
+  return nil
+}
+
+// Define a method to decrypt the private keys and the seed of an encrypted file, so the wallets can sign until Lock
+func (ws *Wallets) Unlock(passphrase []byte) error {
+  if ws.sealed == nil {
+    return ErrNotEncrypted
+  }
+  plain, err := decrypt(ws.sealed, passphrase)
+  if err != nil {
+    return err
+  }
+  var secrets walletSecrets
+  if err := gob.NewDecoder(bytes.NewReader(plain)).Decode(&secrets); err != nil {
+    return err
+  }
+  for address, key := range secrets.Keys {
+    if w, ok := ws.Wallets[address]; ok {
+      w.setSecret(key) // in place, the addresses of the wallets never change
+    }
+  }
+  ws.Nonces = map[string][]byte{}
+  for id, nonce := range secrets.Nonces {
+    ws.Nonces[id] = nonce
+  }
+  ws.Seed, ws.passphrase, ws.locked = secrets.Seed, append([]byte{}, passphrase...), false
+  return nil
+}
+
+// Define a method to wipe the private keys, the seed and the passphrase of an encrypted file from memory
+func (ws *Wallets) Lock() error {
+  if ws.sealed == nil {
+    return ErrNotEncrypted
+  }
+  for _, w := range ws.Wallets {
+    w.wipe()
+  }
+  for i := range ws.Seed {
+    ws.Seed[i] = 0
+  }
+  for _, nonce := range ws.Nonces {
+    for i := range nonce {
+      nonce[i] = 0
+    }
+  }
+  for i := range ws.passphrase {
+    ws.passphrase[i] = 0
+  }
+  ws.Seed, ws.Nonces, ws.passphrase, ws.locked = nil, map[string][]byte{}, nil, true
+  return nil
+}
+
+// Define a method to check if the keys of the wallet file are encrypted
+func (ws *Wallets) IsEncrypted() bool {
+  return ws.sealed != nil
+}
+
+// Define a method to check if the keys of an encrypted file are locked
+func (ws *Wallets) IsLocked() bool {
+  return ws.locked
+}
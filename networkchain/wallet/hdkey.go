@@ -0,0 +1,270 @@
+package wallet
+
+import (
+  "bytes"                // to tell private keys from public keys
+  "crypto/hmac"          // the derivation is an HMAC-SHA512
+  "crypto/sha512"        // the hash of the HMAC
+  "encoding/binary"      // to write the child numbers
+  "errors"               // for the errors
+  "fmt"                  // to format the paths
+  "networkchain/address" // the extended keys are base58check
+  "strconv"              // to parse the paths
+  "strings"              // to split the paths
+
+  "github.com/decred/dcrd/dcrec/secp256k1/v4" // the curve used for the keys
+)
+
+// Define some constants for the hierarchical deterministic keys, they follow BIP32
+const (
+  HardenedKeyStart = uint32(0x80000000) // the first hardened child number, hardened children need the private key
+  AccountPath      = "m/0'"             // the path of the account the addresses of a wallet are derived from
+  DefaultGapLimit  = 20                 // the number of unused addresses in a row after which a scan stops
+  MinSeedLen       = 16                 // the shortest seed accepted, in bytes
+  MaxSeedLen       = 64                 // the longest seed accepted, in bytes
+  extendedKeyLen   = 78                 // the length of a serialized extended key
+)
+
+// Define the version bytes of the serialized extended keys, the ones of bitcoin so the keys read xprv and xpub
+var (
+  privateVersion = []byte{0x04, 0x88, 0xad, 0xe4}
+  publicVersion  = []byte{0x04, 0x88, 0xb2, 0x1e}
+)
+
+// The HMAC key of the master key derivation
+var masterKey = []byte("Bitcoin seed")
+
+// Define the errors of the derivation
+var (
+  ErrInvalidSeed        = fmt.Errorf("wallet: the seed must be %d to %d bytes", MinSeedLen, MaxSeedLen)
+  ErrInvalidChild       = errors.New("wallet: the child key is invalid, use the next index")
+  ErrHardenedFromPub    = errors.New("wallet: a hardened child cannot be derived from a public key")
+  ErrInvalidPath        = errors.New("wallet: invalid derivation path")
+  ErrInvalidExtended    = errors.New("wallet: invalid extended key")
+  ErrNotPrivateExtended = errors.New("wallet: the extended key is public")
+)
+
+// Define a struct for an extended key: a key and the chain code its children are derived with
+type ExtendedKey struct {
+  key       []byte // the 32 bytes private key or the 33 bytes compressed public key
+  chainCode []byte // the extra entropy of the derivation
+  depth     byte   // the number of derivations from the master key
+  parentFP  []byte // the first bytes of the hash of the parent public key
+  childNum  uint32 // the index of the key among the children of its parent
+  private   bool   // whether key is a private key
+}
+
+// Define a function to create the master key of a seed
+func NewMaster(seed []byte) (*ExtendedKey, error) {
+  if len(seed) < MinSeedLen || len(seed) > MaxSeedLen {
+    return nil, ErrInvalidSeed
+  }
+  mac := hmac.New(sha512.New, masterKey)
+  mac.Write(seed) // writing to a hash never fails
+  sum := mac.Sum(nil)
+  var k secp256k1.ModNScalar
+  if overflow := k.SetByteSlice(sum[:32]); overflow || k.IsZero() { // the key must be a valid scalar
+    return nil, ErrInvalidSeed
+  }
+  return &ExtendedKey{sum[:32], sum[32:], 0, []byte{0, 0, 0, 0}, 0, true}, nil
+}
+
+// Define a method to tell whether the key is private
+func (k *ExtendedKey) IsPrivate() bool {
+  return k.private
+}
+
+// Define a method to get the compressed public key
+func (k *ExtendedKey) PublicKey() []byte {
+  if !k.private {
+    return k.key
+  }
+  return secp256k1.PrivKeyFromBytes(k.key).PubKey().SerializeCompressed()
+}
+
+// Define a method to get the address of the key
+func (k *ExtendedKey) Address() string {
+  return AddressFromPubKey(k.PublicKey())
+}
+
+// Define a method to get the wallet of a private key, to sign with it
+func (k *ExtendedKey) Wallet() (*Wallet, error) {
+  if !k.private {
+    return nil, ErrNotPrivateExtended
+  }
+  return walletFromKey(secp256k1.PrivKeyFromBytes(k.key)), nil
+}
+
+// Define a method to derive a child key, hardened if the index is at least HardenedKeyStart
+// The private key of a child only comes from a private parent, and a hardened child needs one
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+  hardened := index >= HardenedKeyStart
+  if hardened && !k.private {
+    return nil, ErrHardenedFromPub
+  }
+  var data []byte // what the HMAC hashes: the parent key then the index
+  if hardened {
+    data = append([]byte{0x00}, k.key...) // the private key, padded to the length of a public key
+  } else {
+    data = append([]byte{}, k.PublicKey()...)
+  }
+  data = binary.BigEndian.AppendUint32(data, index)
+  mac := hmac.New(sha512.New, k.chainCode)
+  mac.Write(data)
+  sum := mac.Sum(nil)
+  var tweak secp256k1.ModNScalar
+  if overflow := tweak.SetByteSlice(sum[:32]); overflow { // the tweak must be a valid scalar
+    return nil, ErrInvalidChild
+  }
+  var childKey []byte
+  if k.private { // the private key of the child is the tweak plus the parent key
+    parent := secp256k1.PrivKeyFromBytes(k.key)
+    tweak.Add(&parent.Key)
+    if tweak.IsZero() {
+      return nil, ErrInvalidChild
+    }
+    b := tweak.Bytes()
+    childKey = b[:]
+  } else { // the public key of the child is the tweak times the generator plus the parent key
+    parent, err := secp256k1.ParsePubKey(k.key)
+    if err != nil {
+      return nil, err
+    }
+    var point, parentPoint, sumPoint secp256k1.JacobianPoint
+    secp256k1.ScalarBaseMultNonConst(&tweak, &point)
+    parent.AsJacobian(&parentPoint)
+    secp256k1.AddNonConst(&point, &parentPoint, &sumPoint)
+    if (sumPoint.X.IsZero() && sumPoint.Y.IsZero()) || sumPoint.Z.IsZero() { // the point at infinity is no key
+      return nil, ErrInvalidChild
+    }
+    sumPoint.ToAffine()
+    childKey = secp256k1.NewPublicKey(&sumPoint.X, &sumPoint.Y).SerializeCompressed()
+  }
+  fingerprint := HashPubKey(k.PublicKey())[:4] // the child remembers its parent
+  return &ExtendedKey{childKey, sum[32:], k.depth + 1, fingerprint, index, k.private}, nil
+}
+
+// Define a method to derive the key at a path like m/0'/0/5, relative to this key
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+  indexes, err := ParsePath(path)
+  if err != nil {
+    return nil, err
+  }
+  key := k
+  for _, index := range indexes { // derive one level at a time
+    if key, err = key.Child(index); err != nil {
+      return nil, err
+    }
+  }
+  return key, nil
+}
+
+// Define a method to get the public version of the key, to watch the addresses without being able to spend
+func (k *ExtendedKey) Neuter() *ExtendedKey {
+  if !k.private {
+    return k
+  }
+  return &ExtendedKey{k.PublicKey(), k.chainCode, k.depth, k.parentFP, k.childNum, false}
+}
+
+// Define a method to serialize the key: an xprv string for a private key, an xpub string for a public key
+func (k *ExtendedKey) String() string {
+  version, key := publicVersion, k.key
+  if k.private {
+    version, key = privateVersion, append([]byte{0x00}, k.key...)
+  }
+  data := make([]byte, 0, extendedKeyLen)
+  data = append(data, version...)
+  data = append(data, k.depth)
+  data = append(data, k.parentFP...)
+  data = binary.BigEndian.AppendUint32(data, k.childNum)
+  data = append(data, k.chainCode...)
+  data = append(data, key...)
+  return address.Base58CheckEncode(data)
+}
+
+// Define a function to parse a key serialized by String
+func ParseExtendedKey(encoded string) (*ExtendedKey, error) {
+  data, err := address.Base58CheckDecode(encoded)
+  if err != nil {
+    return nil, err
+  }
+  if len(data) != extendedKeyLen {
+    return nil, ErrInvalidExtended
+  }
+  version, key := data[:4], data[45:]
+  k := &ExtendedKey{
+    depth:     data[4],
+    parentFP:  data[5:9],
+    childNum:  binary.BigEndian.Uint32(data[9:13]),
+    chainCode: data[13:45],
+  }
+  switch {
+  case bytes.Equal(version, privateVersion) && key[0] == 0x00:
+    var scalar secp256k1.ModNScalar
+    if overflow := scalar.SetByteSlice(key[1:]); overflow || scalar.IsZero() {
+      return nil, ErrInvalidExtended
+    }
+    k.key, k.private = key[1:], true
+  case bytes.Equal(version, publicVersion):
+    if _, err := secp256k1.ParsePubKey(key); err != nil {
+      return nil, ErrInvalidExtended
+    }
+    k.key = key
+  default:
+    return nil, ErrInvalidExtended
+  }
+  return k, nil
+}
+
+// Define a function to parse a path like m/0'/0/5 into child numbers, the hardened ones marked with ' or h
+func ParsePath(path string) ([]uint32, error) {
+  parts := strings.Split(path, "/")
+  if parts[0] != "m" {
+    return nil, fmt.Errorf("%w %q: it must start with m", ErrInvalidPath, path)
+  }
+  var indexes []uint32
+  for _, part := range parts[1:] {
+    offset := uint32(0)
+    if strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h") {
+      offset, part = HardenedKeyStart, part[:len(part)-1]
+    }
+    index, err := strconv.ParseUint(part, 10, 32)
+    if err != nil || uint32(index) >= HardenedKeyStart {
+      return nil, fmt.Errorf("%w %q: bad index %q", ErrInvalidPath, path, part)
+    }
+    indexes = append(indexes, uint32(index)+offset)
+  }
+  return indexes, nil
+}
+
+// Define a struct for an address found by a scan
+type DerivedAddress struct {
+  Index   uint32 // the index of the address on the receive chain of the account
+  Address string // the address
+}
+
+// Define a function to scan the receive chain of an account, private or public, for the addresses in use
+// The scan stops after gapLimit unused addresses in a row, wallets never hand out addresses further ahead
+func ScanAccount(account *ExtendedKey, gapLimit int, used func(address string) bool) ([]DerivedAddress, error) {
+  receive, err := account.Child(0) // the addresses given out to be paid
+  if err != nil {
+    return nil, err
+  }
+  var found []DerivedAddress
+  for index, gap := uint32(0), 0; gap < gapLimit && index < HardenedKeyStart; index++ {
+    child, err := receive.Child(index)
+    if errors.Is(err, ErrInvalidChild) { // wallets skip the rare invalid indexes
+      continue
+    }
+    if err != nil {
+      return nil, err
+    }
+    if !used(child.Address()) {
+      gap++
+      continue
+    }
+    gap = 0
+    found = append(found, DerivedAddress{index, child.Address()})
+  }
+  return found, nil
+}
@@ -0,0 +1,117 @@
+package wallet
+
+import (
+  "crypto/rand"   // to generate the entropy
+  "crypto/sha256" // for the checksum of the entropy
+  "crypto/sha512" // the hash of the seed derivation
+  "errors"        // for the errors
+  "fmt"           // to format the errors
+  "math/big"      // the words are read as one big number
+  "strings"       // to split and join the words
+
+  "golang.org/x/crypto/pbkdf2"     // the seed is stretched from the mnemonic
+  "golang.org/x/text/unicode/norm" // the mnemonic and the passphrase are normalized before hashing
+)
+
+// Define some constants for the mnemonics, they follow BIP39
+const (
+  seedIterations = 2048       // the PBKDF2 iterations turning a mnemonic into a seed
+  bitsPerWord    = 11         // each word encodes 11 bits, an index in the 2048 words
+  seedSalt       = "mnemonic" // the prefix of the salt, followed by the passphrase
+)
+
+// Define the errors of the mnemonics
+var (
+  ErrMnemonicLength   = errors.New("wallet: a mnemonic has 12 or 24 words")
+  ErrMnemonicChecksum = errors.New("wallet: the mnemonic checksum does not match, a word is wrong or misplaced")
+)
+
+// The words of the list and the index of each word
+var (
+  wordlist  = strings.Fields(englishWords)
+  wordIndex = indexWords(wordlist)
+)
+
+// Define a function to index the words of a list
+func indexWords(words []string) map[string]int {
+  index := make(map[string]int, len(words))
+  for i, word := range words {
+    index[word] = i
+  }
+  return index
+}
+
+// Define a function to generate a new mnemonic of 12 or 24 words
+func NewMnemonic(words int) (string, error) {
+  if words != 12 && words != 24 {
+    return "", ErrMnemonicLength
+  }
+  entropy := make([]byte, words*bitsPerWord*32/33/8) // 16 bytes for 12 words, 32 for 24
+  if _, err := rand.Read(entropy); err != nil {
+    return "", err
+  }
+  return EntropyToMnemonic(entropy)
+}
+
+// Define a function to encode entropy of 16 or 32 bytes as a mnemonic
+// A checksum of len(entropy)/4 bits, the first bits of its SHA256, is appended before splitting into words
+func EntropyToMnemonic(entropy []byte) (string, error) {
+  if len(entropy) != 16 && len(entropy) != 32 {
+    return "", ErrMnemonicLength
+  }
+  checksumBits := uint(len(entropy) / 4)
+  hash := sha256.Sum256(entropy)
+  number := new(big.Int).SetBytes(entropy) // the entropy followed by the checksum, as one number
+  number.Lsh(number, checksumBits)
+  number.Or(number, big.NewInt(int64(hash[0]>>(8-checksumBits))))
+  count := (len(entropy)*8 + int(checksumBits)) / bitsPerWord
+  words := make([]string, count)
+  mask := big.NewInt(1<<bitsPerWord - 1)
+  index := new(big.Int)
+  for i := count - 1; i >= 0; i-- { // the last word holds the lowest bits
+    index.And(number, mask)
+    words[i] = wordlist[index.Int64()]
+    number.Rsh(number, bitsPerWord)
+  }
+  return strings.Join(words, " "), nil
+}
+
+// Define a function to decode a mnemonic back to its entropy, checking the words and the checksum
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+  words := strings.Fields(mnemonic)
+  if len(words) != 12 && len(words) != 24 {
+    return nil, ErrMnemonicLength
+  }
+  number := new(big.Int)
+  for _, word := range words {
+    index, ok := wordIndex[strings.ToLower(word)]
+    if !ok {
+      return nil, fmt.Errorf("wallet: %q is not a word of the list", word)
+    }
+    number.Lsh(number, bitsPerWord)
+    number.Or(number, big.NewInt(int64(index)))
+  }
+  checksumBits := uint(len(words) * bitsPerWord / 33)
+  checksum := new(big.Int).And(number, big.NewInt(1<<checksumBits-1)).Int64() // split the checksum off
+  number.Rsh(number, checksumBits)
+  entropy := number.FillBytes(make([]byte, checksumBits*32/8)) // keep the leading zero bytes
+  hash := sha256.Sum256(entropy)
+  if int64(hash[0]>>(8-checksumBits)) != checksum {
+    return nil, ErrMnemonicChecksum
+  }
+  return entropy, nil
+}
+
+// Define a function to check that a mnemonic only has words of the list and a valid checksum
+func ValidateMnemonic(mnemonic string) error {
+  _, err := MnemonicToEntropy(mnemonic)
+  return err
+}
+
+// Define a function to turn a mnemonic and an optional passphrase into the 64 bytes seed of the master key
+// A different passphrase gives a different wallet, so the passphrase is as important to keep as the words
+func MnemonicToSeed(mnemonic, passphrase string) []byte {
+  normalized := norm.NFKD.String(strings.Join(strings.Fields(strings.ToLower(mnemonic)), " ")) // one space between the words
+  salt := norm.NFKD.String(seedSalt + passphrase)
+  return pbkdf2.Key([]byte(normalized), []byte(salt), seedIterations, 64, sha512.New)
+}
@@ -0,0 +1,76 @@
+package wallet
+
+import (
+  "bytes"                // to compare the public keys
+  "errors"               // for the errors
+  "fmt"                  // to format the errors
+  "networkchain/address" // multisig addresses pay to the hash of the script
+  "networkchain/script"  // the multisig script is a script of the interpreter
+
+  "github.com/decred/dcrd/dcrec/secp256k1/v4" // to check the public keys
+)
+
+// The largest number of keys of a multisig script
+const MaxMultisigKeys = 15
+
+// Define an error returned for a multisig script with bad keys or counts
+var ErrInvalidMultisig = errors.New("wallet: invalid multisig script")
+
+// Define a struct for an M-of-N multisig script: any M of the N keys can spend the outputs of its address
+type MultisigScript struct {
+  Required int      // the number of signatures needed, M
+  PubKeys  [][]byte // the compressed public keys allowed to sign, in order
+}
+
+// Define a function to create a multisig script needing some signatures out of public keys
+func NewMultisigScript(required int, pubKeys [][]byte) (*MultisigScript, error) {
+  if len(pubKeys) == 0 || len(pubKeys) > MaxMultisigKeys {
+    return nil, fmt.Errorf("%w: it needs 1 to %d keys, not %d", ErrInvalidMultisig, MaxMultisigKeys, len(pubKeys))
+  }
+  if required < 1 || required > len(pubKeys) {
+    return nil, fmt.Errorf("%w: %d signatures out of %d keys", ErrInvalidMultisig, required, len(pubKeys))
+  }
+  for i, key := range pubKeys {
+    if len(key) != secp256k1.PubKeyBytesLenCompressed {
+      return nil, fmt.Errorf("%w: key %d is not a compressed public key", ErrInvalidMultisig, i)
+    }
+    if _, err := secp256k1.ParsePubKey(key); err != nil {
+      return nil, fmt.Errorf("%w: key %d: %v", ErrInvalidMultisig, i, err)
+    }
+    for _, other := range pubKeys[:i] { // a key listed twice would count twice
+      if bytes.Equal(key, other) {
+        return nil, fmt.Errorf("%w: key %d is repeated", ErrInvalidMultisig, i)
+      }
+    }
+  }
+  return &MultisigScript{required, pubKeys}, nil
+}
+
+// Define a method to serialize the script: OP_M, the N keys, OP_N and OP_CHECKMULTISIG
+func (s *MultisigScript) Serialize() []byte {
+  return script.Multisig(s.Required, s.PubKeys)
+}
+
+// Define a function to parse a script serialized by Serialize, checking it like NewMultisigScript
+func ParseMultisigScript(data []byte) (*MultisigScript, error) {
+  required, keys, err := script.ParseMultisig(data)
+  if err != nil {
+    return nil, fmt.Errorf("%w: %v", ErrInvalidMultisig, err)
+  }
+  return NewMultisigScript(required, keys)
+}
+
+// Define a method to get the address of the script, the hash of the serialized script
+func (s *MultisigScript) Address() string {
+  return address.FromScriptHash(HashPubKey(s.Serialize()))
+}
+
+// Define a method to find the key a signature of a hash belongs to, -1 if it matches none
+func (s *MultisigScript) signer(hash, signature []byte) int {
+  for i, key := range s.PubKeys {
+    if Verify(key, hash, signature) == nil {
+      return i
+    }
+  }
+  return -1
+}
@@ -0,0 +1,81 @@
+package wallet
+
+import (
+  "errors"               // for the errors
+  "fmt"                  // to format the errors
+  "networkchain/address" // aggregate addresses pay to the hash of the aggregate key
+  "networkchain/schnorr" // to aggregate the keys
+
+  "github.com/decred/dcrd/dcrec/secp256k1/v4" // to check the public keys
+)
+
+// The largest number of keys of an aggregate key
+const MaxAggregateKeys = 100
+
+// Define an error returned for an aggregate key with bad keys
+var ErrInvalidAggregate = errors.New("wallet: invalid aggregate key")
+
+// Define a struct for an N-of-N aggregate key: the N keys sign together, the chain only sees the one key they add up to
+type AggregateKey struct {
+  PubKeys [][]byte // the compressed public keys of the signers, sorted
+}
+
+// Define a function to create the aggregate key of some public keys, in any order
+func NewAggregateKey(pubKeys [][]byte) (*AggregateKey, error) {
+  if len(pubKeys) == 0 || len(pubKeys) > MaxAggregateKeys {
+    return nil, fmt.Errorf("%w: it needs 1 to %d keys, not %d", ErrInvalidAggregate, MaxAggregateKeys, len(pubKeys))
+  }
+  for i, key := range pubKeys {
+    if len(key) != secp256k1.PubKeyBytesLenCompressed {
+      return nil, fmt.Errorf("%w: key %d is not a compressed public key", ErrInvalidAggregate, i)
+    }
+  }
+  sorted := schnorr.SortKeys(pubKeys)
+  if _, err := schnorr.AggregateKeys(sorted); err != nil { // a key off the curve or repeated
+    return nil, fmt.Errorf("%w: %v", ErrInvalidAggregate, err)
+  }
+  return &AggregateKey{sorted}, nil
+}
+
+// Define a method to serialize the aggregate key: its sorted keys one after the other
+func (a *AggregateKey) Serialize() []byte {
+  var data []byte
+  for _, key := range a.PubKeys {
+    data = append(data, key...)
+  }
+  return data
+}
+
+// Define a function to parse an aggregate key serialized by Serialize, checking it like NewAggregateKey
+func ParseAggregateKey(data []byte) (*AggregateKey, error) {
+  if len(data) == 0 || len(data)%secp256k1.PubKeyBytesLenCompressed != 0 {
+    return nil, fmt.Errorf("%w: %d bytes are not a list of keys", ErrInvalidAggregate, len(data))
+  }
+  var keys [][]byte
+  for len(data) > 0 {
+    keys = append(keys, data[:secp256k1.PubKeyBytesLenCompressed])
+    data = data[secp256k1.PubKeyBytesLenCompressed:]
+  }
+  return NewAggregateKey(keys)
+}
+
+// Define a method to get the public key the keys add up to, the one unlocking the outputs of the address
+func (a *AggregateKey) Key() []byte {
+  key, _ := schnorr.AggregateKeys(a.PubKeys) // checked when created
+  return key
+}
+
+// Define a method to get the address of the aggregate key, the hash of the key like any Schnorr address
+func (a *AggregateKey) Address() string {
+  return address.FromSchnorrPubKeyHash(HashPubKey(a.Key()))
+}
+
+// Define a method to tell if a public key is one of the aggregate key
+func (a *AggregateKey) has(pubKey []byte) bool {
+  for _, key := range a.PubKeys {
+    if string(key) == string(pubKey) {
+      return true
+    }
+  }
+  return false
+}
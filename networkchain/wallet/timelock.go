@@ -0,0 +1,54 @@
+package wallet
+
+import (
+  "errors"               // for the errors
+  "fmt"                  // to format the errors
+  "networkchain/address" // time-locked addresses pay to the hash of the script
+  "networkchain/script"  // the time lock is a script of the interpreter
+
+  "github.com/decred/dcrd/dcrec/secp256k1/v4" // to check the public key
+)
+
+// Define an error returned for a time lock script with a bad lock time or key
+var ErrInvalidTimeLock = errors.New("wallet: invalid time lock script")
+
+// Define a struct for a time lock script: the coins of its address only move with the signature of the key,
+// in a transaction whose lock time reached the one of the script
+type TimeLockScript struct {
+  LockTime int64  // the block height, or the unix time from script.LockTimeThreshold on, before which the coins cannot move
+  PubKey   []byte // the compressed public key allowed to spend
+}
+
+// Define a function to create a time lock script
+func NewTimeLockScript(lockTime int64, pubKey []byte) (*TimeLockScript, error) {
+  if lockTime <= 0 || lockTime > 0xffffffff {
+    return nil, fmt.Errorf("%w: lock time %d", ErrInvalidTimeLock, lockTime)
+  }
+  if len(pubKey) != secp256k1.PubKeyBytesLenCompressed {
+    return nil, fmt.Errorf("%w: not a compressed public key", ErrInvalidTimeLock)
+  }
+  if _, err := secp256k1.ParsePubKey(pubKey); err != nil {
+    return nil, fmt.Errorf("%w: %v", ErrInvalidTimeLock, err)
+  }
+  return &TimeLockScript{lockTime, pubKey}, nil
+}
+
+// Define a method to serialize the script: <lock time> OP_CHECKLOCKTIMEVERIFY OP_DROP <key> OP_CHECKSIG
+func (s *TimeLockScript) Serialize() []byte {
+  return script.NewBuilder().AddInt(s.LockTime).AddOp(script.OP_CHECKLOCKTIMEVERIFY, script.OP_DROP).
+    AddData(s.PubKey).AddOp(script.OP_CHECKSIG).Script()
+}
+
+// Define a function to parse a script serialized by Serialize, checking it like NewTimeLockScript
+func ParseTimeLockScript(data []byte) (*TimeLockScript, error) {
+  lockTime, pubKey, err := script.ParseTimeLock(data)
+  if err != nil {
+    return nil, fmt.Errorf("%w: %v", ErrInvalidTimeLock, err)
+  }
+  return NewTimeLockScript(lockTime, pubKey)
+}
+
+// Define a method to get the address of the script, the hash of the serialized script
+func (s *TimeLockScript) Address() string {
+  return address.FromScriptHash(HashPubKey(s.Serialize()))
+}
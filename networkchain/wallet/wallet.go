@@ -0,0 +1,156 @@
+package wallet
+
+import (
+  "crypto/ed25519"       // the keys of the Ed25519 wallets
+  "crypto/sha256"        // to hash the public key
+  "errors"               // for the errors
+  "networkchain/address" // to encode the addresses
+
+  "github.com/decred/dcrd/dcrec/secp256k1/v4"       // the curve used for the keys
+  "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa" // signatures on that curve
+  "golang.org/x/crypto/ripemd160"                   // the second hash of the public key
+)
+
+// Define an error returned when a signature cannot be parsed or does not match
+var ErrInvalidSignature = errors.New("wallet: invalid signature")
+
+// Define a struct for a wallet, a keypair able to own coins
+// The keys are ECDSA on secp256k1, or Ed25519 for the wallets paying to Ed25519 addresses; the length of the public key
+// tells them apart
+type Wallet struct {
+  PrivateKey *secp256k1.PrivateKey // the private key of an ECDSA wallet, used to sign
+  Ed25519Key ed25519.PrivateKey    // the private key of an Ed25519 wallet, nil for an ECDSA one
+  PublicKey  []byte                // the compressed public key, or the Ed25519 one, shared with everyone
+}
+
+// Define a function to create a wallet with a new keypair
+func NewWallet() (*Wallet, error) {
+  private, err := secp256k1.GeneratePrivateKey() // generate a random private key
+  if err != nil {
+    return nil, err
+  }
+  return walletFromKey(private), nil
+}
+
+// Define a function to create a wallet with a new Ed25519 keypair
+func NewEd25519Wallet() (*Wallet, error) {
+  public, private, err := ed25519.GenerateKey(nil) // from the random source of the system
+  if err != nil {
+    return nil, err
+  }
+  return &Wallet{Ed25519Key: private, PublicKey: public}, nil
+}
+
+// Define a function to build a wallet from a serialized private key, 32 bytes below the order of the curve
+func FromPrivateKey(key []byte) (*Wallet, error) {
+  var scalar secp256k1.ModNScalar
+  if len(key) != 32 || scalar.SetByteSlice(key) || scalar.IsZero() { // the key overflows the order or is zero
+    return nil, errors.New("wallet: invalid private key")
+  }
+  return walletFromKey(secp256k1.NewPrivateKey(&scalar)), nil
+}
+
+// Define a function to build a wallet from a private key
+func walletFromKey(private *secp256k1.PrivateKey) *Wallet {
+  return &Wallet{PrivateKey: private, PublicKey: private.PubKey().SerializeCompressed()} // the public key is derived from the private key
+}
+
+// Define a function to build the wallet of an address from the private key stored for it, the version of the address
+// telling the kind of the key
+func walletFromSecret(addr string, key []byte) (*Wallet, error) {
+  if version, _, err := address.Decode(addr); err == nil && version == address.Ed25519PubKeyHashVersion {
+    if len(key) != ed25519.SeedSize {
+      return nil, errors.New("wallet: invalid Ed25519 private key")
+    }
+    private := ed25519.NewKeyFromSeed(key)
+    return &Wallet{Ed25519Key: private, PublicKey: private.Public().(ed25519.PublicKey)}, nil
+  }
+  return walletFromKey(secp256k1.PrivKeyFromBytes(key)), nil
+}
+
+// Define a method to tell if the keys of the wallet are Ed25519 ones
+func (w *Wallet) IsEd25519() bool {
+  return len(w.PublicKey) == ed25519.PublicKeySize
+}
+
+// Define a method to get the private key to store, the 32 bytes of the scalar or of the Ed25519 seed
+func (w *Wallet) secret() []byte {
+  if w.IsEd25519() {
+    return w.Ed25519Key.Seed()
+  }
+  return w.PrivateKey.Serialize()
+}
+
+// Define a method to set the private key of a wallet holding only its public key, from the stored bytes
+func (w *Wallet) setSecret(key []byte) {
+  if w.IsEd25519() {
+    w.Ed25519Key = ed25519.NewKeyFromSeed(key)
+    return
+  }
+  w.PrivateKey = secp256k1.PrivKeyFromBytes(key)
+}
+
+// Define a method to wipe the private key from memory, leaving the public key
+func (w *Wallet) wipe() {
+  if w.PrivateKey != nil {
+    w.PrivateKey.Zero()
+    w.PrivateKey = nil
+  }
+  for i := range w.Ed25519Key {
+    w.Ed25519Key[i] = 0
+  }
+  w.Ed25519Key = nil
+}
+
+// Define a method to get the address of the wallet
+func (w *Wallet) Address() string {
+  return AddressFromPubKey(w.PublicKey)
+}
+
+// Define a function to get the address of a public key, an Ed25519 address for an Ed25519 key
+func AddressFromPubKey(pubKey []byte) string {
+  if len(pubKey) == ed25519.PublicKeySize {
+    return address.FromEd25519PubKeyHash(HashPubKey(pubKey))
+  }
+  return address.FromPubKeyHash(HashPubKey(pubKey)) // the address pays to the hash of the key
+}
+
+// Define a method to sign a hash with the private key, returning a DER encoded signature, or the 64 bytes of an
+// Ed25519 one
+func (w *Wallet) Sign(hash []byte) []byte {
+  if w.IsEd25519() {
+    return ed25519.Sign(w.Ed25519Key, hash)
+  }
+  return ecdsa.Sign(w.PrivateKey, hash).Serialize()
+}
+
+// Define a function to check a signature of a hash against a public key, DER encoded for a compressed key and of 64
+// bytes for an Ed25519 key
+func Verify(pubKey, hash, signature []byte) error {
+  if len(pubKey) == ed25519.PublicKeySize {
+    if len(signature) != ed25519.SignatureSize || !ed25519.Verify(pubKey, hash, signature) {
+      return ErrInvalidSignature
+    }
+    return nil
+  }
+  key, err := secp256k1.ParsePubKey(pubKey) // parse the public key
+  if err != nil {
+    return err
+  }
+  sig, err := ecdsa.ParseDERSignature(signature) // parse the signature
+  if err != nil {
+    return err
+  }
+  if !sig.Verify(hash, key) {
+    return ErrInvalidSignature
+  }
+  return nil
+}
+
+// Define a function to hash a public key: RIPEMD160(SHA256(key))
+func HashPubKey(pubKey []byte) []byte {
+  publicSHA256 := sha256.Sum256(pubKey)
+  hasher := ripemd160.New()
+  hasher.Write(publicSHA256[:]) // writing to a hash never fails
+  return hasher.Sum(nil)
+}
@@ -0,0 +1,654 @@
+package wallet
+
+import (
+  "bytes"                // to serialize the wallets
+  "crypto/aes"           // the wallet file is encrypted with AES-GCM
+  "crypto/cipher"        // for the GCM mode
+  "crypto/rand"          // for the salt and the nonce
+  "encoding/gob"         // to serialize the wallets
+  "errors"               // for the errors
+  "fmt"                  // to format the errors
+  "networkchain/schnorr" // to sign with the aggregate keys
+  "os"                   // to read and write the wallet file
+  "path/filepath"        // to build the path of the wallet file
+  "sort"                 // to list the addresses in a stable order
+  "sync"                 // for the lock of the watch-only addresses
+
+  "golang.org/x/crypto/scrypt" // to derive the encryption key from the passphrase
+)
+
+// Define some constants for the wallet file
+const (
+  walletFile = "wallet.dat" // the name of the wallet file inside the data directory
+  saltLen    = 16           // the length of the scrypt salt stored in front of the file
+  keyLen     = 32           // the length of the AES key
+)
+
+// Define an error returned when the passphrase does not decrypt the wallet file
+var ErrWrongPassphrase = errors.New("wallet: wrong passphrase or corrupted wallet file")
+
+// Define an error returned when a seed is set on wallets that already have one
+var ErrSeedExists = errors.New("wallet: the wallet file already has a seed")
+
+// Define an error returned when keys held in memory only are saved
+var ErrNoWalletFile = errors.New("wallet: the keys have no wallet file")
+
+// The kinds of keys a wallet can create
+const (
+  KeyECDSA   = "ecdsa"   // ECDSA on secp256k1, derived from the seed if there is one, the addresses starting with 1
+  KeyEd25519 = "ed25519" // Ed25519, always random, the addresses starting with E
+)
+
+// Define a struct for the collection of wallets of a node, stored encrypted in a single file
+// With a seed the keys are derived from it along paths, and the seed alone is enough to restore them
+type Wallets struct {
+  Wallets    map[string]*Wallet // the wallets, by address
+  Seed       []byte             // the master seed, nil if the keys are independent
+  Paths      map[string]string  // the derivation path of the keys derived from the seed, by address
+  Next       uint32             // the index of the next address on the receive chain of the account
+  Multisigs  map[string][]byte  // the serialized multisig scripts the wallets take part in, by address
+  TimeLocks  map[string][]byte  // the serialized time lock scripts of the keys of the wallets, by address
+  Aggregates map[string][]byte  // the serialized aggregate keys the wallets take part in, by address
+  Nonces     map[string][]byte  // the secret nonces of the aggregate signatures started and not made yet, by hash and key
+  file       string             // the path of the wallet file
+  passphrase []byte             // the passphrase protecting the file, or the keys of an encrypted file while they are unlocked
+  sealed     []byte             // the private keys and the seed encrypted with the passphrase, nil if the file is not encrypted
+  locked     bool               // true while the keys of an encrypted file are not in memory
+  watchMu    sync.RWMutex       // the lock protecting the watch-only addresses, imported while the node runs
+  watchOnly  map[string]string  // the addresses followed without their keys, with the extended public key they come from, if any
+}
+
+// Define a struct for the content of the wallet file
+type walletData struct {
+  Keys       map[string][]byte // the private keys, by address
+  Seed       []byte            // the master seed
+  Paths      map[string]string // the derivation paths, by address
+  Next       uint32            // the index of the next receive address
+  Multisigs  map[string][]byte // the multisig scripts, by address
+  TimeLocks  map[string][]byte // the time lock scripts, by address
+  Aggregates map[string][]byte // the aggregate keys, by address
+  Nonces     map[string][]byte // the secret nonces of the aggregate signatures
+  WatchOnly  map[string]string // the watch-only addresses, with their extended public key
+  PubKeys    map[string][]byte // the public keys of an encrypted file, by address
+  Sealed     []byte            // the private keys and the seed of an encrypted file, encrypted with its passphrase
+}
+
+// Define a function to load the wallets of a data directory, an empty collection is returned if there is no file yet
+func LoadWallets(dataDir string, passphrase []byte) (*Wallets, error) {
+  ws := &Wallets{Wallets: map[string]*Wallet{}, Paths: map[string]string{}, Multisigs: map[string][]byte{}, TimeLocks: map[string][]byte{}, Aggregates: map[string][]byte{}, Nonces: map[string][]byte{}, file: Path(dataDir), passphrase: passphrase, watchOnly: map[string]string{}}
+  data, err := os.ReadFile(ws.file) // read the encrypted file
+  if os.IsNotExist(err) {
+    return ws, nil // nothing saved yet
+  }
+  if err != nil {
+    return nil, err
+  }
+  plain, err := decrypt(data, passphrase) // decrypt it
+  if err == ErrWrongPassphrase && len(passphrase) > 0 { // an encrypted file is only encrypted by the passphrase of its keys
+    plain, err = decrypt(data, nil)
+  }
+  if err != nil {
+    return nil, err
+  }
+  var stored walletData
+  if err := gob.NewDecoder(bytes.NewReader(plain)).Decode(&stored); err != nil { // files written before the seeds only hold the keys
+    stored = walletData{}
+    if err := gob.NewDecoder(bytes.NewReader(plain)).Decode(&stored.Keys); err != nil {
+      return nil, err
+    }
+  }
+  for address, key := range stored.Keys { // rebuild the wallets
+    w, err := walletFromSecret(address, key)
+    if err != nil {
+      return nil, err
+    }
+    ws.Wallets[address] = w
+  }
+  for address, pubKey := range stored.PubKeys { // the keys of an encrypted file wait for its passphrase
+    ws.Wallets[address] = &Wallet{PublicKey: pubKey}
+  }
+  ws.Seed, ws.Next = stored.Seed, stored.Next
+  for address, path := range stored.Paths {
+    ws.Paths[address] = path
+  }
+  for address, script := range stored.Multisigs {
+    ws.Multisigs[address] = script
+  }
+  for address, script := range stored.TimeLocks {
+    ws.TimeLocks[address] = script
+  }
+  for address, key := range stored.Aggregates {
+    ws.Aggregates[address] = key
+  }
+  for id, nonce := range stored.Nonces {
+    ws.Nonces[id] = nonce
+  }
+  for address, xpub := range stored.WatchOnly {
+    ws.watchOnly[address] = xpub
+  }
+  if stored.Sealed != nil {
+    ws.sealed, ws.locked, ws.passphrase = stored.Sealed, true, nil
+    if len(passphrase) > 0 { // the command line unlocks the keys with the passphrase, a node may start locked
+      if err := ws.Unlock(passphrase); err != nil {
+        return nil, err
+      }
+    }
+  }
+  return ws, nil
+}
+
+// Define a function to get the path of the wallet file of a data directory
+func Path(dataDir string) string {
+  return filepath.Join(dataDir, walletFile)
+}
+
+// Define a function to hold keys in memory only, to sign with keys given by the caller instead of the ones of a wallet
+// file; they cannot be saved
+func NewKeyring(keys []*Wallet) *Wallets {
+  ws := &Wallets{Wallets: map[string]*Wallet{}, Paths: map[string]string{}, Multisigs: map[string][]byte{}, TimeLocks: map[string][]byte{}, Aggregates: map[string][]byte{}, Nonces: map[string][]byte{}, watchOnly: map[string]string{}}
+  for _, w := range keys {
+    ws.Wallets[w.Address()] = w
+  }
+  return ws
+}
+
+// Define a method to set the seed the keys are derived from, it cannot be replaced once set
+func (ws *Wallets) SetSeed(seed []byte) error {
+  if ws.locked {
+    return ErrLocked
+  }
+  if ws.Seed != nil {
+    return ErrSeedExists
+  }
+  if _, err := NewMaster(seed); err != nil { // check the seed gives a valid master key
+    return err
+  }
+  ws.Seed = append([]byte{}, seed...)
+  return ws.Save()
+}
+
+// Define a method to get the master key of the seed
+func (ws *Wallets) MasterKey() (*ExtendedKey, error) {
+  if ws.locked {
+    return nil, ErrLocked
+  }
+  if ws.Seed == nil {
+    return nil, errors.New("wallet: the wallet file has no seed, create one with wallet init")
+  }
+  return NewMaster(ws.Seed)
+}
+
+// Define a method to get the extended public key of the account, to watch its addresses elsewhere
+func (ws *Wallets) AccountXPub() (string, error) {
+  master, err := ws.MasterKey()
+  if err != nil {
+    return "", err
+  }
+  account, err := master.Derive(AccountPath)
+  if err != nil {
+    return "", err
+  }
+  return account.Neuter().String(), nil
+}
+
+// Define a method to derive the key at a path of the seed and add it to the wallets, returning its address
+func (ws *Wallets) DeriveWallet(path string) (string, error) {
+  master, err := ws.MasterKey()
+  if err != nil {
+    return "", err
+  }
+  key, err := master.Derive(path)
+  if err != nil {
+    return "", err
+  }
+  w, err := key.Wallet()
+  if err != nil {
+    return "", err
+  }
+  address := w.Address()
+  ws.Wallets[address] = w
+  ws.Paths[address] = path
+  return address, ws.Save() // persist the new key right away
+}
+
+// Define a method to find the addresses of the account that were used on the chain, adding their keys to the wallets
+// The receive chain is scanned up to gapLimit unused addresses in a row, the next address is the one after the last used
+func (ws *Wallets) Scan(gapLimit int, used func(address string) bool) ([]DerivedAddress, error) {
+  master, err := ws.MasterKey()
+  if err != nil {
+    return nil, err
+  }
+  account, err := master.Derive(AccountPath)
+  if err != nil {
+    return nil, err
+  }
+  found, err := ScanAccount(account, gapLimit, used)
+  if err != nil {
+    return nil, err
+  }
+  for _, derived := range found {
+    key, err := account.Derive(fmt.Sprintf("m/0/%d", derived.Index))
+    if err != nil {
+      return nil, err
+    }
+    w, _ := key.Wallet() // the account is private
+    ws.Wallets[derived.Address] = w
+    ws.Paths[derived.Address] = receivePath(derived.Index)
+    if derived.Index >= ws.Next {
+      ws.Next = derived.Index + 1
+    }
+  }
+  return found, ws.Save()
+}
+
+// Define a function to get the path of an address of the receive chain of the account
+func receivePath(index uint32) string {
+  return fmt.Sprintf("%s/0/%d", AccountPath, index)
+}
+
+// Define a method to create a new wallet, save it and return its address
+// With a seed the key is the next one of the receive chain of the account, otherwise it is random
+func (ws *Wallets) CreateWallet() (string, error) {
+  if ws.locked { // the seed is not known
+    return "", ErrLocked
+  }
+  if ws.Seed != nil {
+    index := ws.Next
+    ws.Next++
+    return ws.DeriveWallet(receivePath(index))
+  }
+  w, err := NewWallet() // generate a keypair
+  if err != nil {
+    return "", err
+  }
+  address := w.Address()
+  ws.Wallets[address] = w
+  return address, ws.Save() // persist the new key right away
+}
+
+// Define a method to create a new Ed25519 wallet, save it and return its address
+// The key is random even with a seed, the seed derives ECDSA keys only, so the wallet file must be kept to spend from it
+func (ws *Wallets) CreateEd25519Wallet() (string, error) {
+  if ws.locked { // the new key would be saved unencrypted next to the sealed ones
+    return "", ErrLocked
+  }
+  w, err := NewEd25519Wallet()
+  if err != nil {
+    return "", err
+  }
+  address := w.Address()
+  ws.Wallets[address] = w
+  return address, ws.Save() // persist the new key right away
+}
+
+// Define a method to create a new wallet with a kind of key, ECDSA if the kind is empty, and return its address
+func (ws *Wallets) CreateWalletOfKind(kind string) (string, error) {
+  switch kind {
+  case "", KeyECDSA:
+    return ws.CreateWallet()
+  case KeyEd25519:
+    return ws.CreateEd25519Wallet()
+  }
+  return "", fmt.Errorf("wallet: unknown key kind %q, expected %s or %s", kind, KeyECDSA, KeyEd25519)
+}
+
+// Define a method to list the addresses of the wallets
+func (ws *Wallets) Addresses() []string {
+  var addresses []string
+  for address := range ws.Wallets {
+    addresses = append(addresses, address)
+  }
+  sort.Strings(addresses) // keep the output stable
+  return addresses
+}
+
+// Define a method to get the wallet of an address, ErrLocked while its private key is not in memory
+func (ws *Wallets) Wallet(address string) (*Wallet, error) {
+  w, ok := ws.Wallets[address]
+  if !ok {
+    return nil, fmt.Errorf("wallet: no key for address %s", address)
+  }
+  if ws.locked {
+    return nil, ErrLocked
+  }
+  return w, nil
+}
+
+// Define a method to sign a hash with the key of an address, returning the signature and the public key
+func (ws *Wallets) Sign(address string, hash []byte) ([]byte, []byte, error) {
+  w, err := ws.Wallet(address) // find the key
+  if err != nil {
+    return nil, nil, err
+  }
+  return w.Sign(hash), w.PublicKey, nil
+}
+
+// Define a method to remember a multisig script so the wallets can sign for its address, returning the address
+func (ws *Wallets) AddMultisig(script *MultisigScript) (string, error) {
+  address := script.Address()
+  ws.Multisigs[address] = script.Serialize()
+  return address, ws.Save()
+}
+
+// Define a method to sign a hash for a multisig address with the keys of its script held by the wallets
+// The signatures already made by other signers are kept, so signers can add theirs in turn; the returned
+// signatures are in the order of their keys and stop at the number needed
+// It returns the serialized script along with the signatures
+func (ws *Wallets) SignMultisig(address string, hash []byte, signatures [][]byte) ([]byte, [][]byte, error) {
+  data, ok := ws.Multisigs[address]
+  if !ok {
+    return nil, nil, fmt.Errorf("wallet: no multisig script for address %s", address)
+  }
+  if ws.locked {
+    return nil, nil, ErrLocked
+  }
+  script, err := ParseMultisigScript(data)
+  if err != nil {
+    return nil, nil, err
+  }
+  slots := make([][]byte, len(script.PubKeys)) // the signature of each key, if any
+  for _, signature := range signatures {
+    if i := script.signer(hash, signature); i >= 0 {
+      slots[i] = signature
+    }
+  }
+  var signed [][]byte
+  for i, key := range script.PubKeys {
+    if w, ok := ws.Wallets[AddressFromPubKey(key)]; ok && slots[i] == nil {
+      slots[i] = w.Sign(hash)
+    }
+    if slots[i] != nil && len(signed) < script.Required {
+      signed = append(signed, slots[i])
+    }
+  }
+  return data, signed, nil
+}
+
+// Define a method to remember an aggregate key so the wallets can sign for its address, returning the address
+func (ws *Wallets) AddAggregate(key *AggregateKey) (string, error) {
+  address := key.Address()
+  ws.Aggregates[address] = key.Serialize()
+  return address, ws.Save()
+}
+
+// Define a method to get the aggregate key of an address, nil if the wallets have none
+func (ws *Wallets) Aggregate(address string) *AggregateKey {
+  data, ok := ws.Aggregates[address]
+  if !ok {
+    return nil
+  }
+  key, err := ParseAggregateKey(data)
+  if err != nil {
+    return nil
+  }
+  return key
+}
+
+// Define a method to get the multisig scripts and the aggregate keys of the wallets, by address, what a partially
+// signed transaction needs to be signed by others
+func (ws *Wallets) Redeems() map[string][]byte {
+  redeems := map[string][]byte{}
+  for address, script := range ws.Multisigs {
+    redeems[address] = script
+  }
+  for address, key := range ws.Aggregates {
+    redeems[address] = key
+  }
+  return redeems
+}
+
+// Define a function to get the name of the secret nonce of a key for a hash
+func nonceID(hash, pubKey []byte) string {
+  return fmt.Sprintf("%x:%x", hash, pubKey)
+}
+
+// Define a method to save the secret nonces, keys held in memory only keep them until they are dropped
+func (ws *Wallets) saveNonces() error {
+  if err := ws.Save(); err != nil && err != ErrNoWalletFile {
+    return err
+  }
+  return nil
+}
+
+// Define a method to start signing a hash with an aggregate key, the first of the two rounds: its keys held by the
+// wallets draw their nonces, saved until they sign, and the public nonces to share with the other signers are returned
+// by hex public key; asked again for the same hash, the same nonces are returned
+func (ws *Wallets) MuSigNonces(key *AggregateKey, hash []byte) (map[string][]byte, error) {
+  if ws.locked { // the nonces are secrets too
+    return nil, ErrLocked
+  }
+  nonces, drawn := map[string][]byte{}, false
+  for _, pubKey := range key.PubKeys {
+    if _, ok := ws.Wallets[AddressFromPubKey(pubKey)]; !ok {
+      continue
+    }
+    id := nonceID(hash, pubKey)
+    secret, ok := ws.Nonces[id]
+    if !ok {
+      var err error
+      if secret, _, err = schnorr.NewNonce(); err != nil {
+        return nil, err
+      }
+      ws.Nonces[id], drawn = secret, true
+    }
+    public, err := schnorr.PublicNonce(secret)
+    if err != nil {
+      return nil, err
+    }
+    nonces[fmt.Sprintf("%x", pubKey)] = public
+  }
+  if drawn { // saved before they are shared, so a signer never draws two nonces for one session
+    return nonces, ws.saveNonces()
+  }
+  return nonces, nil
+}
+
+// Define a method to sign a hash with an aggregate key once every signer shared its nonce, the second round: its keys
+// held by the wallets whose nonce is among the given ones make their partial signatures, returned by hex public key;
+// their secret nonces are deleted and saved before, so none ever signs twice
+func (ws *Wallets) MuSigSign(key *AggregateKey, hash []byte, nonces map[string][]byte) (map[string][]byte, error) {
+  if ws.locked {
+    return nil, ErrLocked
+  }
+  partials := map[string][]byte{}
+  for _, pubKey := range key.PubKeys {
+    w, ok := ws.Wallets[AddressFromPubKey(pubKey)]
+    if !ok {
+      continue
+    }
+    id, hexKey := nonceID(hash, pubKey), fmt.Sprintf("%x", pubKey)
+    secret, ok := ws.Nonces[id]
+    if !ok { // no session started, or signed already
+      continue
+    }
+    if public, err := schnorr.PublicNonce(secret); err != nil || !bytes.Equal(public, nonces[hexKey]) { // another session
+      continue
+    }
+    partial, err := schnorr.PartialSign(w.PrivateKey, secret, key.PubKeys, nonces, hash)
+    if err != nil {
+      return nil, err
+    }
+    for i := range secret {
+      secret[i] = 0
+    }
+    delete(ws.Nonces, id)
+    partials[hexKey] = partial
+  }
+  if len(partials) == 0 {
+    return partials, nil
+  }
+  if err := ws.saveNonces(); err != nil { // the partial signatures are only released once their nonces are gone
+    return nil, err
+  }
+  return partials, nil
+}
+
+// Define a method to sign a hash for an aggregate address whose keys are all held by the wallets, the two rounds at
+// once with nonces that never leave memory
+// It returns the signature along with the aggregate key
+func (ws *Wallets) SignAggregate(address string, hash []byte) ([]byte, []byte, error) {
+  key := ws.Aggregate(address)
+  if key == nil {
+    return nil, nil, fmt.Errorf("wallet: no aggregate key for address %s", address)
+  }
+  if ws.locked {
+    return nil, nil, ErrLocked
+  }
+  signers := make([]*Wallet, len(key.PubKeys))
+  secrets, nonces := make([][]byte, len(key.PubKeys)), map[string][]byte{}
+  for i, pubKey := range key.PubKeys {
+    w, ok := ws.Wallets[AddressFromPubKey(pubKey)]
+    if !ok {
+      return nil, nil, fmt.Errorf("wallet: key %x of %s is not in the wallet file, its signers sign a partially signed transaction in turn", pubKey, address)
+    }
+    secret, public, err := schnorr.NewNonce()
+    if err != nil {
+      return nil, nil, err
+    }
+    signers[i], secrets[i], nonces[fmt.Sprintf("%x", pubKey)] = w, secret, public
+  }
+  partials := map[string][]byte{}
+  for i, w := range signers {
+    partial, err := schnorr.PartialSign(w.PrivateKey, secrets[i], key.PubKeys, nonces, hash)
+    if err != nil {
+      return nil, nil, err
+    }
+    partials[fmt.Sprintf("%x", w.PublicKey)] = partial
+  }
+  signature, err := schnorr.Combine(key.PubKeys, nonces, partials, hash)
+  if err != nil {
+    return nil, nil, err
+  }
+  return signature, key.Key(), nil
+}
+
+// Define a method to remember a time lock script of a key of the wallets, returning its address
+func (ws *Wallets) AddTimeLock(script *TimeLockScript) (string, error) {
+  if _, ok := ws.Wallets[AddressFromPubKey(script.PubKey)]; !ok {
+    return "", errors.New("wallet: the key of the time lock is not in the wallet file")
+  }
+  address := script.Address()
+  ws.TimeLocks[address] = script.Serialize()
+  return address, ws.Save()
+}
+
+// Define a method to get the time lock script of an address, nil if the wallets have none
+func (ws *Wallets) TimeLock(address string) *TimeLockScript {
+  data, ok := ws.TimeLocks[address]
+  if !ok {
+    return nil
+  }
+  script, err := ParseTimeLockScript(data)
+  if err != nil {
+    return nil
+  }
+  return script
+}
+
+// Define a method to sign a hash for a time-locked address with its key
+// It returns the serialized script along with the signature
+func (ws *Wallets) SignTimeLock(address string, hash []byte) ([]byte, []byte, error) {
+  script := ws.TimeLock(address)
+  if script == nil {
+    return nil, nil, fmt.Errorf("wallet: no time lock script for address %s", address)
+  }
+  signature, _, err := ws.Sign(AddressFromPubKey(script.PubKey), hash)
+  if err != nil {
+    return nil, nil, err
+  }
+  return ws.TimeLocks[address], signature, nil
+}
+
+// Define a method to write the wallets to the encrypted file
+func (ws *Wallets) Save() error {
+  if ws.file == "" {
+    return ErrNoWalletFile
+  }
+  stored := walletData{Keys: map[string][]byte{}, Seed: ws.Seed, Paths: ws.Paths, Next: ws.Next, Multisigs: ws.Multisigs, TimeLocks: ws.TimeLocks, Aggregates: ws.Aggregates, Nonces: ws.Nonces, WatchOnly: map[string]string{}} // only the private keys are stored, everything else is derived
+  passphrase := ws.passphrase
+  if ws.sealed != nil { // an encrypted file keeps the keys encrypted with their passphrase, the rest is readable without it
+    if err := ws.seal(); err != nil {
+      return err
+    }
+    stored.Keys, stored.Seed, stored.Nonces, stored.Sealed, stored.PubKeys, passphrase = nil, nil, nil, ws.sealed, map[string][]byte{}, nil
+    for address, w := range ws.Wallets {
+      stored.PubKeys[address] = w.PublicKey
+    }
+  } else {
+    for address, w := range ws.Wallets {
+      stored.Keys[address] = w.secret()
+    }
+  }
+  ws.watchMu.RLock() // lock the watch-only addresses
+  for address, xpub := range ws.watchOnly {
+    stored.WatchOnly[address] = xpub
+  }
+  ws.watchMu.RUnlock() // unlock them
+  var plain bytes.Buffer
+  if err := gob.NewEncoder(&plain).Encode(stored); err != nil {
+    return err
+  }
+  data, err := encrypt(plain.Bytes(), passphrase) // encrypt the keys
+  if err != nil {
+    return err
+  }
+  if err := os.MkdirAll(filepath.Dir(ws.file), 0700); err != nil {
+    return err
+  }
+  tmp := ws.file + ".tmp" // write to a temporary file first so a crash never leaves a truncated wallet
+  if err := os.WriteFile(tmp, data, 0600); err != nil {
+    return err
+  }
+  return os.Rename(tmp, ws.file)
+}
+
+// Define a function to build the AES-GCM cipher for a passphrase and a salt
+func newCipher(passphrase, salt []byte) (cipher.AEAD, error) {
+  key, err := scrypt.Key(passphrase, salt, 1<<15, 8, 1, keyLen) // derive the key, slow on purpose
+  if err != nil {
+    return nil, err
+  }
+  block, err := aes.NewCipher(key)
+  if err != nil {
+    return nil, err
+  }
+  return cipher.NewGCM(block)
+}
+
+// Define a function to encrypt data with a passphrase: salt, nonce and ciphertext are stored together
+func encrypt(plain, passphrase []byte) ([]byte, error) {
+  salt := make([]byte, saltLen) // a fresh salt for every save
+  if _, err := rand.Read(salt); err != nil {
+    return nil, err
+  }
+  aead, err := newCipher(passphrase, salt)
+  if err != nil {
+    return nil, err
+  }
+  nonce := make([]byte, aead.NonceSize())
+  if _, err := rand.Read(nonce); err != nil {
+    return nil, err
+  }
+  data := append(salt, nonce...)
+  return aead.Seal(data, nonce, plain, nil), nil // append the ciphertext
+}
+
+// Define a function to decrypt data written by encrypt
+func decrypt(data, passphrase []byte) ([]byte, error) {
+  if len(data) < saltLen {
+    return nil, ErrWrongPassphrase
+  }
+  aead, err := newCipher(passphrase, data[:saltLen])
+  if err != nil {
+    return nil, err
+  }
+  data = data[saltLen:]
+  if len(data) < aead.NonceSize() {
+    return nil, ErrWrongPassphrase
+  }
+  plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+  if err != nil {
+    return nil, ErrWrongPassphrase // authentication failed
+  }
+  return plain, nil
+}
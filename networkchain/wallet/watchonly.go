@@ -0,0 +1,97 @@
+package wallet
+
+import (
+  "errors"               // for the error of a private key
+  "fmt"                  // to format the errors
+  "networkchain/address" // to check the imported addresses
+  "sort"                 // to list the addresses in a stable order
+)
+
+// Define an error returned when an extended private key is imported as watch-only
+var ErrPrivateXPub = errors.New("wallet: a watch-only import takes the extended public key, not the private one")
+
+// Define a method to follow an address without its private key, so its coins and transactions are known but cannot be
+// spent; cold storage funds are watched this way
+func (ws *Wallets) ImportAddress(addr string) error {
+  if !address.Validate(addr) {
+    return fmt.Errorf("wallet: invalid address %q", addr)
+  }
+  if _, ok := ws.Wallets[addr]; ok {
+    return fmt.Errorf("wallet: the key of %s is in the wallet already", addr)
+  }
+  ws.watchMu.Lock() // lock the watch-only addresses
+  if _, ok := ws.watchOnly[addr]; !ok { // an address of an extended public key keeps it
+    ws.watchOnly[addr] = ""
+  }
+  ws.watchMu.Unlock() // unlock them
+  return ws.Save()
+}
+
+// Define a method to follow the receive addresses of an account by its extended public key, returning the ones added
+// The addresses in use are found like a scan does, and gapLimit more are watched past the last one so the next payments
+// are seen; importing the key again with a larger gap watches more of them
+func (ws *Wallets) ImportXPub(xpub string, gapLimit int, used func(address string) bool) ([]string, error) {
+  account, err := ParseExtendedKey(xpub)
+  if err != nil {
+    return nil, err
+  }
+  if account.IsPrivate() {
+    return nil, ErrPrivateXPub
+  }
+  found, err := ScanAccount(account, gapLimit, used)
+  if err != nil {
+    return nil, err
+  }
+  count := uint32(gapLimit) // the number of receive addresses watched
+  for _, derived := range found {
+    if derived.Index+1+uint32(gapLimit) > count {
+      count = derived.Index + 1 + uint32(gapLimit)
+    }
+  }
+  receive, err := account.Child(0)
+  if err != nil {
+    return nil, err
+  }
+  var added []string
+  ws.watchMu.Lock() // lock the watch-only addresses
+  for index := uint32(0); index < count; index++ {
+    child, err := receive.Child(index)
+    if errors.Is(err, ErrInvalidChild) { // wallets skip the rare invalid indexes
+      continue
+    }
+    if err != nil {
+      ws.watchMu.Unlock() // unlock them
+      return nil, err
+    }
+    addr := child.Address()
+    if _, owned := ws.Wallets[addr]; owned {
+      continue
+    }
+    if _, ok := ws.watchOnly[addr]; !ok {
+      added = append(added, addr)
+    }
+    ws.watchOnly[addr] = account.String()
+  }
+  ws.watchMu.Unlock() // unlock them
+  return added, ws.Save()
+}
+
+// Define a method to check if an address is watched without its private key
+func (ws *Wallets) IsWatchOnly(addr string) bool {
+  ws.watchMu.RLock() // lock the watch-only addresses
+  defer ws.watchMu.RUnlock() // unlock them when done
+  _, ok := ws.watchOnly[addr]
+  return ok
+}
+
+// Define a method to list the watch-only addresses
+func (ws *Wallets) WatchOnlyAddresses() []string {
+  ws.watchMu.RLock() // lock the watch-only addresses
+  defer ws.watchMu.RUnlock() // unlock them when done
+  var addresses []string
+  for addr := range ws.watchOnly {
+    addresses = append(addresses, addr)
+  }
+  sort.Strings(addresses) // keep the output stable
+  return addresses
+}
@@ -0,0 +1,661 @@
+package main
+
+import (
+  "encoding/hex"         // the keys and the transactions are exchanged in hex
+  "errors"               // for the errors of the arguments
+  "fmt"                  // to print the results
+  "networkchain/address" // to check the recipients
+  "networkchain/script"  // to tell the missing signatures apart
+  "networkchain/wallet"  // the keys of the user
+
+  "github.com/spf13/cobra" // the command line interface
+)
+
+// Create the command grouping the hierarchical deterministic wallet commands
+func walletCmd() *cobra.Command {
+  var passphrase string
+  cmd := &cobra.Command{
+    Use:   "wallet",
+    Short: "Manage the seed of the wallet file and the keys derived from it",
+  }
+  cmd.PersistentFlags().StringVar(&passphrase, "passphrase", "", "passphrase encrypting the wallet file")
+  cmd.PersistentFlags().Bool("offline", false, "refuse the commands needing the chain, on a machine keeping the keys away from the network")
+  cmd.AddCommand(walletInitCmd(&passphrase), walletEncryptCmd(&passphrase), walletRestoreCmd(&passphrase), walletDeriveCmd(&passphrase), walletXPubCmd(&passphrase), walletScanCmd(&passphrase),
+    walletPubKeyCmd(&passphrase), walletMultisigCmd(&passphrase), walletMuSigCmd(&passphrase), walletTimeLockCmd(&passphrase), walletSignCmd(&passphrase),
+    walletCreatePSBTCmd(&passphrase), walletSignPSBTCmd(&passphrase), walletCombinePSBTCmd(), walletFinalizePSBTCmd(), walletSendPSBTCmd())
+  return cmd
+}
+
+// Create the command that gives the wallet file the seed of a new mnemonic
+func walletInitCmd(passphrase *string) *cobra.Command {
+  var words int
+  var seedPassphrase string
+  cmd := &cobra.Command{
+    Use:   "init",
+    Short: "Generate the mnemonic the next addresses of the wallet file are derived from",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      mnemonic, err := wallet.NewMnemonic(words)
+      if err != nil {
+        return err
+      }
+      wallets, err := openWallets(cmd, *passphrase)
+      if err != nil {
+        return err
+      }
+      if err := wallets.SetSeed(wallet.MnemonicToSeed(mnemonic, seedPassphrase)); err != nil { // a seed is never replaced, the keys derived from it would be lost
+        return err
+      }
+      fmt.Printf("Your mnemonic: %s\n", mnemonic)
+      fmt.Println("Write it down with the seed passphrase if you gave one, they restore every address created from now on.")
+      return nil
+    },
+  }
+  cmd.Flags().IntVar(&words, "words", 12, "number of words of the mnemonic, 12 or 24")
+  cmd.Flags().StringVar(&seedPassphrase, "seed-passphrase", "", "optional passphrase extending the mnemonic, needed again to restore")
+  return cmd
+}
+
+// Create the command that encrypts the keys and the seed of the wallet file with a passphrase, so a node opens it without
+// the passphrase and signs only while it is unlocked
+func walletEncryptCmd(passphrase *string) *cobra.Command {
+  var newPassphrase string
+  cmd := &cobra.Command{
+    Use:   "encrypt",
+    Short: "Encrypt the keys of the wallet file, the node signs only once unlocked with walletpassphrase",
+    Long:  "Encrypt the keys of the wallet file, the node signs only once unlocked with walletpassphrase.\nThe other wallet commands take the new passphrase with --passphrase.",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      wallets, err := openWallets(cmd, *passphrase)
+      if err != nil {
+        return err
+      }
+      if err := wallets.Encrypt([]byte(newPassphrase)); err != nil {
+        return err
+      }
+      fmt.Printf("Encrypted the keys of %d addresses\n", len(wallets.Addresses()))
+      return nil
+    },
+  }
+  cmd.Flags().StringVar(&newPassphrase, "new-passphrase", "", "passphrase encrypting the keys")
+  cmd.MarkFlagRequired("new-passphrase")
+  return cmd
+}
+
+// Create the command that restores the seed of a mnemonic and finds the addresses used on the chain
+func walletRestoreCmd(passphrase *string) *cobra.Command {
+  var mnemonic, seedPassphrase string
+  var gapLimit int
+  cmd := &cobra.Command{
+    Use:   "restore",
+    Short: "Restore the wallet file from a mnemonic and find the addresses used on the chain",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      if err := wallet.ValidateMnemonic(mnemonic); err != nil { // catch the typos before anything is written
+        return err
+      }
+      cfg, err := loadConfig(cmd) // find the data directory
+      if err != nil {
+        return err
+      }
+      wallets, err := wallet.LoadWallets(cfg.DataDir, []byte(*passphrase))
+      if err != nil {
+        return err
+      }
+      bc, err := openWalletChain(cmd, cfg) // open the chain before the seed is set, so an offline restore changes nothing
+      if err != nil {
+        return err
+      }
+      defer bc.Close()
+      if err := wallets.SetSeed(wallet.MnemonicToSeed(mnemonic, seedPassphrase)); err != nil {
+        return err
+      }
+      used, err := usedOnChain(bc)
+      if err != nil {
+        return err
+      }
+      found, err := wallets.Scan(gapLimit, used)
+      if err != nil {
+        return err
+      }
+      printDerived(bc, found)
+      return nil
+    },
+  }
+  cmd.Flags().StringVar(&mnemonic, "mnemonic", "", "the words of the mnemonic, separated by spaces")
+  cmd.Flags().StringVar(&seedPassphrase, "seed-passphrase", "", "passphrase given with the mnemonic when it was created")
+  cmd.Flags().IntVar(&gapLimit, "gap", wallet.DefaultGapLimit, "number of unused addresses in a row ending the scan")
+  cmd.MarkFlagRequired("mnemonic")
+  return cmd
+}
+
+// Create the command that adds the key at a derivation path to the wallet file
+func walletDeriveCmd(passphrase *string) *cobra.Command {
+  var path string
+  cmd := &cobra.Command{
+    Use:   "derive",
+    Short: "Derive the key at a path of the seed and print its address",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      wallets, err := openWallets(cmd, *passphrase)
+      if err != nil {
+        return err
+      }
+      address, err := wallets.DeriveWallet(path)
+      if err != nil {
+        return err
+      }
+      fmt.Printf("Address of %s: %s\n", path, address)
+      return nil
+    },
+  }
+  cmd.Flags().StringVar(&path, "path", "", "derivation path like m/0'/0/5, ' or h marking the hardened children")
+  cmd.MarkFlagRequired("path")
+  return cmd
+}
+
+// Create the command that prints the extended public key of the account
+func walletXPubCmd(passphrase *string) *cobra.Command {
+  return &cobra.Command{
+    Use:   "xpub",
+    Short: "Print the extended public key of the account, to watch its addresses without the private keys",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      wallets, err := openWallets(cmd, *passphrase)
+      if err != nil {
+        return err
+      }
+      xpub, err := wallets.AccountXPub()
+      if err != nil {
+        return err
+      }
+      fmt.Printf("Extended public key of %s: %s\n", wallet.AccountPath, xpub)
+      return nil
+    },
+  }
+}
+
+// Create the command that finds the used addresses of the account on the chain
+func walletScanCmd(passphrase *string) *cobra.Command {
+  var xpub string
+  var gapLimit int
+  cmd := &cobra.Command{
+    Use:   "scan",
+    Short: "Find the addresses of the account used on the chain, adding their keys to the wallet file",
+    Long:  "Find the addresses of the account used on the chain, adding their keys to the wallet file.\nWith --xpub the addresses of an extended public key are listed instead, without touching the wallet file.",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      cfg, err := loadConfig(cmd) // find the data directory
+      if err != nil {
+        return err
+      }
+      bc, err := openWalletChain(cmd, cfg) // open the chain
+      if err != nil {
+        return err
+      }
+      defer bc.Close()
+      used, err := usedOnChain(bc)
+      if err != nil {
+        return err
+      }
+      var found []wallet.DerivedAddress
+      if xpub != "" { // watch only
+        account, err := wallet.ParseExtendedKey(xpub)
+        if err != nil {
+          return err
+        }
+        if found, err = wallet.ScanAccount(account, gapLimit, used); err != nil {
+          return err
+        }
+      } else {
+        wallets, err := wallet.LoadWallets(cfg.DataDir, []byte(*passphrase))
+        if err != nil {
+          return err
+        }
+        if found, err = wallets.Scan(gapLimit, used); err != nil {
+          return err
+        }
+      }
+      printDerived(bc, found)
+      return nil
+    },
+  }
+  cmd.Flags().StringVar(&xpub, "xpub", "", "extended public key of an account to watch")
+  cmd.Flags().IntVar(&gapLimit, "gap", wallet.DefaultGapLimit, "number of unused addresses in a row ending the scan")
+  return cmd
+}
+
+// Create the command that prints the public key of an address, to share it with the other keys of a multisig
+func walletPubKeyCmd(passphrase *string) *cobra.Command {
+  var addr string
+  cmd := &cobra.Command{
+    Use:   "pubkey",
+    Short: "Print the public key of an address of the wallet file",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      wallets, err := openWallets(cmd, *passphrase)
+      if err != nil {
+        return err
+      }
+      w, err := wallets.Wallet(addr)
+      if err != nil {
+        return err
+      }
+      fmt.Printf("Public key of %s: %x\n", addr, w.PublicKey)
+      return nil
+    },
+  }
+  cmd.Flags().StringVar(&addr, "address", "", "address of the wallet file")
+  cmd.MarkFlagRequired("address")
+  return cmd
+}
+
+// Create the command that makes an M-of-N multisig address and remembers its script in the wallet file
+func walletMultisigCmd(passphrase *string) *cobra.Command {
+  var required int
+  var keys []string
+  cmd := &cobra.Command{
+    Use:   "multisig",
+    Short: "Create a multisig address spendable with the signatures of some of its keys",
+    Long:  "Create a multisig address spendable with the signatures of some of its keys.\nEvery signer runs the command with the same keys in the same order to get the same address.",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      wallets, err := openWallets(cmd, *passphrase)
+      if err != nil {
+        return err
+      }
+      var pubKeys [][]byte
+      for _, key := range keys { // a key is hex or an address of the wallet file
+        if w, err := wallets.Wallet(key); err == nil {
+          pubKeys = append(pubKeys, w.PublicKey)
+          continue
+        }
+        pubKey, err := hex.DecodeString(key)
+        if err != nil {
+          return fmt.Errorf("%q is neither a public key nor an address of the wallet file", key)
+        }
+        pubKeys = append(pubKeys, pubKey)
+      }
+      multisig, err := wallet.NewMultisigScript(required, pubKeys)
+      if err != nil {
+        return err
+      }
+      addr, err := wallets.AddMultisig(multisig)
+      if err != nil {
+        return err
+      }
+      fmt.Printf("Multisig address (%d of %d): %s\n", required, len(pubKeys), addr)
+      fmt.Printf("Script: %x\n", multisig.Serialize())
+      return nil
+    },
+  }
+  cmd.Flags().IntVar(&required, "required", 1, "number of signatures needed to spend")
+  cmd.Flags().StringSliceVar(&keys, "key", nil, "public key in hex or address of the wallet file, repeated for each key")
+  cmd.MarkFlagRequired("key")
+  return cmd
+}
+
+// Create the command that makes the address of the aggregate key of some keys and remembers them in the wallet file
+func walletMuSigCmd(passphrase *string) *cobra.Command {
+  var keys []string
+  cmd := &cobra.Command{
+    Use:   "musig",
+    Short: "Create an address spendable with the signatures of all its keys, added up into one",
+    Long:  "Create an address spendable with the signatures of all its keys, added up into one Schnorr signature.\nThe keys may be given in any order, every signer gets the same address.\nOn the chain its outputs and their spends look like those of a single key.",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      wallets, err := openWallets(cmd, *passphrase)
+      if err != nil {
+        return err
+      }
+      var pubKeys [][]byte
+      for _, key := range keys { // a key is hex or an address of the wallet file
+        if w, err := wallets.Wallet(key); err == nil {
+          pubKeys = append(pubKeys, w.PublicKey)
+          continue
+        }
+        pubKey, err := hex.DecodeString(key)
+        if err != nil {
+          return fmt.Errorf("%q is neither a public key nor an address of the wallet file", key)
+        }
+        pubKeys = append(pubKeys, pubKey)
+      }
+      aggregate, err := wallet.NewAggregateKey(pubKeys)
+      if err != nil {
+        return err
+      }
+      addr, err := wallets.AddAggregate(aggregate)
+      if err != nil {
+        return err
+      }
+      fmt.Printf("Aggregate address (%d keys): %s\n", len(pubKeys), addr)
+      fmt.Printf("Aggregate key: %x\n", aggregate.Key())
+      fmt.Printf("Keys: %x\n", aggregate.Serialize())
+      return nil
+    },
+  }
+  cmd.Flags().StringSliceVar(&keys, "key", nil, "public key in hex or ECDSA address of the wallet file, repeated for each key")
+  cmd.MarkFlagRequired("key")
+  return cmd
+}
+
+// Create the command that makes an address whose coins a key of the wallet file can only spend after a height or a time
+func walletTimeLockCmd(passphrase *string) *cobra.Command {
+  var addr string
+  var until int64
+  cmd := &cobra.Command{
+    Use:   "timelock",
+    Short: "Create an address whose coins cannot be spent before a block height or a time",
+    Long:  "Create an address whose coins cannot be spent before a block height or a time.\nThe coins sent to it can only be spent by the key of --address, in blocks after --until.",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      wallets, err := openWallets(cmd, *passphrase)
+      if err != nil {
+        return err
+      }
+      w, err := wallets.Wallet(addr)
+      if err != nil {
+        return err
+      }
+      lock, err := wallet.NewTimeLockScript(until, w.PublicKey)
+      if err != nil {
+        return err
+      }
+      locked, err := wallets.AddTimeLock(lock)
+      if err != nil {
+        return err
+      }
+      fmt.Printf("Address locked until %d: %s\n", until, locked)
+      fmt.Printf("Script: %x\n", lock.Serialize())
+      return nil
+    },
+  }
+  cmd.Flags().StringVar(&addr, "address", "", "address of the wallet file spending the coins")
+  cmd.Flags().Int64Var(&until, "until", 0, "block height, or unix time from 500000000 on, the coins are locked until")
+  cmd.MarkFlagRequired("address")
+  cmd.MarkFlagRequired("until")
+  return cmd
+}
+
+// Create the command that adds the signatures of the wallet file to a partially signed multisig transaction
+func walletSignCmd(passphrase *string) *cobra.Command {
+  var rawHex string
+  cmd := &cobra.Command{
+    Use:   "sign",
+    Short: "Add the signatures of the wallet file to a transaction and print it",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      raw, err := hex.DecodeString(rawHex)
+      if err != nil {
+        return errors.New("the transaction is not hex")
+      }
+      tx, err := decodeTransaction(raw)
+      if err != nil {
+        return err
+      }
+      cfg, err := loadConfig(cmd) // find the data directory
+      if err != nil {
+        return err
+      }
+      wallets, err := wallet.LoadWallets(cfg.DataDir, []byte(*passphrase))
+      if err != nil {
+        return err
+      }
+      bc, err := openWalletChain(cmd, cfg) // the spent outputs come from the chain, signpsbt signs offline
+      if err != nil {
+        return err
+      }
+      defer bc.Close()
+      utxoSet := UTXOSet{bc}
+      prevOuts, err := utxoSet.SpentOutputs(tx)
+      if err != nil {
+        return err
+      }
+      if err := tx.Sign(wallets, prevOuts); err != nil {
+        return err
+      }
+      if complete, err := printIfIncomplete(tx, utxoSet); err != nil || !complete {
+        return err
+      }
+      fmt.Printf("Signed transaction %x, submit it with sendrawtransaction: %x\n", tx.ID, tx.Serialize())
+      return nil
+    },
+  }
+  cmd.Flags().StringVar(&rawHex, "tx", "", "hex serialized transaction")
+  cmd.MarkFlagRequired("tx")
+  return cmd
+}
+
+// Create the command that funds a payment with the coins of the wallet file, the watch-only ones included, and writes it
+// unsigned with the outputs it spends, for the machine holding the keys to sign offline
+func walletCreatePSBTCmd(passphrase *string) *cobra.Command {
+  var to, out string
+  var amount, feeRate int
+  cmd := &cobra.Command{
+    Use:   "createpsbt",
+    Short: "Fund a payment with the coins of the wallet file and write it unsigned, to be signed offline",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      if !address.Validate(to) {
+        return fmt.Errorf("invalid recipient address %q", to)
+      }
+      if amount <= 0 {
+        return errors.New("the amount must be positive")
+      }
+      payment, err := NewTXOutput(amount, to)
+      if err != nil {
+        return err
+      }
+      cfg, err := loadConfig(cmd) // find the data directory
+      if err != nil {
+        return err
+      }
+      wallets, err := wallet.LoadWallets(cfg.DataDir, []byte(*passphrase))
+      if err != nil {
+        return err
+      }
+      bc, err := openWalletChain(cmd, cfg) // the coins come from the chain
+      if err != nil {
+        return err
+      }
+      defer bc.Close()
+      addresses := append(wallets.Addresses(), wallets.WatchOnlyAddresses()...)
+      p, fee, err := fundPartialTx((UTXOSet{bc}).FindCoins(addresses), payment, feeRate, wallets.Redeems())
+      if err != nil {
+        return err
+      }
+      fmt.Printf("Funded the payment with %d inputs paying a fee of %d\n", len(p.Inputs), fee)
+      return writePartialTx(p, out)
+    },
+  }
+  flags := cmd.Flags()
+  flags.StringVar(&to, "to", "", "address receiving the coins")
+  flags.IntVar(&amount, "amount", 0, "amount of coins to send")
+  flags.IntVar(&feeRate, "feerate", defaultFeeRate, fmt.Sprintf("fee paid per %d bytes of the transaction", feeRateUnit))
+  flags.StringVar(&out, "out", "", "file receiving the partially signed transaction, printed if empty")
+  cmd.MarkFlagRequired("to")
+  cmd.MarkFlagRequired("amount")
+  return cmd
+}
+
+// Create the command that adds the signatures of the wallet file to a partially signed transaction, with no chain: the
+// container carries the outputs spent
+func walletSignPSBTCmd(passphrase *string) *cobra.Command {
+  var psbt, in, out string
+  cmd := &cobra.Command{
+    Use:   "signpsbt",
+    Short: "Add the signatures of the wallet file to a partially signed transaction and write it",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      p, err := readPartialTx(psbt, in)
+      if err != nil {
+        return err
+      }
+      wallets, err := openWallets(cmd, *passphrase)
+      if err != nil {
+        return err
+      }
+      if wallets.IsLocked() { // the keys are encrypted and no passphrase was given
+        return wallet.ErrLocked
+      }
+      added, nonces := p.Sign(wallets)
+      if nonces > 0 { // the signers of an aggregate key sign once all their nonces are in
+        fmt.Printf("Added %d nonces for aggregate keys, combine the copies of every signer and sign again\n", nonces)
+      }
+      if _, err := p.Finalize(); err != nil {
+        fmt.Printf("Added %d signatures, the transaction is not complete: %v\n", added, err)
+      } else {
+        fmt.Printf("Added %d signatures, the transaction is complete, finalize it\n", added)
+      }
+      return writePartialTx(p, out)
+    },
+  }
+  cmd.Flags().StringVar(&psbt, "psbt", "", "partially signed transaction in base64")
+  cmd.Flags().StringVar(&in, "in", "", "file holding the partially signed transaction, instead of --psbt")
+  cmd.Flags().StringVar(&out, "out", "", "file receiving the signed copy, printed if empty")
+  return cmd
+}
+
+// Create the command that merges the signatures of copies of a partially signed transaction signed apart
+func walletCombinePSBTCmd() *cobra.Command {
+  var psbts, files []string
+  var out string
+  cmd := &cobra.Command{
+    Use:   "combinepsbt",
+    Short: "Merge the signatures of copies of a partially signed transaction and write it",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      var parts []*PartialTx
+      for _, psbt := range psbts {
+        p, err := readPartialTx(psbt, "")
+        if err != nil {
+          return err
+        }
+        parts = append(parts, p)
+      }
+      for _, path := range files {
+        p, err := readPartialTx("", path)
+        if err != nil {
+          return err
+        }
+        parts = append(parts, p)
+      }
+      combined, err := combinePartialTxs(parts)
+      if err != nil {
+        return err
+      }
+      return writePartialTx(combined, out)
+    },
+  }
+  cmd.Flags().StringArrayVar(&psbts, "psbt", nil, "partially signed transaction in base64, repeated for each copy")
+  cmd.Flags().StringArrayVar(&files, "in", nil, "file holding a copy, repeated for each one")
+  cmd.Flags().StringVar(&out, "out", "", "file receiving the combined copy, printed if empty")
+  return cmd
+}
+
+// Create the command that builds the signed transaction of a partially signed one with enough signatures
+func walletFinalizePSBTCmd() *cobra.Command {
+  var psbt, in string
+  cmd := &cobra.Command{
+    Use:   "finalizepsbt",
+    Short: "Build the signed transaction of a partially signed transaction with enough signatures",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      p, err := readPartialTx(psbt, in)
+      if err != nil {
+        return err
+      }
+      tx, err := p.Finalize()
+      if err != nil {
+        return err
+      }
+      fmt.Printf("Signed transaction %x, submit it with sendrawtransaction: %x\n", tx.ID, tx.Serialize())
+      return nil
+    },
+  }
+  cmd.Flags().StringVar(&psbt, "psbt", "", "partially signed transaction in base64")
+  cmd.Flags().StringVar(&in, "in", "", "file holding the partially signed transaction, instead of --psbt")
+  return cmd
+}
+
+// Create the command that finalizes a partially signed transaction signed offline and hands it over to a node
+func walletSendPSBTCmd() *cobra.Command {
+  var psbt, in, node string
+  cmd := &cobra.Command{
+    Use:   "sendpsbt",
+    Short: "Finalize a partially signed transaction signed offline and hand the payment to a node",
+    Args:  cobra.NoArgs,
+    RunE: func(cmd *cobra.Command, args []string) error {
+      p, err := readPartialTx(psbt, in)
+      if err != nil {
+        return err
+      }
+      tx, err := p.Finalize()
+      if err != nil {
+        return err
+      }
+      cfg, err := loadConfig(cmd) // find the data directory and the first node
+      if err != nil {
+        return err
+      }
+      bc, err := openWalletChain(cmd, cfg)
+      if err != nil {
+        return err
+      }
+      defer bc.Close()
+      if err := handOver(bc, cfg, node, tx); err != nil {
+        return err
+      }
+      fmt.Printf("Sent transaction %x\n", tx.ID)
+      return nil
+    },
+  }
+  cmd.Flags().StringVar(&psbt, "psbt", "", "partially signed transaction in base64")
+  cmd.Flags().StringVar(&in, "in", "", "file holding the partially signed transaction, instead of --psbt")
+  cmd.Flags().StringVar(&node, "node", "", "address of the node receiving the transaction, the first node by default")
+  return cmd
+}
+
+// Create the function that prints a transaction still missing multisig signatures, telling if it is complete
+func printIfIncomplete(tx *Transaction, utxoSet UTXOSet) (bool, error) {
+  prevOuts, err := utxoSet.SpentOutputs(tx)
+  if err != nil {
+    return false, err
+  }
+  err = tx.Verify(prevOuts)
+  if errors.Is(err, script.ErrNotEnoughSignatures) {
+    fmt.Printf("Partially signed transaction, the other signers add theirs with wallet sign: %x\n", tx.Serialize())
+    return false, nil
+  }
+  return err == nil, err
+}
+
+// Create the function that opens the wallet file of the data directory
+func openWallets(cmd *cobra.Command, passphrase string) (*wallet.Wallets, error) {
+  cfg, err := loadConfig(cmd) // find the data directory
+  if err != nil {
+    return nil, err
+  }
+  return wallet.LoadWallets(cfg.DataDir, []byte(passphrase))
+}
+
+// Create the function that tells if an address is used: once a main chain transaction involves it
+// The address index answers, so a chain not keeping it cannot tell
+func usedOnChain(bc *Blockchain) (func(address string) bool, error) {
+  if !bc.HasAddrIndex() {
+    return nil, errNoAddrIndex
+  }
+  return func(address string) bool {
+    _, total, err := bc.AddressTransactions(address, 0, 1)
+    return err == nil && total > 0
+  }, nil
+}
+
+// Create the function that prints the addresses found by a scan with their balances
+func printDerived(bc *Blockchain, found []wallet.DerivedAddress) {
+  utxoSet := UTXOSet{bc}
+  for _, derived := range found {
+    fmt.Printf("%d %s balance %d\n", derived.Index, derived.Address, utxoSet.Balance(derived.Address))
+  }
+  fmt.Printf("Found %d used addresses\n", len(found))
+}
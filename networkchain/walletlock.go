@@ -0,0 +1,73 @@
+package main
+
+import (
+  "errors" // for the errors of a node without a wallet
+  "time"   // for the timeout of the unlock
+)
+
+// Define the longest an encrypted wallet stays unlocked, in seconds
+const maxUnlockTimeout = 100000000
+
+// Define the errors of the locks of the wallet
+var (
+  errNoWallet        = errors.New("the node has no wallet")
+  errValidatorWallet = errors.New("the key of the validator signs the blocks, the wallet stays unlocked") // a locked key would stop the blocks
+)
+
+// Define a method to check if the wallet holds the key of the validator of the node, which must stay unlocked
+func (w *nodeWallet) holdsValidatorKey() bool {
+  key := w.n.validatorKey
+  return key != nil && w.keys.Wallets[key.Address()] == key
+}
+
+// Define a method to encrypt the keys of the wallet with a passphrase, they are locked once encrypted
+func (w *nodeWallet) encrypt(passphrase string) error {
+  if w.holdsValidatorKey() {
+    return errValidatorWallet
+  }
+  w.mu.Lock() // no payment signs while the keys are encrypted
+  defer w.mu.Unlock() // unlock the wallet when done
+  if err := w.keys.Encrypt([]byte(passphrase)); err != nil {
+    return err
+  }
+  netLog.Info("Encrypted the keys of the wallet, unlock them with walletpassphrase to sign", "wallet", w.name)
+  return nil
+}
+
+// Define a method to unlock the keys of the encrypted wallet for a number of seconds, after which they are locked again;
+// unlocking them again sets a new timeout
+func (w *nodeWallet) unlock(passphrase string, timeout int) error {
+  if timeout <= 0 || timeout > maxUnlockTimeout {
+    return errors.New("the timeout must be a positive number of seconds")
+  }
+  w.mu.Lock() // lock the wallet
+  defer w.mu.Unlock() // unlock it when done
+  if err := w.keys.Unlock([]byte(passphrase)); err != nil {
+    return err
+  }
+  if w.relock != nil {
+    w.relock.Stop()
+  }
+  w.relock = time.AfterFunc(time.Duration(timeout)*time.Second, func() {
+    if err := w.lock(); err == nil {
+      netLog.Info("Locked the wallet, the unlock timed out", "wallet", w.name)
+    }
+  })
+  netLog.Info("Unlocked the wallet", "wallet", w.name, "timeout", time.Duration(timeout)*time.Second)
+  return nil
+}
+
+// Define a method to wipe the keys of the encrypted wallet from memory, the payments are refused until it is unlocked
+// again
+func (w *nodeWallet) lock() error {
+  if w.holdsValidatorKey() {
+    return errValidatorWallet
+  }
+  w.mu.Lock() // no payment is signing while the keys are wiped
+  defer w.mu.Unlock() // unlock the wallet when done
+  if w.relock != nil {
+    w.relock.Stop()
+    w.relock = nil
+  }
+  return w.keys.Lock()
+}
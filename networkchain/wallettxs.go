@@ -0,0 +1,340 @@
+package main
+
+import (
+  "bytes"         // to compare the hashes of the blocks
+  "encoding/hex"  // the file keeps the transactions and the blocks in hex
+  "encoding/json" // the format of the wallet transactions file
+  "errors"        // to tell a missing file apart
+  "fmt"           // to key the outputs spent
+  "io/fs"         // for the error of a missing file
+  "os"            // to read and write the file
+  "path/filepath" // to find the file in the data directory
+  "sort"          // to list the transactions in the order they were accepted
+  "sync"          // for the lock of the transactions
+  "time"          // for when the transactions were accepted
+)
+
+// Define the name of the file of the data directory holding the wallet transactions
+const walletTxsFile = "wallettxs.json"
+
+// Define the statuses of a wallet transaction
+const (
+  walletTxPending    = "pending"    // in the mempool or waiting to be announced again, confirmed by no block of the main chain
+  walletTxConfirmed  = "confirmed"  // in a block of the main chain
+  walletTxConflicted = "conflicted" // a block of the main chain holds another transaction spending one of its inputs
+)
+
+// Define an error for a transaction the wallet does not follow
+var errNotWalletTx = errors.New("not a transaction of the wallet")
+
+// Define a struct for a transaction of the wallet with the block of the main chain confirming it
+type walletTx struct {
+  tx             *Transaction // the transaction
+  added          time.Time    // when the node accepted it or found it in a block, to announce the parents first
+  block          *Block       // the block of the main chain holding it, nil while unconfirmed
+  height         int          // the height of the block
+  conflict       *Block       // the block of the main chain holding a double spend of its inputs or of its parents, nil if none
+  conflictHeight int          // the height of that block
+}
+
+// Define a struct for the transactions of the wallet
+// The transactions that spend or pay the keys of the wallet are followed from when the mempool accepts them, or from the
+// block holding them if the node never saw them unconfirmed; each time the tip moves the new blocks are searched for
+// them, so their confirmations follow the chain, a reorganization puts the ones of the disconnected blocks back to
+// pending, and a confirmed double spend marks them and the transactions spending them conflicted. While they are pending
+// they are announced again to the peers every rebroadcastInterval until mined or abandoned; they survive restarts in
+// the file
+type walletTxs struct {
+  mu      sync.Mutex           // the lock protecting the transactions
+  path    string               // the file holding them, nothing is saved if empty
+  txs     map[string]*walletTx // the transactions, by hex ID
+  scanned []byte               // the hash of the last block searched for the transactions
+}
+
+// Define a struct for the JSON form of the file
+type walletTxsFileData struct {
+  Scanned      string             `json:"scanned"` // the hex hash of the last block searched
+  Transactions []walletTxFileData `json:"transactions"`
+}
+
+// Define a struct for the JSON form of a transaction of the file
+type walletTxFileData struct {
+  Raw      string    `json:"raw"`                // the serialized transaction in hex
+  Added    time.Time `json:"added"`              // when the node accepted it
+  Block    string    `json:"block,omitempty"`    // the hex hash of the block holding it, empty while unconfirmed
+  Conflict string    `json:"conflict,omitempty"` // the hex hash of the block holding a double spend, empty if none
+}
+
+// Define a function to load the wallet transactions of a data directory, none if the file does not exist; the blocks
+// searched already are looked up in the chain
+func loadWalletTxs(dataDir string, bc *Blockchain) (*walletTxs, error) {
+  w := &walletTxs{path: filepath.Join(dataDir, walletTxsFile), txs: map[string]*walletTx{}, scanned: bc.Tip().MyBlockHash}
+  data, err := os.ReadFile(w.path)
+  if errors.Is(err, fs.ErrNotExist) {
+    return w, nil
+  } else if err != nil {
+    return nil, err
+  }
+  var file walletTxsFileData
+  if err := json.Unmarshal(data, &file); err != nil {
+    return nil, err
+  }
+  if scanned, err := hex.DecodeString(file.Scanned); err == nil && len(scanned) > 0 {
+    w.scanned = scanned
+  }
+  for _, entry := range file.Transactions {
+    raw, err := hex.DecodeString(entry.Raw)
+    if err != nil {
+      return nil, err
+    }
+    tx, err := decodeTransaction(raw)
+    if err != nil {
+      return nil, err
+    }
+    wtx := &walletTx{tx: tx, added: entry.Added}
+    if hash, err := hex.DecodeString(entry.Block); err == nil && len(hash) > 0 {
+      wtx.block, wtx.height, _ = bc.GetBlock(hash) // an unknown block leaves it unconfirmed, the next scan finds it
+    }
+    if hash, err := hex.DecodeString(entry.Conflict); err == nil && len(hash) > 0 {
+      wtx.conflict, wtx.conflictHeight, _ = bc.GetBlock(hash)
+    }
+    w.txs[hex.EncodeToString(tx.ID)] = wtx
+  }
+  return w, nil
+}
+
+// Define a method to write the wallet transactions to the file, the lock must be held
+func (w *walletTxs) save() error {
+  if w.path == "" {
+    return nil
+  }
+  file := walletTxsFileData{Scanned: hex.EncodeToString(w.scanned), Transactions: []walletTxFileData{}}
+  for _, wtx := range w.sorted() {
+    entry := walletTxFileData{Raw: hex.EncodeToString(wtx.tx.Serialize()), Added: wtx.added}
+    if wtx.block != nil {
+      entry.Block = hex.EncodeToString(wtx.block.MyBlockHash)
+    }
+    if wtx.conflict != nil {
+      entry.Conflict = hex.EncodeToString(wtx.conflict.MyBlockHash)
+    }
+    file.Transactions = append(file.Transactions, entry)
+  }
+  data, err := json.MarshalIndent(file, "", "  ")
+  if err != nil {
+    return err
+  }
+  temp := w.path + ".tmp" // a crash while writing leaves the previous file
+  if err := os.WriteFile(temp, data, 0644); err != nil {
+    return err
+  }
+  return os.Rename(temp, w.path)
+}
+
+// Define a method to list the wallet transactions in the order the node accepted them, the ones found in blocks of the
+// same second by height, the lock must be held
+func (w *walletTxs) sorted() []*walletTx {
+  var sorted []*walletTx
+  for _, wtx := range w.txs {
+    sorted = append(sorted, wtx)
+  }
+  sort.Slice(sorted, func(i, j int) bool {
+    if !sorted[i].added.Equal(sorted[j].added) {
+      return sorted[i].added.Before(sorted[j].added)
+    }
+    return sorted[i].height < sorted[j].height
+  })
+  return sorted
+}
+
+// Define a method to get the status of a wallet transaction
+func (wtx *walletTx) status() string {
+  switch {
+  case wtx.block != nil:
+    return walletTxConfirmed
+  case wtx.conflict != nil:
+    return walletTxConflicted
+  }
+  return walletTxPending
+}
+
+// Define a method to count the confirmations of a wallet transaction with a tip at a height: the blocks from the one
+// holding it, 0 while pending, and minus the blocks from the one holding the double spend when conflicted
+func (wtx *walletTx) confirmations(tipHeight int) int {
+  switch {
+  case wtx.block != nil:
+    return tipHeight - wtx.height + 1
+  case wtx.conflict != nil:
+    return -(tipHeight - wtx.conflictHeight + 1)
+  }
+  return 0
+}
+
+// Define a method to check if an address is a key, a multisig, an aggregate or a watch-only address of the wallet of the
+// node
+func (w *nodeWallet) isWalletAddress(address string) bool {
+  _, key := w.keys.Wallets[address]
+  _, multisig := w.keys.Multisigs[address]
+  _, aggregate := w.keys.Aggregates[address]
+  return key || multisig || aggregate || w.keys.IsWatchOnly(address)
+}
+
+// Define a method to find the output an input spends: among the unspent outputs, the wallet transactions, or the main
+// chain if the node indexes the transactions; the lock of the wallet transactions must be held
+func (w *nodeWallet) walletPrevOut(in TXInput) (TXOutput, bool) {
+  if entry, ok := w.n.bc.findUnspentOutput(in.Txid, in.Vout); ok {
+    return entry.output(), true
+  }
+  if parent, ok := w.history.txs[hex.EncodeToString(in.Txid)]; ok && in.Vout < len(parent.tx.Vout) {
+    return parent.tx.Vout[in.Vout], true
+  }
+  if prev, err := w.n.bc.FindTransaction(in.Txid); err == nil && in.Vout < len(prev.Vout) {
+    return prev.Vout[in.Vout], true
+  }
+  return TXOutput{}, false
+}
+
+// Define a method to sum what a transaction pays to the wallet and what it spends of it, and to tell if a watch-only
+// address takes part, the lock of the wallet transactions must be held; a spent output that cannot be found counts for
+// nothing
+func (w *nodeWallet) walletAmounts(tx *Transaction) (int, int, bool) {
+  received, sent, watchOnly := 0, 0, false
+  for _, out := range tx.Vout {
+    if address := out.Address(); w.isWalletAddress(address) {
+      received += out.Value
+      watchOnly = watchOnly || w.keys.IsWatchOnly(address)
+    }
+  }
+  if tx.IsCoinbase() {
+    return received, sent, watchOnly
+  }
+  for _, in := range tx.Vin {
+    if out, ok := w.walletPrevOut(in); ok && w.isWalletAddress(out.Address()) {
+      sent += out.Value
+      watchOnly = watchOnly || w.keys.IsWatchOnly(out.Address())
+    }
+  }
+  return received, sent, watchOnly
+}
+
+// Define a method to check if a transaction spends or pays the addresses of the wallet of the node, the lock of the
+// wallet transactions must be held
+func (w *nodeWallet) isWalletTx(tx *Transaction) bool {
+  received, sent, _ := w.walletAmounts(tx)
+  return received > 0 || sent > 0
+}
+
+// Define a method to follow a wallet transaction the node accepted until it is abandoned, doing nothing if it is not one
+func (w *nodeWallet) trackWalletTx(tx *Transaction) {
+  h := w.history
+  h.mu.Lock() // lock the transactions
+  defer h.mu.Unlock() // unlock them when done
+  id := hex.EncodeToString(tx.ID)
+  if _, ok := h.txs[id]; ok || !w.isWalletTx(tx) {
+    return
+  }
+  h.txs[id] = &walletTx{tx: tx, added: time.Now()}
+  if err := h.save(); err != nil {
+    netLog.Warn("Failed to save the wallet transactions", "err", err)
+  }
+}
+
+// Define a method to search the blocks connected since the last scan for the wallet transactions, from the last block
+// searched if it is still on the main chain or from where its branch left it: the transactions of the disconnected
+// blocks go back to pending, the ones of the connected blocks are confirmed, new ones are followed, and the pending ones
+// a block double spends become conflicted
+func (w *nodeWallet) scanWalletTxs() {
+  chain := w.n.bc.MainChain() // the blocks do not change, the copy can be searched without the lock of the chain
+  h := w.history
+  h.mu.Lock() // lock the transactions
+  defer h.mu.Unlock() // unlock them when done
+  start := 0 // the height to search from, the whole chain if the last block searched is unknown
+  for hash := h.scanned; hash != nil; {
+    block, height, ok := w.n.bc.GetBlock(hash)
+    if !ok {
+      break
+    }
+    if height < len(chain) && chain[height] == block {
+      start = height + 1
+      break
+    }
+    hash = block.PreviousBlockHash // a reorganization left it, search from the fork
+  }
+  onChain := func(block *Block, height int) bool { return height < len(chain) && chain[height] == block }
+  changed := false
+  for id, wtx := range h.txs { // the blocks a reorganization disconnected no longer confirm nor conflict anything
+    if wtx.block != nil && !onChain(wtx.block, wtx.height) {
+      changed = true
+      if wtx.tx.IsCoinbase() { // a coinbase is only valid in its own block
+        delete(h.txs, id)
+        netLog.Info("Forgot a wallet coinbase, its block left the main chain", "txid", id, "block", wtx.block.MyBlockHash)
+        continue
+      }
+      netLog.Info("Wallet transaction is pending again, its block left the main chain", "txid", id, "block", wtx.block.MyBlockHash)
+      wtx.block = nil
+    }
+    if wtx.conflict != nil && !onChain(wtx.conflict, wtx.conflictHeight) {
+      wtx.conflict = nil
+      changed = true
+    }
+  }
+  type spend struct { // a transaction of a block spending an output
+    id     string
+    block  *Block
+    height int
+  }
+  spends := map[string]spend{} // the outputs the connected blocks spend, by transaction ID and index
+  for height := start; height < len(chain); height++ {
+    block := chain[height]
+    for _, tx := range block.Transactions { // a pruned block has none, it was searched before being pruned
+      id := hex.EncodeToString(tx.ID)
+      wtx, ok := h.txs[id]
+      if !ok && w.isWalletTx(tx) { // a payment the mempool never saw
+        wtx = &walletTx{tx: tx, added: time.Unix(block.Timestamp, 0)}
+        h.txs[id] = wtx
+        ok = true
+      }
+      if ok {
+        wtx.block, wtx.height, wtx.conflict = block, height, nil
+        changed = true
+      }
+      if tx.IsCoinbase() {
+        continue
+      }
+      for _, in := range tx.Vin {
+        spends[fmt.Sprintf("%x:%d", in.Txid, in.Vout)] = spend{id, block, height}
+      }
+    }
+  }
+  for _, wtx := range h.sorted() { // the parents first, so their conflicts reach their children
+    if wtx.block != nil || wtx.conflict != nil {
+      continue
+    }
+    for _, in := range wtx.tx.Vin {
+      if s, ok := spends[fmt.Sprintf("%x:%d", in.Txid, in.Vout)]; ok && s.id != hex.EncodeToString(wtx.tx.ID) {
+        wtx.conflict, wtx.conflictHeight = s.block, s.height
+      } else if parent, ok := h.txs[hex.EncodeToString(in.Txid)]; ok && parent.conflict != nil {
+        wtx.conflict, wtx.conflictHeight = parent.conflict, parent.conflictHeight
+      } else {
+        continue
+      }
+      netLog.Warn("Wallet transaction is conflicted, a block holds a double spend", "txid", wtx.tx.ID, "block", wtx.conflict.MyBlockHash)
+      changed = true
+      break
+    }
+  }
+  tip := chain[len(chain)-1].MyBlockHash
+  if changed || !bytes.Equal(tip, h.scanned) {
+    h.scanned = tip
+    if err := h.save(); err != nil {
+      netLog.Warn("Failed to save the wallet transactions", "err", err)
+    }
+  }
+}
+
+// Define a method to search the whole main chain for the wallet transactions again, after addresses were imported
+func (w *nodeWallet) rescanWalletTxs() {
+  w.history.mu.Lock() // lock the transactions
+  w.history.scanned = nil // the next scan starts from the genesis block
+  w.history.mu.Unlock() // unlock them
+  w.scanWalletTxs()
+}
@@ -0,0 +1,161 @@
+// Package webhook posts the events of a node as JSON to the HTTP endpoints of its operator.
+// Every endpoint has its own delivery queue, so a slow or failing endpoint delays nobody else, and receives the events
+// in order: a failed delivery is tried again with an exponential backoff before the next one is sent, and given up
+// after MaxAttempts. Each request is signed with HMAC-SHA256 over the timestamp, a dot and the body, with the shared
+// secret, so an endpoint can check it comes from the node and is recent.
+package webhook
+
+import (
+  "bytes"               // the body of the requests
+  "crypto/hmac"         // to sign the payloads
+  "crypto/sha256"       // the hash of the signatures
+  "encoding/hex"        // the signatures are sent in hex
+  "encoding/json"       // the format of the payloads
+  "fmt"                 // to number the deliveries
+  "networkchain/logger" // the deliveries log to the RPC subsystem
+  "net/http"            // to post the payloads
+  "strconv"             // to write the timestamp header
+  "sync/atomic"         // for the number of the next delivery
+  "time"                // for the backoff and the timestamps
+)
+
+// Define the events posted
+const (
+  EventBlock   = "block"   // a block was connected to the main chain
+  EventReorg   = "reorg"   // the main chain switched to another branch
+  EventAddress = "address" // a transaction of the mempool or of a new block spends from or pays to a watched address
+)
+
+// Define the headers of the requests
+const (
+  HeaderEvent     = "X-Webhook-Event"     // the type of the event
+  HeaderDelivery  = "X-Webhook-Delivery"  // the ID of the delivery, the same across the attempts
+  HeaderTimestamp = "X-Webhook-Timestamp" // the Unix time of the attempt, covered by the signature
+  HeaderSignature = "X-Webhook-Signature" // sha256= and the hex HMAC-SHA256 of the timestamp, a dot and the body
+)
+
+// Define some limits of the deliveries
+const (
+  MaxQueue       = 1000             // the events waiting for an endpoint before the next ones are dropped
+  MaxAttempts    = 8                // the attempts of a delivery before it is given up
+  initialBackoff = time.Second      // the wait before the second attempt, doubled after each failure
+  maxBackoff     = 5 * time.Minute  // the longest wait between two attempts
+  requestTimeout = 10 * time.Second // how long an endpoint has to answer
+)
+
+// Create the logger of the deliveries
+var rpcLog = logger.New(logger.RPC)
+
+// Define a struct for the payload of an event
+type Payload struct {
+  ID    string      `json:"id"`    // the ID of the delivery
+  Event string      `json:"event"` // the type of the event
+  Time  int64       `json:"time"`  // the Unix time the event happened
+  Data  interface{} `json:"data"`  // what happened, depending on the type
+}
+
+// Define a struct for an event waiting to be delivered
+type delivery struct {
+  id    string // the ID of the delivery
+  event string // the type of the event
+  body  []byte // the JSON payload
+}
+
+// Define a struct for an endpoint and its queue
+type endpoint struct {
+  url   string         // the URL the events are posted to
+  queue chan *delivery // the events waiting to be posted
+}
+
+// Define a struct for the dispatcher of the events to the endpoints
+type Dispatcher struct {
+  next      uint64       // the number of the next delivery, first so it is aligned for the atomic operations
+  endpoints []*endpoint  // the endpoints, each with its queue
+  secret    []byte       // the key of the signatures
+  client    *http.Client // the client posting the payloads
+  prefix    string       // starts the IDs of the deliveries, so they differ across restarts
+}
+
+// Define a function to create a dispatcher posting to some URLs with the signatures of a secret, and to start the
+// delivery of each endpoint
+func New(urls []string, secret string) *Dispatcher {
+  d := &Dispatcher{secret: []byte(secret), client: &http.Client{Timeout: requestTimeout}, prefix: strconv.FormatInt(time.Now().Unix(), 36)}
+  for _, url := range urls {
+    e := &endpoint{url: url, queue: make(chan *delivery, MaxQueue)}
+    d.endpoints = append(d.endpoints, e)
+    go d.deliverAll(e)
+  }
+  return d
+}
+
+// Define a method to queue an event for every endpoint, the ones whose queue is full lose it
+func (d *Dispatcher) Send(event string, data interface{}) {
+  id := fmt.Sprintf("%s-%d", d.prefix, atomic.AddUint64(&d.next, 1))
+  body, err := json.Marshal(Payload{id, event, time.Now().Unix(), data})
+  if err != nil {
+    rpcLog.Error("Failed to encode a webhook event", "event", event, "err", err)
+    return
+  }
+  for _, e := range d.endpoints {
+    select {
+    case e.queue <- &delivery{id, event, body}:
+    default: // the endpoint is too far behind
+      rpcLog.Warn("Dropped a webhook event, the queue of the endpoint is full", "url", e.url, "event", event, "id", id)
+    }
+  }
+}
+
+// Define a method to post the events of an endpoint one by one, trying each one again with a growing backoff
+func (d *Dispatcher) deliverAll(e *endpoint) {
+  for del := range e.queue {
+    backoff := initialBackoff
+    for attempt := 1; ; attempt++ {
+      err := d.post(e.url, del)
+      if err == nil {
+        rpcLog.Debug("Delivered a webhook event", "url", e.url, "event", del.event, "id", del.id, "attempt", attempt)
+        break
+      }
+      if attempt == MaxAttempts {
+        rpcLog.Warn("Gave up a webhook event", "url", e.url, "event", del.event, "id", del.id, "attempts", attempt, "err", err)
+        break
+      }
+      rpcLog.Debug("Failed to deliver a webhook event", "url", e.url, "event", del.event, "id", del.id, "attempt", attempt, "retry", backoff, "err", err)
+      time.Sleep(backoff)
+      if backoff *= 2; backoff > maxBackoff {
+        backoff = maxBackoff
+      }
+    }
+  }
+}
+
+// Define a method to post an event once, any answer but a 2xx status is a failure
+func (d *Dispatcher) post(url string, del *delivery) error {
+  req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(del.body))
+  if err != nil {
+    return err
+  }
+  timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+  req.Header.Set("Content-Type", "application/json")
+  req.Header.Set(HeaderEvent, del.event)
+  req.Header.Set(HeaderDelivery, del.id)
+  req.Header.Set(HeaderTimestamp, timestamp)
+  req.Header.Set(HeaderSignature, "sha256="+Sign(d.secret, timestamp, del.body))
+  resp, err := d.client.Do(req)
+  if err != nil {
+    return err
+  }
+  resp.Body.Close()
+  if resp.StatusCode < 200 || resp.StatusCode > 299 {
+    return fmt.Errorf("the endpoint answered %s", resp.Status)
+  }
+  return nil
+}
+
+// Define a function to compute the hex signature of a body sent at a timestamp, for the endpoints to compare with the
+// signature header
+func Sign(secret []byte, timestamp string, body []byte) string {
+  mac := hmac.New(sha256.New, secret)
+  mac.Write([]byte(timestamp + "."))
+  mac.Write(body)
+  return hex.EncodeToString(mac.Sum(nil))
+}
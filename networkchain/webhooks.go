@@ -0,0 +1,115 @@
+package main
+
+import (
+  "encoding/hex"         // the hashes are posted in hex
+  "networkchain/events"  // the events of the chain
+  "networkchain/webhook" // the delivery of the events
+)
+
+// Define a struct for the data of a block event
+type blockHook struct {
+  Hash         string `json:"hash"`
+  Height       int    `json:"height"`
+  Time         int64  `json:"time"`
+  Transactions int    `json:"transactions"` // the number of transactions, the coinbase included
+}
+
+// Define a struct for the data of a reorg event
+type reorgHook struct {
+  Fork         string   `json:"fork"`         // the hash of the last block both branches share
+  ForkHeight   int      `json:"forkheight"`
+  Disconnected []string `json:"disconnected"` // the hashes of the blocks of the old branch, oldest first
+  Connected    []string `json:"connected"`    // the hashes of the blocks of the new branch, oldest first
+}
+
+// Define a struct for the data of an address event
+type addressHook struct {
+  Address  string `json:"address"`
+  TxID     string `json:"txid"`
+  Received int    `json:"received"`         // the coins the transaction pays to the address
+  Sent     int    `json:"sent"`             // the coins of the address the transaction spends, 0 if the outputs spent are unknown
+  Block    string `json:"block,omitempty"`  // the hash of the block holding the transaction, empty for a mempool transaction
+  Height   int    `json:"height,omitempty"` // the height of the block
+}
+
+// Define a method to post the blocks, the reorganizations and the transactions of the watched addresses to the webhooks
+// until the chain stops publishing events; the buffer is as large as the queues of the endpoints, a burst of blocks
+// during the sync may still overflow it
+func (n *Node) runWebhooks(hooks *webhook.Dispatcher, watch []string) {
+  watched := map[string]bool{} // the addresses, to look them up
+  for _, address := range watch {
+    watched[address] = true
+  }
+  sub := n.events.Subscribe(webhook.MaxQueue, events.TipChanged, events.TxAccepted, events.ReorgDetected) // subscribe to the chain events
+  defer sub.Cancel() // stop the subscription when done
+  for event := range sub.C { // iterate over the events
+    switch data := event.Data.(type) {
+    case *Transaction:
+      if event.Type == events.TxAccepted {
+        n.postAddressHooks(hooks, watched, data, nil, 0)
+      }
+    case *Block:
+      if event.Type != events.TipChanged {
+        continue
+      }
+      _, height, _ := n.bc.GetBlock(data.MyBlockHash)
+      hooks.Send(webhook.EventBlock, blockHook{hex.EncodeToString(data.MyBlockHash), height, data.Timestamp, len(data.Transactions)})
+      for _, tx := range data.Transactions {
+        n.postAddressHooks(hooks, watched, tx, data, height)
+      }
+    case *ReorgEvent:
+      _, forkHeight, _ := n.bc.GetBlock(data.Fork.MyBlockHash)
+      hook := reorgHook{Fork: hex.EncodeToString(data.Fork.MyBlockHash), ForkHeight: forkHeight}
+      for _, block := range data.Disconnected {
+        hook.Disconnected = append(hook.Disconnected, hex.EncodeToString(block.MyBlockHash))
+      }
+      for _, block := range data.Connected {
+        hook.Connected = append(hook.Connected, hex.EncodeToString(block.MyBlockHash))
+      }
+      hooks.Send(webhook.EventReorg, hook)
+    }
+  }
+}
+
+// Define a method to post an address event for every watched address a transaction pays or spends from, the block
+// holding it is nil for a mempool transaction
+// The outputs spent by a transaction of a block are gone from the UTXO set, they are looked up in the transaction index
+// and left out without it
+func (n *Node) postAddressHooks(hooks *webhook.Dispatcher, watched map[string]bool, tx *Transaction, block *Block, height int) {
+  if len(watched) == 0 {
+    return
+  }
+  activity := map[string]*addressHook{} // the events, by address
+  hook := func(address string) *addressHook {
+    if activity[address] == nil {
+      activity[address] = &addressHook{Address: address, TxID: hex.EncodeToString(tx.ID)}
+      if block != nil {
+        activity[address].Block, activity[address].Height = hex.EncodeToString(block.MyBlockHash), height
+      }
+    }
+    return activity[address]
+  }
+  for _, out := range tx.Vout {
+    if address := out.Address(); watched[address] {
+      hook(address).Received += out.Value
+    }
+  }
+  if !tx.IsCoinbase() {
+    for _, in := range tx.Vin {
+      var prevOut TXOutput
+      if entry, ok := n.bc.findUnspentOutput(in.Txid, in.Vout); ok {
+        prevOut = entry.output()
+      } else if prev, err := n.bc.FindTransaction(in.Txid); err == nil && in.Vout < len(prev.Vout) {
+        prevOut = prev.Vout[in.Vout]
+      } else {
+        continue
+      }
+      if address := prevOut.Address(); watched[address] {
+        hook(address).Sent += prevOut.Value
+      }
+    }
+  }
+  for _, data := range activity {
+    hooks.Send(webhook.EventAddress, data)
+  }
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Define some constants for the message framing
+const (
+  checksumLength = 4                                     // the length of the payload checksum
+  headerLength   = 4 + commandLength + 4 + checksumLength // magic, command, payload length and checksum
+  flagsOffset    = 4 + commandLength - 1                 // the last byte of the command field holds the compression flag, the commands are shorter
+)
+
+// Define the compression algorithms of the payloads, as written in the compression flag of the header
+const (
+  compressionNone byte = 0 // the payload is sent as is
+  compressionGzip byte = 1 // the payload is compressed with gzip
+)
+
+// The smallest payload worth compressing, like blocks and address lists; the small messages are sent as is
+const compressionThreshold = 1024
+
+// Define the names of the compression algorithms, announced in the version command, most preferred first
+var compressionAlgorithms = []struct {
+  name string // the name announced to the peers
+  flag byte   // the flag of the header
+}{
+  {"gzip", compressionGzip},
+}
+
+// Define an error returned for a compressed payload that cannot be decompressed
+var errCompression = errors.New("invalid compressed payload")
+
+// Define an error returned for a message whose payload does not match the checksum of its header
+var errChecksum = errors.New("payload checksum mismatch")
+
+// Define an error returned for a message announcing a payload larger than maxPayloadSize
+var errPayloadTooLarge = errors.New("payload too large")
+
+// Define a struct for the fixed header sent in front of every payload
+type messageHeader struct {
+  Magic    uint32               // the magic bytes identifying the network
+  Command  string               // the command name
+  Flags    byte                 // the compression algorithm of the payload
+  Length   uint32               // the length of the payload
+  Checksum [checksumLength]byte // the first bytes of the double SHA256 of the payload
+}
+
+// Define a function to compute the checksum of a payload
+func checksum(payload []byte) [checksumLength]byte {
+  first := sha256.Sum256(payload) // hash the payload
+  second := sha256.Sum256(first[:]) // and hash the hash
+  var sum [checksumLength]byte // create a buffer for the checksum
+  copy(sum[:], second[:checksumLength]) // keep the first bytes
+  return sum // return the checksum
+}
+
+// Define a function to frame a command and its payload into a message
+func encodeMessage(command string, payload []byte) []byte {
+  return frameMessage(command, compressionNone, payload) // compressed when sent, if the peer accepts it
+}
+
+// Define a function to frame a command and its payload, compressed with an algorithm, into a message
+func frameMessage(command string, flags byte, payload []byte) []byte {
+  var buffer bytes.Buffer // create a buffer for the message
+  buffer.Grow(headerLength + len(payload)) // the final size is known
+  binary.Write(&buffer, binary.BigEndian, activeNet.Net) // write the magic bytes of the network
+  commandBytes := commandToBytes(command) // the fixed length command
+  commandBytes[commandLength-1] = flags // with the compression flag in its last byte
+  buffer.Write(commandBytes) // write the command
+  binary.Write(&buffer, binary.BigEndian, uint32(len(payload))) // write the payload length
+  sum := checksum(payload) // compute the payload checksum
+  buffer.Write(sum[:]) // write the checksum
+  buffer.Write(payload) // write the payload itself
+  return buffer.Bytes() // return the message
+}
+
+// Define a function to decode a message header
+func decodeHeader(data []byte) messageHeader {
+  var header messageHeader // create a buffer for the header
+  header.Magic = binary.BigEndian.Uint32(data[:4]) // read the magic bytes
+  header.Command = bytesToCommand(data[4:flagsOffset]) // read the command
+  header.Flags = data[flagsOffset] // read the compression flag
+  header.Length = binary.BigEndian.Uint32(data[4+commandLength : 8+commandLength]) // read the payload length
+  copy(header.Checksum[:], data[8+commandLength:headerLength]) // read the checksum
+  return header // return the header
+}
+
+// Define a function to read a full message from a connection
+func readMessage(r io.Reader) (messageHeader, []byte, error) {
+  data := make([]byte, headerLength) // create a buffer for the header
+  if _, err := io.ReadFull(r, data); err != nil { // keep reading until the whole header is received
+    return messageHeader{}, nil, err
+  }
+  header := decodeHeader(data) // decode the header
+  if header.Magic != activeNet.Net { // the peer does not speak our protocol or runs on another network
+    return header, nil, fmt.Errorf("invalid magic %x", header.Magic)
+  }
+  if header.Length > maxPayloadSize { // check the size before allocating the buffer
+    return header, nil, fmt.Errorf("%w: %d bytes for %s", errPayloadTooLarge, header.Length, header.Command)
+  }
+  payload := make([]byte, header.Length) // create a buffer for the payload
+  if _, err := io.ReadFull(r, payload); err != nil { // keep reading until the whole payload is received
+    return header, nil, err
+  }
+  if checksum(payload) != header.Checksum { // the payload was corrupted on the way
+    return header, nil, fmt.Errorf("%w for %s", errChecksum, header.Command)
+  }
+  if header.Flags != compressionNone { // the checksum covers the payload as sent
+    var err error
+    if payload, err = decompressPayload(header.Flags, payload); err != nil {
+      return header, nil, fmt.Errorf("%s: %w", header.Command, err)
+    }
+  }
+  return header, payload, nil // return the header and the payload
+}
+
+// Define a function to compress the payload of a framed message, unless it is small or does not shrink
+func compressMessage(message []byte, flags byte) []byte {
+  if flags == compressionNone || len(message)-headerLength < compressionThreshold || message[flagsOffset] != compressionNone {
+    return message
+  }
+  var buffer bytes.Buffer // create a buffer for the compressed payload
+  writer := gzip.NewWriter(&buffer) // the only algorithm so far
+  writer.Write(message[headerLength:]) // writing to a buffer never fails
+  writer.Close()
+  if buffer.Len() >= len(message)-headerLength { // already compressed data, like a bloom filter
+    return message
+  }
+  return frameMessage(decodeHeader(message).Command, flags, buffer.Bytes()) // frame the compressed payload
+}
+
+// Define a function to decompress a payload, refusing the ones growing past maxPayloadSize
+func decompressPayload(flags byte, payload []byte) ([]byte, error) {
+  if flags != compressionGzip {
+    return nil, fmt.Errorf("%w: unknown algorithm %d", errCompression, flags)
+  }
+  reader, err := gzip.NewReader(bytes.NewReader(payload))
+  if err != nil {
+    return nil, fmt.Errorf("%w: %v", errCompression, err)
+  }
+  data, err := io.ReadAll(io.LimitReader(reader, maxPayloadSize+1)) // a small payload may inflate to gigabytes
+  if err != nil {
+    return nil, fmt.Errorf("%w: %v", errCompression, err)
+  }
+  if len(data) > maxPayloadSize {
+    return nil, fmt.Errorf("%w: more than %d bytes once decompressed", errPayloadTooLarge, maxPayloadSize)
+  }
+  return data, nil
+}
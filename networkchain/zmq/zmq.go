@@ -0,0 +1,342 @@
+// Package zmq publishes the blocks and transactions of a node to ZeroMQ subscribers, so indexers and payment processors
+// follow the node without polling it.
+// The server speaks enough of ZMTP 3.0 to act as a PUB socket with the NULL mechanism: a SUB socket of any ZeroMQ
+// library connects to it, subscribes to topic prefixes and receives the messages of the matching topics as three
+// frames, the topic, the body and a 4 byte little endian sequence number counted per topic, like bitcoind does with
+// the hashblock, hashtx, rawblock and rawtx topics. Like ZeroMQ, a subscriber that does not keep up loses messages.
+package zmq
+
+import (
+  "bufio"               // to read the frames
+  "bytes"               // to match the topics and check the greetings
+  "encoding/binary"     // for the sizes and the sequence numbers
+  "errors"              // for the errors of the handshake
+  "fmt"                 // to describe the errors of the handshake
+  "io"                  // to read the frames fully
+  "networkchain/logger" // the publisher logs to the RPC subsystem
+  "net"                 // to listen for subscribers
+  "sync"                // the subscribers are shared by the connections and the publisher
+  "time"                // for the deadlines
+)
+
+// Define the topics published
+const (
+  TopicHashBlock = "hashblock" // the hash of a block connected to the main chain
+  TopicHashTx    = "hashtx"    // the ID of a transaction accepted in the mempool or connected in a block
+  TopicRawBlock  = "rawblock"  // the serialized block
+  TopicRawTx     = "rawtx"     // the serialized transaction
+)
+
+// Define some limits of the connections
+const (
+  HighWater        = 1000             // the messages queued for a subscriber before the next ones are dropped
+  handshakeTimeout = 10 * time.Second // how long a subscriber has to complete the handshake
+  writeTimeout     = 10 * time.Second // how long a subscriber has to take a message
+  maxFrameSize     = 4 << 10          // the largest frame accepted from a subscriber, a subscription or a command
+)
+
+// Define the flags of a frame
+const (
+  flagMore    = 0x01 // another frame of the message follows
+  flagLong    = 0x02 // the size takes 8 bytes instead of 1
+  flagCommand = 0x04 // the frame is a command, not a message
+)
+
+// Create the logger of the publisher
+var rpcLog = logger.New(logger.RPC)
+
+// Define the error of a subscriber not speaking ZMTP 3 with the NULL mechanism
+var errHandshake = errors.New("zmq: handshake failed")
+
+// Define a struct for a message published to the subscribers of its topic
+type Notification struct {
+  Topic string // one of the topics
+  Body  []byte // the hash or the serialized block or transaction
+}
+
+// Define an interface for the node behind the server
+type Backend interface {
+  Notifications() (<-chan Notification, func()) // the messages as the chain and the mempool change, and the way to stop them
+}
+
+// Define a struct for the server
+type Server struct {
+  backend  Backend              // the node publishing the messages
+  mu       sync.Mutex           // the lock protecting the fields below
+  subs     map[*subscriber]bool // the connected subscribers
+  sequence map[string]uint32    // the number of the next message of each topic
+}
+
+// Define a struct for the connection of a subscriber
+type subscriber struct {
+  net.Conn
+  mu      sync.Mutex    // the lock protecting the topics and the count of the dropped messages
+  topics  [][]byte      // the topic prefixes subscribed to
+  queue   chan [][]byte // the messages waiting to be written, as frames
+  dropped int           // the messages lost because the queue was full
+}
+
+// Define a function to create a server for a backend
+func NewServer(backend Backend) *Server {
+  return &Server{backend: backend, subs: map[*subscriber]bool{}, sequence: map[string]uint32{}}
+}
+
+// Define a method to serve the subscribers on an address until it fails
+func (s *Server) ListenAndServe(address string) error {
+  ln, err := net.Listen("tcp", address)
+  if err != nil {
+    return err
+  }
+  defer ln.Close()
+  notifications, cancel := s.backend.Notifications()
+  defer cancel()
+  go s.publishAll(notifications)
+  for {
+    c, err := ln.Accept()
+    if err != nil {
+      return err
+    }
+    go s.serve(&subscriber{Conn: c, queue: make(chan [][]byte, HighWater)})
+  }
+}
+
+// Define a method to hand the messages of the backend to the subscribers of their topic until the backend stops
+func (s *Server) publishAll(notifications <-chan Notification) {
+  for n := range notifications {
+    s.Publish(n.Topic, n.Body)
+  }
+}
+
+// Define a method to queue a message for every subscriber of its topic, numbered within the topic whether anyone
+// subscribed or not, so a subscriber sees the gaps
+func (s *Server) Publish(topic string, body []byte) {
+  s.mu.Lock() // lock the subscribers
+  defer s.mu.Unlock() // unlock them when done
+  sequence := make([]byte, 4)
+  binary.LittleEndian.PutUint32(sequence, s.sequence[topic])
+  s.sequence[topic]++
+  message := [][]byte{[]byte(topic), body, sequence}
+  for sub := range s.subs {
+    if !sub.subscribed(message[0]) {
+      continue
+    }
+    select {
+    case sub.queue <- message:
+    default: // the subscriber is too slow, drop the message
+      sub.mu.Lock()
+      sub.dropped++
+      sub.mu.Unlock()
+    }
+  }
+}
+
+// Define a method to complete the handshake of a subscriber, then read its subscriptions while its messages are written
+// until it disconnects
+func (s *Server) serve(sub *subscriber) {
+  defer sub.Close()
+  reader := bufio.NewReader(sub)
+  sub.SetDeadline(time.Now().Add(handshakeTimeout))
+  if err := handshake(sub, reader); err != nil {
+    rpcLog.Debug("ZMQ subscriber failed the handshake", "addr", sub.RemoteAddr(), "err", err)
+    return
+  }
+  sub.SetDeadline(time.Time{}) // a subscriber may stay silent forever
+  s.mu.Lock() // lock the subscribers
+  s.subs[sub] = true
+  s.mu.Unlock() // unlock them
+  rpcLog.Debug("ZMQ subscriber connected", "addr", sub.RemoteAddr())
+  done := make(chan struct{}) // closed when the subscriber disconnects
+  go sub.writeLoop(done)
+  err := sub.readLoop(reader)
+  s.mu.Lock() // lock the subscribers
+  delete(s.subs, sub)
+  s.mu.Unlock() // unlock them
+  close(done)
+  sub.mu.Lock()
+  dropped := sub.dropped
+  sub.mu.Unlock()
+  rpcLog.Debug("ZMQ subscriber disconnected", "addr", sub.RemoteAddr(), "dropped", dropped, "err", err)
+}
+
+// Define a function to exchange the greetings and the READY commands with a subscriber
+func handshake(conn net.Conn, reader *bufio.Reader) error {
+  greeting := make([]byte, 64) // the signature, the version 3.0, the NULL mechanism and the client role
+  greeting[0], greeting[8], greeting[9], greeting[10] = 0xff, 0x01, 0x7f, 3
+  copy(greeting[12:32], "NULL")
+  if _, err := conn.Write(greeting); err != nil {
+    return err
+  }
+  theirs := make([]byte, 64)
+  if _, err := io.ReadFull(reader, theirs); err != nil {
+    return err
+  }
+  if theirs[0] != 0xff || theirs[9] != 0x7f || theirs[10] < 3 {
+    return fmt.Errorf("%w: not a ZMTP 3 peer", errHandshake)
+  }
+  if mechanism := bytes.TrimRight(theirs[12:32], "\x00"); string(mechanism) != "NULL" {
+    return fmt.Errorf("%w: mechanism %q is not supported", errHandshake, mechanism)
+  }
+  ready := append([]byte{5}, "READY"...) // the name of the command, then the socket type property
+  ready = append(append(ready, 11), "Socket-Type"...)
+  ready = binary.BigEndian.AppendUint32(ready, 3)
+  ready = append(ready, "PUB"...)
+  if err := writeFrame(conn, flagCommand, ready); err != nil {
+    return err
+  }
+  flags, body, err := readFrame(reader)
+  if err != nil {
+    return err
+  }
+  if name, props := commandName(body); flags&flagCommand == 0 || name != "READY" {
+    return fmt.Errorf("%w: expected READY", errHandshake)
+  } else if socketType := property(props, "Socket-Type"); socketType != "SUB" && socketType != "XSUB" {
+    return fmt.Errorf("%w: a %s socket cannot subscribe", errHandshake, socketType)
+  }
+  return nil
+}
+
+// Define a method to read the subscriptions of a subscriber until it disconnects: a message starting with 1 subscribes
+// to the prefix that follows and one starting with 0 cancels it, as in ZMTP 3.0, or the SUBSCRIBE and CANCEL commands
+// of ZMTP 3.1; the other commands are ignored
+func (sub *subscriber) readLoop(reader *bufio.Reader) error {
+  for {
+    flags, body, err := readFrame(reader)
+    if err != nil {
+      return err
+    }
+    var subscribe bool
+    var topic []byte
+    if flags&flagCommand != 0 {
+      name, rest := commandName(body)
+      switch name {
+      case "SUBSCRIBE":
+        subscribe, topic = true, rest
+      case "CANCEL":
+        topic = rest
+      default:
+        continue
+      }
+    } else if len(body) > 0 && body[0] <= 1 {
+      subscribe, topic = body[0] == 1, body[1:]
+    } else {
+      continue
+    }
+    sub.mu.Lock()
+    if subscribe {
+      sub.topics = append(sub.topics, append([]byte{}, topic...))
+    } else {
+      for i, t := range sub.topics { // one subscription of the prefix is cancelled
+        if bytes.Equal(t, topic) {
+          sub.topics = append(sub.topics[:i], sub.topics[i+1:]...)
+          break
+        }
+      }
+    }
+    sub.mu.Unlock()
+  }
+}
+
+// Define a method to write the queued messages of a subscriber until it disconnects
+func (sub *subscriber) writeLoop(done <-chan struct{}) {
+  for {
+    select {
+    case <-done:
+      return
+    case message := <-sub.queue:
+      sub.SetWriteDeadline(time.Now().Add(writeTimeout)) // a subscriber that stops reading is dropped
+      for i, frame := range message {
+        flags := byte(0)
+        if i < len(message)-1 {
+          flags = flagMore
+        }
+        if err := writeFrame(sub, flags, frame); err != nil {
+          sub.Close() // the read loop returns and the subscriber is removed
+          return
+        }
+      }
+    }
+  }
+}
+
+// Define a method to check whether a subscriber subscribed to a prefix of a topic
+func (sub *subscriber) subscribed(topic []byte) bool {
+  sub.mu.Lock()
+  defer sub.mu.Unlock()
+  for _, prefix := range sub.topics {
+    if bytes.HasPrefix(topic, prefix) {
+      return true
+    }
+  }
+  return false
+}
+
+// Define a function to write a frame, with a long size if the body needs it
+func writeFrame(w io.Writer, flags byte, body []byte) error {
+  var header []byte
+  if len(body) > 255 {
+    header = binary.BigEndian.AppendUint64([]byte{flags | flagLong}, uint64(len(body)))
+  } else {
+    header = []byte{flags, byte(len(body))}
+  }
+  _, err := w.Write(append(header, body...))
+  return err
+}
+
+// Define a function to read a frame of a subscriber, refusing the ones larger than maxFrameSize
+func readFrame(r *bufio.Reader) (byte, []byte, error) {
+  flags, err := r.ReadByte()
+  if err != nil {
+    return 0, nil, err
+  }
+  var size uint64
+  if flags&flagLong != 0 {
+    var long [8]byte
+    if _, err := io.ReadFull(r, long[:]); err != nil {
+      return 0, nil, err
+    }
+    size = binary.BigEndian.Uint64(long[:])
+  } else {
+    short, err := r.ReadByte()
+    if err != nil {
+      return 0, nil, err
+    }
+    size = uint64(short)
+  }
+  if size > maxFrameSize {
+    return 0, nil, fmt.Errorf("zmq: frame of %d bytes is too large", size)
+  }
+  body := make([]byte, size)
+  if _, err := io.ReadFull(r, body); err != nil {
+    return 0, nil, err
+  }
+  return flags, body, nil
+}
+
+// Define a function to split a command into its name and its data
+func commandName(body []byte) (string, []byte) {
+  if len(body) == 0 || int(body[0]) > len(body)-1 {
+    return "", nil
+  }
+  return string(body[1 : 1+body[0]]), body[1+body[0]:]
+}
+
+// Define a function to find a property of a READY command, empty if it is missing
+func property(props []byte, name string) string {
+  for len(props) > 0 {
+    nameSize := int(props[0])
+    if len(props) < 1+nameSize+4 {
+      return ""
+    }
+    key := string(props[1 : 1+nameSize])
+    valueSize := int(binary.BigEndian.Uint32(props[1+nameSize:]))
+    props = props[1+nameSize+4:]
+    if len(props) < valueSize {
+      return ""
+    }
+    if key == name {
+      return string(props[:valueSize])
+    }
+    props = props[valueSize:]
+  }
+  return ""
+}
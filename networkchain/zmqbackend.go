@@ -0,0 +1,50 @@
+package main
+
+import (
+	"networkchain/events"
+	"networkchain/zmq"
+)
+
+// Define a struct for the view of a node given to the ZMQ publisher
+type zmqBackend struct {
+  n *Node // the node whose blocks and transactions are published
+}
+
+// Define a method to publish the blocks and transactions to ZMQ subscribers on an address until it fails
+func (n *Node) ServeZMQ(address string) error {
+  rpcLog.Info("Publishing the blocks and transactions to ZMQ subscribers", "addr", address)
+  return zmq.NewServer(zmqBackend{n}).ListenAndServe(address) // serve the subscribers
+}
+
+// Define a method to turn the events of the chain into the messages of the topics: a transaction entering the mempool
+// is published with its ID and its bytes, a block connected to the main chain with its transactions first, then its
+// hash and its bytes; the blocks of a new branch are connected one by one, so a reorganization needs no message of its own
+func (b zmqBackend) Notifications() (<-chan zmq.Notification, func()) {
+  sub := b.n.events.Subscribe(events.DefaultBuffer, events.TipChanged, events.TxAccepted) // subscribe to the new tips and transactions
+  notifications := make(chan zmq.Notification, zmq.HighWater) // create a channel for the messages
+  go func() {
+    defer close(notifications) // the subscription was cancelled
+    publishTx := func(tx *Transaction) {
+      notifications <- zmq.Notification{Topic: zmq.TopicHashTx, Body: tx.ID}
+      notifications <- zmq.Notification{Topic: zmq.TopicRawTx, Body: tx.Serialize()}
+    }
+    for event := range sub.C { // iterate over the events
+      switch data := event.Data.(type) {
+      case *Transaction:
+        if event.Type == events.TxAccepted {
+          publishTx(data)
+        }
+      case *Block:
+        if event.Type != events.TipChanged {
+          continue
+        }
+        for _, tx := range data.Transactions {
+          publishTx(tx)
+        }
+        notifications <- zmq.Notification{Topic: zmq.TopicHashBlock, Body: data.MyBlockHash}
+        notifications <- zmq.Notification{Topic: zmq.TopicRawBlock, Body: data.Serialize()}
+      }
+    }
+  }()
+  return notifications, sub.Cancel // return the messages and the way to stop them
+}